@@ -0,0 +1,403 @@
+package app
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/docker"
+
+	"github.com/fatih/color"
+)
+
+// BuildManifestName is the name of the build manifest written into the work
+// directory by default
+const BuildManifestName = "build-manifest.json"
+
+// BuildManifestRedacted replaces the values of secret settings in build
+// manifests
+const BuildManifestRedacted = "<redacted>"
+
+// secretSettingWords mark the flags and environment variables holding
+// credentials; their values are not recorded in build manifests
+var secretSettingWords = []string{"token", "password", "secret", "credential", "header"}
+
+// BuildManifest records the inputs of a build, so it can be reproduced
+type BuildManifest struct {
+	FissileVersion string                  `json:"fissile_version"`
+	Created        time.Time               `json:"created"`
+	Args           []string                `json:"args"`        // Command line arguments, without the program name, secrets redacted
+	WorkingDir     string                  `json:"working_dir"` // Directory relative arguments are resolved in
+	Environment    map[string]string       `json:"environment"` // FISSILE_* environment variables, secrets redacted
+	Flags          map[string]string       `json:"flags"`       // Values of all flags of the command, secrets redacted
+	Files          map[string]string       `json:"files"`       // SHA1 of the input files, by path
+	Releases       []*BuildManifestRelease `json:"releases"`
+	BaseImages     map[string]string       `json:"base_images"` // Docker image IDs, by image name
+}
+
+// BuildManifestRelease records a release used by a build
+type BuildManifestRelease struct {
+	Name       string            `json:"name"`
+	Version    string            `json:"version"`
+	Path       string            `json:"path"`
+	CommitHash string            `json:"commit_hash"`
+	Jobs       map[string]string `json:"jobs"`     // Fingerprints, by job name
+	Packages   map[string]string `json:"packages"` // Fingerprints, by package name
+}
+
+// BuildManifestSettings describes the build to record
+type BuildManifestSettings struct {
+	Args        []string
+	Environment map[string]string // FISSILE_* environment variables of the flags
+	Flags       map[string]string
+	Files       []string // Input files; those that don't exist are skipped
+	BaseImages  []string // Images the build starts from; those that don't exist are skipped
+}
+
+// NewBuildManifest records the inputs of a build: the command line, the
+// input files, the loaded releases, and the base images. The values of
+// secret flags and environment variables are redacted.
+func (f *Fissile) NewBuildManifest(settings *BuildManifestSettings) (*BuildManifest, error) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BuildManifest{
+		FissileVersion: f.Version,
+		Created:        time.Now().UTC(),
+		Args:           redactArgs(settings.Args),
+		WorkingDir:     workingDir,
+		Environment:    redactSettings(settings.Environment),
+		Flags:          redactSettings(settings.Flags),
+		Files:          make(map[string]string),
+		Releases:       []*BuildManifestRelease{},
+		BaseImages:     make(map[string]string),
+	}
+
+	for _, path := range settings.Files {
+		if path == "" {
+			continue
+		}
+		sum, err := fileSHA1(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files[path] = sum
+	}
+
+	for _, release := range f.releases {
+		manifestRelease := &BuildManifestRelease{
+			Name:       release.Name,
+			Version:    release.Version,
+			Path:       release.Path,
+			CommitHash: release.CommitHash,
+			Jobs:       make(map[string]string),
+			Packages:   make(map[string]string),
+		}
+		for _, job := range release.Jobs {
+			manifestRelease.Jobs[job.Name] = job.Fingerprint
+		}
+		for _, pkg := range release.Packages {
+			manifestRelease.Packages[pkg.Name] = pkg.Fingerprint
+		}
+		manifest.Releases = append(manifest.Releases, manifestRelease)
+	}
+
+	if len(settings.BaseImages) > 0 {
+		images, err := imageIDs(settings.BaseImages)
+		if err != nil {
+			f.logger(logBuild).Warnf("base images are not recorded: %s", err)
+		}
+		manifest.BaseImages = images
+	}
+
+	return manifest, nil
+}
+
+// WriteBuildManifest records the inputs of a build in a JSON file
+func (f *Fissile) WriteBuildManifest(path string, settings *BuildManifestSettings) error {
+	manifest, err := f.NewBuildManifest(settings)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, append(buf, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing build manifest: %s", err)
+	}
+
+	f.logger(logBuild).Infof("Wrote build manifest to %s", color.CyanString(path))
+	return nil
+}
+
+// LoadBuildManifest reads a build manifest
+func LoadBuildManifest(path string) (*BuildManifest, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading build manifest: %s", err)
+	}
+
+	var manifest BuildManifest
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, fmt.Errorf("Error parsing build manifest %s: %s", path, err)
+	}
+
+	return &manifest, nil
+}
+
+// VerifyBuildManifest checks that the input files, the loaded releases and
+// the base images are still those recorded in the build manifest. All
+// differences are reported together. A different fissile version is only
+// warned about.
+func (f *Fissile) VerifyBuildManifest(manifest *BuildManifest) error {
+	if manifest.FissileVersion != f.Version {
+		f.logger(logBuild).Warnf("the build used fissile %s, this is %s", manifest.FissileVersion, f.Version)
+	}
+
+	var changes []string
+
+	for path, sum := range manifest.Files {
+		current, err := fileSHA1(path)
+		if err != nil {
+			changes = append(changes, fmt.Sprintf("file %s: %s", path, err))
+		} else if current != sum {
+			changes = append(changes, fmt.Sprintf("file %s has changed", path))
+		}
+	}
+
+	for _, recorded := range manifest.Releases {
+		changes = append(changes, f.releaseChanges(recorded)...)
+	}
+
+	if len(manifest.BaseImages) > 0 {
+		var names []string
+		for name := range manifest.BaseImages {
+			names = append(names, name)
+		}
+		images, err := imageIDs(names)
+		if err != nil {
+			return err
+		}
+		for name, id := range manifest.BaseImages {
+			if current, ok := images[name]; !ok {
+				changes = append(changes, fmt.Sprintf("base image %s is missing", name))
+			} else if current != id {
+				changes = append(changes, fmt.Sprintf("base image %s is now %s, not %s", name, current, id))
+			}
+		}
+	}
+
+	if len(changes) > 0 {
+		sort.Strings(changes)
+		return fmt.Errorf("The inputs of the build have changed:\n  %s", strings.Join(changes, "\n  "))
+	}
+
+	return nil
+}
+
+// releaseChanges compares a recorded release with the loaded one
+func (f *Fissile) releaseChanges(recorded *BuildManifestRelease) []string {
+	var changes []string
+
+	for _, release := range f.releases {
+		if release.Name != recorded.Name {
+			continue
+		}
+
+		if release.Version != recorded.Version {
+			changes = append(changes, fmt.Sprintf("release %s is version %s, not %s", release.Name, release.Version, recorded.Version))
+		}
+		for _, job := range release.Jobs {
+			if fingerprint, ok := recorded.Jobs[job.Name]; ok && fingerprint != job.Fingerprint {
+				changes = append(changes, fmt.Sprintf("job %s/%s has changed", release.Name, job.Name))
+			}
+		}
+		for _, pkg := range release.Packages {
+			if fingerprint, ok := recorded.Packages[pkg.Name]; ok && fingerprint != pkg.Fingerprint {
+				changes = append(changes, fmt.Sprintf("package %s/%s has changed", release.Name, pkg.Name))
+			}
+		}
+		return changes
+	}
+
+	return []string{fmt.Sprintf("release %s is not loaded", recorded.Name)}
+}
+
+// isSecretSetting tests whether a flag or environment variable holds
+// credentials
+func isSecretSetting(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretSettingWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSettings returns the settings with the values of secret ones redacted
+func redactSettings(settings map[string]string) map[string]string {
+	redacted := make(map[string]string, len(settings))
+	for name, value := range settings {
+		if value != "" && isSecretSetting(name) {
+			value = BuildManifestRedacted
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// secretFlagArg tests whether a command line argument sets a secret flag,
+// and whether its value is in the same argument (--flag=value)
+func secretFlagArg(arg string) (secret bool, inline bool) {
+	if !strings.HasPrefix(arg, "--") {
+		return false, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+	return isSecretSetting(parts[0]), len(parts) == 2
+}
+
+// redactArgs returns the command line with the values of secret flags redacted
+func redactArgs(args []string) []string {
+	redacted := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		secret, inline := secretFlagArg(args[i])
+		switch {
+		case !secret:
+			redacted = append(redacted, args[i])
+		case inline:
+			name := strings.SplitN(args[i], "=", 2)[0]
+			redacted = append(redacted, name+"="+BuildManifestRedacted)
+		default:
+			redacted = append(redacted, args[i])
+			if i+1 < len(args) {
+				redacted = append(redacted, BuildManifestRedacted)
+				i++
+			}
+		}
+	}
+	return redacted
+}
+
+// rebuildArgs drops the redacted secret flags of a recorded command line;
+// their values have to come from the environment of the rebuild instead
+func rebuildArgs(args []string) (rebuild []string, dropped []string) {
+	for i := 0; i < len(args); i++ {
+		secret, inline := secretFlagArg(args[i])
+		if secret && inline && strings.HasSuffix(args[i], "="+BuildManifestRedacted) {
+			dropped = append(dropped, strings.SplitN(args[i], "=", 2)[0])
+			continue
+		}
+		if secret && !inline && i+1 < len(args) && args[i+1] == BuildManifestRedacted {
+			dropped = append(dropped, args[i])
+			i++
+			continue
+		}
+		rebuild = append(rebuild, args[i])
+	}
+	return rebuild, dropped
+}
+
+// Rebuild runs the command recorded in a build manifest again, in the
+// recorded directory, with the recorded FISSILE_* environment variables in
+// place of the current ones. Redacted secrets are taken from the current
+// FISSILE_* environment variables.
+func (f *Fissile) Rebuild(manifest *BuildManifest, executable string) error {
+	args, dropped := rebuildArgs(manifest.Args)
+	for _, flag := range dropped {
+		name := strings.TrimPrefix(flag, "--")
+		f.logger(logBuild).Warnf("%s was not recorded, set FISSILE_%s to pass it",
+			flag, strings.ToUpper(strings.Replace(name, "-", "_", -1)))
+	}
+
+	f.logger(logBuild).Infof("Rebuilding with: %s", color.CyanString(strings.Join(args, " ")))
+
+	command := exec.Command(executable, args...)
+	command.Dir = manifest.WorkingDir
+	command.Env = rebuildEnvironment(os.Environ(), manifest.Environment)
+	command.Stdin = f.UI
+	command.Stdout = f.UI
+	command.Stderr = f.errorOutput
+
+	return command.Run()
+}
+
+// rebuildEnvironment replaces the FISSILE_* variables of an environment
+// with the recorded ones, except for the redacted ones
+func rebuildEnvironment(current []string, recorded map[string]string) []string {
+	var environment []string
+	for _, variable := range current {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if !strings.HasPrefix(name, "FISSILE_") || recorded[name] == BuildManifestRedacted {
+			environment = append(environment, variable)
+		}
+	}
+
+	var names []string
+	for name, value := range recorded {
+		if value != BuildManifestRedacted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		environment = append(environment, name+"="+recorded[name])
+	}
+
+	return environment
+}
+
+// imageIDs looks up the IDs of the docker images that exist, by name
+func imageIDs(imageNames []string) (map[string]string, error) {
+	ids := make(map[string]string)
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return ids, fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	for _, name := range imageNames {
+		image, err := dockerManager.FindImage(name)
+		if err == docker.ErrImageNotFound {
+			continue
+		}
+		if err != nil {
+			return ids, err
+		}
+		ids[name] = image.ID
+	}
+
+	return ids, nil
+}
+
+// fileSHA1 returns the hex encoded SHA1 of a file's contents
+func fileSHA1(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/logging"
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	tempDir, err := ioutil.TempDir("", "fissile-build-manifest")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	roleManifestPath := filepath.Join(tempDir, "role-manifest.yml")
+	if !assert.NoError(ioutil.WriteFile(roleManifestPath, []byte("roles: []\n"), 0644)) {
+		return
+	}
+
+	f := NewFissileApplication("1.0", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	manifestPath := filepath.Join(tempDir, BuildManifestName)
+	err = f.WriteBuildManifest(manifestPath, &BuildManifestSettings{
+		Args:        []string{"build", "images", "--force", "--vault-token", "s3cr3t", "--package-cache-header=Authorization: Bearer s3cr3t"},
+		Environment: map[string]string{"FISSILE_WORK_DIR": "/work", "FISSILE_VAULT_TOKEN": "s3cr3t"},
+		Flags:       map[string]string{"force": "true", "vault-token": "s3cr3t", "vault-password": ""},
+		Files:       []string{roleManifestPath, filepath.Join(tempDir, "missing.yml")},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	manifest, err := LoadBuildManifest(manifestPath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal("1.0", manifest.FissileVersion)
+	assert.Equal([]string{"build", "images", "--force", "--vault-token", BuildManifestRedacted, "--package-cache-header=" + BuildManifestRedacted}, manifest.Args)
+	assert.Equal(map[string]string{"FISSILE_WORK_DIR": "/work", "FISSILE_VAULT_TOKEN": BuildManifestRedacted}, manifest.Environment)
+	assert.Equal(map[string]string{"force": "true", "vault-token": BuildManifestRedacted, "vault-password": ""}, manifest.Flags)
+	buf, err := ioutil.ReadFile(manifestPath)
+	if assert.NoError(err) {
+		assert.NotContains(string(buf), "s3cr3t", "Secrets should not be recorded")
+	}
+	assert.Equal(workDir, manifest.WorkingDir)
+	assert.Equal(map[string]string{
+		roleManifestPath: "0e408a79ae6e23db3b860a374742e932392381d4",
+	}, manifest.Files, "Missing files should be skipped")
+	if assert.Len(manifest.Releases, 1) {
+		assert.Equal("tor", manifest.Releases[0].Name)
+		assert.Equal(torReleasePath, manifest.Releases[0].Path)
+		assert.Len(manifest.Releases[0].Jobs, 3)
+		assert.Len(manifest.Releases[0].Packages, 2)
+	}
+
+	assert.NoError(f.VerifyBuildManifest(manifest))
+
+	manifest.Releases[0].Packages["tor"] = "0000"
+	assert.NoError(ioutil.WriteFile(roleManifestPath, []byte("roles: [changed]\n"), 0644))
+	assert.EqualError(f.VerifyBuildManifest(manifest), "The inputs of the build have changed:\n"+
+		"  file "+roleManifestPath+" has changed\n"+
+		"  package tor/tor has changed")
+
+	manifest.Releases[0].Name = "ntp"
+	manifest.Files = nil
+	assert.EqualError(f.VerifyBuildManifest(manifest), "The inputs of the build have changed:\n"+
+		"  release ntp is not loaded")
+}
+
+func TestRebuildEnvironment(t *testing.T) {
+	assert := assert.New(t)
+
+	environment := rebuildEnvironment(
+		[]string{"HOME=/root", "FISSILE_REPOSITORY=current", "FISSILE_WORKERS=4", "FISSILE_VAULT_TOKEN=current"},
+		map[string]string{
+			"FISSILE_REPOSITORY":     "recorded",
+			"FISSILE_LIGHT_OPINIONS": "/opinions.yml",
+			"FISSILE_VAULT_TOKEN":    BuildManifestRedacted,
+		},
+	)
+	assert.Equal([]string{
+		"HOME=/root",
+		"FISSILE_VAULT_TOKEN=current",
+		"FISSILE_LIGHT_OPINIONS=/opinions.yml",
+		"FISSILE_REPOSITORY=recorded",
+	}, environment)
+}
+
+func TestRebuildArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	args, dropped := rebuildArgs([]string{
+		"build", "images", "--vault-token", BuildManifestRedacted, "--force",
+		"--package-cache-header=" + BuildManifestRedacted, "--vault-path", "secret/fissile",
+	})
+	assert.Equal([]string{"build", "images", "--force", "--vault-path", "secret/fissile"}, args)
+	assert.Equal([]string{"--vault-token", "--package-cache-header"}, dropped)
+}
+
+func TestRebuildOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	tempDir, err := ioutil.TempDir("", "fissile-rebuild")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The output of the rebuild goes to the UI, and its errors to the error
+	// output, rather than to those of the process
+	output := &bytes.Buffer{}
+	errorOutput := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+	f.SetLogger(logging.New(ioutil.Discard, logging.Info, logging.FormatText))
+	f.SetErrorOutput(errorOutput)
+
+	manifest := &BuildManifest{
+		Args:       []string{"-c", "pwd; echo failed >&2"},
+		WorkingDir: tempDir,
+	}
+	if assert.NoError(f.Rebuild(manifest, "/bin/sh")) {
+		resolved, _ := filepath.EvalSymlinks(tempDir)
+		assert.Equal(resolved+"\n", output.String())
+		assert.Equal("failed\n", errorOutput.String())
+	}
+}
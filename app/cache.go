@@ -0,0 +1,241 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// cacheLockName is the lock file of the cache directory: fissile processes
+// using the cache hold a shared lock on it, pruning the cache an exclusive one
+const cacheLockName = ".fissile-cache.lock"
+
+// The kinds of entries of the cache
+const (
+	cacheDownloads = "downloads" // Downloaded final release tarballs
+	cacheReleases  = "releases"  // Extracted final release tarballs
+	cachePackages  = "packages"  // Compiled packages
+)
+
+// cacheKinds are the kinds of entries of the cache, in the order they are
+// listed
+var cacheKinds = []string{cacheDownloads, cacheReleases, cachePackages}
+
+// cacheEntry is a downloaded release, an extracted release or a compiled
+// package kept in the cache
+type cacheEntry struct {
+	Kind     string `json:"kind" yaml:"kind"`
+	Name     string `json:"name" yaml:"name"`
+	Path     string `json:"path" yaml:"path"`
+	Size     int64  `json:"size" yaml:"size"`           // In bytes
+	LastUsed string `json:"last_used" yaml:"last_used"` // RFC 3339
+
+	lastUsed time.Time
+}
+
+// cacheReport describes the entries of the cache and their size
+type cacheReport struct {
+	Entries []*cacheEntry    `json:"entries" yaml:"entries"`
+	Sizes   map[string]int64 `json:"sizes" yaml:"sizes"` // By kind, in bytes
+	Size    int64            `json:"size" yaml:"size"`   // In bytes
+}
+
+// cacheLockMode is the way the cache is locked
+type cacheLockMode int
+
+// The ways the cache is locked
+const (
+	cacheLockShared    cacheLockMode = iota // Fissile processes using the cache
+	cacheLockExclusive                      // Pruning the cache
+	cacheUnlock
+)
+
+// LockCache takes a shared lock on the cache directory, held until fissile
+// exits, so that the cache isn't pruned while it is used. It waits for the
+// cache being pruned. Nothing is locked while the cache directory doesn't
+// exist.
+func (f *Fissile) LockCache(cacheDir string) error {
+	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
+		return nil
+	}
+	err := f.lockCache(cacheDir, cacheLockShared, false)
+	if err != errCacheInUse {
+		return err
+	}
+	f.logger(logCache).Infof("Waiting for the cache %s to be pruned", color.YellowString(cacheDir))
+	return f.lockCache(cacheDir, cacheLockShared, true)
+}
+
+// errCacheInUse is returned when the cache is locked by another process
+var errCacheInUse = fmt.Errorf("The cache is in use by another fissile process")
+
+// lockCache locks the cache directory in the given way, waiting for other
+// processes if asked to; a lock held already is converted. See lockFile for
+// each platform.
+func (f *Fissile) lockCache(cacheDir string, how cacheLockMode, wait bool) error {
+	if f.cacheLock == nil {
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return fmt.Errorf("Error creating cache directory %s: %s", cacheDir, err)
+		}
+		file, err := os.OpenFile(filepath.Join(cacheDir, cacheLockName), os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return fmt.Errorf("Error locking the cache %s: %s", cacheDir, err)
+		}
+		f.cacheLock = file
+	}
+
+	if err := lockFile(f.cacheLock, how, wait); err == errCacheInUse {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("Error locking the cache %s: %s", cacheDir, err)
+	}
+	return nil
+}
+
+// cacheDirs returns the directories of the cache, by kind of entry
+func (f *Fissile) cacheDirs(cacheDir, compilationDir string) map[string]string {
+	downloadDir := f.releaseDownloadDir
+	if downloadDir == "" {
+		downloadDir = filepath.Join(cacheDir, releaseDownloadsDir)
+	}
+	return map[string]string{
+		cacheDownloads: downloadDir,
+		cacheReleases:  filepath.Join(cacheDir, model.FinalReleasesCacheDir),
+		cachePackages:  compilationDir,
+	}
+}
+
+// listCache returns the entries of the cache, by kind, then least recently
+// used first. Entries being written, named with a leading dot, are left out.
+// Entries are marked as used when fissile reuses them.
+func (f *Fissile) listCache(cacheDir, compilationDir string) ([]*cacheEntry, error) {
+	dirs := f.cacheDirs(cacheDir, compilationDir)
+
+	var entries []*cacheEntry
+	for _, kind := range cacheKinds {
+		infos, err := ioutil.ReadDir(dirs[kind])
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Error reading the cache: %s", err)
+		}
+
+		var kindEntries []*cacheEntry
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(dirs[kind], info.Name())
+			size, err := diskUsage(path)
+			if err != nil {
+				return nil, fmt.Errorf("Error measuring %s: %s", path, err)
+			}
+			kindEntries = append(kindEntries, &cacheEntry{
+				Kind:     kind,
+				Name:     info.Name(),
+				Path:     path,
+				Size:     size,
+				LastUsed: info.ModTime().UTC().Format(time.RFC3339),
+				lastUsed: info.ModTime(),
+			})
+		}
+		sort.SliceStable(kindEntries, func(i, j int) bool {
+			return kindEntries[i].lastUsed.Before(kindEntries[j].lastUsed)
+		})
+		entries = append(entries, kindEntries...)
+	}
+	return entries, nil
+}
+
+// newCacheReport sums up the sizes of entries of the cache
+func newCacheReport(entries []*cacheEntry) *cacheReport {
+	report := &cacheReport{
+		Entries: entries,
+		Sizes:   make(map[string]int64, len(cacheKinds)),
+	}
+	if report.Entries == nil {
+		report.Entries = []*cacheEntry{}
+	}
+	for _, kind := range cacheKinds {
+		report.Sizes[kind] = 0
+	}
+	for _, entry := range entries {
+		report.Sizes[entry.Kind] += entry.Size
+		report.Size += entry.Size
+	}
+	return report
+}
+
+// formatCacheSize formats a size in bytes as MB, as the cache is measured
+func formatCacheSize(size int64) string {
+	return fmt.Sprintf("%.2fMB", float64(size)/(1024*1024))
+}
+
+// printCacheReport prints the entries of the cache, and their total size by
+// kind, in the given format
+func (f *Fissile) printCacheReport(report *cacheReport, outputFormat string) error {
+	return f.printReport(report, outputFormat, func() {
+		for _, entry := range report.Entries {
+			f.UI.Printf("%s %s %s, last used %s\n", color.CyanString(entry.Kind),
+				color.YellowString(entry.Name), formatCacheSize(entry.Size), entry.LastUsed)
+		}
+		for _, kind := range cacheKinds {
+			f.UI.Printf("%s: %s\n", color.CyanString(kind), formatCacheSize(report.Sizes[kind]))
+		}
+		f.UI.Printf("Total: %s\n", color.MagentaString(formatCacheSize(report.Size)))
+	})
+}
+
+// ListCache lists the downloaded and extracted final releases kept in the
+// cache directory, and the packages compiled in the compilation directory,
+// with their size and when they were last used
+func (f *Fissile) ListCache(cacheDir, compilationDir, outputFormat string) error {
+	entries, err := f.listCache(cacheDir, compilationDir)
+	if err != nil {
+		return err
+	}
+	return f.printCacheReport(newCacheReport(entries), outputFormat)
+}
+
+// PruneCache removes the entries of the cache not used for longer than the
+// given duration, or all of them if it is zero, and lists those removed. It
+// fails when other fissile processes use the cache. With dryRun, the entries
+// are listed without being removed.
+func (f *Fissile) PruneCache(cacheDir, compilationDir string, olderThan time.Duration, dryRun bool, outputFormat string) error {
+	if err := f.lockCache(cacheDir, cacheLockExclusive, false); err == errCacheInUse {
+		return fmt.Errorf("The cache %s is in use by another fissile process, try again later", cacheDir)
+	} else if err != nil {
+		return err
+	}
+
+	entries, err := f.listCache(cacheDir, compilationDir)
+	if err != nil {
+		return err
+	}
+
+	var pruned []*cacheEntry
+	for _, entry := range entries {
+		if olderThan > 0 && time.Since(entry.lastUsed) < olderThan {
+			continue
+		}
+		if dryRun {
+			f.logger(logCache).Infof("- Would remove %s %s (%s)", entry.Kind, color.YellowString(entry.Name), formatCacheSize(entry.Size))
+		} else {
+			f.logger(logCache).Infof("- Removing %s %s (%s)", entry.Kind, color.YellowString(entry.Name), formatCacheSize(entry.Size))
+			if err := os.RemoveAll(entry.Path); err != nil {
+				return fmt.Errorf("Error removing %s from the cache: %s", entry.Path, err)
+			}
+		}
+		pruned = append(pruned, entry)
+	}
+
+	return f.printCacheReport(newCacheReport(pruned), outputFormat)
+}
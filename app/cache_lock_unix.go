@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks the lock file of the cache with flock(2), which converts a
+// lock held already atomically
+func lockFile(file *os.File, how cacheLockMode, wait bool) error {
+	var operation int
+	switch how {
+	case cacheLockShared:
+		operation = syscall.LOCK_SH
+	case cacheLockExclusive:
+		operation = syscall.LOCK_EX
+	default:
+		operation = syscall.LOCK_UN
+	}
+	if !wait {
+		operation |= syscall.LOCK_NB
+	}
+
+	err := syscall.Flock(int(file.Fd()), operation)
+	if err == syscall.EWOULDBLOCK {
+		return errCacheInUse
+	}
+	return err
+}
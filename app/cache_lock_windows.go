@@ -0,0 +1,54 @@
+package app
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorNotLocked     = syscall.Errno(158)
+	errorLockViolation = syscall.Errno(33)
+)
+
+// lockFile locks the first byte of the lock file of the cache with
+// LockFileEx. Windows doesn't convert locks, so a lock held already is
+// released first; another process may take the cache in between.
+func lockFile(file *os.File, how cacheLockMode, wait bool) error {
+	handle := uintptr(file.Fd())
+
+	overlapped := &syscall.Overlapped{}
+	ok, _, err := procUnlockFileEx.Call(handle, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if ok == 0 && err != errorNotLocked {
+		return err
+	}
+	if how == cacheUnlock {
+		return nil
+	}
+
+	var flags uintptr
+	if how == cacheLockExclusive {
+		flags |= lockfileExclusiveLock
+	}
+	if !wait {
+		flags |= lockfileFailImmediately
+	}
+	overlapped = &syscall.Overlapped{}
+	ok, _, err = procLockFileEx.Call(handle, flags, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if ok == 0 {
+		if err == errorLockViolation {
+			return errCacheInUse
+		}
+		return err
+	}
+	return nil
+}
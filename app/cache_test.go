@@ -0,0 +1,104 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/fissile/logging"
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/util"
+	"github.com/hpcloud/termui"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := util.TempDir("", "fissile-cache-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	cacheDir := filepath.Join(dir, "cache")
+	compilationDir := filepath.Join(dir, "compilation")
+	old := time.Now().Add(-48 * time.Hour)
+	for _, entry := range []struct {
+		path string
+		old  bool
+	}{
+		{filepath.Join(cacheDir, releaseDownloadsDir, "aaa.tgz"), true},
+		{filepath.Join(cacheDir, releaseDownloadsDir, ".download-123"), true},
+		{filepath.Join(cacheDir, model.FinalReleasesCacheDir, "bbb", "release.MF"), false},
+		{filepath.Join(compilationDir, "ccc", "compiled", "file"), true},
+		{filepath.Join(compilationDir, "ddd", "compiled", "file"), false},
+	} {
+		assert.NoError(os.MkdirAll(filepath.Dir(entry.path), 0755))
+		assert.NoError(ioutil.WriteFile(entry.path, []byte("1234"), 0644))
+		if entry.old {
+			path := entry.path
+			if filepath.Base(filepath.Dir(path)) == "compiled" {
+				path = filepath.Dir(filepath.Dir(path))
+			}
+			assert.NoError(os.Chtimes(path, old, old))
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	f.SetLogger(logging.New(ioutil.Discard, logging.Info, logging.FormatText))
+	if !assert.NoError(f.LockCache(cacheDir)) {
+		return
+	}
+
+	var report cacheReport
+	if assert.NoError(f.ListCache(cacheDir, compilationDir, "json")) &&
+		assert.NoError(json.Unmarshal(buffer.Bytes(), &report)) {
+		var names []string
+		for _, entry := range report.Entries {
+			names = append(names, entry.Kind+"/"+entry.Name)
+		}
+		assert.Equal([]string{"downloads/aaa.tgz", "releases/bbb", "packages/ccc", "packages/ddd"}, names)
+		assert.Equal(int64(4), report.Sizes[cacheDownloads])
+		assert.Equal(int64(16), report.Size)
+	}
+
+	// Other fissile processes using the cache prevent pruning it
+	other := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = other.PruneCache(cacheDir, compilationDir, 0, false, "json")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "is in use by another fissile process")
+	}
+
+	buffer.Reset()
+	if assert.NoError(f.PruneCache(cacheDir, compilationDir, 24*time.Hour, true, "json")) &&
+		assert.NoError(json.Unmarshal(buffer.Bytes(), &report)) {
+		assert.Len(report.Entries, 2)
+		assert.Equal(int64(8), report.Size)
+	}
+	assert.True(pathExists(filepath.Join(compilationDir, "ccc")), "Nothing is removed in a dry run")
+
+	buffer.Reset()
+	assert.NoError(f.PruneCache(cacheDir, compilationDir, 24*time.Hour, false, "json"))
+	assert.False(pathExists(filepath.Join(cacheDir, releaseDownloadsDir, "aaa.tgz")))
+	assert.False(pathExists(filepath.Join(compilationDir, "ccc")))
+	assert.True(pathExists(filepath.Join(cacheDir, releaseDownloadsDir, ".download-123")), "Entries being written are kept")
+	assert.True(pathExists(filepath.Join(cacheDir, model.FinalReleasesCacheDir, "bbb")))
+	assert.True(pathExists(filepath.Join(compilationDir, "ddd")))
+
+	// Once it is pruned, other processes can use the cache again
+	assert.NoError(f.lockCache(cacheDir, cacheUnlock, false))
+	assert.NoError(other.LockCache(cacheDir))
+}
+
+// pathExists tests whether a file or directory exists
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
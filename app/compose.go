@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hpcloud/fissile/compose"
+
+	"github.com/fatih/color"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+// GenerateCompose writes a docker-compose file running all roles of the role
+// manifest, including the dev-only ones, for local development
+func (f *Fissile) GenerateCompose(rolesManifestPath, outputPath, repository, registry, organization string, defaultFiles []string) error {
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	registry, err = f.imageRegistry(rolesManifest, registry, organization)
+	if err != nil {
+		return err
+	}
+
+	defaults := map[string]string{}
+	if len(defaultFiles) > 0 {
+		f.logger(logConfig).Info("Loading defaults from env files")
+		defaults, err = godotenv.Read(defaultFiles...)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := compose.NewFile(rolesManifest.Roles, &compose.Settings{
+		Defaults:     defaults,
+		Repository:   repository,
+		Registry:     registry,
+		Organization: organization,
+	})
+	if err != nil {
+		return err
+	}
+
+	buf, err := yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	f.logger(logConfig).Infof("Writing docker-compose file %s", color.CyanString(outputPath))
+
+	// The environment includes secrets
+	return ioutil.WriteFile(outputPath, buf, 0600)
+}
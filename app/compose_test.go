@@ -0,0 +1,61 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/compose"
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGenerateCompose(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/compose.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	defaultsPath := filepath.Join(outputDir, "defaults.env")
+	err = ioutil.WriteFile(defaultsPath, []byte("PRIVATE_KEY=not-so-private\n"), 0600)
+	assert.NoError(err)
+
+	outputPath := filepath.Join(outputDir, "docker-compose.yml")
+	err = f.GenerateCompose(roleManifestPath, outputPath, "fissile", "", "", []string{defaultsPath})
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(outputPath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var file compose.File
+	if assert.NoError(yaml.Unmarshal(contents, &file)) && assert.Contains(file.Services, "myrole") {
+		assert.Equal("not-so-private", file.Services["myrole"].Environment["PRIVATE_KEY"])
+		assert.Contains(file.Services, "myrole-log-shipper")
+	}
+
+	f.SetRegistryEnvironment("production")
+	err = f.GenerateCompose(roleManifestPath, outputPath, "fissile", "docker.example.com", "", nil)
+	assert.EqualError(err, "A registry environment can't be combined with a docker registry or organization")
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// ShowDependencyGraph prints a graph of the roles of the role manifest, the
+// jobs they run, the packages of those jobs, and the packages these depend
+// on. The only supported format is "dot", for Graphviz.
+func (f *Fissile) ShowDependencyGraph(roleManifestPath, format string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := f.loadRoleManifest(roleManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	switch format {
+	case "dot":
+		f.UI.Printf("%s", dependencyGraphDot(roleManifest))
+	default:
+		return fmt.Errorf("Invalid graph format '%s', expected dot", format)
+	}
+
+	return nil
+}
+
+// dependencyGraphDot renders the dependency graph in the Graphviz DOT
+// language. Nodes and edges are sorted, so that the output is stable.
+func dependencyGraphDot(roleManifest *model.RoleManifest) string {
+	nodes := make(map[string]string)
+	edges := make(map[string]bool)
+
+	addPackage := func(pkg *model.Package) string {
+		id := dotQuote("package/" + pkg.Release.Name + "/" + pkg.Name)
+		nodes[id] = fmt.Sprintf("label=%s, shape=component", dotQuote(pkg.Name))
+		return id
+	}
+
+	// Package dependencies are followed transitively, as the packages of a
+	// job need not list everything they depend on
+	var pending model.Packages
+
+	for _, role := range roleManifest.Roles {
+		roleID := dotQuote("role/" + role.Name)
+		nodes[roleID] = fmt.Sprintf("label=%s, shape=box, style=filled", dotQuote(role.Name))
+
+		for _, job := range role.Jobs {
+			jobID := dotQuote("job/" + job.Release.Name + "/" + job.Name)
+			nodes[jobID] = fmt.Sprintf("label=%s, shape=ellipse", dotQuote(job.Name))
+			edges[roleID+" -> "+jobID] = true
+
+			for _, pkg := range job.Packages {
+				edges[jobID+" -> "+addPackage(pkg)] = true
+			}
+			pending = append(pending, job.Packages...)
+		}
+	}
+
+	seen := make(map[*model.Package]bool)
+	for len(pending) > 0 {
+		pkg := pending[0]
+		pending = pending[1:]
+		if seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+
+		pkgID := addPackage(pkg)
+		for _, dependency := range pkg.Dependencies {
+			edges[pkgID+" -> "+addPackage(dependency)] = true
+			pending = append(pending, dependency)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph dependencies {\n")
+	buf.WriteString("  rankdir=LR;\n")
+	for _, id := range sortedKeys(nodes) {
+		fmt.Fprintf(&buf, "  %s [%s];\n", id, nodes[id])
+	}
+	edgeList := make([]string, 0, len(edges))
+	for edge := range edges {
+		edgeList = append(edgeList, edge)
+	}
+	sort.Strings(edgeList)
+	for _, edge := range edgeList {
+		fmt.Fprintf(&buf, "  %s;\n", edge)
+	}
+	buf.WriteString("}\n")
+
+	return buf.String()
+}
+
+// dotQuote quotes an identifier for the DOT language
+func dotQuote(id string) string {
+	return `"` + strings.Replace(id, `"`, `\"`, -1) + `"`
+}
+
+// sortedKeys returns the keys of a map, sorted
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowDependencyGraph(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/dependency-graph.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.ShowDependencyGraph(roleManifestPath, "dot")
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(`digraph dependencies {
+  rankdir=LR;
+  "job/tor/hashmat" [label="hashmat", shape=ellipse];
+  "job/tor/new_hostname" [label="new_hostname", shape=ellipse];
+  "job/tor/tor" [label="tor", shape=ellipse];
+  "package/tor/libevent" [label="libevent", shape=component];
+  "package/tor/tor" [label="tor", shape=component];
+  "role/myrole" [label="myrole", shape=box, style=filled];
+  "role/otherrole" [label="otherrole", shape=box, style=filled];
+  "job/tor/hashmat" -> "package/tor/libevent";
+  "job/tor/hashmat" -> "package/tor/tor";
+  "job/tor/new_hostname" -> "package/tor/libevent";
+  "job/tor/new_hostname" -> "package/tor/tor";
+  "job/tor/tor" -> "package/tor/libevent";
+  "job/tor/tor" -> "package/tor/tor";
+  "package/tor/tor" -> "package/tor/libevent";
+  "role/myrole" -> "job/tor/new_hostname";
+  "role/myrole" -> "job/tor/tor";
+  "role/otherrole" -> "job/tor/hashmat";
+}
+`, buffer.String())
+
+	err = f.ShowDependencyGraph(roleManifestPath, "svg")
+	assert.EqualError(err, "Invalid graph format 'svg', expected dot")
+}
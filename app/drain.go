@@ -0,0 +1,60 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// DrainRole runs the drain scripts of a role in a running container of it,
+// the way they would run before the container stops in Kubernetes. The
+// container defaults to the one named after the role.
+func (f *Fissile) DrainRole(rolesManifestPath, roleName, containerName string) error {
+	rolesManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	role := rolesManifest.LookupRole(roleName)
+	if role == nil {
+		return fmt.Errorf("Unknown role %s", roleName)
+	}
+
+	drainCommand := role.GetDrainCommand()
+	if drainCommand == nil {
+		f.UI.Printf("Role %s has no drain scripts\n", color.YellowString(roleName))
+		return nil
+	}
+
+	if containerName == "" {
+		containerName = roleName
+	}
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	f.UI.Printf("Draining role %s in container %s\n", color.GreenString(roleName), color.CyanString(containerName))
+
+	stdoutWriter := docker.NewFormattingWriter(f.UI, nil)
+	stderrWriter := docker.NewFormattingWriter(f.UI, func(line string) string {
+		return color.RedString("%s", line)
+	})
+	exitCode, err := dockerManager.ExecInContainer(containerName, drainCommand, stdoutWriter, stderrWriter)
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		return fmt.Errorf("Error draining role %s: %s", roleName, err.Error())
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("Draining role %s failed with exit code %d", roleName, exitCode)
+	}
+
+	f.UI.Printf("Role %s drained\n", color.GreenString(roleName))
+
+	return nil
+}
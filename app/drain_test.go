@@ -0,0 +1,37 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainRole(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/drain.yml")
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.DrainRole(roleManifestPath, "missing", "")
+	assert.EqualError(err, "Unknown role missing")
+
+	err = f.DrainRole(roleManifestPath, "quietrole", "")
+	if assert.NoError(err) {
+		assert.Contains(output.String(), "has no drain scripts")
+	}
+}
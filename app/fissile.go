@@ -1,19 +1,29 @@
+// Package app implements the commands of fissile. Its methods return their
+// errors rather than exiting, and write to the UI and logger they are given,
+// so that fissile can be used as a library as well as by its command line.
 package app
 
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hpcloud/fissile/builder"
 	"github.com/hpcloud/fissile/compilator"
 	"github.com/hpcloud/fissile/docker"
 	"github.com/hpcloud/fissile/kube"
+	"github.com/hpcloud/fissile/logging"
 	"github.com/hpcloud/fissile/model"
 	"github.com/hpcloud/fissile/scripts/compilation"
 	"github.com/hpcloud/fissile/util"
@@ -25,24 +35,89 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// releaseDownloadsDir is the directory, inside the BOSH cache directory,
+// remote releases are downloaded into by default
+const releaseDownloadsDir = "fissile-release-downloads"
+
 // Fissile represents a fissile application
 type Fissile struct {
 	Version                    string
 	UI                         *termui.UI
+	log                        *logging.Logger
+	errorOutput                io.Writer
 	cmdErr                     error
-	releases                   []*model.Release // Only applies for some commands
-	patchPropertiesReleaseName string           // Only applies for some commands
-	patchPropertiesJobName     string           // Only applies for some commands
+	releases                   []*model.Release          // Only applies for some commands
+	patchPropertiesReleaseName string                    // Only applies for some commands
+	patchPropertiesJobName     string                    // Only applies for some commands
+	releaseDownloadDir         string                    // Only applies for some commands
+	registryEnvironment        string                    // Only applies for some commands
+	allowUnknownOpinions       bool                      // Only applies for some commands
+	transferLimits             *docker.TransferLimits    // Only applies for some commands
+	roleGroups                 []string                  // Only applies for some commands
+	releasesLock               string                    // Only applies for some commands
+	packageCache               compilator.PackageCache   // Only applies for some commands
+	packageCacheReadOnly       bool                      // Only applies for some commands
+	kubeCompilation            *compilator.KubeOptions   // Only applies for some commands
+	compilationStatsPath       string                    // Only applies for some commands
+	keepFailedContainers       bool                      // Only applies for some commands
+	compileLimits              *compilator.CompileLimits // Only applies for some commands
+	buildCache                 *compilator.BuildCache    // Only applies for some commands
+	squashImages               bool                      // Only applies for some commands
+	sbomFormat                 string                    // Only applies for some commands
+	sbomDirectory              string                    // Only applies for some commands
+	prefetches                 map[string]*imagePrefetch // Only applies for some commands
+	imageBuilder               builder.ImageBuilder      // Only applies for some commands
+	platform                   docker.Platform           // Only applies for some commands
+	publicServiceType          string                    // Only applies for some commands
+	checkSecrets               bool                      // Only applies for some commands
+	cacheLock                  *os.File                  // Only applies for some commands
+	prefetchLock               sync.Mutex
 }
 
 // NewFissileApplication creates a new app.Fissile
 func NewFissileApplication(version string, ui *termui.UI) *Fissile {
 	return &Fissile{
-		Version: version,
-		UI:      ui,
+		Version:     version,
+		UI:          ui,
+		log:         logging.New(ui, logging.Info, logging.FormatText),
+		errorOutput: os.Stderr,
+		platform:    docker.DefaultPlatform,
 	}
 }
 
+// The subsystems whose progress is logged
+const (
+	logBuild   = "build"
+	logCompile = "compile"
+	logConfig  = "config"
+	logDocker  = "docker"
+	logRelease = "release"
+	logCache   = "cache"
+)
+
+// SetLogger sets the logger the progress of commands is written to, apart
+// from their results. By default, it is written to the UI.
+func (f *Fissile) SetLogger(logger *logging.Logger) {
+	f.log = logger
+}
+
+// SetErrorOutput sets where the errors of the programs run attached to the
+// UI, such as shells, are written. By default, they are written to stderr.
+func (f *Fissile) SetErrorOutput(output io.Writer) {
+	f.errorOutput = output
+}
+
+// logger returns the logger of the progress of a subsystem
+func (f *Fissile) logger(subsystem string) *logging.Logger {
+	return f.log.WithSubsystem(subsystem)
+}
+
+// progressUI returns a UI for the components printing their progress,
+// logging each line of it for the subsystem
+func (f *Fissile) progressUI(subsystem string) *termui.UI {
+	return termui.New(f.UI.Reader, f.logger(subsystem).Writer(logging.Info), f.UI.PasswordReader)
+}
+
 // SetPatchPropertiesDirective saves the patch-properties release and job names, if specified.
 func (f *Fissile) SetPatchPropertiesDirective(patchPropertiesDirective string) error {
 	if patchPropertiesDirective == "" {
@@ -64,6 +139,173 @@ func (f *Fissile) SetPatchPropertiesDirective(patchPropertiesDirective string) e
 	return nil
 }
 
+// SetReleaseDownloadDir sets the directory remote releases are downloaded
+// into. If not set, they are stored in the BOSH cache directory.
+func (f *Fissile) SetReleaseDownloadDir(downloadDir string) {
+	f.releaseDownloadDir = downloadDir
+}
+
+// SetRegistryEnvironment selects the environment, from the registries of the
+// role manifest, whose registry prefix is used for image names
+func (f *Fissile) SetRegistryEnvironment(environment string) {
+	f.registryEnvironment = environment
+}
+
+// SetPublicServiceType sets the type of the Kubernetes services making the
+// public ports of roles reachable: LoadBalancer (the default) or NodePort
+func (f *Fissile) SetPublicServiceType(serviceType string) error {
+	switch serviceType {
+	case "", kube.PublicServiceTypeLoadBalancer, kube.PublicServiceTypeNodePort:
+	default:
+		return fmt.Errorf("Invalid public service type %s, expected %s or %s",
+			serviceType, kube.PublicServiceTypeLoadBalancer, kube.PublicServiceTypeNodePort)
+	}
+	f.publicServiceType = serviceType
+	return nil
+}
+
+// SetCheckSecrets selects whether validating the role manifest and opinions
+// also reports the properties which look like they hold secrets, but are
+// neither dark opinions nor templated
+func (f *Fissile) SetCheckSecrets(check bool) {
+	f.checkSecrets = check
+}
+
+// SetAllowUnknownOpinions selects whether opinions on properties which are
+// not defined by any loaded job are only warned about, instead of failing
+// validation
+func (f *Fissile) SetAllowUnknownOpinions(allow bool) {
+	f.allowUnknownOpinions = allow
+}
+
+// SetTransferLimits sets how many images are pushed or pulled at the same
+// time, overall and per registry
+func (f *Fissile) SetTransferLimits(limits *docker.TransferLimits) {
+	f.transferLimits = limits
+}
+
+// SetPackageCache sets the cache compiled packages are shared through;
+// with readOnly set, newly compiled packages are not stored in it
+func (f *Fissile) SetPackageCache(cache compilator.PackageCache, readOnly bool) {
+	f.packageCache = cache
+	f.packageCacheReadOnly = readOnly
+}
+
+// SetKubeCompilation makes packages compile as Kubernetes jobs configured
+// by the given options, instead of in local containers; nil compiles them
+// locally again
+func (f *Fissile) SetKubeCompilation(opts *compilator.KubeOptions) {
+	f.kubeCompilation = opts
+}
+
+// SetCompilationStats sets the path of the JSON file the statistics of each
+// compilation run are written to; none are if empty
+func (f *Fissile) SetCompilationStats(path string) {
+	f.compilationStatsPath = path
+}
+
+// SetKeepFailedContainers selects whether the containers of failed package
+// compilations are kept for debugging, instead of being removed
+func (f *Fissile) SetKeepFailedContainers(keep bool) {
+	f.keepFailedContainers = keep
+}
+
+// SetCompileLimits sets the resource limits of the containers compiling
+// packages; they are not limited if nil
+func (f *Fissile) SetCompileLimits(limits *compilator.CompileLimits) {
+	f.compileLimits = limits
+}
+
+// SetBuildCache sets the build cache mounted into the containers compiling
+// packages; there is none if nil
+func (f *Fissile) SetBuildCache(cache *compilator.BuildCache) {
+	f.buildCache = cache
+}
+
+// SetRoleGroups selects the groups whose roles commands operate on; all
+// roles are used if no groups are given
+func (f *Fissile) SetRoleGroups(groups []string) {
+	f.roleGroups = groups
+}
+
+// SetImageBuilder selects the tool role images are built with: the docker
+// daemon, or one of the external builders, whose executable is command, or
+// its usual one if empty. BuildKit keeps its build cache in cacheDirectory,
+// if set.
+func (f *Fissile) SetImageBuilder(name, command, cacheDirectory string) error {
+	if name == "" || name == builder.DockerBuilder {
+		f.imageBuilder = nil
+		return nil
+	}
+	if cacheDirectory != "" && name != builder.BuildKitBuilder {
+		return fmt.Errorf("Only %s keeps a build cache", builder.BuildKitBuilder)
+	}
+
+	externalBuilder, err := builder.NewExternalImageBuilder(name, command)
+	if err != nil {
+		return err
+	}
+	externalBuilder.SetCacheDirectory(cacheDirectory)
+	f.imageBuilder = externalBuilder
+	return nil
+}
+
+// SetSquashImages selects whether the layers each role image adds to its
+// packages layer image are merged into one
+func (f *Fissile) SetSquashImages(squash bool) {
+	f.squashImages = squash
+}
+
+// SetSBOM has a software bill of materials written in the given format for
+// each role image built, to the given directory; none are written if the
+// format is empty
+func (f *Fissile) SetSBOM(format, directory string) error {
+	if format != "" {
+		if err := builder.ValidateSBOMFormat(format); err != nil {
+			return err
+		}
+	}
+	f.sbomFormat = format
+	f.sbomDirectory = directory
+	return nil
+}
+
+// SetPlatform sets the platform packages are compiled for, which keeps their
+// archives in the package cache apart from those of other platforms
+func (f *Fissile) SetPlatform(platform docker.Platform) {
+	f.platform = platform
+}
+
+// SetReleasesLock sets the path of the releases lock the loaded releases
+// are checked against; they are not checked if the file doesn't exist
+func (f *Fissile) SetReleasesLock(path string) {
+	f.releasesLock = path
+}
+
+// compileProgress returns the report of package compilation: a live status
+// table on terminals, log lines otherwise
+func (f *Fissile) compileProgress() compilator.Progress {
+	if terminal := f.log.Terminal(); terminal != nil && f.log.Enabled(logging.Info) {
+		return compilator.NewProgress(terminal, true)
+	}
+	return compilator.NewProgress(f.logger(logCompile).Writer(logging.Info), false)
+}
+
+// loadRoleManifest loads the role manifest, keeping only the roles of the
+// selected groups
+func (f *Fissile) loadRoleManifest(roleManifestPath string) (*model.RoleManifest, error) {
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := roleManifest.SelectGroups(f.roleGroups); err != nil {
+		return nil, err
+	}
+
+	return roleManifest, nil
+}
+
 // ShowBaseImage will show details about the base BOSH images
 func (f *Fissile) ShowBaseImage(repository string) error {
 	dockerManager, err := docker.NewImageManager()
@@ -71,7 +313,7 @@ func (f *Fissile) ShowBaseImage(repository string) error {
 		return fmt.Errorf("Error connecting to docker: %s", err.Error())
 	}
 
-	comp, err := compilator.NewDockerCompilator(dockerManager, "", "", repository, compilation.UbuntuBase, f.Version, false, f.UI)
+	comp, err := compilator.NewDockerCompilator(dockerManager, "", "", repository, compilation.UbuntuBase, f.Version, false, f.progressUI(logCompile))
 	if err != nil {
 		return fmt.Errorf("Error creating a new compilator: %s", err.Error())
 	}
@@ -106,14 +348,18 @@ func (f *Fissile) CreateBaseCompilationImage(baseImageName, repository, metricsP
 		return fmt.Errorf("Error connecting to docker: %s", err.Error())
 	}
 
+	if err := f.pullMissingImages(dockerManager, baseImageName); err != nil {
+		return err
+	}
+
 	baseImage, err := dockerManager.FindImage(baseImageName)
 	if err != nil {
 		return fmt.Errorf("Error looking up base image %s: %s", baseImageName, err)
 	}
 
-	f.UI.Println(color.GreenString("Base image with ID %s found", color.YellowString(baseImage.ID)))
+	f.logger(logDocker).Info(color.GreenString("Base image with ID %s found", color.YellowString(baseImage.ID)))
 
-	comp, err := compilator.NewDockerCompilator(dockerManager, "", "", repository, compilation.UbuntuBase, f.Version, keepContainer, f.UI)
+	comp, err := compilator.NewDockerCompilator(dockerManager, "", "", repository, compilation.UbuntuBase, f.Version, keepContainer, f.progressUI(logCompile))
 	if err != nil {
 		return fmt.Errorf("Error creating a new compilator: %s", err.Error())
 	}
@@ -125,6 +371,34 @@ func (f *Fissile) CreateBaseCompilationImage(baseImageName, repository, metricsP
 	return nil
 }
 
+// pullMissingImages pulls those of the given images that don't exist
+// locally, within the transfer limits, once their prefetches are over.
+// Images with a digest are verified.
+func (f *Fissile) pullMissingImages(dockerManager *docker.ImageManager, imageNames ...string) error {
+	f.waitForPrefetches(imageNames)
+
+	var missing []string
+	for _, imageName := range imageNames {
+		_, err := dockerManager.FindImage(imageName)
+		if err == docker.ErrImageNotFound {
+			missing = append(missing, imageName)
+		} else if err != nil {
+			return fmt.Errorf("Error looking up image %s: %s", imageName, err)
+		} else if err := dockerManager.VerifyImageDigest(imageName); err != nil {
+			return err
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	f.logger(logDocker).Infof("Pulling %s", color.YellowString(strings.Join(missing, ", ")))
+	if err := dockerManager.PullImages(missing, f.transferLimits, f.progressUI(logDocker)); err != nil {
+		return fmt.Errorf("Error pulling images: %s", err)
+	}
+	return nil
+}
+
 // GenerateBaseDockerImage generates a base docker image to be used as a FROM for role images
 func (f *Fissile) GenerateBaseDockerImage(targetPath, baseImage, metricsPath string, noBuild bool, repository string) error {
 	if metricsPath != "" {
@@ -141,11 +415,11 @@ func (f *Fissile) GenerateBaseDockerImage(targetPath, baseImage, metricsPath str
 
 	image, err := dockerManager.FindImage(baseImageName)
 	if err == docker.ErrImageNotFound {
-		f.UI.Println("Image doesn't exist, it will be created ...")
+		f.logger(logDocker).Info("Image doesn't exist, it will be created ...")
 	} else if err != nil {
 		return fmt.Errorf("Error looking up image: %s", err.Error())
 	} else {
-		f.UI.Println(color.GreenString(
+		f.logger(logDocker).Info(color.GreenString(
 			"Base role image %s with ID %s already exists. Doing nothing.",
 			color.YellowString(baseImageName),
 			color.YellowString(image.ID),
@@ -160,11 +434,15 @@ func (f *Fissile) GenerateBaseDockerImage(targetPath, baseImage, metricsPath str
 	baseImageBuilder := builder.NewBaseImageBuilder(baseImage)
 
 	if noBuild {
-		f.UI.Println("Skipping image build because of flag.")
+		f.logger(logDocker).Info("Skipping image build because of flag.")
 		return nil
 	}
 
-	f.UI.Println("Building base docker image ...")
+	if err := f.pullMissingImages(dockerManager, baseImage); err != nil {
+		return err
+	}
+
+	f.logger(logDocker).Info("Building base docker image ...")
 	log := new(bytes.Buffer)
 	stdoutWriter := docker.NewFormattingWriter(
 		log,
@@ -174,20 +452,24 @@ func (f *Fissile) GenerateBaseDockerImage(targetPath, baseImage, metricsPath str
 	tarPopulator := baseImageBuilder.NewDockerPopulator()
 	err = dockerManager.BuildImageFromCallback(baseImageName, stdoutWriter, tarPopulator)
 	if err != nil {
-		log.WriteTo(f.UI)
+		log.WriteTo(f.logger(logDocker).Writer(logging.Error))
 		return fmt.Errorf("Error building base image: %s", err)
 	}
-	f.UI.Println(color.GreenString("Done."))
+	f.logger(logDocker).Info(color.GreenString("Done."))
 
 	return nil
 }
 
 // ListPackages will list all BOSH packages within a list of dev releases
-func (f *Fissile) ListPackages() error {
+func (f *Fissile) ListPackages(outputFormat string) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
 
+	return f.printReport(f.collectReleases(false, true), outputFormat, f.listPackagesForHuman)
+}
+
+func (f *Fissile) listPackagesForHuman() {
 	for _, release := range f.releases {
 		f.UI.Println(color.GreenString("Dev release %s (%s)", color.YellowString(release.Name), color.MagentaString(release.Version)))
 
@@ -200,16 +482,18 @@ func (f *Fissile) ListPackages() error {
 			color.GreenString("%d", len(release.Packages)),
 		)
 	}
-
-	return nil
 }
 
 // ListJobs will list all jobs within a list of dev releases
-func (f *Fissile) ListJobs() error {
+func (f *Fissile) ListJobs(outputFormat string) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
 
+	return f.printReport(f.collectReleases(true, false), outputFormat, f.listJobsForHuman)
+}
+
+func (f *Fissile) listJobsForHuman() {
 	for _, release := range f.releases {
 		f.UI.Println(color.GreenString("Dev release %s (%s)", color.YellowString(release.Name), color.MagentaString(release.Version)))
 
@@ -222,8 +506,74 @@ func (f *Fissile) ListJobs() error {
 			color.GreenString("%d", len(release.Jobs)),
 		)
 	}
+}
 
-	return nil
+// JobsReport lists all jobs within a list of dev releases, like ListJobs.
+// With withProperties, it details each job: its packages, the processes monit
+// checks, and the properties its spec declares, with their defaults and
+// descriptions.
+func (f *Fissile) JobsReport(withProperties bool, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	if !withProperties {
+		return f.ListJobs(outputFormat)
+	}
+
+	return f.printReport(f.collectReleasesDetail(true, true, false), outputFormat, f.listJobDetailsForHuman)
+}
+
+func (f *Fissile) listJobDetailsForHuman() {
+	for _, release := range f.releases {
+		f.UI.Println(color.GreenString("Dev release %s (%s)", color.YellowString(release.Name), color.MagentaString(release.Version)))
+
+		for _, job := range release.Jobs {
+			f.UI.Printf("%s (%s): %s\n", color.YellowString(job.Name), color.WhiteString(job.Version), job.Description)
+
+			var packages []string
+			for _, pkg := range job.Packages {
+				packages = append(packages, pkg.Name)
+			}
+			if len(packages) > 0 {
+				f.UI.Printf("  packages: %s\n", strings.Join(packages, ", "))
+			}
+			if len(job.Processes) > 0 {
+				f.UI.Printf("  processes: %s\n", strings.Join(job.Processes, ", "))
+			}
+			if len(job.Properties) > 0 {
+				f.UI.Printf("  properties:\n")
+			}
+			for _, property := range job.Properties {
+				if property.Default != nil {
+					f.UI.Printf("    %s (default: %v)\n", color.YellowString(property.Name), property.Default)
+				} else {
+					f.UI.Printf("    %s\n", color.YellowString(property.Name))
+				}
+				if property.Description != "" {
+					f.UI.Printf("      %s\n", property.Description)
+				}
+			}
+		}
+
+		f.UI.Printf(
+			"There are %s jobs present.\n\n",
+			color.GreenString("%d", len(release.Jobs)),
+		)
+	}
+}
+
+// ShowReleases will list all jobs and packages within a list of dev
+// releases; machine-readable output is a single document covering both
+func (f *Fissile) ShowReleases(outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	return f.printReport(f.collectReleases(true, true), outputFormat, func() {
+		f.listJobsForHuman()
+		f.listPackagesForHuman()
+	})
 }
 
 // ListProperties will list all properties in all jobs within a list of dev releases
@@ -312,32 +662,40 @@ type propertyInfo struct {
 }
 
 func (f *Fissile) collectPropertyDefaults() propertyDefaults {
+	var jobs []*model.Job
+	for _, release := range f.releases {
+		jobs = append(jobs, release.Jobs...)
+	}
+	return collectJobPropertyDefaults(jobs)
+}
+
+// collectJobPropertyDefaults returns the defaults of the properties of the
+// given jobs
+func collectJobPropertyDefaults(jobs []*model.Job) propertyDefaults {
 	result := make(propertyDefaults)
 
-	for _, release := range f.releases {
-		for _, job := range release.Jobs {
-			for _, property := range job.Properties {
+	for _, job := range jobs {
+		for _, property := range job.Properties {
 
-				// Extend map for newly seen properties
-				if _, ok := result[property.Name]; !ok {
-					result[property.Name] = newPropertyInfo(false)
-				}
+			// Extend map for newly seen properties
+			if _, ok := result[property.Name]; !ok {
+				result[property.Name] = newPropertyInfo(false)
+			}
 
-				// Extend the map of defaults to job lists.
-				defaultAsString := fmt.Sprintf("%v", property.Default)
-				result[property.Name].defaults[defaultAsString] =
-					append(result[property.Name].defaults[defaultAsString], job)
+			// Extend the map of defaults to job lists.
+			defaultAsString := fmt.Sprintf("%v", property.Default)
+			result[property.Name].defaults[defaultAsString] =
+				append(result[property.Name].defaults[defaultAsString], job)
 
-				// Handle the property's hash flag, based on the current default for
-				// it. Note that if the default is <nil> we assume that it can be a
-				// hash. This works arounds problems in the CF spec files where the two
-				// hash-valued properties we are interested in do not have defaults.
-				// (uaa.clients, cc.quota_definitions)
+			// Handle the property's hash flag, based on the current default for
+			// it. Note that if the default is <nil> we assume that it can be a
+			// hash. This works arounds problems in the CF spec files where the two
+			// hash-valued properties we are interested in do not have defaults.
+			// (uaa.clients, cc.quota_definitions)
 
-				if property.Default == nil ||
-					reflect.TypeOf(property.Default).Kind() == reflect.Map {
-					result[property.Name].maybeHash = true
-				}
+			if property.Default == nil ||
+				reflect.TypeOf(property.Default).Kind() == reflect.Map {
+				result[property.Name].maybeHash = true
 			}
 		}
 	}
@@ -353,8 +711,9 @@ func newPropertyInfo(maybeHash bool) *propertyInfo {
 	}
 }
 
-// Compile will compile a list of dev BOSH releases
-func (f *Fissile) Compile(repository, targetPath, roleManifestPath, metricsPath string, roleNames []string, workerCount int, withoutDocker bool) error {
+// Compile will compile a list of dev BOSH releases. Packages compiled
+// already, against the same dependencies, are skipped unless force is set.
+func (f *Fissile) Compile(repository, targetPath, roleManifestPath, metricsPath string, roleNames []string, workerCount int, withoutDocker, force bool) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
@@ -364,49 +723,109 @@ func (f *Fissile) Compile(repository, targetPath, roleManifestPath, metricsPath
 		defer stampy.Stamp(metricsPath, "fissile", "compile-packages", "done")
 	}
 
-	dockerManager, err := docker.NewImageManager()
-	if err != nil {
-		return fmt.Errorf("Error connecting to docker: %s", err.Error())
-	}
-
-	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases)
+	roleManifest, err := f.loadRoleManifest(roleManifestPath)
 	if err != nil {
 		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
 	}
 
-	f.UI.Println(color.GreenString("Compiling packages for dev releases:"))
+	f.logger(logCompile).Info(color.GreenString("Compiling packages for dev releases:"))
 	for _, release := range f.releases {
-		f.UI.Printf("         %s (%s)\n", color.YellowString(release.Name), color.MagentaString(release.Version))
+		f.logger(logCompile).Infof("         %s (%s)", color.YellowString(release.Name), color.MagentaString(release.Version))
+	}
+
+	if withoutDocker && f.platform != docker.NativePlatform() {
+		return fmt.Errorf("Compiling packages for %s without docker is not possible on %s", f.platform, docker.NativePlatform())
+	}
+
+	if withoutDocker && f.kubeCompilation != nil {
+		return fmt.Errorf("Packages can't be compiled both without docker and in Kubernetes")
+	}
+	if withoutDocker && f.keepFailedContainers {
+		return fmt.Errorf("Packages compiled without docker have no containers to keep")
+	}
+	if withoutDocker && f.compileLimits != nil {
+		return fmt.Errorf("Packages compiled without docker can't be limited")
 	}
 
 	var comp *compilator.Compilator
 	if withoutDocker {
-		comp, err = compilator.NewMountNSCompilator(targetPath, metricsPath, repository, compilation.UbuntuBase, f.Version, f.UI)
+		comp, err = compilator.NewMountNSCompilator(targetPath, metricsPath, repository, compilation.UbuntuBase, f.Version, f.progressUI(logCompile))
+		if err != nil {
+			return fmt.Errorf("Error creating a new compilator: %s", err.Error())
+		}
+	} else if f.kubeCompilation != nil {
+		comp, err = compilator.NewKubeCompilator(targetPath, metricsPath, repository, compilation.UbuntuBase, f.Version, *f.kubeCompilation, f.keepFailedContainers, f.progressUI(logCompile))
 		if err != nil {
 			return fmt.Errorf("Error creating a new compilator: %s", err.Error())
 		}
 	} else {
-		comp, err = compilator.NewDockerCompilator(dockerManager, targetPath, metricsPath, repository, compilation.UbuntuBase, f.Version, false, f.UI)
+		dockerManager, err := docker.NewImageManager()
+		if err != nil {
+			return fmt.Errorf("Error connecting to docker: %s", err.Error())
+		}
+
+		comp, err = compilator.NewDockerCompilator(dockerManager, targetPath, metricsPath, repository, compilation.UbuntuBase, f.Version, f.keepFailedContainers, f.progressUI(logCompile))
 		if err != nil {
 			return fmt.Errorf("Error creating a new compilator: %s", err.Error())
 		}
 	}
 
+	comp.SetForce(force)
+	comp.SetPlatform(f.platform)
+	comp.SetProgress(f.compileProgress())
+	if f.compileLimits != nil {
+		comp.SetLimits(f.compileLimits)
+	}
+	if f.buildCache != nil {
+		comp.SetBuildCache(f.buildCache)
+	}
+	if f.packageCache != nil {
+		comp.SetPackageCache(f.packageCache, f.packageCacheReadOnly)
+	}
+
 	roles, err := roleManifest.SelectRoles(roleNames)
 	if err != nil {
 		return fmt.Errorf("Error selecting packages to build: %s", err.Error())
 	}
 
-	if err := comp.Compile(workerCount, f.releases, roles); err != nil {
-		return fmt.Errorf("Error compiling packages: %s", err.Error())
+	compileErr := comp.Compile(workerCount, f.releases, roles)
+
+	// Failed runs are worth tracking too
+	if f.compilationStatsPath != "" {
+		if err := f.writeCompilationStats(f.platform.File(f.compilationStatsPath), comp.Stats()); err != nil {
+			return err
+		}
+	}
+
+	if compileErr != nil {
+		return fmt.Errorf("Error compiling packages: %s", compileErr.Error())
+	}
+
+	return nil
+}
+
+// writeCompilationStats writes the statistics of a compilation run to a JSON
+// file
+func (f *Fissile) writeCompilationStats(path string, stats *compilator.CompilationStats) error {
+	buf, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, append(buf, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing compilation statistics: %s", err)
 	}
 
+	f.logger(logCompile).Infof("Wrote compilation statistics to %s", color.CyanString(path))
 	return nil
 }
 
 // CleanCache inspects the compilation cache and removes all packages
-// which are not referenced (anymore).
-func (f *Fissile) CleanCache(targetPath string) error {
+// which are not referenced (anymore). Packages referenced by the
+// keepVersions most recent dev versions of the releases are kept as
+// well. Packages compiled less than olderThan ago are never removed
+// (a zero duration removes packages regardless of their age).
+func (f *Fissile) CleanCache(targetPath string, keepVersions int, olderThan time.Duration) error {
 	// 1. Collect list of packages referenced by the releases. A
 	//    variant of the code in ListPackages, we keep only the
 	//    hashes.
@@ -415,17 +834,42 @@ func (f *Fissile) CleanCache(targetPath string) error {
 		return fmt.Errorf("Releases not loaded")
 	}
 
+	if keepVersions < 0 {
+		return fmt.Errorf("Invalid number of versions to keep: %d", keepVersions)
+	}
+
 	referenced := make(map[string]int)
 	for _, release := range f.releases {
 		for _, pkg := range release.Packages {
 			referenced[pkg.Version] = 1
 		}
+
+		if keepVersions == 0 || release.FinalRelease {
+			continue
+		}
+
+		devVersions, err := release.GetDevVersions()
+		if err != nil {
+			return fmt.Errorf("Error listing dev versions of release %s: %s", release.Name, err)
+		}
+		if len(devVersions) > keepVersions {
+			devVersions = devVersions[:keepVersions]
+		}
+		for _, devVersion := range devVersions {
+			packageVersions, err := release.GetDevVersionPackageVersions(devVersion)
+			if err != nil {
+				return fmt.Errorf("Error loading packages of release %s version %s: %s", release.Name, devVersion, err)
+			}
+			for _, packageVersion := range packageVersions {
+				referenced[packageVersion] = 1
+			}
+		}
 	}
 
 	/// 2. Scan local compilation cache, compare to referenced,
 	///    remove anything not found.
 
-	f.UI.Printf("Cleaning up %s\n", color.MagentaString(targetPath))
+	f.logger(logCompile).Infof("Cleaning up %s", color.MagentaString(targetPath))
 
 	cached, err := filepath.Glob(targetPath + "/*")
 	if err != nil {
@@ -433,21 +877,38 @@ func (f *Fissile) CleanCache(targetPath string) error {
 	}
 
 	removed := 0
+	var reclaimed int64
 	for _, cache := range cached {
 		key := filepath.Base(cache)
 		if _, ok := referenced[key]; ok {
 			continue
 		}
 
-		f.UI.Printf("- Removing %s\n", color.YellowString(key))
+		info, err := os.Stat(cache)
+		if err != nil {
+			return err
+		}
+		if olderThan > 0 && time.Since(info.ModTime()) < olderThan {
+			f.logger(logCompile).Debugf("- Keeping %s, compiled less than %s ago", color.YellowString(key), olderThan)
+			continue
+		}
+
+		size, err := diskUsage(cache)
+		if err != nil {
+			return err
+		}
+
+		f.logger(logCompile).Infof("- Removing %s (%sMB)", color.YellowString(key),
+			color.YellowString("%.2f", float64(size)/(1024*1024)))
 		if err := os.RemoveAll(cache); err != nil {
 			return err
 		}
 		removed++
+		reclaimed += size
 	}
 
 	if removed == 0 {
-		f.UI.Println("Nothing found to remove")
+		f.logger(logCompile).Info("Nothing found to remove")
 		return nil
 	}
 
@@ -455,49 +916,77 @@ func (f *Fissile) CleanCache(targetPath string) error {
 	if removed > 1 {
 		plural = "s"
 	}
-	f.UI.Printf("Removed %s package%s\n",
+	f.logger(logCompile).Infof("Removed %s package%s, reclaiming %sMB",
 		color.MagentaString(fmt.Sprintf("%d", removed)),
-		plural)
+		plural,
+		color.MagentaString("%.2f", float64(reclaimed)/(1024*1024)))
 
 	return nil
 }
 
+// diskUsage returns the total size of all the files in a directory tree
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 // GeneratePackagesRoleImage builds the docker image for the packages layer
 // where all packages are included
-func (f *Fissile) GeneratePackagesRoleImage(repository string, roleManifest *model.RoleManifest, noBuild, force bool, roles model.Roles, packagesImageBuilder *builder.PackagesImageBuilder) error {
+func (f *Fissile) GeneratePackagesRoleImage(repository string, noBuild, force bool, roles model.Roles, packagesImageBuilder *builder.PackagesImageBuilder) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
 
-	dockerManager, err := docker.NewImageManager()
-	if err != nil {
-		return fmt.Errorf("Error connecting to docker: %s", err.Error())
-	}
-
-	packagesLayerImageName, err := packagesImageBuilder.GetRolePackageImageName(roleManifest, roles)
+	packagesLayerImageName, err := packagesImageBuilder.GetRolePackageImageName(roles)
 	if err != nil {
 		return fmt.Errorf("Error finding role's package name: %s", err.Error())
 	}
-	if !force {
-		if hasImage, err := dockerManager.HasImage(packagesLayerImageName); err == nil && hasImage {
-			f.UI.Printf("Packages layer %s already exists. Skipping ...\n", color.YellowString(packagesLayerImageName))
-			return nil
+
+	// External builders pull the base image themselves, and have no images
+	// to look at
+	imageBuilder := f.imageBuilder
+	if imageBuilder == nil {
+		dockerManager, err := docker.NewImageManager()
+		if err != nil {
+			return fmt.Errorf("Error connecting to docker: %s", err.Error())
 		}
-	}
+		imageBuilder = dockerManager
 
-	baseImageName := builder.GetBaseImageName(repository, f.Version)
-	if hasImage, err := dockerManager.HasImage(baseImageName); err != nil {
-		return fmt.Errorf("Error getting base image: %s", err)
-	} else if !hasImage {
-		return fmt.Errorf("Failed to find role base %s, did you build it first?", baseImageName)
+		if !force {
+			if hasImage, err := dockerManager.HasImage(packagesLayerImageName); err == nil && hasImage {
+				f.logger(logDocker).Infof("Packages layer %s already exists. Skipping ...", color.YellowString(packagesLayerImageName))
+				return nil
+			}
+		}
+
+		baseImageName := packagesImageBuilder.BaseImageName(roles)
+		if baseImageName != builder.GetBaseImageName(repository, f.Version) {
+			// Base images of roles are not built by fissile
+			if err := f.pullMissingImages(dockerManager, baseImageName); err != nil {
+				return err
+			}
+		} else if hasImage, err := dockerManager.HasImage(baseImageName); err != nil {
+			return fmt.Errorf("Error getting base image: %s", err)
+		} else if !hasImage {
+			return fmt.Errorf("Failed to find role base %s, did you build it first?", baseImageName)
+		}
 	}
 
 	if noBuild {
-		f.UI.Println("Skipping packages layer docker image build because of --no-build flag.")
+		f.logger(logDocker).Info("Skipping packages layer docker image build because of --no-build flag.")
 		return nil
 	}
 
-	f.UI.Printf("Building packages layer docker image %s ...\n",
+	f.logger(logDocker).Infof("Building packages layer docker image %s ...",
 		color.YellowString(packagesLayerImageName))
 	log := new(bytes.Buffer)
 	stdoutWriter := docker.NewFormattingWriter(
@@ -506,24 +995,24 @@ func (f *Fissile) GeneratePackagesRoleImage(repository string, roleManifest *mod
 	)
 
 	tarPopulator := packagesImageBuilder.NewDockerPopulator(roles, force)
-	err = dockerManager.BuildImageFromCallback(packagesLayerImageName, stdoutWriter, tarPopulator)
+	err = imageBuilder.BuildImageFromCallback(packagesLayerImageName, stdoutWriter, tarPopulator)
 	if err != nil {
-		log.WriteTo(f.UI)
+		log.WriteTo(f.logger(logDocker).Writer(logging.Error))
 		return fmt.Errorf("Error building packages layer docker image: %s", err.Error())
 	}
-	f.UI.Println(color.GreenString("Done."))
+	f.logger(logDocker).Info(color.GreenString("Done."))
 
 	return nil
 }
 
 // GeneratePackagesRoleTarball builds a tarball snapshot of the build context
 // for the docker image for the packages layer where all packages are included
-func (f *Fissile) GeneratePackagesRoleTarball(repository string, roleManifest *model.RoleManifest, noBuild, force bool, roles model.Roles, outputDirectory string, packagesImageBuilder *builder.PackagesImageBuilder) error {
+func (f *Fissile) GeneratePackagesRoleTarball(repository string, noBuild, force bool, roles model.Roles, outputDirectory string, packagesImageBuilder *builder.PackagesImageBuilder) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
 
-	packagesLayerImageName, err := packagesImageBuilder.GetRolePackageImageName(roleManifest, roles)
+	packagesLayerImageName, err := packagesImageBuilder.GetRolePackageImageName(roles)
 	if err != nil {
 		return fmt.Errorf("Error finding role's package name: %v", err)
 	}
@@ -532,17 +1021,17 @@ func (f *Fissile) GeneratePackagesRoleTarball(repository string, roleManifest *m
 	if !force {
 		info, err := os.Stat(outputPath)
 		if err == nil && !info.IsDir() {
-			f.UI.Printf("Packages layer %s already exists. Skipping ...\n", color.YellowString(outputPath))
+			f.logger(logDocker).Infof("Packages layer %s already exists. Skipping ...", color.YellowString(outputPath))
 			return nil
 		}
 	}
 
 	if noBuild {
-		f.UI.Println("Skipping packages layer tarball build because of --no-build flag.")
+		f.logger(logDocker).Info("Skipping packages layer tarball build because of --no-build flag.")
 		return nil
 	}
 
-	f.UI.Printf("Building packages layer tarball %s ...\n", color.YellowString(outputPath))
+	f.logger(logDocker).Infof("Building packages layer tarball %s ...", color.YellowString(outputPath))
 
 	tarFile, err := os.Create(outputPath)
 	if err != nil {
@@ -559,7 +1048,7 @@ func (f *Fissile) GeneratePackagesRoleTarball(repository string, roleManifest *m
 	if err != nil {
 		return fmt.Errorf("Error closing tar file: %s", err)
 	}
-	f.UI.Println(color.GreenString("Done."))
+	f.logger(logDocker).Info(color.GreenString("Done."))
 
 	return nil
 }
@@ -569,6 +1058,9 @@ func (f *Fissile) GenerateRoleImages(targetPath, repository, metricsPath string,
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
+	if f.squashImages && (outputDirectory != "" || f.imageBuilder != nil) {
+		return fmt.Errorf("Only the role images built by docker can be squashed")
+	}
 
 	if metricsPath != "" {
 		stampy.Stamp(metricsPath, "fissile", "create-role-images", "start")
@@ -588,6 +1080,11 @@ func (f *Fissile) GenerateRoleImages(targetPath, repository, metricsPath string,
 		return fmt.Errorf(errs.Errors())
 	}
 
+	// Opinions are validated against all roles, before narrowing them down
+	if err := roleManifest.SelectGroups(f.roleGroups); err != nil {
+		return err
+	}
+
 	if outputDirectory != "" {
 		err = os.MkdirAll(outputDirectory, 0755)
 		if err != nil {
@@ -605,7 +1102,7 @@ func (f *Fissile) GenerateRoleImages(targetPath, repository, metricsPath string,
 		compiledPackagesPath,
 		targetPath,
 		f.Version,
-		f.UI,
+		f.progressUI(logDocker),
 	)
 	if err != nil {
 		return err
@@ -616,18 +1113,31 @@ func (f *Fissile) GenerateRoleImages(targetPath, repository, metricsPath string,
 		return err
 	}
 
-	if outputDirectory == "" {
-		err = f.GeneratePackagesRoleImage(repository, roleManifest, noBuild, force, roles, packagesImageBuilder)
-	} else {
-		err = f.GeneratePackagesRoleTarball(repository, roleManifest, noBuild, force, roles, outputDirectory, packagesImageBuilder)
-	}
-	if err != nil {
+	if err := validateJobTemplates(roles); err != nil {
 		return err
 	}
 
-	packagesLayerImageName, err := packagesImageBuilder.GetRolePackageImageName(roleManifest, roles)
-	if err != nil {
-		return err
+	// Roles with the same packages share a packages layer image. Later layers
+	// are built on top of earlier ones holding some of their packages, so that
+	// packages common to several groups are only stored once.
+	packagesImageNames := make(map[string]string, len(roles))
+	for _, group := range builder.GroupRolesByPackages(roles) {
+		if outputDirectory == "" {
+			err = f.GeneratePackagesRoleImage(repository, noBuild, force, group, packagesImageBuilder)
+		} else {
+			err = f.GeneratePackagesRoleTarball(repository, noBuild, force, group, outputDirectory, packagesImageBuilder)
+		}
+		if err != nil {
+			return err
+		}
+
+		packagesLayerImageName, err := packagesImageBuilder.GetRolePackageImageName(group)
+		if err != nil {
+			return err
+		}
+		for _, role := range group {
+			packagesImageNames[role.Name] = packagesLayerImageName
+		}
 	}
 
 	roleBuilder, err := builder.NewRoleImageBuilder(
@@ -639,13 +1149,17 @@ func (f *Fissile) GenerateRoleImages(targetPath, repository, metricsPath string,
 		metricsPath,
 		"",
 		f.Version,
-		f.UI,
+		f.progressUI(logDocker),
 	)
 	if err != nil {
 		return err
 	}
 
-	if err := roleBuilder.BuildRoleImages(roles, repository, packagesLayerImageName, outputDirectory, force, noBuild, workerCount); err != nil {
+	roleBuilder.SetImageBuilder(f.imageBuilder)
+	roleBuilder.SetSquash(f.squashImages)
+	roleBuilder.SetSBOM(f.sbomFormat, f.sbomDirectory)
+
+	if err := roleBuilder.BuildRoleImages(roles, repository, packagesImageNames, outputDirectory, force, noBuild, workerCount); err != nil {
 		return err
 	}
 
@@ -653,7 +1167,7 @@ func (f *Fissile) GenerateRoleImages(targetPath, repository, metricsPath string,
 }
 
 // ListRoleImages lists all dev role images
-func (f *Fissile) ListRoleImages(repository string, rolesManifestPath string, existingOnDocker, withVirtualSize bool) error {
+func (f *Fissile) ListRoleImages(repository string, rolesManifestPath string, existingOnDocker, withVirtualSize bool, outputFormat string) error {
 	if withVirtualSize && !existingOnDocker {
 		return fmt.Errorf("Cannot list image virtual sizes if not matching image names with docker")
 	}
@@ -672,48 +1186,66 @@ func (f *Fissile) ListRoleImages(repository string, rolesManifestPath string, ex
 		}
 	}
 
-	rolesManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
 	if err != nil {
 		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
 	}
 
+	images := []*roleImageReport{}
 	for _, role := range rolesManifest.Roles {
 		devVersion, err := role.GetRoleDevVersion()
 		if err != nil {
 			return fmt.Errorf("Error creating role checksum: %s", err.Error())
 		}
 
-		imageName := builder.GetRoleDevImageName(repository, role, devVersion)
-
-		if !existingOnDocker {
-			f.UI.Println(imageName)
-			continue
+		report := &roleImageReport{
+			Role:  role.Name,
+			Image: builder.GetRoleDevImageName(repository, role, devVersion),
 		}
 
-		image, err := dockerManager.FindImage(imageName)
+		if existingOnDocker {
+			image, err := dockerManager.FindImage(report.Image)
 
-		if err == docker.ErrImageNotFound {
-			continue
-		} else if err != nil {
-			return fmt.Errorf("Error looking up image: %s", err.Error())
-		}
+			if err == docker.ErrImageNotFound {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("Error looking up image: %s", err.Error())
+			}
 
-		if withVirtualSize {
-			f.UI.Printf(
-				"%s (%sMB)\n",
-				color.GreenString(imageName),
-				color.YellowString("%.2f", float64(image.VirtualSize)/(1024*1024)),
-			)
-		} else {
-			f.UI.Println(imageName)
+			if withVirtualSize {
+				report.VirtualSize = image.VirtualSize
+			}
 		}
+
+		images = append(images, report)
 	}
 
-	return nil
+	return f.printReport(images, outputFormat, func() {
+		for _, image := range images {
+			if withVirtualSize {
+				f.UI.Printf(
+					"%s (%sMB)\n",
+					color.GreenString(image.Image),
+					color.YellowString("%.2f", float64(image.VirtualSize)/(1024*1024)),
+				)
+			} else {
+				f.UI.Println(image.Image)
+			}
+		}
+	})
 }
 
 //LoadReleases loads information about BOSH releases
 func (f *Fissile) LoadReleases(releasePaths, releaseNames, releaseVersions []string, cacheDir string) error {
+	if err := f.loadReleases(releasePaths, releaseNames, releaseVersions, cacheDir); err != nil {
+		return err
+	}
+	return f.checkReleasesLock()
+}
+
+// loadReleases loads the releases without checking them against the releases
+// lock, for commands comparing or pinning releases
+func (f *Fissile) loadReleases(releasePaths, releaseNames, releaseVersions []string, cacheDir string) error {
 	releases := make([]*model.Release, len(releasePaths))
 
 	for idx, releasePath := range releasePaths {
@@ -727,11 +1259,37 @@ func (f *Fissile) LoadReleases(releasePaths, releaseNames, releaseVersions []str
 			releaseVersion = releaseVersions[idx]
 		}
 
-		release, err := model.NewDevRelease(releasePath, releaseName, releaseVersion, cacheDir)
+		if model.IsRemoteRelease(releasePath) {
+			downloadDir := f.releaseDownloadDir
+			if downloadDir == "" {
+				downloadDir = filepath.Join(cacheDir, releaseDownloadsDir)
+			}
+
+			f.logger(logRelease).Info(color.GreenString("Fetching release %s", color.YellowString(releasePath)))
+			downloadedPath, err := model.DownloadRelease(releasePath, downloadDir)
+			if err != nil {
+				return fmt.Errorf("Error loading release information: %s", err.Error())
+			}
+			releasePath = downloadedPath
+		}
+
+		var release *model.Release
+		var err error
+		if model.IsFinalRelease(releasePath) {
+			release, err = model.NewFinalRelease(releasePath, releaseName, releaseVersion, cacheDir)
+		} else {
+			release, err = model.NewDevRelease(releasePath, releaseName, releaseVersion, cacheDir)
+			if err == nil {
+				// Final releases are verified while loading them
+				err = release.ValidateArchives()
+			}
+		}
 		if err != nil {
 			return fmt.Errorf("Error loading release information: %s", err.Error())
 		}
 
+		f.logger(logRelease).Debugf("Loaded release %s (%s) from %s",
+			color.YellowString(release.Name), release.Version, release.Path)
 		releases[idx] = release
 	}
 
@@ -759,7 +1317,7 @@ func (f *Fissile) GetDiffConfigurationBases(releasePaths []string, cacheDir stri
 		return nil, fmt.Errorf("expected two release paths, got %d", len(releasePaths))
 	}
 	defaultValues := []string{}
-	err := f.LoadReleases(releasePaths, defaultValues, defaultValues, cacheDir)
+	err := f.loadReleases(releasePaths, defaultValues, defaultValues, cacheDir)
 	if err != nil {
 		return nil, fmt.Errorf("dev config diff: error loading release information: %s", err)
 	}
@@ -874,29 +1432,173 @@ func compareHashes(v1Hash, v2Hash keyHash) *HashDiffs {
 	return &HashDiffs{AddedKeys: added, DeletedKeys: deleted, ChangedValues: changed}
 }
 
+// writeKubeConfiguration writes the ConfigMap and Secret holding the values
+// of the configuration variables of a role
+func writeKubeConfiguration(role *model.Role, settings *kube.ExportSettings, outputFile *os.File) error {
+	configMap, err := kube.NewConfigMap(role, settings)
+	if err != nil {
+		return err
+	}
+	if configMap != nil {
+		if err := kube.WriteYamlConfig(configMap, outputFile); err != nil {
+			return err
+		}
+	}
+
+	return writeKubeSecret(role, settings, outputFile)
+}
+
+// writeKubeSecret writes the Secret holding the values of the secret
+// configuration variables of a role, if it has any
+func writeKubeSecret(role *model.Role, settings *kube.ExportSettings, outputFile *os.File) error {
+	secret, err := kube.NewSecret(role, settings)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return nil
+	}
+	return kube.WriteYamlConfig(secret, outputFile)
+}
+
+// writeExternalAccess writes the service making the public ports of a role
+// reachable, and its Ingress, if it has them
+func writeExternalAccess(role *model.Role, settings *kube.ExportSettings, outputFile *os.File) error {
+	publicService, err := kube.NewPublicService(role, settings)
+	if err != nil {
+		return err
+	}
+	if publicService != nil {
+		if err := kube.WriteYamlConfig(publicService, outputFile); err != nil {
+			return err
+		}
+	}
+
+	ingress, err := kube.NewIngress(role)
+	if err != nil {
+		return err
+	}
+	if ingress != nil {
+		if err := kube.WriteYamlConfig(ingress, outputFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeRoleAccess writes the service account of the pods of a role, if they
+// need access to the Kubernetes API
+func writeRoleAccess(role *model.Role, outputFile *os.File) error {
+	access := kube.NewRoleAccess(role)
+	if access == nil {
+		return nil
+	}
+	return kube.WriteYamlConfig(access, outputFile)
+}
+
+// writeHorizontalPodAutoscaler writes the autoscaler of a role, if it scales
+func writeHorizontalPodAutoscaler(role *model.Role, outputFile *os.File) error {
+	hpa, err := kube.NewHorizontalPodAutoscaler(role)
+	if err != nil {
+		return err
+	}
+	if hpa == nil {
+		return nil
+	}
+	return kube.WriteYamlConfig(hpa, outputFile)
+}
+
+// writeEnvFile writes the env file of a role into the output directory. The
+// secret variables are kept out of it, but it is still written readable by
+// the owner only, as the other values may be sensitive too.
+func (f *Fissile) writeEnvFile(role *model.Role, settings *kube.ExportSettings, outputDir string) error {
+	contents, err := kube.NewEnvFile(role, settings)
+	if err != nil {
+		return err
+	}
+
+	outputPath := filepath.Join(outputDir, kube.EnvFileName(role))
+	f.logger(logConfig).Infof("Writing env file %s for role %s",
+		color.CyanString(outputPath),
+		color.CyanString(role.Name),
+	)
+	return ioutil.WriteFile(outputPath, contents, 0600)
+}
+
+// vaultSecretsFile is the name of the file, in the Kubernetes output
+// directory, holding the secrets to import into Vault
+const vaultSecretsFile = "vault-secrets.json"
+
+// writeVaultSecrets writes the secrets of all roles into a JSON file, keyed
+// by their Vault KV path. It is written readable by the owner only, as it
+// holds the secrets in plain text.
+func writeVaultSecrets(secrets map[string]map[string]string, outputPath string) error {
+	buf, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(outputPath, append(buf, '\n'), 0600)
+}
+
+// imageRegistry returns the docker registry of role images: the one given,
+// or else the registry prefix of the selected registry environment, which
+// can't be combined with a registry or organization
+func (f *Fissile) imageRegistry(rolesManifest *model.RoleManifest, registry, organization string) (string, error) {
+	if f.registryEnvironment == "" {
+		return registry, nil
+	}
+
+	if registry != "" || organization != "" {
+		return "", fmt.Errorf("A registry environment can't be combined with a docker registry or organization")
+	}
+
+	return rolesManifest.LookupRegistry(f.registryEnvironment)
+}
+
 // GenerateKube will create a set of configuration files suitable for deployment
 // on Kubernetes
-func (f *Fissile) GenerateKube(rolesManifestPath, outputDir, repository, registry, organization string, defaultFiles []string, useMemoryLimits bool) error {
+func (f *Fissile) GenerateKube(rolesManifestPath, outputDir, repository, registry, organization string, defaultFiles []string, useMemoryLimits bool, configProvider, vaultPath string) error {
+	if configProvider == "" {
+		configProvider = kube.ConfigProviderEnv
+	}
+	switch configProvider {
+	case kube.ConfigProviderEnv, kube.ConfigProviderEnvFiles, kube.ConfigProviderK8s, kube.ConfigProviderVault:
+	default:
+		return fmt.Errorf("Invalid configuration provider %s, expected %s, %s, %s or %s",
+			configProvider, kube.ConfigProviderEnv, kube.ConfigProviderEnvFiles, kube.ConfigProviderK8s, kube.ConfigProviderVault)
+	}
 
-	rolesManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
 	if err != nil {
 		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
 	}
 
-	f.UI.Println("Loading defaults from env files")
+	registry, err = f.imageRegistry(rolesManifest, registry, organization)
+	if err != nil {
+		return err
+	}
+
+	f.logger(logConfig).Info("Loading defaults from env files")
 	defaults, err := godotenv.Read(defaultFiles...)
 	if err != nil {
 		return err
 	}
 
 	settings := &kube.ExportSettings{
-		Defaults:        defaults,
-		Registry:        registry,
-		Organization:    organization,
-		Repository:      repository,
-		UseMemoryLimits: useMemoryLimits,
+		Defaults:          defaults,
+		Registry:          registry,
+		Organization:      organization,
+		Repository:        repository,
+		UseMemoryLimits:   useMemoryLimits,
+		ConfigProvider:    configProvider,
+		VaultPath:         vaultPath,
+		PublicServiceType: f.publicServiceType,
 	}
 
+	vaultSecrets := make(map[string]map[string]string)
+
 	for _, role := range rolesManifest.Roles {
 		if role.IsDevRole() {
 			continue
@@ -908,7 +1610,7 @@ func (f *Fissile) GenerateKube(rolesManifestPath, outputDir, repository, registr
 		}
 		outputPath := filepath.Join(roleTypeDir, fmt.Sprintf("%s.yml", role.Name))
 
-		f.UI.Printf("Writing config %s for role %s\n",
+		f.logger(logConfig).Infof("Writing config %s for role %s",
 			color.CyanString(outputPath),
 			color.CyanString(role.Name),
 		)
@@ -919,6 +1621,32 @@ func (f *Fissile) GenerateKube(rolesManifestPath, outputDir, repository, registr
 		}
 		defer outputFile.Close()
 
+		switch configProvider {
+		case kube.ConfigProviderEnv:
+			if err := writeKubeSecret(role, settings, outputFile); err != nil {
+				return err
+			}
+		case kube.ConfigProviderEnvFiles:
+			if err := f.writeEnvFile(role, settings, outputDir); err != nil {
+				return err
+			}
+			if err := writeKubeSecret(role, settings, outputFile); err != nil {
+				return err
+			}
+		case kube.ConfigProviderK8s:
+			if err := writeKubeConfiguration(role, settings, outputFile); err != nil {
+				return err
+			}
+		case kube.ConfigProviderVault:
+			secrets, err := kube.NewVaultSecrets(role, settings)
+			if err != nil {
+				return err
+			}
+			if secrets != nil {
+				vaultSecrets[kube.VaultSecretsPath(role, settings)] = secrets
+			}
+		}
+
 		switch role.Type {
 		case model.RoleTypeBoshTask:
 			job, err := kube.NewJob(role, settings)
@@ -930,10 +1658,12 @@ func (f *Fissile) GenerateKube(rolesManifestPath, outputDir, repository, registr
 				return err
 			}
 
-		case model.RoleTypeBosh:
-			needsStorage := len(role.Run.PersistentVolumes) != 0 || len(role.Run.SharedVolumes) != 0
+			if err := writeRoleAccess(role, outputFile); err != nil {
+				return err
+			}
 
-			if role.HasTag("clustered") || needsStorage {
+		case model.RoleTypeBosh:
+			if role.IsStateful() {
 				statefulSet, deps, err := kube.NewStatefulSet(role, settings)
 				if err != nil {
 					return err
@@ -947,6 +1677,18 @@ func (f *Fissile) GenerateKube(rolesManifestPath, outputDir, repository, registr
 					return err
 				}
 
+				if err := writeExternalAccess(role, settings, outputFile); err != nil {
+					return err
+				}
+
+				if err := writeRoleAccess(role, outputFile); err != nil {
+					return err
+				}
+
+				if err := writeHorizontalPodAutoscaler(role, outputFile); err != nil {
+					return err
+				}
+
 				continue
 			}
 
@@ -959,11 +1701,105 @@ func (f *Fissile) GenerateKube(rolesManifestPath, outputDir, repository, registr
 				return err
 			}
 
+			canary, err := kube.NewCanaryDeployment(role, settings)
+			if err != nil {
+				return err
+			}
+
+			if canary != nil {
+				if err := kube.WriteYamlConfig(canary, outputFile); err != nil {
+					return err
+				}
+			}
+
 			if svc != nil {
 				if err := kube.WriteYamlConfig(svc, outputFile); err != nil {
 					return err
 				}
 			}
+
+			if err := writeExternalAccess(role, settings, outputFile); err != nil {
+				return err
+			}
+
+			if err := writeRoleAccess(role, outputFile); err != nil {
+				return err
+			}
+
+			if err := writeHorizontalPodAutoscaler(role, outputFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	if configProvider == kube.ConfigProviderVault {
+		outputPath := filepath.Join(outputDir, vaultSecretsFile)
+		f.logger(logConfig).Infof("Writing Vault secrets %s", color.CyanString(outputPath))
+		if err := writeVaultSecrets(vaultSecrets, outputPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// environmentNamePattern matches the names of environments, which become
+// directory names and Vault path segments
+var environmentNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// GenerateKubeEnvironments creates the Kubernetes configuration files of
+// several named environments in one run. Each environment is written to a
+// directory of its own in outputDir, and with the vault provider, keeps its
+// secrets under a Vault path of its own in vaultPath, which must be set.
+// Environments only differ in their values: the defaults files are shared by
+// all environments; when environmentsDir is set, each environment overlays
+// them with <environment>.env from that directory.
+func (f *Fissile) GenerateKubeEnvironments(environments []string, environmentsDir, rolesManifestPath, outputDir, repository, registry, organization string, defaultFiles []string, useMemoryLimits bool, configProvider, vaultPath string) error {
+	vaultPath = strings.TrimSuffix(vaultPath, "/")
+	if configProvider == kube.ConfigProviderVault && vaultPath == "" {
+		return fmt.Errorf("The vault provider needs a Vault path to keep the secrets of each environment under")
+	}
+
+	seen := make(map[string]bool, len(environments))
+	for _, environment := range environments {
+		if !environmentNamePattern.MatchString(environment) {
+			return fmt.Errorf("Invalid environment name %s, expected lowercase letters, digits and dashes", environment)
+		}
+		if seen[environment] {
+			return fmt.Errorf("Environment %s given more than once", environment)
+		}
+		seen[environment] = true
+	}
+
+	for _, environment := range environments {
+		environmentFiles := append([]string{}, defaultFiles...)
+		if environmentsDir != "" {
+			overlay := filepath.Join(environmentsDir, fmt.Sprintf("%s.env", environment))
+			if _, err := os.Stat(overlay); err != nil {
+				return fmt.Errorf("Error reading the values of environment %s: %s", environment, err)
+			}
+			environmentFiles = append(environmentFiles, overlay)
+		}
+
+		environmentVaultPath := ""
+		if vaultPath != "" {
+			environmentVaultPath = fmt.Sprintf("%s/%s", vaultPath, environment)
+		}
+
+		f.logger(logConfig).Infof("Generating environment %s", color.GreenString(environment))
+		err := f.GenerateKube(
+			rolesManifestPath,
+			filepath.Join(outputDir, environment),
+			repository,
+			registry,
+			organization,
+			environmentFiles,
+			useMemoryLimits,
+			configProvider,
+			environmentVaultPath,
+		)
+		if err != nil {
+			return fmt.Errorf("Error generating environment %s: %s", environment, err)
 		}
 	}
 
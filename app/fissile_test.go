@@ -2,12 +2,14 @@ package app
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/hpcloud/fissile/model"
 	"github.com/hpcloud/termui"
@@ -27,11 +29,78 @@ func TestCleanCacheEmpty(t *testing.T) {
 	f := NewFissileApplication(".", ui)
 	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
 	if assert.NoError(err) {
-		err = f.CleanCache(workDir + "compilation")
+		err = f.CleanCache(workDir+"compilation", 0, 0)
 		assert.Nil(err, "Expected CleanCache to find the release")
 	}
 }
 
+func TestCleanCachePolicy(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	releasePathCacheDir := filepath.Join(releasePath, "bosh-cache")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	const (
+		currentPackage = "543219fbdaf6ec6f8af2956016055f2fb100d782" // ntp 2+dev.3
+		olderPackage   = "d7a94e58bfd958e811284e3d4e8ba2408abd1c6c" // ntp 2+dev.1, 2+dev.2
+		unknownPackage = "0123456789abcdef0123456789abcdef01234567"
+	)
+
+	setup := func() string {
+		compilationDir, err := ioutil.TempDir("", "fissile-tests")
+		assert.NoError(err)
+		for _, key := range []string{currentPackage, olderPackage, unknownPackage} {
+			compiledDir := filepath.Join(compilationDir, key, "compiled")
+			assert.NoError(os.MkdirAll(compiledDir, 0755))
+			assert.NoError(ioutil.WriteFile(filepath.Join(compiledDir, "file"), []byte("compiled"), 0644))
+		}
+		return compilationDir
+	}
+	remaining := func(compilationDir string) []string {
+		entries, err := ioutil.ReadDir(compilationDir)
+		assert.NoError(err)
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.Name())
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	compilationDir := setup()
+	defer os.RemoveAll(compilationDir)
+	assert.NoError(f.CleanCache(compilationDir, 0, 0))
+	assert.Equal([]string{currentPackage}, remaining(compilationDir))
+
+	compilationDir = setup()
+	defer os.RemoveAll(compilationDir)
+	assert.NoError(f.CleanCache(compilationDir, 3, 0))
+	assert.Equal([]string{currentPackage, olderPackage}, remaining(compilationDir),
+		"Packages of the last dev versions should be kept")
+
+	compilationDir = setup()
+	defer os.RemoveAll(compilationDir)
+	assert.NoError(f.CleanCache(compilationDir, 0, time.Hour))
+	assert.Len(remaining(compilationDir), 3, "Recently compiled packages should be kept")
+
+	past := time.Now().Add(-2 * time.Hour)
+	assert.NoError(os.Chtimes(filepath.Join(compilationDir, unknownPackage), past, past))
+	assert.NoError(f.CleanCache(compilationDir, 0, time.Hour))
+	assert.Equal([]string{currentPackage, olderPackage}, remaining(compilationDir))
+
+	assert.Error(f.CleanCache(compilationDir, -1, 0))
+}
+
 func TestListPackages(t *testing.T) {
 	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
 	assert := assert.New(t)
@@ -50,7 +119,7 @@ func TestListPackages(t *testing.T) {
 
 	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
 	if assert.NoError(err) {
-		err = f.ListPackages()
+		err = f.ListPackages("human")
 		assert.Nil(err, "Expected ListPackages to find the release")
 	}
 }
@@ -74,7 +143,7 @@ func TestListJobs(t *testing.T) {
 
 	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
 	if assert.NoError(err) {
-		err = f.ListJobs()
+		err = f.ListJobs("human")
 		assert.Nil(err, "Expected ListJobs to find the release")
 	}
 }
@@ -234,3 +303,260 @@ func TestFissileSelectRolesToBuild(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateKubeConfigProvider(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/config-provider.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	envFile := filepath.Join(outputDir, "defaults.env")
+	assert.NoError(ioutil.WriteFile(envFile, []byte("HOSTNAME=tor.example.com\nPRIVATE_KEY=not-so-private\n"), 0644))
+	defaultFiles := []string{envFile}
+
+	err = f.GenerateKube(roleManifestPath, outputDir, "fissile", "", "", defaultFiles, false, "consul", "")
+	assert.EqualError(err, "Invalid configuration provider consul, expected env, envfiles, k8s or vault")
+
+	err = f.GenerateKube(roleManifestPath, outputDir, "fissile", "", "", defaultFiles, false, "k8s", "")
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "bosh", "myrole.yml"))
+	if assert.NoError(err) {
+		assert.Contains(string(contents), "kind: ConfigMap")
+		assert.Contains(string(contents), "kind: Secret")
+		assert.Contains(string(contents), "configMapKeyRef")
+		assert.NotContains(string(contents), "value: tor.example.com")
+	}
+}
+
+func TestGenerateKubeEnvFilesProvider(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/config-provider.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	envFile := filepath.Join(outputDir, "defaults.env")
+	assert.NoError(ioutil.WriteFile(envFile, []byte("CONTROL_PASSWORD=hunter2\nPRIVATE_KEY=not-so-private\n"), 0644))
+
+	err = f.GenerateKube(roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "envfiles", "")
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "bosh", "myrole.yml"))
+	if assert.NoError(err) {
+		assert.Contains(string(contents), "value: tor.example.com")
+		assert.Contains(string(contents), "kind: Secret")
+		assert.Contains(string(contents), "secretKeyRef")
+		assert.NotContains(string(contents), "value: hunter2")
+	}
+
+	info, err := os.Stat(filepath.Join(outputDir, "myrole.env"))
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+
+	contents, err = ioutil.ReadFile(filepath.Join(outputDir, "myrole.env"))
+	if assert.NoError(err) {
+		assert.Contains(string(contents), "HOSTNAME=tor.example.com\n")
+		assert.NotContains(string(contents), "hunter2")
+		assert.NotContains(string(contents), "not-so-private")
+	}
+}
+
+func TestGenerateKubeVaultProvider(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/config-provider.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	envFile := filepath.Join(outputDir, "defaults.env")
+	assert.NoError(ioutil.WriteFile(envFile, []byte("CONTROL_PASSWORD=hunter2\nPRIVATE_KEY=not-so-private\n"), 0644))
+
+	err = f.GenerateKube(roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "vault", "secret/scf")
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "bosh", "myrole.yml"))
+	if assert.NoError(err) {
+		assert.Contains(string(contents), "value: tor.example.com")
+		assert.Contains(string(contents), "value: secret/scf/myrole")
+		assert.NotContains(string(contents), "hunter2")
+		assert.NotContains(string(contents), "not-so-private")
+	}
+
+	info, err := os.Stat(filepath.Join(outputDir, "vault-secrets.json"))
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+
+	contents, err = ioutil.ReadFile(filepath.Join(outputDir, "vault-secrets.json"))
+	if assert.NoError(err) {
+		var secrets map[string]map[string]string
+		assert.NoError(json.Unmarshal(contents, &secrets))
+		assert.Equal(map[string]map[string]string{
+			"secret/scf/myrole": {
+				"CONTROL_PASSWORD": "hunter2",
+				"PRIVATE_KEY":      "not-so-private",
+			},
+		}, secrets)
+	}
+}
+
+func TestGenerateKubeEnvironments(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/config-provider.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	envFile := filepath.Join(outputDir, "defaults.env")
+	assert.NoError(ioutil.WriteFile(envFile, []byte("CONTROL_PASSWORD=hunter2\nHOSTNAME=tor.example.com\n"), 0644))
+
+	environmentsDir := filepath.Join(outputDir, "environments")
+	assert.NoError(os.Mkdir(environmentsDir, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(environmentsDir, "prod.env"), []byte("HOSTNAME=tor.prod.example.com\n"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(environmentsDir, "staging.env"), []byte("HOSTNAME=tor.staging.example.com\n"), 0644))
+
+	err = f.GenerateKubeEnvironments([]string{"prod", "staging"}, environmentsDir, roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "vault", "secret/scf/")
+	if !assert.NoError(err) {
+		return
+	}
+
+	for _, environment := range []string{"prod", "staging"} {
+		contents, err := ioutil.ReadFile(filepath.Join(outputDir, environment, "bosh", "myrole.yml"))
+		if assert.NoError(err) {
+			assert.Contains(string(contents), fmt.Sprintf("value: tor.%s.example.com", environment))
+			assert.Contains(string(contents), fmt.Sprintf("value: secret/scf/%s/myrole", environment))
+		}
+
+		contents, err = ioutil.ReadFile(filepath.Join(outputDir, environment, "vault-secrets.json"))
+		if assert.NoError(err) {
+			var secrets map[string]map[string]string
+			assert.NoError(json.Unmarshal(contents, &secrets))
+			if assert.Contains(secrets, fmt.Sprintf("secret/scf/%s/myrole", environment)) {
+				assert.Equal("hunter2", secrets[fmt.Sprintf("secret/scf/%s/myrole", environment)]["CONTROL_PASSWORD"])
+			}
+		}
+	}
+
+	err = f.GenerateKubeEnvironments([]string{"dev"}, environmentsDir, roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "env", "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Error reading the values of environment dev")
+	}
+
+	err = f.GenerateKubeEnvironments([]string{"Prod"}, "", roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "env", "")
+	assert.EqualError(err, "Invalid environment name Prod, expected lowercase letters, digits and dashes")
+
+	err = f.GenerateKubeEnvironments([]string{"prod", "prod"}, "", roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "env", "")
+	assert.EqualError(err, "Environment prod given more than once")
+
+	err = f.GenerateKubeEnvironments([]string{"prod"}, "", roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "vault", "/")
+	assert.EqualError(err, "The vault provider needs a Vault path to keep the secrets of each environment under")
+}
+
+func TestGenerateKubeRegistryEnvironment(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/registries.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	envFile := filepath.Join(outputDir, "defaults.env")
+	assert.NoError(ioutil.WriteFile(envFile, []byte{}, 0644))
+
+	f.SetRegistryEnvironment("production")
+
+	err = f.GenerateKube(roleManifestPath, outputDir, "fissile", "", "cf", []string{envFile}, false, "", "")
+	assert.EqualError(err, "A registry environment can't be combined with a docker registry or organization")
+
+	err = f.GenerateKube(roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "", "")
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "bosh", "myrole.yml"))
+	if assert.NoError(err) {
+		assert.Contains(string(contents), "image: docker.example.com/cf/fissile-myrole:")
+	}
+
+	f.SetRegistryEnvironment("qa")
+	err = f.GenerateKube(roleManifestPath, outputDir, "fissile", "", "", []string{envFile}, false, "", "")
+	assert.EqualError(err, "Unknown registry environment qa, expected one of: internal, production, staging")
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// groupReport summarizes the roles of a role group
+type groupReport struct {
+	Name         string   `json:"name" yaml:"name"`
+	Roles        []string `json:"roles" yaml:"roles"`
+	MinInstances int32    `json:"min_instances" yaml:"min_instances"`
+	MaxInstances int32    `json:"max_instances" yaml:"max_instances"`
+	Memory       int      `json:"memory" yaml:"memory"`             // MB requested by the minimum number of instances
+	VirtualCPUs  float64  `json:"virtual_cpus" yaml:"virtual_cpus"` // CPUs requested by the minimum number of instances
+}
+
+// ShowGroups reports the role groups of the role manifest, with their roles
+// and the instances and resources they need. Roles without a group are
+// reported in a group without a name.
+func (f *Fissile) ShowGroups(roleManifestPath, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := f.loadRoleManifest(roleManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	groupsByName := make(map[string]*groupReport)
+	groups := []*groupReport{}
+	for _, role := range roleManifest.Roles {
+		group, ok := groupsByName[role.Group]
+		if !ok {
+			group = &groupReport{Name: role.Group, Roles: []string{}}
+			groupsByName[role.Group] = group
+			groups = append(groups, group)
+		}
+
+		group.Roles = append(group.Roles, role.Name)
+		if role.Run == nil || role.Run.Scaling == nil {
+			continue
+		}
+		group.MinInstances += role.Run.Scaling.Min
+		group.MaxInstances += role.Run.Scaling.Max
+		group.Memory += role.Run.Memory.Request * int(role.Run.Scaling.Min)
+		group.VirtualCPUs += role.Run.CPU.Request * float64(role.Run.Scaling.Min)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	return f.printReport(groups, outputFormat, func() {
+		f.UI.Printf("%-24s %-10s %-10s %-6s %s\n", "GROUP", "INSTANCES", "MEMORY", "CPUS", "ROLES")
+		for _, group := range groups {
+			name := group.Name
+			if name == "" {
+				name = "(none)"
+			}
+			f.UI.Printf("%s %-10s %-10s %-6g %s\n",
+				color.GreenString("%-24s", name),
+				fmt.Sprintf("%d-%d", group.MinInstances, group.MaxInstances),
+				fmt.Sprintf("%dMB", group.Memory),
+				group.VirtualCPUs,
+				strings.Join(group.Roles, ", "))
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/groups.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(f.ShowGroups(roleManifestPath, "json")) {
+		return
+	}
+
+	var groups []*groupReport
+	if assert.NoError(json.Unmarshal(buffer.Bytes(), &groups)) && assert.Len(groups, 3) {
+		assert.Equal(&groupReport{Name: "", Roles: []string{"loose"}, MinInstances: 1, MaxInstances: 1}, groups[0])
+		assert.Equal("control-plane", groups[1].Name)
+		assert.Equal(&groupReport{
+			Name:         "data-plane",
+			Roles:        []string{"myrole", "otherrole"},
+			MinInstances: 3,
+			MaxInstances: 5,
+			Memory:       512,
+			VirtualCPUs:  5,
+		}, groups[2])
+	}
+
+	// Only the selected groups are reported
+	buffer.Reset()
+	f.SetRoleGroups([]string{"control-plane"})
+	if assert.NoError(f.ShowGroups(roleManifestPath, "json")) {
+		groups = nil
+		if assert.NoError(json.Unmarshal(buffer.Bytes(), &groups)) && assert.Len(groups, 1) {
+			assert.Equal([]string{"controlrole"}, groups[0].Roles)
+		}
+	}
+
+	f.SetRoleGroups([]string{"storage"})
+	err = f.ShowGroups(roleManifestPath, "json")
+	assert.EqualError(err, "Error loading roles manifest: Some role groups are unknown: [storage]")
+}
@@ -0,0 +1,580 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// pushedImageReport is the machine-readable form of a pushed role image
+type pushedImageReport struct {
+	Role   string `json:"role" yaml:"role"`
+	Image  string `json:"image" yaml:"image"`
+	Digest string `json:"digest" yaml:"digest"`
+}
+
+// PushRoleImages tags the images of the selected roles with the registry and
+// organization, and pushes them. With several platforms, the image of each
+// platform is pushed with the platform appended to its tag, and a manifest
+// list referring to them is pushed as the image name. A summary of the pushed
+// digests is printed.
+func (f *Fissile) PushRoleImages(rolesManifestPath, repository, registry, organization string, platforms []docker.Platform, roleNames []string, auth *docker.RegistryAuth, retries int, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	registry, err = f.imageRegistry(rolesManifest, registry, organization)
+	if err != nil {
+		return err
+	}
+
+	roles, err := rolesManifest.SelectRoles(roleNames)
+	if err != nil {
+		return err
+	}
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	targets, err := pushTargets(roles, repository, registry, organization, platforms)
+	if err != nil {
+		return err
+	}
+
+	var reports []*pushedImageReport
+	var targetNames []string
+	for _, role := range roles {
+		target := targets[role.Name]
+		images := target.platforms
+		if len(images) == 0 {
+			images = []platformPushTarget{{source: target.source, name: target.name}}
+		}
+
+		for _, image := range images {
+			if hasImage, err := dockerManager.HasImage(image.source); err != nil {
+				return err
+			} else if !hasImage {
+				return fmt.Errorf("Failed to find role image %s, did you build it first?", image.source)
+			}
+
+			if err := dockerManager.TagImage(image.source, image.name); err != nil {
+				return fmt.Errorf("Error tagging image %s as %s: %s", image.source, image.name, err)
+			}
+			targetNames = append(targetNames, image.name)
+		}
+
+		reports = append(reports, &pushedImageReport{Role: role.Name, Image: target.name})
+	}
+
+	f.logger(logDocker).Infof("Pushing %s images", color.YellowString("%d", len(targetNames)))
+	digests, err := dockerManager.PushImages(targetNames, f.transferLimits, auth, retries, f.progressUI(logDocker))
+	if err != nil {
+		return fmt.Errorf("Error pushing images: %s", err)
+	}
+
+	for _, report := range reports {
+		target := targets[report.Role]
+		if len(target.platforms) == 0 {
+			report.Digest = digests[report.Image]
+			continue
+		}
+
+		var images []docker.PlatformImage
+		for _, image := range target.platforms {
+			images = append(images, docker.PlatformImage{Image: image.name, Platform: image.platform})
+		}
+		f.logger(logDocker).Infof("Pushing the manifest list %s", color.YellowString(target.name))
+		if report.Digest, err = docker.CreateManifestList(target.name, images, auth); err != nil {
+			return err
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Role < reports[j].Role })
+
+	return f.printReport(reports, outputFormat, func() {
+		for _, report := range reports {
+			f.UI.Printf("%s: %s@%s\n", color.GreenString(report.Role), report.Image, color.YellowString(report.Digest))
+		}
+	})
+}
+
+// pushTarget is the local image of a role and the name it is pushed as. With
+// several platforms, the images of the platforms are pushed instead, and the
+// name is that of the manifest list referring to them.
+type pushTarget struct {
+	source    string
+	name      string
+	platforms []platformPushTarget
+}
+
+// platformPushTarget is the local image of a role for a platform and the name
+// it is pushed as
+type platformPushTarget struct {
+	platform docker.Platform
+	source   string
+	name     string
+}
+
+// pushTargets returns the images of roles and the names they are pushed as,
+// in the registry and organization, by role name. The images are those built
+// for the platforms; no platforms stand for the default one.
+func pushTargets(roles model.Roles, repository, registry, organization string, platforms []docker.Platform) (map[string]pushTarget, error) {
+	if len(platforms) == 0 {
+		platforms = []docker.Platform{docker.DefaultPlatform}
+	}
+	targets := make(map[string]pushTarget, len(roles))
+
+	for _, role := range roles {
+		devVersion, err := role.GetRoleDevVersion()
+		if err != nil {
+			return nil, fmt.Errorf("Error calculating checksum for role %s: %s", role.Name, err.Error())
+		}
+
+		name := builder.GetRoleDevImageName(repository, role, devVersion)
+		if organization != "" {
+			name = fmt.Sprintf("%s/%s", organization, name)
+		}
+		if registry != "" {
+			name = fmt.Sprintf("%s/%s", registry, name)
+		}
+
+		target := pushTarget{name: name}
+		if len(platforms) == 1 {
+			target.source = builder.GetRoleDevImageName(platforms[0].Repository(repository), role, devVersion)
+		} else {
+			for _, platform := range platforms {
+				target.platforms = append(target.platforms, platformPushTarget{
+					platform: platform,
+					source:   builder.GetRoleDevImageName(platform.Repository(repository), role, devVersion),
+					name:     fmt.Sprintf("%s-%s", name, platform.Suffix()),
+				})
+			}
+		}
+		targets[role.Name] = target
+	}
+
+	return targets, nil
+}
+
+// packageUsageReport describes a compiled package and the roles using it
+type packageUsageReport struct {
+	Release     string   `json:"release" yaml:"release"`
+	Name        string   `json:"name" yaml:"name"`
+	Fingerprint string   `json:"fingerprint" yaml:"fingerprint"`
+	Size        int64    `json:"size" yaml:"size"`
+	Roles       []string `json:"roles" yaml:"roles"`
+}
+
+// packagesLayerReport describes a packages layer image, shared by the roles
+// using the same set of packages
+type packagesLayerReport struct {
+	Roles    []string `json:"roles" yaml:"roles"`
+	Packages int      `json:"packages" yaml:"packages"`
+	Size     int64    `json:"size" yaml:"size"`
+}
+
+// imageAnalysisReport describes how the compiled packages are spread across
+// the role images, and the registry size of the packages in several layouts
+type imageAnalysisReport struct {
+	Packages []*packageUsageReport  `json:"packages" yaml:"packages"`
+	Layers   []*packagesLayerReport `json:"layers" yaml:"layers"`
+	// The size of the packages if each role image had its own copy of them
+	PerRoleSize int64 `json:"per_role_size" yaml:"per_role_size"`
+	// The size of the packages layers, one per set of packages
+	LayeredSize int64 `json:"layered_size" yaml:"layered_size"`
+	// The size of the packages if each was stored once
+	SharedSize int64 `json:"shared_size" yaml:"shared_size"`
+}
+
+// AnalyzeRoleImages reports which compiled packages are shared by the role
+// images and which are unique to one, and estimates the registry size of the
+// packages with and without sharing them
+func (f *Fissile) AnalyzeRoleImages(rolesManifestPath, compiledPackagesPath, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	report, err := analyzeRoleImages(rolesManifest.Roles, compiledPackagesPath)
+	if err != nil {
+		return err
+	}
+
+	return f.printReport(report, outputFormat, func() {
+		megabytes := func(size int64) string {
+			return color.YellowString("%.2fMB", float64(size)/(1024*1024))
+		}
+
+		for _, shared := range []bool{true, false} {
+			if shared {
+				f.UI.Println(color.GreenString("Packages shared by several roles:"))
+			} else {
+				f.UI.Println(color.GreenString("Packages used by a single role:"))
+			}
+			for _, pkg := range report.Packages {
+				if (len(pkg.Roles) > 1) == shared {
+					f.UI.Printf("  %s/%s (%s): %s\n", pkg.Release, color.MagentaString(pkg.Name), megabytes(pkg.Size), strings.Join(pkg.Roles, ", "))
+				}
+			}
+		}
+
+		f.UI.Println(color.GreenString("Packages layers:"))
+		for _, layer := range report.Layers {
+			f.UI.Printf("  %s: %d packages, %s\n", strings.Join(layer.Roles, ", "), layer.Packages, megabytes(layer.Size))
+		}
+
+		f.UI.Println(color.GreenString("Registry size of the packages:"))
+		f.UI.Printf("  with a copy in each role image: %s\n", megabytes(report.PerRoleSize))
+		f.UI.Printf("  with a layer per set of packages: %s, saving %s\n",
+			megabytes(report.LayeredSize), megabytes(report.PerRoleSize-report.LayeredSize))
+		f.UI.Printf("  with each package stored once: %s, saving %s more\n",
+			megabytes(report.SharedSize), megabytes(report.LayeredSize-report.SharedSize))
+	})
+}
+
+// analyzeRoleImages measures the compiled packages of the roles, and how
+// they are shared between role images
+func analyzeRoleImages(roles model.Roles, compiledPackagesPath string) (*imageAnalysisReport, error) {
+	report := &imageAnalysisReport{}
+
+	sizes := make(map[string]int64)
+	usages := make(map[string]*packageUsageReport)
+	for _, role := range roles {
+		for _, pkg := range role.Jobs.Packages() {
+			usage, ok := usages[pkg.Fingerprint]
+			if !ok {
+				size, err := diskUsage(pkg.GetPackageCompiledDir(compiledPackagesPath))
+				if os.IsNotExist(err) {
+					return nil, fmt.Errorf("Package %s/%s is not compiled, did you build the packages first?", pkg.Release.Name, pkg.Name)
+				} else if err != nil {
+					return nil, err
+				}
+
+				usage = &packageUsageReport{
+					Release:     pkg.Release.Name,
+					Name:        pkg.Name,
+					Fingerprint: pkg.Fingerprint,
+					Size:        size,
+				}
+				usages[pkg.Fingerprint] = usage
+				sizes[pkg.Fingerprint] = size
+				report.Packages = append(report.Packages, usage)
+				report.SharedSize += size
+			}
+
+			usage.Roles = append(usage.Roles, role.Name)
+			report.PerRoleSize += usage.Size
+		}
+	}
+
+	sort.Slice(report.Packages, func(i, j int) bool {
+		if report.Packages[i].Release != report.Packages[j].Release {
+			return report.Packages[i].Release < report.Packages[j].Release
+		}
+		return report.Packages[i].Name < report.Packages[j].Name
+	})
+	for _, usage := range report.Packages {
+		sort.Strings(usage.Roles)
+	}
+
+	for _, group := range builder.GroupRolesByPackages(roles) {
+		layer := &packagesLayerReport{}
+		for _, role := range group {
+			layer.Roles = append(layer.Roles, role.Name)
+		}
+		packages := group[0].Jobs.Packages()
+		layer.Packages = len(packages)
+		for _, pkg := range packages {
+			layer.Size += sizes[pkg.Fingerprint]
+		}
+		report.Layers = append(report.Layers, layer)
+		report.LayeredSize += layer.Size
+	}
+
+	return report, nil
+}
+
+// imageContentReport is the size of a package or job in a role image
+type imageContentReport struct {
+	Name string `json:"name" yaml:"name"`
+	Size int64  `json:"size" yaml:"size"`
+}
+
+// builtImageReport describes the layers of a built role image, the files
+// they hide, and the largest packages and jobs in it
+type builtImageReport struct {
+	Role        string                     `json:"role" yaml:"role"`
+	Image       string                     `json:"image" yaml:"image"`
+	Size        int64                      `json:"size" yaml:"size"`
+	WastedSize  int64                      `json:"wasted_size" yaml:"wasted_size"`
+	Layers      []*docker.LayerReport      `json:"layers" yaml:"layers"`
+	HiddenFiles []*docker.HiddenFileReport `json:"hidden_files" yaml:"hidden_files"`
+	Packages    []*imageContentReport      `json:"packages" yaml:"packages"`
+	Jobs        []*imageContentReport      `json:"jobs" yaml:"jobs"`
+}
+
+// AnalyzeBuiltRoleImages inspects the images of the selected roles, as
+// built by `fissile build images`, and reports the size of their layers, the
+// files hidden by later layers, and the largest packages and jobs they hold.
+// Only the top entries of each list are reported.
+func (f *Fissile) AnalyzeBuiltRoleImages(rolesManifestPath, repository string, roleNames []string, top int, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	roles, err := rolesManifest.SelectRoles(roleNames)
+	if err != nil {
+		return err
+	}
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	var reports []*builtImageReport
+	for _, role := range roles {
+		devVersion, err := role.GetRoleDevVersion()
+		if err != nil {
+			return fmt.Errorf("Error calculating checksum for role %s: %s", role.Name, err.Error())
+		}
+		imageName := builder.GetRoleDevImageName(repository, role, devVersion)
+
+		if hasImage, err := dockerManager.HasImage(imageName); err != nil {
+			return err
+		} else if !hasImage {
+			return fmt.Errorf("Failed to find role image %s, did you build it first?", imageName)
+		}
+
+		f.logger(logDocker).Infof("Analyzing image %s", color.YellowString(imageName))
+		analysis, err := dockerManager.AnalyzeImage(imageName, roleImageContent)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, newBuiltImageReport(role, imageName, analysis, top))
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Role < reports[j].Role })
+
+	return f.printReport(reports, outputFormat, func() {
+		megabytes := func(size int64) string {
+			return color.YellowString("%.2fMB", float64(size)/(1024*1024))
+		}
+
+		for _, report := range reports {
+			f.UI.Printf("%s: %s, %s, of which %s hidden\n", color.GreenString(report.Role), report.Image,
+				megabytes(report.Size), megabytes(report.WastedSize))
+
+			f.UI.Println("  Layers:")
+			for index, layer := range report.Layers {
+				createdBy := layer.CreatedBy
+				if len(createdBy) > 60 {
+					createdBy = createdBy[:57] + "..."
+				}
+				f.UI.Printf("    %d: %s, %d files  %s\n", index, megabytes(layer.Size), layer.Files, createdBy)
+			}
+			if len(report.HiddenFiles) > 0 {
+				f.UI.Println("  Files hidden by later layers:")
+				for _, file := range report.HiddenFiles {
+					layers := make([]string, 0, len(file.Layers))
+					for _, layer := range file.Layers {
+						layers = append(layers, fmt.Sprintf("%d", layer))
+					}
+					f.UI.Printf("    %s: %s in layers %s\n", file.Path, megabytes(file.WastedSize), strings.Join(layers, ", "))
+				}
+			}
+			for _, contents := range []struct {
+				title   string
+				entries []*imageContentReport
+			}{{"Largest packages:", report.Packages}, {"Largest jobs:", report.Jobs}} {
+				f.UI.Println("  " + contents.title)
+				for _, entry := range contents.entries {
+					f.UI.Printf("    %s: %s\n", color.MagentaString(entry.Name), megabytes(entry.Size))
+				}
+			}
+		}
+	})
+}
+
+// Prefixes of the groups files of role images are sorted into
+const (
+	packageContentPrefix = "package:"
+	jobContentPrefix     = "job:"
+)
+
+// roleImageContent returns the group the size of a file of a role image
+// counts towards: its package, by fingerprint, or its job
+func roleImageContent(path string) string {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/var/vcap/"), "/", 3)
+	if len(parts) < 3 || !strings.HasPrefix(path, "/var/vcap/") {
+		return ""
+	}
+	switch parts[0] {
+	case "packages-src":
+		return packageContentPrefix + parts[1]
+	case "jobs-src":
+		return jobContentPrefix + parts[1]
+	}
+	return ""
+}
+
+// newBuiltImageReport reports the analysis of the image of a role, keeping
+// the top entries of each list
+func newBuiltImageReport(role *model.Role, imageName string, analysis *docker.ImageAnalysis, top int) *builtImageReport {
+	report := &builtImageReport{
+		Role:        role.Name,
+		Image:       imageName,
+		Size:        analysis.Size,
+		WastedSize:  analysis.WastedSize,
+		Layers:      analysis.Layers,
+		HiddenFiles: analysis.HiddenFiles,
+		Packages:    []*imageContentReport{},
+		Jobs:        []*imageContentReport{},
+	}
+	if len(report.HiddenFiles) > top {
+		report.HiddenFiles = report.HiddenFiles[:top]
+	}
+
+	packageNames := make(map[string]string)
+	for _, pkg := range role.Jobs.Packages() {
+		packageNames[pkg.Fingerprint] = fmt.Sprintf("%s/%s", pkg.Release.Name, pkg.Name)
+	}
+	for group, size := range analysis.Groups {
+		if strings.HasPrefix(group, packageContentPrefix) {
+			fingerprint := strings.TrimPrefix(group, packageContentPrefix)
+			name, ok := packageNames[fingerprint]
+			if !ok {
+				// From the packages layer, but not used by the role
+				name = fingerprint
+			}
+			report.Packages = append(report.Packages, &imageContentReport{Name: name, Size: size})
+		} else if strings.HasPrefix(group, jobContentPrefix) {
+			report.Jobs = append(report.Jobs, &imageContentReport{Name: strings.TrimPrefix(group, jobContentPrefix), Size: size})
+		}
+	}
+	for _, entries := range []*[]*imageContentReport{&report.Packages, &report.Jobs} {
+		sort.Slice(*entries, func(i, j int) bool {
+			if (*entries)[i].Size != (*entries)[j].Size {
+				return (*entries)[i].Size > (*entries)[j].Size
+			}
+			return (*entries)[i].Name < (*entries)[j].Name
+		})
+		if len(*entries) > top {
+			*entries = (*entries)[:top]
+		}
+	}
+
+	return report
+}
+
+// imageVerificationReport describes the packages of a role image that do not
+// match those the role uses now
+type imageVerificationReport struct {
+	Role       string   `json:"role" yaml:"role"`
+	Image      string   `json:"image" yaml:"image"`
+	Mismatches []string `json:"mismatches" yaml:"mismatches"`
+}
+
+// VerifyRoleImages compares the compiled packages recorded in the images of
+// the selected roles with the packages the roles use in the loaded releases,
+// and fails if any image has other packages or fingerprints
+func (f *Fissile) VerifyRoleImages(rolesManifestPath, repository string, roleNames []string, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	roles, err := rolesManifest.SelectRoles(roleNames)
+	if err != nil {
+		return err
+	}
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	var reports []*imageVerificationReport
+	mismatched := 0
+	for _, role := range roles {
+		devVersion, err := role.GetRoleDevVersion()
+		if err != nil {
+			return fmt.Errorf("Error calculating checksum for role %s: %s", role.Name, err.Error())
+		}
+		imageName := builder.GetRoleDevImageName(repository, role, devVersion)
+
+		image, err := dockerManager.FindImage(imageName)
+		if err == docker.ErrImageNotFound {
+			return fmt.Errorf("Failed to find role image %s, did you build it first?", imageName)
+		} else if err != nil {
+			return err
+		}
+
+		report := &imageVerificationReport{Role: role.Name, Image: imageName}
+		label := ""
+		if image.Config != nil {
+			label = image.Config.Labels[builder.PackagesLabel]
+		}
+		if label == "" {
+			report.Mismatches = []string{fmt.Sprintf("the image has no %s label", builder.PackagesLabel)}
+		} else {
+			provenance, err := builder.ParsePackagesLabel(label)
+			if err != nil {
+				return fmt.Errorf("Error verifying image %s: %s", imageName, err)
+			}
+			report.Mismatches = builder.VerifyPackagesProvenance(role, provenance)
+		}
+
+		if len(report.Mismatches) > 0 {
+			mismatched++
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Role < reports[j].Role })
+
+	err = f.printReport(reports, outputFormat, func() {
+		for _, report := range reports {
+			if len(report.Mismatches) == 0 {
+				f.UI.Printf("%s: %s %s\n", color.GreenString(report.Role), report.Image, color.GreenString("OK"))
+				continue
+			}
+			f.UI.Printf("%s: %s\n", color.RedString(report.Role), report.Image)
+			for _, mismatch := range report.Mismatches {
+				f.UI.Printf("  %s\n", mismatch)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if mismatched > 0 {
+		return fmt.Errorf("%d role images do not match the releases", mismatched)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// mirroredImageReport is the machine-readable form of a mirrored role image
+type mirroredImageReport struct {
+	Role   string `json:"role" yaml:"role"`
+	Source string `json:"source" yaml:"source"`
+	Image  string `json:"image" yaml:"image"`
+	Digest string `json:"digest" yaml:"digest"`
+}
+
+// MirrorRoleImages copies the images of the selected roles from one registry
+// to another, by digest, as when promoting the images of a build from a
+// staging registry to a production one. The registries are given as registry
+// environments of the role manifest, or as registry prefixes (a registry,
+// optionally followed by an organization). A summary of the mirrored digests
+// is printed.
+func (f *Fissile) MirrorRoleImages(rolesManifestPath, repository, from, to string, roleNames []string, sourceAuth, targetAuth *docker.RegistryAuth, retries int, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	sourcePrefix, err := mirrorRegistryPrefix(rolesManifest, from)
+	if err != nil {
+		return err
+	}
+	targetPrefix, err := mirrorRegistryPrefix(rolesManifest, to)
+	if err != nil {
+		return err
+	}
+	if sourcePrefix == targetPrefix {
+		return fmt.Errorf("Can't mirror images from %s to the same registry", sourcePrefix)
+	}
+
+	roles, err := rolesManifest.SelectRoles(roleNames)
+	if err != nil {
+		return err
+	}
+
+	sources, err := pushTargets(roles, repository, sourcePrefix, "", nil)
+	if err != nil {
+		return err
+	}
+	targets, err := pushTargets(roles, repository, targetPrefix, "", nil)
+	if err != nil {
+		return err
+	}
+
+	var mirrors []docker.ImageMirror
+	var reports []*mirroredImageReport
+	for _, role := range roles {
+		source, target := sources[role.Name].name, targets[role.Name].name
+		mirrors = append(mirrors, docker.ImageMirror{Source: source, Target: target})
+		reports = append(reports, &mirroredImageReport{Role: role.Name, Source: source, Image: target})
+	}
+
+	f.logger(logDocker).Infof("Mirroring %s images from %s to %s",
+		color.YellowString("%d", len(mirrors)), color.CyanString(sourcePrefix), color.CyanString(targetPrefix))
+	digests, err := docker.MirrorImages(mirrors, f.transferLimits, sourceAuth, targetAuth, retries, f.progressUI(logDocker))
+	if err != nil {
+		return fmt.Errorf("Error mirroring images: %s", err)
+	}
+
+	for _, report := range reports {
+		report.Digest = digests[report.Image]
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Role < reports[j].Role })
+
+	return f.printReport(reports, outputFormat, func() {
+		for _, report := range reports {
+			f.UI.Printf("%s: %s@%s\n", color.GreenString(report.Role), report.Image, color.YellowString(report.Digest))
+		}
+	})
+}
+
+// mirrorRegistryPrefix returns the registry prefix of a registry environment
+// of the role manifest, or the given registry prefix if it names none
+func mirrorRegistryPrefix(rolesManifest *model.RoleManifest, registry string) (string, error) {
+	if registry == "" {
+		return "", fmt.Errorf("Both the registry to mirror from and the one to mirror to are required")
+	}
+	if _, ok := rolesManifest.Registries[registry]; ok {
+		return rolesManifest.LookupRegistry(registry)
+	}
+	return strings.TrimSuffix(registry, "/"), nil
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+)
+
+// SavedImagesName is the name of the manifest listing the images saved to a
+// directory by SaveRoleImages
+const SavedImagesName = "saved-images.yml"
+
+// The formats role images are saved in
+const (
+	SaveFormatDocker = "docker" // A `docker save` tarball per image
+	SaveFormatOCI    = "oci"    // A single OCI image layout holding all images
+)
+
+// savedImagesManifest lists the role images saved to a directory
+type savedImagesManifest struct {
+	Format string              `json:"format" yaml:"format"`
+	Images []*savedImageReport `json:"images" yaml:"images"`
+}
+
+// savedImageReport describes a saved role image
+type savedImageReport struct {
+	Role  string `json:"role" yaml:"role"`
+	Image string `json:"image" yaml:"image"`
+	// The tarball of the image, relative to the target directory; empty for
+	// OCI image layouts, which hold all images
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// The SHA256 of the tarball, or the digest of the OCI image manifest
+	Digest string `json:"digest" yaml:"digest"`
+}
+
+// SaveRoleImages exports the images of the selected roles to the target
+// directory, as `docker save` tarballs or as an OCI image layout, so they can
+// be imported without access to a registry. The saved images are listed in
+// SavedImagesName in the target directory.
+func (f *Fissile) SaveRoleImages(rolesManifestPath, repository, targetPath, format string, roleNames []string, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	if format != SaveFormatDocker && format != SaveFormatOCI {
+		return fmt.Errorf("Invalid image format '%s', expected one of %s or %s", format, SaveFormatDocker, SaveFormatOCI)
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	roles, err := rolesManifest.SelectRoles(roleNames)
+	if err != nil {
+		return err
+	}
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	imageNames := make(map[string]string, len(roles))
+	for _, role := range roles {
+		devVersion, err := role.GetRoleDevVersion()
+		if err != nil {
+			return fmt.Errorf("Error calculating checksum for role %s: %s", role.Name, err.Error())
+		}
+		imageName := builder.GetRoleDevImageName(repository, role, devVersion)
+
+		if hasImage, err := dockerManager.HasImage(imageName); err != nil {
+			return err
+		} else if !hasImage {
+			return fmt.Errorf("Failed to find role image %s, did you build it first?", imageName)
+		}
+		imageNames[role.Name] = imageName
+	}
+
+	if err := os.MkdirAll(targetPath, 0755); err != nil {
+		return err
+	}
+
+	manifest := &savedImagesManifest{Format: format, Images: []*savedImageReport{}}
+	if format == SaveFormatDocker {
+		manifest.Images, err = f.saveDockerArchives(dockerManager, roles, imageNames, targetPath)
+	} else {
+		manifest.Images, err = f.saveOCILayout(dockerManager, roles, imageNames, targetPath)
+	}
+	if err != nil {
+		return err
+	}
+	sort.Slice(manifest.Images, func(i, j int) bool { return manifest.Images[i].Role < manifest.Images[j].Role })
+
+	contents, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(targetPath, SavedImagesName), contents, 0644); err != nil {
+		return err
+	}
+
+	return f.printReport(manifest.Images, outputFormat, func() {
+		for _, report := range manifest.Images {
+			if report.File == "" {
+				f.UI.Printf("%s: %s@%s\n", color.GreenString(report.Role), report.Image, color.YellowString(report.Digest))
+			} else {
+				f.UI.Printf("%s: %s in %s (%s)\n", color.GreenString(report.Role), report.Image, report.File, color.YellowString(report.Digest))
+			}
+		}
+		f.UI.Printf("Saved %s images to %s\n", color.YellowString("%d", len(manifest.Images)), targetPath)
+	})
+}
+
+// saveDockerArchives saves each role image as a `docker save` tarball named
+// after the role
+func (f *Fissile) saveDockerArchives(dockerManager *docker.ImageManager, roles model.Roles, imageNames map[string]string, targetPath string) ([]*savedImageReport, error) {
+	var reports []*savedImageReport
+	for _, role := range roles {
+		imageName := imageNames[role.Name]
+		fileName := fmt.Sprintf("%s.tar", role.Name)
+		f.logger(logDocker).Infof("Saving image %s to %s", color.YellowString(imageName), color.YellowString(fileName))
+
+		file, err := os.Create(filepath.Join(targetPath, fileName))
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		err = dockerManager.SaveImage(imageName, io.MultiWriter(file, hash))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error saving image %s: %s", imageName, err)
+		}
+
+		reports = append(reports, &savedImageReport{
+			Role:   role.Name,
+			Image:  imageName,
+			File:   fileName,
+			Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+	return reports, nil
+}
+
+// saveOCILayout adds the role images to an OCI image layout in the target
+// directory, named by their image name
+func (f *Fissile) saveOCILayout(dockerManager *docker.ImageManager, roles model.Roles, imageNames map[string]string, targetPath string) ([]*savedImageReport, error) {
+	layout, err := docker.NewOCILayout(targetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*savedImageReport
+	for _, role := range roles {
+		imageName := imageNames[role.Name]
+		f.logger(logDocker).Infof("Saving image %s to the OCI image layout", color.YellowString(imageName))
+
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(dockerManager.SaveImage(imageName, writer))
+		}()
+		digest, err := layout.AddDockerArchive(reader, imageName)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error saving image %s: %s", imageName, err)
+		}
+
+		reports = append(reports, &savedImageReport{Role: role.Name, Image: imageName, Digest: digest})
+	}
+
+	return reports, layout.WriteIndex()
+}
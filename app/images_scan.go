@@ -0,0 +1,184 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/docker"
+
+	"github.com/fatih/color"
+)
+
+// scannedVulnerability is a vulnerability found in a role image
+type scannedVulnerability struct {
+	docker.Vulnerability `yaml:",inline"`
+	// Whether the base image the role is built on has the vulnerability
+	// too, rather than the packages and jobs of the role bringing it
+	InBaseImage bool `json:"in_base_image" yaml:"in_base_image"`
+}
+
+// imageScanReport is the report written for each role image scanned
+type imageScanReport struct {
+	Role            string                  `json:"role" yaml:"role"`
+	Image           string                  `json:"image" yaml:"image"`
+	BaseImage       string                  `json:"base_image" yaml:"base_image"`
+	Scanner         string                  `json:"scanner" yaml:"scanner"`
+	Vulnerabilities []*scannedVulnerability `json:"vulnerabilities" yaml:"vulnerabilities"` // Most severe first
+}
+
+// imageScanSummary summarizes the scan of a role image
+type imageScanSummary struct {
+	Role   string `json:"role" yaml:"role"`
+	Image  string `json:"image" yaml:"image"`
+	Report string `json:"report" yaml:"report"` // The file of the report
+	// The number of vulnerabilities by severity
+	Severities map[string]int `json:"severities" yaml:"severities"`
+	// The number of vulnerabilities at or above the severity threshold
+	Failing int `json:"failing" yaml:"failing"`
+}
+
+// ScanRoleImages scans the base images and the images of the selected roles
+// for vulnerabilities, writing a JSON report for each role to the report
+// directory. It fails if any role image has vulnerabilities of the threshold
+// severity or above.
+func (f *Fissile) ScanRoleImages(rolesManifestPath, repository string, roleNames []string, scanner docker.ImageScanner, threshold, reportDirectory, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+	if err := docker.ValidateSeverity(threshold); err != nil {
+		return err
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	roles, err := rolesManifest.SelectRoles(roleNames)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(reportDirectory, 0755); err != nil {
+		return fmt.Errorf("Error creating report directory: %s", err)
+	}
+
+	// Roles mostly share their base image, which is scanned once
+	baseImageScans := make(map[string]map[string]bool)
+	var summaries []*imageScanSummary
+	failing := 0
+	for _, role := range roles {
+		devVersion, err := role.GetRoleDevVersion()
+		if err != nil {
+			return fmt.Errorf("Error calculating checksum for role %s: %s", role.Name, err.Error())
+		}
+		imageName := builder.GetRoleDevImageName(repository, role, devVersion)
+		baseImageName := role.BaseImage
+		if baseImageName == "" {
+			baseImageName = builder.GetBaseImageName(repository, f.Version)
+		}
+
+		inBaseImage, ok := baseImageScans[baseImageName]
+		if !ok {
+			f.logger(logDocker).Infof("Scanning base image %s with %s", color.YellowString(baseImageName), scanner.Name())
+			vulnerabilities, err := scanner.ScanImage(baseImageName)
+			if err != nil {
+				return err
+			}
+			inBaseImage = make(map[string]bool, len(vulnerabilities))
+			for _, vulnerability := range vulnerabilities {
+				inBaseImage[vulnerability.Key()] = true
+			}
+			baseImageScans[baseImageName] = inBaseImage
+		}
+
+		f.logger(logDocker).Infof("Scanning role image %s with %s", color.YellowString(imageName), scanner.Name())
+		vulnerabilities, err := scanner.ScanImage(imageName)
+		if err != nil {
+			return err
+		}
+
+		report := &imageScanReport{
+			Role:            role.Name,
+			Image:           imageName,
+			BaseImage:       baseImageName,
+			Scanner:         scanner.Name(),
+			Vulnerabilities: []*scannedVulnerability{},
+		}
+		summary := &imageScanSummary{
+			Role:       role.Name,
+			Image:      imageName,
+			Report:     filepath.Join(reportDirectory, fmt.Sprintf("%s.json", role.Name)),
+			Severities: make(map[string]int),
+		}
+		for _, vulnerability := range vulnerabilities {
+			report.Vulnerabilities = append(report.Vulnerabilities, &scannedVulnerability{
+				Vulnerability: *vulnerability,
+				InBaseImage:   inBaseImage[vulnerability.Key()],
+			})
+			summary.Severities[vulnerability.Severity]++
+			if docker.SeverityLevel(vulnerability.Severity) >= docker.SeverityLevel(threshold) {
+				summary.Failing++
+			}
+		}
+
+		contents, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(summary.Report, contents, 0644); err != nil {
+			return fmt.Errorf("Error writing the scan report of role %s: %s", role.Name, err)
+		}
+
+		if summary.Failing > 0 {
+			failing++
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Role < summaries[j].Role })
+
+	err = f.printReport(summaries, outputFormat, func() {
+		for _, summary := range summaries {
+			roleColor := color.GreenString
+			if summary.Failing > 0 {
+				roleColor = color.RedString
+			}
+			f.UI.Printf("%s: %s %s\n", roleColor(summary.Role), summary.Image, formatSeverityCounts(summary.Severities))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if failing > 0 {
+		return fmt.Errorf("%d role images have vulnerabilities of severity %s or above", failing, strings.ToUpper(threshold))
+	}
+	return nil
+}
+
+// formatSeverityCounts describes the number of vulnerabilities of each
+// severity, the most severe first
+func formatSeverityCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "no vulnerabilities"
+	}
+	severities := make([]string, 0, len(counts))
+	for severity := range counts {
+		severities = append(severities, severity)
+	}
+	sort.Slice(severities, func(i, j int) bool {
+		return docker.SeverityLevel(severities[i]) > docker.SeverityLevel(severities[j])
+	})
+
+	parts := make([]string, 0, len(severities))
+	for _, severity := range severities {
+		parts = append(parts, fmt.Sprintf("%s %d", severity, counts[severity]))
+	}
+	return strings.Join(parts, ", ")
+}
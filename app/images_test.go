@@ -0,0 +1,310 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/util"
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	rolesManifest, err := model.LoadRoleManifest(filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml"), f.releases)
+	if !assert.NoError(err) {
+		return
+	}
+	role := rolesManifest.LookupRole("myrole")
+	devVersion, err := role.GetRoleDevVersion()
+	assert.NoError(err)
+	source := builder.GetRoleDevImageName("fissile", role, devVersion)
+
+	targets, err := pushTargets(model.Roles{role}, "fissile", "registry.example.com:5000", "org", nil)
+	if assert.NoError(err) {
+		assert.Equal(pushTarget{source: source, name: "registry.example.com:5000/org/" + source}, targets["myrole"])
+	}
+
+	targets, err = pushTargets(model.Roles{role}, "fissile", "", "org", nil)
+	if assert.NoError(err) {
+		assert.Equal("org/"+source, targets["myrole"].name)
+	}
+
+	arm64 := docker.Platform{OS: "linux", Architecture: "arm64"}
+	armSource := builder.GetRoleDevImageName("fissile-linux-arm64", role, devVersion)
+
+	targets, err = pushTargets(model.Roles{role}, "fissile", "", "org", []docker.Platform{arm64})
+	if assert.NoError(err) {
+		assert.Equal(pushTarget{source: armSource, name: "org/" + source}, targets["myrole"])
+	}
+
+	targets, err = pushTargets(model.Roles{role}, "fissile", "", "org", []docker.Platform{docker.DefaultPlatform, arm64})
+	if assert.NoError(err) {
+		assert.Equal(pushTarget{
+			name: "org/" + source,
+			platforms: []platformPushTarget{
+				{platform: docker.DefaultPlatform, source: source, name: "org/" + source + "-linux-amd64"},
+				{platform: arm64, source: armSource, name: "org/" + source + "-linux-arm64"},
+			},
+		}, targets["myrole"])
+	}
+}
+
+func TestMirrorRegistryPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	rolesManifest := &model.RoleManifest{Registries: map[string]string{"staging": "staging.example.com/org/"}}
+
+	prefix, err := mirrorRegistryPrefix(rolesManifest, "staging")
+	if assert.NoError(err) {
+		assert.Equal("staging.example.com/org", prefix)
+	}
+	prefix, err = mirrorRegistryPrefix(rolesManifest, "registry.example.com:5000/org/")
+	if assert.NoError(err) {
+		assert.Equal("registry.example.com:5000/org", prefix)
+	}
+	_, err = mirrorRegistryPrefix(rolesManifest, "")
+	assert.Error(err)
+}
+
+func TestAnalyzeRoleImages(t *testing.T) {
+	assert := assert.New(t)
+
+	release := &model.Release{Name: "release"}
+	pkgA := &model.Package{Name: "aaa", Fingerprint: "fingerprint-a", Release: release}
+	pkgB := &model.Package{Name: "bbb", Fingerprint: "fingerprint-b", Release: release}
+	pkgC := &model.Package{Name: "ccc", Fingerprint: "fingerprint-c", Release: release}
+
+	compiledPackagesPath, err := ioutil.TempDir("", "fissile-analyze")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compiledPackagesPath)
+	for size, pkg := range []*model.Package{pkgA, pkgB, pkgC} {
+		compiledDir := pkg.GetPackageCompiledDir(compiledPackagesPath)
+		assert.NoError(os.MkdirAll(compiledDir, 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(compiledDir, "file"), make([]byte, (size+1)*100), 0644))
+	}
+
+	roles := model.Roles{
+		{Name: "one", Jobs: model.Jobs{{Packages: model.Packages{pkgA, pkgB}}}},
+		{Name: "two", Jobs: model.Jobs{{Packages: model.Packages{pkgB}}, {Packages: model.Packages{pkgA}}}},
+		{Name: "three", Jobs: model.Jobs{{Packages: model.Packages{pkgB, pkgC}}}},
+	}
+
+	report, err := analyzeRoleImages(roles, compiledPackagesPath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal([]*packageUsageReport{
+		{Release: "release", Name: "aaa", Fingerprint: "fingerprint-a", Size: 100, Roles: []string{"one", "two"}},
+		{Release: "release", Name: "bbb", Fingerprint: "fingerprint-b", Size: 200, Roles: []string{"one", "three", "two"}},
+		{Release: "release", Name: "ccc", Fingerprint: "fingerprint-c", Size: 300, Roles: []string{"three"}},
+	}, report.Packages)
+	assert.Equal([]*packagesLayerReport{
+		{Roles: []string{"one", "two"}, Packages: 2, Size: 300},
+		{Roles: []string{"three"}, Packages: 2, Size: 500},
+	}, report.Layers)
+	assert.Equal(int64(1100), report.PerRoleSize)
+	assert.Equal(int64(800), report.LayeredSize)
+	assert.Equal(int64(600), report.SharedSize)
+
+	assert.NoError(os.RemoveAll(pkgC.GetPackageCompiledDir(compiledPackagesPath)))
+	_, err = analyzeRoleImages(roles, compiledPackagesPath)
+	assert.EqualError(err, "Package release/ccc is not compiled, did you build the packages first?")
+}
+
+func TestSaveRoleImagesInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	releasePathCacheDir := filepath.Join(releasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+	targetPath := filepath.Join(workDir, "../test-assets/saved-images")
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, &bytes.Buffer{}, nil))
+
+	err = f.SaveRoleImages(roleManifestPath, "fissile", targetPath, SaveFormatDocker, nil, "human")
+	assert.EqualError(err, "Releases not loaded")
+
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.SaveRoleImages(roleManifestPath, "fissile", targetPath, "zip", nil, "human")
+	assert.EqualError(err, "Invalid image format 'zip', expected one of docker or oci")
+
+	err = f.SaveRoleImages(roleManifestPath, "fissile", targetPath, SaveFormatOCI, []string{"nosuchrole"}, "human")
+	assert.EqualError(err, "Some roles are unknown: [nosuchrole]")
+
+	_, err = os.Stat(targetPath)
+	assert.True(os.IsNotExist(err), "Nothing should be saved")
+}
+
+func TestRoleImageContent(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("package:abc", roleImageContent("/var/vcap/packages-src/abc/bin/ruby"))
+	assert.Equal("job:nats", roleImageContent("/var/vcap/jobs-src/nats/templates/ctl.erb"))
+	assert.Equal("", roleImageContent("/var/vcap/packages/ruby"), "Links to packages take no space")
+	assert.Equal("", roleImageContent("/var/vcap/jobs-src/nats"))
+	assert.Equal("", roleImageContent("/opt/var/vcap/jobs-src/nats/spec"))
+}
+
+func TestNewBuiltImageReport(t *testing.T) {
+	assert := assert.New(t)
+
+	release := &model.Release{Name: "cf"}
+	role := &model.Role{
+		Name: "myrole",
+		Jobs: model.Jobs{{
+			Name: "nats",
+			Packages: model.Packages{
+				{Name: "ruby", Fingerprint: "abc", Release: release},
+				{Name: "nats", Fingerprint: "def", Release: release},
+			},
+		}},
+	}
+	analysis := &docker.ImageAnalysis{
+		Layers:     []*docker.LayerReport{{CreatedBy: "ADD packages-src", Files: 3, Size: 60}},
+		Size:       60,
+		WastedSize: 10,
+		HiddenFiles: []*docker.HiddenFileReport{
+			{Path: "/tmp/a", Layers: []int{0}, WastedSize: 7},
+			{Path: "/tmp/b", Layers: []int{0}, WastedSize: 3},
+		},
+		Groups: map[string]int64{
+			"package:abc": 30,
+			"package:def": 10,
+			"package:ghi": 20,
+			"job:nats":    5,
+		},
+	}
+
+	report := newBuiltImageReport(role, "fissile-myrole:1234", analysis, 2)
+	assert.Equal("myrole", report.Role)
+	assert.Equal("fissile-myrole:1234", report.Image)
+	assert.Equal(int64(60), report.Size)
+	assert.Equal(int64(10), report.WastedSize)
+	assert.Equal(analysis.Layers, report.Layers)
+	assert.Equal(analysis.HiddenFiles, report.HiddenFiles)
+	assert.Equal([]*imageContentReport{{Name: "cf/ruby", Size: 30}, {Name: "ghi", Size: 20}}, report.Packages)
+	assert.Equal([]*imageContentReport{{Name: "nats", Size: 5}}, report.Jobs)
+
+	report = newBuiltImageReport(role, "fissile-myrole:1234", analysis, 1)
+	assert.Len(report.HiddenFiles, 1)
+	assert.Len(report.Packages, 1)
+}
+
+// fakeImageScanner reports the vulnerabilities of images from a map, by
+// image name prefix
+type fakeImageScanner struct {
+	vulnerabilities map[string][]*docker.Vulnerability
+	scanned         []string
+}
+
+func (s *fakeImageScanner) Name() string {
+	return "fake"
+}
+
+func (s *fakeImageScanner) ScanImage(imageName string) ([]*docker.Vulnerability, error) {
+	s.scanned = append(s.scanned, imageName)
+	for prefix, vulnerabilities := range s.vulnerabilities {
+		if strings.HasPrefix(imageName, prefix) {
+			return vulnerabilities, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestScanRoleImages(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+
+	reportDir, err := util.TempDir("", "fissile-scan-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(reportDir)
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	inherited := &docker.Vulnerability{ID: "CVE-1", Package: "bash", InstalledVersion: "4.3", Severity: "MEDIUM"}
+	added := &docker.Vulnerability{ID: "CVE-2", Package: "openssl", InstalledVersion: "1.0", FixedVersion: "1.1", Severity: "HIGH"}
+	scanner := &fakeImageScanner{vulnerabilities: map[string][]*docker.Vulnerability{
+		"fissile-role-base:": {inherited},
+		"fissile-myrole:":    {added, inherited},
+	}}
+
+	err = f.ScanRoleImages(roleManifestPath, "fissile", []string{"myrole"}, scanner, "critical", reportDir, "json")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(scanner.scanned, 2)
+
+	contents, err := ioutil.ReadFile(filepath.Join(reportDir, "myrole.json"))
+	if !assert.NoError(err) {
+		return
+	}
+	var report imageScanReport
+	if assert.NoError(json.Unmarshal(contents, &report)) {
+		assert.Equal("myrole", report.Role)
+		assert.Equal("fake", report.Scanner)
+		assert.Equal(builder.GetBaseImageName("fissile", "."), report.BaseImage)
+		assert.Equal([]*scannedVulnerability{
+			{Vulnerability: *added},
+			{Vulnerability: *inherited, InBaseImage: true},
+		}, report.Vulnerabilities)
+	}
+
+	err = f.ScanRoleImages(roleManifestPath, "fissile", []string{"myrole"}, scanner, "high", reportDir, "json")
+	if assert.Error(err) {
+		assert.Equal("1 role images have vulnerabilities of severity HIGH or above", err.Error())
+	}
+
+	err = f.ScanRoleImages(roleManifestPath, "fissile", []string{"myrole"}, scanner, "severe", reportDir, "json")
+	assert.Error(err)
+}
+
+func TestFormatSeverityCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("no vulnerabilities", formatSeverityCounts(nil))
+	assert.Equal("CRITICAL 1, MEDIUM 3, LOW 2", formatSeverityCounts(map[string]int{"LOW": 2, "CRITICAL": 1, "MEDIUM": 3}))
+}
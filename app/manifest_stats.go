@@ -0,0 +1,199 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// largestRolesCount is how many of the largest roles the statistics list
+const largestRolesCount = 5
+
+// manifestStatsReport summarizes the size and complexity of the role manifest
+type manifestStatsReport struct {
+	Roles        int            `json:"roles" yaml:"roles"`
+	RolesByType  map[string]int `json:"roles_by_type" yaml:"roles_by_type"`
+	RolesByStage map[string]int `json:"roles_by_stage" yaml:"roles_by_stage"`
+	// Number of roles by their number of jobs
+	JobsPerRole map[int]int `json:"jobs_per_role" yaml:"jobs_per_role"`
+	// Distinct jobs and packages used by the roles
+	Jobs     int `json:"jobs" yaml:"jobs"`
+	Packages int `json:"packages" yaml:"packages"`
+	// Templates of the distinct jobs, and properties templated by the role
+	// manifest
+	JobTemplates           int `json:"job_templates" yaml:"job_templates"`
+	ConfigurationTemplates int `json:"configuration_templates" yaml:"configuration_templates"`
+	// Configuration variables by kind: generated, secret or plain
+	VariablesByKind map[string]int    `json:"variables_by_kind" yaml:"variables_by_kind"`
+	LargestRoles    []*roleSizeReport `json:"largest_roles" yaml:"largest_roles"`
+}
+
+// roleSizeReport describes the size of a role, estimated from the archives
+// of its jobs and packages in the releases. Archives missing from the cache
+// of dev releases are counted as empty.
+type roleSizeReport struct {
+	Name            string `json:"name" yaml:"name"`
+	Jobs            int    `json:"jobs" yaml:"jobs"`
+	Packages        int    `json:"packages" yaml:"packages"`
+	Size            int64  `json:"size" yaml:"size"`
+	MissingArchives int    `json:"missing_archives,omitempty" yaml:"missing_archives,omitempty"`
+}
+
+// ShowStats reports statistics about the role manifest: its roles by type
+// and flight stage, the jobs, packages and templates they use, its
+// configuration variables by kind, and its largest roles
+func (f *Fissile) ShowStats(rolesManifestPath, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	rolesManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	report, err := collectManifestStats(rolesManifest)
+	if err != nil {
+		return err
+	}
+
+	return f.printReport(report, outputFormat, func() {
+		printCounts := func(title string, counts map[string]int) {
+			names := make([]string, 0, len(counts))
+			for name := range counts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			f.UI.Println(color.GreenString(title))
+			for _, name := range names {
+				f.UI.Printf("  %-16s %d\n", name, counts[name])
+			}
+		}
+
+		f.UI.Printf("%s %d\n", color.GreenString("Roles:"), report.Roles)
+		printCounts("Roles by type:", report.RolesByType)
+		printCounts("Roles by flight stage:", report.RolesByStage)
+
+		jobCounts := make([]int, 0, len(report.JobsPerRole))
+		for jobs := range report.JobsPerRole {
+			jobCounts = append(jobCounts, jobs)
+		}
+		sort.Ints(jobCounts)
+		f.UI.Println(color.GreenString("Roles by number of jobs:"))
+		for _, jobs := range jobCounts {
+			f.UI.Printf("  %-16d %d\n", jobs, report.JobsPerRole[jobs])
+		}
+
+		f.UI.Printf("%s %d\n", color.GreenString("Jobs:"), report.Jobs)
+		f.UI.Printf("%s %d\n", color.GreenString("Packages:"), report.Packages)
+		f.UI.Printf("%s %d\n", color.GreenString("Job templates:"), report.JobTemplates)
+		f.UI.Printf("%s %d\n", color.GreenString("Configuration templates:"), report.ConfigurationTemplates)
+		printCounts("Variables by kind:", report.VariablesByKind)
+
+		f.UI.Println(color.GreenString("Largest roles, from their release archives:"))
+		for _, role := range report.LargestRoles {
+			missing := ""
+			if role.MissingArchives > 0 {
+				missing = fmt.Sprintf(", %d archives missing", role.MissingArchives)
+			}
+			f.UI.Printf("  %-24s %s (%d jobs, %d packages%s)\n",
+				color.MagentaString(role.Name),
+				color.YellowString("%.2fMB", float64(role.Size)/(1024*1024)),
+				role.Jobs, role.Packages, missing)
+		}
+	})
+}
+
+// collectManifestStats computes the statistics of a role manifest
+func collectManifestStats(rolesManifest *model.RoleManifest) (*manifestStatsReport, error) {
+	report := &manifestStatsReport{
+		Roles:           len(rolesManifest.Roles),
+		RolesByType:     make(map[string]int),
+		RolesByStage:    make(map[string]int),
+		JobsPerRole:     make(map[int]int),
+		VariablesByKind: make(map[string]int),
+		LargestRoles:    []*roleSizeReport{},
+	}
+
+	// Archive sizes, by path; jobs and packages are shared between roles
+	sizes := make(map[string]int64)
+	addArchive := func(roleSize *roleSizeReport, path string) error {
+		size, ok := sizes[path]
+		if !ok {
+			info, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				size = -1
+			} else if err != nil {
+				return err
+			} else {
+				size = info.Size()
+			}
+			sizes[path] = size
+		}
+		if size < 0 {
+			roleSize.MissingArchives++
+		} else {
+			roleSize.Size += size
+		}
+		return nil
+	}
+
+	jobs := make(map[*model.Job]bool)
+	packages := make(map[string]bool)
+	var roleSizes []*roleSizeReport
+	for _, role := range rolesManifest.Roles {
+		report.RolesByType[string(role.Type)]++
+		if role.Run != nil && role.Run.FlightStage != "" {
+			report.RolesByStage[string(role.Run.FlightStage)]++
+		}
+		report.JobsPerRole[len(role.Jobs)]++
+
+		roleSize := &roleSizeReport{Name: role.Name, Jobs: len(role.Jobs)}
+		for _, job := range role.Jobs {
+			if !jobs[job] {
+				jobs[job] = true
+				report.JobTemplates += len(job.Templates)
+			}
+			if err := addArchive(roleSize, job.Path); err != nil {
+				return nil, fmt.Errorf("Error measuring job %s: %s", job.Name, err)
+			}
+		}
+
+		rolePackages := role.Jobs.Packages()
+		roleSize.Packages = len(rolePackages)
+		for _, pkg := range rolePackages {
+			packages[pkg.Fingerprint] = true
+			if err := addArchive(roleSize, pkg.Path); err != nil {
+				return nil, fmt.Errorf("Error measuring package %s: %s", pkg.Name, err)
+			}
+		}
+		roleSizes = append(roleSizes, roleSize)
+	}
+	report.Jobs = len(jobs)
+	report.Packages = len(packages)
+	report.ConfigurationTemplates = len(collectManifestProperties(rolesManifest))
+
+	for _, variable := range rolesManifest.Configuration.Variables {
+		switch {
+		case variable.Generator != nil:
+			report.VariablesByKind["generated"]++
+		case variable.Secret:
+			report.VariablesByKind["secret"]++
+		default:
+			report.VariablesByKind["plain"]++
+		}
+	}
+
+	sort.SliceStable(roleSizes, func(i, j int) bool { return roleSizes[i].Size > roleSizes[j].Size })
+	if len(roleSizes) > largestRolesCount {
+		roleSizes = roleSizes[:largestRolesCount]
+	}
+	report.LargestRoles = append(report.LargestRoles, roleSizes...)
+
+	return report, nil
+}
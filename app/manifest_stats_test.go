@@ -0,0 +1,55 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowStats(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-issues.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(f.ShowStats(roleManifestPath, "json")) {
+		return
+	}
+
+	var report manifestStatsReport
+	if assert.NoError(json.Unmarshal(buffer.Bytes(), &report)) {
+		assert.Equal(2, report.Roles)
+		assert.Equal(map[string]int{"bosh": 1, "bosh-task": 1}, report.RolesByType)
+		assert.Equal(map[int]int{1: 1, 2: 1}, report.JobsPerRole)
+		assert.Equal(2, report.Jobs)
+		assert.Equal(5, report.ConfigurationTemplates)
+		assert.Equal(map[string]int{"plain": 4}, report.VariablesByKind)
+		if assert.Len(report.LargestRoles, 2) {
+			assert.Equal("myrole", report.LargestRoles[0].Name)
+			assert.Equal(2, report.LargestRoles[0].Jobs)
+			assert.True(report.LargestRoles[0].Size > report.LargestRoles[1].Size)
+		}
+	}
+
+	buffer.Reset()
+	if assert.NoError(f.ShowStats(roleManifestPath, "human")) {
+		assert.Contains(buffer.String(), "Roles: 2")
+		assert.Contains(buffer.String(), "Largest roles")
+	}
+}
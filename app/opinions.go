@@ -0,0 +1,177 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// opinionsReport lists the opinions which have no effect on the roles of the
+// role manifest, so they can be removed or fixed
+type opinionsReport struct {
+	// Opinions with the same value as the default of their property in the
+	// specs of all the jobs declaring it
+	SpecDefaults []*opinionDefaultReport `json:"spec_defaults" yaml:"spec_defaults"`
+	// Dark opinions on properties without a template in the role manifest
+	UntemplatedDark []string `json:"untemplated_dark" yaml:"untemplated_dark"`
+	// Light opinions on properties no job of the roles declares
+	UnusedLight []string `json:"unused_light" yaml:"unused_light"`
+	// Dark opinions on properties no job of the roles declares
+	UnusedDark []string `json:"unused_dark" yaml:"unused_dark"`
+}
+
+// opinionDefaultReport describes an opinion duplicating the spec default of
+// its property
+type opinionDefaultReport struct {
+	Property string   `json:"property" yaml:"property"`
+	Opinions string   `json:"opinions" yaml:"opinions"` // light or dark
+	Value    string   `json:"value" yaml:"value"`
+	Jobs     []string `json:"jobs" yaml:"jobs"` // <release>/<job> declaring the default
+}
+
+// empty returns whether the report found no problems
+func (r *opinionsReport) empty() bool {
+	return len(r.SpecDefaults) == 0 && len(r.UntemplatedDark) == 0 && len(r.UnusedLight) == 0 &&
+		len(r.UnusedDark) == 0
+}
+
+// DiffOpinions reports the light and dark opinions which duplicate the spec
+// defaults of the jobs used by the roles of the role manifest, the dark
+// opinions without a template in the role manifest, and the light and dark
+// opinions no job of the roles declares a property for. Opinions on hash
+// properties are matched against the property holding them.
+func (f *Fissile) DiffOpinions(rolesManifestPath, lightManifestPath, darkManifestPath, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading opinions: %s", err.Error())
+	}
+
+	report := diffOpinions(roleManifest,
+		model.FlattenOpinions(opinions.Light),
+		model.FlattenOpinions(opinions.Dark))
+
+	return f.printReport(report, outputFormat, func() {
+		if report.empty() {
+			f.UI.Printf("%s: all opinions are in use\n", color.GreenString("OK"))
+			return
+		}
+
+		if len(report.SpecDefaults) > 0 {
+			f.UI.Println(color.YellowString("Opinions matching the spec defaults:"))
+			for _, entry := range report.SpecDefaults {
+				f.UI.Printf("- %s (%s opinion): %s, in %s\n",
+					color.CyanString(entry.Property),
+					entry.Opinions,
+					color.CyanString(entry.Value),
+					strings.Join(entry.Jobs, ", "))
+			}
+		}
+		if len(report.UntemplatedDark) > 0 {
+			f.UI.Println(color.YellowString("Dark opinions without a template in the role manifest:"))
+			for _, property := range report.UntemplatedDark {
+				f.UI.Printf("- %s\n", color.CyanString(property))
+			}
+		}
+		if len(report.UnusedLight) > 0 {
+			f.UI.Println(color.YellowString("Light opinions on properties no job declares:"))
+			for _, property := range report.UnusedLight {
+				f.UI.Printf("- %s\n", color.CyanString(property))
+			}
+		}
+		if len(report.UnusedDark) > 0 {
+			f.UI.Println(color.YellowString("Dark opinions on properties no job declares:"))
+			for _, property := range report.UnusedDark {
+				f.UI.Printf("- %s\n", color.CyanString(property))
+			}
+		}
+	})
+}
+
+// diffOpinions compares the flattened light and dark opinions with the role
+// manifest and the specs of the jobs of its roles
+func diffOpinions(roleManifest *model.RoleManifest, light, dark map[string]string) *opinionsReport {
+	pd := collectJobPropertyDefaults(collectRoleJobs(roleManifest.Roles))
+
+	report := &opinionsReport{
+		SpecDefaults:    []*opinionDefaultReport{},
+		UntemplatedDark: []string{},
+		UnusedLight:     []string{},
+		UnusedDark:      []string{},
+	}
+
+	for _, opinions := range []struct {
+		name   string
+		values map[string]string
+	}{{"light", light}, {"dark", dark}} {
+		for property, value := range opinions.values {
+			// Ignore specials (without the "properties." prefix)
+			if !strings.HasPrefix(property, "properties.") {
+				continue
+			}
+			p := strings.TrimPrefix(property, "properties.")
+
+			pInfo, ok := pd[p]
+			if !ok {
+				if checkParentsOfUndefined(p, pd) {
+					continue
+				}
+				if opinions.name == "light" {
+					report.UnusedLight = append(report.UnusedLight, property)
+				} else {
+					report.UnusedDark = append(report.UnusedDark, property)
+				}
+				continue
+			}
+
+			// Properties with differing defaults are left to validate
+			if len(pInfo.defaults) != 1 {
+				continue
+			}
+			if defaultJobs, ok := pInfo.defaults[value]; ok {
+				entry := &opinionDefaultReport{
+					Property: property,
+					Opinions: opinions.name,
+					Value:    value,
+					Jobs:     []string{},
+				}
+				for _, job := range defaultJobs {
+					entry.Jobs = append(entry.Jobs, fmt.Sprintf("%s/%s", job.Release.Name, job.Name))
+				}
+				sort.Strings(entry.Jobs)
+				report.SpecDefaults = append(report.SpecDefaults, entry)
+			}
+		}
+	}
+
+	templates := collectManifestProperties(roleManifest)
+	for property := range dark {
+		if _, ok := templates[property]; !ok {
+			report.UntemplatedDark = append(report.UntemplatedDark, property)
+		}
+	}
+
+	sort.Slice(report.SpecDefaults, func(i, j int) bool {
+		if report.SpecDefaults[i].Property != report.SpecDefaults[j].Property {
+			return report.SpecDefaults[i].Property < report.SpecDefaults[j].Property
+		}
+		return report.SpecDefaults[i].Opinions > report.SpecDefaults[j].Opinions
+	})
+	sort.Strings(report.UntemplatedDark)
+	sort.Strings(report.UnusedLight)
+	sort.Strings(report.UnusedDark)
+
+	return report
+}
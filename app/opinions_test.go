@@ -0,0 +1,70 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffOpinions(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-issues.yml")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(f.DiffOpinions(rolesManifestPath, lightManifestPath, darkManifestPath, "json")) {
+		return
+	}
+
+	var report opinionsReport
+	if assert.NoError(json.Unmarshal(buffer.Bytes(), &report)) {
+		assert.Equal([]*opinionDefaultReport{
+			{
+				Property: "properties.tor.hostname",
+				Opinions: "light",
+				Value:    "localhost",
+				Jobs:     []string{"tor/tor"},
+			},
+		}, report.SpecDefaults)
+		assert.Equal([]string{
+			"properties.tor.dark-opinion",
+			"properties.tor.masked_opinion",
+		}, report.UntemplatedDark)
+		assert.Equal([]string{
+			"properties.tor.bogus",
+			"properties.tor.int_opinion",
+			"properties.tor.masked_opinion",
+			"properties.tor.opinion",
+		}, report.UnusedLight)
+		assert.Equal([]string{
+			"properties.tor.dark-opinion",
+			"properties.tor.masked_opinion",
+		}, report.UnusedDark)
+	}
+
+	buffer.Reset()
+	goodLightPath := filepath.Join(workDir, "../test-assets/test-opinions/good-opinions.yml")
+	goodDarkPath := filepath.Join(workDir, "../test-assets/test-opinions/good-dark-opinions.yml")
+	goodManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-ok.yml")
+	if assert.NoError(f.DiffOpinions(goodManifestPath, goodLightPath, goodDarkPath, "human")) {
+		assert.Contains(buffer.String(), "all opinions are in use")
+	}
+}
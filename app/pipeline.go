@@ -0,0 +1,230 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/secrets"
+
+	"github.com/fatih/color"
+)
+
+// PipelineSettings holds the configuration for a complete build pipeline run
+type PipelineSettings struct {
+	BaseImage           string   `json:"base_image"`             // Docker image the compilation and stemcell layers are built from, or images by platform
+	Repository          string   `json:"repository"`             // Repository name prefix used to create image names
+	MetricsPath         string   `json:"metrics"`                // Path to a CSV file to store timing metrics into
+	RoleManifestPath    string   `json:"role_manifest"`          // Path to the role manifest
+	LightOpinionsPath   string   `json:"light_opinions"`         // Path to the light opinions
+	DarkOpinionsPath    string   `json:"dark_opinions"`          // Path to the dark opinions
+	CompilationDir      string   `json:"compilation_dir"`        // Directory holding the compiled packages
+	BaseDockerfileDir   string   `json:"base_dockerfile_dir"`    // Directory for the stemcell layer assets
+	DockerDir           string   `json:"docker_dir"`             // Directory for the role image assets
+	RoleNames           []string `json:"roles"`                  // Roles to build; all roles if empty
+	WorkerCount         int      `json:"workers"`                // Number of parallel workers
+	Force               bool     `json:"force"`                  // Build role images even if they exist already
+	KubeOutputDir       string   `json:"kube_output_dir"`        // Directory for Kubernetes configs; skipped if empty
+	KubeDefaultEnvFiles []string `json:"kube_defaults_files"`    // Env files with defaults for the Kubernetes configs
+	KubeRegistry        string   `json:"kube_registry"`          // Docker registry used in the Kubernetes configs
+	KubeOrganization    string   `json:"kube_organization"`      // Docker organization used in the Kubernetes configs
+	KubeUseMemoryLimits bool     `json:"kube_use_memory_limits"` // Include memory limits in the Kubernetes configs
+	KubeConfigProvider  string   `json:"kube_config_provider"`   // How configuration values are passed to the pods (env, envfiles, k8s or vault)
+	KubeVaultPath       string   `json:"kube_vault_path"`        // Vault KV path for secrets, with the vault provider
+	ValuesFile          string   `json:"values_file"`            // Env file for generated values; skipped if empty
+	ValuesNamespace     string   `json:"values_namespace"`       // Kubernetes namespace used in generated certificates
+
+	Platforms []docker.Platform `json:"platforms"` // Platforms packages and images are built for; the default one if empty
+}
+
+// pipelineStep is a single stage of the build pipeline
+type pipelineStep struct {
+	name string
+	run  func() error
+}
+
+// pipelineResult records the outcome of a single pipeline stage
+type pipelineResult struct {
+	name     string
+	status   string
+	duration time.Duration
+}
+
+// BuildPipeline runs all the steps needed to go from BOSH releases to
+// images and deployment configuration in the correct order: validation,
+// compilation layer, package compilation, stemcell layer, role images, and
+// (optionally) generated values and Kubernetes configuration. Every step
+// reuses the caches of the previous ones, so only missing artifacts get
+// built. A summary of all steps is printed at the end, regardless of success.
+func (f *Fissile) BuildPipeline(settings *PipelineSettings) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	results, err := f.runPipeline(f.pipelineSteps(settings))
+	f.reportPipeline(results)
+
+	return err
+}
+
+// pipelineSteps returns the steps of a pipeline run with the given settings
+func (f *Fissile) pipelineSteps(settings *PipelineSettings) []pipelineStep {
+	steps := []pipelineStep{
+		{"validate", func() error {
+			return f.validatePipelineInputs(settings)
+		}},
+	}
+
+	platforms := settings.Platforms
+	if len(platforms) == 0 {
+		platforms = []docker.Platform{docker.DefaultPlatform}
+	}
+	for _, platform := range platforms {
+		steps = append(steps, f.platformPipelineSteps(settings, platform, len(platforms) > 1)...)
+	}
+
+	kubeDefaultEnvFiles := settings.KubeDefaultEnvFiles
+	if settings.ValuesFile != "" {
+		// The generated values are defaults for the Kubernetes configuration
+		kubeDefaultEnvFiles = append(append([]string{}, kubeDefaultEnvFiles...), settings.ValuesFile)
+		steps = append(steps, pipelineStep{"values", func() error {
+			return f.GenerateValues(settings.RoleManifestPath, settings.ValuesFile, settings.KubeDefaultEnvFiles,
+				&secrets.Settings{Namespace: settings.ValuesNamespace})
+		}})
+	}
+
+	if settings.KubeOutputDir != "" {
+		steps = append(steps, pipelineStep{"kube", func() error {
+			return f.GenerateKube(settings.RoleManifestPath, settings.KubeOutputDir, settings.Repository,
+				settings.KubeRegistry, settings.KubeOrganization, kubeDefaultEnvFiles,
+				settings.KubeUseMemoryLimits, settings.KubeConfigProvider, settings.KubeVaultPath)
+		}})
+	}
+
+	return steps
+}
+
+// platformPipelineSteps returns the steps building the layers, packages and
+// images of a platform. With several platforms, the steps are named after it.
+func (f *Fissile) platformPipelineSteps(settings *PipelineSettings, platform docker.Platform, named bool) []pipelineStep {
+	name := func(step string) string {
+		if named {
+			return fmt.Sprintf("%s (%s)", step, platform)
+		}
+		return step
+	}
+	repository := platform.Repository(settings.Repository)
+	compilationDir := platform.Dir(settings.CompilationDir)
+
+	return []pipelineStep{
+		{name("compilation layer"), func() error {
+			baseImage, err := docker.ImageForPlatform(settings.BaseImage, platform)
+			if err != nil {
+				return err
+			}
+			return f.CreateBaseCompilationImage(baseImage, repository, settings.MetricsPath, false)
+		}},
+		{name("packages"), func() error {
+			f.SetPlatform(platform)
+			return f.Compile(repository, compilationDir, settings.RoleManifestPath,
+				settings.MetricsPath, settings.RoleNames, settings.WorkerCount, false, false)
+		}},
+		{name("stemcell layer"), func() error {
+			baseImage, err := docker.ImageForPlatform(settings.BaseImage, platform)
+			if err != nil {
+				return err
+			}
+			return f.GenerateBaseDockerImage(platform.Dir(settings.BaseDockerfileDir), baseImage,
+				settings.MetricsPath, false, repository)
+		}},
+		{name("images"), func() error {
+			return f.GenerateRoleImages(platform.Dir(settings.DockerDir), repository, settings.MetricsPath,
+				false, settings.Force, settings.RoleNames, settings.WorkerCount, settings.RoleManifestPath,
+				compilationDir, settings.LightOpinionsPath, settings.DarkOpinionsPath, "")
+		}},
+	}
+}
+
+// validatePipelineInputs checks the role manifest and opinions before
+// anything expensive happens.
+func (f *Fissile) validatePipelineInputs(settings *PipelineSettings) error {
+	roleManifest, err := model.LoadRoleManifest(settings.RoleManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	roles, err := roleManifest.SelectRoles(settings.RoleNames)
+	if err != nil {
+		return err
+	}
+
+	if err := validateJobTemplates(roles); err != nil {
+		return err
+	}
+
+	opinions, err := model.NewOpinions(settings.LightOpinionsPath, settings.DarkOpinionsPath)
+	if err != nil {
+		return err
+	}
+
+	if errs := f.validateManifestAndOpinions(roleManifest, opinions); len(errs) != 0 {
+		return fmt.Errorf("%s", errs.Errors())
+	}
+
+	return nil
+}
+
+// runPipeline executes the steps in order, stopping at the first
+// failure. All steps after a failure are reported as skipped.
+func (f *Fissile) runPipeline(steps []pipelineStep) ([]pipelineResult, error) {
+	results := make([]pipelineResult, 0, len(steps))
+
+	var err error
+	for _, step := range steps {
+		if err != nil {
+			results = append(results, pipelineResult{name: step.name, status: "skipped"})
+			continue
+		}
+
+		f.logger(logBuild).Info(color.GreenString("==> %s", color.YellowString(step.name)))
+
+		start := time.Now()
+		err = step.run()
+		result := pipelineResult{
+			name:     step.name,
+			status:   "done",
+			duration: time.Since(start),
+		}
+		if err != nil {
+			result.status = "failed"
+			err = fmt.Errorf("Pipeline step %s failed: %s", step.name, err)
+		}
+		results = append(results, result)
+	}
+
+	return results, err
+}
+
+// reportPipeline prints the consolidated summary of a pipeline run
+func (f *Fissile) reportPipeline(results []pipelineResult) {
+	f.UI.Println(color.GreenString("\nBuild summary:"))
+
+	var total time.Duration
+	for _, result := range results {
+		var status string
+		switch result.status {
+		case "done":
+			status = color.GreenString("%-8s", result.status)
+		case "failed":
+			status = color.RedString("%-8s", result.status)
+		default:
+			status = color.YellowString("%-8s", result.status)
+		}
+
+		f.UI.Printf("  %-20s %s %s\n", result.name, status, result.duration-result.duration%time.Millisecond)
+		total += result.duration
+	}
+
+	f.UI.Printf("  %-20s %-8s %s\n", "total", "", total-total%time.Millisecond)
+}
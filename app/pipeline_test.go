@@ -0,0 +1,151 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/docker"
+
+	"github.com/hpcloud/termui"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPipelineStopsAtFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	f := NewFissileApplication(".", ui)
+
+	var ran []string
+	steps := []pipelineStep{
+		{"one", func() error { ran = append(ran, "one"); return nil }},
+		{"two", func() error { ran = append(ran, "two"); return fmt.Errorf("broken") }},
+		{"three", func() error { ran = append(ran, "three"); return nil }},
+	}
+
+	results, err := f.runPipeline(steps)
+	assert.EqualError(err, "Pipeline step two failed: broken")
+	assert.Equal([]string{"one", "two"}, ran)
+
+	if assert.Len(results, 3) {
+		assert.Equal("done", results[0].status)
+		assert.Equal("failed", results[1].status)
+		assert.Equal("skipped", results[2].status)
+	}
+
+	f.reportPipeline(results)
+	assert.Contains(output.String(), "Build summary:")
+	assert.Contains(output.String(), "three")
+}
+
+func TestBuildPipelineValidationFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	f := NewFissileApplication(".", ui)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.BuildPipeline(&PipelineSettings{
+		RoleManifestPath:  filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-issues.yml"),
+		LightOpinionsPath: filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml"),
+		DarkOpinionsPath:  filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml"),
+		KubeOutputDir:     filepath.Join(workDir, "kube-output"),
+	})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Pipeline step validate failed")
+	}
+
+	_, err = os.Stat(filepath.Join(workDir, "kube-output"))
+	assert.True(os.IsNotExist(err), "Kubernetes output should not be written after a failed validation")
+
+	assert.Contains(output.String(), "skipped")
+	assert.Contains(output.String(), "kube")
+}
+
+func TestBuildPipelineReleasesNotLoaded(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, &bytes.Buffer{}, nil)
+	f := NewFissileApplication(".", ui)
+
+	err := f.BuildPipeline(&PipelineSettings{})
+	assert.EqualError(t, err, "Releases not loaded")
+}
+
+func TestBuildPipelineValues(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	f := NewFissileApplication(".", ui)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(outputDir)
+
+	steps := f.pipelineSteps(&PipelineSettings{
+		RoleManifestPath: filepath.Join(workDir, "../test-assets/role-manifests/generators.yml"),
+		KubeOutputDir:    filepath.Join(outputDir, "kube"),
+		ValuesFile:       filepath.Join(outputDir, "values.env"),
+	})
+
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	if !assert.Equal([]string{"validate", "compilation layer", "packages", "stemcell layer", "images", "values", "kube"}, names) {
+		return
+	}
+
+	// Only run the steps not needing docker; the values are there for kube
+	_, err = f.runPipeline(steps[len(steps)-2:])
+	assert.NoError(err)
+
+	values, err := godotenv.Read(filepath.Join(outputDir, "values.env"))
+	if assert.NoError(err) {
+		assert.NotEmpty(values["PASSWORD"])
+	}
+	_, err = os.Stat(filepath.Join(outputDir, "kube"))
+	assert.NoError(err)
+
+	steps = f.pipelineSteps(&PipelineSettings{})
+	assert.Len(steps, 5, "Values and kube steps should be skipped without output locations")
+
+	steps = f.pipelineSteps(&PipelineSettings{
+		Platforms: []docker.Platform{docker.DefaultPlatform, {OS: "linux", Architecture: "arm64"}},
+	})
+	names = nil
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	assert.Equal([]string{
+		"validate",
+		"compilation layer (linux/amd64)", "packages (linux/amd64)", "stemcell layer (linux/amd64)", "images (linux/amd64)",
+		"compilation layer (linux/arm64)", "packages (linux/arm64)", "stemcell layer (linux/arm64)", "images (linux/arm64)",
+	}, names)
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// portReport describes a port exposed by a role, and its conflicts with
+// other exposed ports
+type portReport struct {
+	Role       string   `json:"role" yaml:"role"`
+	Name       string   `json:"name" yaml:"name"`
+	Protocol   string   `json:"protocol" yaml:"protocol"`
+	Internal   string   `json:"internal" yaml:"internal"`
+	External   string   `json:"external" yaml:"external"`
+	Public     bool     `json:"public" yaml:"public"`
+	Collisions []string `json:"collisions,omitempty" yaml:"collisions,omitempty"`
+	Overlaps   []string `json:"overlaps,omitempty" yaml:"overlaps,omitempty"`
+
+	minPort, maxPort int
+}
+
+// ShowPorts reports all ports exposed by the roles of the role manifest.
+// External ports of the same protocol collide when their ranges overlap
+// within a role, or across public ports of different roles (which share
+// the external addresses). Overlaps between other roles are reported too,
+// as they matter once services are exposed together.
+func (f *Fissile) ShowPorts(roleManifestPath, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := f.loadRoleManifest(roleManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	ports, err := collectPorts(roleManifest)
+	if err != nil {
+		return err
+	}
+
+	return f.printReport(ports, outputFormat, func() { f.listPortsForHuman(ports) })
+}
+
+// collectPorts lists the exposed ports of all roles, with their conflicts
+func collectPorts(roleManifest *model.RoleManifest) ([]*portReport, error) {
+	ports := []*portReport{}
+
+	for _, role := range roleManifest.Roles {
+		for _, port := range role.Run.ExposedPorts {
+			protocol := strings.ToUpper(port.Protocol)
+			if protocol == "" {
+				protocol = "TCP"
+			}
+
+			// Without an external port, the internal one is exposed
+			external := port.External
+			if external == "" {
+				external = port.Internal
+			}
+
+			minPort, maxPort, err := parsePortRange(external)
+			if err != nil {
+				return nil, fmt.Errorf("Role %s has invalid external port %s: %s", role.Name, port.Name, err)
+			}
+
+			ports = append(ports, &portReport{
+				Role:     role.Name,
+				Name:     port.Name,
+				Protocol: protocol,
+				Internal: port.Internal,
+				External: external,
+				Public:   port.Public,
+				minPort:  minPort,
+				maxPort:  maxPort,
+			})
+		}
+	}
+
+	for i, port := range ports {
+		for _, other := range ports[i+1:] {
+			if port.Protocol != other.Protocol || port.maxPort < other.minPort || other.maxPort < port.minPort {
+				continue
+			}
+
+			if port.Role == other.Role || (port.Public && other.Public) {
+				port.Collisions = append(port.Collisions, other.id())
+				other.Collisions = append(other.Collisions, port.id())
+			} else {
+				port.Overlaps = append(port.Overlaps, other.id())
+				other.Overlaps = append(other.Overlaps, port.id())
+			}
+		}
+	}
+
+	return ports, nil
+}
+
+func (f *Fissile) listPortsForHuman(ports []*portReport) {
+	f.UI.Printf("%-24s %-16s %-8s %-12s %-12s %-6s %s\n",
+		"ROLE", "NAME", "PROTOCOL", "INTERNAL", "EXTERNAL", "PUBLIC", "CONFLICTS")
+
+	for _, port := range ports {
+		var conflicts []string
+		for _, collision := range port.Collisions {
+			conflicts = append(conflicts, color.RedString("collides with %s", collision))
+		}
+		for _, overlap := range port.Overlaps {
+			conflicts = append(conflicts, color.YellowString("overlaps %s", overlap))
+		}
+
+		f.UI.Printf("%-24s %-16s %-8s %-12s %-12s %-6t %s\n",
+			port.Role, port.Name, port.Protocol, port.Internal, port.External, port.Public,
+			strings.Join(conflicts, ", "))
+	}
+}
+
+// id identifies a port in conflict reports
+func (p *portReport) id() string {
+	return fmt.Sprintf("%s/%s", p.Role, p.Name)
+}
+
+// parsePortRange parses a port (`80`) or port range (`8000-8010`)
+func parsePortRange(portRange string) (int, int, error) {
+	parts := strings.SplitN(portRange, "-", 2)
+
+	minPort, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return minPort, minPort, nil
+	}
+
+	maxPort, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if minPort > maxPort {
+		return 0, 0, fmt.Errorf("Port range %s ends before it starts", portRange)
+	}
+	return minPort, maxPort, nil
+}
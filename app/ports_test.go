@@ -0,0 +1,88 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowPorts(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/ports.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.ShowPorts(roleManifestPath, "json")
+	if !assert.NoError(err) {
+		return
+	}
+
+	var ports []*portReport
+	if !assert.NoError(json.Unmarshal(buffer.Bytes(), &ports)) || !assert.Len(ports, 5) {
+		return
+	}
+
+	byID := make(map[string]*portReport)
+	for _, port := range ports {
+		byID[port.id()] = port
+	}
+
+	assert.Equal(&portReport{
+		Role:       "myrole",
+		Name:       "http",
+		Protocol:   "TCP",
+		Internal:   "8080",
+		External:   "80",
+		Public:     true,
+		Collisions: []string{"otherrole/web"},
+	}, byID["myrole/http"])
+	assert.Equal([]string{"myrole/http"}, byID["otherrole/web"].Collisions)
+	assert.Equal([]string{"otherrole/metrics"}, byID["myrole/ssh-range"].Overlaps)
+	assert.Equal([]string{"myrole/ssh-range"}, byID["otherrole/metrics"].Overlaps)
+	assert.Empty(byID["otherrole/dns"].Collisions, "Ports of different protocols don't conflict")
+	assert.Empty(byID["otherrole/dns"].Overlaps, "Ports of different protocols don't conflict")
+
+	buffer.Reset()
+	assert.NoError(f.ShowPorts(roleManifestPath, "human"))
+	assert.Contains(buffer.String(), "collides with otherrole/web")
+
+	assert.EqualError(f.ShowPorts(roleManifestPath, "xml"), "Invalid output format 'xml', expected one of human, json, or yaml")
+}
+
+func TestCollectPorts(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := &model.RoleManifest{Roles: model.Roles{
+		{Name: "myrole", Run: &model.RoleRun{ExposedPorts: []*model.RoleRunExposedPort{
+			{Name: "http", Internal: "8080"},
+			{Name: "alt", Internal: "80", External: "8080"},
+		}}},
+	}}
+
+	ports, err := collectPorts(roleManifest)
+	if assert.NoError(err) && assert.Len(ports, 2) {
+		assert.Equal("8080", ports[0].External, "The external port should default to the internal one")
+		assert.Equal([]string{"myrole/alt"}, ports[0].Collisions)
+	}
+
+	roleManifest.Roles[0].Run.ExposedPorts[1].External = "9000-8000"
+	_, err = collectPorts(roleManifest)
+	assert.EqualError(err, "Role myrole has invalid external port alt: Port range 9000-8000 ends before it starts")
+}
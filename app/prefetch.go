@@ -0,0 +1,149 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/compilator"
+	"github.com/hpcloud/fissile/docker"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+)
+
+// imagePrefetch is a pull of images started in the background, before the
+// images are needed
+type imagePrefetch struct {
+	done chan struct{}
+	err  error
+}
+
+// PrefetchImages starts pulling those of the images which don't exist
+// locally in the background, within the transfer limits, so they are ready
+// by the time they are needed. Images with a digest are verified once
+// pulled. Failures are only reported when the images are needed, and the
+// pulls are attempted again then.
+func (f *Fissile) PrefetchImages(imageNames ...string) {
+	f.prefetchLock.Lock()
+	defer f.prefetchLock.Unlock()
+
+	if f.prefetches == nil {
+		f.prefetches = make(map[string]*imagePrefetch)
+	}
+
+	var names []string
+	for _, imageName := range imageNames {
+		if _, ok := f.prefetches[imageName]; !ok && imageName != "" {
+			names = append(names, imageName)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	prefetch := &imagePrefetch{done: make(chan struct{})}
+	for _, imageName := range names {
+		f.prefetches[imageName] = prefetch
+	}
+
+	go func() {
+		defer close(prefetch.done)
+		prefetch.err = f.prefetchImages(names)
+	}()
+}
+
+// PrefetchBaseImages starts pulling the images layers and role images are
+// built from in the background, see PrefetchImages: the base image of the
+// compilation and stemcell layers, unless both layers exist, and the base
+// images of the roles of the role manifest. The role manifest is read without
+// the releases; problems with it are reported when it is loaded.
+func (f *Fissile) PrefetchBaseImages(repository, baseImage, rolesManifestPath string) {
+	var imageNames []string
+
+	if baseImage != "" {
+		layersExist := false
+		if dockerManager, err := docker.NewImageManager(); err == nil {
+			compilationExists, _ := dockerManager.HasImage(compilator.GetBaseImageName(repository, f.Version))
+			stemcellExists, _ := dockerManager.HasImage(builder.GetBaseImageName(repository, f.Version))
+			layersExist = compilationExists && stemcellExists
+		}
+		if !layersExist {
+			imageNames = append(imageNames, baseImage)
+		}
+	}
+
+	if rolesManifestPath != "" {
+		imageNames = append(imageNames, roleBaseImages(rolesManifestPath)...)
+	}
+
+	f.PrefetchImages(imageNames...)
+}
+
+// prefetchImages pulls those of the images which don't exist locally
+func (f *Fissile) prefetchImages(imageNames []string) error {
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	var missing []string
+	for _, imageName := range imageNames {
+		if hasImage, err := dockerManager.HasImage(imageName); err != nil {
+			return err
+		} else if !hasImage {
+			missing = append(missing, imageName)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	f.logger(logDocker).Infof("Prefetching %s", color.YellowString(strings.Join(missing, ", ")))
+	return dockerManager.PullImages(missing, f.transferLimits, f.progressUI(logDocker))
+}
+
+// waitForPrefetches waits for the prefetches of the images to end. Failed
+// prefetches are only warned about, the images are pulled again.
+func (f *Fissile) waitForPrefetches(imageNames []string) {
+	for _, imageName := range imageNames {
+		f.prefetchLock.Lock()
+		prefetch := f.prefetches[imageName]
+		f.prefetchLock.Unlock()
+		if prefetch == nil {
+			continue
+		}
+
+		<-prefetch.done
+		if prefetch.err != nil {
+			f.logger(logDocker).Warnf("Prefetching %s failed: %s", imageName, prefetch.err)
+		}
+	}
+}
+
+// roleBaseImages returns the base images of the roles of the role manifest,
+// reading only those; none if the role manifest can't be read
+func roleBaseImages(rolesManifestPath string) []string {
+	contents, err := ioutil.ReadFile(rolesManifestPath)
+	if err != nil {
+		return nil
+	}
+
+	var manifest struct {
+		Roles []struct {
+			BaseImage string `yaml:"base-image"`
+		} `yaml:"roles"`
+	}
+	if err := yaml.Unmarshal(contents, &manifest); err != nil {
+		return nil
+	}
+
+	var imageNames []string
+	for _, role := range manifest.Roles {
+		if role.BaseImage != "" {
+			imageNames = append(imageNames, role.BaseImage)
+		}
+	}
+	return imageNames
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleBaseImages(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/base-image.yml")
+	assert.Equal([]string{"registry.example.com/stemcells/fissile-role-base-centos:1.0"}, roleBaseImages(rolesManifestPath))
+
+	assert.Empty(roleBaseImages(filepath.Join(workDir, "../test-assets/role-manifests/missing.yml")))
+}
+
+func TestWaitForPrefetches(t *testing.T) {
+	assert := assert.New(t)
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+
+	// Images already being prefetched are not prefetched again
+	prefetch := &imagePrefetch{done: make(chan struct{})}
+	f.prefetches = map[string]*imagePrefetch{"ubuntu:14.04": prefetch}
+	f.PrefetchImages("ubuntu:14.04", "")
+	assert.Len(f.prefetches, 1)
+
+	go func() {
+		prefetch.err = fmt.Errorf("registry unreachable")
+		close(prefetch.done)
+	}()
+	f.waitForPrefetches([]string{"ubuntu:14.04", "ubuntu:16.04"})
+	assert.Contains(output.String(), "Prefetching ubuntu:14.04 failed: registry unreachable")
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// Sources of the value of a property in a role, by precedence: templates of
+// the role manifest override the light opinions, which override the spec
+// defaults. Dark opinions drop the light opinions they match.
+const (
+	propertySourceTemplate     = "template"
+	propertySourceLightOpinion = "light opinion"
+	propertySourceSpecDefault  = "spec default"
+)
+
+// propertySourcesReport describes where the value of a job property comes
+// from in each role whose jobs declare it
+type propertySourcesReport struct {
+	Property      string                       `json:"property" yaml:"property"`
+	Declarations  []*propertyDeclarationReport `json:"declarations" yaml:"declarations"`
+	LightOpinions map[string]string            `json:"light_opinions" yaml:"light_opinions"` // By opinion key; those of a hash are below it
+	DarkOpinions  []string                     `json:"dark_opinions" yaml:"dark_opinions"`   // Opinion keys only, their values are secret
+	Templates     []*propertyTemplateReport    `json:"templates" yaml:"templates"`
+	Roles         map[string]string            `json:"roles" yaml:"roles"` // Source of the value, by role
+}
+
+// propertyDeclarationReport describes a job declaring a property
+type propertyDeclarationReport struct {
+	Job     string      `json:"job" yaml:"job"` // <release>/<job>
+	Default interface{} `json:"default" yaml:"default"`
+	Roles   []string    `json:"roles" yaml:"roles"`
+}
+
+// propertyTemplateReport describes a template of the role manifest for a
+// property, or for the hash holding it. Global templates are named by their
+// property, templates of a role replacing or adding to them as
+// <role>/<property>.
+type propertyTemplateReport struct {
+	Name      string   `json:"name" yaml:"name"`
+	Template  string   `json:"template" yaml:"template"`
+	Variables []string `json:"variables" yaml:"variables"`
+	Roles     []string `json:"roles" yaml:"roles"`
+}
+
+// ShowPropertySources reports, for the given job properties or all the
+// properties of the jobs of the roles, which jobs declare them with which
+// defaults, which light and dark opinions and which templates of the role
+// manifest apply to them, and where their value comes from in each role.
+func (f *Fissile) ShowPropertySources(rolesManifestPath, lightManifestPath, darkManifestPath string, properties []string, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading opinions: %s", err.Error())
+	}
+
+	reports, err := collectPropertySources(roleManifest,
+		model.FlattenOpinions(opinions.Light),
+		model.FlattenOpinions(opinions.Dark),
+		properties)
+	if err != nil {
+		return err
+	}
+
+	return f.printReport(reports, outputFormat, func() {
+		for _, report := range reports {
+			f.UI.Printf("%s:\n", color.GreenString(report.Property))
+			f.UI.Println("  declared by:")
+			for _, declaration := range report.Declarations {
+				f.UI.Printf("  - %s (default: %v), in %s\n",
+					color.CyanString(declaration.Job),
+					declaration.Default,
+					strings.Join(declaration.Roles, ", "))
+			}
+			for _, key := range sortedKeys(report.LightOpinions) {
+				f.UI.Printf("  light opinion: %s: %s\n", key, color.YellowString(report.LightOpinions[key]))
+			}
+			for _, key := range report.DarkOpinions {
+				f.UI.Printf("  dark opinion: %s\n", key)
+			}
+			for _, template := range report.Templates {
+				f.UI.Printf("  template %s: %s, in %s\n",
+					color.CyanString(template.Name),
+					color.YellowString(template.Template),
+					strings.Join(template.Roles, ", "))
+			}
+			f.UI.Println("  value from:")
+			for _, role := range sortedKeys(report.Roles) {
+				f.UI.Printf("  - %s: %s\n", role, report.Roles[role])
+			}
+		}
+	})
+}
+
+// collectPropertySources describes the sources of the given properties, or
+// of all properties of the jobs of the roles. The properties may be given
+// with or without the "properties." prefix.
+func collectPropertySources(roleManifest *model.RoleManifest, light, dark map[string]string, properties []string) ([]*propertySourcesReport, error) {
+	reports := make(map[string]*propertySourcesReport)
+	declarations := make(map[string]map[*model.Job]*propertyDeclarationReport)
+	templates := make(map[string]map[string]*propertyTemplateReport)
+	globalTemplates := roleManifest.Configuration.Templates
+	usage := roleManifest.VariableUsage()
+
+	for _, role := range roleManifest.Roles {
+		for _, job := range role.Jobs {
+			for _, property := range job.Properties {
+				report, ok := reports[property.Name]
+				if !ok {
+					report = &propertySourcesReport{
+						Property:      property.Name,
+						Declarations:  []*propertyDeclarationReport{},
+						LightOpinions: make(map[string]string),
+						DarkOpinions:  []string{},
+						Templates:     []*propertyTemplateReport{},
+						Roles:         make(map[string]string),
+					}
+					reports[property.Name] = report
+					declarations[property.Name] = make(map[*model.Job]*propertyDeclarationReport)
+					templates[property.Name] = make(map[string]*propertyTemplateReport)
+				}
+
+				declaration, ok := declarations[property.Name][job]
+				if !ok {
+					declaration = &propertyDeclarationReport{
+						Job:     fmt.Sprintf("%s/%s", job.Release.Name, job.Name),
+						Default: stringKeys(property.Default),
+					}
+					declarations[property.Name][job] = declaration
+					report.Declarations = append(report.Declarations, declaration)
+				}
+				declaration.Roles = appendUnique(declaration.Roles, role.Name)
+
+				// The template of the property wins over those of the hashes
+				// holding it
+				for name := property.Name; name != ""; name = parentProperty(name) {
+					key := "properties." + name
+					template, ok := role.Configuration.Templates[key]
+					if !ok {
+						continue
+					}
+					templateName := key
+					if global, ok := globalTemplates[key]; !ok || global != template {
+						templateName = fmt.Sprintf("%s/%s", role.Name, key)
+					}
+					templateReport, ok := templates[property.Name][templateName]
+					if !ok {
+						// Templates of hashes no job declares aren't indexed
+						variables, ok := usage.Templates[templateName]
+						if !ok {
+							variables = []string{}
+						}
+						templateReport = &propertyTemplateReport{
+							Name:      templateName,
+							Template:  template,
+							Variables: variables,
+						}
+						templates[property.Name][templateName] = templateReport
+						report.Templates = append(report.Templates, templateReport)
+					}
+					templateReport.Roles = appendUnique(templateReport.Roles, role.Name)
+					report.Roles[role.Name] = fmt.Sprintf("%s %s", propertySourceTemplate, templateName)
+					break
+				}
+			}
+		}
+	}
+
+	for name, report := range reports {
+		prefix := "properties." + name
+		for key, value := range light {
+			if key == prefix || strings.HasPrefix(key, prefix+".") {
+				report.LightOpinions[key] = value
+			}
+		}
+		for key := range dark {
+			if key == prefix || strings.HasPrefix(key, prefix+".") {
+				report.DarkOpinions = append(report.DarkOpinions, key)
+			}
+		}
+		sort.Strings(report.DarkOpinions)
+
+		source := propertySourceSpecDefault
+		if _, isDark := dark[prefix]; !isDark && len(report.LightOpinions) > 0 {
+			source = propertySourceLightOpinion
+		}
+		for _, declaration := range report.Declarations {
+			for _, role := range declaration.Roles {
+				if _, ok := report.Roles[role]; !ok {
+					report.Roles[role] = source
+				}
+			}
+			sort.Strings(declaration.Roles)
+		}
+		sort.Slice(report.Declarations, func(i, j int) bool {
+			return report.Declarations[i].Job < report.Declarations[j].Job
+		})
+		for _, template := range report.Templates {
+			sort.Strings(template.Roles)
+		}
+		sort.Slice(report.Templates, func(i, j int) bool {
+			return report.Templates[i].Name < report.Templates[j].Name
+		})
+	}
+
+	if len(properties) == 0 {
+		for name := range reports {
+			properties = append(properties, name)
+		}
+		sort.Strings(properties)
+	}
+
+	result := make([]*propertySourcesReport, 0, len(properties))
+	var unknown []string
+	for _, name := range properties {
+		report, ok := reports[strings.TrimPrefix(name, "properties.")]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		result = append(result, report)
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("Some properties are not declared by the jobs of the roles: %v", unknown)
+	}
+
+	return result, nil
+}
+
+// parentProperty returns the name of the hash holding a property, or an
+// empty string for properties at the top
+func parentProperty(name string) string {
+	at := strings.LastIndex(name, ".")
+	if at < 0 {
+		return ""
+	}
+	return name[:at]
+}
+
+// appendUnique appends a value to a list unless it holds it already
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
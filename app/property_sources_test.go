@@ -0,0 +1,108 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowPropertySources(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-issues.yml")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	properties := []string{"properties.tor.hostname", "tor.client_keys"}
+	if !assert.NoError(f.ShowPropertySources(rolesManifestPath, lightManifestPath, darkManifestPath, properties, "json")) {
+		return
+	}
+
+	var reports []*propertySourcesReport
+	if !assert.NoError(json.Unmarshal(buffer.Bytes(), &reports)) || !assert.Len(reports, 2) {
+		return
+	}
+	assert.Equal(&propertySourcesReport{
+		Property: "tor.hostname",
+		Declarations: []*propertyDeclarationReport{
+			{Job: "tor/tor", Default: "localhost", Roles: []string{"foorole", "myrole"}},
+		},
+		LightOpinions: map[string]string{"properties.tor.hostname": "localhost"},
+		DarkOpinions:  []string{},
+		Templates: []*propertyTemplateReport{
+			{
+				Name:      "properties.tor.hostname",
+				Template:  "((FOO))",
+				Variables: []string{"FOO"},
+				Roles:     []string{"foorole", "myrole"},
+			},
+		},
+		Roles: map[string]string{
+			"foorole": "template properties.tor.hostname",
+			"myrole":  "template properties.tor.hostname",
+		},
+	}, reports[0])
+	assert.Equal("tor.client_keys", reports[1].Property)
+	assert.Empty(reports[1].Templates)
+	assert.Equal(map[string]string{"foorole": "spec default", "myrole": "spec default"}, reports[1].Roles)
+
+	err = f.ShowPropertySources(rolesManifestPath, lightManifestPath, darkManifestPath, []string{"tor.bogus"}, "json")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "[tor.bogus]")
+	}
+}
+
+func TestCollectPropertySourcesOpinions(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := model.NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, []*model.Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	light := map[string]string{"properties.tor.client_keys.alice": "key"}
+	reports, err := collectPropertySources(roleManifest, light, map[string]string{}, []string{"tor.client_keys"})
+	if assert.NoError(err) && assert.Len(reports, 1) {
+		assert.Equal(light, reports[0].LightOpinions)
+		assert.Equal(map[string]string{"foorole": "light opinion", "myrole": "light opinion"}, reports[0].Roles)
+	}
+
+	light = map[string]string{"properties.tor.client_keys": "light"}
+	dark := map[string]string{"properties.tor.client_keys": "dark"}
+	reports, err = collectPropertySources(roleManifest, light, dark, []string{"tor.client_keys"})
+	if assert.NoError(err) && assert.Len(reports, 1) {
+		assert.Equal([]string{"properties.tor.client_keys"}, reports[0].DarkOpinions)
+		assert.Equal(map[string]string{"foorole": "spec default", "myrole": "spec default"}, reports[0].Roles,
+			"Dark opinions should drop the light ones")
+	}
+}
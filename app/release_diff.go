@@ -0,0 +1,219 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// releaseDiffReport lists the changes between two versions of a release
+type releaseDiffReport struct {
+	Old             string                   `json:"old" yaml:"old"` // <name>/<version>
+	New             string                   `json:"new" yaml:"new"`
+	AddedJobs       []string                 `json:"added_jobs" yaml:"added_jobs"`
+	RemovedJobs     []string                 `json:"removed_jobs" yaml:"removed_jobs"`
+	ChangedJobs     []*releaseChangeReport   `json:"changed_jobs" yaml:"changed_jobs"`
+	AddedPackages   []string                 `json:"added_packages" yaml:"added_packages"`
+	RemovedPackages []string                 `json:"removed_packages" yaml:"removed_packages"`
+	ChangedPackages []*releaseChangeReport   `json:"changed_packages" yaml:"changed_packages"`
+	AddedProps      []string                 `json:"added_properties" yaml:"added_properties"` // <job>/<property>
+	RemovedProps    []string                 `json:"removed_properties" yaml:"removed_properties"`
+	ChangedDefaults []*propertyDefaultChange `json:"changed_defaults" yaml:"changed_defaults"`
+}
+
+// releaseChangeReport describes a job or package whose fingerprint changed
+type releaseChangeReport struct {
+	Name       string `json:"name" yaml:"name"`
+	OldVersion string `json:"old_version" yaml:"old_version"`
+	NewVersion string `json:"new_version" yaml:"new_version"`
+}
+
+// propertyDefaultChange describes a property whose spec default changed
+type propertyDefaultChange struct {
+	Property string `json:"property" yaml:"property"` // <job>/<property>
+	Old      string `json:"old" yaml:"old"`
+	New      string `json:"new" yaml:"new"`
+}
+
+// empty returns whether the report found no changes
+func (r *releaseDiffReport) empty() bool {
+	return len(r.AddedJobs) == 0 && len(r.RemovedJobs) == 0 && len(r.ChangedJobs) == 0 &&
+		len(r.AddedPackages) == 0 && len(r.RemovedPackages) == 0 && len(r.ChangedPackages) == 0 &&
+		len(r.AddedProps) == 0 && len(r.RemovedProps) == 0 && len(r.ChangedDefaults) == 0
+}
+
+// DiffReleases reports the jobs and packages added, removed or changed
+// between two versions of a release, along with the properties added to or
+// removed from the jobs found in both, and the properties whose default
+// changed. Releases are loaded like --release, so remote releases are
+// downloaded first; they are not checked against the releases lock.
+func (f *Fissile) DiffReleases(oldReleasePath, newReleasePath, cacheDir, outputFormat string) error {
+	err := f.loadReleases([]string{oldReleasePath, newReleasePath}, []string{}, []string{}, cacheDir)
+	if err != nil {
+		return err
+	}
+
+	report := diffReleases(f.releases[0], f.releases[1])
+
+	return f.printReport(report, outputFormat, func() {
+		f.UI.Printf("Changes from %s to %s\n", color.YellowString(report.Old), color.YellowString(report.New))
+		if report.empty() {
+			f.UI.Printf("%s: no changes\n", color.GreenString("OK"))
+			return
+		}
+
+		f.printNames(color.GreenString("Added jobs:"), report.AddedJobs)
+		f.printNames(color.RedString("Removed jobs:"), report.RemovedJobs)
+		f.printChanges(color.BlueString("Changed jobs:"), report.ChangedJobs)
+		f.printNames(color.GreenString("Added packages:"), report.AddedPackages)
+		f.printNames(color.RedString("Removed packages:"), report.RemovedPackages)
+		f.printChanges(color.BlueString("Changed packages:"), report.ChangedPackages)
+		f.printNames(color.GreenString("Added properties:"), report.AddedProps)
+		f.printNames(color.RedString("Removed properties:"), report.RemovedProps)
+		if len(report.ChangedDefaults) > 0 {
+			f.UI.Println(color.BlueString("Changed defaults:"))
+			for _, change := range report.ChangedDefaults {
+				f.UI.Printf("  %s: %s => %s\n", change.Property, change.Old, change.New)
+			}
+		}
+	})
+}
+
+// printNames prints a section of the release diff listing names, if any
+func (f *Fissile) printNames(title string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	f.UI.Println(title)
+	for _, name := range names {
+		f.UI.Printf("  %s\n", name)
+	}
+}
+
+// printChanges prints a section of the release diff listing changed jobs or
+// packages, if any
+func (f *Fissile) printChanges(title string, changes []*releaseChangeReport) {
+	if len(changes) == 0 {
+		return
+	}
+	f.UI.Println(title)
+	for _, change := range changes {
+		f.UI.Printf("  %s: %s => %s\n", change.Name, change.OldVersion, change.NewVersion)
+	}
+}
+
+// diffReleases compares the jobs, packages, and job properties of two
+// releases. Jobs and packages are changed when their fingerprint is.
+func diffReleases(oldRelease, newRelease *model.Release) *releaseDiffReport {
+	report := &releaseDiffReport{
+		Old:             fmt.Sprintf("%s/%s", oldRelease.Name, oldRelease.Version),
+		New:             fmt.Sprintf("%s/%s", newRelease.Name, newRelease.Version),
+		AddedJobs:       []string{},
+		RemovedJobs:     []string{},
+		ChangedJobs:     []*releaseChangeReport{},
+		AddedPackages:   []string{},
+		RemovedPackages: []string{},
+		ChangedPackages: []*releaseChangeReport{},
+		AddedProps:      []string{},
+		RemovedProps:    []string{},
+		ChangedDefaults: []*propertyDefaultChange{},
+	}
+
+	newJobs := make(map[string]*model.Job, len(newRelease.Jobs))
+	for _, job := range newRelease.Jobs {
+		newJobs[job.Name] = job
+	}
+	for _, oldJob := range oldRelease.Jobs {
+		newJob, ok := newJobs[oldJob.Name]
+		if !ok {
+			report.RemovedJobs = append(report.RemovedJobs, oldJob.Name)
+			continue
+		}
+		delete(newJobs, oldJob.Name)
+
+		if oldJob.Fingerprint != newJob.Fingerprint {
+			report.ChangedJobs = append(report.ChangedJobs, &releaseChangeReport{
+				Name:       oldJob.Name,
+				OldVersion: oldJob.Version,
+				NewVersion: newJob.Version,
+			})
+		}
+		diffJobProperties(report, oldJob, newJob)
+	}
+	for name := range newJobs {
+		report.AddedJobs = append(report.AddedJobs, name)
+	}
+
+	newPackages := make(map[string]*model.Package, len(newRelease.Packages))
+	for _, pkg := range newRelease.Packages {
+		newPackages[pkg.Name] = pkg
+	}
+	for _, oldPackage := range oldRelease.Packages {
+		newPackage, ok := newPackages[oldPackage.Name]
+		if !ok {
+			report.RemovedPackages = append(report.RemovedPackages, oldPackage.Name)
+			continue
+		}
+		delete(newPackages, oldPackage.Name)
+
+		if oldPackage.Fingerprint != newPackage.Fingerprint {
+			report.ChangedPackages = append(report.ChangedPackages, &releaseChangeReport{
+				Name:       oldPackage.Name,
+				OldVersion: oldPackage.Version,
+				NewVersion: newPackage.Version,
+			})
+		}
+	}
+	for name := range newPackages {
+		report.AddedPackages = append(report.AddedPackages, name)
+	}
+
+	sort.Strings(report.AddedJobs)
+	sort.Strings(report.RemovedJobs)
+	sort.Slice(report.ChangedJobs, func(i, j int) bool { return report.ChangedJobs[i].Name < report.ChangedJobs[j].Name })
+	sort.Strings(report.AddedPackages)
+	sort.Strings(report.RemovedPackages)
+	sort.Slice(report.ChangedPackages, func(i, j int) bool { return report.ChangedPackages[i].Name < report.ChangedPackages[j].Name })
+	sort.Strings(report.AddedProps)
+	sort.Strings(report.RemovedProps)
+	sort.Slice(report.ChangedDefaults, func(i, j int) bool {
+		return report.ChangedDefaults[i].Property < report.ChangedDefaults[j].Property
+	})
+
+	return report
+}
+
+// diffJobProperties adds the properties added to, removed from, or with
+// another default in a job found in both releases to the report
+func diffJobProperties(report *releaseDiffReport, oldJob, newJob *model.Job) {
+	newProperties := make(map[string]*model.JobProperty, len(newJob.Properties))
+	for _, property := range newJob.Properties {
+		newProperties[property.Name] = property
+	}
+
+	for _, oldProperty := range oldJob.Properties {
+		name := fmt.Sprintf("%s/%s", oldJob.Name, oldProperty.Name)
+		newProperty, ok := newProperties[oldProperty.Name]
+		if !ok {
+			report.RemovedProps = append(report.RemovedProps, name)
+			continue
+		}
+		delete(newProperties, oldProperty.Name)
+
+		oldDefault := fmt.Sprintf("%+v", oldProperty.Default)
+		newDefault := fmt.Sprintf("%+v", newProperty.Default)
+		if oldDefault != newDefault {
+			report.ChangedDefaults = append(report.ChangedDefaults, &propertyDefaultChange{
+				Property: name,
+				Old:      oldDefault,
+				New:      newDefault,
+			})
+		}
+	}
+	for name := range newProperties {
+		report.AddedProps = append(report.AddedProps, fmt.Sprintf("%s/%s", newJob.Name, name))
+	}
+}
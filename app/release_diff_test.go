@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/termui"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffReleases(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	cachePath := filepath.Join(workDir, "../test-assets/test-dev-config-diff/cache")
+	oldRelease, err := model.NewDevRelease(filepath.Join(workDir, "../test-assets/test-dev-config-diff/cf-release-215"), "", "", cachePath)
+	if !assert.NoError(err) {
+		return
+	}
+	newRelease, err := model.NewDevRelease(filepath.Join(workDir, "../test-assets/test-dev-config-diff/cf-release-224"), "", "", cachePath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	report := diffReleases(oldRelease, newRelease)
+	assert.Equal("cf/215+dev.2", report.Old)
+	assert.Equal("cf/224+dev.8", report.New)
+	assert.Empty(report.AddedJobs)
+	assert.Empty(report.RemovedJobs)
+	assert.Equal([]*releaseChangeReport{
+		{Name: "acceptance-tests", OldVersion: "d4e5be4f9effe39168e44c2240edca837963622b", NewVersion: "d2182d821527f9b59a65b86eebdaaed4362d1ec4"},
+		{Name: "cloud_controller_ng", OldVersion: "5d6a9b592f6f8f3dc16bc44bfdc287c026a1099d", NewVersion: "6a79f7b9530d1defa04223d4270ad6c7d907c5a2"},
+	}, report.ChangedJobs)
+	assert.Equal([]string{"mysqlclient-5.5", "ruby-2.1.7", "ruby-2.2.3"}, report.AddedPackages)
+	assert.Equal([]string{"common", "mysqlclient", "nginx", "nginx_newrelic_plugin", "ruby-2.1.6"}, report.RemovedPackages)
+	if assert.Len(report.ChangedPackages, 4) {
+		assert.Equal("golang1.4", report.ChangedPackages[2].Name)
+	}
+	assert.Equal([]string{
+		"acceptance-tests/acceptance_tests.include_route_services",
+		"cloud_controller_ng/app_ssh.oauth_client_id",
+	}, report.AddedProps)
+	assert.Equal([]string{
+		"acceptance-tests/acceptance_tests.old_key",
+		"cloud_controller_ng/networks.apps",
+	}, report.RemovedProps)
+	assert.Equal([]*propertyDefaultChange{
+		{Property: "acceptance-tests/acceptance_tests.fake_key", Old: "49", New: "10"},
+		{Property: "cloud_controller_ng/cc.external_protocol", Old: "http", New: "https"},
+		{Property: "cloud_controller_ng/metron_endpoint.port", Old: "3456", New: "3457"},
+	}, report.ChangedDefaults)
+
+	same := diffReleases(oldRelease, oldRelease)
+	assert.True(same.empty())
+}
+
+func TestDiffReleasesCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	buffer := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, buffer, nil)
+	f := NewFissileApplication(".", ui)
+
+	err = f.DiffReleases(
+		filepath.Join(workDir, "../test-assets/tor-boshrelease"),
+		filepath.Join(workDir, "../test-assets/tor-final-release"),
+		filepath.Join(workDir, "../test-assets/tor-boshrelease/bosh-cache"),
+		"human")
+	if assert.NoError(err) {
+		assert.Contains(buffer.String(), "Changes from tor/0.3.5+dev.5 to tor/0.3.5")
+		assert.Contains(buffer.String(), "no changes")
+	}
+
+	err = f.DiffReleases(
+		filepath.Join(workDir, "../test-assets/tor-boshrelease"),
+		filepath.Join(workDir, "../test-assets/no-such-release"),
+		filepath.Join(workDir, "../test-assets/tor-boshrelease/bosh-cache"),
+		"human")
+	assert.Error(err)
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+)
+
+// ReleasesLockName is the name of the releases lock read from the work
+// directory by default
+const ReleasesLockName = "releases.lock"
+
+// ReleasesLock pins the releases commands are run with
+type ReleasesLock struct {
+	Releases []*ReleasesLockEntry `yaml:"releases"`
+}
+
+// ReleasesLockEntry pins a release
+type ReleasesLockEntry struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	SHA1    string `yaml:"sha1"` // Digest of the job and package fingerprints
+}
+
+// releaseDigest returns the SHA1 of the fingerprints of the jobs and the
+// packages of a release, which changes with any of them, e.g. when a dev
+// release is created again with the same version
+func releaseDigest(release *model.Release) string {
+	var lines []string
+	for _, job := range release.Jobs {
+		lines = append(lines, fmt.Sprintf("job %s %s", job.Name, job.Fingerprint))
+	}
+	for _, pkg := range release.Packages {
+		lines = append(lines, fmt.Sprintf("package %s %s", pkg.Name, pkg.Fingerprint))
+	}
+	sort.Strings(lines)
+
+	sum := sha1.Sum([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadReleasesLock reads a releases lock
+func LoadReleasesLock(path string) (*ReleasesLock, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock ReleasesLock
+	if err := yaml.Unmarshal(contents, &lock); err != nil {
+		return nil, fmt.Errorf("Error loading releases lock %s: %s", path, err)
+	}
+	return &lock, nil
+}
+
+// checkReleasesLock fails if a loaded release is not the one pinned by the
+// releases lock. Nothing is checked if there is no lock; pinned releases
+// which are not loaded are left alone, as commands may use some of them.
+func (f *Fissile) checkReleasesLock() error {
+	if f.releasesLock == "" {
+		return nil
+	}
+
+	lock, err := LoadReleasesLock(f.releasesLock)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pinned := make(map[string]*ReleasesLockEntry, len(lock.Releases))
+	for _, entry := range lock.Releases {
+		pinned[entry.Name] = entry
+	}
+
+	var mismatches []string
+	for _, release := range f.releases {
+		entry, ok := pinned[release.Name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("release %s is not pinned", release.Name))
+		case entry.Version != release.Version:
+			mismatches = append(mismatches, fmt.Sprintf("release %s is version %s, not %s", release.Name, release.Version, entry.Version))
+		case entry.SHA1 != releaseDigest(release):
+			mismatches = append(mismatches, fmt.Sprintf("release %s %s has changed", release.Name, release.Version))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("The releases don't match the releases lock %s; run `fissile release update` if the change is intended:\n  %s",
+			f.releasesLock, strings.Join(mismatches, "\n  "))
+	}
+
+	f.logger(logRelease).Debugf("Releases match the releases lock %s", f.releasesLock)
+	return nil
+}
+
+// UpdateReleasesLock loads the releases and pins them in the releases lock,
+// replacing the releases it pinned before
+func (f *Fissile) UpdateReleasesLock(releasePaths, releaseNames, releaseVersions []string, cacheDir string) error {
+	if f.releasesLock == "" {
+		return fmt.Errorf("No releases lock given")
+	}
+
+	if err := f.loadReleases(releasePaths, releaseNames, releaseVersions, cacheDir); err != nil {
+		return err
+	}
+
+	lock := &ReleasesLock{Releases: []*ReleasesLockEntry{}}
+	for _, release := range f.releases {
+		lock.Releases = append(lock.Releases, &ReleasesLockEntry{
+			Name:    release.Name,
+			Version: release.Version,
+			SHA1:    releaseDigest(release),
+		})
+		f.logger(logRelease).Infof("Pinning release %s (%s)",
+			color.YellowString(release.Name), color.MagentaString(release.Version))
+	}
+
+	contents, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(f.releasesLock, contents, 0644)
+}
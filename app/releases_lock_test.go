@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestReleasesLock(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	tempDir, err := ioutil.TempDir("", "fissile-releases-lock")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	lockPath := filepath.Join(tempDir, ReleasesLockName)
+	f := NewFissileApplication("1.0", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	f.SetReleasesLock(lockPath)
+
+	// Without a lock, releases are not checked
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.UpdateReleasesLock([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	lock, err := LoadReleasesLock(lockPath)
+	if !assert.NoError(err) || !assert.Len(lock.Releases, 1) {
+		return
+	}
+	assert.Equal("tor", lock.Releases[0].Name)
+	assert.Equal("0.3.5+dev.5", lock.Releases[0].Version)
+	assert.Equal(releaseDigest(f.releases[0]), lock.Releases[0].SHA1)
+
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	assert.NoError(err)
+
+	// Another version of the release is not loaded
+	lock.Releases[0].Version = "0.3.4"
+	contents, err := yaml.Marshal(lock)
+	if !assert.NoError(err) || !assert.NoError(ioutil.WriteFile(lockPath, contents, 0644)) {
+		return
+	}
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "release tor is version 0.3.5+dev.5, not 0.3.4")
+	}
+
+	// Nor is the same version with other contents
+	lock.Releases[0].Version = "0.3.5+dev.5"
+	lock.Releases[0].SHA1 = "0000000000000000000000000000000000000000"
+	contents, err = yaml.Marshal(lock)
+	if !assert.NoError(err) || !assert.NoError(ioutil.WriteFile(lockPath, contents, 0644)) {
+		return
+	}
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "release tor 0.3.5+dev.5 has changed")
+	}
+
+	// Nor a release which is not pinned
+	lock.Releases = []*ReleasesLockEntry{}
+	contents, err = yaml.Marshal(lock)
+	if !assert.NoError(err) || !assert.NoError(ioutil.WriteFile(lockPath, contents, 0644)) {
+		return
+	}
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "release tor is not pinned")
+	}
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// releaseReport describes a release, its jobs, and its packages, for
+// machine-readable reports
+type releaseReport struct {
+	Name     string           `json:"name" yaml:"name"`
+	Version  string           `json:"version" yaml:"version"`
+	Jobs     []*jobReport     `json:"jobs,omitempty" yaml:"jobs,omitempty"`
+	Packages []*packageReport `json:"packages,omitempty" yaml:"packages,omitempty"`
+}
+
+// jobReport describes a job of a release; the properties and processes are
+// only part of detailed reports
+type jobReport struct {
+	Name        string               `json:"name" yaml:"name"`
+	Version     string               `json:"version" yaml:"version"`
+	Fingerprint string               `json:"fingerprint" yaml:"fingerprint"`
+	Description string               `json:"description" yaml:"description"`
+	Packages    []string             `json:"packages" yaml:"packages"`
+	Processes   []string             `json:"processes,omitempty" yaml:"processes,omitempty"`
+	Properties  []*jobPropertyReport `json:"properties,omitempty" yaml:"properties,omitempty"`
+}
+
+// jobPropertyReport describes a property declared by the spec of a job
+type jobPropertyReport struct {
+	Name        string      `json:"name" yaml:"name"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// packageReport describes a package of a release
+type packageReport struct {
+	Name         string   `json:"name" yaml:"name"`
+	Version      string   `json:"version" yaml:"version"`
+	Fingerprint  string   `json:"fingerprint" yaml:"fingerprint"`
+	Dependencies []string `json:"dependencies" yaml:"dependencies"`
+}
+
+// roleImageReport describes the image of a role
+type roleImageReport struct {
+	Role        string `json:"role" yaml:"role"`
+	Image       string `json:"image" yaml:"image"`
+	VirtualSize int64  `json:"virtual_size,omitempty" yaml:"virtual_size,omitempty"`
+}
+
+// printReport prints a report in the given output format; human readable
+// output is left to printHuman
+func (f *Fissile) printReport(report interface{}, outputFormat string, printHuman func()) error {
+	switch outputFormat {
+	case "human":
+		printHuman()
+	case "json":
+		buf, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s\n", buf)
+	case "yaml":
+		buf, err := yaml.Marshal(report)
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", outputFormat)
+	}
+
+	return nil
+}
+
+// collectReleases describes the loaded releases, with their jobs and/or
+// packages
+func (f *Fissile) collectReleases(withJobs, withPackages bool) []*releaseReport {
+	return f.collectReleasesDetail(withJobs, false, withPackages)
+}
+
+// collectReleasesDetail describes the loaded releases like collectReleases,
+// and with jobDetail, the processes and properties of their jobs as well
+func (f *Fissile) collectReleasesDetail(withJobs, jobDetail, withPackages bool) []*releaseReport {
+	releases := []*releaseReport{}
+
+	for _, release := range f.releases {
+		report := &releaseReport{
+			Name:    release.Name,
+			Version: release.Version,
+		}
+
+		if withJobs {
+			report.Jobs = []*jobReport{}
+			for _, job := range release.Jobs {
+				packages := []string{}
+				for _, pkg := range job.Packages {
+					packages = append(packages, pkg.Name)
+				}
+				jobReport := &jobReport{
+					Name:        job.Name,
+					Version:     job.Version,
+					Fingerprint: job.Fingerprint,
+					Description: job.Description,
+					Packages:    packages,
+				}
+				if jobDetail {
+					jobReport.Processes = job.Processes
+					for _, property := range job.Properties {
+						jobReport.Properties = append(jobReport.Properties, &jobPropertyReport{
+							Name:        property.Name,
+							Description: property.Description,
+							Default:     stringKeys(property.Default),
+						})
+					}
+				}
+				report.Jobs = append(report.Jobs, jobReport)
+			}
+		}
+
+		if withPackages {
+			report.Packages = []*packageReport{}
+			for _, pkg := range release.Packages {
+				dependencies := []string{}
+				for _, dependency := range pkg.Dependencies {
+					dependencies = append(dependencies, dependency.Name)
+				}
+				report.Packages = append(report.Packages, &packageReport{
+					Name:         pkg.Name,
+					Version:      pkg.Version,
+					Fingerprint:  pkg.Fingerprint,
+					Dependencies: dependencies,
+				})
+			}
+		}
+
+		releases = append(releases, report)
+	}
+
+	return releases
+}
+
+// stringKeys converts the maps with interface keys in a value read from YAML
+// to maps with string keys, which JSON can represent
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[fmt.Sprintf("%v", key)] = stringKeys(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = stringKeys(item)
+		}
+		return result
+	}
+	return value
+}
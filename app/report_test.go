@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestShowReleasesMachineReadable(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(f.ShowReleases("json")) {
+		return
+	}
+
+	var releases []*releaseReport
+	if !assert.NoError(json.Unmarshal(buffer.Bytes(), &releases)) || !assert.Len(releases, 1) {
+		return
+	}
+	assert.Equal("tor", releases[0].Name)
+	assert.Len(releases[0].Jobs, 3)
+	if assert.Len(releases[0].Packages, 2) {
+		dependencies := make(map[string][]string)
+		for _, pkg := range releases[0].Packages {
+			dependencies[pkg.Name] = pkg.Dependencies
+		}
+		assert.Equal(map[string][]string{"libevent": {}, "tor": {"libevent"}}, dependencies)
+	}
+
+	buffer.Reset()
+	if assert.NoError(f.ListJobs("yaml")) {
+		releases = nil
+		if assert.NoError(yaml.Unmarshal(buffer.Bytes(), &releases)) && assert.Len(releases, 1) {
+			assert.Empty(releases[0].Packages, "Only jobs should be listed")
+			if assert.NotEmpty(releases[0].Jobs) {
+				assert.Len(releases[0].Jobs[0].Packages, 2)
+			}
+		}
+	}
+
+	buffer.Reset()
+	if assert.NoError(f.ListPackages("json")) {
+		assert.False(strings.Contains(buffer.String(), `"jobs"`), "Only packages should be listed")
+	}
+
+	assert.EqualError(f.ListJobs("xml"), "Invalid output format 'xml', expected one of human, json, or yaml")
+}
+
+func TestJobsReportProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(f.JobsReport(false, "json")) {
+		return
+	}
+	assert.False(strings.Contains(buffer.String(), `"properties"`), "Properties should only be reported when asked for")
+
+	buffer.Reset()
+	if !assert.NoError(f.JobsReport(true, "json")) {
+		return
+	}
+	var releases []*releaseReport
+	if !assert.NoError(json.Unmarshal(buffer.Bytes(), &releases)) || !assert.Len(releases, 1) {
+		return
+	}
+	var tor *jobReport
+	for _, job := range releases[0].Jobs {
+		if job.Name == "tor" {
+			tor = job
+		}
+	}
+	if !assert.NotNil(tor) {
+		return
+	}
+	assert.Equal([]string{"tor"}, tor.Processes)
+	assert.Len(tor.Packages, 2)
+	if assert.NotEmpty(tor.Properties) {
+		properties := make(map[string]*jobPropertyReport)
+		for _, property := range tor.Properties {
+			properties[property.Name] = property
+		}
+		if assert.Contains(properties, "tor.hashed_control_password") {
+			assert.NotEmpty(properties["tor.hashed_control_password"].Description)
+		}
+	}
+
+	buffer.Reset()
+	if assert.NoError(f.JobsReport(true, "human")) {
+		assert.Contains(buffer.String(), "processes: tor")
+	}
+}
+
+func TestListRoleImagesMachineReadable(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/dependency-graph.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(f.ListRoleImages("fissile", roleManifestPath, false, false, "json")) {
+		return
+	}
+
+	var images []*roleImageReport
+	if assert.NoError(json.Unmarshal(buffer.Bytes(), &images)) && assert.Len(images, 2) {
+		assert.Equal("myrole", images[0].Role)
+		assert.True(strings.HasPrefix(images[0].Image, "fissile-myrole:"), images[0].Image)
+		assert.Equal("otherrole", images[1].Role)
+		assert.Zero(images[1].VirtualSize)
+	}
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// ShowRoleManifest prints the role manifest as canonical YAML, to compare
+// manifests. Resolved, it is printed as fissile sees it once loaded: with the
+// variants of roles stamped out, the defaults applied, the templates of the manifest merged into those of each
+// role, and the roles of other types than bosh and bosh-task left out. This
+// needs the releases to be loaded.
+func (f *Fissile) ShowRoleManifest(roleManifestPath string, resolved bool) error {
+	var roleManifest *model.RoleManifest
+	var err error
+	if resolved {
+		if len(f.releases) == 0 {
+			return fmt.Errorf("Releases not loaded")
+		}
+		roleManifest, err = f.loadRoleManifest(roleManifestPath)
+	} else {
+		roleManifest, err = model.ReadRoleManifest(roleManifestPath)
+	}
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	buf, err := roleManifest.MarshalCanonical()
+	if err != nil {
+		return err
+	}
+	f.UI.Printf("%s", buf)
+	return nil
+}
+
+// ListRoleNames prints the names of the roles of the role manifest, one per
+// line, for shell completion; roles with variants are listed by the names of
+// their variants. The manifest is read as written, without the releases.
+func (f *Fissile) ListRoleNames(roleManifestPath string) error {
+	roleManifest, err := model.ReadRoleManifest(roleManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	for _, name := range roleManifest.RoleNames() {
+		f.UI.Println(name)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/termui"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestShowRoleManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	releasePathCacheDir := filepath.Join(releasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+
+	err = f.ShowRoleManifest(roleManifestPath, true)
+	assert.EqualError(err, "Releases not loaded")
+
+	// As written, the templates stay in the configuration of the manifest
+	if !assert.NoError(f.ShowRoleManifest(roleManifestPath, false)) {
+		return
+	}
+	var written model.RoleManifest
+	if assert.NoError(yaml.Unmarshal(buffer.Bytes(), &written)) {
+		if assert.Len(written.Roles, 2) {
+			assert.Equal(model.RoleType(""), written.Roles[0].Type)
+			assert.Nil(written.Roles[0].Configuration)
+		}
+		assert.Equal("((FOO))", written.Configuration.Templates["properties.tor.hostname"])
+	}
+
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	buffer.Reset()
+	if !assert.NoError(f.ShowRoleManifest(roleManifestPath, true)) {
+		return
+	}
+	var resolved model.RoleManifest
+	if assert.NoError(yaml.Unmarshal(buffer.Bytes(), &resolved)) {
+		if assert.Len(resolved.Roles, 2) {
+			role := resolved.Roles[0]
+			assert.Equal("myrole", role.Name)
+			assert.Equal(model.RoleTypeBosh, role.Type)
+			assert.Equal(model.FlightStageFlight, role.Run.FlightStage)
+			assert.Equal("((FOO))", role.Configuration.Templates["properties.tor.hostname"])
+		}
+	}
+
+	// The dump is stable
+	dump := buffer.String()
+	buffer.Reset()
+	assert.NoError(f.ShowRoleManifest(roleManifestPath, true))
+	assert.Equal(dump, buffer.String())
+}
+
+func TestListRoleNames(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+
+	// The releases are not needed
+	if assert.NoError(f.ListRoleNames(filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml"))) {
+		assert.Equal("myrole\nfoorole\n", buffer.String())
+	}
+
+	err = f.ListRoleNames(filepath.Join(workDir, "../test-assets/role-manifests/missing.yml"))
+	assert.Error(err)
+}
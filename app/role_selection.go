@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// selectedRoleReport describes a role matched by the role selection
+type selectedRoleReport struct {
+	Name  string   `json:"name" yaml:"name"`
+	Type  string   `json:"type" yaml:"type"`
+	Group string   `json:"group,omitempty" yaml:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// ListRoleSelection reports the roles the given role selections match, as
+// commands taking --roles would operate on, without doing anything else
+func (f *Fissile) ListRoleSelection(roleManifestPath string, roleNames []string, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := f.loadRoleManifest(roleManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	roles, err := roleManifest.SelectRoles(roleNames)
+	if err != nil {
+		return err
+	}
+
+	report := make([]*selectedRoleReport, 0, len(roles))
+	for _, role := range roles {
+		report = append(report, &selectedRoleReport{
+			Name:  role.Name,
+			Type:  string(role.Type),
+			Group: role.Group,
+			Tags:  role.Tags,
+		})
+	}
+
+	return f.printReport(report, outputFormat, func() {
+		for _, role := range report {
+			details := role.Type
+			if len(role.Tags) > 0 {
+				details = fmt.Sprintf("%s, tags: %s", details, strings.Join(role.Tags, ", "))
+			}
+			f.UI.Printf("%s (%s)\n", color.GreenString(role.Name), details)
+		}
+		f.UI.Printf("%d roles selected\n", len(report))
+	})
+}
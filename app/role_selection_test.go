@@ -0,0 +1,50 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListRoleSelection(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	releasePathCacheDir := filepath.Join(releasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+
+	err = f.ListRoleSelection(roleManifestPath, []string{"myrole"}, "human")
+	assert.EqualError(err, "Releases not loaded")
+
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.ListRoleSelection(roleManifestPath, []string{"*role AND NOT foo*"}, "human")
+	if assert.NoError(err) {
+		assert.Contains(buffer.String(), "myrole (bosh)")
+		assert.NotContains(buffer.String(), "foorole")
+		assert.Contains(buffer.String(), "1 roles selected")
+	}
+
+	buffer.Reset()
+	err = f.ListRoleSelection(roleManifestPath, []string{"type:bosh"}, "yaml")
+	if assert.NoError(err) {
+		assert.Contains(buffer.String(), "- name: myrole\n  type: bosh\n")
+		assert.NotContains(buffer.String(), "foorole")
+	}
+
+	err = f.ListRoleSelection(roleManifestPath, []string{"nosuchrole"}, "human")
+	assert.EqualError(err, "Some roles are unknown: [nosuchrole]")
+}
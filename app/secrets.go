@@ -0,0 +1,294 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/validation"
+
+	"github.com/fatih/color"
+)
+
+// secretWords are the words in the names of properties holding secrets
+var secretWords = map[string]bool{
+	"cert":         true,
+	"certs":        true,
+	"certificate":  true,
+	"certificates": true,
+	"credential":   true,
+	"credentials":  true,
+	"key":          true,
+	"keys":         true,
+	"passphrase":   true,
+	"passwd":       true,
+	"password":     true,
+	"passwords":    true,
+	"secret":       true,
+	"secrets":      true,
+	"token":        true,
+	"tokens":       true,
+}
+
+// secretTypes are the spec types of properties holding secrets
+var secretTypes = map[string]bool{
+	"certificate": true,
+	"password":    true,
+	"rsa":         true,
+	"ssh":         true,
+}
+
+// propertyWordSeparator splits the names of properties into words
+var propertyWordSeparator = regexp.MustCompile(`[._-]+`)
+
+// secretPropertyReport describes a property which looks like it holds a
+// secret, but is neither a dark opinion nor templated in the role manifest
+type secretPropertyReport struct {
+	Property    string   `json:"property" yaml:"property"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Jobs        []string `json:"jobs" yaml:"jobs"` // <release>/<job> declaring the property
+}
+
+// isSecretProperty tests whether a property looks like it holds a secret, by
+// its type or the words of its name. Public keys and certificates are no
+// secrets.
+func isSecretProperty(property *model.JobProperty) bool {
+	if secretTypes[strings.ToLower(property.Type)] {
+		return true
+	}
+
+	words := propertyWordSeparator.Split(strings.ToLower(property.Name), -1)
+	secret := false
+	for _, word := range words {
+		if word == "public" {
+			return false
+		}
+		if secretWords[word] {
+			secret = true
+		}
+	}
+	return secret
+}
+
+// collectSecretProperties lists the properties of the jobs of the roles which
+// look like they hold secrets, but are neither dark opinions nor templated in
+// the role manifest, so their values come from the light opinions or the
+// spec defaults, both of which are baked into the images. Properties held by
+// a dark or templated hash are covered by it.
+func collectSecretProperties(roleManifest *model.RoleManifest, dark map[string]string) []*secretPropertyReport {
+	covered := collectManifestProperties(roleManifest)
+	for property := range dark {
+		covered[property] = dark[property]
+	}
+	isCovered := func(name string) bool {
+		for {
+			if _, ok := covered["properties."+name]; ok {
+				return true
+			}
+			at := strings.LastIndex(name, ".")
+			if at < 0 {
+				return false
+			}
+			name = name[:at]
+		}
+	}
+
+	reports := make(map[string]*secretPropertyReport)
+	seen := make(map[*model.Job]bool)
+	for _, role := range roleManifest.Roles {
+		for _, job := range role.Jobs {
+			if seen[job] {
+				continue
+			}
+			seen[job] = true
+
+			for _, property := range job.Properties {
+				if !isSecretProperty(property) || isCovered(property.Name) {
+					continue
+				}
+				report, ok := reports[property.Name]
+				if !ok {
+					report = &secretPropertyReport{Property: property.Name}
+					reports[property.Name] = report
+				}
+				if report.Description == "" {
+					report.Description = property.Description
+				}
+				report.Jobs = append(report.Jobs, fmt.Sprintf("%s/%s", job.Release.Name, job.Name))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*secretPropertyReport, 0, len(reports))
+	for _, name := range names {
+		sort.Strings(reports[name].Jobs)
+		result = append(result, reports[name])
+	}
+	return result
+}
+
+// checkForUnguardedSecrets reports the properties which look like they hold
+// secrets, but are neither dark opinions nor templated in the role manifest
+func checkForUnguardedSecrets(roleManifest *model.RoleManifest, dark map[string]string) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, secret := range collectSecretProperties(roleManifest, dark) {
+		allErrs = append(allErrs, validation.NotFound(
+			fmt.Sprintf("properties.%s", secret.Property),
+			"Looks like a secret, but is neither a dark opinion nor templated in role-manifest"))
+	}
+
+	return allErrs
+}
+
+// checkForLeakedSecrets reports the templates passing secret variables to a
+// destination which doesn't look like it holds secrets: anything but a job
+// property, or a job property whose name and type don't look like a secret.
+// Such values are likely to end up in logs or plain text configuration.
+func checkForLeakedSecrets(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	variables := model.MakeMapOfVariables(roleManifest)
+	properties := make(map[string][]*model.JobProperty)
+	seen := make(map[*model.Job]bool)
+	for _, role := range roleManifest.Roles {
+		for _, job := range role.Jobs {
+			if seen[job] {
+				continue
+			}
+			seen[job] = true
+			for _, property := range job.Properties {
+				properties[property.Name] = append(properties[property.Name], property)
+			}
+		}
+	}
+
+	usage := roleManifest.VariableUsage()
+	names := make([]string, 0, len(usage.Templates))
+	for name := range usage.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var secrets []string
+		for _, variable := range usage.Templates[name] {
+			if cv, ok := variables[variable]; ok && cv != nil && cv.IsSecret() {
+				secrets = append(secrets, variable)
+			}
+		}
+		if len(secrets) == 0 {
+			continue
+		}
+		uses := fmt.Sprintf("Uses the secret variable %s", secrets[0])
+		if len(secrets) > 1 {
+			uses = fmt.Sprintf("Uses the secret variables %s", strings.Join(secrets, ", "))
+		}
+
+		// Templates of roles are named <role>/<property>
+		field := fmt.Sprintf("configuration.templates[%s]", name)
+		destination := name
+		if at := strings.Index(name, "/"); at >= 0 {
+			destination = name[at+1:]
+			field = fmt.Sprintf("roles[%s].configuration.templates[%s]", name[:at], destination)
+		}
+
+		if !strings.HasPrefix(destination, "properties.") {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				fmt.Sprintf("%s, but is no job property", uses)))
+			continue
+		}
+
+		declared := properties[strings.TrimPrefix(destination, "properties.")]
+		if len(declared) == 0 {
+			continue
+		}
+		secret := false
+		for _, property := range declared {
+			if isSecretProperty(property) {
+				secret = true
+			}
+		}
+		if !secret {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				fmt.Sprintf("%s, but the property doesn't look like it holds a secret", uses)))
+		}
+	}
+
+	return allErrs
+}
+
+// darkOpinionPlaceholders returns dark opinions for the given secret
+// properties, ready to fill in, each with a suggested template
+func darkOpinionPlaceholders(secrets []*secretPropertyReport) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("---\n")
+	buffer.WriteString("# Dark opinions for the properties which look like secrets. Each also\n")
+	buffer.WriteString("# needs a template in the role manifest, as suggested.\n")
+	buffer.WriteString("properties:\n")
+	for _, secret := range secrets {
+		buffer.WriteString(fmt.Sprintf("  # %s\n", strings.Join(secret.Jobs, ", ")))
+		if secret.Description != "" {
+			buffer.WriteString(fmt.Sprintf("  # %s\n", strings.Replace(secret.Description, "\n", " ", -1)))
+		}
+		buffer.WriteString(fmt.Sprintf("  # template: ((%s))\n", placeholderVariableName(secret.Property)))
+		buffer.WriteString(fmt.Sprintf("  %s: \"\"\n", secret.Property))
+	}
+	return buffer.Bytes()
+}
+
+// placeholderVariableName suggests the name of the variable to template a
+// property with, e.g. TOR_PRIVATE_KEY for tor.private_key
+func placeholderVariableName(property string) string {
+	return strings.ToUpper(propertyWordSeparator.ReplaceAllString(property, "_"))
+}
+
+// ShowSecretProperties reports the properties of the jobs of the roles which
+// look like they hold secrets, by their name or type, but are neither dark
+// opinions nor templated in the role manifest. With a placeholders file, dark
+// opinions for them are written to it, ready to fill in.
+func (f *Fissile) ShowSecretProperties(rolesManifestPath, lightManifestPath, darkManifestPath, placeholdersPath, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading opinions: %s", err.Error())
+	}
+
+	secrets := collectSecretProperties(roleManifest, model.FlattenOpinions(opinions.Dark))
+
+	if placeholdersPath != "" {
+		if err := ioutil.WriteFile(placeholdersPath, darkOpinionPlaceholders(secrets), 0644); err != nil {
+			return err
+		}
+	}
+
+	return f.printReport(secrets, outputFormat, func() {
+		if len(secrets) == 0 {
+			f.UI.Printf("%s: all secret-like properties are dark opinions or templated\n", color.GreenString("OK"))
+			return
+		}
+
+		f.UI.Println(color.YellowString("Secret-like properties which are neither dark opinions nor templated:"))
+		for _, secret := range secrets {
+			f.UI.Printf("- %s, in %s\n", color.CyanString(secret.Property), strings.Join(secret.Jobs, ", "))
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestIsSecretProperty(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, secret := range map[string]bool{
+		"tor.hashed_control_password": true,
+		"tor.client_keys":             true,
+		"uaa.clients.cc.secret":       true,
+		"router.tls-cert":             true,
+		"nats.auth_token":             true,
+		"tor.hostname":                false,
+		"ssh_proxy.public_key":        false,
+		"cc.keyspace":                 false,
+	} {
+		assert.Equal(secret, isSecretProperty(&model.JobProperty{Name: name}), name)
+	}
+
+	assert.True(isSecretProperty(&model.JobProperty{Name: "uaa.jwt.signing", Type: "certificate"}))
+	assert.Equal("UAA_CLIENTS_CC_SECRET", placeholderVariableName("uaa.clients.cc-secret"))
+}
+
+func TestCheckForLeakedSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := model.NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/secret-leak.yml")
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, []*model.Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	var messages []string
+	for _, err := range checkForLeakedSecrets(roleManifest) {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal([]string{
+		`configuration.templates[networks.default.dns_record_name]: Forbidden: Uses the secret variable CONTROL_PASSWORD, but is no job property`,
+		`configuration.templates[properties.tor.hostname]: Forbidden: Uses the secret variables HOSTNAME, PRIVATE_KEY, but the property doesn't look like it holds a secret`,
+	}, messages)
+}
+
+func TestShowSecretProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-ok.yml")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-dark-opinions.yml")
+
+	tempDir, err := ioutil.TempDir("", "fissile-secrets")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	placeholdersPath := filepath.Join(tempDir, "placeholders.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.ShowSecretProperties(rolesManifestPath, lightManifestPath, darkManifestPath, placeholdersPath, "json")
+	if !assert.NoError(err) {
+		return
+	}
+
+	// The password and the private key are templated
+	var secrets []*secretPropertyReport
+	if assert.NoError(json.Unmarshal(buffer.Bytes(), &secrets)) && assert.Len(secrets, 1) {
+		assert.Equal("tor.client_keys", secrets[0].Property)
+		assert.Equal([]string{"tor/tor"}, secrets[0].Jobs)
+		assert.NotEmpty(secrets[0].Description)
+	}
+
+	contents, err := ioutil.ReadFile(placeholdersPath)
+	if assert.NoError(err) {
+		assert.Contains(string(contents), "# template: ((TOR_CLIENT_KEYS))\n")
+		var placeholders map[string]map[string]string
+		if assert.NoError(yaml.Unmarshal(contents, &placeholders)) {
+			assert.Equal(map[string]map[string]string{
+				"properties": {"tor.client_keys": ""},
+			}, placeholders)
+		}
+	}
+
+	// Validation only reports them when asked to
+	assert.NoError(f.Validate(rolesManifestPath, lightManifestPath, darkManifestPath))
+	f.SetCheckSecrets(true)
+	err = f.Validate(rolesManifestPath, lightManifestPath, darkManifestPath)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), `properties.tor.client_keys: Not found: "Looks like a secret, but is neither a dark opinion nor templated in role-manifest"`)
+	}
+}
@@ -0,0 +1,476 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/hpcloud/termui"
+)
+
+// ServerJobRequest describes a fissile operation requested through the API.
+// The settings of the server are used as defaults for all fields not present
+// in the request; requests can only set the serverRequestFields.
+type ServerJobRequest struct {
+	Operation              string   `json:"operation"`                // One of the serverOperations
+	Releases               []string `json:"releases"`                 // Paths to the dev BOSH releases
+	ReleaseNames           []string `json:"release_names"`            // Names of the releases; optional
+	ReleaseVersions        []string `json:"release_versions"`         // Versions of the releases; optional
+	CacheDir               string   `json:"cache_dir"`                // Local BOSH cache directory
+	PatchPropertiesRelease string   `json:"patch_properties_release"` // RELEASE/JOB of the patch-properties job
+	PipelineSettings
+}
+
+// Job states, as reported by the API
+const (
+	jobStatusQueued    = "queued"
+	jobStatusRunning   = "running"
+	jobStatusSucceeded = "succeeded"
+	jobStatusFailed    = "failed"
+)
+
+// serverOperations maps the operations available through the API to their
+// implementation. Each operation runs on its own Fissile instance with the
+// releases of the request loaded.
+var serverOperations = map[string]func(f *Fissile, settings *PipelineSettings) error{
+	"load": func(f *Fissile, settings *PipelineSettings) error {
+		for _, release := range f.releases {
+			f.UI.Printf("Loaded release %s (%s) from %s\n",
+				color.YellowString(release.Name), release.Version, release.Path)
+		}
+		return nil
+	},
+	"validate": func(f *Fissile, settings *PipelineSettings) error {
+		if err := f.validatePipelineInputs(settings); err != nil {
+			return err
+		}
+		f.UI.Println(color.GreenString("Validation passed."))
+		return nil
+	},
+	"compile": func(f *Fissile, settings *PipelineSettings) error {
+		return f.Compile(settings.Repository, settings.CompilationDir, settings.RoleManifestPath,
+			settings.MetricsPath, settings.RoleNames, settings.WorkerCount, false, false)
+	},
+	"build-images": func(f *Fissile, settings *PipelineSettings) error {
+		return f.GenerateRoleImages(settings.DockerDir, settings.Repository, settings.MetricsPath,
+			false, settings.Force, settings.RoleNames, settings.WorkerCount, settings.RoleManifestPath,
+			settings.CompilationDir, settings.LightOpinionsPath, settings.DarkOpinionsPath, "")
+	},
+	"build-kube": func(f *Fissile, settings *PipelineSettings) error {
+		if settings.KubeOutputDir == "" {
+			return fmt.Errorf("No Kubernetes output directory specified")
+		}
+		return f.GenerateKube(settings.RoleManifestPath, settings.KubeOutputDir, settings.Repository,
+			settings.KubeRegistry, settings.KubeOrganization, settings.KubeDefaultEnvFiles,
+			settings.KubeUseMemoryLimits, settings.KubeConfigProvider, settings.KubeVaultPath)
+	},
+	"build": func(f *Fissile, settings *PipelineSettings) error {
+		return f.BuildPipeline(settings)
+	},
+}
+
+// jobLog collects the output of a job, and allows reading it while the job
+// is still writing to it.
+type jobLog struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buffer.Write(p)
+}
+
+// readFrom returns a copy of the log, starting at the given offset
+func (l *jobLog) readFrom(offset int) []byte {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if offset >= l.buffer.Len() {
+		return nil
+	}
+	return append([]byte{}, l.buffer.Bytes()[offset:]...)
+}
+
+// len returns the current size of the log
+func (l *jobLog) len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buffer.Len()
+}
+
+// serverJobStatus is the state of a job, as reported by the API
+type serverJobStatus struct {
+	ID        string     `json:"id"`
+	Operation string     `json:"operation"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Created   time.Time  `json:"created"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	LogSize   int        `json:"log_size"`
+}
+
+// serverJob is a single operation submitted through the API
+type serverJob struct {
+	serverJobStatus
+
+	request ServerJobRequest
+	log     jobLog
+}
+
+// serverRequestFields are the fields of a ServerJobRequest a request may
+// set. The others name the directories and files the server writes to, and
+// are kept as configured on the server, so clients can't make it write
+// anywhere else.
+var serverRequestFields = map[string]bool{
+	"operation":                true,
+	"releases":                 true,
+	"release_names":            true,
+	"release_versions":         true,
+	"patch_properties_release": true,
+	"base_image":               true,
+	"repository":               true,
+	"role_manifest":            true,
+	"light_opinions":           true,
+	"dark_opinions":            true,
+	"roles":                    true,
+	"workers":                  true,
+	"force":                    true,
+	"kube_defaults_files":      true,
+	"kube_registry":            true,
+	"kube_organization":        true,
+	"kube_use_memory_limits":   true,
+	"kube_config_provider":     true,
+	"kube_vault_path":          true,
+	"values_namespace":         true,
+}
+
+// server runs fissile operations submitted over HTTP. Jobs are executed one
+// at a time, in the order they were submitted, as most operations make heavy
+// use of docker and of the shared work directories.
+type server struct {
+	version  string
+	ui       *termui.UI
+	defaults ServerJobRequest
+
+	mutex  sync.Mutex
+	jobs   map[string]*serverJob
+	nextID int
+	queue  chan *serverJob
+
+	pollInterval time.Duration
+}
+
+// newServer creates a server; the job runner must be started separately
+func newServer(version string, ui *termui.UI, defaults ServerJobRequest) *server {
+	return &server{
+		version:      version,
+		ui:           ui,
+		defaults:     defaults,
+		jobs:         map[string]*serverJob{},
+		queue:        make(chan *serverJob, 100),
+		pollInterval: 250 * time.Millisecond,
+	}
+}
+
+// Serve exposes fissile operations through a REST API listening on the
+// given address. The defaults are used for all settings not given by the
+// individual requests.
+//
+//	POST /v1/jobs           submit a job; the body is a ServerJobRequest,
+//	                        answered with 503 when the queue is full
+//	GET  /v1/jobs           list all jobs
+//	GET  /v1/jobs/ID        show the status of a job
+//	GET  /v1/jobs/ID/log    show the output of a job; use ?offset=N to skip
+//	                        output already seen, ?follow=true to stream the
+//	                        output until the job finishes
+func (f *Fissile) Serve(address string, defaults ServerJobRequest) error {
+	s := newServer(f.Version, f.UI, defaults)
+	go s.runJobs()
+
+	f.UI.Printf("Listening on %s\n", color.GreenString(address))
+	return http.ListenAndServe(address, s.handler())
+}
+
+// handler returns the HTTP handler for the API
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/jobs", s.handleJobs)
+	mux.HandleFunc("/v1/jobs/", s.handleJob)
+	return mux
+}
+
+// runJobs executes the queued jobs, one at a time
+func (s *server) runJobs() {
+	for job := range s.queue {
+		s.runJob(job)
+	}
+}
+
+// runJob executes a single job, capturing its output in the job log
+func (s *server) runJob(job *serverJob) {
+	s.setStatus(job, jobStatusRunning, nil)
+	s.ui.Printf("Job %s (%s) started\n", job.ID, color.YellowString(job.Operation))
+
+	ui := termui.New(&bytes.Buffer{}, &job.log, nil)
+	f := NewFissileApplication(s.version, ui)
+
+	var err error
+	defer func() {
+		// A broken job must not take the whole server down
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Job %s panicked: %v", job.ID, r)
+			ui.Println(color.RedString("%s", err.Error()))
+			s.setStatus(job, jobStatusFailed, err)
+		}
+	}()
+
+	err = f.SetPatchPropertiesDirective(job.request.PatchPropertiesRelease)
+	if err == nil {
+		err = f.LoadReleases(job.request.Releases, job.request.ReleaseNames,
+			job.request.ReleaseVersions, job.request.CacheDir)
+	}
+	if err == nil {
+		err = serverOperations[job.Operation](f, &job.request.PipelineSettings)
+	}
+
+	if err != nil {
+		ui.Println(color.RedString("%s", err.Error()))
+		s.setStatus(job, jobStatusFailed, err)
+		s.ui.Printf("Job %s (%s) %s: %s\n", job.ID, color.YellowString(job.Operation), color.RedString("failed"), err)
+		return
+	}
+
+	s.setStatus(job, jobStatusSucceeded, nil)
+	s.ui.Printf("Job %s (%s) %s\n", job.ID, color.YellowString(job.Operation), color.GreenString("succeeded"))
+}
+
+// setStatus updates the state of a job
+func (s *server) setStatus(job *serverJob, status string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	job.Status = status
+	switch status {
+	case jobStatusRunning:
+		job.Started = &now
+	case jobStatusSucceeded, jobStatusFailed:
+		job.Finished = &now
+	}
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// snapshot returns a copy of the public state of a job
+func (s *server) snapshot(job *serverJob) serverJobStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := job.serverJobStatus
+	status.LogSize = job.log.len()
+	return status
+}
+
+// handleJobs lists and submits jobs
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		s.mutex.Lock()
+		jobs := make([]*serverJob, 0, len(s.jobs))
+		for _, job := range s.jobs {
+			jobs = append(jobs, job)
+		}
+		s.mutex.Unlock()
+
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+
+		result := make([]serverJobStatus, 0, len(jobs))
+		for _, job := range jobs {
+			result = append(result, s.snapshot(job))
+		}
+		writeJSON(w, http.StatusOK, result)
+
+	case "POST":
+		request := s.defaults
+		// Decoding reuses the backing arrays of slices; do not share them with the defaults
+		request.Releases = append([]string(nil), request.Releases...)
+		request.ReleaseNames = append([]string(nil), request.ReleaseNames...)
+		request.ReleaseVersions = append([]string(nil), request.ReleaseVersions...)
+		request.RoleNames = append([]string(nil), request.RoleNames...)
+		request.KubeDefaultEnvFiles = append([]string(nil), request.KubeDefaultEnvFiles...)
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Error reading request: %s", err))
+			return
+		}
+		if err := checkRequestFields(body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Error decoding request: %s", err))
+			return
+		}
+		if _, ok := serverOperations[request.Operation]; !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Unknown operation %q", request.Operation))
+			return
+		}
+		if len(request.Releases) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("No releases specified"))
+			return
+		}
+		if len(request.ReleaseNames) != 0 && len(request.ReleaseNames) != len(request.Releases) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("If you specify custom release names, you need to do it for all of them"))
+			return
+		}
+		if len(request.ReleaseVersions) != 0 && len(request.ReleaseVersions) != len(request.Releases) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("If you specify custom release versions, you need to do it for all of them"))
+			return
+		}
+
+		s.mutex.Lock()
+		s.nextID++
+		job := &serverJob{
+			serverJobStatus: serverJobStatus{
+				ID:        strconv.Itoa(s.nextID),
+				Operation: request.Operation,
+				Status:    jobStatusQueued,
+				Created:   time.Now(),
+			},
+			request: request,
+		}
+		s.jobs[job.ID] = job
+		s.mutex.Unlock()
+
+		select {
+		case s.queue <- job:
+		default:
+			s.mutex.Lock()
+			delete(s.jobs, job.ID)
+			s.mutex.Unlock()
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("Too many jobs queued, try again later"))
+			return
+		}
+		writeJSON(w, http.StatusAccepted, s.snapshot(job))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+	}
+}
+
+// checkRequestFields rejects requests setting fields reserved to the server
+func checkRequestFields(body []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return fmt.Errorf("Error decoding request: %s", err)
+	}
+
+	var reserved []string
+	for name := range fields {
+		if !serverRequestFields[name] {
+			reserved = append(reserved, name)
+		}
+	}
+	if len(reserved) > 0 {
+		sort.Strings(reserved)
+		return fmt.Errorf("Fields can't be set by requests: %s", strings.Join(reserved, ", "))
+	}
+
+	return nil
+}
+
+// handleJob shows the status and the log of a single job
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/")
+
+	s.mutex.Lock()
+	job, ok := s.jobs[parts[0]]
+	s.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Job %s not found", parts[0]))
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		writeJSON(w, http.StatusOK, s.snapshot(job))
+	case len(parts) == 2 && parts[1] == "log":
+		s.streamLog(w, r, job)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("Path %s not found", r.URL.Path))
+	}
+}
+
+// streamLog writes the log of a job, optionally following it until the job
+// is done
+func (s *server) streamLog(w http.ResponseWriter, r *http.Request, job *serverJob) {
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid offset %q", value))
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		// Check for completion before reading, so no output is lost
+		status := s.snapshot(job).Status
+		done := status == jobStatusSucceeded || status == jobStatusFailed
+
+		chunk := job.log.readFrom(offset)
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			offset += len(chunk)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if !follow || done {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
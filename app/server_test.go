@@ -0,0 +1,161 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func serverTestSubmit(t *testing.T, url string, body string) (int, serverJobStatus) {
+	var job serverJobStatus
+	response, err := http.Post(url+"/v1/jobs", "application/json", strings.NewReader(body))
+	if !assert.NoError(t, err) {
+		return 0, job
+	}
+	defer response.Body.Close()
+	json.NewDecoder(response.Body).Decode(&job)
+	return response.StatusCode, job
+}
+
+func serverTestWait(t *testing.T, url string, id string) serverJobStatus {
+	var job serverJobStatus
+	for i := 0; i < 100; i++ {
+		response, err := http.Get(url + "/v1/jobs/" + id)
+		if !assert.NoError(t, err) {
+			return job
+		}
+		err = json.NewDecoder(response.Body).Decode(&job)
+		response.Body.Close()
+		assert.NoError(t, err)
+		if job.Status == jobStatusSucceeded || job.Status == jobStatusFailed {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("Timed out waiting for job %s", id)
+	return job
+}
+
+func TestServerJobs(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	defaults := ServerJobRequest{
+		Releases: []string{torReleasePath},
+		CacheDir: filepath.Join(torReleasePath, "bosh-cache"),
+		PipelineSettings: PipelineSettings{
+			RoleManifestPath:  filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml"),
+			LightOpinionsPath: filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml"),
+			DarkOpinionsPath:  filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml"),
+		},
+	}
+
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	s := newServer("0.0.1", ui, defaults)
+	s.pollInterval = 10 * time.Millisecond
+	go s.runJobs()
+	defer close(s.queue)
+
+	httpServer := httptest.NewServer(s.handler())
+	defer httpServer.Close()
+
+	code, _ := serverTestSubmit(t, httpServer.URL, `{"operation": "bogus"}`)
+	assert.Equal(http.StatusBadRequest, code, "Unknown operations should be rejected")
+
+	response, err := http.Post(httpServer.URL+"/v1/jobs", "application/json",
+		strings.NewReader(`{"operation": "load", "docker_dir": "/etc", "metrics": "/etc/passwd"}`))
+	if assert.NoError(err) {
+		var result map[string]string
+		json.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		assert.Equal(http.StatusBadRequest, response.StatusCode, "Output locations should be reserved to the server")
+		assert.Equal("Fields can't be set by requests: docker_dir, metrics", result["error"])
+	}
+
+	code, job := serverTestSubmit(t, httpServer.URL, `{"operation": "load"}`)
+	if !assert.Equal(http.StatusAccepted, code) {
+		return
+	}
+	job = serverTestWait(t, httpServer.URL, job.ID)
+	assert.Equal(jobStatusSucceeded, job.Status)
+
+	response, err = http.Get(httpServer.URL + "/v1/jobs/" + job.ID + "/log?follow=true")
+	if assert.NoError(err) {
+		contents, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		assert.NoError(err)
+		assert.Contains(string(contents), "Loaded release")
+		assert.Contains(string(contents), "tor")
+
+		response, err = http.Get(httpServer.URL + "/v1/jobs/" + job.ID + "/log?offset=" + strconv.Itoa(len(contents)))
+		if assert.NoError(err) {
+			contents, err = ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			assert.NoError(err)
+			assert.Empty(contents, "Output before the offset should be skipped")
+		}
+	}
+
+	// Request settings override the defaults
+	code, job = serverTestSubmit(t, httpServer.URL, `{
+		"operation": "validate",
+		"role_manifest": "`+filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-issues.yml")+`"
+	}`)
+	if assert.Equal(http.StatusAccepted, code) {
+		job = serverTestWait(t, httpServer.URL, job.ID)
+		assert.Equal(jobStatusFailed, job.Status)
+		assert.NotEmpty(job.Error)
+	}
+	assert.Equal(filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml"), s.defaults.RoleManifestPath,
+		"Requests should not change the defaults")
+
+	response, err = http.Get(httpServer.URL + "/v1/jobs")
+	if assert.NoError(err) {
+		var jobs []serverJobStatus
+		assert.NoError(json.NewDecoder(response.Body).Decode(&jobs))
+		response.Body.Close()
+		if assert.Len(jobs, 2) {
+			assert.Equal("load", jobs[0].Operation)
+			assert.Equal("validate", jobs[1].Operation)
+		}
+	}
+
+	response, err = http.Get(httpServer.URL + "/v1/jobs/1000")
+	if assert.NoError(err) {
+		response.Body.Close()
+		assert.Equal(http.StatusNotFound, response.StatusCode)
+	}
+}
+
+func TestServerQueueFull(t *testing.T) {
+	assert := assert.New(t)
+
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	s := newServer("0.0.1", ui, ServerJobRequest{Releases: []string{"release"}})
+	// No jobs are run, so the queue fills up
+	s.queue = make(chan *serverJob, 1)
+
+	httpServer := httptest.NewServer(s.handler())
+	defer httpServer.Close()
+
+	code, _ := serverTestSubmit(t, httpServer.URL, `{"operation": "load"}`)
+	assert.Equal(http.StatusAccepted, code)
+
+	code, _ = serverTestSubmit(t, httpServer.URL, `{"operation": "load"}`)
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Len(s.jobs, 1, "Rejected jobs should not be listed")
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/compilator"
+	"github.com/hpcloud/fissile/compose"
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/scripts/compilation"
+
+	"github.com/fatih/color"
+	"github.com/joho/godotenv"
+)
+
+// PackageShell starts an interactive shell in the compilation image, set up
+// the way it is to compile the named package
+func (f *Fissile) PackageShell(repository, targetPath, packageName string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	pkg, err := f.findPackage(packageName)
+	if err != nil {
+		return err
+	}
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+
+	comp, err := compilator.NewDockerCompilator(dockerManager, targetPath, "", repository, compilation.UbuntuBase, f.Version, false, f.UI)
+	if err != nil {
+		return fmt.Errorf("Error creating a new compilator: %s", err.Error())
+	}
+
+	if hasImage, err := dockerManager.HasImage(comp.BaseImageName()); err != nil {
+		return err
+	} else if !hasImage {
+		return fmt.Errorf("Failed to find compilation image %s, did you build it first?", comp.BaseImageName())
+	}
+
+	return comp.Shell(pkg, f.UI, f.UI, f.errorOutput)
+}
+
+// RoleShell starts an interactive shell in the image of a role instead of its
+// entrypoint, with the environment it would have in docker-compose. The
+// volumes of the role are empty.
+func (f *Fissile) RoleShell(rolesManifestPath, repository, roleName string, defaultFiles []string) error {
+	rolesManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	role := rolesManifest.LookupRole(roleName)
+	if role == nil {
+		return fmt.Errorf("Unknown role %s", roleName)
+	}
+
+	devVersion, err := role.GetRoleDevVersion()
+	if err != nil {
+		return fmt.Errorf("Error calculating checksum for role %s: %s", roleName, err.Error())
+	}
+	imageName := builder.GetRoleDevImageName(repository, role, devVersion)
+
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	}
+	if hasImage, err := dockerManager.HasImage(imageName); err != nil {
+		return err
+	} else if !hasImage {
+		return fmt.Errorf("Failed to find role image %s, did you build it first?", imageName)
+	}
+
+	defaults := map[string]string{}
+	if len(defaultFiles) > 0 {
+		defaults, err = godotenv.Read(defaultFiles...)
+		if err != nil {
+			return err
+		}
+	}
+
+	environment, err := compose.RoleEnvironment(role, &compose.Settings{Defaults: defaults})
+	if err != nil {
+		return err
+	}
+
+	var volumes []string
+	for _, volume := range append(append([]*model.RoleRunVolume{}, role.Run.PersistentVolumes...), role.Run.SharedVolumes...) {
+		volumes = append(volumes, volume.Path)
+	}
+
+	f.UI.Printf("Starting a shell in %s; start the role with:\n  %s\n",
+		color.YellowString(imageName), color.CyanString("/opt/hcf/run.sh"))
+
+	return docker.RunShell(docker.ShellOpts{
+		ImageName:       imageName,
+		Env:             environment,
+		Volumes:         volumes,
+		ResetEntrypoint: true,
+	}, f.UI, f.UI, f.errorOutput)
+}
+
+// findPackage looks up a package of the loaded releases by name. Names
+// shared by several releases have to be qualified as <release>/<package>.
+func (f *Fissile) findPackage(name string) (*model.Package, error) {
+	releaseName := ""
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		releaseName, name = parts[0], parts[1]
+	}
+
+	var found []*model.Package
+	for _, release := range f.releases {
+		if releaseName != "" && release.Name != releaseName {
+			continue
+		}
+		for _, pkg := range release.Packages {
+			if pkg.Name == name {
+				found = append(found, pkg)
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("Unknown package %s", name)
+	case 1:
+		return found[0], nil
+	}
+
+	var names []string
+	for _, pkg := range found {
+		names = append(names, fmt.Sprintf("%s/%s", pkg.Release.Name, pkg.Name))
+	}
+	return nil, fmt.Errorf("Package %s is in several releases, use one of %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindPackage(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	pkg, err := f.findPackage("libevent")
+	if assert.NoError(err) {
+		assert.Equal("libevent", pkg.Name)
+	}
+
+	pkg, err = f.findPackage("tor/libevent")
+	if assert.NoError(err) {
+		assert.Equal("libevent", pkg.Name)
+	}
+
+	_, err = f.findPackage("ntp/libevent")
+	assert.EqualError(err, "Unknown package libevent")
+
+	_, err = f.findPackage("missing")
+	assert.EqualError(err, "Unknown package missing")
+}
+
+func TestRoleShellUnknownRole(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = f.RoleShell(roleManifestPath, "fissile", "missing", nil)
+	assert.EqualError(err, "Unknown role missing")
+}
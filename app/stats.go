@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// statsTimeout bounds the time sending the statistics of a run may take
+const statsTimeout = 10 * time.Second
+
+// RunStats are the statistics of a run of fissile sent by the opt-in stats
+// emitter. They hold counts and timings only; nothing names the releases,
+// roles or paths used.
+type RunStats struct {
+	Command   string    `json:"command"`
+	Version   string    `json:"version"`
+	Started   time.Time `json:"started"`
+	Duration  float64   `json:"duration_seconds"`
+	Succeeded bool      `json:"succeeded"`
+	Workers   int       `json:"workers"`
+	Releases  int       `json:"releases"`
+	Jobs      int       `json:"jobs"`
+	Packages  int       `json:"packages"`
+}
+
+// CollectStats returns the statistics of a run of a command which started at
+// the given time
+func (f *Fissile) CollectStats(command string, started time.Time, workers int, succeeded bool) *RunStats {
+	stats := &RunStats{
+		Command:   command,
+		Version:   f.Version,
+		Started:   started.UTC(),
+		Duration:  time.Since(started).Seconds(),
+		Succeeded: succeeded,
+		Workers:   workers,
+		Releases:  len(f.releases),
+	}
+
+	for _, release := range f.releases {
+		stats.Jobs += len(release.Jobs)
+		stats.Packages += len(release.Packages)
+	}
+
+	return stats
+}
+
+// SendStats posts the statistics as JSON to an http(s) URL, or appends them
+// as a line of JSON to a file
+func SendStats(stats *RunStats, destination string) error {
+	buf, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://") {
+		client := &http.Client{Timeout: statsTimeout}
+		response, err := client.Post(destination, "application/json", bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= 300 {
+			return fmt.Errorf("Sending statistics to %s failed: %s", destination, response.Status)
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(buf, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectStats(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	f := NewFissileApplication("1.2.3", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	started := time.Now().Add(-time.Minute)
+	stats := f.CollectStats("fissile show release", started, 4, true)
+	assert.Equal("fissile show release", stats.Command)
+	assert.Equal("1.2.3", stats.Version)
+	assert.True(stats.Duration >= 60)
+	assert.Equal(4, stats.Workers)
+	assert.Equal(1, stats.Releases)
+	assert.Equal(len(f.releases[0].Jobs), stats.Jobs)
+	assert.Equal(len(f.releases[0].Packages), stats.Packages)
+}
+
+func TestSendStats(t *testing.T) {
+	assert := assert.New(t)
+
+	stats := &RunStats{Command: "fissile build images", Succeeded: true, Releases: 2}
+
+	var received RunStats
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		assert.NoError(json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	if assert.NoError(SendStats(stats, server.URL)) {
+		assert.Equal(*stats, received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.EqualError(SendStats(stats, failing.URL), "Sending statistics to "+failing.URL+" failed: 500 Internal Server Error")
+
+	statsDir, err := ioutil.TempDir("", "fissile-stats")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(statsDir)
+
+	statsFile := filepath.Join(statsDir, "stats.jsonl")
+	assert.NoError(SendStats(stats, statsFile))
+	assert.NoError(SendStats(stats, statsFile))
+	contents, err := ioutil.ReadFile(statsFile)
+	if assert.NoError(err) {
+		lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		assert.Len(lines, 2, "Statistics should be appended to the file")
+	}
+}
@@ -1,7 +1,9 @@
 package app
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hpcloud/fissile/model"
@@ -10,6 +12,33 @@ import (
 	"github.com/fatih/color"
 )
 
+// Validate loads the role manifest and the opinions, and checks them against
+// each other and the loaded releases, like the build commands do, without
+// building anything. All problems found are reported in the error.
+func (f *Fissile) Validate(rolesManifestPath, lightManifestPath, darkManifestPath string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	if err != nil {
+		return fmt.Errorf("Error loading opinions: %s", err.Error())
+	}
+
+	if errs := f.validateManifestAndOpinions(roleManifest, opinions); len(errs) != 0 {
+		return fmt.Errorf("%s", errs.Errors())
+	}
+
+	f.UI.Printf("%s: role manifest %s and opinions are valid\n",
+		color.GreenString("OK"), color.CyanString(rolesManifestPath))
+	return nil
+}
+
 // validateManifestAndOpinions applies a series of checks to the role
 // manifest and opinions, testing for consistency against each other
 // and the loaded bosh releases. The result is a (possibly empty)
@@ -18,6 +47,7 @@ func (f *Fissile) validateManifestAndOpinions(roleManifest *model.RoleManifest,
 	allErrs := validation.ErrorList{}
 
 	boshPropertyDefaultsAndJobs := f.collectPropertyDefaults()
+	rolePropertyDefaults := collectJobPropertyDefaults(collectRoleJobs(roleManifest.Roles))
 	darkOpinions := model.FlattenOpinions(opinions.Dark)
 	lightOpinions := model.FlattenOpinions(opinions.Light)
 	manifestProperties := collectManifestProperties(roleManifest)
@@ -26,13 +56,24 @@ func (f *Fissile) validateManifestAndOpinions(roleManifest *model.RoleManifest,
 	allErrs = append(allErrs, checkForUndefinedBOSHProperties("role-manifest",
 		manifestProperties, boshPropertyDefaultsAndJobs)...)
 
-	// All light opinions must exists in a bosh release
-	allErrs = append(allErrs, checkForUndefinedBOSHProperties("light opinion",
-		lightOpinions, boshPropertyDefaultsAndJobs)...)
+	// Templates must be for properties of the jobs they apply to
+	allErrs = append(allErrs, checkForOrphanTemplates(roleManifest, boshPropertyDefaultsAndJobs)...)
 
-	// All dark opinions must exists in a bosh release
-	allErrs = append(allErrs, checkForUndefinedBOSHProperties("dark opinion",
+	// All light and dark opinions must exist in a bosh release, unless
+	// unknown opinions are allowed; then they are only warned about
+	opinionErrs := checkForUndefinedBOSHProperties("light opinion",
+		lightOpinions, boshPropertyDefaultsAndJobs)
+	opinionErrs = append(opinionErrs, checkForUndefinedBOSHProperties("dark opinion",
 		darkOpinions, boshPropertyDefaultsAndJobs)...)
+	// Dark opinions must be on properties of the jobs of the roles; those
+	// only unused jobs declare are left over from release bumps
+	opinionErrs = append(opinionErrs, checkForUnusedDarkOpinions(darkOpinions,
+		boshPropertyDefaultsAndJobs, rolePropertyDefaults)...)
+	if f.allowUnknownOpinions {
+		f.warnUnknownOpinions(opinionErrs)
+	} else {
+		allErrs = append(allErrs, opinionErrs...)
+	}
 
 	// All dark opinions must be configured as templates
 	allErrs = append(allErrs, checkForUntemplatedDarkOpinions(darkOpinions,
@@ -41,6 +82,16 @@ func (f *Fissile) validateManifestAndOpinions(roleManifest *model.RoleManifest,
 	// No dark opinions must have defaults in light opinions
 	allErrs = append(allErrs, checkForDarkInTheLight(darkOpinions, lightOpinions)...)
 
+	// Properties looking like secrets should be dark opinions or templated,
+	// when asked for
+	if f.checkSecrets {
+		allErrs = append(allErrs, checkForUnguardedSecrets(roleManifest, darkOpinions)...)
+	}
+
+	// Secret variables must only be templated into properties holding
+	// secrets
+	allErrs = append(allErrs, checkForLeakedSecrets(roleManifest)...)
+
 	// No duplicates must exist between role manifest and light
 	// opinions
 	allErrs = append(allErrs, checkForDuplicatesBetweenManifestAndLight(lightOpinions, roleManifest)...)
@@ -57,13 +108,48 @@ func (f *Fissile) validateManifestAndOpinions(roleManifest *model.RoleManifest,
 	return allErrs
 }
 
+// validateJobTemplates checks the ERB syntax of the templates of all jobs
+// used by the given roles, so that broken templates are caught before they
+// are baked into images. All problems are reported in a single error.
+func validateJobTemplates(roles model.Roles) error {
+	var problems []string
+
+	checked := make(map[*model.Job]bool)
+	for _, role := range roles {
+		for _, job := range role.Jobs {
+			if checked[job] {
+				continue
+			}
+			checked[job] = true
+
+			templates := make([]*model.JobTemplate, len(job.Templates))
+			copy(templates, job.Templates)
+			sort.Slice(templates, func(i, j int) bool {
+				return templates[i].SourcePath < templates[j].SourcePath
+			})
+
+			for _, template := range templates {
+				for _, err := range template.ValidateERB() {
+					problems = append(problems, err.Error())
+				}
+			}
+		}
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("Invalid job templates:\n%s", strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
 // Check that the given 'properties' are all defined in a 'bosh'
 // release.
 func checkForUndefinedBOSHProperties(label string, properties map[string]string, bosh propertyDefaults) validation.ErrorList {
 	// All provided properties must be defined in a BOSH release
 	allErrs := validation.ErrorList{}
 
-	for property := range properties {
+	for _, property := range sortedKeys(properties) {
 		// Ignore specials (without the "properties." prefix)
 		if !strings.HasPrefix(property, "properties.") {
 			continue
@@ -83,14 +169,184 @@ func checkForUndefinedBOSHProperties(label string, properties map[string]string,
 				continue
 			}
 
+			detail := "In any BOSH release"
+			if nearest := nearestProperty(p, bosh); nearest != "" {
+				detail = fmt.Sprintf("In any BOSH release, did you mean '%s'?", nearest)
+			}
 			allErrs = append(allErrs, validation.NotFound(
-				fmt.Sprintf("%s '%s'", label, p), "In any BOSH release"))
+				fmt.Sprintf("%s '%s'", label, p), detail))
 		}
 	}
 
 	return allErrs
 }
 
+// isDeclaredProperty tests whether a property, or a hash holding it, is
+// declared by the jobs of the given property defaults
+func isDeclaredProperty(p string, declared propertyDefaults) bool {
+	if _, ok := declared[p]; ok {
+		return true
+	}
+	return checkParentsOfUndefined(p, declared)
+}
+
+// checkForOrphanTemplates reports the templates of the role manifest whose
+// property no job they apply to declares, although a job of the releases
+// does: global templates no job of the roles declares the property of, and
+// templates of roles none of their jobs declares it. Such templates have no
+// effect, typically after a release bump dropped the property from the jobs
+// used. Properties no release declares at all are left to
+// checkForUndefinedBOSHProperties.
+func checkForOrphanTemplates(roleManifest *model.RoleManifest, bosh propertyDefaults) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	globalTemplates := roleManifest.Configuration.Templates
+	roles := collectJobPropertyDefaults(collectRoleJobs(roleManifest.Roles))
+
+	for _, property := range sortedKeys(globalTemplates) {
+		if !strings.HasPrefix(property, "properties.") {
+			continue
+		}
+		p := strings.TrimPrefix(property, "properties.")
+		if isDeclaredProperty(p, roles) || !isDeclaredProperty(p, bosh) {
+			continue
+		}
+		allErrs = append(allErrs, validation.NotFound(
+			fmt.Sprintf("configuration.templates[%s]", property),
+			"In any job of the roles, only in unused jobs of the releases"))
+	}
+
+	for _, role := range roleManifest.Roles {
+		declared := collectJobPropertyDefaults(collectRoleJobs(model.Roles{role}))
+
+		for _, property := range sortedKeys(role.Configuration.Templates) {
+			if !strings.HasPrefix(property, "properties.") {
+				continue
+			}
+			// Global templates apply to the roles whose jobs use them
+			if global, ok := globalTemplates[property]; ok && global == role.Configuration.Templates[property] {
+				continue
+			}
+			p := strings.TrimPrefix(property, "properties.")
+			if isDeclaredProperty(p, declared) || !isDeclaredProperty(p, bosh) {
+				continue
+			}
+			allErrs = append(allErrs, validation.NotFound(
+				fmt.Sprintf("roles[%s].configuration.templates[%s]", role.Name, property),
+				"In any job of the role"))
+		}
+	}
+
+	return allErrs
+}
+
+// checkForUnusedDarkOpinions reports the dark opinions on properties no job
+// of the roles declares, although a job of the releases does. Properties no
+// release declares at all are left to checkForUndefinedBOSHProperties.
+func checkForUnusedDarkOpinions(dark map[string]string, bosh, roles propertyDefaults) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, property := range sortedKeys(dark) {
+		if !strings.HasPrefix(property, "properties.") {
+			continue
+		}
+		p := strings.TrimPrefix(property, "properties.")
+		if isDeclaredProperty(p, roles) || !isDeclaredProperty(p, bosh) {
+			continue
+		}
+		allErrs = append(allErrs, validation.NotFound(
+			fmt.Sprintf("dark opinion '%s'", p),
+			"In any job of the roles, only in unused jobs of the releases"))
+	}
+
+	return allErrs
+}
+
+// collectRoleJobs returns the distinct jobs of the given roles
+func collectRoleJobs(roles model.Roles) []*model.Job {
+	var jobs []*model.Job
+	seen := make(map[*model.Job]bool)
+	for _, role := range roles {
+		for _, job := range role.Jobs {
+			if !seen[job] {
+				seen[job] = true
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	return jobs
+}
+
+// warnUnknownOpinions reports opinions not found in any BOSH release as
+// warnings, sorted
+func (f *Fissile) warnUnknownOpinions(errs validation.ErrorList) {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	sort.Strings(messages)
+
+	for _, message := range messages {
+		f.logger(logConfig).Warn(message)
+	}
+}
+
+// nearestProperty returns the BOSH property whose name is closest to the
+// given undefined one, if it is close enough to likely be a typo of it.
+// Ties go to the first name in sort order.
+func nearestProperty(p string, bosh propertyDefaults) string {
+	names := make([]string, 0, len(bosh))
+	for name := range bosh {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	nearest := ""
+	nearestDistance := len(p)/3 + 1
+	for _, name := range names {
+		if strings.HasPrefix(p, name+".") {
+			// Parents are no typos; they are not hashes either, see
+			// checkParentsOfUndefined
+			continue
+		}
+		if distance := editDistance(p, name); distance < nearestDistance {
+			nearest = name
+			nearestDistance = distance
+		}
+	}
+
+	return nearest
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
 // checkParentsOfUndefined walks the chain of parents for `p` from the
 // bottom up and checks if any of them exist. The elements of the
 // chain are separated by dots.
@@ -148,7 +404,7 @@ func collectManifestProperties(roleManifest *model.RoleManifest) map[string]stri
 func checkForUntemplatedDarkOpinions(dark map[string]string, properties map[string]string) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	for property := range dark {
+	for _, property := range sortedKeys(dark) {
 		if _, ok := properties[property]; ok {
 			continue
 		}
@@ -164,7 +420,7 @@ func checkForUntemplatedDarkOpinions(dark map[string]string, properties map[stri
 func checkForDarkInTheLight(dark map[string]string, light map[string]string) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	for property := range dark {
+	for _, property := range sortedKeys(dark) {
 		if _, ok := light[property]; !ok {
 			continue
 		}
@@ -184,7 +440,8 @@ func checkForDuplicatesBetweenManifestAndLight(light map[string]string, roleMani
 	check := make(map[string]struct{})
 
 	// The global properties, ...
-	for property, template := range roleManifest.Configuration.Templates {
+	for _, property := range sortedKeys(roleManifest.Configuration.Templates) {
+		template := roleManifest.Configuration.Templates[property]
 		allErrs = append(allErrs, checkForDuplicateProperty("configuration.templates", property, template, light, true)...)
 		check[property] = struct{}{}
 	}
@@ -193,7 +450,8 @@ func checkForDuplicatesBetweenManifestAndLight(light map[string]string, roleMani
 	for _, role := range roleManifest.Roles {
 		prefix := fmt.Sprintf("roles[%s].configuration.templates", role.Name)
 
-		for property, template := range role.Configuration.Templates {
+		for _, property := range sortedKeys(role.Configuration.Templates) {
+			template := role.Configuration.Templates[property]
 			// Skip over duplicates of the global
 			// properties in the per-role data, we already
 			// checked them, see above.
@@ -233,44 +491,56 @@ func checkForDuplicateProperty(prefix, property, value string, light map[string]
 // checkBOSHDefaults reports all properties which were given differing
 // defaults across BOSH releases and the jobs inside.
 func (f *Fissile) checkBOSHDefaults(pd propertyDefaults) {
-	for property, pInfo := range pd {
+	properties := make([]string, 0, len(pd))
+	for property := range pd {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+
+	for _, property := range properties {
+		pInfo := pd[property]
 		// Ignore properties with a single default across all definitions.
 		if len(pInfo.defaults) == 1 {
 			continue
 		}
 
-		f.UI.Printf("%s: Property %s has %s defaults:\n",
-			color.YellowString("Warning"),
+		message := &bytes.Buffer{}
+		fmt.Fprintf(message, "Property %s has %s defaults:\n",
 			color.YellowString(property),
 			color.YellowString(fmt.Sprintf("%d", len(pInfo.defaults))))
 
 		maxlen := 0
+		defaults := make([]string, 0, len(pInfo.defaults))
 		for defaultv := range pInfo.defaults {
 			ds := fmt.Sprintf("%v", defaultv)
 			if len(ds) > maxlen {
 				maxlen = len(ds)
 			}
+			defaults = append(defaults, defaultv)
 		}
+		sort.Strings(defaults)
 
 		leftjustified := fmt.Sprintf("%%-%ds", maxlen)
 
-		for defaultv, jobs := range pInfo.defaults {
+		for _, defaultv := range defaults {
+			jobs := pInfo.defaults[defaultv]
 			ds := fmt.Sprintf("%v", defaultv)
 			if len(jobs) == 1 {
 				job := jobs[0]
-				f.UI.Printf("- Default %s: Release %s, job %s\n",
+				fmt.Fprintf(message, "- Default %s: Release %s, job %s\n",
 					color.CyanString(fmt.Sprintf(leftjustified, ds)),
 					color.CyanString(job.Release.Name),
 					color.CyanString(job.Name))
 			} else {
-				f.UI.Printf("- Default %s:\n", color.CyanString(ds))
+				fmt.Fprintf(message, "- Default %s:\n", color.CyanString(ds))
 				for _, job := range jobs {
-					f.UI.Printf("  - Release %s, job %s\n",
+					fmt.Fprintf(message, "  - Release %s, job %s\n",
 						color.CyanString(job.Release.Name),
 						color.CyanString(job.Name))
 				}
 			}
 		}
+		f.logger(logConfig).Warn(message.String())
 	}
 }
 
@@ -282,7 +552,8 @@ func (f *Fissile) checkLightDefaults(light map[string]string, pd propertyDefault
 	// pd    :: (property.name -> (default.string -> [*job...])
 	allErrs := validation.ErrorList{}
 
-	for property, opinion := range light {
+	for _, property := range sortedKeys(light) {
+		opinion := light[property]
 		// Ignore specials (without the "properties." prefix)
 		if !strings.HasPrefix(property, "properties.") {
 			continue
@@ -297,7 +568,7 @@ func (f *Fissile) checkLightDefaults(light map[string]string, pd propertyDefault
 
 		// Ignore properties with ambigous defaults. Warn however.
 		if len(pInfo.defaults) > 1 {
-			f.UI.Printf("light opinion %s ignored, %s\n",
+			f.logger(logConfig).Warnf("light opinion %s ignored, %s",
 				color.YellowString(p),
 				color.YellowString("ambiguous default"))
 			continue
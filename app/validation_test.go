@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -98,6 +99,86 @@ func TestValidationOk(t *testing.T) {
 	assert.Empty(errs)
 }
 
+func TestValidate(t *testing.T) {
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-dark-opinions.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	assert.NoError(err)
+
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-ok.yml")
+	assert.NoError(f.Validate(rolesManifestPath, lightManifestPath, darkManifestPath))
+	assert.Contains(output.String(), "OK")
+
+	rolesManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-issues.yml")
+	err = f.Validate(rolesManifestPath, lightManifestPath, darkManifestPath)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), `role-manifest 'fox': Not found: "In any BOSH release"`)
+	}
+
+	rolesManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/templates-bad.yml")
+	err = f.Validate(rolesManifestPath, lightManifestPath, darkManifestPath)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Error loading roles manifest: ")
+		assert.Contains(err.Error(), "Cannot parse template")
+	}
+}
+
+func TestValidationUnknownOpinions(t *testing.T) {
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-ok.yml")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/unknown-opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-dark-opinions.yml")
+
+	f := NewFissileApplication(".", ui)
+
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	assert.NoError(err)
+
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	assert.NoError(err)
+
+	errs := f.validateManifestAndOpinions(roleManifest, opinions)
+
+	actual := errs.Errors()
+	allExpected := []string{
+		`light opinion 'tor.client_kyes': Not found: "In any BOSH release, did you mean 'tor.client_keys'?"`,
+		`light opinion 'tor.unheard_of': Not found: "In any BOSH release"`,
+	}
+	for _, expected := range allExpected {
+		assert.Contains(actual, expected)
+	}
+	assert.Len(errs, len(allExpected))
+
+	// Allowing unknown opinions turns them into warnings
+	f.SetAllowUnknownOpinions(true)
+	errs = f.validateManifestAndOpinions(roleManifest, opinions)
+	assert.Empty(errs)
+	assert.Contains(output.String(), "light opinion 'tor.client_kyes': Not found")
+	assert.Contains(output.String(), "light opinion 'tor.unheard_of': Not found")
+}
+
 func TestValidationHash(t *testing.T) {
 	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
 	assert := assert.New(t)
@@ -133,3 +214,82 @@ func TestValidationHash(t *testing.T) {
 	}
 	assert.Len(errs, len(allExpected))
 }
+
+func TestValidationOrphans(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/orphan-templates.yml")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/orphan-dark.yml")
+	f := NewFissileApplication(".", ui)
+
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if !assert.NoError(err) {
+		return
+	}
+
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	assert.NoError(err)
+
+	errs := f.validateManifestAndOpinions(roleManifest, opinions)
+
+	actual := errs.Errors()
+	allExpected := []string{
+		// checkForOrphanTemplates
+		`configuration.templates[properties.is.a.hash]: Not found: "In any job of the roles, only in unused jobs of the releases"`,
+		`roles[myrole].configuration.templates[properties.tor.hostname]: Not found: "In any job of the role"`,
+		// checkForUnusedDarkOpinions
+		`dark opinion 'is.a.hash': Not found: "In any job of the roles, only in unused jobs of the releases"`,
+	}
+	for _, expected := range allExpected {
+		assert.Contains(actual, expected)
+	}
+	assert.Len(errs, len(allExpected))
+}
+
+func TestValidateJobTemplates(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	rolesManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-validation-ok.yml")
+
+	f := NewFissileApplication(".", ui)
+
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	roleManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(validateJobTemplates(roleManifest.Roles))
+
+	job := roleManifest.Roles[0].Jobs[0]
+	job.Templates = append(job.Templates, &model.JobTemplate{
+		SourcePath: "broken.erb",
+		Job:        job,
+		Content:    "<% if_p('foo') do |foo| %>\n<%= foo\n",
+	})
+
+	err = validateJobTemplates(roleManifest.Roles)
+	if assert.Error(err) {
+		assert.Equal(fmt.Sprintf("Invalid job templates:\njobs[%s].templates[broken.erb:2]: ERB tag is never closed with %%>: <%%= foo ...", job.Name), err.Error())
+	}
+}
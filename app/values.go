@@ -0,0 +1,95 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/secrets"
+
+	"github.com/fatih/color"
+	"github.com/joho/godotenv"
+)
+
+// GenerateValues creates the values of all configuration variables with a
+// generator, and writes them into an env file usable as a defaults file for
+// the Kubernetes configuration, whichever backend generated them. Values
+// found in the defaults files, or in the env file itself, are kept.
+func (f *Fissile) GenerateValues(rolesManifestPath, outputPath string, defaultFiles []string, settings *secrets.Settings) error {
+	rolesManifest, err := model.LoadRoleManifest(rolesManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	envFiles := defaultFiles
+	if _, err := os.Stat(outputPath); err == nil {
+		envFiles = append(append([]string{}, defaultFiles...), outputPath)
+	}
+
+	existing := map[string]string{}
+	if len(envFiles) > 0 {
+		existing, err = godotenv.Read(envFiles...)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := secrets.Generate(rolesManifest, existing, settings)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range result.Generated {
+		f.logger(logConfig).Infof("Generated %s", color.GreenString(name))
+	}
+	for _, name := range result.Skipped {
+		f.logger(logConfig).Warnf("%s %s: its generator type is not supported", color.YellowString("Skipped"), name)
+	}
+
+	f.logger(logConfig).Infof("Writing values to %s", color.CyanString(outputPath))
+
+	return writeEnvFile(result.Values, outputPath)
+}
+
+// checkEnvFileValue returns an error if the value can't be read back from an
+// env file. godotenv only unquotes values with exactly two double quotes,
+// trims quotes of either kind off both ends, never unescapes backslashes, and
+// loses parts of values mixing single quotes and #.
+func checkEnvFileValue(value string) error {
+	if strings.ContainsAny(value, `"\`) {
+		return fmt.Errorf("it contains double quotes or backslashes")
+	}
+	if strings.HasPrefix(value, "'") || strings.HasSuffix(value, "'") {
+		return fmt.Errorf("it starts or ends with a single quote")
+	}
+	if strings.Contains(value, "'") && strings.Contains(value, "#") {
+		return fmt.Errorf("it contains both single quotes and #")
+	}
+	return nil
+}
+
+// writeEnvFile writes values into an env file, sorted by name. The file is
+// readable by the owner only, as the values are usually secrets. Values which
+// can't be read back unchanged are rejected, rather than silently corrupted.
+func writeEnvFile(values map[string]string, outputPath string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		if err := checkEnvFileValue(values[name]); err != nil {
+			return fmt.Errorf("The value of %s can't be written to an env file: %s", name, err)
+		}
+		value := strings.Replace(values[name], "\n", `\n`, -1)
+		fmt.Fprintf(&buf, "%s=\"%s\"\n", name, value)
+	}
+
+	return ioutil.WriteFile(outputPath, buf.Bytes(), 0600)
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpcloud/fissile/secrets"
+	"github.com/hpcloud/termui"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateValues(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/generators.yml")
+
+	f := NewFissileApplication(".", ui)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	valuesPath := filepath.Join(outputDir, "values.env")
+	err = f.GenerateValues(roleManifestPath, valuesPath, nil, &secrets.Settings{Namespace: "cf"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	info, err := os.Stat(valuesPath)
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+
+	values, err := godotenv.Read(valuesPath)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(values, 8)
+	assert.True(strings.HasPrefix(values["TOR_CERT"], "-----BEGIN CERTIFICATE-----\n"))
+	assert.True(strings.HasSuffix(values["TOR_CERT"], "-----END CERTIFICATE-----\n"))
+
+	// Generating again keeps the values in the file
+	err = f.GenerateValues(roleManifestPath, valuesPath, nil, &secrets.Settings{Namespace: "cf"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	again, err := godotenv.Read(valuesPath)
+	if assert.NoError(err) {
+		assert.Equal(values, again)
+	}
+}
+
+func TestWriteEnvFileRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	outputDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(outputDir)
+
+	valuesPath := filepath.Join(outputDir, "values.env")
+	values := map[string]string{
+		"COMMENT":   "pass#word",
+		"EMPTY":     "",
+		"EQUALS":    "a=b==",
+		"MULTILINE": "-----BEGIN KEY-----\nabc\n-----END KEY-----\n",
+		"QUOTE":     "it's",
+		"SHELL":     "$HOME `id` $(id)",
+		"SPACES":    "  padded  ",
+	}
+	if !assert.NoError(writeEnvFile(values, valuesPath)) {
+		return
+	}
+	read, err := godotenv.Read(valuesPath)
+	if assert.NoError(err) {
+		assert.Equal(values, read)
+	}
+
+	for value, reason := range map[string]string{
+		`pass"word`: "it contains double quotes or backslashes",
+		`pass\word`: "it contains double quotes or backslashes",
+		`'password`: "it starts or ends with a single quote",
+		`password'`: "it starts or ends with a single quote",
+		`it's#1`:    "it contains both single quotes and #",
+	} {
+		err := writeEnvFile(map[string]string{"PASSWORD": value}, valuesPath)
+		assert.EqualError(err, "The value of PASSWORD can't be written to an env file: "+reason, value)
+	}
+}
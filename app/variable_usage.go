@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// ShowVariableUsage reports which templates and roles use the given
+// configuration variables, or all of them. With an output file, the complete
+// index of the role manifest is written to it as JSON, for other tools.
+func (f *Fissile) ShowVariableUsage(roleManifestPath string, variables []string, outputFile, outputFormat string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+	usage := roleManifest.VariableUsage()
+
+	if outputFile != "" {
+		contents, err := json.MarshalIndent(usage, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(outputFile, append(contents, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	report, err := selectVariableUsage(usage, variables)
+	if err != nil {
+		return err
+	}
+
+	return f.printReport(report, outputFormat, func() {
+		var names []string
+		for name := range report.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			uses := report.Variables[name]
+			f.UI.Printf("%s:\n", color.GreenString(name))
+			if len(uses.Templates) > 0 {
+				f.UI.Printf("  templates: %s\n", strings.Join(uses.Templates, ", "))
+			}
+			if len(uses.Roles) > 0 {
+				f.UI.Printf("  roles: %s\n", strings.Join(uses.Roles, ", "))
+			}
+		}
+	})
+}
+
+// selectVariableUsage narrows the index down to the given variables and the
+// templates using them; all of it is returned if no variables are given
+func selectVariableUsage(usage *model.VariableUsage, variables []string) (*model.VariableUsage, error) {
+	if len(variables) == 0 {
+		return usage, nil
+	}
+
+	selected := &model.VariableUsage{
+		Variables: make(map[string]*model.VariableUses, len(variables)),
+		Templates: make(map[string][]string),
+	}
+
+	var unknown []string
+	for _, name := range variables {
+		uses, ok := usage.Variables[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		selected.Variables[name] = uses
+		for _, template := range uses.Templates {
+			selected.Templates[template] = usage.Templates[template]
+		}
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("Some variables are unknown: %v", unknown)
+	}
+
+	return selected, nil
+}
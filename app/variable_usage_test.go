@@ -0,0 +1,68 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/termui"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowVariableUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	releasePathCacheDir := filepath.Join(releasePath, "bosh-cache")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/variable-usage.yml")
+
+	buffer := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, buffer, nil))
+
+	err = f.ShowVariableUsage(roleManifestPath, nil, "", "human")
+	assert.EqualError(err, "Releases not loaded")
+
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	outputDir, err := ioutil.TempDir("", "fissile-variable-usage-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(outputDir)
+	outputFile := filepath.Join(outputDir, "variable-usage.json")
+
+	err = f.ShowVariableUsage(roleManifestPath, []string{"DOMAIN"}, outputFile, "human")
+	if assert.NoError(err) {
+		assert.Contains(buffer.String(), "DOMAIN:\n  templates: otherrole/properties.tor.hostname\n  roles: otherrole\n")
+		assert.NotContains(buffer.String(), "HOSTNAME")
+	}
+
+	// The output file holds the complete index
+	contents, err := ioutil.ReadFile(outputFile)
+	if assert.NoError(err) {
+		var usage model.VariableUsage
+		if assert.NoError(json.Unmarshal(contents, &usage)) {
+			assert.Len(usage.Variables, 4)
+			assert.Equal([]string{"HOSTNAME"}, usage.Templates["properties.tor.hostname"])
+		}
+	}
+
+	buffer.Reset()
+	err = f.ShowVariableUsage(roleManifestPath, []string{"SHIPPER_TOKEN"}, "", "yaml")
+	if assert.NoError(err) {
+		assert.Equal("variables:\n  SHIPPER_TOKEN:\n    templates: []\n    roles:\n    - myrole\ntemplates: {}\n", buffer.String())
+	}
+
+	err = f.ShowVariableUsage(roleManifestPath, []string{"NOPE"}, "", "human")
+	assert.EqualError(err, "Some variables are unknown: [NOPE]")
+}
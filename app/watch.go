@@ -0,0 +1,216 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// releaseWatchedDirs are the directories of releases watched for changes;
+// they change when a release is created again, such as after its job
+// templates changed
+var releaseWatchedDirs = []string{"config", "dev_releases", "releases"}
+
+// fileSnapshot records the size and modification time of watched files, by
+// path; files missing are recorded as such, to notice them being created
+type fileSnapshot map[string]string
+
+// takeFileSnapshot records the state of the given files, and of all the files
+// within the given directories
+func takeFileSnapshot(paths []string) (fileSnapshot, error) {
+	snapshot := make(fileSnapshot)
+	for _, path := range paths {
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				snapshot[path] = "missing"
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				snapshot[path] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// changedFiles returns the paths of the files created, changed or removed
+// since an earlier snapshot, sorted
+func (s fileSnapshot) changedFiles(earlier fileSnapshot) []string {
+	var changed []string
+	for path, state := range s {
+		if earlier[path] != state {
+			changed = append(changed, path)
+		}
+	}
+	for path := range earlier {
+		if _, ok := s[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// watchedPaths returns the files and directories role images are built from
+// which are watched: the role manifest and the given extra paths, the scripts
+// and Dockerfile snippets of the roles, and the indexes of the releases
+func (f *Fissile) watchedPaths(rolesManifestPath string, roles model.Roles, extraPaths []string) []string {
+	paths := append([]string{rolesManifestPath}, extraPaths...)
+	for _, role := range roles {
+		for _, scriptPath := range role.GetScriptPaths() {
+			paths = append(paths, scriptPath)
+		}
+		pre, post := role.GetImageSnippetPaths()
+		paths = append(paths, pre...)
+		paths = append(paths, post...)
+	}
+	for _, release := range f.releases {
+		if release.Path == "" || release.FinalRelease {
+			continue
+		}
+		for _, dir := range releaseWatchedDirs {
+			paths = append(paths, filepath.Join(release.Path, dir))
+		}
+	}
+
+	// Scripts may be shared by roles
+	seen := make(map[string]bool, len(paths))
+	unique := paths[:0]
+	for _, path := range paths {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
+
+// roleDevVersions loads the role manifest and returns the selected roles
+// and their dev versions, by role name
+func (f *Fissile) roleDevVersions(rolesManifestPath string, roleNames []string) (model.Roles, map[string]string, error) {
+	roleManifest, err := f.loadRoleManifest(rolesManifestPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+	roles, err := roleManifest.SelectRoles(roleNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versions := make(map[string]string, len(roles))
+	for _, role := range roles {
+		if versions[role.Name], err = role.GetRoleDevVersion(); err != nil {
+			return nil, nil, fmt.Errorf("Error calculating checksum for role %s: %s", role.Name, err.Error())
+		}
+	}
+	return roles, versions, nil
+}
+
+// WatchRoleImages builds the images of the selected roles, then watches the
+// files they are built from: the role manifest, the extra paths given, such
+// as the opinions, the role scripts and Dockerfile snippets, and the indexes
+// of the dev releases. The files are checked at each interval; once they
+// changed, the releases are reloaded, and the roles whose dev version changed
+// are built again. Failures to load or build are reported, and watching
+// goes on. It returns once stop is closed.
+func (f *Fissile) WatchRoleImages(rolesManifestPath string, roleNames, extraPaths []string, interval time.Duration, reload func() error, build func(roleNames []string) error, stop <-chan struct{}) error {
+	if interval <= 0 {
+		return fmt.Errorf("Invalid watch interval %s", interval)
+	}
+
+	roles, versions, err := f.roleDevVersions(rolesManifestPath, roleNames)
+	if err != nil {
+		return err
+	}
+
+	// Files changed during the first build are noticed
+	paths := f.watchedPaths(rolesManifestPath, roles, extraPaths)
+	snapshot, err := takeFileSnapshot(paths)
+	if err != nil {
+		return err
+	}
+
+	if err := build(roleNames); err != nil {
+		f.UI.Println(color.RedString("%s", err))
+		// All roles are built again at the next change; those built
+		// already are skipped
+		versions = map[string]string{}
+	}
+	f.UI.Printf("Watching %s files for changes\n", color.YellowString("%d", len(snapshot)))
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+
+		current, err := takeFileSnapshot(paths)
+		if err != nil {
+			return err
+		}
+		changed := current.changedFiles(snapshot)
+		if len(changed) == 0 {
+			continue
+		}
+		snapshot = current
+		f.UI.Printf("Changed: %s\n", color.YellowString(strings.Join(changed, ", ")))
+
+		var newRoles model.Roles
+		var newVersions map[string]string
+		err = reload()
+		if err == nil {
+			newRoles, newVersions, err = f.roleDevVersions(rolesManifestPath, roleNames)
+		}
+		if err != nil {
+			// Likely an edit in progress; the next change will tell
+			f.UI.Println(color.RedString("%s", err))
+			continue
+		}
+
+		var outdated []string
+		for _, role := range newRoles {
+			if versions[role.Name] != newVersions[role.Name] {
+				outdated = append(outdated, role.Name)
+			}
+		}
+
+		// Scripts and snippets may have been added or removed. The files
+		// watched already keep their earlier state, so that changes made
+		// since are noticed.
+		paths = f.watchedPaths(rolesManifestPath, newRoles, extraPaths)
+		if snapshot, err = takeFileSnapshot(paths); err != nil {
+			return err
+		}
+		for path := range snapshot {
+			if state, ok := current[path]; ok {
+				snapshot[path] = state
+			}
+		}
+
+		if len(outdated) == 0 {
+			f.UI.Println("No role changed")
+			continue
+		}
+		f.UI.Printf("Building the roles which changed: %s\n", color.YellowString(strings.Join(outdated, ", ")))
+		if err := build(outdated); err != nil {
+			f.UI.Println(color.RedString("%s", err))
+			continue
+		}
+		versions = newVersions
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/fissile/util"
+	"github.com/hpcloud/termui"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := util.TempDir("", "fissile-watch-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	subdir := filepath.Join(dir, "subdir")
+	assert.NoError(ioutil.WriteFile(file, []byte("a"), 0644))
+	assert.NoError(os.Mkdir(subdir, 0755))
+	missing := filepath.Join(dir, "missing")
+
+	paths := []string{file, subdir, missing}
+	before, err := takeFileSnapshot(paths)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(fileSnapshot{file: before[file], missing: "missing"}, before)
+
+	assert.NoError(ioutil.WriteFile(file, []byte("ab"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(subdir, "new"), []byte("c"), 0644))
+	assert.NoError(ioutil.WriteFile(missing, []byte("d"), 0644))
+
+	after, err := takeFileSnapshot(paths)
+	if assert.NoError(err) {
+		assert.Equal([]string{file, missing, filepath.Join(subdir, "new")}, after.changedFiles(before))
+		assert.Empty(after.changedFiles(after))
+	}
+}
+
+func TestWatchRoleImages(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+
+	dir, err := util.TempDir("", "fissile-watch-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// The role manifest and its scripts are copied, to edit them
+	for _, name := range []string{"tor-good.yml", "environ.sh", "myrole.sh", "post_config_script.sh"} {
+		contents, err := ioutil.ReadFile(filepath.Join(workDir, "../test-assets/role-manifests", name))
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), contents, 0755))
+	}
+	roleManifestPath := filepath.Join(dir, "tor-good.yml")
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	builds := make(chan []string, 10)
+	reloads := 0
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- f.WatchRoleImages(roleManifestPath, nil, nil, 10*time.Millisecond,
+			func() error { reloads++; return nil },
+			func(roleNames []string) error { builds <- roleNames; return nil },
+			stop)
+	}()
+
+	nextBuild := func() []string {
+		select {
+		case roleNames := <-builds:
+			return roleNames
+		case <-time.After(5 * time.Second):
+			return []string{"timed out"}
+		}
+	}
+
+	assert.Nil(nextBuild())
+
+	// Only the role using the script is built again
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "myrole.sh"), []byte("#!/bin/bash\necho changed\n"), 0755))
+	assert.Equal([]string{"myrole"}, nextBuild())
+
+	close(stop)
+	assert.NoError(<-done)
+	assert.Equal(1, reloads)
+}
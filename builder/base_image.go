@@ -5,7 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"path/filepath"
+	"path"
 	"strings"
 	"text/template"
 
@@ -41,12 +41,14 @@ func (b *BaseImageBuilder) NewDockerPopulator() func(*tar.Writer) error {
 			return err
 		}
 
-		// Add rsyslog_conf, monitrc.erb, and the post-start handler.
+		// Add rsyslog_conf, monitrc.erb, the post-start handler, and the
+		// Vault secrets reader.
 		for _, assetName := range dockerfiles.AssetNames() {
 			switch {
 			case strings.HasPrefix(assetName, "rsyslog_conf/"):
 			case assetName == "monitrc.erb":
 			case assetName == "post-start.sh":
+			case assetName == "vault-secrets.sh":
 			default:
 				continue
 			}
@@ -71,7 +73,7 @@ func (b *BaseImageBuilder) NewDockerPopulator() func(*tar.Writer) error {
 			"configgin.tgz",
 			bytes.NewReader(configginGzip),
 			func(reader *tar.Reader, header *tar.Header) error {
-				header.Name = filepath.Join("configgin", header.Name)
+				header.Name = path.Join("configgin", header.Name)
 				if err = tarWriter.WriteHeader(header); err != nil {
 					return err
 				}
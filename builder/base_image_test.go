@@ -59,6 +59,9 @@ func TestBaseImageNewDockerPopulator(t *testing.T) {
 		"monitrc.erb": func(rawContents []byte) {
 			assert.Contains(string(rawContents), "fissile.monit.password")
 		},
+		"vault-secrets.sh": func(rawContents []byte) {
+			assert.Contains(string(rawContents), "VAULT_SECRETS_PATH")
+		},
 	}
 
 	tarReader := tar.NewReader(buffer)
@@ -0,0 +1,226 @@
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BuildKitBuilder is the name of the BuildKit builder, which keeps a build
+// cache
+const BuildKitBuilder = "buildkit"
+
+// externalBuild describes an image built by an external builder
+type externalBuild struct {
+	contextDir     string // Holds the Dockerfile
+	imageName      string
+	cacheDirectory string // Holds the build cache of the image; none if empty
+}
+
+// externalBuilderArgs returns the arguments of the commands building an
+// image, by builder name
+var externalBuilderArgs = map[string]func(build externalBuild) []string{
+	"buildah": func(build externalBuild) []string {
+		return []string{"bud", "--tag", build.imageName, build.contextDir}
+	},
+	BuildKitBuilder: func(build externalBuild) []string {
+		args := []string{
+			"build",
+			"--frontend", "dockerfile.v0",
+			"--local", "context=" + build.contextDir,
+			"--local", "dockerfile=" + build.contextDir,
+			"--output", "type=image,name=" + build.imageName + ",push=true",
+		}
+		if build.cacheDirectory != "" {
+			args = append(args,
+				"--export-cache", "type=local,mode=max,dest="+build.cacheDirectory,
+				"--import-cache", "type=local,src="+build.cacheDirectory,
+			)
+		}
+		return args
+	},
+	"img": func(build externalBuild) []string {
+		return []string{"build", "--tag", build.imageName, build.contextDir}
+	},
+	"kaniko": func(build externalBuild) []string {
+		return []string{
+			"--dockerfile", filepath.Join(build.contextDir, "Dockerfile"),
+			"--context", "dir://" + build.contextDir,
+			"--destination", build.imageName,
+		}
+	},
+}
+
+// externalBuilderCommands are the default executables of the builders
+var externalBuilderCommands = map[string]string{
+	"buildah":       "buildah",
+	BuildKitBuilder: "buildctl",
+	"img":           "img",
+	"kaniko":        "/kaniko/executor",
+}
+
+// ExternalBuilderNames returns the names of the builders which don't need a
+// docker daemon
+func ExternalBuilderNames() []string {
+	var names []string
+	for name := range externalBuilderArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExternalImageBuilder builds images with a tool which doesn't need a docker
+// daemon, such as kaniko, BuildKit, buildah, or img. The build context is
+// extracted to a temporary directory the tool is run on. Where the images end
+// up depends on the tool: kaniko and BuildKit push them to the registry of
+// their name.
+type ExternalImageBuilder struct {
+	name           string
+	command        string
+	cacheDirectory string
+}
+
+// NewExternalImageBuilder returns a builder running the named tool. The
+// command is the path of its executable, the usual one if empty.
+func NewExternalImageBuilder(name, command string) (*ExternalImageBuilder, error) {
+	if _, ok := externalBuilderArgs[name]; !ok {
+		return nil, fmt.Errorf("Invalid builder '%s', expected one of %s",
+			name, strings.Join(ImageBuilderNames(), ", "))
+	}
+	if command == "" {
+		command = externalBuilderCommands[name]
+	}
+	return &ExternalImageBuilder{name: name, command: command}, nil
+}
+
+// Name returns the name of the tool images are built with
+func (b *ExternalImageBuilder) Name() string {
+	return b.name
+}
+
+// SetCacheDirectory sets the directory BuildKit exports the build cache of
+// each image to, and imports it from at the next build. Each image repository
+// has a cache of its own, so that concurrent builds don't write the same one.
+func (b *ExternalImageBuilder) SetCacheDirectory(directory string) {
+	b.cacheDirectory = directory
+}
+
+// HasImage always returns false: external builders have no local images to
+// look at, so images are always built, relying on the caching of the tool.
+func (b *ExternalImageBuilder) HasImage(imageName string) (bool, error) {
+	return false, nil
+}
+
+// BuildImage builds an image from a directory holding its Dockerfile
+func (b *ExternalImageBuilder) BuildImage(dockerfileDirPath, name string, stdoutWriter io.WriteCloser) error {
+	defer stdoutWriter.Close()
+	return b.run(dockerfileDirPath, name, stdoutWriter)
+}
+
+// BuildImageFromCallback builds an image from the build context the callback
+// writes into a tar stream
+func (b *ExternalImageBuilder) BuildImageFromCallback(name string, stdoutWriter io.Writer, callback func(*tar.Writer) error) error {
+	contextDir, err := ioutil.TempDir("", "fissile-build-context-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(contextDir)
+
+	reader, writer := io.Pipe()
+	go func() {
+		tarWriter := tar.NewWriter(writer)
+		err := callback(tarWriter)
+		if err == nil {
+			err = tarWriter.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	err = extractBuildContext(reader, contextDir)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("Error writing the build context of %s: %s", name, err)
+	}
+
+	return b.run(contextDir, name, stdoutWriter)
+}
+
+// run runs the tool on a context directory
+func (b *ExternalImageBuilder) run(contextDir, name string, output io.Writer) error {
+	build := externalBuild{contextDir: contextDir, imageName: name}
+	if b.cacheDirectory != "" {
+		build.cacheDirectory = filepath.Join(b.cacheDirectory, imageCacheName(name))
+	}
+
+	command := exec.Command(b.command, externalBuilderArgs[b.name](build)...)
+	command.Stdout = output
+	command.Stderr = output
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("Error running %s: %s", b.name, err)
+	}
+	return nil
+}
+
+// imageCacheName returns the name of the build cache of an image: its
+// repository, without the tag, as a file name
+func imageCacheName(imageName string) string {
+	if i := strings.LastIndex(imageName, ":"); i > strings.LastIndex(imageName, "/") {
+		imageName = imageName[:i]
+	}
+	return strings.NewReplacer("/", "_", ":", "_").Replace(imageName)
+}
+
+// extractBuildContext extracts a build context tar stream into dir
+func extractBuildContext(context io.Reader, dir string) error {
+	reader := tar.NewReader(context)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, header.Name)
+		if path != filepath.Clean(dir) && !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("Invalid file %s in build context", header.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(file, reader); err != nil {
+				file.Close()
+				return err
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unsupported file %s in build context", header.Name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExternalImageBuilder(t *testing.T) {
+	assert := assert.New(t)
+
+	externalBuilder, err := NewExternalImageBuilder("kaniko", "")
+	if assert.NoError(err) {
+		assert.Equal("kaniko", externalBuilder.Name())
+		assert.Equal("/kaniko/executor", externalBuilder.command)
+		hasImage, err := externalBuilder.HasImage("fissile-myrole:1234")
+		assert.NoError(err)
+		assert.False(hasImage, "External builders always build")
+	}
+
+	_, err = NewExternalImageBuilder("podman", "")
+	assert.EqualError(err, "Invalid builder 'podman', expected one of docker, buildah, buildkit, img, kaniko")
+}
+
+func TestExternalImageBuilderBuildImageFromCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	tempDir, err := ioutil.TempDir("", "fissile-external-builder-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The fake img prints the Dockerfile of the context and its arguments
+	command := filepath.Join(tempDir, "img")
+	script := "#!/bin/sh\ncat \"$4/Dockerfile\"\necho \"$1 $2 $3\"\ntest -x \"$4/root/opt/hcf/run.sh\"\n"
+	if !assert.NoError(ioutil.WriteFile(command, []byte(script), 0755)) {
+		return
+	}
+
+	externalBuilder, err := NewExternalImageBuilder("img", command)
+	if !assert.NoError(err) {
+		return
+	}
+
+	output := &bytes.Buffer{}
+	err = externalBuilder.BuildImageFromCallback("fissile-myrole:1234", output, func(tarWriter *tar.Writer) error {
+		if err := util.WriteToTarStream(tarWriter, []byte("FROM scratch\n"), tar.Header{Name: "Dockerfile"}); err != nil {
+			return err
+		}
+		return util.WriteToTarStream(tarWriter, []byte("#!/bin/sh\n"), tar.Header{Name: "root/opt/hcf/run.sh", Mode: 0755})
+	})
+	if assert.NoError(err, output.String()) {
+		assert.Equal("FROM scratch\nbuild --tag fissile-myrole:1234\n", output.String())
+	}
+
+	err = externalBuilder.BuildImageFromCallback("fissile-myrole:1234", output, func(tarWriter *tar.Writer) error {
+		return util.WriteToTarStream(tarWriter, []byte("FROM scratch\n"), tar.Header{Name: "../Dockerfile"})
+	})
+	assert.EqualError(err, "Error writing the build context of fissile-myrole:1234: Invalid file ../Dockerfile in build context")
+
+	err = externalBuilder.BuildImageFromCallback("fissile-myrole:1234", output, func(tarWriter *tar.Writer) error {
+		return util.WriteToTarStream(tarWriter, []byte("FROM scratch\n"), tar.Header{Name: "Dockerfile"})
+	})
+	assert.EqualError(err, "Error running img: exit status 1")
+}
+
+func TestBuildKitImageBuilderCache(t *testing.T) {
+	assert := assert.New(t)
+
+	tempDir, err := ioutil.TempDir("", "fissile-external-builder-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The fake buildctl prints its arguments, but the context directory
+	command := filepath.Join(tempDir, "buildctl")
+	script := "#!/bin/sh\nfor arg; do case \"$arg\" in context=*|dockerfile=*) echo \"${arg%%=*}=\";; *) echo \"$arg\";; esac; done\n"
+	if !assert.NoError(ioutil.WriteFile(command, []byte(script), 0755)) {
+		return
+	}
+
+	externalBuilder, err := NewExternalImageBuilder(BuildKitBuilder, command)
+	if !assert.NoError(err) {
+		return
+	}
+	externalBuilder.SetCacheDirectory("/cache")
+
+	output := &bytes.Buffer{}
+	err = externalBuilder.BuildImageFromCallback("registry:5000/fissile-myrole:1234", output, func(tarWriter *tar.Writer) error {
+		return util.WriteToTarStream(tarWriter, []byte("FROM scratch\n"), tar.Header{Name: "Dockerfile"})
+	})
+	if assert.NoError(err, output.String()) {
+		assert.Equal(strings.Join([]string{
+			"build",
+			"--frontend", "dockerfile.v0",
+			"--local", "context=",
+			"--local", "dockerfile=",
+			"--output", "type=image,name=registry:5000/fissile-myrole:1234,push=true",
+			"--export-cache", "type=local,mode=max,dest=/cache/registry_5000_fissile-myrole",
+			"--import-cache", "type=local,src=/cache/registry_5000_fissile-myrole",
+		}, "\n")+"\n", output.String())
+	}
+}
+
+func TestNewImageBuilder(t *testing.T) {
+	assert := assert.New(t)
+
+	imageBuilder, err := NewImageBuilder(DockerBuilder, "")
+	if assert.NoError(err) {
+		assert.IsType(&docker.ImageManager{}, imageBuilder)
+	}
+
+	imageBuilder, err = NewImageBuilder(BuildKitBuilder, "")
+	if assert.NoError(err) {
+		assert.Equal("buildctl", imageBuilder.(*ExternalImageBuilder).command)
+	}
+}
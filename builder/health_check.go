@@ -0,0 +1,149 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+const (
+	// healthCheckJobName is the configgin job rendering the health checks
+	// with templates, when the container starts
+	healthCheckJobName = "fissile-healthcheck"
+	// healthCheckDir holds the spec and templates of the health check job
+	healthCheckDir = "/opt/hcf/healthcheck"
+)
+
+// curlArgs returns the arguments of curl checking a URL health check, but the
+// URL itself
+func curlArgs(probe *model.HealthProbe) []string {
+	args := []string{"--fail", "--silent", "--output", "/dev/null"}
+	keys := make([]string, 0, len(probe.Headers))
+	for key := range probe.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--header", fmt.Sprintf("%s: %s", key, probe.Headers[key]))
+	}
+	return args
+}
+
+// templatedHealthChecks returns the health checks of a role with templates,
+// and their kinds, in the order of HealthCheck.Probes
+func templatedHealthChecks(role *model.Role) ([]string, []*model.HealthProbe) {
+	var kinds []string
+	var probes []*model.HealthProbe
+	if role.Run == nil {
+		return kinds, probes
+	}
+	allKinds, allProbes := role.Run.HealthCheck.Probes()
+	for index, probe := range allProbes {
+		if probe.IsTemplated() {
+			kinds = append(kinds, allKinds[index])
+			probes = append(probes, probe)
+		}
+	}
+	return kinds, probes
+}
+
+// hasTemplatedHealthChecks tests whether a role has health checks with
+// templates
+func hasTemplatedHealthChecks(role *model.Role) bool {
+	kinds, _ := templatedHealthChecks(role)
+	return len(kinds) > 0
+}
+
+// healthCheckProperty returns the name of the property of the health check
+// job a health check template is rendered into
+func healthCheckProperty(kind, setting string) string {
+	return fmt.Sprintf("fissile.healthcheck.%s.%s", kind, setting)
+}
+
+// healthCheckTemplates returns the env2conf templates of the health checks
+// of a role, by property
+func healthCheckTemplates(role *model.Role) map[string]string {
+	templates := make(map[string]string)
+	kinds, probes := templatedHealthChecks(role)
+	for i, probe := range probes {
+		kind := kinds[i]
+		if probe.URL != "" {
+			templates["properties."+healthCheckProperty(kind, "url")] = probe.URL
+		}
+		for index, arg := range probe.Command {
+			templates["properties."+healthCheckProperty(kind, fmt.Sprintf("command.%d", index))] = arg
+		}
+	}
+	return templates
+}
+
+// shellQuote quotes a word for bash, escaping the ERB tags it holds
+func shellQuote(word string) string {
+	word = strings.Replace(word, "<%", "<%%", -1)
+	return "'" + strings.Replace(word, "'", `'"'"'`, -1) + "'"
+}
+
+// erbShellQuote returns the ERB quoting the value of a property of the health
+// check job for bash, through the given Ruby filter
+func erbShellQuote(property, filter string) string {
+	return fmt.Sprintf(`'<%%= p(%q).to_s%s.gsub("'", %%q('"'"')) %%>'`, property, filter)
+}
+
+// generateHealthCheckTemplate returns the ERB template of the script a health
+// check with templates is rendered into: it checks the URL with curl, from
+// inside the container, or runs the command
+func generateHealthCheckTemplate(role *model.Role, kind string, probe *model.HealthProbe) []byte {
+	var words []string
+	if probe.URL != "" {
+		for _, arg := range append([]string{"curl"}, curlArgs(probe)...) {
+			words = append(words, shellQuote(arg))
+		}
+		// The check runs inside the container
+		words = append(words, erbShellQuote(healthCheckProperty(kind, "url"),
+			`.sub(%r{\A(?<prefix>\w+://([^@/]*@)?)container-ip(?=[:/]|\z)}, '\k<prefix>127.0.0.1')`))
+	} else {
+		for index := range probe.Command {
+			words = append(words, erbShellQuote(healthCheckProperty(kind, fmt.Sprintf("command.%d", index)), ""))
+		}
+	}
+
+	var script bytes.Buffer
+	fmt.Fprintf(&script, "#!/bin/bash\n# The %s health check of %s, rendered when the container starts\n", kind, role.Name)
+	fmt.Fprintf(&script, "exec %s\n", strings.Join(words, " "))
+	return script.Bytes()
+}
+
+// generateHealthCheckSpec returns the configgin spec of the health check job
+// of a role; the properties are those of env2conf
+func generateHealthCheckSpec(role *model.Role) ([]byte, error) {
+	spec := map[string]interface{}{
+		"job": map[string]interface{}{
+			"name":      role.Name,
+			"templates": []interface{}{},
+		},
+		"parameters": map[string]interface{}{},
+		"properties": map[string]interface{}{},
+		"links":      map[string]interface{}{},
+	}
+	return json.MarshalIndent(spec, "", "    ")
+}
+
+// healthCheckJobConfig returns the configgin configuration of the health
+// check job of a role, rendering each health check with templates into its
+// script
+func healthCheckJobConfig(role *model.Role) map[string]interface{} {
+	files := make(map[string]string)
+	kinds, _ := templatedHealthChecks(role)
+	for _, kind := range kinds {
+		files[path.Join(healthCheckDir, kind+".sh.erb")] = model.HealthCheckScript(kind)
+	}
+	return map[string]interface{}{
+		"base":  path.Join(healthCheckDir, "config_spec.json"),
+		"files": files,
+	}
+}
@@ -0,0 +1,38 @@
+package builder
+
+import (
+	"archive/tar"
+	"io"
+
+	"github.com/hpcloud/fissile/docker"
+)
+
+// DockerBuilder is the name of the default builder, the docker daemon
+const DockerBuilder = "docker"
+
+// ImageBuilder builds images from a build context holding their Dockerfile:
+// the docker daemon, through docker.ImageManager, or an external builder
+type ImageBuilder interface {
+	// HasImage tells whether the image is built already
+	HasImage(imageName string) (bool, error)
+	// BuildImage builds an image from a directory holding its Dockerfile
+	BuildImage(dockerfileDirPath, name string, stdoutProcessor io.WriteCloser) error
+	// BuildImageFromCallback builds an image from the build context the
+	// callback writes into a tar stream
+	BuildImageFromCallback(name string, stdoutWriter io.Writer, callback func(*tar.Writer) error) error
+}
+
+// ImageBuilderNames returns the names of the image builders, the docker
+// daemon first
+func ImageBuilderNames() []string {
+	return append([]string{DockerBuilder}, ExternalBuilderNames()...)
+}
+
+// NewImageBuilder returns the named image builder. The command is the path of
+// the executable of external builders, the usual one if empty.
+func NewImageBuilder(name, command string) (ImageBuilder, error) {
+	if name == "" || name == DockerBuilder {
+		return docker.NewImageManager()
+	}
+	return NewExternalImageBuilder(name, command)
+}
@@ -3,11 +3,15 @@ package builder
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/hpcloud/fissile/docker"
@@ -50,7 +54,7 @@ type tarWalker struct {
 	prefix string      // The prefix in the tar file the names should have
 }
 
-func (w *tarWalker) walk(path string, info os.FileInfo, err error) error {
+func (w *tarWalker) walk(hostPath string, info os.FileInfo, err error) error {
 	if err != nil {
 		return err
 	}
@@ -61,19 +65,20 @@ func (w *tarWalker) walk(path string, info os.FileInfo, err error) error {
 	}
 
 	if (info.Mode() & os.ModeSymlink) != 0 {
-		linkname, err := os.Readlink(path)
+		linkname, err := os.Readlink(hostPath)
 		if err != nil {
 			return err
 		}
 		header.Linkname = linkname
 	}
 
-	relPath, err := filepath.Rel(w.root, path)
+	relPath, err := filepath.Rel(w.root, hostPath)
 	if err != nil {
 		return err
 	}
 
-	header.Name = filepath.Join(w.prefix, relPath)
+	header.Name = path.Join(w.prefix, filepath.ToSlash(relPath))
+	util.SetHostPermissions(header)
 	if err := w.stream.WriteHeader(header); err != nil {
 		return err
 	}
@@ -82,7 +87,7 @@ func (w *tarWalker) walk(path string, info os.FileInfo, err error) error {
 		return nil
 	}
 
-	file, err := os.Open(path)
+	file, err := os.Open(hostPath)
 	if err != nil {
 		return err
 	}
@@ -95,8 +100,7 @@ func (w *tarWalker) walk(path string, info os.FileInfo, err error) error {
 // determinePackagesLayerBaseImage finds the best base image to use for the
 // packages layer image.  Given a list of packages, it returns the base image
 // name to use, as well as the set of packages that still need to be inserted.
-func (p *PackagesImageBuilder) determinePackagesLayerBaseImage(packages model.Packages) (string, model.Packages, error) {
-	baseImageName := GetBaseImageName(p.repository, p.fissileVersion)
+func (p *PackagesImageBuilder) determinePackagesLayerBaseImage(baseImageName string, packages model.Packages) (string, model.Packages, error) {
 	if baseImageOverride != "" {
 		baseImageName = baseImageOverride
 	}
@@ -127,12 +131,15 @@ func (p *PackagesImageBuilder) determinePackagesLayerBaseImage(packages model.Pa
 		delete(remainingPackages, parts[1])
 	}
 
-	packages = make(model.Packages, 0, len(remainingPackages))
-	for _, pkg := range remainingPackages {
-		packages = append(packages, pkg)
+	// Keep the order of the packages, so the Dockerfile doesn't change
+	remaining := make(model.Packages, 0, len(remainingPackages))
+	for _, pkg := range packages {
+		if _, ok := remainingPackages[pkg.Fingerprint]; ok {
+			remaining = append(remaining, pkg)
+		}
 	}
 
-	return matchedImage, packages, nil
+	return matchedImage, remaining, nil
 }
 
 // NewDockerPopulator returns a function which can populate a tar stream with the docker context to build the packages layer image with
@@ -143,27 +150,13 @@ func (p *PackagesImageBuilder) NewDockerPopulator(roles model.Roles, forceBuildA
 			return fmt.Errorf("No roles to build")
 		}
 
-		// Collect compiled packages
-		foundFingerprints := make(map[string]struct{})
-		var packages model.Packages
-		for _, role := range roles {
-			for _, job := range role.Jobs {
-				for _, pkg := range job.Packages {
-					if _, ok := foundFingerprints[pkg.Fingerprint]; ok {
-						// Package has already been found (possibly due to a different role)
-						continue
-					}
-					packages = append(packages, pkg)
-					foundFingerprints[pkg.Fingerprint] = struct{}{}
-				}
-			}
-		}
+		packages := collectPackages(roles)
 
 		// Generate dockerfile
 		dockerfile := bytes.Buffer{}
-		baseImageName := GetBaseImageName(p.repository, p.fissileVersion)
+		baseImageName := p.BaseImageName(roles)
 		if !forceBuildAll {
-			baseImageName, packages, err = p.determinePackagesLayerBaseImage(packages)
+			baseImageName, packages, err = p.determinePackagesLayerBaseImage(baseImageName, packages)
 			if err != nil {
 				return err
 			}
@@ -193,7 +186,7 @@ func (p *PackagesImageBuilder) NewDockerPopulator(roles model.Roles, forceBuildA
 			walker := &tarWalker{
 				stream: tarWriter,
 				root:   pkg.GetPackageCompiledDir(p.compiledPackagesPath),
-				prefix: filepath.Join("packages-src", pkg.Fingerprint),
+				prefix: path.Join("packages-src", pkg.Fingerprint),
 			}
 			if err = filepath.Walk(walker.root, walker.walk); err != nil {
 				return err
@@ -228,15 +221,90 @@ func (p *PackagesImageBuilder) generateDockerfile(baseImage string, packages mod
 	return nil
 }
 
-// GetRolePackageImageName generates a docker image name for the amalgamation for a role image
-func (p *PackagesImageBuilder) GetRolePackageImageName(roleManifest *model.RoleManifest, roles model.Roles) (string, error) {
-	rmVersion, err := roleManifest.GetRoleManifestDevPackageVersion(roles, p.fissileVersion)
-	if err != nil {
-		return "", err
+// collectPackages returns the compiled packages used by the given roles,
+// without duplicates (packages are commonly shared between roles).
+func collectPackages(roles model.Roles) model.Packages {
+	foundFingerprints := make(map[string]struct{})
+	var packages model.Packages
+	for _, role := range roles {
+		for _, job := range role.Jobs {
+			for _, pkg := range job.Packages {
+				if _, ok := foundFingerprints[pkg.Fingerprint]; ok {
+					// Package has already been found (possibly due to a different role)
+					continue
+				}
+				packages = append(packages, pkg)
+				foundFingerprints[pkg.Fingerprint] = struct{}{}
+			}
+		}
+	}
+	return packages
+}
+
+// packageFingerprints returns the sorted fingerprints of the compiled
+// packages used by the given roles.
+func packageFingerprints(roles model.Roles) []string {
+	packages := collectPackages(roles)
+	fingerprints := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		fingerprints = append(fingerprints, pkg.Fingerprint)
+	}
+	sort.Strings(fingerprints)
+	return fingerprints
+}
+
+// BaseImageName returns the name of the image the packages layer of the roles
+// is built on: the base image of the roles if they set one, or else the role
+// base image. The roles of a group from GroupRolesByPackages share it.
+func (p *PackagesImageBuilder) BaseImageName(roles model.Roles) string {
+	if len(roles) > 0 && roles[0].BaseImage != "" {
+		return roles[0].BaseImage
+	}
+	return GetBaseImageName(p.repository, p.fissileVersion)
+}
+
+// GroupRolesByPackages splits the roles into groups of roles using exactly the
+// same set of compiled packages and the same base image. Each group gets a
+// packages layer image of its own, which the role images of the group are
+// built on. Groups are ordered by their first role, and keep the order of the
+// roles within them.
+func GroupRolesByPackages(roles model.Roles) []model.Roles {
+	var groups []model.Roles
+	groupIndex := make(map[string]int)
+	for _, role := range roles {
+		key := role.BaseImage + "\n" + strings.Join(packageFingerprints(model.Roles{role}), "\n")
+		index, ok := groupIndex[key]
+		if !ok {
+			index = len(groups)
+			groupIndex[key] = index
+			groups = append(groups, nil)
+		}
+		groups[index] = append(groups[index], role)
+	}
+	return groups
+}
+
+// GetRolePackageImageName generates a docker image name for the amalgamation for a role image.
+// The name is keyed off the set of package fingerprints and the base image of
+// the roles only; changes to jobs, scripts or templates of the roles do not
+// require a new packages layer, and role selections using the same packages
+// share the same layer.
+func (p *PackagesImageBuilder) GetRolePackageImageName(roles model.Roles) (string, error) {
+	fingerprints := packageFingerprints(roles)
+
+	hasher := sha1.New()
+	hasher.Write([]byte(p.fissileVersion))
+	if len(roles) > 0 && roles[0].BaseImage != "" {
+		hasher.Write([]byte("\nbase-image:"))
+		hasher.Write([]byte(roles[0].BaseImage))
+	}
+	for _, fingerprint := range fingerprints {
+		hasher.Write([]byte("\n"))
+		hasher.Write([]byte(fingerprint))
 	}
 
 	return util.SanitizeDockerName(fmt.Sprintf("%s-role-packages:%s",
 		p.repository,
-		rmVersion,
+		hex.EncodeToString(hasher.Sum(nil)),
 	)), nil
 }
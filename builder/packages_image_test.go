@@ -165,3 +165,77 @@ func TestNewDockerPopulator(t *testing.T) {
 	}
 	assert.Empty(testFunctions, "Missing files in tar stream")
 }
+
+func TestGetRolePackageImageName(t *testing.T) {
+	assert := assert.New(t)
+
+	ui := termui.New(
+		&bytes.Buffer{},
+		ioutil.Discard,
+		nil,
+	)
+
+	targetPath, err := ioutil.TempDir("", "fissile-test")
+	assert.NoError(err)
+	defer os.RemoveAll(targetPath)
+
+	packagesImageBuilder, err := NewPackagesImageBuilder("foo", "", targetPath, "3.14.15", ui)
+	assert.NoError(err)
+
+	pkgA := &model.Package{Name: "aaa", Fingerprint: "fingerprint-a"}
+	pkgB := &model.Package{Name: "bbb", Fingerprint: "fingerprint-b"}
+	pkgC := &model.Package{Name: "ccc", Fingerprint: "fingerprint-c"}
+
+	refRoles := model.Roles{
+		{Name: "one", Jobs: model.Jobs{{SHA1: "job-1", Packages: model.Packages{pkgA, pkgB}}}},
+		{Name: "two", Jobs: model.Jobs{{SHA1: "job-2", Packages: model.Packages{pkgB}}}},
+	}
+	refName, err := packagesImageBuilder.GetRolePackageImageName(refRoles)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(refName, "foo-role-packages:"), "Unexpected image name %s", refName)
+
+	// Same packages, different jobs and roles
+	sameRoles := model.Roles{
+		{Name: "three", Jobs: model.Jobs{{SHA1: "job-3", Packages: model.Packages{pkgB, pkgA}}}},
+	}
+	sameName, err := packagesImageBuilder.GetRolePackageImageName(sameRoles)
+	assert.NoError(err)
+	assert.Equal(refName, sameName, "Image name should only depend on the package set")
+
+	otherRoles := model.Roles{
+		{Name: "one", Jobs: model.Jobs{{SHA1: "job-1", Packages: model.Packages{pkgA, pkgC}}}},
+	}
+	otherName, err := packagesImageBuilder.GetRolePackageImageName(otherRoles)
+	assert.NoError(err)
+	assert.NotEqual(refName, otherName, "Image name should depend on the package set")
+
+	packagesImageBuilder.fissileVersion = "6.28.30"
+	otherVersionName, err := packagesImageBuilder.GetRolePackageImageName(refRoles)
+	assert.NoError(err)
+	assert.NotEqual(refName, otherVersionName, "Image name should depend on the fissile version")
+
+	baseImageRoles := model.Roles{
+		{Name: "one", BaseImage: "centos-base", Jobs: model.Jobs{{SHA1: "job-1", Packages: model.Packages{pkgA, pkgB}}}},
+	}
+	baseImageName, err := packagesImageBuilder.GetRolePackageImageName(baseImageRoles)
+	assert.NoError(err)
+	assert.NotEqual(otherVersionName, baseImageName, "Image name should depend on the base image")
+	assert.Equal("centos-base", packagesImageBuilder.BaseImageName(baseImageRoles))
+	assert.Equal(GetBaseImageName("foo", "6.28.30"), packagesImageBuilder.BaseImageName(refRoles))
+}
+
+func TestGroupRolesByPackages(t *testing.T) {
+	assert := assert.New(t)
+
+	pkgA := &model.Package{Name: "aaa", Fingerprint: "fingerprint-a"}
+	pkgB := &model.Package{Name: "bbb", Fingerprint: "fingerprint-b"}
+
+	one := &model.Role{Name: "one", Jobs: model.Jobs{{Packages: model.Packages{pkgA, pkgB}}}}
+	two := &model.Role{Name: "two", Jobs: model.Jobs{{Packages: model.Packages{pkgB}}}}
+	three := &model.Role{Name: "three", Jobs: model.Jobs{{Packages: model.Packages{pkgB}}, {Packages: model.Packages{pkgA}}}}
+	four := &model.Role{Name: "four"}
+	five := &model.Role{Name: "five", BaseImage: "centos-base", Jobs: model.Jobs{{Packages: model.Packages{pkgB}}}}
+
+	groups := GroupRolesByPackages(model.Roles{one, two, three, four, five})
+	assert.Equal([]model.Roles{{one, three}, {two}, {four}, {five}}, groups)
+}
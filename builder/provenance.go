@@ -0,0 +1,185 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// PackagesLabel is the label of role images recording the compiled packages
+// they include, as JSON; see PackageProvenance
+const PackagesLabel = "packages"
+
+// The labels of role images recording the inputs they were built from, so a
+// running container can be traced back to them. The keys of the OCI image
+// spec are used where they fit.
+const (
+	CreatedLabel          = "org.opencontainers.image.created" // RFC 3339
+	TitleLabel            = "org.opencontainers.image.title"   // The role name
+	VersionLabel          = "org.opencontainers.image.version" // The role dev version
+	FissileVersionLabel   = "fissile.version"
+	ReleasesLabel         = "fissile.releases"           // <name>/<version> of the releases of the jobs, comma separated
+	JobsLabel             = "fissile.jobs"               // <name>=<release>/<job> of the jobs, by their name in the role, comma separated
+	RoleManifestSHA1Label = "fissile.role-manifest.sha1" // Only for roles loaded from a role manifest
+)
+
+// packagesProvenanceFile is the file of role images recording the compiled
+// packages they include, like PackagesLabel
+const packagesProvenanceFile = "root/opt/hcf/packages.json"
+
+// PackageProvenance records a compiled package included in a role image
+type PackageProvenance struct {
+	Release     string `json:"release"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	CompiledAt  string `json:"compiled_at,omitempty"` // RFC 3339; empty if unknown
+}
+
+// getPackagesProvenance returns the provenance of the compiled packages of a
+// role, sorted by release and name. Packages are compiled when their
+// compilation key is written.
+func getPackagesProvenance(role *model.Role, compiledPackagesPath string) []*PackageProvenance {
+	var provenance []*PackageProvenance
+	for _, pkg := range role.Jobs.Packages() {
+		entry := &PackageProvenance{
+			Release:     pkg.Release.Name,
+			Name:        pkg.Name,
+			Fingerprint: pkg.Fingerprint,
+		}
+		if info, err := os.Stat(pkg.GetPackageCompilationKeyFile(compiledPackagesPath)); err == nil {
+			entry.CompiledAt = info.ModTime().UTC().Format(time.RFC3339)
+		}
+		provenance = append(provenance, entry)
+	}
+
+	sort.Slice(provenance, func(i, j int) bool {
+		if provenance[i].Release != provenance[j].Release {
+			return provenance[i].Release < provenance[j].Release
+		}
+		return provenance[i].Name < provenance[j].Name
+	})
+	return provenance
+}
+
+// ParsePackagesLabel reads the provenance of the packages of a role image from
+// the value of its PackagesLabel
+func ParsePackagesLabel(label string) ([]*PackageProvenance, error) {
+	var provenance []*PackageProvenance
+	if err := json.Unmarshal([]byte(label), &provenance); err != nil {
+		return nil, fmt.Errorf("Error reading the %s label: %s", PackagesLabel, err)
+	}
+	return provenance, nil
+}
+
+// VerifyPackagesProvenance compares the packages recorded in the image of a
+// role with those the jobs of the role use now, and describes each mismatch:
+// packages missing from the image, packages the role no longer uses, and
+// packages with another fingerprint
+func VerifyPackagesProvenance(role *model.Role, provenance []*PackageProvenance) []string {
+	recorded := make(map[string]*PackageProvenance, len(provenance))
+	for _, entry := range provenance {
+		recorded[fmt.Sprintf("%s/%s", entry.Release, entry.Name)] = entry
+	}
+
+	var mismatches []string
+	for _, pkg := range role.Jobs.Packages() {
+		name := fmt.Sprintf("%s/%s", pkg.Release.Name, pkg.Name)
+		entry, ok := recorded[name]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("package %s is missing from the image", name))
+			continue
+		}
+		delete(recorded, name)
+
+		if entry.Fingerprint != pkg.Fingerprint {
+			mismatches = append(mismatches, fmt.Sprintf("package %s has fingerprint %s in the image, expected %s",
+				name, entry.Fingerprint, pkg.Fingerprint))
+		}
+	}
+
+	for name := range recorded {
+		mismatches = append(mismatches, fmt.Sprintf("package %s is not used by the role", name))
+	}
+
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+// SourceDateEpochEnv is the environment variable setting the time role images
+// are recorded as built at, as seconds since the epoch, for reproducible
+// builds; the current time is used if it is not set
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// buildTime returns the time role images are recorded as built at
+func buildTime() (time.Time, error) {
+	epoch := os.Getenv(SourceDateEpochEnv)
+	if epoch == "" {
+		return time.Now(), nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s '%s', expected seconds since the epoch", SourceDateEpochEnv, epoch)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// provenanceLabels returns the provenance labels of the image of a role,
+// built now by the given fissile version, as quoted "key"="value" pairs
+// sorted by key, for its Dockerfile
+func provenanceLabels(role *model.Role, fissileVersion string, now time.Time) ([]string, error) {
+	devVersion, err := role.GetRoleDevVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var releases, jobs []string
+	seen := make(map[*model.Release]bool)
+	for _, job := range role.Jobs {
+		jobs = append(jobs, fmt.Sprintf("%s=%s/%s", role.JobName(job), job.Release.Name, job.Name))
+		if !seen[job.Release] {
+			seen[job.Release] = true
+			releases = append(releases, fmt.Sprintf("%s/%s", job.Release.Name, job.Release.Version))
+		}
+	}
+	sort.Strings(releases)
+
+	labels := map[string]string{
+		CreatedLabel:        now.UTC().Format(time.RFC3339),
+		TitleLabel:          role.Name,
+		VersionLabel:        devVersion,
+		FissileVersionLabel: fissileVersion,
+		ReleasesLabel:       strings.Join(releases, ","),
+		JobsLabel:           strings.Join(jobs, ","),
+	}
+	if sum := role.RoleManifestSHA1(); sum != "" {
+		labels[RoleManifestSHA1Label] = sum
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", strconv.Quote(key), strconv.Quote(labels[key])))
+	}
+	return pairs, nil
+}
+
+// packagesLabelValue returns the quoted value of the PackagesLabel of a role
+// image, for its Dockerfile
+func packagesLabelValue(provenance []*PackageProvenance) (string, error) {
+	contents, err := json.Marshal(provenance)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(string(contents)), nil
+}
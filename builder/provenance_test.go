@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPackagesProvenance(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	release, err := model.NewDevRelease(releasePath, "", "", filepath.Join(releasePath, "bosh-cache"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+	rolesManifest, err := model.LoadRoleManifest(roleManifestPath, []*model.Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+	role := rolesManifest.Roles[0]
+
+	compiledPackagesDir := filepath.Join(workDir, "../test-assets/tor-boshrelease-fake-compiled")
+	provenance := getPackagesProvenance(role, compiledPackagesDir)
+	if assert.Len(provenance, len(role.Jobs.Packages())) {
+		for _, entry := range provenance {
+			assert.Equal("tor", entry.Release)
+			assert.NotEmpty(entry.Fingerprint)
+		}
+	}
+	assert.Empty(VerifyPackagesProvenance(role, provenance))
+
+	label, err := packagesLabelValue(provenance)
+	if assert.NoError(err) {
+		unquoted, err := strconv.Unquote(label)
+		if assert.NoError(err) {
+			parsed, err := ParsePackagesLabel(unquoted)
+			assert.NoError(err)
+			assert.Equal(provenance, parsed)
+		}
+	}
+}
+
+func TestVerifyPackagesProvenance(t *testing.T) {
+	assert := assert.New(t)
+
+	release := &model.Release{Name: "tor"}
+	role := &model.Role{
+		Name: "myrole",
+		Jobs: model.Jobs{
+			{
+				Name: "tor",
+				Packages: model.Packages{
+					{Name: "libevent", Fingerprint: "aaa", Release: release},
+					{Name: "tor", Fingerprint: "bbb", Release: release},
+				},
+			},
+		},
+	}
+
+	provenance, err := ParsePackagesLabel(`[
+		{"release": "tor", "name": "tor", "fingerprint": "ccc", "compiled_at": "2017-01-01T00:00:00Z"},
+		{"release": "tor", "name": "openssl", "fingerprint": "ddd"}
+	]`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal([]string{
+		"package tor/libevent is missing from the image",
+		"package tor/openssl is not used by the role",
+		"package tor/tor has fingerprint ccc in the image, expected bbb",
+	}, VerifyPackagesProvenance(role, provenance))
+
+	_, err = ParsePackagesLabel("not json")
+	assert.Error(err)
+}
+
+func TestBuildTime(t *testing.T) {
+	assert := assert.New(t)
+
+	defer os.Setenv(SourceDateEpochEnv, os.Getenv(SourceDateEpochEnv))
+
+	os.Setenv(SourceDateEpochEnv, "1500000000")
+	created, err := buildTime()
+	if assert.NoError(err) {
+		assert.Equal("2017-07-14T02:40:00Z", created.UTC().Format(time.RFC3339))
+	}
+
+	os.Setenv(SourceDateEpochEnv, "yesterday")
+	_, err = buildTime()
+	assert.EqualError(err, "Invalid SOURCE_DATE_EPOCH 'yesterday', expected seconds since the epoch")
+
+	os.Unsetenv(SourceDateEpochEnv)
+	created, err = buildTime()
+	if assert.NoError(err) {
+		assert.WithinDuration(time.Now(), created, time.Minute)
+	}
+}
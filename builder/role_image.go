@@ -4,11 +4,17 @@ import (
 	"archive/tar"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/hpcloud/fissile/docker"
@@ -30,14 +36,18 @@ const (
 
 var (
 	// newDockerImageBuilder is a stub to be replaced by the unit test
-	newDockerImageBuilder = func() (dockerImageBuilder, error) { return docker.NewImageManager() }
+	newDockerImageBuilder = func() (ImageBuilder, error) { return docker.NewImageManager() }
 )
 
-// dockerImageBuilder is the interface to shim around docker.RoleImageBuilder for the unit test
-type dockerImageBuilder interface {
-	HasImage(imageName string) (bool, error)
-	BuildImage(dockerfileDirPath, name string, stdoutProcessor io.WriteCloser) error
-	BuildImageFromCallback(name string, stdoutWriter io.Writer, callback func(*tar.Writer) error) error
+// imageSquasher is implemented by the image builders able to squash images
+type imageSquasher interface {
+	SquashImage(imageName, parentImageName string) error
+}
+
+// imageDigestResolver is implemented by the image builders able to tell the
+// digest of the images they have
+type imageDigestResolver interface {
+	ResolveImageDigest(imageName string) (string, error)
 }
 
 // RoleImageBuilder represents a builder of docker role images
@@ -50,7 +60,12 @@ type RoleImageBuilder struct {
 	fissileVersion       string
 	lightOpinionsPath    string
 	darkOpinionsPath     string
+	imageBuilder         ImageBuilder // Builds the images instead of the docker daemon, if set
+	squash               bool
+	sbomFormat           string // The format of the SBOM of each role image; none if empty
+	sbomDirectory        string
 	ui                   *termui.UI
+	uiMutex              sync.Mutex // serializes output of concurrent role builds
 }
 
 // NewRoleImageBuilder creates a new RoleImageBuilder
@@ -71,6 +86,26 @@ func NewRoleImageBuilder(repository, compiledPackagesPath, targetPath, lightOpin
 	}, nil
 }
 
+// SetImageBuilder has the role images built by another builder than the
+// docker daemon, unless nil
+func (r *RoleImageBuilder) SetImageBuilder(imageBuilder ImageBuilder) {
+	r.imageBuilder = imageBuilder
+}
+
+// SetSquash selects whether the layers of each role image are merged into
+// one once it is built; the layers of the packages layer image are kept
+func (r *RoleImageBuilder) SetSquash(squash bool) {
+	r.squash = squash
+}
+
+// SetSBOM has a software bill of materials (SBOM) written in the given format
+// for each role image, to the given directory; none are written if the format
+// is empty
+func (r *RoleImageBuilder) SetSBOM(format, directory string) {
+	r.sbomFormat = format
+	r.sbomDirectory = directory
+}
+
 // NewDockerPopulator returns a function which can populate a tar stream with the docker context to build the packages layer image with
 func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName string) func(*tar.Writer) error {
 	return func(tarWriter *tar.Writer) error {
@@ -86,11 +121,18 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 					continue
 				}
 
-				releaseDir := filepath.Join("root/opt/hcf/share/doc", job.Release.Name)
+				releaseDir := path.Join("root/opt/hcf/share/doc", job.Release.Name)
 
-				for filename, contents := range job.Release.License.Files {
+				filenames := make([]string, 0, len(job.Release.License.Files))
+				for filename := range job.Release.License.Files {
+					filenames = append(filenames, filename)
+				}
+				sort.Strings(filenames)
+
+				for _, filename := range filenames {
+					contents := job.Release.License.Files[filename]
 					err := util.WriteToTarStream(tarWriter, contents, tar.Header{
-						Name: filepath.Join(releaseDir, filename),
+						Name: path.Join(releaseDir, filepath.ToSlash(filename)),
 					})
 					if err != nil {
 						return fmt.Errorf("failed to write out release license file %s: %v", filename, err)
@@ -106,9 +148,9 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 			for _, pkg := range job.Packages {
 				if _, ok := packageSet[pkg.Name]; !ok {
 					err := util.WriteToTarStream(tarWriter, nil, tar.Header{
-						Name:     filepath.Join("root/var/vcap/packages", pkg.Name),
+						Name:     path.Join("root/var/vcap/packages", pkg.Name),
 						Typeflag: tar.TypeSymlink,
-						Linkname: filepath.Join("..", "packages-src", pkg.Fingerprint),
+						Linkname: path.Join("..", "packages-src", pkg.Fingerprint),
 					})
 					if err != nil {
 						return fmt.Errorf("failed to write package symlink for %s: %s", pkg.Name, err)
@@ -123,12 +165,23 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 			}
 		}
 
+		// Record the compiled packages, along with the label of the image
+		provenance, err := json.MarshalIndent(getPackagesProvenance(role, r.compiledPackagesPath), "", "  ")
+		if err != nil {
+			return err
+		}
+		err = util.WriteToTarStream(tarWriter, provenance, tar.Header{
+			Name: packagesProvenanceFile,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write the packages of role %s: %s", role.Name, err)
+		}
+
 		// Copy jobs templates, spec configs and monit
 		for _, job := range role.Jobs {
 			templates := make(map[string]*model.JobTemplate)
 			for _, template := range job.Templates {
-				sourcePath := filepath.Clean(filepath.Join("templates", template.SourcePath))
-				templates[filepath.ToSlash(sourcePath)] = template
+				templates[path.Join("templates", template.SourcePath)] = template
 			}
 
 			sourceTgz, err := os.Open(job.Path)
@@ -137,13 +190,13 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 			}
 			defer sourceTgz.Close()
 			err = util.TargzIterate(job.Path, sourceTgz, func(reader *tar.Reader, header *tar.Header) error {
-				filePath := filepath.ToSlash(filepath.Clean(header.Name))
+				filePath := path.Clean(header.Name)
 				if filePath == "job.MF" {
 					return nil
 				}
-				header.Name = filepath.Join("root/var/vcap/jobs-src", job.Name, header.Name)
+				header.Name = path.Join("root/var/vcap/jobs-src", role.JobName(job), header.Name)
 				if template, ok := templates[filePath]; ok {
-					if strings.HasPrefix(template.DestinationPath, fmt.Sprintf("%s%c", binPrefix, os.PathSeparator)) {
+					if strings.HasPrefix(template.DestinationPath, binPrefix+"/") {
 						header.Mode = 0755
 					} else {
 						header.Mode = 0644
@@ -164,14 +217,21 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 				return err
 			}
 			util.WriteToTarStream(tarWriter, configJSON, tar.Header{
-				Name: filepath.Join("root/var/vcap/jobs-src", job.Name, jobConfigSpecFilename),
+				Name: path.Join("root/var/vcap/jobs-src", role.JobName(job), jobConfigSpecFilename),
 			})
 		}
 
 		// Copy role startup scripts
-		for script, sourceScriptPath := range role.GetScriptPaths() {
+		scriptPaths := role.GetScriptPaths()
+		scripts := make([]string, 0, len(scriptPaths))
+		for script := range scriptPaths {
+			scripts = append(scripts, script)
+		}
+		sort.Strings(scripts)
+		for _, script := range scripts {
+			sourceScriptPath := scriptPaths[script]
 			err := util.CopyFileToTarStream(tarWriter, sourceScriptPath, &tar.Header{
-				Name: filepath.Join("root/opt/hcf/startup", script),
+				Name: path.Join("root/opt/hcf/startup", script),
 			})
 			if err != nil {
 				return fmt.Errorf("Error writing script %s: %s", script, err)
@@ -190,6 +250,65 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 			return err
 		}
 
+		// Add the drift probe, run by cron
+		if hasDriftProbe(role) {
+			probe, err := dockerfiles.Asset("drift-probe.sh")
+			if err != nil {
+				return err
+			}
+			err = util.WriteToTarStream(tarWriter, probe, tar.Header{
+				Name: "root/opt/hcf/drift-probe.sh",
+				Mode: 0755,
+			})
+			if err != nil {
+				return err
+			}
+			err = util.WriteToTarStream(tarWriter, generateDriftProbeCrontab(role), tar.Header{
+				Name: "root/etc/cron.d/fissile-drift-probe",
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Add the active/passive probe, run by run.sh
+		if role.IsActivePassive() {
+			wrapper, err := dockerfiles.Asset("active-passive.sh")
+			if err != nil {
+				return err
+			}
+			err = util.WriteToTarStream(tarWriter, wrapper, tar.Header{
+				Name: "root/opt/hcf/active-passive.sh",
+				Mode: 0755,
+			})
+			if err != nil {
+				return err
+			}
+			err = util.WriteToTarStream(tarWriter, generateActivePassiveProbe(role), tar.Header{
+				Name: "root/opt/hcf/active-passive-probe",
+				Mode: 0755,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Add the script waiting for the roles the role depends on, run by
+		// an init container of its pods
+		if len(role.Dependencies()) > 0 {
+			wait, err := dockerfiles.Asset("wait-for-roles.sh")
+			if err != nil {
+				return err
+			}
+			err = util.WriteToTarStream(tarWriter, wait, tar.Header{
+				Name: "root/opt/hcf/wait-for-roles.sh",
+				Mode: 0755,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		jobsConfigContents, err := r.generateJobsConfig(role)
 		if err != nil {
 			return err
@@ -201,8 +320,57 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 			return err
 		}
 
-		// Create env2conf templates file in /opt/hcf/env2conf.yml
-		configTemplatesBytes, err := yaml.Marshal(role.Configuration.Templates)
+		conditionalJobsConfig, err := r.generateConditionalJobsConfig(role)
+		if err != nil {
+			return err
+		}
+		for _, job := range role.Jobs {
+			contents, ok := conditionalJobsConfig[role.JobName(job)]
+			if !ok {
+				continue
+			}
+			err = util.WriteToTarStream(tarWriter, contents, tar.Header{
+				Name: path.Join("root/opt/hcf/job_config", role.JobName(job)+".json"),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Add the health check job, rendering the health checks with
+		// templates when the container starts
+		if hasTemplatedHealthChecks(role) {
+			spec, err := generateHealthCheckSpec(role)
+			if err != nil {
+				return err
+			}
+			err = util.WriteToTarStream(tarWriter, spec, tar.Header{
+				Name: path.Join("root", healthCheckDir, "config_spec.json"),
+			})
+			if err != nil {
+				return err
+			}
+			kinds, probes := templatedHealthChecks(role)
+			for index, kind := range kinds {
+				err = util.WriteToTarStream(tarWriter, generateHealthCheckTemplate(role, kind, probes[index]), tar.Header{
+					Name: path.Join("root", healthCheckDir, kind+".sh.erb"),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Create env2conf templates file in /opt/hcf/env2conf.yml, with
+		// those of the health checks
+		configTemplates := make(map[string]string, len(role.Configuration.Templates))
+		for property, template := range role.Configuration.Templates {
+			configTemplates[property] = template
+		}
+		for property, template := range healthCheckTemplates(role) {
+			configTemplates[property] = template
+		}
+		configTemplatesBytes, err := yaml.Marshal(configTemplates)
 		if err != nil {
 			return err
 		}
@@ -241,11 +409,21 @@ func (r *RoleImageBuilder) generateRunScript(role *model.Role) ([]byte, error) {
 
 	runScriptTemplate := template.New("role-runscript")
 	runScriptTemplate.Funcs(template.FuncMap{
-		"is_abs":       filepath.IsAbs,
+		"is_abs":       path.IsAbs,
 		"is_pre_start": isPreStart,
 	})
+	conditions := make(map[string]string)
+	for _, job := range role.Jobs {
+		if variable := role.JobCondition(role.JobName(job)); variable != "" {
+			conditions[role.JobName(job)] = variable
+		}
+	}
 	context := map[string]interface{}{
-		"role": role,
+		"role":           role,
+		"conditions":     conditions,
+		"drift_probe":    hasDriftProbe(role),
+		"active_passive": role.IsActivePassive(),
+		"healthcheck":    hasTemplatedHealthChecks(role),
 	}
 	runScriptTemplate, err = runScriptTemplate.Parse(string(asset))
 	if err != nil {
@@ -261,42 +439,99 @@ func (r *RoleImageBuilder) generateRunScript(role *model.Role) ([]byte, error) {
 	return output.Bytes(), nil
 }
 
+// hasDriftProbe tests whether the image of a role checks it for configuration
+// drift. Tasks don't run long enough to drift.
+func hasDriftProbe(role *model.Role) bool {
+	return role.Type != model.RoleTypeBoshTask && role.Run != nil && role.Run.DriftProbe != nil
+}
+
+// generateDriftProbeCrontab generates the cron table running the drift probe
+// of a role
+func generateDriftProbeCrontab(role *model.Role) []byte {
+	return []byte(fmt.Sprintf("# Checks the templates of the role for configuration drift\n"+
+		"*/%d * * * * root /opt/hcf/drift-probe.sh %s > /dev/null 2>&1\n",
+		role.Run.DriftProbe.Interval, role.Name))
+}
+
+// generateActivePassiveProbe generates the script running the active/passive
+// probe command of a role
+func generateActivePassiveProbe(role *model.Role) []byte {
+	return []byte(fmt.Sprintf("#!/bin/bash\n# Succeeds on the active instance of %s\n%s\n",
+		role.Name, role.Run.ActivePassiveProbe))
+}
+
+// generateJobsConfig generates the configgin configuration of the jobs of a
+// role which are always enabled
 func (r *RoleImageBuilder) generateJobsConfig(role *model.Role) ([]byte, error) {
 	jobsConfig := make(map[string]map[string]interface{})
 
 	for index, job := range role.Jobs {
-		jobsConfig[job.Name] = make(map[string]interface{})
-		jobsConfig[job.Name]["base"] = fmt.Sprintf("/var/vcap/jobs-src/%s/config_spec.json", job.Name)
+		if role.JobCondition(role.JobName(job)) != "" {
+			continue
+		}
+		jobsConfig[role.JobName(job)] = jobConfig(role, index, job)
+	}
+	if hasTemplatedHealthChecks(role) {
+		jobsConfig[healthCheckJobName] = healthCheckJobConfig(role)
+	}
 
-		files := make(map[string]string)
+	jsonOut, err := json.Marshal(jobsConfig)
+	if err != nil {
+		return jsonOut, err
+	}
 
-		for _, file := range job.Templates {
-			src := fmt.Sprintf("/var/vcap/jobs-src/%s/templates/%s",
-				job.Name, file.SourcePath)
-			dest := fmt.Sprintf("/var/vcap/jobs/%s/%s",
-				job.Name, file.DestinationPath)
-			files[src] = dest
-		}
+	return jsonOut, nil
+}
 
-		if role.Type != "bosh-task" {
-			src := fmt.Sprintf("/var/vcap/jobs-src/%s/monit", job.Name)
-			dest := fmt.Sprintf("/var/vcap/monit/%s.monitrc", job.Name)
-			files[src] = dest
+// generateConditionalJobsConfig generates the configgin configuration of each
+// conditional job of a role, by job name; run.sh adds those of the enabled
+// jobs to the configuration of the others
+func (r *RoleImageBuilder) generateConditionalJobsConfig(role *model.Role) (map[string][]byte, error) {
+	jobsConfig := make(map[string][]byte)
 
-			if index == 0 {
-				files["/opt/hcf/monitrc.erb"] = "/etc/monitrc"
-			}
+	for index, job := range role.Jobs {
+		if role.JobCondition(role.JobName(job)) == "" {
+			continue
+		}
+		jsonOut, err := json.Marshal(jobConfig(role, index, job))
+		if err != nil {
+			return nil, err
 		}
+		jobsConfig[role.JobName(job)] = jsonOut
+	}
+
+	return jobsConfig, nil
+}
 
-		jobsConfig[job.Name]["files"] = files
+// jobConfig returns the configgin configuration of a job: its spec, and the
+// templates to render, under the name of the job in the role
+func jobConfig(role *model.Role, index int, job *model.Job) map[string]interface{} {
+	name := role.JobName(job)
+	config := make(map[string]interface{})
+	config["base"] = fmt.Sprintf("/var/vcap/jobs-src/%s/config_spec.json", name)
+
+	files := make(map[string]string)
+
+	for _, file := range job.Templates {
+		src := fmt.Sprintf("/var/vcap/jobs-src/%s/templates/%s",
+			name, file.SourcePath)
+		dest := fmt.Sprintf("/var/vcap/jobs/%s/%s",
+			name, file.DestinationPath)
+		files[src] = dest
 	}
 
-	jsonOut, err := json.Marshal(jobsConfig)
-	if err != nil {
-		return jsonOut, err
+	if role.Type != "bosh-task" {
+		src := fmt.Sprintf("/var/vcap/jobs-src/%s/monit", name)
+		dest := fmt.Sprintf("/var/vcap/monit/%s.monitrc", name)
+		files[src] = dest
+
+		if index == 0 {
+			files["/opt/hcf/monitrc.erb"] = "/etc/monitrc"
+		}
 	}
 
-	return jsonOut, nil
+	config["files"] = files
+	return config
 }
 
 // generateDockerfile builds a docker file for a given role.
@@ -308,11 +543,46 @@ func (r *RoleImageBuilder) generateDockerfile(role *model.Role, baseImageName st
 
 	dockerfileTemplate := template.New("Dockerfile-role")
 
+	healthcheck, err := getHealthcheckInstruction(role)
+	if err != nil {
+		return err
+	}
+
+	packages, err := packagesLabelValue(getPackagesProvenance(role, r.compiledPackagesPath))
+	if err != nil {
+		return err
+	}
+
+	created, err := buildTime()
+	if err != nil {
+		return err
+	}
+	provenance, err := provenanceLabels(role, r.fissileVersion, created)
+	if err != nil {
+		return err
+	}
+
+	preSnippetPaths, postSnippetPaths := role.GetImageSnippetPaths()
+	preSnippets, err := readImageSnippets(preSnippetPaths)
+	if err != nil {
+		return err
+	}
+	postSnippets, err := readImageSnippets(postSnippetPaths)
+	if err != nil {
+		return err
+	}
+
 	context := map[string]interface{}{
 		"base_image":    baseImageName,
 		"image_version": r.version,
 		"role":          role,
 		"licenses":      role.Jobs[0].Release.License.Files,
+		"healthcheck":   healthcheck,
+		"packages":      packages,
+		"user":          role.RunUser(),
+		"provenance":    strings.Join(provenance, " \\\n      "),
+		"pre_snippets":  preSnippets,
+		"post_snippets": postSnippets,
 	}
 
 	dockerfileTemplate, err = dockerfileTemplate.Parse(string(asset))
@@ -327,118 +597,290 @@ func (r *RoleImageBuilder) generateDockerfile(role *model.Role, baseImageName st
 	return nil
 }
 
+// readImageSnippets returns the Dockerfile snippets at the given paths, each
+// preceded by a comment naming its file
+func readImageSnippets(paths []string) (string, error) {
+	var snippets []string
+	for _, snippetPath := range paths {
+		contents, err := ioutil.ReadFile(snippetPath)
+		if err != nil {
+			return "", fmt.Errorf("Error reading Dockerfile snippet %s: %s", snippetPath, err)
+		}
+		snippets = append(snippets, fmt.Sprintf("# From %s\n%s", filepath.Base(snippetPath), strings.TrimSpace(string(contents))))
+	}
+	return strings.Join(snippets, "\n\n"), nil
+}
+
+// getHealthcheckInstruction returns the HEALTHCHECK instruction of the image
+// of a role: its liveness check, or else its readiness check. It is empty for
+// roles without either.
+func getHealthcheckInstruction(role *model.Role) (string, error) {
+	if role.Run == nil || role.Run.HealthCheck == nil {
+		return "", nil
+	}
+	kind, probe := "liveness", role.Run.HealthCheck.Liveness
+	if probe == nil {
+		kind, probe = "readiness", role.Run.HealthCheck.Readiness
+	}
+	if probe == nil {
+		return "", nil
+	}
+
+	var command []string
+	switch {
+	case probe.IsTemplated():
+		// The check is rendered when the container starts
+		command = []string{"bash", model.HealthCheckScript(kind)}
+	case probe.URL != "":
+		probeURL, err := url.Parse(probe.URL)
+		if err != nil {
+			return "", fmt.Errorf("Invalid URL health check for %s: %s", role.Name, err)
+		}
+		if probeURL.Hostname() == "container-ip" {
+			// The check runs inside the container
+			probeURL.Host = strings.Replace(probeURL.Host, "container-ip", "127.0.0.1", 1)
+		}
+
+		command = append([]string{"curl"}, curlArgs(probe)...)
+		command = append(command, probeURL.String())
+	case probe.Port != 0:
+		command = []string{"bash", "-c", fmt.Sprintf("exec 3<>/dev/tcp/127.0.0.1/%d", probe.Port)}
+	case len(probe.Command) > 0:
+		command = probe.Command
+	default:
+		return "", nil
+	}
+
+	var commandJSON bytes.Buffer
+	encoder := json.NewEncoder(&commandJSON)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(command); err != nil {
+		return "", err
+	}
+
+	options := ""
+	if probe.Period > 0 {
+		options += fmt.Sprintf("--interval=%ds ", probe.Period)
+	}
+	if probe.Timeout > 0 {
+		options += fmt.Sprintf("--timeout=%ds ", probe.Timeout)
+	}
+	if probe.InitialDelay > 0 {
+		options += fmt.Sprintf("--start-period=%ds ", probe.InitialDelay)
+	}
+	if probe.FailureThreshold > 0 {
+		options += fmt.Sprintf("--retries=%d ", probe.FailureThreshold)
+	}
+
+	return fmt.Sprintf("HEALTHCHECK %sCMD %s", options, strings.TrimSpace(commandJSON.String())), nil
+}
+
+// errRoleBuildAborted is reported for roles whose build was skipped
+// because another role failed first
+var errRoleBuildAborted = errors.New("aborted")
+
+type roleBuildResult struct {
+	role *model.Role
+	err  error
+}
+
 type roleBuildJob struct {
 	role            *model.Role
 	builder         *RoleImageBuilder
 	ui              *termui.UI
 	force           bool
 	noBuild         bool
-	dockerManager   dockerImageBuilder
+	dockerManager   ImageBuilder
 	outputDirectory string
-	resultsCh       chan<- error
+	resultsCh       chan<- roleBuildResult
 	abort           <-chan struct{}
 	repository      string
 	baseImageName   string
 }
 
+// printf writes a line of progress output, prefixed with the name of the
+// role, so that the output of concurrent builds can be told apart.
+func (j roleBuildJob) printf(format string, args ...interface{}) {
+	j.builder.uiMutex.Lock()
+	defer j.builder.uiMutex.Unlock()
+
+	j.ui.Printf("%s %s", color.CyanString("[%s]", j.role.Name), fmt.Sprintf(format, args...))
+}
+
+// writeLog dumps the (already prefixed) docker build log of the role
+func (j roleBuildJob) writeLog(log *bytes.Buffer) {
+	j.builder.uiMutex.Lock()
+	defer j.builder.uiMutex.Unlock()
+
+	log.WriteTo(j.ui)
+}
+
 func (j roleBuildJob) Run() {
 	select {
 	case <-j.abort:
-		j.resultsCh <- nil
+		j.resultsCh <- roleBuildResult{role: j.role, err: errRoleBuildAborted}
 		return
 	default:
 	}
 
-	j.resultsCh <- func() error {
-		devVersion, err := j.role.GetRoleDevVersion()
-		if err != nil {
-			return fmt.Errorf("Error calculating checksum for role %s: %s", j.role.Name, err.Error())
+	err := j.build()
+	if err == nil && j.builder.sbomFormat != "" && !j.noBuild {
+		err = j.writeSBOM()
+	}
+	j.resultsCh <- roleBuildResult{role: j.role, err: err}
+}
+
+// writeSBOM writes the software bill of materials of the role image. The
+// base image it lists is the image the packages layer is built on, whose
+// digest is only known for images built by the docker daemon, or given by
+// digest.
+func (j roleBuildJob) writeSBOM() error {
+	devVersion, err := j.role.GetRoleDevVersion()
+	if err != nil {
+		return err
+	}
+	roleImageName := GetRoleDevImageName(j.repository, j.role, devVersion)
+
+	baseImageName := j.role.BaseImage
+	if baseImageName == "" {
+		baseImageName = GetBaseImageName(j.repository, j.builder.fissileVersion)
+	}
+	baseImageDigest := docker.ImageDigest(baseImageName)
+	if resolver, ok := j.dockerManager.(imageDigestResolver); ok && j.outputDirectory == "" && baseImageDigest == "" {
+		if baseImageDigest, err = resolver.ResolveImageDigest(baseImageName); err != nil {
+			return fmt.Errorf("Error finding the digest of base image %s: %s", baseImageName, err)
 		}
-		roleImageName := GetRoleDevImageName(j.repository, j.role, devVersion)
-		outputPath := filepath.Join(j.outputDirectory, fmt.Sprintf("%s.tar", roleImageName))
-		if !j.force {
-			if j.outputDirectory == "" {
-				if hasImage, err := j.dockerManager.HasImage(roleImageName); err != nil {
-					return err
-				} else if hasImage {
-					j.ui.Printf("Skipping build of role image %s because it exists\n", color.YellowString(j.role.Name))
-					return nil
-				}
-			} else {
-				info, err := os.Stat(outputPath)
-				if err == nil {
-					if info.IsDir() {
-						return fmt.Errorf("Output path %s exists but is a directory", outputPath)
-					}
-					j.ui.Printf("Skipping build of role tarball %s because it exists\n", color.YellowString(outputPath))
-					return nil
-				}
-				if !os.IsNotExist(err) {
-					return err
+	}
+
+	now, err := buildTime()
+	if err != nil {
+		return err
+	}
+	bom, err := newRoleBOM(j.role, roleImageName, baseImageName, baseImageDigest, j.builder.fissileVersion, now)
+	if err != nil {
+		return err
+	}
+	contents, err := generateSBOM(bom, j.builder.sbomFormat)
+	if err != nil {
+		return err
+	}
+
+	sbomPath := filepath.Join(j.builder.sbomDirectory, SBOMFileName(j.role, j.builder.sbomFormat))
+	if err := ioutil.WriteFile(sbomPath, contents, 0644); err != nil {
+		return fmt.Errorf("Error writing the SBOM of role %s: %s", j.role.Name, err)
+	}
+	j.printf("Wrote SBOM %s\n", color.YellowString(sbomPath))
+	return nil
+}
+
+func (j roleBuildJob) build() error {
+	devVersion, err := j.role.GetRoleDevVersion()
+	if err != nil {
+		return fmt.Errorf("Error calculating checksum for role %s: %s", j.role.Name, err.Error())
+	}
+	roleImageName := GetRoleDevImageName(j.repository, j.role, devVersion)
+	outputPath := filepath.Join(j.outputDirectory, fmt.Sprintf("%s.tar", roleImageName))
+	if !j.force {
+		if j.outputDirectory == "" {
+			if hasImage, err := j.dockerManager.HasImage(roleImageName); err != nil {
+				return err
+			} else if hasImage {
+				j.printf("Skipping build of role image %s because it exists\n", color.YellowString(j.role.Name))
+				return nil
+			}
+		} else {
+			info, err := os.Stat(outputPath)
+			if err == nil {
+				if info.IsDir() {
+					return fmt.Errorf("Output path %s exists but is a directory", outputPath)
 				}
+				j.printf("Skipping build of role tarball %s because it exists\n", color.YellowString(outputPath))
+				return nil
+			}
+			if !os.IsNotExist(err) {
+				return err
 			}
 		}
+	}
 
-		if j.builder.metricsPath != "" {
-			seriesName := fmt.Sprintf("create-role-images::%s", roleImageName)
+	if j.builder.metricsPath != "" {
+		seriesName := fmt.Sprintf("create-role-images::%s", roleImageName)
 
-			stampy.Stamp(j.builder.metricsPath, "fissile", seriesName, "start")
-			defer stampy.Stamp(j.builder.metricsPath, "fissile", seriesName, "done")
-		}
+		stampy.Stamp(j.builder.metricsPath, "fissile", seriesName, "start")
+		defer stampy.Stamp(j.builder.metricsPath, "fissile", seriesName, "done")
+	}
 
-		j.ui.Printf("Creating Dockerfile for role %s ...\n", color.YellowString(j.role.Name))
-		dockerPopulator := j.builder.NewDockerPopulator(j.role, j.baseImageName)
+	j.printf("Creating Dockerfile for role %s ...\n", color.YellowString(j.role.Name))
+	dockerPopulator := j.builder.NewDockerPopulator(j.role, j.baseImageName)
 
-		if j.noBuild {
-			j.ui.Printf("Skipping build of role image %s because of flag\n", color.YellowString(j.role.Name))
-			return nil
-		}
+	if j.noBuild {
+		j.printf("Skipping build of role image %s because of flag\n", color.YellowString(j.role.Name))
+		return nil
+	}
 
-		if j.outputDirectory == "" {
-			j.ui.Printf("Building docker image of %s...\n", color.YellowString(j.role.Name))
+	if j.outputDirectory == "" {
+		j.printf("Building docker image of %s...\n", color.YellowString(j.role.Name))
 
-			log := new(bytes.Buffer)
-			stdoutWriter := docker.NewFormattingWriter(
-				log,
-				docker.ColoredBuildStringFunc(roleImageName),
-			)
+		log := new(bytes.Buffer)
+		stdoutWriter := docker.NewFormattingWriter(
+			log,
+			docker.ColoredBuildStringFunc(roleImageName),
+		)
 
-			err := j.dockerManager.BuildImageFromCallback(roleImageName, stdoutWriter, dockerPopulator)
-			if err != nil {
-				log.WriteTo(j.ui)
-				return fmt.Errorf("Error building image: %s", err.Error())
-			}
-		} else {
-			j.ui.Printf("Building tarball of %s...\n", color.YellowString(j.role.Name))
+		err := j.dockerManager.BuildImageFromCallback(roleImageName, stdoutWriter, dockerPopulator)
+		if err != nil {
+			j.writeLog(log)
+			return fmt.Errorf("Error building image: %s", err.Error())
+		}
 
-			tarFile, err := os.Create(outputPath)
-			if err != nil {
-				return fmt.Errorf("Failed to create tar file %s: %s", outputPath, err)
+		if j.builder.squash {
+			squasher, ok := j.dockerManager.(imageSquasher)
+			if !ok {
+				return fmt.Errorf("Role images can't be squashed by this builder")
 			}
-			tarWriter := tar.NewWriter(tarFile)
-
-			err = dockerPopulator(tarWriter)
-			if err != nil {
-				return fmt.Errorf("Failed to populate tar file %s: %s", outputPath, err)
+			j.printf("Squashing docker image of %s...\n", color.YellowString(j.role.Name))
+			if err := squasher.SquashImage(roleImageName, j.baseImageName); err != nil {
+				return err
 			}
+		}
+	} else {
+		j.printf("Building tarball of %s...\n", color.YellowString(j.role.Name))
 
-			err = tarWriter.Close()
-			if err != nil {
-				return fmt.Errorf("Failed to close tar file %s: %s", outputPath, err)
-			}
+		tarFile, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("Failed to create tar file %s: %s", outputPath, err)
 		}
-		return nil
-	}()
+		tarWriter := tar.NewWriter(tarFile)
+
+		err = dockerPopulator(tarWriter)
+		if err != nil {
+			return fmt.Errorf("Failed to populate tar file %s: %s", outputPath, err)
+		}
+
+		err = tarWriter.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to close tar file %s: %s", outputPath, err)
+		}
+	}
+	j.printf("Done.\n")
+	return nil
 }
 
-// BuildRoleImages triggers the building of the role docker images in parallel
-func (r *RoleImageBuilder) BuildRoleImages(roles model.Roles, repository, baseImageName, outputDirectory string, force, noBuild bool, workerCount int) error {
+// BuildRoleImages triggers the building of the role docker images in parallel.
+// The first failure stops the scheduling of further builds; builds already
+// running are allowed to finish. All failures are reported together at the end.
+// Each role image is built on the packages layer image named for the role in
+// packagesImageNames.
+func (r *RoleImageBuilder) BuildRoleImages(roles model.Roles, repository string, packagesImageNames map[string]string, outputDirectory string, force, noBuild bool, workerCount int) error {
 	if workerCount < 1 {
 		return fmt.Errorf("Invalid worker count %d", workerCount)
 	}
 
-	dockerManager, err := newDockerImageBuilder()
-	if err != nil {
+	var dockerManager ImageBuilder
+	var err error
+	if r.imageBuilder != nil {
+		dockerManager = r.imageBuilder
+	} else if dockerManager, err = newDockerImageBuilder(); err != nil {
 		return fmt.Errorf("Error connecting to docker: %s", err.Error())
 	}
 
@@ -448,10 +890,16 @@ func (r *RoleImageBuilder) BuildRoleImages(roles model.Roles, repository, baseIm
 		}
 	}
 
+	if r.sbomFormat != "" {
+		if err = os.MkdirAll(r.sbomDirectory, 0755); err != nil {
+			return fmt.Errorf("Error creating SBOM directory: %s", err)
+		}
+	}
+
 	workerLib.MaxJobs = workerCount
 	worker := workerLib.NewWorker()
 
-	resultsCh := make(chan error)
+	resultsCh := make(chan roleBuildResult)
 	abort := make(chan struct{})
 	for _, role := range roles {
 		worker.Add(roleBuildJob{
@@ -465,25 +913,57 @@ func (r *RoleImageBuilder) BuildRoleImages(roles model.Roles, repository, baseIm
 			resultsCh:       resultsCh,
 			abort:           abort,
 			repository:      repository,
-			baseImageName:   baseImageName,
+			baseImageName:   packagesImageNames[role.Name],
 		})
 	}
 
 	go worker.RunUntilDone()
 
 	aborted := false
+	var failed []roleBuildResult
+	var skipped []string
 	for i := 0; i < len(roles); i++ {
 		result := <-resultsCh
-		if result != nil {
+		if result.err == errRoleBuildAborted {
+			skipped = append(skipped, result.role.Name)
+			continue
+		}
+		if result.err != nil {
 			if !aborted {
 				close(abort)
 				aborted = true
 			}
-			err = result
+			failed = append(failed, result)
 		}
 	}
 
-	return err
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return r.reportFailures(failed, skipped)
+}
+
+// reportFailures prints a summary of all failed and skipped role builds,
+// and returns an error aggregating the failures.
+func (r *RoleImageBuilder) reportFailures(failed []roleBuildResult, skipped []string) error {
+	sort.Slice(failed, func(i, j int) bool { return failed[i].role.Name < failed[j].role.Name })
+	sort.Strings(skipped)
+
+	messages := make([]string, 0, len(failed))
+	for _, result := range failed {
+		messages = append(messages, fmt.Sprintf("- %s: %s", result.role.Name, result.err))
+	}
+
+	r.ui.Println(color.RedString("Failed to build %d role image(s):", len(failed)))
+	for _, result := range failed {
+		r.ui.Printf("- %s: %s\n", color.YellowString(result.role.Name), color.RedString(result.err.Error()))
+	}
+	if len(skipped) > 0 {
+		r.ui.Printf("Skipped because of the failures above: %s\n", color.YellowString(strings.Join(skipped, ", ")))
+	}
+
+	return fmt.Errorf("Failed to build role images:\n%s", strings.Join(messages, "\n"))
 }
 
 // GetRoleDevImageName generates a docker image name to be used as a dev role image
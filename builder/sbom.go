@@ -0,0 +1,420 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// The formats of the software bills of materials (SBOM) of role images, both
+// written as JSON
+const (
+	SBOMFormatSPDX      = "spdx"      // SPDX 2.3
+	SBOMFormatCycloneDX = "cyclonedx" // CycloneDX 1.4
+)
+
+// SBOMFormats returns the formats the SBOM of role images can be written in
+func SBOMFormats() []string {
+	return []string{SBOMFormatSPDX, SBOMFormatCycloneDX}
+}
+
+// ValidateSBOMFormat checks that the SBOM of role images can be written in a
+// format
+func ValidateSBOMFormat(format string) error {
+	for _, known := range SBOMFormats() {
+		if format == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid SBOM format '%s', expected one of %s", format, strings.Join(SBOMFormats(), ", "))
+}
+
+// SBOMFileName returns the name of the file the SBOM of a role image is
+// written to, in a format
+func SBOMFileName(role *model.Role, format string) string {
+	extension := "spdx.json"
+	if format == SBOMFormatCycloneDX {
+		extension = "cdx.json"
+	}
+	return fmt.Sprintf("%s.%s", role.Name, extension)
+}
+
+// roleBOM is what the SBOM of a role image lists, whatever its format
+type roleBOM struct {
+	imageName       string
+	devVersion      string
+	baseImageName   string
+	baseImageDigest string // Empty if unknown
+	fissileVersion  string
+	created         time.Time
+	releases        []*releaseBOM // Sorted by name
+}
+
+// releaseBOM lists the jobs and packages of a release a role image includes,
+// sorted by name
+type releaseBOM struct {
+	release  *model.Release
+	jobs     model.Jobs
+	packages model.Packages
+}
+
+// newRoleBOM gathers the contents of the image of a role, built on the given
+// base image at the given time
+func newRoleBOM(role *model.Role, imageName, baseImageName, baseImageDigest, fissileVersion string, now time.Time) (*roleBOM, error) {
+	devVersion, err := role.GetRoleDevVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	bom := &roleBOM{
+		imageName:       imageName,
+		devVersion:      devVersion,
+		baseImageName:   baseImageName,
+		baseImageDigest: baseImageDigest,
+		fissileVersion:  fissileVersion,
+		created:         now.UTC(),
+	}
+
+	releases := make(map[*model.Release]*releaseBOM)
+	releaseOf := func(release *model.Release) *releaseBOM {
+		entry, ok := releases[release]
+		if !ok {
+			entry = &releaseBOM{release: release}
+			releases[release] = entry
+			bom.releases = append(bom.releases, entry)
+		}
+		return entry
+	}
+	for _, job := range role.Jobs {
+		entry := releaseOf(job.Release)
+		entry.jobs = append(entry.jobs, job)
+	}
+	for _, pkg := range role.Jobs.Packages() {
+		entry := releaseOf(pkg.Release)
+		entry.packages = append(entry.packages, pkg)
+	}
+
+	sort.Slice(bom.releases, func(i, j int) bool { return bom.releases[i].release.Name < bom.releases[j].release.Name })
+	for _, entry := range bom.releases {
+		jobs, packages := entry.jobs, entry.packages
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+		sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	}
+
+	return bom, nil
+}
+
+// generateSBOM writes the SBOM of a role image in a format
+func generateSBOM(bom *roleBOM, format string) ([]byte, error) {
+	var document interface{}
+	switch format {
+	case SBOMFormatSPDX:
+		document = bom.spdx()
+	case SBOMFormatCycloneDX:
+		document = bom.cycloneDX()
+	default:
+		return nil, ValidateSBOMFormat(format)
+	}
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// splitDigest returns the algorithm and hex value of a digest such as
+// sha256:<hex>; the algorithm is empty if the digest has none
+func splitDigest(digest string) (string, string) {
+	if colon := strings.Index(digest, ":"); colon >= 0 {
+		return digest[:colon], digest[colon+1:]
+	}
+	return "", digest
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []*spdxPackage     `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string         `json:"SPDXID"`
+	Name                  string         `json:"name"`
+	VersionInfo           string         `json:"versionInfo,omitempty"`
+	DownloadLocation      string         `json:"downloadLocation"`
+	FilesAnalyzed         bool           `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum `json:"checksums,omitempty"`
+	SourceInfo            string         `json:"sourceInfo,omitempty"`
+	Comment               string         `json:"comment,omitempty"`
+	PrimaryPackagePurpose string         `json:"primaryPackagePurpose"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxIDInvalidChars matches the characters SPDX identifiers can't hold
+var spdxIDInvalidChars = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+// spdxID returns the SPDX identifier of an element with the given kind and
+// names
+func spdxID(kind string, names ...string) string {
+	id := "SPDXRef-" + kind
+	for _, name := range names {
+		id += "-" + spdxIDInvalidChars.ReplaceAllString(name, "-")
+	}
+	return id
+}
+
+// spdxSHA1 returns the checksums of an element with the given SHA1, if any
+func spdxSHA1(sha1 string) []spdxChecksum {
+	if sha1 == "" {
+		return nil
+	}
+	return []spdxChecksum{{Algorithm: "SHA1", ChecksumValue: sha1}}
+}
+
+// spdx returns the SBOM as an SPDX document. The image contains the releases,
+// which contain the jobs and packages; jobs depend on their packages.
+func (b *roleBOM) spdx() *spdxDocument {
+	imageID := spdxID("Image")
+	baseImageID := spdxID("BaseImage")
+	document := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              b.imageName,
+		DocumentNamespace: fmt.Sprintf("https://github.com/hpcloud/fissile/sbom/%s", strings.Replace(b.imageName, ":", "/", -1)),
+		CreationInfo: spdxCreationInfo{
+			Created:  b.created.Format(time.RFC3339),
+			Creators: []string{fmt.Sprintf("Tool: fissile-%s", b.fissileVersion)},
+		},
+		Packages: []*spdxPackage{
+			{
+				SPDXID:                imageID,
+				Name:                  b.imageName,
+				VersionInfo:           b.devVersion,
+				DownloadLocation:      "NOASSERTION",
+				PrimaryPackagePurpose: "CONTAINER",
+			},
+		},
+		Relationships: []spdxRelationship{
+			{"SPDXRef-DOCUMENT", "DESCRIBES", imageID},
+			{imageID, "DESCENDANT_OF", baseImageID},
+		},
+	}
+
+	baseImage := &spdxPackage{
+		SPDXID:                baseImageID,
+		Name:                  b.baseImageName,
+		VersionInfo:           b.baseImageDigest,
+		DownloadLocation:      "NOASSERTION",
+		PrimaryPackagePurpose: "CONTAINER",
+	}
+	if algorithm, value := splitDigest(b.baseImageDigest); algorithm == "sha256" {
+		baseImage.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: value}}
+	}
+	document.Packages = append(document.Packages, baseImage)
+
+	for _, entry := range b.releases {
+		release := entry.release
+		releaseID := spdxID("Release", release.Name)
+		releasePackage := &spdxPackage{
+			SPDXID:                releaseID,
+			Name:                  release.Name,
+			VersionInfo:           release.Version,
+			DownloadLocation:      "NOASSERTION",
+			PrimaryPackagePurpose: "ARCHIVE",
+		}
+		if release.CommitHash != "" {
+			releasePackage.SourceInfo = fmt.Sprintf("commit %s", release.CommitHash)
+		}
+		document.Packages = append(document.Packages, releasePackage)
+		document.Relationships = append(document.Relationships, spdxRelationship{imageID, "CONTAINS", releaseID})
+
+		for _, job := range entry.jobs {
+			jobID := spdxID("Job", release.Name, job.Name)
+			document.Packages = append(document.Packages, &spdxPackage{
+				SPDXID:                jobID,
+				Name:                  job.Name,
+				VersionInfo:           job.Version,
+				DownloadLocation:      "NOASSERTION",
+				Checksums:             spdxSHA1(job.SHA1),
+				Comment:               fmt.Sprintf("BOSH job, fingerprint %s", job.Fingerprint),
+				PrimaryPackagePurpose: "APPLICATION",
+			})
+			document.Relationships = append(document.Relationships, spdxRelationship{releaseID, "CONTAINS", jobID})
+			for _, pkg := range job.Packages {
+				document.Relationships = append(document.Relationships,
+					spdxRelationship{jobID, "DEPENDS_ON", spdxID("Package", pkg.Release.Name, pkg.Name)})
+			}
+		}
+
+		for _, pkg := range entry.packages {
+			packageID := spdxID("Package", release.Name, pkg.Name)
+			document.Packages = append(document.Packages, &spdxPackage{
+				SPDXID:                packageID,
+				Name:                  pkg.Name,
+				VersionInfo:           pkg.Version,
+				DownloadLocation:      "NOASSERTION",
+				Checksums:             spdxSHA1(pkg.SHA1),
+				Comment:               fmt.Sprintf("BOSH package, fingerprint %s", pkg.Fingerprint),
+				PrimaryPackagePurpose: "LIBRARY",
+			})
+			document.Relationships = append(document.Relationships, spdxRelationship{releaseID, "CONTAINS", packageID})
+		}
+	}
+
+	return document
+}
+
+type cycloneDXDocument struct {
+	BOMFormat    string                 `json:"bomFormat"`
+	SpecVersion  string                 `json:"specVersion"`
+	Version      int                    `json:"version"`
+	Metadata     cycloneDXMetadata      `json:"metadata"`
+	Components   []*cycloneDXComponent  `json:"components"`
+	Dependencies []*cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string              `json:"timestamp"`
+	Tools     []cycloneDXTool     `json:"tools"`
+	Component *cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cycloneDXComponent struct {
+	Type       string                `json:"type"`
+	BOMRef     string                `json:"bom-ref"`
+	Name       string                `json:"name"`
+	Version    string                `json:"version,omitempty"`
+	Hashes     []cycloneDXHash       `json:"hashes,omitempty"`
+	Properties []cycloneDXProperty   `json:"properties,omitempty"`
+	Components []*cycloneDXComponent `json:"components,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// cycloneDXSHA1 returns the hashes of a component with the given SHA1, if any
+func cycloneDXSHA1(sha1 string) []cycloneDXHash {
+	if sha1 == "" {
+		return nil
+	}
+	return []cycloneDXHash{{Algorithm: "SHA-1", Content: sha1}}
+}
+
+// cycloneDX returns the SBOM as a CycloneDX document. The components of each
+// release are its jobs and packages; the image depends on its base image and
+// on the jobs, which depend on their packages.
+func (b *roleBOM) cycloneDX() *cycloneDXDocument {
+	baseImage := &cycloneDXComponent{
+		Type:    "container",
+		BOMRef:  "base-image",
+		Name:    b.baseImageName,
+		Version: b.baseImageDigest,
+	}
+	if algorithm, value := splitDigest(b.baseImageDigest); algorithm == "sha256" {
+		baseImage.Hashes = []cycloneDXHash{{Algorithm: "SHA-256", Content: value}}
+	}
+
+	document := &cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: b.created.Format(time.RFC3339),
+			Tools:     []cycloneDXTool{{Name: "fissile", Version: b.fissileVersion}},
+			Component: &cycloneDXComponent{
+				Type:    "container",
+				BOMRef:  "image",
+				Name:    b.imageName,
+				Version: b.devVersion,
+			},
+		},
+		Components: []*cycloneDXComponent{baseImage},
+	}
+	image := &cycloneDXDependency{Ref: "image", DependsOn: []string{"base-image"}}
+	document.Dependencies = append(document.Dependencies, image)
+
+	for _, entry := range b.releases {
+		release := entry.release
+		releaseComponent := &cycloneDXComponent{
+			Type:    "application",
+			BOMRef:  fmt.Sprintf("release:%s", release.Name),
+			Name:    release.Name,
+			Version: release.Version,
+		}
+		if release.CommitHash != "" {
+			releaseComponent.Properties = []cycloneDXProperty{{Name: "fissile:commit", Value: release.CommitHash}}
+		}
+		document.Components = append(document.Components, releaseComponent)
+
+		for _, job := range entry.jobs {
+			ref := fmt.Sprintf("job:%s/%s", release.Name, job.Name)
+			releaseComponent.Components = append(releaseComponent.Components, &cycloneDXComponent{
+				Type:       "application",
+				BOMRef:     ref,
+				Name:       job.Name,
+				Version:    job.Version,
+				Hashes:     cycloneDXSHA1(job.SHA1),
+				Properties: []cycloneDXProperty{{Name: "fissile:fingerprint", Value: job.Fingerprint}},
+			})
+			image.DependsOn = append(image.DependsOn, ref)
+
+			dependency := &cycloneDXDependency{Ref: ref}
+			for _, pkg := range job.Packages {
+				dependency.DependsOn = append(dependency.DependsOn, fmt.Sprintf("package:%s/%s", pkg.Release.Name, pkg.Name))
+			}
+			document.Dependencies = append(document.Dependencies, dependency)
+		}
+
+		for _, pkg := range entry.packages {
+			releaseComponent.Components = append(releaseComponent.Components, &cycloneDXComponent{
+				Type:       "library",
+				BOMRef:     fmt.Sprintf("package:%s/%s", release.Name, pkg.Name),
+				Name:       pkg.Name,
+				Version:    pkg.Version,
+				Hashes:     cycloneDXSHA1(pkg.SHA1),
+				Properties: []cycloneDXProperty{{Name: "fissile:fingerprint", Value: pkg.Fingerprint}},
+			})
+		}
+	}
+
+	return document
+}
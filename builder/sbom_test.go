@@ -0,0 +1,150 @@
+package builder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadSBOMTestRole(assert *assert.Assertions) *model.Role {
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	release, err := model.NewDevRelease(releasePath, "", "", filepath.Join(releasePath, "bosh-cache"))
+	if !assert.NoError(err) {
+		return nil
+	}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+	rolesManifest, err := model.LoadRoleManifest(roleManifestPath, []*model.Release{release})
+	if !assert.NoError(err) {
+		return nil
+	}
+	return rolesManifest.Roles[0]
+}
+
+func TestGenerateSBOMSPDX(t *testing.T) {
+	assert := assert.New(t)
+
+	role := loadSBOMTestRole(assert)
+	if role == nil {
+		return
+	}
+	bom, err := newRoleBOM(role, "fissile-myrole:abc", "fissile-role-base:1.0", "sha256:0123", "1.0", time.Unix(0, 0))
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := generateSBOM(bom, SBOMFormatSPDX)
+	if !assert.NoError(err) {
+		return
+	}
+	var document spdxDocument
+	if !assert.NoError(json.Unmarshal(contents, &document)) {
+		return
+	}
+
+	assert.Equal("SPDX-2.3", document.SPDXVersion)
+	assert.Equal("1970-01-01T00:00:00Z", document.CreationInfo.Created)
+	assert.Equal([]string{"Tool: fissile-1.0"}, document.CreationInfo.Creators)
+
+	packages := make(map[string]*spdxPackage)
+	for _, pkg := range document.Packages {
+		packages[pkg.SPDXID] = pkg
+	}
+	if assert.Contains(packages, "SPDXRef-Image") {
+		assert.Equal("fissile-myrole:abc", packages["SPDXRef-Image"].Name)
+		assert.Equal("CONTAINER", packages["SPDXRef-Image"].PrimaryPackagePurpose)
+	}
+	if assert.Contains(packages, "SPDXRef-BaseImage") {
+		assert.Equal("sha256:0123", packages["SPDXRef-BaseImage"].VersionInfo)
+		assert.Equal([]spdxChecksum{{Algorithm: "SHA256", ChecksumValue: "0123"}}, packages["SPDXRef-BaseImage"].Checksums)
+	}
+	if assert.Contains(packages, "SPDXRef-Release-tor") {
+		assert.Equal(role.Jobs[0].Release.Version, packages["SPDXRef-Release-tor"].VersionInfo)
+	}
+	for _, job := range role.Jobs {
+		id := spdxID("Job", "tor", job.Name)
+		if assert.Contains(packages, id) {
+			assert.Equal(job.Version, packages[id].VersionInfo)
+			assert.Equal(spdxSHA1(job.SHA1), packages[id].Checksums)
+		}
+		assert.Contains(document.Relationships, spdxRelationship{"SPDXRef-Release-tor", "CONTAINS", id})
+	}
+	for _, pkg := range role.Jobs.Packages() {
+		id := spdxID("Package", "tor", pkg.Name)
+		if assert.Contains(packages, id) {
+			assert.Equal(pkg.Version, packages[id].VersionInfo)
+			assert.Contains(packages[id].Comment, pkg.Fingerprint)
+		}
+	}
+	assert.Contains(document.Relationships, spdxRelationship{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Image"})
+	assert.Contains(document.Relationships, spdxRelationship{"SPDXRef-Image", "DESCENDANT_OF", "SPDXRef-BaseImage"})
+}
+
+func TestGenerateSBOMCycloneDX(t *testing.T) {
+	assert := assert.New(t)
+
+	role := loadSBOMTestRole(assert)
+	if role == nil {
+		return
+	}
+	bom, err := newRoleBOM(role, "fissile-myrole:abc", "fissile-role-base:1.0", "", "1.0", time.Unix(0, 0))
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := generateSBOM(bom, SBOMFormatCycloneDX)
+	if !assert.NoError(err) {
+		return
+	}
+	var document cycloneDXDocument
+	if !assert.NoError(json.Unmarshal(contents, &document)) {
+		return
+	}
+
+	assert.Equal("CycloneDX", document.BOMFormat)
+	assert.Equal("fissile-myrole:abc", document.Metadata.Component.Name)
+	if !assert.Len(document.Components, 2) {
+		return
+	}
+	assert.Equal("fissile-role-base:1.0", document.Components[0].Name)
+	assert.Empty(document.Components[0].Hashes)
+
+	release := document.Components[1]
+	assert.Equal("tor", release.Name)
+	assert.Len(release.Components, len(role.Jobs)+len(role.Jobs.Packages()))
+	for _, component := range release.Components {
+		assert.NotEmpty(component.Version)
+		if assert.Len(component.Properties, 1) {
+			assert.Equal("fissile:fingerprint", component.Properties[0].Name)
+			assert.NotEmpty(component.Properties[0].Value)
+		}
+	}
+
+	if assert.NotEmpty(document.Dependencies) {
+		image := document.Dependencies[0]
+		assert.Equal("image", image.Ref)
+		assert.Len(image.DependsOn, 1+len(role.Jobs))
+		assert.Equal("base-image", image.DependsOn[0])
+	}
+}
+
+func TestValidateSBOMFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateSBOMFormat(SBOMFormatSPDX))
+	assert.NoError(ValidateSBOMFormat(SBOMFormatCycloneDX))
+	assert.Error(ValidateSBOMFormat("swid"))
+
+	role := &model.Role{Name: "myrole"}
+	assert.Equal("myrole.spdx.json", SBOMFileName(role, SBOMFormatSPDX))
+	assert.Equal("myrole.cdx.json", SBOMFileName(role, SBOMFormatCycloneDX))
+}
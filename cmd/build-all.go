@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/hpcloud/fissile/app"
+	"github.com/hpcloud/fissile/docker"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// buildAllCmd represents the all command
+var buildAllCmd = &cobra.Command{
+	Use:   "all",
+	Short: "Runs the complete build pipeline, from releases to deployment configuration.",
+	Long: `
+This command runs all the steps needed to go from BOSH releases to role images
+and Kubernetes configuration, in the correct order:
+
+- validate the role manifest and opinions
+- build the compilation layer (` + "`fissile build layer compilation`" + `)
+- compile the packages needed by the selected roles (` + "`fissile build packages`" + `)
+- build the stemcell layer (` + "`fissile build layer stemcell`" + `)
+- build the role images (` + "`fissile build images`" + `)
+- generate the values of the configuration variables with a generator
+  (` + "`fissile build values`" + `, with the builtin backend), if --values-file is set
+- write the Kubernetes configuration (` + "`fissile build kube`" + `), if --kube-output-dir is set;
+  the generated values are used as defaults, after those of --defaults-file
+
+The --from image and the base images of roles are pulled in the background from
+the start, while the releases are loaded and checked; the --from image only if
+the compilation or stemcell layer is missing. Images given by digest
+(` + "`<image>@sha256:<digest>`" + `) are verified once pulled.
+
+All steps share the same work directory, so anything built by a previous run
+(layers, compiled packages, images) is reused. The first failing step stops the
+pipeline. A summary with the status and duration of each step is printed at the end.
+
+With several platforms given by --platform, the layers, packages and role
+images are built for each platform in turn, see ` + "`fissile build images`" + `.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		flagPatchPropertiesDirective = buildAllViper.GetString("patch-properties-release")
+
+		err := fissile.SetPatchPropertiesDirective(flagPatchPropertiesDirective)
+		if err != nil {
+			return err
+		}
+
+		listSelection := buildAllViper.GetBool("list-selection")
+		if !listSelection {
+			for _, platform := range flagPlatforms {
+				from, err := docker.ImageForPlatform(buildAllViper.GetString("from"), platform)
+				if err != nil {
+					return err
+				}
+				fissile.PrefetchBaseImages(platform.Repository(flagRepository), from, flagRoleManifest)
+			}
+		}
+
+		err = fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		roleNames := strings.FieldsFunc(buildAllViper.GetString("roles"), func(r rune) bool { return r == ',' })
+		if listSelection {
+			return fissile.ListRoleSelection(flagRoleManifest, roleNames, flagOutputFormat)
+		}
+
+		kubeOutputDir := buildAllViper.GetString("kube-output-dir")
+		if kubeOutputDir != "" {
+			if kubeOutputDir, err = absolutePath(kubeOutputDir); err != nil {
+				return err
+			}
+		}
+
+		valuesFile := buildAllViper.GetString("values-file")
+		if valuesFile != "" {
+			if valuesFile, err = absolutePath(valuesFile); err != nil {
+				return err
+			}
+		}
+
+		return fissile.BuildPipeline(&app.PipelineSettings{
+			BaseImage:           buildAllViper.GetString("from"),
+			Repository:          flagRepository,
+			MetricsPath:         flagMetrics,
+			RoleManifestPath:    flagRoleManifest,
+			LightOpinionsPath:   flagLightOpinions,
+			DarkOpinionsPath:    flagDarkOpinions,
+			CompilationDir:      workPathCompilationDir,
+			BaseDockerfileDir:   workPathBaseDockerfile,
+			DockerDir:           workPathDockerDir,
+			RoleNames:           roleNames,
+			WorkerCount:         flagWorkers,
+			Force:               buildAllViper.GetBool("force"),
+			KubeOutputDir:       kubeOutputDir,
+			KubeDefaultEnvFiles: splitNonEmpty(buildAllViper.GetString("defaults-file"), ","),
+			KubeRegistry:        buildAllViper.GetString("docker-registry"),
+			KubeOrganization:    buildAllViper.GetString("docker-organization"),
+			KubeUseMemoryLimits: buildAllViper.GetBool("use-memory-limits"),
+			KubeConfigProvider:  buildAllViper.GetString("provider"),
+			KubeVaultPath:       buildAllViper.GetString("vault-path"),
+			ValuesFile:          valuesFile,
+			ValuesNamespace:     buildAllViper.GetString("namespace"),
+			Platforms:           flagPlatforms,
+		})
+	},
+}
+
+var buildAllViper = viper.New()
+
+func init() {
+	initViper(buildAllViper)
+
+	buildCmd.AddCommand(buildAllCmd)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"from",
+		"",
+		"ubuntu:14.04",
+		"Docker image used as a base for the compilation and stemcell layers; images for some platforms are given as <platform>=<image>, comma separated",
+	)
+
+	buildAllCmd.PersistentFlags().BoolP(
+		"force",
+		"F",
+		false,
+		"If specified, role image creation will proceed even when images already exist.",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"patch-properties-release",
+		"P",
+		"",
+		"Used to designate a \"patch-properties\" psuedo-job in a particular release.  Format: RELEASE/JOB.",
+	)
+
+	// viper is busted w/ string slice, https://github.com/spf13/viper/issues/200
+	buildAllCmd.PersistentFlags().StringP(
+		"roles",
+		"",
+		"",
+		"Build only the selected roles (and their packages)"+rolesFlagUsage,
+	)
+
+	addListSelectionFlag(buildAllCmd)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"kube-output-dir",
+		"k",
+		"",
+		"Kubernetes configuration files will be written to this directory; skipped if empty",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"defaults-file",
+		"D",
+		"",
+		"Env files that contain defaults for the parameters generated by kube",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"docker-registry",
+		"",
+		"",
+		"Docker registry used when referencing image names",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"docker-organization",
+		"",
+		"",
+		"Docker organization used when referencing image names",
+	)
+
+	buildAllCmd.PersistentFlags().BoolP(
+		"use-memory-limits",
+		"",
+		true,
+		"Include memory limits when generating kube configurations",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"provider",
+		"",
+		"env",
+		"How configuration values are passed to the containers: env, k8s (ConfigMaps and Secrets) or vault",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"vault-path",
+		"",
+		"secret/fissile",
+		"Vault KV path the secrets of the roles are stored under, with --provider vault",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"values-file",
+		"",
+		"",
+		"Env file the generated values are written to; skipped if empty",
+	)
+
+	buildAllCmd.PersistentFlags().StringP(
+		"namespace",
+		"",
+		"",
+		"Kubernetes namespace the roles run in, used in the names of generated certificates",
+	)
+
+	buildAllViper.BindPFlags(buildAllCmd.PersistentFlags())
+}
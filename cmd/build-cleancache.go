@@ -1,7 +1,13 @@
 package cmd
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // buildCleanCacheCmd represents the cleancache command
@@ -10,10 +16,22 @@ var buildCleanCacheCmd = &cobra.Command{
 	Short: "Removes unused BOSH packages from the compilation cache.",
 	Long: `
 This command will inspect the compilation cache populated by its sibling "packages"
-and remove all which are not required anymore.`,
+and remove all which are not required anymore.
+
+Packages used by the loaded releases are always kept. With --keep-versions N,
+the packages used by the N most recent dev versions of each release are kept as
+well. With --older-than, only packages compiled before the given duration ago
+are removed (e.g. ` + "`30d`, `12h`" + `).
+
+The space reclaimed is reported at the end.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		err := fissile.LoadReleases(
+		olderThan, err := parseDurationWithDays(buildCleanCacheViper.GetString("older-than"))
+		if err != nil {
+			return err
+		}
+
+		err = fissile.LoadReleases(
 			flagRelease,
 			flagReleaseName,
 			flagReleaseVersion,
@@ -23,10 +41,56 @@ and remove all which are not required anymore.`,
 			return err
 		}
 
-		return fissile.CleanCache(workPathCompilationDir)
+		return fissile.CleanCache(
+			workPathCompilationDir,
+			buildCleanCacheViper.GetInt("keep-versions"),
+			olderThan,
+		)
 	},
 }
 
+var buildCleanCacheViper = viper.New()
+
 func init() {
+	initViper(buildCleanCacheViper)
+
 	buildCmd.AddCommand(buildCleanCacheCmd)
+
+	buildCleanCacheCmd.PersistentFlags().IntP(
+		"keep-versions",
+		"",
+		0,
+		"Also keep the packages of this many most recent dev versions of each release",
+	)
+
+	buildCleanCacheCmd.PersistentFlags().StringP(
+		"older-than",
+		"",
+		"",
+		"Only remove packages compiled longer ago than this (e.g. 30d, 12h); all if empty",
+	)
+
+	buildCleanCacheViper.BindPFlags(buildCleanCacheCmd.PersistentFlags())
+}
+
+// parseDurationWithDays parses a duration like time.ParseDuration, with
+// additional support for a number of days (e.g. "30d")
+func parseDurationWithDays(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("Invalid duration %s", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("Invalid duration %s", value)
+	}
+	return duration, nil
 }
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// buildComposeCmd represents the compose command
+var buildComposeCmd = &cobra.Command{
+	Use:   "compose",
+	Short: "Creates a docker-compose file for local development.",
+	Long: `
+Writes a docker-compose file into --compose-file which runs all roles of the
+role manifest, including dev-only ones, on a single docker host.
+
+Each role becomes a service named after it, running its image as built by
+"build images". Public ports are published on the host, persistent and shared
+volumes become named volumes, and the configuration variables of the role are
+set in its environment, from the role manifest and the --defaults-file env
+files. Roles wait for those of the previous flight stage to be started; roles
+of the manual flight stage are left out. Sidecars become services of their
+own, sharing the network of their role. The memory and CPU requests and limits
+of a role, and the shm-size, memory-swap and ulimits of its resources,
+constrain its service, which restarts according to the restart policy of the
+role.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		composeFile, err := absolutePath(buildComposeViper.GetString("compose-file"))
+		if err != nil {
+			return err
+		}
+
+		err = fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.GenerateCompose(
+			flagRoleManifest,
+			composeFile,
+			flagRepository,
+			buildComposeViper.GetString("docker-registry"),
+			buildComposeViper.GetString("docker-organization"),
+			splitNonEmpty(buildComposeViper.GetString("defaults-file"), ","),
+		)
+	},
+}
+
+var buildComposeViper = viper.New()
+
+func init() {
+	initViper(buildComposeViper)
+
+	buildCmd.AddCommand(buildComposeCmd)
+
+	buildComposeCmd.PersistentFlags().StringP(
+		"compose-file",
+		"",
+		"docker-compose.yml",
+		"Path the docker-compose file is written to",
+	)
+
+	buildComposeCmd.PersistentFlags().StringP(
+		"defaults-file",
+		"D",
+		"",
+		"Env files that contain values of the configuration variables; comma separated",
+	)
+
+	buildComposeCmd.PersistentFlags().StringP(
+		"docker-registry",
+		"",
+		"",
+		"Docker registry used when referencing image names",
+	)
+
+	buildComposeCmd.PersistentFlags().StringP(
+		"docker-organization",
+		"",
+		"",
+		"Docker organization used when referencing image names",
+	)
+
+	buildComposeViper.BindPFlags(buildComposeCmd.PersistentFlags())
+}
@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"fmt"
 	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/docker"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -28,7 +33,13 @@ a Dockerfile and a directory structure that gets ADDed to the docker image. The
 directory structure contains jobs, packages and all other necessary scripts and 
 templates.
 
-The images will have a 'role' label useful for filtering.
+The images will have a 'role' label useful for filtering. Labels record what
+they were built from, so a running container can be traced back to its inputs:
+the role name, role dev version and build time (as ` + "`org.opencontainers.image.title`" + `,
+` + "`version`" + ` and ` + "`created`" + `), the fissile version (` + "`fissile.version`" + `), the
+releases of the role's jobs (` + "`fissile.releases`" + `), and the SHA1 of the role
+manifest (` + "`fissile.role-manifest.sha1`" + `). For reproducible builds, the build
+time recorded is that of SOURCE_DATE_EPOCH (seconds since the epoch) if set.
 The entrypoint for each image is ` + "`/opt/hcf/run.sh`" + `.
 
 Before running this command, you should run ` + "`fissile build layer stemcell`" + `.
@@ -37,6 +48,87 @@ The images will be tagged: ` + "`<repository>-<role_name>:<SIGNATURE>`" + `.
 The SIGNATURE is based on the hashes of all jobs and packages that are included in
 the image.
 
+The compiled packages are not copied into each role image. Roles using the same
+set of packages are built on a shared packages layer image, tagged
+` + "`<repository>-role-packages:<SIGNATURE>`" + ` where the SIGNATURE is based on
+the package fingerprints only. Packages layers reuse existing ones holding some
+of their packages, so packages common to several roles are stored once.
+
+Roles setting a ` + "`base-image`" + ` in the role manifest are built on that image
+instead of the role base image, which is pulled if it doesn't exist locally.
+The pulls start in the background while the releases are loaded and checked.
+Base images given by digest (` + "`<image>@sha256:<digest>`" + `) are verified.
+It has to provide what the role base image does, e.g. by being built with
+` + "`fissile build layer stemcell`" + ` from another stemcell, or FROM the role base
+image with additional OS packages.
+
+Roles can also customize their image with Dockerfile fragments, without a base
+image of their own. The files listed by ` + "`image.pre-snippets`" + ` in the role
+manifest, relative to it, are spliced into the Dockerfile of the role before
+the files of the role are added, and those listed by ` + "`image.post-snippets`" + `
+after, before switching to the user the role runs as; e.g. to install OS
+packages or set up locales. Snippets must not start a build stage of their
+own with FROM. Their contents are part of the role version.
+
+Before anything is built, the job templates of the selected roles are checked
+for ERB syntax errors (unclosed tags, unterminated strings, unbalanced brackets
+and blocks); all problems are reported with their file and line.
+
+Images are built in parallel, using the number of workers given by --workers.
+Output lines are prefixed with the name of their role. The first failure stops
+the scheduling of further builds; all failed roles are listed at the end.
+
+With --push, the images of the selected roles are tagged and pushed once they
+are built, like ` + "`fissile images push`" + ` does; it takes the same flags.
+
+Images can be built without a docker daemon. With --output-directory, the
+complete build context of each role and packages layer image, Dockerfile
+included, is written as a tarball instead, for any builder to use. With
+--builder, fissile runs one of the builders ` + "`kaniko`" + `, ` + "`buildkit`" + `, ` + "`buildah`" + `, or
+` + "`img`" + ` on each build context; --builder-command sets the path of its executable.
+External builders always build the images, as fissile can't check whether
+they exist, and pull the base images themselves: the role base image has to
+be in a registry they can reach, as ` + "`<repository>-role-base:<version>`" + `.
+kaniko and BuildKit push the images to the registry of their name, given by
+--repository.
+
+BuildKit builds run ` + "`buildctl`" + ` against a buildkitd, which solves the steps the
+concurrent builds share, such as their packages layer, once. With
+--builder-cache-directory, the build cache of each image is exported to it and
+imported at the next build, so that it outlives the buildkitd. Dockerfile
+snippets can use cache mounts (` + "`RUN --mount=type=cache,target=/var/cache/apt`" + `)
+when building with BuildKit.
+
+Images are built for each platform given by --platform, from the packages
+compiled and the stemcell layer built for it. Those of other platforms than
+linux/amd64 are named after the repository suffixed with the platform, as
+` + "`<repository>-linux-arm64-<role_name>:<SIGNATURE>`" + `, and their assets are in
+` + "`<work-dir>/dockerfiles-linux-arm64`" + ` (or the --output-directory suffixed
+likewise). With --push, the image of each platform is pushed, and a manifest
+list referring to them is pushed as the name of the role image.
+
+With --squash, the layers each role image adds to its packages layer image are
+merged into one once it is built, leaving out the files later layers replace
+or remove; the packages layer stays shared with the other roles. Use
+` + "`fissile images analyze --built`" + ` to see what takes up space in the images.
+
+With --sbom, a software bill of materials (SBOM) is written for each role
+image, as JSON in the format given: ` + "`spdx`" + ` (SPDX 2.3) or ` + "`cyclonedx`" + `
+(CycloneDX 1.4). It lists the image, the image its packages layer is built on
+with its digest, the releases of the role with their versions, and their jobs
+and packages with their versions, SHA1s and fingerprints. The SBOM of each
+role is written to ` + "`<role>.spdx.json`" + ` or ` + "`<role>.cdx.json`" + ` in the --sbom-directory.
+The digest of the base image is only known for images built by the docker
+daemon, or base images given by digest.
+
+With --watch, the role manifest, the opinions, the scripts and Dockerfile
+snippets of the roles, and the indexes of the dev releases are watched once
+the images are built, checking them every --watch-interval. Once they change,
+the releases and the role manifest are loaded again, and the images of the
+roles whose version changed are built. Changes to job templates and packages
+are picked up once the dev release is created again. Failures are reported,
+and watching goes on until the command is interrupted.
+
 The --patch-properties-release flag is used to distinguish the patchProperties release/job spec
 from other specs.  At most one is allowed.  Its syntax is --patch-properties-release=<RELEASE>/<JOB>.
 	`,
@@ -52,6 +144,32 @@ from other specs.  At most one is allowed.  Its syntax is --patch-properties-rel
 		if err != nil {
 			return err
 		}
+
+		fissile.SetSquashImages(buildImagesViper.GetBool("squash"))
+
+		imageBuilder := buildImagesViper.GetString("builder")
+		builderCacheDirectory := buildImagesViper.GetString("builder-cache-directory")
+		if builderCacheDirectory != "" {
+			if builderCacheDirectory, err = absolutePath(builderCacheDirectory); err != nil {
+				return err
+			}
+		}
+		err = fissile.SetImageBuilder(imageBuilder, buildImagesViper.GetString("builder-command"), builderCacheDirectory)
+		if err != nil {
+			return err
+		}
+		externalBuilder := imageBuilder != "" && imageBuilder != builder.DockerBuilder
+		if externalBuilder && flagOutputDirectory != "" {
+			return fmt.Errorf("--builder can't be combined with --output-directory")
+		}
+
+		listSelection := buildImagesViper.GetBool("list-selection")
+		if flagOutputDirectory == "" && !flagBuildImagesNoBuild && !listSelection && !externalBuilder {
+			for _, platform := range flagPlatforms {
+				fissile.PrefetchBaseImages(platform.Repository(flagRepository), "", flagRoleManifest)
+			}
+		}
+
 		err = fissile.LoadReleases(
 			flagRelease,
 			flagReleaseName,
@@ -67,20 +185,81 @@ from other specs.  At most one is allowed.  Its syntax is --patch-properties-rel
 			flagBuildImagesForce = true
 		}
 
-		return fissile.GenerateRoleImages(
-			workPathDockerDir,
-			flagRepository,
-			flagMetrics,
-			flagBuildImagesNoBuild,
-			flagBuildImagesForce,
-			strings.FieldsFunc(flagBuildImagesRoles, func(r rune) bool { return r == ',' }),
-			flagWorkers,
-			flagRoleManifest,
-			workPathCompilationDir,
-			flagLightOpinions,
-			flagDarkOpinions,
-			flagOutputDirectory,
-		)
+		roleNames := strings.FieldsFunc(flagBuildImagesRoles, func(r rune) bool { return r == ',' })
+		if listSelection {
+			return fissile.ListRoleSelection(flagRoleManifest, roleNames, flagOutputFormat)
+		}
+
+		push := buildImagesViper.GetBool("push")
+		if push && (flagBuildImagesNoBuild || flagOutputDirectory != "" || externalBuilder) {
+			return fmt.Errorf("--push can't be combined with --no-build, --output-directory or --builder")
+		}
+		watch := buildImagesViper.GetBool("watch")
+		if watch && push {
+			return fmt.Errorf("--watch can't be combined with --push")
+		}
+
+		build := func(roleNames []string) error {
+			return forEachPlatform(func(platform docker.Platform) error {
+				outputDirectory := flagOutputDirectory
+				if outputDirectory != "" {
+					outputDirectory = platform.Dir(outputDirectory)
+				}
+				sbomDirectory := buildImagesViper.GetString("sbom-directory")
+				if sbomDirectory == "" {
+					sbomDirectory = outputDirectory
+				} else {
+					sbomDirectory = platform.Dir(sbomDirectory)
+				}
+				if sbomDirectory == "" {
+					sbomDirectory = platform.Dir(workPathSBOMDir)
+				}
+				if err := fissile.SetSBOM(buildImagesViper.GetString("sbom"), sbomDirectory); err != nil {
+					return err
+				}
+
+				return fissile.GenerateRoleImages(
+					platform.Dir(workPathDockerDir),
+					platform.Repository(flagRepository),
+					flagMetrics,
+					flagBuildImagesNoBuild,
+					flagBuildImagesForce,
+					roleNames,
+					flagWorkers,
+					flagRoleManifest,
+					platform.Dir(workPathCompilationDir),
+					flagLightOpinions,
+					flagDarkOpinions,
+					outputDirectory,
+				)
+			})
+		}
+
+		if watch {
+			reload := func() error {
+				return fissile.LoadReleases(
+					flagRelease,
+					flagReleaseName,
+					flagReleaseVersion,
+					flagCacheDir,
+				)
+			}
+			var opinions []string
+			for _, path := range []string{flagLightOpinions, flagDarkOpinions} {
+				if path != "" {
+					opinions = append(opinions, path)
+				}
+			}
+			return fissile.WatchRoleImages(flagRoleManifest, roleNames, opinions,
+				buildImagesViper.GetDuration("watch-interval"), reload, build, nil)
+		}
+
+		err = build(roleNames)
+		if err != nil || !push {
+			return err
+		}
+
+		return pushRoleImages(buildImagesViper, roleNames)
 	},
 }
 var buildImagesViper = viper.New()
@@ -116,9 +295,11 @@ func init() {
 		"roles",
 		"",
 		"",
-		"Build only images with the given role name; comma separated.",
+		"Build only images of the selected roles"+rolesFlagUsage,
 	)
 
+	addListSelectionFlag(buildImagesCmd)
+
 	buildImagesCmd.PersistentFlags().StringP(
 		"output-directory",
 		"O",
@@ -126,5 +307,70 @@ func init() {
 		"Output the result as tar files in the given directory rather than building with docker",
 	)
 
+	buildImagesCmd.PersistentFlags().BoolP(
+		"squash",
+		"",
+		false,
+		"If specified, the layers of each role image above its packages layer are merged into one.",
+	)
+
+	buildImagesCmd.PersistentFlags().StringP(
+		"sbom",
+		"",
+		"",
+		"Write a software bill of materials of each role image, as "+strings.Join(builder.SBOMFormats(), " or "),
+	)
+
+	buildImagesCmd.PersistentFlags().StringP(
+		"sbom-directory",
+		"",
+		"",
+		"Directory the bills of materials are written to; defaults to the --output-directory, else <work-dir>/sbom",
+	)
+
+	buildImagesCmd.PersistentFlags().StringP(
+		"builder",
+		"",
+		builder.DockerBuilder,
+		"Tool building the images: "+builder.DockerBuilder+" (the docker daemon), "+strings.Join(builder.ExternalBuilderNames(), ", "),
+	)
+
+	buildImagesCmd.PersistentFlags().StringP(
+		"builder-command",
+		"",
+		"",
+		"Path of the executable of the --builder, if not the usual one",
+	)
+
+	buildImagesCmd.PersistentFlags().StringP(
+		"builder-cache-directory",
+		"",
+		"",
+		"Directory "+builder.BuildKitBuilder+" keeps the build cache of each image in, across builds",
+	)
+
+	buildImagesCmd.PersistentFlags().BoolP(
+		"watch",
+		"",
+		false,
+		"Keep watching the role manifest, opinions, scripts and releases, building the roles which change",
+	)
+
+	buildImagesCmd.PersistentFlags().DurationP(
+		"watch-interval",
+		"",
+		time.Second,
+		"How often the files are checked for changes with --watch",
+	)
+
+	buildImagesCmd.PersistentFlags().BoolP(
+		"push",
+		"",
+		false,
+		"Tag and push the images once they are built",
+	)
+
+	addPushFlags(buildImagesCmd)
+
 	buildImagesViper.BindPFlags(buildImagesCmd.PersistentFlags())
 }
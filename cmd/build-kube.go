@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/hpcloud/fissile/kube"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -11,13 +15,75 @@ var (
 	flagBuildKubeDockerRegistry     string
 	flagBuildKubeDockerOrganization string
 	flagBuildKubeUseMemoryLimits    bool
+	flagBuildKubeProvider           string
+	flagBuildKubeVaultPath          string
+	flagBuildKubeEnvironments       []string
+	flagBuildKubeEnvironmentsDir    string
 )
 
 // buildKubeCmd represents the kube command
 var buildKubeCmd = &cobra.Command{
 	Use:   "kube",
 	Short: "Creates Kubernetes configuration files.",
-	Long:  ``,
+	Long: `
+Writes a Kubernetes configuration file for each role into --kube-output-dir.
+
+With --provider env (the default), the values of the configuration variables
+are set directly in the environment of the containers. Secret variables, those
+with a generator or marked with ` + "`secret: true`" + `, are never written in plain
+text: their values go into a Secret (` + "`<role>-secret`" + `) per role, referenced
+from the containers. With --provider k8s, the other values are written into a
+ConfigMap (` + "`<role>-config`" + `) per role too.
+
+With --provider vault, those secret variables are kept out of the Kubernetes
+configuration altogether. Their values are written to
+` + "`vault-secrets.json`" + ` in --kube-output-dir instead, keyed by the Vault KV
+path of each role (` + "`<vault-path>/<role>`" + `), ready to be imported into
+Vault. The containers get that path in ` + "`VAULT_SECRETS_PATH`" + `, and read the
+secrets from there when they start, exporting them like the other variables,
+which are set directly in their environment. They access Vault with the
+` + "`address`" + ` and ` + "`token`" + ` of the Secret named ` + "`vault`" + `, which has to be created
+along with the roles.
+
+With --provider envfiles, the values are set directly in the environment of
+the containers as with --provider env, and also written to an env file per role,
+` + "`<role>.env`" + ` in --kube-output-dir, for ` + "`docker run --env-file`" + ` and local
+debugging. Secret values, and values spanning several lines, are not written
+to those files; they are left out, with a comment. The files are readable by
+their owner only.
+
+With --environments, the configuration of several named environments is
+written in one run, each into ` + "`<kube-output-dir>/<environment>`" + `, and with
+--provider vault, with its secrets under ` + "`<vault-path>/<environment>`" + `; the
+vault provider then needs --vault-path. Environments only differ in the values
+of their configuration variables: the --defaults-file env files are read for
+all of them, and each overlays them with ` + "`<environment>.env`" + ` from
+--environments-dir, which has to exist when --environments-dir is set. Values
+shared by all environments, such as secrets generated once, go into the
+--defaults-file env files; fissile doesn't generate secrets here. Opinions are
+built into the role images, so they are the same in every environment.
+
+Roles whose ` + "`scaling.max`" + ` is above their ` + "`scaling.min`" + ` also get a
+HorizontalPodAutoscaler, scaling them between the two at the average CPU
+utilization given by ` + "`scaling.cpu-target`" + ` (in percent of the CPU request),
+and at the per pod ` + "`scaling.custom-metrics`" + ` targets. Canary instances are
+not scaled, and roles deployed with no instances are left alone until scaled up.
+
+Each role exposing ports gets a ClusterIP service named after it. The ports
+marked ` + "`public`" + ` are also made reachable from outside the cluster, by a
+service ` + "`<role>-public`" + ` of the type given by --public-service-type. Roles
+with an ` + "`ingress`" + ` in the role manifest (` + "`host`" + `, optional ` + "`path`" + `, ` + "`port`" + ` and
+` + "`tls.secret-name`" + `) get an Ingress routing the HTTP requests for their host
+and path to that port of their service.
+
+Roles with an ` + "`active-passive-probe`" + ` only get traffic to the pod the probe
+succeeds on: their services select the pods labelled ` + "`skiff-role-active: \"true\"`" + `.
+The pods set that label themselves, as the service account ` + "`<role>`" + `, which is
+written along with them and allowed to do so.
+
+Roles with a ` + "`depends-on`" + ` list wait for those roles to be ready, and for tasks
+to have succeeded, in the init container ` + "`wait-for-roles`" + ` of their pods.
+`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
 		flagBuildKubeOutputDir = viper.GetString("kube-output-dir")
@@ -25,6 +91,18 @@ var buildKubeCmd = &cobra.Command{
 		flagBuildKubeDockerRegistry = viper.GetString("docker-registry")
 		flagBuildKubeDockerOrganization = viper.GetString("docker-organization")
 		flagBuildKubeUseMemoryLimits = viper.GetBool("use-memory-limits")
+		flagBuildKubeProvider = viper.GetString("provider")
+		flagBuildKubeVaultPath = viper.GetString("vault-path")
+		flagBuildKubeEnvironments = splitNonEmpty(viper.GetString("environments"), ",")
+		flagBuildKubeEnvironmentsDir = viper.GetString("environments-dir")
+
+		if flagBuildKubeEnvironmentsDir != "" && len(flagBuildKubeEnvironments) == 0 {
+			return fmt.Errorf("--environments-dir requires --environments")
+		}
+
+		if err := fissile.SetPublicServiceType(viper.GetString("public-service-type")); err != nil {
+			return err
+		}
 
 		err := fissile.LoadReleases(
 			flagRelease,
@@ -36,6 +114,22 @@ var buildKubeCmd = &cobra.Command{
 			return err
 		}
 
+		if len(flagBuildKubeEnvironments) > 0 {
+			return fissile.GenerateKubeEnvironments(
+				flagBuildKubeEnvironments,
+				flagBuildKubeEnvironmentsDir,
+				flagRoleManifest,
+				flagBuildKubeOutputDir,
+				flagRepository,
+				flagBuildKubeDockerRegistry,
+				flagBuildKubeDockerOrganization,
+				flagBuildKubeDefaultEnvFiles,
+				flagBuildKubeUseMemoryLimits,
+				flagBuildKubeProvider,
+				flagBuildKubeVaultPath,
+			)
+		}
+
 		return fissile.GenerateKube(
 			flagRoleManifest,
 			flagBuildKubeOutputDir,
@@ -44,6 +138,8 @@ var buildKubeCmd = &cobra.Command{
 			flagBuildKubeDockerOrganization,
 			flagBuildKubeDefaultEnvFiles,
 			flagBuildKubeUseMemoryLimits,
+			flagBuildKubeProvider,
+			flagBuildKubeVaultPath,
 		)
 
 	},
@@ -87,5 +183,40 @@ func init() {
 		"Include memory limits when generating kube configurations",
 	)
 
+	buildKubeCmd.PersistentFlags().StringP(
+		"provider",
+		"",
+		"env",
+		"How configuration values are passed to the containers: env, envfiles, k8s (ConfigMaps and Secrets) or vault",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"vault-path",
+		"",
+		"secret/fissile",
+		"Vault KV path the secrets of the roles are stored under, with --provider vault",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"environments",
+		"",
+		"",
+		"Names of the environments to write configuration files for, each into its own directory; comma separated",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"environments-dir",
+		"",
+		"",
+		"Directory with an <environment>.env file of values for each of --environments",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"public-service-type",
+		"",
+		kube.PublicServiceTypeLoadBalancer,
+		"Type of the services making the public ports of roles reachable: "+kube.PublicServiceTypeLoadBalancer+" or "+kube.PublicServiceTypeNodePort,
+	)
+
 	viper.BindPFlags(buildKubeCmd.PersistentFlags())
 }
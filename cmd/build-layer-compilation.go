@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"github.com/hpcloud/fissile/docker"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -23,19 +25,30 @@ removed.
 
 If the prerequisites script fails, the container is not removed. 
 If the compilation base image already exists, this command does not do anything.
+The --from image is pulled first if it doesn't exist locally, within the limits
+of --transfer-workers, --registry-transfer-workers and --transfer-bandwidth.
+
+A layer is built for each platform given by --platform. Those of other
+platforms than linux/amd64 are named after the repository suffixed with the
+platform, as ` + "`<repository>-linux-arm64-cbase:<FISSILE_VERSION>`" + `.
 	`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
 		flagBuildLayerCompilationDebug = viper.GetBool("debug")
 
-		err := fissile.CreateBaseCompilationImage(
-			flagBuildLayerFrom,
-			flagRepository,
-			flagMetrics,
-			flagBuildLayerCompilationDebug,
-		)
+		return forEachPlatform(func(platform docker.Platform) error {
+			from, err := docker.ImageForPlatform(flagBuildLayerFrom, platform)
+			if err != nil {
+				return err
+			}
 
-		return err
+			return fissile.CreateBaseCompilationImage(
+				from,
+				platform.Repository(flagRepository),
+				flagMetrics,
+				flagBuildLayerCompilationDebug,
+			)
+		})
 	},
 }
 
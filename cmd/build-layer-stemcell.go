@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"github.com/hpcloud/fissile/docker"
+
 	"github.com/spf13/cobra"
 )
 
@@ -14,17 +16,31 @@ similar to BOSH 'stemcells'.
 
 Fissile will create a Dockerfile and a directory structure with all dependencies in 
 ` + "`<work-dir>/base_dockerfile`" + `. After that, it will build an image named 
-` + "`<repository>-role-base:<FISSILE_VERSION>`" + `.
+` + "`<repository>-role-base:<FISSILE_VERSION>`" + `. The --from image is pulled first
+if it doesn't exist locally, within the limits of --transfer-workers,
+--registry-transfer-workers and --transfer-bandwidth.
+
+A layer is built for each platform given by --platform. Those of other
+platforms than linux/amd64 are named after the repository suffixed with the
+platform, as ` + "`<repository>-linux-arm64-role-base:<FISSILE_VERSION>`" + `,
+and their assets are in ` + "`<work-dir>/base_dockerfile-linux-arm64`" + `.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		return fissile.GenerateBaseDockerImage(
-			workPathBaseDockerfile,
-			flagBuildLayerFrom,
-			flagMetrics,
-			flagBuildLayerNoBuild,
-			flagRepository,
-		)
+		return forEachPlatform(func(platform docker.Platform) error {
+			from, err := docker.ImageForPlatform(flagBuildLayerFrom, platform)
+			if err != nil {
+				return err
+			}
+
+			return fissile.GenerateBaseDockerImage(
+				platform.Dir(workPathBaseDockerfile),
+				from,
+				flagMetrics,
+				flagBuildLayerNoBuild,
+				platform.Repository(flagRepository),
+			)
+		})
 	},
 }
 
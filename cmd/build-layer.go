@@ -36,7 +36,7 @@ func init() {
 		"from",
 		"F",
 		"ubuntu:14.04",
-		"Docker image used as a base for the layers",
+		"Docker image used as a base for the layers; images for some platforms are given as <platform>=<image>, comma separated, e.g. ubuntu:14.04,linux/arm64=arm64v8/ubuntu:14.04",
 	)
 
 	buildLayerCmd.PersistentFlags().BoolP(
@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/hpcloud/fissile/compilator"
+	"github.com/hpcloud/fissile/docker"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -25,13 +29,156 @@ Compiled packages are stored in ` + "`<work-dir>/compilation`" + `. Fissile uses
 package's fingerprint as part of the directory structure. This means that if the 
 same package (with the same version) is used by multiple releases, it will only be 
 compiled once.
+
+Packages are only compiled when they have changed since the last run: next to
+each compiled package, the fingerprints of the packages it depends on
+(directly or not) are recorded, and the package is compiled again when any of
+them differ. Packages compiled by older versions of fissile have no such record
+and are compiled again. Use --force to compile all packages regardless.
+
+The state of each package (pending, compiling, or done along with a checksum
+of the compiled package) is recorded next to it, so that an interrupted run is
+resumed: only the packages it didn't finish are compiled again, once whatever
+they left behind is discarded. Compiled packages altered since they were
+stored are compiled again too.
+
+Packages are compiled in parallel, using the number of workers given by
+--workers. On a terminal, a live table shows the packages in progress, with
+their state (waiting for dependencies, compiling) and duration, and packages
+are listed once finished; otherwise, a line prefixed with the package is logged
+for each change of state. The output of failed compilations is printed whole.
+
+With --stats-file, the statistics of the run are written to the given JSON
+file: for each package, its state (done, cached, failed, killed, or
+compiled-before when an earlier run compiled it), the worker which handled it,
+whether it was found in the package cache, the time spent waiting for its
+dependencies, compiling, and overall, and the size of the compiled package;
+along with totals for the run. Statistics of platforms other than linux/amd64
+go to files named after the platform, e.g. ` + "`stats-linux-arm64.json`" + `.
+
+With --package-cache, compiled packages are shared between machines. Before a
+package is compiled, it is looked up in the cache, by its fingerprint and
+those of its dependencies; after it is compiled, it is stored in the cache,
+unless --package-cache-read-only is set. Failing to reach the cache only
+causes a warning. The cache is one of:
+
+  <directory>           a directory, e.g. on a shared file system
+  http(s)://<url>       a bucket accessed with GET and PUT requests, sending
+                        the --package-cache-header headers
+  s3://<bucket>/<path>  an S3 bucket, using the credentials and region in
+                        AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+                        AWS_SESSION_TOKEN and AWS_REGION
+  gs://<bucket>/<path>  a GCS bucket, using the OAuth access token in
+                        GOOGLE_OAUTH_ACCESS_TOKEN
+
+Packages are compiled for each platform given by --platform, in the
+compilation layer of the platform. Those compiled for other platforms than
+linux/amd64 are stored in ` + "`<work-dir>/compilation-<platform>`" + `, e.g.
+` + "`<work-dir>/compilation-linux-arm64`" + `, and under a directory named after
+the platform in the package cache. Compiling for another architecture than the
+host's runs the compilation containers emulated by QEMU, which has to be
+registered with binfmt_misc (e.g. by the ` + "`multiarch/qemu-user-static`" + ` image);
+it can't be done with --without-docker.
+
+--compiler selects where packages are compiled:
+
+  docker   in local containers (default)
+  mountns  without docker, like --without-docker
+  kube     as Kubernetes jobs, through kubectl
+
+With --compiler kube, each package is compiled by a job named
+` + "`compile-<PACKAGE_NAME>-<HASH>`" + ` in the --kube-context and --kube-namespace
+(those of the current kubeconfig context by default). Its pod runs the
+compilation base image, which the cluster has to be able to pull, or the image
+given by --kube-image. The inputs of the compilation are uploaded into the pod,
+and its output and the compiled package are downloaded from it, all through
+kubectl. The pods work in node storage, or, with --kube-volume, in
+a directory of their own on the given persistent volume claim. Jobs are
+removed once their package is collected; --kube-timeout bounds how long a job
+may take.
+
+With --keep-failed-containers, the container of a failed compilation is kept
+running, along with its volumes, instead of being removed; its name and ID are
+printed, with the command entering it to reproduce the failure by hand. The
+compilation script is ` + "`/fissile-in/compile.sh`" + `, and the sources of the
+package are in ` + "`/var/vcap/source`" + `. With --compiler kube, the job of the
+failed compilation is kept instead. Containers and jobs kept are replaced when
+their package is compiled again.
+
+--memory and --cpus limit the memory (e.g. 4g) and number of CPUs (e.g. 1.5)
+each compilation may use, in its container or job. Packages needing more or
+less are given their own limits in the YAML file of --compile-limits, by
+package name or by <release>/<package>; --memory and --cpus override the
+limits it gives for all packages:
+
+  memory: 2g
+  cpus: 1
+  packages:
+    ruby-2.5: {memory: 6g}
+    cf/uaa: {memory: 4g, cpus: 2}
+
+With --build-cache, the given directory is mounted into the compilation
+containers as a persistent build cache, at ` + "`/var/vcap/build-cache`" + `; the
+caches of ccache (which compilers go through when it is installed), of go
+builds, and of bundler and rubygems are kept there from one compilation to the
+next. The cache is shared by all packages, or with --build-cache-per-package,
+each package has its own, in a directory named after it, kept across its
+versions. With --compiler kube, --build-cache is the persistent volume claim
+the cache is on.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
 		flagBuildPackagesRoles := buildPackagesViper.GetString("roles")
 		flagBuildPackagesWithoutDocker := buildPackagesViper.GetBool("without-docker")
 
-		err := fissile.LoadReleases(
+		fissile.SetCompilationStats(buildPackagesViper.GetString("stats-file"))
+		fissile.SetKeepFailedContainers(buildPackagesViper.GetBool("keep-failed-containers"))
+
+		limits, err := buildPackagesLimits()
+		if err != nil {
+			return err
+		}
+		fissile.SetCompileLimits(limits)
+
+		if location := buildPackagesViper.GetString("build-cache"); location != "" {
+			fissile.SetBuildCache(&compilator.BuildCache{
+				Location:   location,
+				PerPackage: buildPackagesViper.GetBool("build-cache-per-package"),
+			})
+		}
+
+		switch compiler := buildPackagesViper.GetString("compiler"); compiler {
+		case "", "docker":
+		case "mountns":
+			flagBuildPackagesWithoutDocker = true
+		case "kube":
+			timeout, err := parseDurationWithDays(buildPackagesViper.GetString("kube-timeout"))
+			if err != nil {
+				return err
+			}
+			fissile.SetKubeCompilation(&compilator.KubeOptions{
+				Context:   buildPackagesViper.GetString("kube-context"),
+				Namespace: buildPackagesViper.GetString("kube-namespace"),
+				Image:     buildPackagesViper.GetString("kube-image"),
+				Volume:    buildPackagesViper.GetString("kube-volume"),
+				Timeout:   timeout,
+			})
+		default:
+			return fmt.Errorf("Unknown compiler %s, expected one of docker, mountns, kube", compiler)
+		}
+
+		if location := buildPackagesViper.GetString("package-cache"); location != "" {
+			cache, err := compilator.NewPackageCache(
+				location,
+				splitNonEmpty(buildPackagesViper.GetString("package-cache-header"), ","),
+			)
+			if err != nil {
+				return err
+			}
+			fissile.SetPackageCache(cache, buildPackagesViper.GetBool("package-cache-read-only"))
+		}
+
+		err = fissile.LoadReleases(
 			flagRelease,
 			flagReleaseName,
 			flagReleaseVersion,
@@ -41,18 +188,56 @@ compiled once.
 			return err
 		}
 
-		return fissile.Compile(
-			flagRepository,
-			workPathCompilationDir,
-			flagRoleManifest,
-			flagMetrics,
-			strings.FieldsFunc(flagBuildPackagesRoles, func(r rune) bool { return r == ',' }),
-			flagWorkers,
-			flagBuildPackagesWithoutDocker,
-		)
+		roleNames := strings.FieldsFunc(flagBuildPackagesRoles, func(r rune) bool { return r == ',' })
+		if buildPackagesViper.GetBool("list-selection") {
+			return fissile.ListRoleSelection(flagRoleManifest, roleNames, flagOutputFormat)
+		}
+
+		return forEachPlatform(func(platform docker.Platform) error {
+			return fissile.Compile(
+				platform.Repository(flagRepository),
+				platform.Dir(workPathCompilationDir),
+				flagRoleManifest,
+				flagMetrics,
+				roleNames,
+				flagWorkers,
+				flagBuildPackagesWithoutDocker,
+				buildPackagesViper.GetBool("force"),
+			)
+		})
 	},
 }
 
+// buildPackagesLimits returns the resource limits of compilations given by
+// --compile-limits, --memory and --cpus; nil if there are none
+func buildPackagesLimits() (*compilator.CompileLimits, error) {
+	limits := &compilator.CompileLimits{}
+	if path := buildPackagesViper.GetString("compile-limits"); path != "" {
+		var err error
+		if limits, err = compilator.LoadCompileLimits(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if memory := buildPackagesViper.GetString("memory"); memory != "" {
+		bytes, err := compilator.ParseMemoryLimit(memory)
+		if err != nil {
+			return nil, err
+		}
+		limits.Memory = bytes
+	}
+	if cpus := buildPackagesViper.GetFloat64("cpus"); cpus < 0 {
+		return nil, fmt.Errorf("Invalid number of CPUs %v", cpus)
+	} else if cpus != 0 {
+		limits.CPUs = cpus
+	}
+
+	if limits.ResourceLimits == (compilator.ResourceLimits{}) && len(limits.Packages) == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
 var buildPackagesViper = viper.New()
 
 func init() {
@@ -65,9 +250,11 @@ func init() {
 		"roles",
 		"",
 		"",
-		"Build only packages for the given role names; comma separated.",
+		"Build only packages for the selected roles"+rolesFlagUsage,
 	)
 
+	addListSelectionFlag(buildPackagesCmd)
+
 	buildPackagesCmd.PersistentFlags().BoolP(
 		"without-docker",
 		"",
@@ -75,5 +262,124 @@ func init() {
 		"Build without docker; this may adversely affect your system.  Only supported on Linux, and requires CAP_SYS_ADMIN.",
 	)
 
+	buildPackagesCmd.PersistentFlags().StringP(
+		"compiler",
+		"",
+		"docker",
+		"Where packages are compiled: docker, mountns or kube.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"kube-context",
+		"",
+		"",
+		"Kubeconfig context packages are compiled in, with --compiler kube; the current one by default.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"kube-namespace",
+		"",
+		"",
+		"Namespace packages are compiled in, with --compiler kube; that of the context by default.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"kube-image",
+		"",
+		"",
+		"Image of the compilation pods, with --compiler kube; the compilation base image by default.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"kube-volume",
+		"",
+		"",
+		"Persistent volume claim the compilation pods work in, with --compiler kube.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"kube-timeout",
+		"",
+		"",
+		"How long a compilation job may take (e.g. 2h), with --compiler kube; no limit if empty.",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"keep-failed-containers",
+		"",
+		false,
+		"If specified, the containers of failed compilations are kept for debugging.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"memory",
+		"",
+		"",
+		"Memory each compilation may use (e.g. 4g); no limit if empty.",
+	)
+
+	buildPackagesCmd.PersistentFlags().Float64P(
+		"cpus",
+		"",
+		0,
+		"Number of CPUs each compilation may use (e.g. 1.5); no limit if 0.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"compile-limits",
+		"",
+		"",
+		"Path of a YAML file with the resource limits of compilations, per package.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"build-cache",
+		"",
+		"",
+		"Directory of a build cache (ccache, go, rubygems) mounted into the compilation containers.",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"build-cache-per-package",
+		"",
+		false,
+		"If specified, each package has a build cache of its own, instead of all sharing one.",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"force",
+		"F",
+		false,
+		"If specified, all packages are compiled, even those compiled already.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"package-cache",
+		"",
+		"",
+		"Directory or URL of a cache compiled packages are shared through.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"package-cache-header",
+		"",
+		"",
+		"Headers sent to an http(s) package cache, as Name: value; comma separated.",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"package-cache-read-only",
+		"",
+		false,
+		"If specified, compiled packages are not stored in the package cache.",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"stats-file",
+		"",
+		"",
+		"Path of a JSON file the statistics of the compilation are written to.",
+	)
+
 	buildPackagesViper.BindPFlags(buildPackagesCmd.PersistentFlags())
 }
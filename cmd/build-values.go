@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"github.com/hpcloud/fissile/secrets"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// buildValuesCmd represents the values command
+var buildValuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "Generates values for configuration variables.",
+	Long: `
+Generates the values of all configuration variables of the role manifest that
+have a generator, and writes them into --values-file. That file can be passed
+to "build kube" using --defaults-file.
+
+Variables sharing a generator ` + "`id`" + ` are generated together. Generators of type
+` + "`CACertificate`" + ` create a CA, those of type ` + "`Certificate`" + ` a certificate signed
+by the CA named in ` + "`ca`" + ` (or the only CA there is); the ` + "`value_type`" + ` of each
+variable selects the ` + "`certificate`" + ` or the ` + "`private_key`" + `. A certificate is
+valid for the service names of the role given in ` + "`role_name`" + `, qualified with
+--namespace if set, and for its ` + "`subject_names`" + `, which may reference other
+variables as ` + "`((VARIABLE))`" + `.
+
+Generators of type ` + "`Password`" + ` create a random password of ` + "`length`" + ` (32 by
+default) characters out of ` + "`charset`" + ` (letters and digits by default, and never
+quotes or backslashes, which env files can't hold); those of
+type ` + "`SSH`" + ` create an RSA key pair, with the ` + "`value_type`" + ` selecting the
+` + "`private_key`" + `, the ` + "`public_key`" + `, or its MD5 ` + "`fingerprint`" + `.
+
+Values are generated by the backend named in the ` + "`backend`" + ` of a generator, or
+else by --backend:
+
+  builtin  generates them in fissile itself
+  command  runs --generator-command, passing the generator, its variables, and
+           (for certificates) the subject names and CA as JSON on stdin; it has
+           to print a JSON object mapping variable names to values
+  vault    reads them from Vault at ` + "`<vault-path>/<id>`" + `, by variable name or value type
+
+Values found in the defaults files, or in --values-file itself, are kept, so
+the command can be run repeatedly; certificates are regenerated along with
+their CA. Generated variables are secrets, so "build kube" stores them in a
+Secret, or in the Vault import file, depending on its --provider.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		valuesFile, err := absolutePath(buildValuesViper.GetString("values-file"))
+		if err != nil {
+			return err
+		}
+
+		settings := &secrets.Settings{
+			Namespace:      buildValuesViper.GetString("namespace"),
+			DefaultBackend: buildValuesViper.GetString("backend"),
+			Backends:       map[string]secrets.Backend{},
+		}
+		if command := buildValuesViper.GetString("generator-command"); command != "" {
+			settings.Backends[secrets.CommandBackendName] = secrets.NewCommandBackend(command)
+		}
+		if address := buildValuesViper.GetString("vault-address"); address != "" {
+			settings.Backends[secrets.VaultBackendName] = secrets.NewVaultBackend(
+				address,
+				buildValuesViper.GetString("vault-token"),
+				buildValuesViper.GetString("vault-path"),
+			)
+		}
+
+		err = fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.GenerateValues(
+			flagRoleManifest,
+			valuesFile,
+			splitNonEmpty(buildValuesViper.GetString("defaults-file"), ","),
+			settings,
+		)
+	},
+}
+
+var buildValuesViper = viper.New()
+
+func init() {
+	initViper(buildValuesViper)
+
+	buildCmd.AddCommand(buildValuesCmd)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"values-file",
+		"",
+		"generated-values.env",
+		"Env file the generated values are written to",
+	)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"defaults-file",
+		"D",
+		"",
+		"Env files that contain existing values; comma separated",
+	)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"namespace",
+		"",
+		"",
+		"Kubernetes namespace the roles run in, used in the names of generated certificates",
+	)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"backend",
+		"",
+		secrets.BuiltinBackendName,
+		"Backend generating values for generators that don't name one: builtin, command, or vault",
+	)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"generator-command",
+		"",
+		"",
+		"Command run by the command backend",
+	)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"vault-address",
+		"",
+		"",
+		"Address of the Vault server used by the vault backend",
+	)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"vault-token",
+		"",
+		"",
+		"Token for the Vault server used by the vault backend",
+	)
+
+	buildValuesCmd.PersistentFlags().StringP(
+		"vault-path",
+		"",
+		"secret/fissile/generated",
+		"Vault KV path the vault backend reads values from",
+	)
+
+	buildValuesViper.BindPFlags(buildValuesCmd.PersistentFlags())
+}
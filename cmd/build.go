@@ -1,15 +1,106 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcloud/fissile/app"
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/compilator"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
 // buildCmd represents the build command
 var buildCmd = &cobra.Command{
 	Use:   "build",
 	Short: "Has subcommands to build all images and necessary artifacts.",
+	Long: `
+Has subcommands to build all images and necessary artifacts.
+
+After a successful build, its inputs are recorded in a build manifest, by
+default ` + "`<work-dir>/" + app.BuildManifestName + "`" + `: the command line and its directory, the FISSILE_*
+environment variables of the flags, the values of all flags, the SHA1 of the
+role manifest, opinions and defaults files, the fingerprints of all jobs and
+packages of the releases, the IDs of the base images, and the fissile version.
+Use ` + "`fissile rebuild`" + ` to repeat the build.
+
+The values of flags holding credentials (those with token, password, secret,
+credential or header in their name) are not recorded, whether they are set on
+the command line or in the environment.
+`,
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		manifestPath := buildViper.GetString("build-manifest")
+		if manifestPath == "" {
+			manifestPath = filepath.Join(flagWorkDir, app.BuildManifestName)
+		}
+		manifestPath, err := absolutePath(manifestPath)
+		if err != nil {
+			return err
+		}
+
+		flags := make(map[string]string)
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+			if flag.Name != "help" {
+				flags[flag.Name] = flag.Value.String()
+			}
+		})
+
+		files := []string{flagRoleManifest, flagLightOpinions, flagDarkOpinions}
+		for _, path := range splitNonEmpty(flags["defaults-file"], ",") {
+			if path, err = absolutePath(path); err != nil {
+				return err
+			}
+			files = append(files, path)
+		}
+
+		baseImages := []string{
+			compilator.GetBaseImageName(flagRepository, version),
+			builder.GetBaseImageName(flagRepository, version),
+		}
+		if from := flags["from"]; from != "" {
+			baseImages = append(baseImages, from)
+		}
+
+		return fissile.WriteBuildManifest(manifestPath, &app.BuildManifestSettings{
+			Args:        os.Args[1:],
+			Environment: fissileEnvironment(flags),
+			Flags:       flags,
+			Files:       files,
+			BaseImages:  baseImages,
+		})
+	},
 }
 
+var buildViper = viper.New()
+
 func init() {
+	initViper(buildViper)
+
 	RootCmd.AddCommand(buildCmd)
+
+	buildCmd.PersistentFlags().StringP(
+		"build-manifest",
+		"",
+		"",
+		"Path to the build manifest recording the inputs of the build; defaults to "+app.BuildManifestName+" in the work directory",
+	)
+
+	buildViper.BindPFlags(buildCmd.PersistentFlags())
+}
+
+// fissileEnvironment returns the FISSILE_* environment variables setting the
+// given flags, by name; other variables are not fissile's to record
+func fissileEnvironment(flags map[string]string) map[string]string {
+	environment := make(map[string]string)
+	for flag := range flags {
+		name := "FISSILE_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+		if value, ok := os.LookupEnv(name); ok {
+			environment[name] = value
+		}
+	}
+	return environment
 }
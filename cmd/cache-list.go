@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// cacheListCmd represents the list command
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the releases and packages fissile keeps, with their size.",
+	Long: `
+Fissile keeps the final releases it downloads and extracts in the --cache-dir,
+and the packages it compiles in ` + "`<work-dir>/compilation`" + `. This command
+lists them, by kind (` + "`downloads`, `releases` and `packages`" + `) and least
+recently used first, with their size and when fissile last used them, followed
+by the total size of each kind, in the format given by --output.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return fissile.ListCache(flagCacheDir, workPathCompilationDir, flagOutputFormat)
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheListCmd)
+}
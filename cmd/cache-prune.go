@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// cachePruneCmd represents the prune command
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes the releases and packages fissile hasn't used for a while.",
+	Long: `
+Removes the downloaded and extracted final releases of the --cache-dir, and
+the packages compiled in ` + "`<work-dir>/compilation`" + `, which fissile hasn't
+used for longer than --older-than (e.g. ` + "`30d`, `12h`" + `), or all of them if
+it is empty. The entries removed are listed as ` + "`fissile cache list`" + ` does.
+
+Fissile commands hold a shared lock on the cache directory while they run;
+pruning fails rather than remove what they use, and they wait for pruning to
+finish. Processes using another cache directory but the same work directory
+aren't seen.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		olderThan, err := parseDurationWithDays(cachePruneViper.GetString("older-than"))
+		if err != nil {
+			return err
+		}
+
+		return fissile.PruneCache(
+			flagCacheDir,
+			workPathCompilationDir,
+			olderThan,
+			cachePruneViper.GetBool("dry-run"),
+			flagOutputFormat,
+		)
+	},
+}
+
+var cachePruneViper = viper.New()
+
+func init() {
+	initViper(cachePruneViper)
+
+	cacheCmd.AddCommand(cachePruneCmd)
+
+	cachePruneCmd.PersistentFlags().StringP(
+		"older-than",
+		"",
+		"",
+		"Only remove what wasn't used for longer than this (e.g. 30d, 12h); all if empty",
+	)
+
+	cachePruneCmd.PersistentFlags().BoolP(
+		"dry-run",
+		"",
+		false,
+		"List what would be removed, without removing it",
+	)
+
+	cachePruneViper.BindPFlags(cachePruneCmd.PersistentFlags())
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Has subcommands that list and prune the releases and packages fissile keeps.",
+}
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// completionCmd represents the completion command
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh",
+	Short: "Generates a shell completion script.",
+	Long: `
+Writes a script providing tab completion of the commands and flags of fissile
+to stdout, for bash or zsh. The names of roles are completed as well, for the
+arguments of the commands taking roles and for --roles, read from the role
+manifest given by --role-manifest or --work-dir on the command line, or by
+the environment.
+
+To load the completion in the current shell:
+
+	source <(fissile completion bash)
+
+For zsh, the script can also be saved as ` + "`_fissile`" + ` in a directory of the fpath.
+`,
+	ValidArgs: []string{"bash", "zsh"},
+	// The releases are not needed
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateBasicFlags()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("Expected the shell to complete for, bash or zsh")
+		}
+
+		switch args[0] {
+		case "bash":
+			return writeBashCompletion(fissile.UI)
+		case "zsh":
+			return writeZshCompletion(fissile.UI)
+		}
+		return fmt.Errorf("Invalid shell '%s', expected bash or zsh", args[0])
+	},
+}
+
+// completionRolesCmd lists the roles for the completion scripts
+var completionRolesCmd = &cobra.Command{
+	Use:    "roles",
+	Short:  "Lists the roles of the role manifest, for shell completion.",
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return fissile.ListRoleNames(flagRoleManifest)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(completionCmd)
+	completionCmd.AddCommand(completionRolesCmd)
+}
+
+// roleCompletionFunctions are the bash functions completing role names. The
+// role manifest flags on the command line are passed on to
+// `fissile completion roles`; lists of roles are separated by commas.
+const roleCompletionFunctions = `
+__fissile_roles()
+{
+    local args=() i
+    for (( i = 1; i < ${#words[@]}; i++ )); do
+        case "${words[i]}" in
+            -m|--role-manifest|-w|--work-dir|--config)
+                args+=("${words[i]}" "${words[i+1]}")
+                ;;
+            --role-manifest=*|--work-dir=*|--config=*)
+                args+=("${words[i]}")
+                ;;
+        esac
+    done
+    "${words[0]}" completion roles "${args[@]}" 2>/dev/null | grep -v ' '
+}
+
+__fissile_complete_roles()
+{
+    local prefix="" word="${cur}"
+    if [[ ${cur} == *,* ]]; then
+        prefix="${cur%,*},"
+        word="${cur##*,}"
+    fi
+    COMPREPLY=( $(compgen -P "${prefix}" -W "$(__fissile_roles)" -- "${word}") )
+}
+`
+
+// roleCommands returns the names of the commands taking roles as arguments,
+// as the completion script names them
+func roleCommands(cmd *cobra.Command) []string {
+	var names []string
+	if strings.Contains(cmd.Use, "<role>") {
+		names = append(names, strings.Replace(cmd.CommandPath(), " ", "_", -1))
+	}
+	for _, child := range cmd.Commands() {
+		names = append(names, roleCommands(child)...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// markRolesFlags has the --roles flags of the commands completed with role
+// names
+func markRolesFlags(cmd *cobra.Command) {
+	if cmd.PersistentFlags().Lookup("roles") != nil {
+		cmd.PersistentFlags().SetAnnotation("roles", cobra.BashCompCustom, []string{"__fissile_complete_roles"})
+	}
+	for _, child := range cmd.Commands() {
+		markRolesFlags(child)
+	}
+}
+
+// writeBashCompletion writes the bash completion script of fissile
+func writeBashCompletion(out io.Writer) error {
+	markRolesFlags(RootCmd)
+	RootCmd.BashCompletionFunction = fmt.Sprintf(`%s
+__custom_func()
+{
+    case ${last_command} in
+        %s)
+            __fissile_complete_roles
+            ;;
+    esac
+}`, roleCompletionFunctions, strings.Join(roleCommands(RootCmd), " | "))
+
+	return RootCmd.GenBashCompletion(out)
+}
+
+// zshCompletionHead loads the bash completion script in zsh, emulating sh
+// so that its functions run with arrays indexed from 0 as bash does
+const zshCompletionHead = `#compdef fissile
+
+autoload -U +X compinit && compinit
+autoload -U +X bashcompinit && bashcompinit
+
+__fissile_bash_source()
+{
+    alias shopt=':'
+    emulate -L sh
+    setopt kshglob noshglob braceexpand
+    source "$@"
+}
+
+__fissile_bash_source <(cat <<'FISSILE_BASH_COMPLETION'
+`
+
+// zshCompletionReplacer adapts the bash completion script to zsh, where
+// declare -F defines a float, and type -t and compopt don't exist
+var zshCompletionReplacer = strings.NewReplacer(
+	"declare -F", "whence -w",
+	"$(type -t compopt)", `""`,
+)
+
+// writeZshCompletion writes the zsh completion script of fissile, the bash
+// completion run by the bash completion emulation of zsh
+func writeZshCompletion(out io.Writer) error {
+	var bash bytes.Buffer
+	if err := writeBashCompletion(&bash); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(out, zshCompletionHead); err != nil {
+		return err
+	}
+	if _, err := zshCompletionReplacer.WriteString(out, bash.String()); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "FISSILE_BASH_COMPLETION\n)\n")
+	return err
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// projectConfigNames are the names of the project file, looked for in the
+// current directory and its parents
+var projectConfigNames = []string{".fissile.yml", ".fissile.yaml"}
+
+// projectConfigPathKeys are the flags taking paths; relative paths in the
+// project file are relative to the directory holding it, rather than to the
+// directory fissile runs in
+var projectConfigPathKeys = map[string]bool{
+	"build-manifest":       true,
+	"cache-dir":            true,
+	"compose-file":         true,
+	"dark-opinions":        true,
+	"defaults-file":        true,
+	"environments-dir":     true,
+	"kube-output-dir":      true,
+	"light-opinions":       true,
+	"man-output-dir":       true,
+	"md-output-dir":        true,
+	"metrics":              true,
+	"output-directory":     true,
+	"output-file":          true,
+	"release":              true,
+	"release-download-dir": true,
+	"releases-lock":        true,
+	"report-directory":     true,
+	"role-manifest":        true,
+	"sbom-directory":       true,
+	"stats-file":           true,
+	"values-file":          true,
+	"work-dir":             true,
+}
+
+var (
+	// projectConfigFile is the project file read, if any
+	projectConfigFile string
+	// projectConfigErr is the error reading the project file, reported once
+	// the command runs
+	projectConfigErr error
+)
+
+// findProjectConfig returns the path of the project file in the given
+// directory or the closest of its parents, or an empty string if none has one
+func findProjectConfig(dir string) string {
+	for {
+		for _, name := range projectConfigNames {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readProjectConfig reads a project file, returning its settings as flag
+// values: keys are flag names, lists are joined with commas, as the flags
+// taking several values expect, and relative paths are made absolute
+func readProjectConfig(path string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(contents, &settings); err != nil {
+		return nil, fmt.Errorf("Error reading project file %s: %s", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	config := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		// Keys may be written as the environment variables are
+		key = strings.Replace(strings.ToLower(key), "_", "-", -1)
+
+		if list, ok := value.([]interface{}); ok {
+			values := make([]string, len(list))
+			for i, item := range list {
+				values[i] = fmt.Sprintf("%v", item)
+			}
+			value = strings.Join(values, ",")
+		}
+
+		if projectConfigPathKeys[key] {
+			paths, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("Error reading project file %s: %s is not a path", path, key)
+			}
+			value = projectConfigPaths(dir, paths)
+		}
+
+		config[key] = value
+	}
+	return config, nil
+}
+
+// projectConfigPaths makes the comma separated relative paths of the project
+// file in the given directory absolute, leaving remote releases alone; the
+// files of release lists are made absolute as well
+func projectConfigPaths(dir, paths string) string {
+	values := strings.Split(paths, ",")
+	for i, value := range values {
+		prefix := ""
+		if strings.HasPrefix(value, model.ReleaseListPrefix) {
+			prefix, value = model.ReleaseListPrefix, strings.TrimPrefix(value, model.ReleaseListPrefix)
+		}
+		if value == "" || filepath.IsAbs(value) || model.IsRemoteRelease(value) {
+			continue
+		}
+		values[i] = prefix + filepath.Join(dir, value)
+	}
+	return strings.Join(values, ",")
+}
+
+// mergeProjectConfig merges the project file found from the current
+// directory into the configuration, over the settings of the config file
+func mergeProjectConfig(v *viper.Viper) {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	path := findProjectConfig(workDir)
+	if path == "" {
+		return
+	}
+
+	config, err := readProjectConfig(path)
+	if err == nil {
+		var contents []byte
+		if contents, err = yaml.Marshal(config); err == nil {
+			v.SetConfigType("yaml")
+			err = v.MergeConfig(bytes.NewReader(contents))
+		}
+	}
+	if err != nil {
+		projectConfigErr = err
+		return
+	}
+	projectConfigFile = path
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// configurationPropertySourcesCmd represents the property-sources command
+var configurationPropertySourcesCmd = &cobra.Command{
+	Use:   "property-sources [<property>...]",
+	Short: "Displays where the values of job properties come from.",
+	Long: `
+Displays, for the given job properties or all the properties of the jobs of the
+roles, where their values come from:
+
+- the jobs declaring them, with their spec defaults, and the roles using them;
+- the light opinions on them, or on the keys of the hash they hold;
+- the dark opinions on them, without their values;
+- the templates of the role manifest for them, or for the hash holding them,
+  with the variables they use, and the roles they apply to.
+
+Finally, the source of the value in each role is given, by precedence: a
+template, else a light opinion unless a dark opinion drops it, else the spec
+default. Global templates are named by their property, templates of a role
+replacing or adding to them as ` + "`<role>/<property>`" + `. The properties may
+be given with or without the "properties." prefix.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowPropertySources(
+			flagRoleManifest,
+			flagLightOpinions,
+			flagDarkOpinions,
+			args,
+			flagOutputFormat,
+		)
+	},
+}
+
+func init() {
+	configurationCmd.AddCommand(configurationPropertySourcesCmd)
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// configurationCmd represents the configuration command
+var configurationCmd = &cobra.Command{
+	Use:   "configuration",
+	Short: "Has subcommands that explain the configuration of the roles.",
+}
+
+func init() {
+	RootCmd.AddCommand(configurationCmd)
+}
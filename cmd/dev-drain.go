@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// devDrainCmd represents the drain command
+var devDrainCmd = &cobra.Command{
+	Use:   "drain <role>",
+	Short: "Drains a role running in a local container.",
+	Long: `
+Runs the drain scripts of a role, as listed in its ` + "`run.drain-script`" + `, inside a
+running local docker container of the role; --container names the container,
+which defaults to the name of the role.
+
+Job drain scripts are run the way BOSH runs them when a job shuts down, and
+custom scripts are simply run, in order. This is the same command the
+Kubernetes configuration runs as the preStop hook of the role.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("Expected the name of a role")
+		}
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.DrainRole(
+			flagRoleManifest,
+			args[0],
+			devDrainViper.GetString("container"),
+		)
+	},
+}
+
+var devDrainViper = viper.New()
+
+func init() {
+	initViper(devDrainViper)
+
+	devCmd.AddCommand(devDrainCmd)
+
+	devDrainCmd.PersistentFlags().StringP(
+		"container",
+		"",
+		"",
+		"Name or ID of the container to drain; defaults to the name of the role",
+	)
+
+	devDrainViper.BindPFlags(devDrainCmd.PersistentFlags())
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// devShellCmd represents the shell command
+var devShellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Starts an interactive shell in a compilation or role image.",
+	Long: `
+Starts an interactive shell in a new docker container, which is removed when the
+shell exits. Exactly one of --package and --role selects the container.
+
+With --package, the container runs the compilation image, as built by
+` + "`fissile build layer compilation`" + `, with the sources of the package, its compiled
+dependencies and the compilation script mounted the way they are when
+` + "`fissile build packages`" + ` compiles it; the command compiling the package is
+printed. Packages of the same name in several releases are selected as
+` + "`<release>/<package>`" + `.
+
+With --role, the container runs the image of the role, as built by
+` + "`fissile build images`" + `, with a shell instead of its entrypoint, and the values of
+its configuration variables from the role manifest and the --defaults-file env
+files in its environment, like ` + "`fissile build compose`" + ` sets them. Its
+volumes are empty.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		packageName := devShellViper.GetString("package")
+		roleName := devShellViper.GetString("role")
+		if (packageName == "") == (roleName == "") {
+			return fmt.Errorf("Expected exactly one of --package and --role")
+		}
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		if packageName != "" {
+			return fissile.PackageShell(flagRepository, workPathCompilationDir, packageName)
+		}
+
+		return fissile.RoleShell(
+			flagRoleManifest,
+			flagRepository,
+			roleName,
+			splitNonEmpty(devShellViper.GetString("defaults-file"), ","),
+		)
+	},
+}
+
+var devShellViper = viper.New()
+
+func init() {
+	initViper(devShellViper)
+
+	devCmd.AddCommand(devShellCmd)
+
+	devShellCmd.PersistentFlags().StringP(
+		"package",
+		"",
+		"",
+		"Package whose compilation environment the shell starts in",
+	)
+
+	devShellCmd.PersistentFlags().StringP(
+		"role",
+		"",
+		"",
+		"Role whose image the shell starts in",
+	)
+
+	devShellCmd.PersistentFlags().StringP(
+		"defaults-file",
+		"D",
+		"",
+		"Env files that contain values of the configuration variables, with --role; comma separated",
+	)
+
+	devShellViper.BindPFlags(devShellCmd.PersistentFlags())
+}
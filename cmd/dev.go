@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// devCmd represents the dev command
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Has subcommands that help developing roles locally.",
+}
+
+func init() {
+	RootCmd.AddCommand(devCmd)
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -12,7 +14,8 @@ var docsAutocompleteCmd = &cobra.Command{
 	Use:   "autocomplete",
 	Short: "Generates a bash auto-complete script.",
 	Long: `
-You can source the script to provide tab completion in bash.
+You can source the script to provide tab completion in bash. It is the script
+written by ` + "`fissile completion bash`" + `.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var err error
@@ -25,7 +28,13 @@ You can source the script to provide tab completion in bash.
 			return err
 		}
 
-		return RootCmd.GenBashCompletionFile(flagDocsAutocompleteOutputFile)
+		outFile, err := os.Create(flagDocsAutocompleteOutputFile)
+		if err != nil {
+			return err
+		}
+		defer outFile.Close()
+
+		return writeBashCompletion(outFile)
 	},
 }
 
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// imagesAnalyzeCmd represents the analyze command
+var imagesAnalyzeCmd = &cobra.Command{
+	Use:   "analyze [<role>...]",
+	Short: "Reports how the compiled packages are shared by the role images.",
+	Long: `
+Lists the compiled packages used by the roles of the role manifest, split into
+those shared by several roles and those used by a single one, with their size
+and the roles using them. The packages layers ` + "`fissile build images`" + ` builds, one
+per set of packages used by roles, are listed as well.
+
+To guide how jobs are grouped into roles, the registry size of the packages is
+estimated for three layouts: a copy of the packages in each role image, a
+packages layer per set of packages, and each package stored once. Reuse of
+existing packages layers by ` + "`fissile build images`" + ` is not accounted for.
+
+The packages have to be compiled first, with ` + "`fissile build packages`" + `.
+
+With --built, the images of the given roles, or of all roles, as built by
+` + "`fissile build images`" + `, are inspected instead. For each image, the size of
+each layer and the command which created it are listed, along with the files
+stored by a layer but hidden by a later one, which replaces or removes them,
+and the largest packages and jobs in the image. --top bounds the number of
+files, packages and jobs listed. Images built with ` + "`fissile build images --squash`" + `
+have a single layer above their packages layer image.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		if imagesAnalyzeViper.GetBool("built") {
+			return fissile.AnalyzeBuiltRoleImages(flagRoleManifest, flagRepository, args, imagesAnalyzeViper.GetInt("top"), flagOutputFormat)
+		}
+		return fissile.AnalyzeRoleImages(flagRoleManifest, workPathCompilationDir, flagOutputFormat)
+	},
+}
+
+var imagesAnalyzeViper = viper.New()
+
+func init() {
+	initViper(imagesAnalyzeViper)
+
+	imagesCmd.AddCommand(imagesAnalyzeCmd)
+
+	imagesAnalyzeCmd.PersistentFlags().BoolP(
+		"built",
+		"",
+		false,
+		"If specified, the built role images are inspected, layer by layer.",
+	)
+
+	imagesAnalyzeCmd.PersistentFlags().IntP(
+		"top",
+		"",
+		10,
+		"Number of hidden files, packages and jobs listed for each built image.",
+	)
+
+	imagesAnalyzeViper.BindPFlags(imagesAnalyzeCmd.PersistentFlags())
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"github.com/hpcloud/fissile/docker"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// imagesMirrorCmd represents the mirror command
+var imagesMirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Copies the role images from one docker registry to another.",
+	Long: `
+Copies the images of the roles, as pushed by ` + "`fissile images push`" + `, from the
+registry given by --from to the one given by --to, e.g. to promote a build
+from a staging registry to a production one. Both are registry environments
+of the role manifest, or registry prefixes: a registry, optionally followed by
+an organization, as in ` + "`registry.example.com/org`" + `.
+
+The images are copied through the registry API, without the docker daemon.
+They are copied by digest: the copies have the same digests as the originals,
+and manifest lists are copied along with the images of all their platforms.
+Layers and images the target registry has already are skipped, so an
+interrupted mirror resumes where it stopped when run again. Failed copies are
+attempted again up to --retries times, waiting longer after each attempt.
+Copies are limited like all image transfers, see --transfer-workers,
+--registry-transfer-workers and --transfer-bandwidth.
+
+The registry credentials are those of the docker client configuration
+(` + "`docker login`" + `), unless --from-username or --to-username are given. The
+passwords are best set through FISSILE_FROM_PASSWORD and FISSILE_TO_PASSWORD,
+so they are not recorded in the shell history.
+
+Once all images are copied, their digests are listed, in the format given by
+--output.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		roleNames := splitNonEmpty(imagesMirrorViper.GetString("roles"), ",")
+		if imagesMirrorViper.GetBool("list-selection") {
+			return fissile.ListRoleSelection(flagRoleManifest, roleNames, flagOutputFormat)
+		}
+
+		return fissile.MirrorRoleImages(
+			flagRoleManifest,
+			flagRepository,
+			imagesMirrorViper.GetString("from"),
+			imagesMirrorViper.GetString("to"),
+			roleNames,
+			mirrorAuth(imagesMirrorViper, "from"),
+			mirrorAuth(imagesMirrorViper, "to"),
+			imagesMirrorViper.GetInt("retries"),
+			flagOutputFormat,
+		)
+	},
+}
+
+var imagesMirrorViper = viper.New()
+
+func init() {
+	initViper(imagesMirrorViper)
+
+	imagesCmd.AddCommand(imagesMirrorCmd)
+
+	imagesMirrorCmd.PersistentFlags().StringP(
+		"roles",
+		"",
+		"",
+		"Mirror only images of the selected roles"+rolesFlagUsage,
+	)
+
+	addListSelectionFlag(imagesMirrorCmd)
+
+	imagesMirrorCmd.PersistentFlags().StringP(
+		"from",
+		"",
+		"",
+		"Registry environment or registry prefix the images are copied from",
+	)
+
+	imagesMirrorCmd.PersistentFlags().StringP(
+		"to",
+		"",
+		"",
+		"Registry environment or registry prefix the images are copied to",
+	)
+
+	for _, side := range []string{"from", "to"} {
+		imagesMirrorCmd.PersistentFlags().StringP(
+			side+"-username",
+			"",
+			"",
+			"User name for the registry given by --"+side+", instead of the docker client credentials",
+		)
+
+		imagesMirrorCmd.PersistentFlags().StringP(
+			side+"-password",
+			"",
+			"",
+			"Password for the registry given by --"+side+", with --"+side+"-username",
+		)
+	}
+
+	imagesMirrorCmd.PersistentFlags().IntP(
+		"retries",
+		"",
+		3,
+		"Number of times a failed copy is attempted again",
+	)
+
+	imagesMirrorViper.BindPFlags(imagesMirrorCmd.PersistentFlags())
+}
+
+// mirrorAuth returns the credentials given for one side of a mirror, if any
+func mirrorAuth(v *viper.Viper, side string) *docker.RegistryAuth {
+	username := v.GetString(side + "-username")
+	if username == "" {
+		return nil
+	}
+	return &docker.RegistryAuth{
+		Username: username,
+		Password: v.GetString(side + "-password"),
+	}
+}
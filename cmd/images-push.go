@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"github.com/hpcloud/fissile/docker"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// imagesPushCmd represents the push command
+var imagesPushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Tags and pushes the role images to a docker registry.",
+	Long: `
+Tags the images of the roles, as built by ` + "`fissile build images`" + `, as
+` + "`<docker-registry>/<docker-organization>/<image>`" + ` and pushes them. The names
+are those ` + "`fissile build kube`" + ` and ` + "`fissile build compose`" + ` reference given
+the same flags; a registry environment (--registry-env) replaces both
+flags.
+
+The registry credentials are those of the docker client configuration
+(` + "`docker login`" + `), unless --docker-username is given. The password is best
+set through FISSILE_DOCKER_PASSWORD, so it is not recorded in the shell
+history. Failed pushes are attempted again up to --retries times, waiting
+longer after each attempt. Pushes are limited like all image transfers, see
+--transfer-workers.
+
+With several platforms given by --platform, the image of each platform, as
+built by ` + "`fissile build images`" + `, is pushed with the platform appended to its
+tag, as ` + "`<image>:<tag>-linux-arm64`" + `. A manifest list referring to them
+is then pushed as the image name, so that each node pulls the image of its own
+platform. The manifest list is pushed through the registry API, with the same
+credentials.
+
+Once all images are pushed, their digests are listed, in the format given by
+--output.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		roleNames := splitNonEmpty(imagesPushViper.GetString("roles"), ",")
+		if imagesPushViper.GetBool("list-selection") {
+			return fissile.ListRoleSelection(flagRoleManifest, roleNames, flagOutputFormat)
+		}
+
+		return pushRoleImages(imagesPushViper, roleNames)
+	},
+}
+
+var imagesPushViper = viper.New()
+
+func init() {
+	initViper(imagesPushViper)
+
+	imagesCmd.AddCommand(imagesPushCmd)
+
+	imagesPushCmd.PersistentFlags().StringP(
+		"roles",
+		"",
+		"",
+		"Push only images of the selected roles"+rolesFlagUsage,
+	)
+
+	addListSelectionFlag(imagesPushCmd)
+
+	addPushFlags(imagesPushCmd)
+
+	imagesPushViper.BindPFlags(imagesPushCmd.PersistentFlags())
+}
+
+// addPushFlags adds the flags selecting where role images are pushed to
+func addPushFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP(
+		"docker-registry",
+		"",
+		"",
+		"Docker registry the images are pushed to",
+	)
+
+	cmd.PersistentFlags().StringP(
+		"docker-organization",
+		"",
+		"",
+		"Docker organization the images are pushed to",
+	)
+
+	cmd.PersistentFlags().StringP(
+		"docker-username",
+		"",
+		"",
+		"User name for the docker registry, instead of the docker client credentials",
+	)
+
+	cmd.PersistentFlags().StringP(
+		"docker-password",
+		"",
+		"",
+		"Password for the docker registry, with --docker-username",
+	)
+
+	cmd.PersistentFlags().IntP(
+		"retries",
+		"",
+		3,
+		"Number of times a failed push is attempted again",
+	)
+}
+
+// pushRoleImages pushes the images of the roles as set up by the flags of
+// addPushFlags
+func pushRoleImages(v *viper.Viper, roleNames []string) error {
+	var auth *docker.RegistryAuth
+	if username := v.GetString("docker-username"); username != "" {
+		auth = &docker.RegistryAuth{
+			Username: username,
+			Password: v.GetString("docker-password"),
+		}
+	}
+
+	return fissile.PushRoleImages(
+		flagRoleManifest,
+		flagRepository,
+		v.GetString("docker-registry"),
+		v.GetString("docker-organization"),
+		flagPlatforms,
+		roleNames,
+		auth,
+		v.GetInt("retries"),
+		flagOutputFormat,
+	)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/hpcloud/fissile/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// imagesSaveCmd represents the save command
+var imagesSaveCmd = &cobra.Command{
+	Use:   "save",
+	Short: "Exports the role images to a directory, without a registry.",
+	Long: `
+Exports the images of the roles, as built by ` + "`fissile build images`" + `, to the
+--target directory, so they can be carried to a site without access to a
+registry and imported there.
+
+With ` + "`--format docker`" + `, each image is written as a ` + "`docker save`" + ` tarball named
+after its role, to be imported with ` + "`docker load`" + `. With ` + "`--format oci`" + `, the
+images are written to a single OCI image layout, in which layers shared by
+several images are stored once; each image is named by its image name.
+
+The saved images, along with their tarball and digest, are listed in
+` + "`" + app.SavedImagesName + "`" + ` in the target directory, and printed in the format given
+by --output.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		roleNames := splitNonEmpty(imagesSaveViper.GetString("roles"), ",")
+		if imagesSaveViper.GetBool("list-selection") {
+			return fissile.ListRoleSelection(flagRoleManifest, roleNames, flagOutputFormat)
+		}
+
+		target := imagesSaveViper.GetString("target")
+		if target == "" {
+			return fmt.Errorf("No target directory given, see --target")
+		}
+		target, err = absolutePath(target)
+		if err != nil {
+			return err
+		}
+
+		return fissile.SaveRoleImages(
+			flagRoleManifest,
+			flagRepository,
+			target,
+			imagesSaveViper.GetString("format"),
+			roleNames,
+			flagOutputFormat,
+		)
+	},
+}
+
+var imagesSaveViper = viper.New()
+
+func init() {
+	initViper(imagesSaveViper)
+
+	imagesCmd.AddCommand(imagesSaveCmd)
+
+	imagesSaveCmd.PersistentFlags().StringP(
+		"target",
+		"",
+		"",
+		"Directory the images are saved to",
+	)
+
+	imagesSaveCmd.PersistentFlags().StringP(
+		"format",
+		"",
+		app.SaveFormatDocker,
+		"Format of the saved images, docker (a tarball per image) or oci (an OCI image layout)",
+	)
+
+	imagesSaveCmd.PersistentFlags().StringP(
+		"roles",
+		"",
+		"",
+		"Save only images of the selected roles"+rolesFlagUsage,
+	)
+
+	addListSelectionFlag(imagesSaveCmd)
+
+	imagesSaveViper.BindPFlags(imagesSaveCmd.PersistentFlags())
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/hpcloud/fissile/docker"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// imagesScanCmd represents the scan command
+var imagesScanCmd = &cobra.Command{
+	Use:   "scan [<role>...]",
+	Short: "Scans the role images for vulnerabilities.",
+	Long: `
+Runs a vulnerability scanner on the images of the given roles, or of all roles,
+as built by ` + "`fissile build images`" + `, and on the base images they are built on.
+The scanner is chosen by --scanner; --scanner-command sets the path of its
+executable. Only ` + "`trivy`" + ` is supported for now.
+
+A JSON report is written for each role to ` + "`<role>.json`" + ` in the
+--report-directory, listing the vulnerabilities of its image, the most severe
+first, with the package they are in, its installed version and the version
+fixing them. Vulnerabilities the base image has too are marked as such.
+
+The number of vulnerabilities of each image is listed by severity, in the
+format given by --output. The command fails if any image has vulnerabilities
+of the --severity-threshold or above: one of UNKNOWN, LOW, MEDIUM, HIGH or
+CRITICAL.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		scanner, err := docker.NewImageScanner(
+			imagesScanViper.GetString("scanner"),
+			imagesScanViper.GetString("scanner-command"),
+		)
+		if err != nil {
+			return err
+		}
+
+		reportDirectory := imagesScanViper.GetString("report-directory")
+		if reportDirectory == "" {
+			reportDirectory = workPathScanDir
+		}
+
+		err = fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ScanRoleImages(
+			flagRoleManifest,
+			flagRepository,
+			args,
+			scanner,
+			imagesScanViper.GetString("severity-threshold"),
+			reportDirectory,
+			flagOutputFormat,
+		)
+	},
+}
+
+var imagesScanViper = viper.New()
+
+func init() {
+	initViper(imagesScanViper)
+
+	imagesCmd.AddCommand(imagesScanCmd)
+
+	imagesScanCmd.PersistentFlags().StringP(
+		"scanner",
+		"",
+		"trivy",
+		"Vulnerability scanner to run: "+strings.Join(docker.ImageScannerNames(), ", "),
+	)
+
+	imagesScanCmd.PersistentFlags().StringP(
+		"scanner-command",
+		"",
+		"",
+		"Path of the executable of the --scanner, if not the usual one",
+	)
+
+	imagesScanCmd.PersistentFlags().StringP(
+		"severity-threshold",
+		"",
+		"HIGH",
+		"Fail if an image has vulnerabilities of this severity or above",
+	)
+
+	imagesScanCmd.PersistentFlags().StringP(
+		"report-directory",
+		"",
+		"",
+		"Directory the reports are written to; defaults to <work-dir>/scan",
+	)
+
+	imagesScanViper.BindPFlags(imagesScanCmd.PersistentFlags())
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// imagesVerifyCmd represents the verify command
+var imagesVerifyCmd = &cobra.Command{
+	Use:   "verify [<role>...]",
+	Short: "Checks the compiled packages of role images against the releases.",
+	Long: `
+Role images record the fingerprint and compilation time of each compiled
+package they include, in the ` + "`packages`" + ` label and in
+` + "`/opt/hcf/packages.json`" + `. This command reads the label of the images of the
+given roles, or of all roles, as built by ` + "`fissile build images`" + `, and compares
+it with the packages the jobs of each role use in the releases.
+
+Packages missing from an image, packages the role no longer uses, and
+packages with another fingerprint are listed, in the format given by
+--output, and the command fails if there are any. Images built before
+packages were recorded fail as well.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.VerifyRoleImages(flagRoleManifest, flagRepository, args, flagOutputFormat)
+	},
+}
+
+func init() {
+	imagesCmd.AddCommand(imagesVerifyCmd)
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// imagesCmd represents the images command
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Has subcommands that distribute and analyze the role images.",
+}
+
+func init() {
+	RootCmd.AddCommand(imagesCmd)
+}
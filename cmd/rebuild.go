@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/hpcloud/fissile/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// rebuildCmd represents the rebuild command
+var rebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Repeats a build recorded in a build manifest.",
+	Long: `
+Repeats the build recorded in the build manifest given by --from, as written by
+the ` + "`build`" + ` commands.
+
+Before anything is built, the recorded releases are loaded, and the input files,
+the fingerprints of the jobs and packages, and the base images are compared
+with the recorded ones; all differences are reported, and stop the rebuild
+unless --force is set. The recorded command is then run with the recorded
+FISSILE_* environment variables. Credentials are not recorded, so they are
+taken from the current FISSILE_* environment variables instead.
+`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Releases come from the build manifest, not from --release
+		return validateBasicFlags()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		manifestPath, err := absolutePath(rebuildViper.GetString("from"))
+		if err != nil {
+			return err
+		}
+
+		manifest, err := app.LoadBuildManifest(manifestPath)
+		if err != nil {
+			return err
+		}
+
+		var releasePaths, releaseNames, releaseVersions []string
+		for _, release := range manifest.Releases {
+			releasePaths = append(releasePaths, release.Path)
+			releaseNames = append(releaseNames, release.Name)
+			releaseVersions = append(releaseVersions, release.Version)
+		}
+
+		if len(releasePaths) > 0 {
+			err = fissile.LoadReleases(
+				releasePaths,
+				releaseNames,
+				releaseVersions,
+				manifest.Flags["cache-dir"],
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := fissile.VerifyBuildManifest(manifest); err != nil {
+			if !rebuildViper.GetBool("force") {
+				return err
+			}
+			fissile.UI.Printf("%s\n", err)
+		}
+
+		return fissile.Rebuild(manifest, os.Args[0])
+	},
+}
+
+var rebuildViper = viper.New()
+
+func init() {
+	initViper(rebuildViper)
+
+	RootCmd.AddCommand(rebuildCmd)
+
+	rebuildCmd.PersistentFlags().StringP(
+		"from",
+		"",
+		app.BuildManifestName,
+		"Path to the build manifest of the build to repeat",
+	)
+
+	rebuildCmd.PersistentFlags().BoolP(
+		"force",
+		"F",
+		false,
+		"If specified, the build is repeated even when its inputs have changed",
+	)
+
+	rebuildViper.BindPFlags(rebuildCmd.PersistentFlags())
+}
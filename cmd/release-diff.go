@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// releaseDiffCmd represents the diff command
+var releaseDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Reports the changes between two versions of a BOSH release.",
+	Long: `
+Compares the release given by --old with the one given by --new, e.g. before
+bumping a release, and lists:
+
+- the jobs and packages added, removed, or changed (with another fingerprint),
+  along with their versions;
+- the properties added to or removed from the jobs found in both versions;
+- the properties whose spec default changed.
+
+Releases are given like --release: paths to dev or final releases, or URLs of
+final release tarballs. The report is printed in the format given by --output.
+Unlike "fissile diff", --release is not used.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldRelease := releaseDiffViper.GetString("old")
+		newRelease := releaseDiffViper.GetString("new")
+		if oldRelease == "" || newRelease == "" {
+			return fmt.Errorf("Both --old and --new releases are required")
+		}
+
+		return fissile.DiffReleases(oldRelease, newRelease, flagCacheDir, flagOutputFormat)
+	},
+}
+
+var releaseDiffViper = viper.New()
+
+func init() {
+	initViper(releaseDiffViper)
+
+	releaseCmd.AddCommand(releaseDiffCmd)
+
+	releaseDiffCmd.PersistentFlags().StringP(
+		"old",
+		"",
+		"",
+		"Path or URL of the old version of the release",
+	)
+
+	releaseDiffCmd.PersistentFlags().StringP(
+		"new",
+		"",
+		"",
+		"Path or URL of the new version of the release",
+	)
+
+	releaseDiffViper.BindPFlags(releaseDiffCmd.PersistentFlags())
+}
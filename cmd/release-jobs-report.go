@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// releaseJobsReportCmd represents the jobs-report command
+var releaseJobsReportCmd = &cobra.Command{
+	Use:   "jobs-report",
+	Short: "Reports the jobs of BOSH releases.",
+	Long: `
+Lists the jobs of the releases given by --release, with their version and
+description, in the format given by --output.
+
+With --properties, each job is detailed: the packages it uses, the names of the
+processes its monit file checks, and the properties its spec declares, along
+with their defaults and descriptions. This saves reading the spec files of the
+jobs when evaluating a release.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.JobsReport(releaseJobsReportViper.GetBool("properties"), flagOutputFormat)
+	},
+}
+
+var releaseJobsReportViper = viper.New()
+
+func init() {
+	initViper(releaseJobsReportViper)
+
+	releaseCmd.AddCommand(releaseJobsReportCmd)
+
+	releaseJobsReportCmd.PersistentFlags().BoolP(
+		"properties",
+		"",
+		false,
+		"Include the packages, monit processes, and properties of each job",
+	)
+
+	releaseJobsReportViper.BindPFlags(releaseJobsReportCmd.PersistentFlags())
+}
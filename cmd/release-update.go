@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// releaseUpdateCmd represents the update command
+var releaseUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Pins the releases in the releases lock.",
+	Long: `
+Records the name, version, and digest of the jobs and packages of each release
+given by --release in the releases lock (--releases-lock, releases.lock in the
+work directory by default), replacing the releases it pinned before.
+
+Once the lock exists, all commands loading releases fail if one of them is not
+pinned, or is another version or has other contents than the pinned one, so a
+half-updated release tree is not built by accident. Run this command after
+updating the releases on purpose, and commit the lock along with the role
+manifest.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return fissile.UpdateReleasesLock(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+	},
+}
+
+func init() {
+	releaseCmd.AddCommand(releaseUpdateCmd)
+}
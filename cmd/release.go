@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// releaseCmd represents the release command
+var releaseCmd = &cobra.Command{
+	Use:     "release",
+	Aliases: []string{"releases"},
+	Short:   "Has subcommands that compare, pin, and report on BOSH releases.",
+}
+
+func init() {
+	RootCmd.AddCommand(releaseCmd)
+}
@@ -2,14 +2,21 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
+	"github.com/shiena/ansicolor"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/hpcloud/fissile/app"
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/logging"
+	"github.com/hpcloud/fissile/model"
 )
 
 var (
@@ -17,24 +24,40 @@ var (
 	fissile *app.Fissile
 	version string
 
-	flagRoleManifest   string
-	flagRelease        []string
-	flagReleaseName    []string
-	flagReleaseVersion []string
-	flagCacheDir       string
-	flagWorkDir        string
-	flagRepository     string
-	flagWorkers        int
-	flagLightOpinions  string
-	flagDarkOpinions   string
-	flagOutputFormat   string
-	flagMetrics        string
+	flagRoleManifest         string
+	flagRelease              []string
+	flagReleaseName          []string
+	flagReleaseVersion       []string
+	flagCacheDir             string
+	flagWorkDir              string
+	flagRepository           string
+	flagWorkers              int
+	flagLightOpinions        string
+	flagDarkOpinions         string
+	flagOutputFormat         string
+	flagMetrics              string
+	flagReleaseDownloadDir   string
+	flagReleasesLock         string
+	flagRegistryEnv          string
+	flagAllowUnknownOpinions bool
+	flagTransferWorkers      int
+	flagRegistryWorkers      []string
+	flagTransferBandwidth    string
+	flagGroups               []string
+	flagPlatforms            []docker.Platform
+	flagStats                string
+	flagLogLevel             string
+	flagLogFormat            string
+	flagVerbose              bool
+	flagQuiet                bool
 
 	// workPath* variables contain paths derived from flagWorkDir
 	workPathCompilationDir string
 	workPathConfigDir      string
 	workPathBaseDockerfile string
 	workPathDockerDir      string
+	workPathSBOMDir        string
+	workPathScanDir        string
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -46,6 +69,21 @@ Fissile converts existing BOSH dev releases into docker images.
 
 It does this using just the releases, without a BOSH deployment, CPIs, or a BOSH 
 agent.
+
+Progress messages are written to stderr, apart from the results of commands,
+which are written to stdout. --log-level and --quiet or --verbose select which
+are written, and --log-format json writes them as a JSON object per line, with
+the level and the subsystem (e.g. compile, docker, config) of each.
+
+Flags can be set in a .fissile.yml project file, found in the current directory
+or the closest of its parents, by flag name (role-manifest: role-manifest.yml),
+with lists for the flags taking several values. Relative paths in it are
+relative to its directory. FISSILE_* environment variables (FISSILE_WORK_DIR)
+take precedence over the project file, and flags over both.
+
+No statistics are collected unless --stats is set. With it, each run sends the
+command, the fissile version, its start time, duration and outcome, the number
+of workers, and the number of releases, jobs and packages loaded; nothing else.
 `,
 	SilenceErrors: true,
 	SilenceUsage:  true,
@@ -66,7 +104,17 @@ func Execute(f *app.Fissile, v string) error {
 	fissile = f
 	version = v
 
-	return RootCmd.Execute()
+	started := time.Now()
+	cmd, err := RootCmd.ExecuteC()
+
+	if flagStats != "" && cmd != nil {
+		stats := fissile.CollectStats(cmd.CommandPath(), started, flagWorkers, err == nil)
+		if statsErr := app.SendStats(stats, flagStats); statsErr != nil {
+			fissile.UI.Printf("Failed to send statistics to %s: %s\n", flagStats, statsErr)
+		}
+	}
+
+	return err
 }
 
 func init() {
@@ -76,7 +124,7 @@ func init() {
 	// Cobra supports Persistent Flags, which, if defined here,
 	// will be global for your application.
 
-	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.fissile.yaml)")
+	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.fissile.yaml, with the .fissile.yml project file of the current directory or a parent over it)")
 
 	RootCmd.PersistentFlags().StringP(
 		"role-manifest",
@@ -90,7 +138,7 @@ func init() {
 		"release",
 		"r",
 		"",
-		"Path to dev BOSH release(s).",
+		"Path to dev BOSH release(s), or to final BOSH release(s) (tarballs, extracted directories, URLs, or bosh.io <org>/<repo>@<version> shorthands). Glob patterns, quoted as in './releases/*', load all the releases they match, and @<file> the releases listed in the file, one per line.",
 	)
 
 	// We can't use slices here because of https://github.com/spf13/viper/issues/112
@@ -116,6 +164,27 @@ func init() {
 		"Local BOSH cache directory.",
 	)
 
+	RootCmd.PersistentFlags().StringP(
+		"release-download-dir",
+		"",
+		"",
+		"Directory remote releases are downloaded into; defaults to a directory inside the cache directory.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"releases-lock",
+		"",
+		"",
+		"Path to the releases lock the releases are checked against, if it exists; defaults to releases.lock in the work directory.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"registry-env",
+		"",
+		"",
+		"Environment from the registries section of the role manifest, whose registry prefix is used for image names.",
+	)
+
 	RootCmd.PersistentFlags().StringP(
 		"work-dir",
 		"w",
@@ -151,6 +220,48 @@ func init() {
 		"Path to a BOSH deployment manifest file that contains properties that should not have opinionated defaults.",
 	)
 
+	RootCmd.PersistentFlags().BoolP(
+		"allow-unknown-opinions",
+		"",
+		false,
+		"Only warn about light and dark opinions on properties which are not defined by any job, instead of failing.",
+	)
+
+	RootCmd.PersistentFlags().IntP(
+		"transfer-workers",
+		"",
+		0,
+		"Number of images pushed or pulled at the same time; unlimited if 0.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"transfer-bandwidth",
+		"",
+		"",
+		"Bandwidth of image pushes and pulls per second, e.g. 10MB; unlimited if empty. As the docker daemon does the transfers, new ones wait while the throughput of the running ones is above it.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"registry-transfer-workers",
+		"",
+		"",
+		"Number of images pushed or pulled at the same time per registry, as registry=count; comma separated.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"platform",
+		"",
+		docker.DefaultPlatform.String(),
+		"Platforms packages are compiled and images are built for, as <os>/<architecture>; comma separated. Building for another architecture than the host's needs QEMU registered with binfmt_misc.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"group",
+		"",
+		"",
+		"Only operate on the roles of the given role groups; comma separated.",
+	)
+
 	RootCmd.PersistentFlags().StringP(
 		"metrics",
 		"M",
@@ -158,17 +269,52 @@ func init() {
 		"Path to a CSV file to store timing metrics into.",
 	)
 
+	RootCmd.PersistentFlags().StringP(
+		"stats",
+		"",
+		"",
+		"Opt-in: after each run, send its statistics (counts and timings only) to this http(s) URL as JSON, or append them to this file. Off if empty.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"log-level",
+		"",
+		"info",
+		"Level of the progress messages written to stderr, one of debug, info, warn, or error.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"log-format",
+		"",
+		"text",
+		"Format of the progress messages, text or json (an object per line, without colors).",
+	)
+
+	RootCmd.PersistentFlags().BoolP(
+		"verbose",
+		"",
+		false,
+		"Write debug progress messages too; same as --log-level debug.",
+	)
+
+	RootCmd.PersistentFlags().BoolP(
+		"quiet",
+		"q",
+		false,
+		"Only write warnings and errors as progress messages; same as --log-level warn.",
+	)
+
 	RootCmd.PersistentFlags().StringP(
 		"output",
 		"o",
 		"human",
-		"Choose output format, one of human, json, or yaml (currently only for 'show properties')",
+		"Choose output format, one of human, json, or yaml (for the reports of the show and images commands)",
 	)
 
 	viper.BindPFlags(RootCmd.PersistentFlags())
 }
 
-// initConfig reads in config file and ENV variables if set.
+// initConfig reads in config file, project file and ENV variables if set.
 func initConfig() {
 	initViper(viper.GetViper())
 }
@@ -190,6 +336,11 @@ func initViper(v *viper.Viper) {
 			fmt.Println("Using config file:", viper.ConfigFileUsed())
 		}
 	}
+
+	// An explicit config file is the whole configuration
+	if cfgFile == "" {
+		mergeProjectConfig(v)
+	}
 }
 
 // extendPathsFromWorkDirectory sets some directory defaults derived from the
@@ -205,6 +356,8 @@ func extendPathsFromWorkDirectory() {
 	workPathConfigDir = filepath.Join(workDir, "config")
 	workPathBaseDockerfile = filepath.Join(workDir, "base_dockerfile")
 	workPathDockerDir = filepath.Join(workDir, "dockerfiles")
+	workPathSBOMDir = filepath.Join(workDir, "sbom")
+	workPathScanDir = filepath.Join(workDir, "scan")
 
 	// Set defaults for empty flags
 	if flagRoleManifest == "" {
@@ -218,11 +371,20 @@ func extendPathsFromWorkDirectory() {
 	if flagDarkOpinions == "" {
 		flagDarkOpinions = filepath.Join(workDir, "dark-opinions.yml")
 	}
+
+	if flagReleasesLock == "" {
+		flagReleasesLock = filepath.Join(workDir, app.ReleasesLockName)
+	}
 }
 
 func validateBasicFlags() error {
 	var err error
 
+	// The project file is not read along an explicit config file
+	if projectConfigErr != nil && cfgFile == "" {
+		return projectConfigErr
+	}
+
 	flagRoleManifest = viper.GetString("role-manifest")
 	flagRelease = splitNonEmpty(viper.GetString("release"), ",")
 	flagReleaseName = splitNonEmpty(viper.GetString("release-name"), ",")
@@ -235,6 +397,29 @@ func validateBasicFlags() error {
 	flagDarkOpinions = viper.GetString("dark-opinions")
 	flagOutputFormat = viper.GetString("output")
 	flagMetrics = viper.GetString("metrics")
+	flagReleaseDownloadDir = viper.GetString("release-download-dir")
+	flagReleasesLock = viper.GetString("releases-lock")
+	flagRegistryEnv = viper.GetString("registry-env")
+	flagAllowUnknownOpinions = viper.GetBool("allow-unknown-opinions")
+	flagTransferWorkers = viper.GetInt("transfer-workers")
+	flagRegistryWorkers = splitNonEmpty(viper.GetString("registry-transfer-workers"), ",")
+	flagTransferBandwidth = viper.GetString("transfer-bandwidth")
+	flagGroups = splitNonEmpty(viper.GetString("group"), ",")
+	flagStats = viper.GetString("stats")
+	flagLogLevel = viper.GetString("log-level")
+	flagLogFormat = viper.GetString("log-format")
+	flagVerbose = viper.GetBool("verbose")
+	flagQuiet = viper.GetBool("quiet")
+
+	logger, err := newLogger()
+	if err != nil {
+		return err
+	}
+	fissile.SetLogger(logger)
+
+	if projectConfigFile != "" {
+		logger.Debugf("Using project file %s", projectConfigFile)
+	}
 
 	extendPathsFromWorkDirectory()
 
@@ -249,17 +434,108 @@ func validateBasicFlags() error {
 		&workPathConfigDir,
 		&workPathBaseDockerfile,
 		&workPathDockerDir,
+		&workPathSBOMDir,
+		&workPathScanDir,
 	); err != nil {
 		return err
 	}
 
-	if flagRelease, err = absolutePathsForArray(flagRelease); err != nil {
+	if flagRelease, err = model.ExpandReleasePaths(flagRelease); err != nil {
+		return err
+	}
+	if flagRelease, err = absoluteReleasePaths(flagRelease); err != nil {
+		return err
+	}
+
+	if flagReleaseDownloadDir != "" {
+		if flagReleaseDownloadDir, err = absolutePath(flagReleaseDownloadDir); err != nil {
+			return err
+		}
+	}
+	fissile.SetReleaseDownloadDir(flagReleaseDownloadDir)
+
+	// The cache isn't pruned while it's used
+	if err = fissile.LockCache(flagCacheDir); err != nil {
+		return err
+	}
+
+	if flagReleasesLock != "" {
+		if flagReleasesLock, err = absolutePath(flagReleasesLock); err != nil {
+			return err
+		}
+	}
+	fissile.SetReleasesLock(flagReleasesLock)
+	fissile.SetRegistryEnvironment(flagRegistryEnv)
+	fissile.SetAllowUnknownOpinions(flagAllowUnknownOpinions)
+	fissile.SetRoleGroups(flagGroups)
+
+	if flagPlatforms, err = docker.ParsePlatforms(viper.GetString("platform")); err != nil {
 		return err
 	}
 
+	registryWorkers, err := docker.ParseRegistryLimits(flagRegistryWorkers)
+	if err != nil {
+		return err
+	}
+	bandwidth, err := docker.ParseBandwidth(flagTransferBandwidth)
+	if err != nil {
+		return err
+	}
+	fissile.SetTransferLimits(&docker.TransferLimits{
+		Concurrency: flagTransferWorkers,
+		Registries:  registryWorkers,
+		Bandwidth:   bandwidth,
+	})
+
+	return nil
+}
+
+// forEachPlatform runs a build step for each of the platforms given by
+// --platform, selecting the platform the packages are compiled for. A single
+// platform is built quietly; otherwise each platform is announced first.
+func forEachPlatform(step func(platform docker.Platform) error) error {
+	for _, platform := range flagPlatforms {
+		if len(flagPlatforms) > 1 {
+			fissile.UI.Printf("Building for %s\n", platform)
+		}
+		fissile.SetPlatform(platform)
+		if err := step(platform); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// newLogger creates the logger of the progress messages, as selected by the
+// logging flags
+func newLogger() (*logging.Logger, error) {
+	if flagVerbose && flagQuiet {
+		return nil, fmt.Errorf("Only one of --verbose and --quiet can be set")
+	}
+
+	level, err := logging.ParseLevel(flagLogLevel)
+	if err != nil {
+		return nil, err
+	}
+	if flagVerbose {
+		level = logging.Debug
+	} else if flagQuiet {
+		level = logging.Warn
+	}
+
+	format, err := logging.ParseFormat(flagLogFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	var output io.Writer = os.Stderr
+	if runtime.GOOS == "windows" {
+		output = ansicolor.NewAnsiColorWriter(os.Stderr)
+	}
+
+	return logging.New(output, level, format), nil
+}
+
 func validateReleaseArgs() error {
 	releasePathsCount := len(flagRelease)
 	releaseNamesCount := len(flagReleaseName)
@@ -290,18 +566,25 @@ func validateReleaseArgs() error {
 	return nil
 }
 
-func absolutePathsForArray(paths []string) ([]string, error) {
-	absolutePaths := make([]string, len(paths))
+// absoluteReleasePaths makes all local release paths absolute, leaving
+// remote release references alone
+func absoluteReleasePaths(paths []string) ([]string, error) {
+	result := make([]string, len(paths))
 	for idx, path := range paths {
+		if model.IsRemoteRelease(path) {
+			result[idx] = path
+			continue
+		}
+
 		absPath, err := absolutePath(path)
 		if err != nil {
 			return nil, err
 		}
 
-		absolutePaths[idx] = absPath
+		result[idx] = absPath
 	}
 
-	return absolutePaths, nil
+	return result, nil
 }
 
 func absolutePaths(paths ...*string) error {
@@ -337,3 +620,18 @@ func splitNonEmpty(value string, separator string) []string {
 	}
 	return r
 }
+
+// rolesFlagUsage describes the role selections --roles takes, after the
+// description of what is done with the selected roles
+const rolesFlagUsage = "; comma separated. Each is a role name, a glob pattern (router-*), or an expression of tag:, type:, group: and stage: terms combined with AND, OR, NOT and parentheses."
+
+// addListSelectionFlag adds the --list-selection flag of commands taking
+// --roles
+func addListSelectionFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolP(
+		"list-selection",
+		"",
+		false,
+		"Only list the roles selected by --roles, without doing anything else",
+	)
+}
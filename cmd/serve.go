@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"github.com/hpcloud/fissile/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Exposes fissile operations through a REST API.",
+	Long: `
+This command starts an HTTP server which runs fissile operations on request, so
+that build orchestration can drive fissile without parsing its output.
+
+The following endpoints are available:
+
+- ` + "`POST /v1/jobs`" + ` submits a job; the JSON body names the ` + "`operation`" + `
+  (one of load, validate, compile, build-images, build-kube, or build) and
+  optionally overrides the inputs given on the command line
+  (e.g. ` + "`releases`, `role_manifest`, `roles`, `workers`" + `). The directories
+  and files fissile writes to (the work directory, --cache-dir, --metrics,
+  and --kube-output-dir) can't be overridden. When too many jobs are queued, the
+  request is answered with 503 Service Unavailable.
+- ` + "`GET /v1/jobs`" + ` lists all jobs and their status
+- ` + "`GET /v1/jobs/<id>`" + ` shows the status of a job
+- ` + "`GET /v1/jobs/<id>/log`" + ` returns the output of a job; use ` + "`?offset=N`" + `
+  to skip output already seen, and ` + "`?follow=true`" + ` to stream it until the job
+  is done
+
+Jobs run one at a time, in the order they were submitted.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		defaults := app.ServerJobRequest{
+			Releases:               flagRelease,
+			ReleaseNames:           flagReleaseName,
+			ReleaseVersions:        flagReleaseVersion,
+			CacheDir:               flagCacheDir,
+			PatchPropertiesRelease: serveViper.GetString("patch-properties-release"),
+			PipelineSettings: app.PipelineSettings{
+				BaseImage:           serveViper.GetString("from"),
+				Repository:          flagRepository,
+				MetricsPath:         flagMetrics,
+				RoleManifestPath:    flagRoleManifest,
+				LightOpinionsPath:   flagLightOpinions,
+				DarkOpinionsPath:    flagDarkOpinions,
+				CompilationDir:      workPathCompilationDir,
+				BaseDockerfileDir:   workPathBaseDockerfile,
+				DockerDir:           workPathDockerDir,
+				WorkerCount:         flagWorkers,
+				KubeOutputDir:       serveViper.GetString("kube-output-dir"),
+				KubeUseMemoryLimits: true,
+			},
+		}
+
+		return fissile.Serve(serveViper.GetString("address"), defaults)
+	},
+}
+
+var serveViper = viper.New()
+
+func init() {
+	initViper(serveViper)
+
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.PersistentFlags().StringP(
+		"address",
+		"",
+		"127.0.0.1:8080",
+		"Address the API server listens on",
+	)
+
+	serveCmd.PersistentFlags().StringP(
+		"from",
+		"",
+		"ubuntu:14.04",
+		"Default docker image used as a base for the compilation and stemcell layers",
+	)
+
+	serveCmd.PersistentFlags().StringP(
+		"patch-properties-release",
+		"P",
+		"",
+		"Used to designate a \"patch-properties\" psuedo-job in a particular release.  Format: RELEASE/JOB.",
+	)
+
+	serveCmd.PersistentFlags().StringP(
+		"kube-output-dir",
+		"",
+		"",
+		"Directory the build-kube and build operations write Kubernetes configs to; those of build are skipped if empty",
+	)
+
+	serveViper.BindPFlags(serveCmd.PersistentFlags())
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showDependencyGraphCmd represents the dependency-graph command
+var showDependencyGraphCmd = &cobra.Command{
+	Use:   "dependency-graph",
+	Short: "Displays a graph of what roles, jobs and packages depend on.",
+	Long: `
+Displays a graph of the roles of the role manifest, the jobs they run, the
+packages of those jobs, and the packages these depend on in turn. This shows
+what is affected when the compilation of a package fails.
+
+The only supported --format is dot; render the graph with Graphviz, e.g.
+
+  fissile show dependency-graph | dot -Tsvg > dependencies.svg
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowDependencyGraph(
+			flagRoleManifest,
+			showDependencyGraphViper.GetString("format"),
+		)
+	},
+}
+
+var showDependencyGraphViper = viper.New()
+
+func init() {
+	initViper(showDependencyGraphViper)
+
+	showCmd.AddCommand(showDependencyGraphCmd)
+
+	showDependencyGraphCmd.PersistentFlags().StringP(
+		"format",
+		"",
+		"dot",
+		"Format of the graph; only dot is supported",
+	)
+
+	showDependencyGraphViper.BindPFlags(showDependencyGraphCmd.PersistentFlags())
+}
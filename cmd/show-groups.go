@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// showGroupsCmd represents the groups command
+var showGroupsCmd = &cobra.Command{
+	Use:   "groups",
+	Short: "Displays the role groups of the role manifest.",
+	Long: `
+Displays a report of the role groups of the role manifest, as given by the
+` + "`group`" + ` of each role. For each group, it lists its roles, the number of
+instances they scale between, and the memory and CPUs needed by the minimum
+number of instances. Roles without a group are reported as (none).
+
+Use --group to limit this, and other commands, to the roles of some groups.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowGroups(flagRoleManifest, flagOutputFormat)
+	},
+}
+
+func init() {
+	showCmd.AddCommand(showGroupsCmd)
+}
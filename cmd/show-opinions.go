@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// showOpinionsCmd represents the opinions command
+var showOpinionsCmd = &cobra.Command{
+	Use:   "opinions",
+	Short: "Displays the opinions which have no effect on the roles.",
+	Long: `
+Audits the --light-opinions and --dark-opinions against the role manifest and
+the specs of the jobs of its roles, and reports:
+
+- light and dark opinions whose value is the default of their property in the
+  specs of all the jobs declaring it, which makes the opinion redundant;
+- dark opinions on properties without a template in the role manifest, which
+  leaves the property without a value;
+- light and dark opinions on properties no job of the roles declares, e.g.
+  properties renamed or dropped by a release bump.
+
+Opinions on the keys of hash properties are matched against the property
+holding them. Unlike "validate", the opinions are compared with the jobs used
+by the roles only, not with all jobs of the releases.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.DiffOpinions(flagRoleManifest, flagLightOpinions, flagDarkOpinions, flagOutputFormat)
+	},
+}
+
+func init() {
+	showCmd.AddCommand(showOpinionsCmd)
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// showPortsCmd represents the ports command
+var showPortsCmd = &cobra.Command{
+	Use:   "ports",
+	Short: "Displays the ports exposed by all roles.",
+	Long: `
+Displays a report of all ports exposed by the roles of the role manifest, with
+their name, protocol, internal and external ports, and whether they are public.
+
+Ports of the same protocol whose external ranges overlap are highlighted: they
+collide when they belong to the same role, or are both public; otherwise they
+are reported as overlapping.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowPorts(flagRoleManifest, flagOutputFormat)
+	},
+}
+
+func init() {
+	showCmd.AddCommand(showPortsCmd)
+}
@@ -25,11 +25,7 @@ The report contains the name, version, description and counts of jobs and packag
 			return err
 		}
 
-		if err := fissile.ListJobs(); err != nil {
-			return err
-		}
-
-		return fissile.ListPackages()
+		return fissile.ShowReleases(flagOutputFormat)
 	},
 }
 
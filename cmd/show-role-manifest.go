@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showRoleManifestCmd represents the role-manifest command
+var showRoleManifestCmd = &cobra.Command{
+	Use:   "role-manifest",
+	Short: "Displays the role manifest as canonical YAML.",
+	Long: `
+Prints the role manifest in a stable form, to compare versions of it: all
+settings are written, in the same order, with the keys of maps and the
+variables sorted, without comments. Keys fissile doesn't know are left out.
+
+With --resolved, the role manifest is printed as fissile sees it once loaded
+with the releases: the defaults of the settings of each role are applied, such
+as the ` + "`defaults`" + ` of the manifest, the templates of the manifest are merged
+into those of each role, the templates of the role winning, and roles of other
+types than ` + "`bosh`" + ` and ` + "`bosh-task`" + ` are left out.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resolved := showRoleManifestViper.GetBool("resolved")
+		if resolved {
+			err := fissile.LoadReleases(
+				flagRelease,
+				flagReleaseName,
+				flagReleaseVersion,
+				flagCacheDir,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return fissile.ShowRoleManifest(flagRoleManifest, resolved)
+	},
+}
+
+var showRoleManifestViper = viper.New()
+
+func init() {
+	initViper(showRoleManifestViper)
+
+	showCmd.AddCommand(showRoleManifestCmd)
+
+	showRoleManifestCmd.PersistentFlags().BoolP(
+		"resolved",
+		"",
+		false,
+		"If specified, the role manifest is printed with its defaults applied and its templates merged into each role",
+	)
+
+	showRoleManifestViper.BindPFlags(showRoleManifestCmd.PersistentFlags())
+}
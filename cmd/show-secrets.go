@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showSecretsCmd represents the secrets command
+var showSecretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Displays the secret-like properties which are not kept dark.",
+	Long: `
+Reports the properties of the jobs of the roles which look like they hold
+secrets, by their name (password, key, cert, token, ...) or their type in the
+spec, but are neither dark opinions nor templated in the role manifest. Their
+values come from the light opinions or the spec defaults, which are baked into
+the images. Public keys and certificates are left out, as are the properties
+of hashes which are dark or templated as a whole.
+
+With --placeholders, dark opinions for the reported properties are written to
+the given file, ready to fill in and merge into --dark-opinions. Each comes
+with the jobs declaring the property, its description, and a suggested
+variable to template it with in the role manifest, which dark opinions need.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowSecretProperties(
+			flagRoleManifest,
+			flagLightOpinions,
+			flagDarkOpinions,
+			showSecretsViper.GetString("placeholders"),
+			flagOutputFormat,
+		)
+	},
+}
+
+var showSecretsViper = viper.New()
+
+func init() {
+	initViper(showSecretsViper)
+
+	showCmd.AddCommand(showSecretsCmd)
+
+	showSecretsCmd.PersistentFlags().StringP(
+		"placeholders",
+		"",
+		"",
+		"Path of a file to write dark opinions for the reported properties to",
+	)
+
+	showSecretsViper.BindPFlags(showSecretsCmd.PersistentFlags())
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// showStatsCmd represents the stats command
+var showStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Displays statistics about the role manifest.",
+	Long: `
+Displays statistics about the size and complexity of the role manifest, to
+track its growth over time:
+
+- the number of roles, by type and by flight stage;
+- the number of roles by their number of jobs;
+- the number of distinct jobs and packages the roles use;
+- the number of templates of those jobs, and of properties templated by the
+  role manifest;
+- the number of configuration variables by kind: generated, secret or plain;
+- the largest roles, by the size of the archives of their jobs and packages in
+  the releases.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowStats(flagRoleManifest, flagOutputFormat)
+	},
+}
+
+func init() {
+	showCmd.AddCommand(showStatsCmd)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showVariableUsageCmd represents the variable-usage command
+var showVariableUsageCmd = &cobra.Command{
+	Use:   "variable-usage [<variable>...]",
+	Short: "Displays which templates and roles use the configuration variables.",
+	Long: `
+Displays, for the given configuration variables or all of them, the templates
+of the role manifest using them, and the roles whose jobs or sidecars use them,
+so the impact of changing a variable can be seen. Global templates are named
+by their property, templates of a role replacing or adding to them as
+` + "`<role>/<property>`" + `.
+
+The index is built the same way as the role manifest validation checks the
+variable usage. With --output-file, the complete index, with the variables
+used by each template, is written there as JSON, for editors and other tools.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowVariableUsage(
+			flagRoleManifest,
+			args,
+			showVariableUsageViper.GetString("output-file"),
+			flagOutputFormat,
+		)
+	},
+}
+
+var showVariableUsageViper = viper.New()
+
+func init() {
+	initViper(showVariableUsageViper)
+
+	showCmd.AddCommand(showVariableUsageCmd)
+
+	showVariableUsageCmd.PersistentFlags().StringP(
+		"output-file",
+		"",
+		"",
+		"Write the complete variable usage index to this file, as JSON",
+	)
+
+	showVariableUsageViper.BindPFlags(showVariableUsageCmd.PersistentFlags())
+}
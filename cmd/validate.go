@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates the role manifest and opinions.",
+	Long: `
+Loads the role manifest and the light and dark opinions, and runs the checks the
+build commands run on them, against each other and the releases, without
+building anything. All problems found are reported, and the command fails if
+there are any, which makes it usable as a quick check of changes to the role
+manifest.
+
+Besides properties no release declares, this reports the templates and dark
+opinions left over from release bumps: templates for properties no job they
+apply to declares, and dark opinions on properties no job of the roles
+declares, although unused jobs of the releases still do.
+
+With --check-secrets, the properties of the jobs of the roles which look like
+they hold secrets, by their name (password, key, cert, token, ...) or their
+type in the spec, are reported as well, unless they are dark opinions or
+templated in the role manifest. Otherwise their values come from the light
+opinions or the spec defaults, which are baked into the images. See
+"fissile show secrets" to write dark opinions for them.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fissile.SetCheckSecrets(validateViper.GetBool("check-secrets"))
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.Validate(flagRoleManifest, flagLightOpinions, flagDarkOpinions)
+	},
+}
+
+var validateViper = viper.New()
+
+func init() {
+	initViper(validateViper)
+
+	RootCmd.AddCommand(validateCmd)
+
+	validateCmd.PersistentFlags().BoolP(
+		"check-secrets",
+		"",
+		false,
+		"Report secret-like properties which are neither dark opinions nor templated",
+	)
+
+	validateViper.BindPFlags(validateCmd.PersistentFlags())
+}
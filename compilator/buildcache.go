@@ -0,0 +1,61 @@
+package compilator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// ContainerBuildCacheDir is where the build cache is mounted in compilation
+// containers
+const ContainerBuildCacheDir = "/var/vcap/build-cache"
+
+// buildCacheEnvVar tells the compilation script where the build cache is; it
+// points the caches of ccache, go and bundler into it
+const buildCacheEnvVar = "FISSILE_BUILD_CACHE"
+
+// BuildCache is a persistent cache of the tools compiling packages (ccache,
+// the go build cache, rubygems), kept from one compilation to the next
+type BuildCache struct {
+	// The directory of the cache on the host, or with Kubernetes, the
+	// persistent volume claim it is on
+	Location string
+	// Whether each package has a cache of its own, named after it, instead
+	// of all sharing one
+	PerPackage bool
+}
+
+// SetBuildCache sets the build cache mounted into the containers compiling
+// packages; there is none if nil
+func (c *Compilator) SetBuildCache(cache *BuildCache) {
+	c.buildCache = cache
+}
+
+// buildCacheSubPath returns the path of the build cache of a package within
+// the cache: a directory named after the package if packages have their own,
+// else the cache itself. The cache of a package is kept across its versions.
+func (c *Compilator) buildCacheSubPath(pkg *model.Package) string {
+	if !c.buildCache.PerPackage {
+		return ""
+	}
+	return pkg.Name
+}
+
+// packageBuildCacheDir returns the directory of the build cache of a package
+// on the host, creating it; empty if there is no build cache
+func (c *Compilator) packageBuildCacheDir(pkg *model.Package) (string, error) {
+	if c.buildCache == nil {
+		return "", nil
+	}
+
+	dir, err := filepath.Abs(filepath.Join(c.buildCache.Location, c.buildCacheSubPath(pkg)))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("Error creating the build cache of package %s: %s", pkg.Name, err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,47 @@
+package compilator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageBuildCacheDir(t *testing.T) {
+	assert := assert.New(t)
+
+	cacheDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(cacheDir)
+
+	pkg := &model.Package{Name: "openssl", Fingerprint: "abc"}
+	comp := &Compilator{}
+
+	dir, err := comp.packageBuildCacheDir(pkg)
+	assert.NoError(err)
+	assert.Empty(dir, "There should be no build cache unless one is set")
+
+	comp.SetBuildCache(&BuildCache{Location: cacheDir})
+	dir, err = comp.packageBuildCacheDir(pkg)
+	assert.NoError(err)
+	assert.Equal(cacheDir, dir)
+
+	comp.SetBuildCache(&BuildCache{Location: cacheDir, PerPackage: true})
+	dir, err = comp.packageBuildCacheDir(pkg)
+	assert.NoError(err)
+	assert.Equal(filepath.Join(cacheDir, "openssl"), dir)
+	if info, err := os.Stat(dir); assert.NoError(err) {
+		assert.True(info.IsDir())
+	}
+
+	// Other versions of the package use the same cache
+	dir, err = comp.packageBuildCacheDir(&model.Package{Name: "openssl", Fingerprint: "def"})
+	assert.NoError(err)
+	assert.Equal(filepath.Join(cacheDir, "openssl"), dir)
+}
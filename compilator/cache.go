@@ -0,0 +1,424 @@
+package compilator
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/pivotal-golang/archiver/extractor"
+)
+
+// PackageCache stores compiled packages outside the compilation directory,
+// so that they can be shared between machines
+type PackageCache interface {
+	// Fetch downloads the archive stored under the key into a file; it
+	// returns false if there is none
+	Fetch(key, archivePath string) (bool, error)
+	// Store uploads an archive under the key
+	Store(key, archivePath string) error
+}
+
+// NewPackageCache creates a package cache from its location:
+//
+//   http://, https://  a bucket accessed with plain GET and PUT requests,
+//                      sending the given headers ("Name: value")
+//   s3://bucket/path   an S3 bucket, using the credentials and region in the
+//                      AWS_* environment variables
+//   gs://bucket/path   a GCS bucket, using the OAuth token in
+//                      GOOGLE_OAUTH_ACCESS_TOKEN
+//   anything else      a directory, e.g. on a shared file system
+func NewPackageCache(location string, headers []string) (PackageCache, error) {
+	cacheURL, err := url.Parse(location)
+	if err != nil || cacheURL.Scheme == "" || cacheURL.Scheme == "file" {
+		path := location
+		if err == nil && cacheURL.Scheme == "file" {
+			path = cacheURL.Path
+		}
+		return &dirPackageCache{dir: path}, nil
+	}
+
+	cache := &httpPackageCache{
+		header: http.Header{},
+		client: &http.Client{},
+	}
+	prefix := strings.Trim(cacheURL.Path, "/")
+
+	switch cacheURL.Scheme {
+	case "http", "https":
+		for _, header := range headers {
+			parts := strings.SplitN(header, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Invalid package cache header %s, expected Name: value", header)
+			}
+			cache.header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+		cache.baseURL = fmt.Sprintf("%s://%s/%s", cacheURL.Scheme, cacheURL.Host, prefix)
+	case "s3":
+		credentials, err := s3CredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		cache.baseURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cacheURL.Host, credentials.region, prefix)
+		cache.sign = credentials.sign
+	case "gs":
+		token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("GOOGLE_OAUTH_ACCESS_TOKEN must be set to use a GCS package cache")
+		}
+		cache.header.Set("Authorization", "Bearer "+token)
+		cache.baseURL = fmt.Sprintf("https://storage.googleapis.com/%s/%s", cacheURL.Host, prefix)
+	default:
+		return nil, fmt.Errorf("Unsupported package cache %s, expected a directory, or an http, https, s3 or gs URL", location)
+	}
+	cache.baseURL = strings.TrimSuffix(cache.baseURL, "/")
+
+	return cache, nil
+}
+
+// packageCacheKey names the archive of a compiled package in the cache. It
+// depends on the fingerprints of the package and of all its dependencies.
+// Packages compiled for other platforms than the default one are kept apart,
+// under a directory named after the platform.
+func packageCacheKey(pkg *model.Package, platform docker.Platform) string {
+	sum := sha1.Sum([]byte(compilationKey(pkg)))
+	key := fmt.Sprintf("%s/%s-%s.tgz", pkg.Name, pkg.Fingerprint, hex.EncodeToString(sum[:]))
+	if platform != docker.DefaultPlatform {
+		key = fmt.Sprintf("%s/%s", platform.Suffix(), key)
+	}
+	return key
+}
+
+// SetPackageCache sets the cache compiled packages are fetched from before
+// compiling them, and, unless readOnly is set, stored in after compiling them
+func (c *Compilator) SetPackageCache(cache PackageCache, readOnly bool) {
+	c.packageCache = cache
+	c.packageCacheReadOnly = readOnly
+}
+
+// fetchCachedPackage tries to get a compiled package from the package cache
+func (c *Compilator) fetchCachedPackage(pkg *model.Package) (bool, error) {
+	if err := os.MkdirAll(filepath.Join(c.hostWorkDir, pkg.Fingerprint), 0755); err != nil {
+		return false, err
+	}
+
+	archive, err := ioutil.TempFile(filepath.Join(c.hostWorkDir, pkg.Fingerprint), "cached-")
+	if err != nil {
+		return false, err
+	}
+	archive.Close()
+	defer os.Remove(archive.Name())
+
+	found, err := c.packageCache.Fetch(packageCacheKey(pkg, c.platform), archive.Name())
+	if err != nil || !found {
+		return false, err
+	}
+
+	compiledTempDir := pkg.GetPackageCompiledTempDir(c.hostWorkDir)
+	if err := os.RemoveAll(compiledTempDir); err != nil {
+		return false, err
+	}
+	if err := extractor.NewTgz().Extract(archive.Name(), compiledTempDir); err != nil {
+		return false, fmt.Errorf("Error extracting cached package %s: %s", pkg.Name, err)
+	}
+
+	return true, c.storeCompiledPackage(pkg)
+}
+
+// storeCachedPackage uploads a compiled package into the package cache
+func (c *Compilator) storeCachedPackage(pkg *model.Package) error {
+	archive, err := ioutil.TempFile(filepath.Join(c.hostWorkDir, pkg.Fingerprint), "cached-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+
+	err = writeTgz(archive, pkg.GetPackageCompiledDir(c.hostWorkDir))
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error archiving package %s: %s", pkg.Name, err)
+	}
+
+	return c.packageCache.Store(packageCacheKey(pkg, c.platform), archive.Name())
+}
+
+// writeTgz writes the contents of a directory as a gzipped tar archive
+func writeTgz(writer io.Writer, dir string) error {
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// dirPackageCache keeps archives in a directory
+type dirPackageCache struct {
+	dir string
+}
+
+func (d *dirPackageCache) Fetch(key, archivePath string) (bool, error) {
+	source, err := os.Open(filepath.Join(d.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer source.Close()
+
+	return true, copyToFile(source, archivePath)
+}
+
+func (d *dirPackageCache) Store(key, archivePath string) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	source, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// Write under a temporary name first, so that concurrent readers never
+	// see a partial archive
+	tempPath := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := copyToFile(source, tempPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// httpPackageCache keeps archives in a bucket accessed over HTTP
+type httpPackageCache struct {
+	baseURL string
+	header  http.Header
+	client  *http.Client
+	sign    func(*http.Request, time.Time)
+}
+
+func (h *httpPackageCache) Fetch(key, archivePath string) (bool, error) {
+	response, err := h.do("GET", key, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound, response.StatusCode == http.StatusForbidden && h.sign != nil:
+		// S3 answers 403 for missing objects when listing is not allowed
+		return false, nil
+	case response.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("Error fetching %s from the package cache: %s", key, response.Status)
+	}
+
+	return true, copyToFile(response.Body, archivePath)
+}
+
+func (h *httpPackageCache) Store(key, archivePath string) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	info, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+
+	response, err := h.do("PUT", key, archive, info.Size())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Error storing %s in the package cache: %s", key, response.Status)
+	}
+	return nil
+}
+
+// do sends a request for the object with the given key
+func (h *httpPackageCache) do(method, key string, body io.Reader, size int64) (*http.Response, error) {
+	request, err := http.NewRequest(method, h.baseURL+"/"+key, body)
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = size
+	for name, values := range h.header {
+		request.Header[name] = values
+	}
+	if h.sign != nil {
+		h.sign(request, time.Now().UTC())
+	}
+
+	return h.client.Do(request)
+}
+
+// s3Credentials sign requests to S3 using signature version 4
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+}
+
+// s3CredentialsFromEnv reads S3 credentials from the standard AWS
+// environment variables
+func s3CredentialsFromEnv() (*s3Credentials, error) {
+	credentials := &s3Credentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		region:          os.Getenv("AWS_REGION"),
+	}
+	if credentials.accessKeyID == "" || credentials.secretAccessKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to use an S3 package cache")
+	}
+	if credentials.region == "" {
+		credentials.region = "us-east-1"
+	}
+	return credentials, nil
+}
+
+// sign adds an AWS signature version 4 to a request. The payload is not
+// signed, which S3 allows over HTTPS.
+func (s *s3Credentials) sign(request *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	request.Header.Set("X-Amz-Date", amzDate)
+	if request.Header.Get("X-Amz-Content-Sha256") == "" {
+		request.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+	if s.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		request.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s.region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// copyToFile writes everything read from a reader into a file
+func copyToFile(source io.Reader, path string) error {
+	target, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(target, source)
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -49,6 +51,12 @@ type Compilator struct {
 	baseType         string
 	fissileVersion   string
 	compilePackage   func(*Compilator, *model.Package) error
+	force            bool
+
+	packageCache         PackageCache
+	packageCacheReadOnly bool
+	platform             docker.Platform
+	kube                 *KubeOptions // Only when compiling in Kubernetes
 
 	// signalDependencies is a map of
 	//    (package fingerprint) -> (channel to close when done)
@@ -65,6 +73,10 @@ type Compilator struct {
 	signalDependencies map[string]chan struct{}
 	keepContainer      bool
 	ui                 *termui.UI
+	progress           Progress
+	stats              *statsRecorder // Of the last run
+	limits             *CompileLimits
+	buildCache         *BuildCache
 }
 
 type compileJob struct {
@@ -96,6 +108,7 @@ func NewDockerCompilator(
 		fissileVersion:   fissileVersion,
 		compilePackage:   (*Compilator).compilePackageInDocker,
 		keepContainer:    keepContainer,
+		platform:         docker.DefaultPlatform,
 		ui:               ui,
 
 		signalDependencies: make(map[string]chan struct{}),
@@ -122,6 +135,7 @@ func NewMountNSCompilator(
 		baseType:         baseType,
 		fissileVersion:   fissileVersion,
 		compilePackage:   (*Compilator).compilePackageInMountNS,
+		platform:         docker.DefaultPlatform,
 		ui:               ui,
 
 		signalDependencies: make(map[string]chan struct{}),
@@ -130,6 +144,34 @@ func NewMountNSCompilator(
 	return compilator, nil
 }
 
+// SetForce selects whether all packages are compiled, even those compiled
+// already
+func (c *Compilator) SetForce(force bool) {
+	c.force = force
+}
+
+// SetPlatform sets the platform packages are compiled for. It only tells
+// apart their archives in the package cache: the compilation image and the
+// work directory must already be those of the platform.
+func (c *Compilator) SetPlatform(platform docker.Platform) {
+	c.platform = platform
+}
+
+// SetProgress sets the report of the states of packages while they are
+// compiled, and of their output. By default, lines are printed to the UI.
+func (c *Compilator) SetProgress(progress Progress) {
+	c.progress = progress
+}
+
+// output returns where the output of compilations, e.g. the logs of failed
+// packages, is written
+func (c *Compilator) output() io.Writer {
+	if c.progress != nil {
+		return c.progress
+	}
+	return c.ui
+}
+
 var errWorkerAbort = errors.New("worker aborted")
 
 type compileResult struct {
@@ -163,17 +205,40 @@ type compileResult struct {
 //   workers out and won't wait for the <-doneCh for the N packages it
 //   drained.
 func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles model.Roles) error {
-	packages, err := c.removeCompiledPackages(c.gatherPackages(releases, roles))
+	c.stats = newStatsRecorder(workerCount)
+	defer c.stats.end()
+
+	gathered := c.gatherPackages(releases, roles)
+	packages, err := c.removeCompiledPackages(gathered)
 
 	if err != nil {
 		return fmt.Errorf("failed to remove compiled packages: %v", err)
 	}
+	c.stats.compiledBefore(gathered, packages, c.hostWorkDir)
 	if 0 == len(packages) {
 		c.ui.Println("No package needed to be built")
 		return nil
 	}
 	sort.Sort(packages)
 
+	// Packages left half compiled by an interrupted run start over
+	for _, pkg := range packages {
+		if err := c.discardPartialCompilation(pkg); err != nil {
+			return err
+		}
+		if err := c.writePackageState(pkg, packageStatePending, ""); err != nil {
+			return fmt.Errorf("failed to record the state of package %s: %v", pkg.Name, err)
+		}
+	}
+
+	if c.progress == nil {
+		c.progress = NewProgress(c.ui, false)
+	}
+	defer c.progress.Close()
+	for _, pkg := range packages {
+		c.progress.Update(pkg, PackageQueued, "")
+	}
+
 	// Setup the queuing system ...
 	doneCh := make(chan compileResult)
 	killCh := make(chan struct{})
@@ -201,8 +266,8 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles m
 
 	// (**) All jobs push their results into the single doneCh.
 	// The code below is a synchronizer which pulls the results
-	// from the channel as fast as it can and reports them to the
-	// user. In case of an error it signals this back to all jobs
+	// from the channel as fast as it can; the jobs report them to
+	// the user. In case of an error it signals this back to all jobs
 	// by closing killCh. This will cause the remaining jobs to
 	// abort when the queing system invokes them.  Note however,
 	// that the synchronizer is in a race with the dependency
@@ -213,21 +278,9 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles m
 	for result := range doneCh {
 		if result.err == nil {
 			close(c.signalDependencies[result.pkg.Fingerprint])
-			c.ui.Printf("%s   > success: %s/%s\n",
-				color.YellowString("result"),
-				color.GreenString(result.pkg.Release.Name),
-				color.GreenString(result.pkg.Name))
 			continue
 		}
 
-		c.ui.Printf(
-			"%s   > failure: %s/%s - %s\n",
-			color.YellowString("result"),
-			color.RedString(result.pkg.Release.Name),
-			color.RedString(result.pkg.Name),
-			color.RedString(result.err.Error()),
-		)
-
 		err = result.err
 		if !killed {
 			close(killCh)
@@ -267,6 +320,7 @@ func (c *Compilator) gatherPackages(releases []*model.Release, roles model.Roles
 
 func (j compileJob) Run() {
 	c := j.compilator
+	run := c.stats.start(j.pkg)
 
 	// Metrics: Overall time for the specific job
 	var waitSeriesName string
@@ -282,6 +336,27 @@ func (j compileJob) Run() {
 		stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "start")
 	}
 
+	// A cached package does not need its dependencies, as the cache key
+	// covers their fingerprints
+	if c.packageCache != nil && !c.force {
+		found, err := c.fetchCachedPackage(j.pkg)
+		if err != nil {
+			fmt.Fprintf(c.progress, "%s fetching %s/%s from the package cache failed: %s\n",
+				color.YellowString("Warning:"), j.pkg.Release.Name, j.pkg.Name, err)
+		}
+		run.cacheLookup(found && err == nil)
+		if found && err == nil {
+			c.progress.Update(j.pkg, PackageCached, "")
+			run.end(PackageCached, j.pkg.GetPackageCompiledDir(c.hostWorkDir))
+
+			if c.metricsPath != "" {
+				stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
+			}
+			j.doneCh <- compileResult{pkg: j.pkg}
+			return
+		}
+	}
+
 	// (xx) Wait for our deps. Note how without deps the killCh is
 	// not checked and ignored. It is also in a race with (**)
 	// draining doneCh and actually signaling the kill.
@@ -292,9 +367,8 @@ func (j compileJob) Run() {
 		for !done {
 			select {
 			case <-j.killCh:
-				c.ui.Printf("killed:  %s/%s\n",
-					color.MagentaString(j.pkg.Release.Name),
-					color.MagentaString(j.pkg.Name))
+				c.progress.Update(j.pkg, PackageKilled, "")
+				run.end(PackageKilled, "")
 				j.doneCh <- compileResult{pkg: j.pkg, err: errWorkerAbort}
 
 				if c.metricsPath != "" {
@@ -302,15 +376,8 @@ func (j compileJob) Run() {
 				}
 				return
 			case <-time.After(5 * time.Second):
-				c.ui.Printf("waiting: %s/%s - %s\n",
-					color.MagentaString(j.pkg.Release.Name),
-					color.MagentaString(j.pkg.Name),
-					color.MagentaString(dep.Name))
+				c.progress.Update(j.pkg, PackageWaiting, dep.Name)
 			case <-c.signalDependencies[dep.Fingerprint]:
-				c.ui.Printf("depdone: %s/%s - %s\n",
-					color.MagentaString(j.pkg.Release.Name),
-					color.MagentaString(j.pkg.Name),
-					color.MagentaString(dep.Name))
 				done = true
 			}
 		}
@@ -320,24 +387,37 @@ func (j compileJob) Run() {
 		stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
 	}
 
-	c.ui.Printf("compile: %s/%s\n",
-		color.MagentaString(j.pkg.Release.Name),
-		color.MagentaString(j.pkg.Name))
+	c.progress.Update(j.pkg, PackageCompiling, "")
+	run.compiling()
 
 	// Time spent in actual compilation
 	if c.metricsPath != "" {
 		stampy.Stamp(c.metricsPath, "fissile", runSeriesName, "start")
 	}
 
-	workerErr := c.compilePackage(c, j.pkg)
+	workerErr := c.writePackageState(j.pkg, packageStateCompiling, "")
+	if workerErr == nil {
+		workerErr = c.compilePackage(c, j.pkg)
+	}
 
 	if c.metricsPath != "" {
 		stampy.Stamp(c.metricsPath, "fissile", runSeriesName, "done")
 	}
 
-	c.ui.Printf("done:    %s/%s\n",
-		color.MagentaString(j.pkg.Release.Name),
-		color.MagentaString(j.pkg.Name))
+	if workerErr == nil {
+		c.progress.Update(j.pkg, PackageDone, "")
+		run.end(PackageDone, j.pkg.GetPackageCompiledDir(c.hostWorkDir))
+	} else {
+		c.progress.Update(j.pkg, PackageFailed, workerErr.Error())
+		run.end(PackageFailed, "")
+	}
+
+	if workerErr == nil && c.packageCache != nil && !c.packageCacheReadOnly {
+		if err := c.storeCachedPackage(j.pkg); err != nil {
+			fmt.Fprintf(c.progress, "%s storing %s/%s in the package cache failed: %s\n",
+				color.YellowString("Warning:"), j.pkg.Release.Name, j.pkg.Name, err)
+		}
+	}
 
 	j.doneCh <- compileResult{pkg: j.pkg, err: workerErr}
 }
@@ -485,7 +565,7 @@ func (c *Compilator) CreateCompilationBase(baseImageName string) (image *dockerC
 	defer os.RemoveAll(tempScriptDir)
 
 	targetScriptName := "compilation-prerequisites.sh"
-	containerScriptPath := filepath.Join(docker.ContainerInPath, targetScriptName)
+	containerScriptPath := path.Join(docker.ContainerInPath, targetScriptName)
 	hostScriptPath := filepath.Join(tempScriptDir, targetScriptName)
 	if err = compilation.SaveScript(c.baseType, compilation.PrerequisitesScript, hostScriptPath); err != nil {
 		return nil, fmt.Errorf("Error saving script asset: %s", err.Error())
@@ -562,32 +642,79 @@ func (c *Compilator) CreateCompilationBase(baseImageName string) (image *dockerC
 	return image, nil
 }
 
-func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
+// prepareCompilation sets up the inputs of the compilation of a package in
+// the work directory: its sources, its compiled dependencies, and the
+// compilation script. It returns the path of the script in the container.
+func (c *Compilator) prepareCompilation(pkg *model.Package) (string, error) {
 	// Prepare input dir (package plus deps)
 	if err := c.createCompilationDirStructure(pkg); err != nil {
-		return err
+		return "", err
 	}
 
 	if err := c.copyDependencies(pkg); err != nil {
-		return err
+		return "", err
 	}
 
 	// Generate a compilation script
 	targetScriptName := "compile.sh"
 	hostScriptPath := filepath.Join(pkg.GetTargetPackageSourcesDir(c.hostWorkDir), targetScriptName)
-	containerScriptPath := filepath.Join(docker.ContainerInPath, targetScriptName)
 	if err := compilation.SaveScript(c.baseType, compilation.CompilationScript, hostScriptPath); err != nil {
-		return err
+		return "", err
 	}
 
 	// Extract package
 	extractDir := c.getSourcePackageDir(pkg)
 	if _, err := pkg.Extract(extractDir); err != nil {
+		return "", err
+	}
+
+	return path.Join(docker.ContainerInPath, targetScriptName), nil
+}
+
+// Shell starts an interactive shell in a container of the compilation image,
+// with the inputs of the package mounted the way they are for its compilation.
+// The dependencies of the package have to be compiled already. The compilation
+// script is not run; the command running it is printed instead.
+func (c *Compilator) Shell(pkg *model.Package, stdin io.Reader, stdout, stderr io.Writer) error {
+	containerScriptPath, err := c.prepareCompilation(pkg)
+	if err != nil {
+		return fmt.Errorf("Error preparing the compilation of %s: %s", pkg.Name, err)
+	}
+	if err := os.MkdirAll(pkg.GetPackageCompiledTempDir(c.hostWorkDir), 0755); err != nil {
+		return err
+	}
+
+	c.ui.Printf("Starting a shell in %s; compile %s with:\n  %s\n",
+		color.YellowString(c.BaseImageName()),
+		color.MagentaString(pkg.Name),
+		color.CyanString("bash %s %s %s", containerScriptPath, pkg.Name, pkg.Version))
+
+	return docker.RunShell(docker.ShellOpts{
+		ContainerName: c.getPackageContainerName(pkg) + "-shell",
+		ImageName:     c.BaseImageName(),
+		Mounts: map[string]string{
+			pkg.GetTargetPackageSourcesDir(c.hostWorkDir): docker.ContainerInPath,
+			pkg.GetPackageCompiledTempDir(c.hostWorkDir):  docker.ContainerOutPath,
+		},
+		Volumes:    []string{ContainerSourceDir},
+		WorkingDir: "/",
+	}, stdin, stdout, stderr)
+}
+
+func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
+	containerScriptPath, err := c.prepareCompilation(pkg)
+	if err != nil {
 		return err
 	}
 
 	// Run compilation in container
 	containerName := c.getPackageContainerName(pkg)
+	if c.keepContainer {
+		// The container of an earlier failed compilation is in the way
+		if err := c.dockerManager.RemoveStaleContainer(containerName); err != nil {
+			return fmt.Errorf("Error removing the old container compiling package %s: %s", pkg.Name, err)
+		}
+	}
 
 	// in-memory buffer of the log
 	log := new(bytes.Buffer)
@@ -614,6 +741,16 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		// from, so it will be in some docker-maintained storage.
 		sourceMountName: ContainerSourceDir,
 	}
+	var env []string
+	buildCacheDir, err := c.packageBuildCacheDir(pkg)
+	if err != nil {
+		return err
+	}
+	if buildCacheDir != "" {
+		mounts[buildCacheDir] = ContainerBuildCacheDir
+		env = append(env, fmt.Sprintf("%s=%s", buildCacheEnvVar, ContainerBuildCacheDir))
+	}
+	limits := c.packageLimits(pkg)
 	exitCode, container, err := c.dockerManager.RunInContainer(docker.RunInContainerOpts{
 		ContainerName: containerName,
 		ImageName:     c.BaseImageName(),
@@ -623,6 +760,9 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		KeepContainer: c.keepContainer,
 		StdoutWriter:  stdoutWriter,
 		StderrWriter:  stderrWriter,
+		Env:           env,
+		Memory:        limits.Memory,
+		CPUs:          limits.CPUs,
 	})
 
 	if container != nil && (!c.keepContainer || err == nil || exitCode == 0) {
@@ -652,21 +792,75 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		}()
 	}
 
+	if err != nil || exitCode != 0 {
+		log.WriteTo(c.output())
+		if container != nil && c.keepContainer {
+			fmt.Fprintf(c.output(), "Kept container %s (%s) of the failed compilation of %s; enter it with:\n  %s\n",
+				color.YellowString(containerName), container.ID, color.MagentaString(pkg.Name),
+				color.CyanString("docker exec -it %s bash", containerName))
+		}
+	}
+
 	if err != nil {
-		log.WriteTo(c.ui)
 		return fmt.Errorf("Error compiling package %s: %s", pkg.Name, err.Error())
 	}
 
 	if exitCode != 0 {
-		log.WriteTo(c.ui)
 		return fmt.Errorf("Error - compilation for package %s exited with code %d", pkg.Name, exitCode)
 	}
 
-	return os.Rename(
-		pkg.GetPackageCompiledTempDir(c.hostWorkDir),
-		pkg.GetPackageCompiledDir(c.hostWorkDir))
+	return c.storeCompiledPackage(pkg)
+}
+
+// storeCompiledPackage moves the compilation result of a package into place,
+// replacing any stale one, and records the fingerprints it was compiled with
+func (c *Compilator) storeCompiledPackage(pkg *model.Package) error {
+	compiledPackagePath := pkg.GetPackageCompiledDir(c.hostWorkDir)
+	if err := os.RemoveAll(compiledPackagePath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(pkg.GetPackageCompiledTempDir(c.hostWorkDir), compiledPackagePath); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(pkg.GetPackageCompilationKeyFile(c.hostWorkDir), []byte(compilationKey(pkg)), 0644); err != nil {
+		return err
+	}
+
+	checksum, err := dirChecksum(compiledPackagePath)
+	if err != nil {
+		return err
+	}
+	return c.writePackageState(pkg, packageStateDone, checksum)
 }
 
+// compilationKey lists the fingerprints of a package and of all packages it
+// depends on, directly or not. The fingerprint of a package only covers its
+// own sources, so a package is compiled again when this changes.
+func compilationKey(pkg *model.Package) string {
+	seen := make(map[*model.Package]bool)
+	var lines []string
+
+	var visit func(*model.Package)
+	visit = func(p *model.Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		lines = append(lines, fmt.Sprintf("%s %s\n", p.Name, p.Fingerprint))
+		for _, dep := range p.Dependencies {
+			visit(dep)
+		}
+	}
+	visit(pkg)
+
+	sort.Strings(lines[1:])
+	return strings.Join(lines, "")
+}
+
+// isPackageCompiled checks whether a package has been compiled, against the
+// same dependencies it has now
 func (c *Compilator) isPackageCompiled(pkg *model.Package) (bool, error) {
 	// If compiled package exists on hard disk
 	compiledPackagePath := pkg.GetPackageCompiledDir(c.hostWorkDir)
@@ -680,11 +874,38 @@ func (c *Compilator) isPackageCompiled(pkg *model.Package) (bool, error) {
 	}
 
 	compiledDirEmpty, err := isDirEmpty(compiledPackagePath)
+	if err != nil || compiledDirEmpty {
+		return false, err
+	}
+
+	// The state of packages compiled by older versions of fissile is
+	// unknown; their compilation key tells whether they were completely
+	// stored
+	state, err := c.readPackageState(pkg)
 	if err != nil {
 		return false, err
 	}
+	if state != nil {
+		if state.State != packageStateDone || state.Key != compilationKey(pkg) {
+			return false, nil
+		}
+		checksum, err := dirChecksum(compiledPackagePath)
+		if err != nil {
+			return false, err
+		}
+		return checksum == state.Checksum, nil
+	}
 
-	return !compiledDirEmpty, nil
+	// Packages compiled by even older versions of fissile have no key;
+	// they are compiled again, as their dependencies are unknown
+	key, err := ioutil.ReadFile(pkg.GetPackageCompilationKeyFile(c.hostWorkDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return string(key) == compilationKey(pkg), nil
 }
 
 func isDirEmpty(path string) (bool, error) {
@@ -812,9 +1033,21 @@ func (c *Compilator) BaseImageName() string {
 	return util.SanitizeDockerName(fmt.Sprintf("%s:%s", c.baseCompilationImageRepository(), c.baseCompilationImageTag()))
 }
 
+// GetBaseImageName returns the name of the compilation base image for the
+// given repository and fissile version
+func GetBaseImageName(repository, fissileVersion string) string {
+	c := &Compilator{repositoryPrefix: repository, fissileVersion: fissileVersion}
+	return c.BaseImageName()
+}
+
 // removeCompiledPackages must be called after initPackageMaps as it closes
-// the broadcast channels of anything already compiled.
+// the broadcast channels of anything already compiled. Nothing is removed
+// when compilation is forced.
 func (c *Compilator) removeCompiledPackages(packages model.Packages) (model.Packages, error) {
+	if c.force {
+		return packages, nil
+	}
+
 	var culledPackages model.Packages
 	for _, pkg := range packages {
 		compiled, err := isPackageCompiledHarness(c, pkg)
@@ -823,6 +1056,9 @@ func (c *Compilator) removeCompiledPackages(packages model.Packages) (model.Pack
 		}
 
 		if compiled {
+			// Mark it as used, for fissile cache prune
+			now := time.Now()
+			os.Chtimes(filepath.Join(c.hostWorkDir, pkg.Fingerprint), now, now)
 			close(c.signalDependencies[pkg.Fingerprint])
 		} else {
 			culledPackages = append(culledPackages, pkg)
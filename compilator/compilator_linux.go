@@ -53,6 +53,15 @@ func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 		},
 	)
 
+	env := append(os.Environ(), "HOST_USERID=1000", "HOST_USERGID=1000")
+	buildCacheDir, err := c.packageBuildCacheDir(pkg)
+	if err != nil {
+		return err
+	}
+	if buildCacheDir != "" {
+		env = append(env, fmt.Sprintf("%s=%s", buildCacheEnvVar, buildCacheDir))
+	}
+
 	bashPath, err := exec.LookPath("bash")
 	if err != nil {
 		return fmt.Errorf("Failed to find bash: %s", err)
@@ -60,7 +69,7 @@ func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 	cmd := &exec.Cmd{
 		Path:   bashPath,
 		Args:   []string{"bash", hostScriptPath, pkg.Name, pkg.Version, c.hostWorkDir},
-		Env:    append(os.Environ(), "HOST_USERID=1000", "HOST_USERGID=1000"),
+		Env:    env,
 		Dir:    c.hostWorkDir,
 		Stdout: stdoutWriter,
 		Stderr: stderrWriter,
@@ -70,7 +79,7 @@ func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 	}
 	err = cmd.Run()
 	if err != nil {
-		log.WriteTo(c.ui)
+		log.WriteTo(c.output())
 		if exitError, ok := err.(*exec.ExitError); ok {
 			if waitStatus, ok := exitError.Sys().(*syscall.WaitStatus); ok {
 				return fmt.Errorf("Error - compilation for packages %s exited with code %d", pkg.Name, waitStatus.ExitStatus())
@@ -79,7 +88,5 @@ func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 		return fmt.Errorf("Error compiling package %s: %s", pkg.Name, err)
 	}
 
-	return os.Rename(
-		pkg.GetPackageCompiledTempDir(c.hostWorkDir),
-		pkg.GetPackageCompiledDir(c.hostWorkDir))
+	return c.storeCompiledPackage(pkg)
 }
@@ -49,7 +49,11 @@ func TestMain(m *testing.M) {
 func TestCompilationEmpty(t *testing.T) {
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", false, ui)
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", false, ui)
 	assert.NoError(err)
 
 	waitCh := make(chan struct{})
@@ -71,7 +75,11 @@ func TestCompilationBasic(t *testing.T) {
 	metrics := file.Name()
 	defer os.Remove(metrics)
 
-	c, err := NewDockerCompilator(nil, "", metrics, "", "", "", false, ui)
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, metrics, "", "", "", false, ui)
 	assert.NoError(err)
 
 	compileChan := make(chan string)
@@ -150,7 +158,11 @@ func TestCompilationSkipCompiled(t *testing.T) {
 
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", false, ui)
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", false, ui)
 	assert.NoError(err)
 
 	compileChan := make(chan string)
@@ -175,7 +187,11 @@ func TestCompilationSkipCompiled(t *testing.T) {
 func TestCompilationRoleManifest(t *testing.T) {
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", false, ui)
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", false, ui)
 	assert.NoError(err)
 
 	compileChan := make(chan string, 2)
@@ -365,7 +381,11 @@ func TestCompilationMultipleErrors(t *testing.T) {
 
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", false, ui)
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", false, ui)
 	assert.NoError(err)
 
 	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
@@ -408,8 +428,39 @@ func TestGetPackageStatusCompiled(t *testing.T) {
 
 	status, err := compilator.isPackageCompiled(release.Packages[0])
 
+	assert.NoError(err)
+	assert.False(status, "Packages without a compilation key should be compiled again")
+
+	keyPath := release.Packages[0].GetPackageCompilationKeyFile(compilationWorkDir)
+	err = ioutil.WriteFile(keyPath, []byte(compilationKey(release.Packages[0])), 0644)
+	assert.NoError(err)
+
+	status, err = compilator.isPackageCompiled(release.Packages[0])
+
 	assert.NoError(err)
 	assert.True(status)
+
+	err = ioutil.WriteFile(keyPath, []byte("stale 1234\n"), 0644)
+	assert.NoError(err)
+
+	status, err = compilator.isPackageCompiled(release.Packages[0])
+
+	assert.NoError(err)
+	assert.False(status, "Packages whose dependencies changed should be compiled again")
+}
+
+func TestCompilationKey(t *testing.T) {
+	assert := assert.New(t)
+
+	libyaml := &model.Package{Name: "libyaml", Fingerprint: "1111"}
+	ruby := &model.Package{Name: "ruby", Fingerprint: "2222", Dependencies: model.Packages{libyaml}}
+	app := &model.Package{Name: "app", Fingerprint: "3333", Dependencies: model.Packages{ruby, libyaml}}
+
+	assert.Equal("app 3333\nlibyaml 1111\nruby 2222\n", compilationKey(app))
+
+	// A change to an indirect dependency changes the key
+	libyaml.Fingerprint = "4444"
+	assert.Equal("app 3333\nlibyaml 4444\nruby 2222\n", compilationKey(app))
 }
 
 // TestCompilationParallel checks that we compile multiple releases in parallel
@@ -454,7 +505,11 @@ func TestCompilationParallel(t *testing.T) {
 
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", false, ui)
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", false, ui)
 	assert.NoError(err)
 	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
 		mutex.Lock()
@@ -743,6 +798,14 @@ func TestRemoveCompiledPackages(t *testing.T) {
 	assert.Len(packages, 2)
 	assert.Equal(packages[0].Name, "consul")
 	assert.Equal(packages[1].Name, "go-1.4")
+
+	c, err = NewDockerCompilator(nil, "", "", "", "", "", false, ui)
+	assert.NoError(err)
+	c.SetForce(true)
+
+	packages, err = c.removeCompiledPackages(c.gatherPackages(releases, nil))
+	assert.NoError(err)
+	assert.Len(packages, 3, "Forced compilation should keep compiled packages")
 }
 
 func genTestCase(args ...string) []*model.Release {
@@ -0,0 +1,430 @@
+package compilator
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/docker"
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+	"github.com/hpcloud/termui"
+	"github.com/pivotal-golang/archiver/extractor"
+	"k8s.io/client-go/pkg/api/resource"
+	meta "k8s.io/client-go/pkg/api/unversioned"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	extra "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+// Paths in the pods compiling packages in Kubernetes, besides the input and
+// output paths of the docker compilation: the state directory holds the
+// files fissile and the pod signal each other with
+const (
+	kubeStatePath     = "/fissile-state"
+	kubeReadyFile     = kubeStatePath + "/ready"
+	kubeExitCodeFile  = kubeStatePath + "/exit-code"
+	kubeCollectedFile = kubeStatePath + "/collected"
+)
+
+// KubeOptions configures the compilation of packages as Kubernetes jobs
+type KubeOptions struct {
+	Context   string        // kubeconfig context; the current one if empty
+	Namespace string        // The namespace of the context if empty
+	Image     string        // Compilation image, pulled by the cluster; the compilation base image if empty
+	Volume    string        // Persistent volume claim the pods work in; node storage if empty
+	Timeout   time.Duration // How long a package may take to start and compile; no limit if 0
+}
+
+// mocked out in tests
+var (
+	runKubectl   = runKubectlCommand
+	kubePollTime = 2 * time.Second
+)
+
+// NewKubeCompilator will create an instance of the Compilator compiling each
+// package in a Kubernetes job, through kubectl
+func NewKubeCompilator(
+	hostWorkDir string,
+	metricsPath string,
+	repositoryPrefix string,
+	baseType string,
+	fissileVersion string,
+	opts KubeOptions,
+	keepContainer bool,
+	ui *termui.UI,
+) (*Compilator, error) {
+
+	compilator := &Compilator{
+		hostWorkDir:      hostWorkDir,
+		metricsPath:      metricsPath,
+		repositoryPrefix: repositoryPrefix,
+		baseType:         baseType,
+		fissileVersion:   fissileVersion,
+		compilePackage:   (*Compilator).compilePackageInKube,
+		keepContainer:    keepContainer,
+		platform:         docker.DefaultPlatform,
+		kube:             &opts,
+		ui:               ui,
+
+		signalDependencies: make(map[string]chan struct{}),
+	}
+
+	return compilator, nil
+}
+
+// runKubectlCommand runs kubectl with the given arguments, reading its input
+// from stdin if not nil, and writing its output to stdout. The error holds
+// whatever kubectl wrote to stderr.
+func runKubectlCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	command := exec.Command("kubectl", args...)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// kubectl runs kubectl in the context and namespace of the options
+func (c *Compilator) kubectl(stdin io.Reader, stdout io.Writer, args ...string) error {
+	global := []string{}
+	if c.kube.Context != "" {
+		global = append(global, "--context", c.kube.Context)
+	}
+	if c.kube.Namespace != "" {
+		global = append(global, "--namespace", c.kube.Namespace)
+	}
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if err := runKubectl(append(global, args...), stdin, stdout); err != nil {
+		return fmt.Errorf("kubectl %s failed: %s", args[0], err)
+	}
+	return nil
+}
+
+var kubeNameInvalidChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// getPackageJobName returns the name of the Kubernetes job compiling a
+// package: a DNS label made of the package name and its fingerprint, which
+// tells apart equally named packages of different releases
+func (c *Compilator) getPackageJobName(pkg *model.Package) string {
+	name := strings.Trim(kubeNameInvalidChars.ReplaceAllString(strings.ToLower(pkg.Name), "-"), "-")
+	sum := sha1.Sum([]byte(pkg.Fingerprint + c.fissileVersion))
+	suffix := hex.EncodeToString(sum[:])[:10]
+	// Job names are limited to 63 characters, and the pods add their own
+	// suffix to it
+	const maxNameLength = 52 - len("compile--") - 10
+	if len(name) > maxNameLength {
+		name = strings.TrimRight(name[:maxNameLength], "-")
+	}
+	return fmt.Sprintf("compile-%s-%s", name, suffix)
+}
+
+// newCompilationJob returns the Kubernetes job compiling a package. Its pod
+// waits for fissile to upload the inputs of the compilation, runs the
+// compilation script, and waits for fissile to download the compiled package.
+func (c *Compilator) newCompilationJob(pkg *model.Package, jobName, containerScriptPath string) *extra.Job {
+	image := c.kube.Image
+	if image == "" {
+		image = c.BaseImageName()
+	}
+
+	script := strings.Join([]string{
+		fmt.Sprintf("while [ ! -f %s ]; do sleep 1; done", kubeReadyFile),
+		fmt.Sprintf("bash %s %s %s", containerScriptPath, pkg.Name, pkg.Version),
+		fmt.Sprintf("echo $? > %s", kubeExitCodeFile),
+		fmt.Sprintf("while [ ! -f %s ]; do sleep 1; done", kubeCollectedFile),
+	}, "\n")
+
+	volume := apiv1.Volume{Name: "work"}
+	subPathPrefix := ""
+	if c.kube.Volume != "" {
+		// Pods share the claim, each works in its own directory
+		volume.PersistentVolumeClaim = &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: c.kube.Volume}
+		subPathPrefix = jobName + "/"
+	} else {
+		volume.EmptyDir = &apiv1.EmptyDirVolumeSource{}
+	}
+
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "fissile",
+		"fissile.io/compilation":       jobName,
+	}
+	container := apiv1.Container{
+		Name:    "compile",
+		Image:   image,
+		Command: []string{"bash", "-c", script},
+		Env: []apiv1.EnvVar{
+			{Name: "HOST_USERID", Value: "0"},
+			{Name: "HOST_USERGID", Value: "0"},
+		},
+		VolumeMounts: []apiv1.VolumeMount{
+			{Name: "work", MountPath: docker.ContainerInPath, SubPath: subPathPrefix + "in"},
+			{Name: "work", MountPath: docker.ContainerOutPath, SubPath: subPathPrefix + "out"},
+			{Name: "work", MountPath: kubeStatePath, SubPath: subPathPrefix + "state"},
+		},
+	}
+
+	job := &extra.Job{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "extensions/v1beta1",
+			Kind:       "Job",
+		},
+		ObjectMeta: apiv1.ObjectMeta{
+			Name:   jobName,
+			Labels: labels,
+		},
+		Spec: extra.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: apiv1.ObjectMeta{Labels: labels},
+				Spec: apiv1.PodSpec{
+					Containers:    []apiv1.Container{container},
+					Volumes:       []apiv1.Volume{volume},
+					RestartPolicy: apiv1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+	if c.buildCache != nil {
+		// The cache is a claim of its own, shared by the pods
+		podSpec := &job.Spec.Template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+			Name: "build-cache",
+			VolumeSource: apiv1.VolumeSource{
+				PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: c.buildCache.Location},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, apiv1.VolumeMount{
+			Name:      "build-cache",
+			MountPath: ContainerBuildCacheDir,
+			SubPath:   c.buildCacheSubPath(pkg),
+		})
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env,
+			apiv1.EnvVar{Name: buildCacheEnvVar, Value: ContainerBuildCacheDir})
+	}
+	limits := c.packageLimits(pkg)
+	if limits.Memory != 0 || limits.CPUs != 0 {
+		resources := apiv1.ResourceList{}
+		if limits.Memory != 0 {
+			resources[apiv1.ResourceMemory] = *resource.NewQuantity(limits.Memory, resource.BinarySI)
+		}
+		if limits.CPUs != 0 {
+			resources[apiv1.ResourceCPU] = *resource.NewMilliQuantity(int64(math.Round(limits.CPUs*1000)), resource.DecimalSI)
+		}
+		// The pod is scheduled where the resources are available
+		job.Spec.Template.Spec.Containers[0].Resources = apiv1.ResourceRequirements{
+			Limits:   resources,
+			Requests: resources,
+		}
+	}
+	if c.kube.Timeout > 0 {
+		seconds := int64(c.kube.Timeout / time.Second)
+		job.Spec.ActiveDeadlineSeconds = &seconds
+	}
+	return job
+}
+
+// compilePackageInKube compiles a package in a Kubernetes job. The inputs
+// are uploaded into its pod, and the compiled package and the output of the
+// compilation downloaded from it, through kubectl.
+func (c *Compilator) compilePackageInKube(pkg *model.Package) (err error) {
+	containerScriptPath, err := c.prepareCompilation(pkg)
+	if err != nil {
+		return err
+	}
+
+	jobName := c.getPackageJobName(pkg)
+	job := c.newCompilationJob(pkg, jobName, containerScriptPath)
+	manifest, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	// A job left over from an interrupted compilation is replaced
+	if err := c.kubectl(nil, nil, "delete", "job", jobName, "--ignore-not-found"); err != nil {
+		return fmt.Errorf("Error removing the old job compiling package %s: %s", pkg.Name, err)
+	}
+	if err := c.kubectl(bytes.NewReader(manifest), nil, "create", "-f", "-"); err != nil {
+		return fmt.Errorf("Error creating the job compiling package %s: %s", pkg.Name, err)
+	}
+
+	defer func() {
+		if err != nil && c.keepContainer {
+			return
+		}
+		if removeErr := c.kubectl(nil, nil, "delete", "job", jobName, "--ignore-not-found"); removeErr != nil {
+			if err == nil {
+				err = removeErr
+			} else {
+				err = fmt.Errorf("%s. Error removing job %s: %s", err, jobName, removeErr)
+			}
+		}
+	}()
+
+	podName, err := c.waitForCompilationPod(jobName)
+	if err != nil {
+		return fmt.Errorf("Error compiling package %s: %s", pkg.Name, err)
+	}
+
+	// Upload the inputs, then let the compilation start
+	inputs := new(bytes.Buffer)
+	if err := writeTgz(inputs, pkg.GetTargetPackageSourcesDir(c.hostWorkDir)); err != nil {
+		return fmt.Errorf("Error archiving the inputs of package %s: %s", pkg.Name, err)
+	}
+	if err := c.kubectl(inputs, nil, "exec", "-i", podName, "--", "tar", "-xz", "-C", docker.ContainerInPath); err != nil {
+		return fmt.Errorf("Error uploading the inputs of package %s: %s", pkg.Name, err)
+	}
+	if err := c.kubectl(nil, nil, "exec", podName, "--", "touch", kubeReadyFile); err != nil {
+		return fmt.Errorf("Error starting the compilation of package %s: %s", pkg.Name, err)
+	}
+
+	exitCode, err := c.waitForCompilationExit(jobName, podName)
+	if err != nil {
+		return fmt.Errorf("Error compiling package %s: %s", pkg.Name, err)
+	}
+
+	// in-memory buffer of the log, complete once the exit code is written
+	log := new(bytes.Buffer)
+	logWriter := docker.NewFormattingWriter(
+		log,
+		func(line string) string {
+			return color.GreenString("compilation-%s > %s", color.MagentaString("%s", pkg.Name), color.WhiteString("%s", line))
+		},
+	)
+	if logsErr := c.kubectl(nil, logWriter, "logs", podName); logsErr != nil {
+		fmt.Fprintf(logWriter, "%s\n", logsErr)
+	}
+	logWriter.Close()
+
+	if exitCode == 0 {
+		err = c.downloadCompiledPackage(pkg, podName)
+	}
+
+	// Let the pod finish, unless it is kept for debugging
+	if exitCode == 0 || !c.keepContainer {
+		if collectErr := c.kubectl(nil, nil, "exec", podName, "--", "touch", kubeCollectedFile); collectErr != nil && err == nil {
+			err = collectErr
+		}
+	}
+
+	if exitCode != 0 {
+		log.WriteTo(c.output())
+		if c.keepContainer {
+			fmt.Fprintf(c.output(), "Kept job %s of the failed compilation of %s; enter its pod with:\n  %s\n",
+				color.YellowString(jobName), color.MagentaString(pkg.Name),
+				color.CyanString("kubectl exec -it %s -- bash", podName))
+		}
+		return fmt.Errorf("Error - compilation for package %s exited with code %d", pkg.Name, exitCode)
+	}
+	if err != nil {
+		return fmt.Errorf("Error downloading compiled package %s: %s", pkg.Name, err)
+	}
+
+	return c.storeCompiledPackage(pkg)
+}
+
+// waitForCompilationPod waits for the pod of a compilation job to run, and
+// returns its name
+func (c *Compilator) waitForCompilationPod(jobName string) (string, error) {
+	for {
+		output := new(bytes.Buffer)
+		err := c.kubectl(nil, output, "get", "pods",
+			"--selector", "fissile.io/compilation="+jobName,
+			"--output", "jsonpath={range .items[*]}{.metadata.name} {.status.phase}{end}")
+		if err != nil {
+			return "", err
+		}
+
+		fields := strings.Fields(output.String())
+		if len(fields) == 2 {
+			switch fields[1] {
+			case "Running":
+				return fields[0], nil
+			case "Failed", "Succeeded":
+				return "", fmt.Errorf("pod %s of job %s ended before the compilation started", fields[0], jobName)
+			}
+		}
+
+		if err := c.checkCompilationJob(jobName); err != nil {
+			return "", err
+		}
+		time.Sleep(kubePollTime)
+	}
+}
+
+// waitForCompilationExit waits for the compilation in the pod to end, and
+// returns the exit code of the compilation script
+func (c *Compilator) waitForCompilationExit(jobName, podName string) (int, error) {
+	for {
+		output := new(bytes.Buffer)
+		err := c.kubectl(nil, output, "exec", podName, "--", "cat", kubeExitCodeFile)
+		if err == nil {
+			var exitCode int
+			if _, err := fmt.Sscanf(output.String(), "%d", &exitCode); err != nil {
+				return 0, fmt.Errorf("invalid exit code %q in pod %s", output.String(), podName)
+			}
+			return exitCode, nil
+		}
+
+		// The file is missing while the compilation runs; anything else
+		// means the pod is gone
+		if err := c.checkCompilationJob(jobName); err != nil {
+			return 0, err
+		}
+		time.Sleep(kubePollTime)
+	}
+}
+
+// checkCompilationJob reports an error if a compilation job failed, e.g.
+// because it ran into its deadline
+func (c *Compilator) checkCompilationJob(jobName string) error {
+	output := new(bytes.Buffer)
+	err := c.kubectl(nil, output, "get", "job", jobName,
+		"--output", `jsonpath={.status.conditions[?(@.type=="Failed")].message}`)
+	if err != nil {
+		return err
+	}
+	if message := strings.TrimSpace(output.String()); message != "" {
+		return fmt.Errorf("job %s failed: %s", jobName, message)
+	}
+	return nil
+}
+
+// downloadCompiledPackage copies the compiled package out of the pod into
+// the temporary directory of the package in the work directory
+func (c *Compilator) downloadCompiledPackage(pkg *model.Package, podName string) error {
+	archive, err := ioutil.TempFile(filepath.Join(c.hostWorkDir, pkg.Fingerprint), "compiled-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+
+	err = c.kubectl(nil, archive, "exec", podName, "--", "tar", "-cz", "-C", docker.ContainerOutPath, ".")
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	compiledTempDir := pkg.GetPackageCompiledTempDir(c.hostWorkDir)
+	if err := os.RemoveAll(compiledTempDir); err != nil {
+		return err
+	}
+	return extractor.NewTgz().Extract(archive.Name(), compiledTempDir)
+}
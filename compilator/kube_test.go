@@ -0,0 +1,195 @@
+package compilator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/scripts/compilation"
+	"github.com/hpcloud/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	extra "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+// fakeKubectl answers the kubectl commands of a compilation in Kubernetes
+type fakeKubectl struct {
+	commands []string
+	job      []byte
+	compiled []byte // Archive of the compiled package
+	exitCode string
+}
+
+func (k *fakeKubectl) run(args []string, stdin io.Reader, stdout io.Writer) error {
+	command := strings.Join(args, " ")
+	k.commands = append(k.commands, command)
+	command = " " + command
+
+	switch {
+	case strings.Contains(command, " create -f -"):
+		k.job, _ = ioutil.ReadAll(stdin)
+	case strings.Contains(command, " get pods "):
+		fmt.Fprint(stdout, "compile-pod Running")
+	case strings.HasSuffix(command, "cat "+kubeExitCodeFile):
+		fmt.Fprintln(stdout, k.exitCode)
+	case strings.Contains(command, " tar -cz "):
+		stdout.Write(k.compiled)
+	case strings.Contains(command, " logs "):
+		fmt.Fprintln(stdout, "compiling")
+	}
+	return nil
+}
+
+func TestCompilePackageInKube(t *testing.T) {
+	assert := assert.New(t)
+
+	savedKubectl, savedPollTime := runKubectl, kubePollTime
+	defer func() { runKubectl, kubePollTime = savedKubectl, savedPollTime }()
+	kubePollTime = time.Millisecond
+
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compilationWorkDir)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+	ntpReleasePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	ntpReleasePathBoshCache := filepath.Join(ntpReleasePath, "bosh-cache")
+	release, err := model.NewDevRelease(ntpReleasePath, "", "", ntpReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+	pkg := release.Packages[0]
+
+	compiledDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compiledDir)
+	assert.NoError(ioutil.WriteFile(filepath.Join(compiledDir, "ntpd"), []byte("binary"), 0755))
+	compiled := new(bytes.Buffer)
+	assert.NoError(writeTgz(compiled, compiledDir))
+
+	kubectl := &fakeKubectl{compiled: compiled.Bytes(), exitCode: "0"}
+	runKubectl = kubectl.run
+
+	comp, err := NewKubeCompilator(compilationWorkDir, "", "fissile-test", compilation.FakeBase, "3.14.15",
+		KubeOptions{Namespace: "build", Volume: "compilation", Timeout: time.Hour}, false, ui)
+	if !assert.NoError(err) {
+		return
+	}
+	comp.SetLimits(&CompileLimits{ResourceLimits: ResourceLimits{Memory: 4 << 30, CPUs: 1.5}})
+	comp.SetBuildCache(&BuildCache{Location: "build-cache", PerPackage: true})
+
+	err = comp.compilePackageInKube(pkg)
+	if !assert.NoError(err) {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(pkg.GetPackageCompiledDir(compilationWorkDir), "ntpd"))
+	assert.NoError(err)
+	assert.Equal("binary", string(contents))
+
+	jobName := comp.getPackageJobName(pkg)
+	var job extra.Job
+	if assert.NoError(json.Unmarshal(kubectl.job, &job)) {
+		assert.Equal(jobName, job.Name)
+		podSpec := job.Spec.Template.Spec
+		assert.Equal("compilation", podSpec.Volumes[0].PersistentVolumeClaim.ClaimName)
+		assert.Equal(comp.BaseImageName(), podSpec.Containers[0].Image)
+		assert.Equal(jobName+"/out", podSpec.Containers[0].VolumeMounts[1].SubPath)
+		if assert.NotNil(job.Spec.ActiveDeadlineSeconds) {
+			assert.Equal(int64(3600), *job.Spec.ActiveDeadlineSeconds)
+		}
+		limits := podSpec.Containers[0].Resources.Limits
+		memory, cpus := limits[apiv1.ResourceMemory], limits[apiv1.ResourceCPU]
+		assert.Equal("4Gi", memory.String())
+		assert.Equal("1500m", cpus.String())
+		assert.Equal("build-cache", podSpec.Volumes[1].PersistentVolumeClaim.ClaimName)
+		assert.Equal(apiv1.VolumeMount{Name: "build-cache", MountPath: ContainerBuildCacheDir, SubPath: pkg.Name},
+			podSpec.Containers[0].VolumeMounts[3])
+		assert.Contains(podSpec.Containers[0].Env, apiv1.EnvVar{Name: buildCacheEnvVar, Value: ContainerBuildCacheDir})
+	}
+
+	for _, command := range kubectl.commands {
+		assert.True(strings.HasPrefix(command, "--namespace build "), command)
+	}
+	assert.Equal("--namespace build delete job "+jobName+" --ignore-not-found",
+		kubectl.commands[len(kubectl.commands)-1])
+}
+
+func TestCompilePackageInKubeFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	savedKubectl, savedPollTime := runKubectl, kubePollTime
+	defer func() { runKubectl, kubePollTime = savedKubectl, savedPollTime }()
+	kubePollTime = time.Millisecond
+
+	compilationWorkDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compilationWorkDir)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+	ntpReleasePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	ntpReleasePathBoshCache := filepath.Join(ntpReleasePath, "bosh-cache")
+	release, err := model.NewDevRelease(ntpReleasePath, "", "", ntpReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	kubectl := &fakeKubectl{exitCode: "2"}
+	runKubectl = kubectl.run
+
+	comp, err := NewKubeCompilator(compilationWorkDir, "", "fissile-test", compilation.FakeBase, "3.14.15",
+		KubeOptions{}, true, ui)
+	if !assert.NoError(err) {
+		return
+	}
+	output := &bytes.Buffer{}
+	comp.SetProgress(NewProgress(output, false))
+
+	err = comp.compilePackageInKube(release.Packages[0])
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "exited with code 2")
+	}
+	deletions := 0
+	for _, command := range kubectl.commands {
+		assert.NotContains(command, " tar -cz ", "Failed compilations should not be downloaded")
+		assert.NotContains(command, kubeCollectedFile, "Pods of failed compilations should be kept running")
+		if strings.HasPrefix(command, "delete job ") {
+			deletions++
+		}
+	}
+	assert.Equal(1, deletions, "Jobs of failed compilations should be kept when asked to")
+	assert.Contains(output.String(), "compiling")
+	assert.Contains(output.String(), "Kept job "+comp.getPackageJobName(release.Packages[0]))
+}
+
+func TestGetPackageJobName(t *testing.T) {
+	assert := assert.New(t)
+
+	comp := &Compilator{fissileVersion: "3.14.15"}
+	pkg := &model.Package{Name: strings.Repeat("Very_Long.Package-", 5), Fingerprint: "abc"}
+
+	name := comp.getPackageJobName(pkg)
+	assert.True(len(name) <= 52, name)
+	assert.Regexp(regexp.MustCompile(`^compile-very-long-package-[a-z0-9-]*[a-z0-9]-[0-9a-f]{10}$`), name)
+
+	other := &model.Package{Name: pkg.Name, Fingerprint: "def"}
+	assert.NotEqual(name, comp.getPackageJobName(other))
+}
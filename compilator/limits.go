@@ -0,0 +1,126 @@
+package compilator
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/docker/go-units"
+	"gopkg.in/yaml.v2"
+)
+
+// ResourceLimits bounds the resources the compilation of a package may use
+type ResourceLimits struct {
+	Memory int64   // In bytes; no limit if 0
+	CPUs   float64 // No limit if 0
+}
+
+// CompileLimits holds the resource limits of compilations: the limits of all
+// packages, and those of some packages, by <release>/<package> or by package
+// name
+type CompileLimits struct {
+	ResourceLimits
+	Packages map[string]ResourceLimits
+}
+
+// compileLimitsFile is the format of the files compile limits are read from,
+// as in:
+//
+//	memory: 2g
+//	cpus: 1
+//	packages:
+//	  ruby-2.5: {memory: 6g}
+//	  cf/uaa: {memory: 4g, cpus: 2}
+type compileLimitsFile struct {
+	Memory   string                        `yaml:"memory"`
+	CPUs     float64                       `yaml:"cpus"`
+	Packages map[string]resourceLimitsFile `yaml:"packages"`
+}
+
+type resourceLimitsFile struct {
+	Memory string  `yaml:"memory"`
+	CPUs   float64 `yaml:"cpus"`
+}
+
+// LoadCompileLimits reads compile limits from a YAML file
+func LoadCompileLimits(path string) (*CompileLimits, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file compileLimitsFile
+	if err := yaml.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("Error loading compile limits %s: %s", path, err)
+	}
+
+	limits := &CompileLimits{Packages: make(map[string]ResourceLimits)}
+	defaults := resourceLimitsFile{Memory: file.Memory, CPUs: file.CPUs}
+	if limits.ResourceLimits, err = defaults.parse(); err != nil {
+		return nil, fmt.Errorf("Error loading compile limits %s: %s", path, err)
+	}
+	for name, packageFile := range file.Packages {
+		if limits.Packages[name], err = packageFile.parse(); err != nil {
+			return nil, fmt.Errorf("Error loading compile limits %s: package %s: %s", path, name, err)
+		}
+	}
+	return limits, nil
+}
+
+func (f resourceLimitsFile) parse() (ResourceLimits, error) {
+	limits := ResourceLimits{CPUs: f.CPUs}
+	if f.CPUs < 0 {
+		return limits, fmt.Errorf("Invalid number of CPUs %v", f.CPUs)
+	}
+	if f.Memory != "" {
+		memory, err := ParseMemoryLimit(f.Memory)
+		if err != nil {
+			return limits, err
+		}
+		limits.Memory = memory
+	}
+	return limits, nil
+}
+
+// ParseMemoryLimit parses an amount of memory, as in 512m or 4g
+func ParseMemoryLimit(memory string) (int64, error) {
+	bytes, err := units.RAMInBytes(memory)
+	if err != nil || bytes < 0 {
+		return 0, fmt.Errorf("Invalid amount of memory %s", memory)
+	}
+	return bytes, nil
+}
+
+// For returns the resource limits of the compilation of a package: those set
+// for <release>/<package>, else for the package name, else for all packages,
+// limit by limit
+func (l *CompileLimits) For(pkg *model.Package) ResourceLimits {
+	limits := l.ResourceLimits
+	for _, name := range []string{pkg.Name, fmt.Sprintf("%s/%s", pkg.Release.Name, pkg.Name)} {
+		override, ok := l.Packages[name]
+		if !ok {
+			continue
+		}
+		if override.Memory != 0 {
+			limits.Memory = override.Memory
+		}
+		if override.CPUs != 0 {
+			limits.CPUs = override.CPUs
+		}
+	}
+	return limits
+}
+
+// SetLimits sets the resource limits of the containers compiling packages
+func (c *Compilator) SetLimits(limits *CompileLimits) {
+	c.limits = limits
+}
+
+// packageLimits returns the resource limits of the compilation of a package
+func (c *Compilator) packageLimits(pkg *model.Package) ResourceLimits {
+	if c.limits == nil {
+		return ResourceLimits{}
+	}
+	return c.limits.For(pkg)
+}
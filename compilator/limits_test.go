@@ -0,0 +1,71 @@
+package compilator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCompileLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "limits.yml")
+	assert.NoError(ioutil.WriteFile(path, []byte(`
+memory: 2g
+cpus: 1
+packages:
+  ruby-2.5: {memory: 6g}
+  cf/uaa: {memory: 512m, cpus: 2.5}
+  uaa: {cpus: 3}
+`), 0644))
+
+	limits, err := LoadCompileLimits(path)
+	if !assert.NoError(err) {
+		return
+	}
+
+	release := &model.Release{Name: "cf"}
+	for name, expected := range map[string]ResourceLimits{
+		"nats":     {Memory: 2 << 30, CPUs: 1},
+		"ruby-2.5": {Memory: 6 << 30, CPUs: 1},
+		"uaa":      {Memory: 512 << 20, CPUs: 2.5},
+	} {
+		assert.Equal(expected, limits.For(&model.Package{Name: name, Release: release}), name)
+	}
+
+	other := &model.Package{Name: "uaa", Release: &model.Release{Name: "other"}}
+	assert.Equal(ResourceLimits{Memory: 2 << 30, CPUs: 3}, limits.For(other))
+}
+
+func TestLoadCompileLimitsInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "limits.yml")
+	assert.NoError(ioutil.WriteFile(path, []byte("packages:\n  nats: {memory: lots}\n"), 0644))
+
+	_, err = LoadCompileLimits(path)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "package nats: Invalid amount of memory lots")
+	}
+
+	_, err = LoadCompileLimits(filepath.Join(dir, "missing.yml"))
+	assert.Error(err)
+}
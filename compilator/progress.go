@@ -0,0 +1,308 @@
+package compilator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// PackageState is the state of a package in a compilation run
+type PackageState string
+
+// The states of packages in a compilation run
+const (
+	PackageQueued    PackageState = "queued"
+	PackageWaiting   PackageState = "waiting" // For its dependencies
+	PackageCompiling PackageState = "compiling"
+	PackageCached    PackageState = "cached"
+	PackageDone      PackageState = "done"
+	PackageFailed    PackageState = "failed"
+	PackageKilled    PackageState = "killed"
+)
+
+// finished returns whether the state of a package doesn't change anymore
+func (s PackageState) finished() bool {
+	switch s {
+	case PackageCached, PackageDone, PackageFailed, PackageKilled:
+		return true
+	}
+	return false
+}
+
+// colored returns the state, colored after its outcome
+func (s PackageState) colored() string {
+	switch s {
+	case PackageCached, PackageDone:
+		return color.GreenString("%s", s)
+	case PackageFailed, PackageKilled:
+		return color.RedString("%s", s)
+	case PackageCompiling:
+		return color.YellowString("%s", s)
+	}
+	return string(s)
+}
+
+// padded returns the colored state, padded to the width; escapes have no
+// width, so the padding is added before coloring
+func (s PackageState) padded(width int) string {
+	return strings.Replace(fmt.Sprintf("%-*s", width, s), string(s), s.colored(), 1)
+}
+
+// Progress reports the states of the packages of a compilation run, along
+// with the output of the compilation, e.g. the logs of failed packages
+type Progress interface {
+	io.Writer
+	// Update records the new state of a package, with details such as the
+	// dependency it waits for, or the error it failed with
+	Update(pkg *model.Package, state PackageState, detail string)
+	// Close ends the report once all packages are finished
+	Close() error
+}
+
+// progressTick is how often the live status table is drawn again
+const progressTick = time.Second
+
+// NewProgress returns the progress report of a compilation run written to
+// the output: a live status table of the packages in progress on terminals,
+// with packages listed once finished, or lines prefixed with the package
+// otherwise. The output has to be line-buffered when several packages
+// compile at once.
+func NewProgress(output io.Writer, terminal bool) Progress {
+	if !terminal {
+		return &lineProgress{output: output, started: make(map[string]time.Time), now: time.Now}
+	}
+
+	progress := &tableProgress{
+		output: output,
+		rows:   make(map[string]*progressRow),
+		counts: make(map[PackageState]int),
+		now:    time.Now,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go progress.tick()
+	return progress
+}
+
+// packageName returns the name of a package in progress reports
+func packageName(pkg *model.Package) string {
+	return fmt.Sprintf("%s/%s", pkg.Release.Name, pkg.Name)
+}
+
+// formatDuration rounds a duration for progress reports
+func formatDuration(duration time.Duration) string {
+	return (duration - duration%time.Second).String()
+}
+
+// lineProgress writes a line for each change of state, prefixed with the
+// package
+type lineProgress struct {
+	sync.Mutex
+	output  io.Writer
+	started map[string]time.Time // When packages started to compile, by name
+	now     func() time.Time
+}
+
+func (p *lineProgress) Write(data []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.output.Write(data)
+}
+
+func (p *lineProgress) Update(pkg *model.Package, state PackageState, detail string) {
+	p.Lock()
+	defer p.Unlock()
+
+	// Queued packages are only shown once they start
+	if state == PackageQueued {
+		return
+	}
+
+	name := packageName(pkg)
+	if state == PackageCompiling {
+		p.started[name] = p.now()
+	}
+
+	line := fmt.Sprintf("[%s] %s", color.MagentaString(name), state.colored())
+	if detail != "" {
+		line = fmt.Sprintf("%s - %s", line, detail)
+	}
+	if started, ok := p.started[name]; ok && state.finished() {
+		line = fmt.Sprintf("%s (%s)", line, formatDuration(p.now().Sub(started)))
+	}
+	fmt.Fprintln(p.output, line)
+}
+
+func (p *lineProgress) Close() error {
+	return nil
+}
+
+// progressRow is a package in the live status table
+type progressRow struct {
+	name    string
+	state   PackageState
+	detail  string
+	started time.Time // When the package started to compile; zero before
+	order   int
+}
+
+// tableProgress draws the packages in progress as a table at the bottom of
+// the terminal, below finished packages and other output
+type tableProgress struct {
+	sync.Mutex
+	output  io.Writer
+	rows    map[string]*progressRow // Packages not finished, by name
+	counts  map[PackageState]int    // Packages per state
+	pending bytes.Buffer            // Output waiting to be written above the table
+	drawn   int                     // Lines of the table drawn last
+	added   int                     // Packages added to the table so far, for their order
+	now     func() time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (p *tableProgress) Write(data []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.pending.Write(data)
+	p.draw()
+	return len(data), nil
+}
+
+func (p *tableProgress) Update(pkg *model.Package, state PackageState, detail string) {
+	p.Lock()
+	defer p.Unlock()
+
+	name := packageName(pkg)
+	row, ok := p.rows[name]
+	if !ok {
+		row = &progressRow{name: name, order: p.added}
+		p.rows[name] = row
+		p.added++
+	} else {
+		p.counts[row.state]--
+	}
+	p.counts[state]++
+
+	row.state = state
+	row.detail = detail
+	if state == PackageCompiling {
+		row.started = p.now()
+	}
+
+	if state.finished() {
+		delete(p.rows, name)
+		line := fmt.Sprintf("%s %s", state.padded(9), color.MagentaString(name))
+		if !row.started.IsZero() {
+			line = fmt.Sprintf("%s (%s)", line, formatDuration(p.now().Sub(row.started)))
+		}
+		if detail != "" {
+			line = fmt.Sprintf("%s - %s", line, detail)
+		}
+		fmt.Fprintln(&p.pending, line)
+	}
+
+	p.draw()
+}
+
+func (p *tableProgress) Close() error {
+	close(p.stop)
+	<-p.done
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Only the summary is left once all packages are finished
+	p.draw()
+	return nil
+}
+
+// tick draws the table again regularly, for the durations to progress
+func (p *tableProgress) tick() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(progressTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Lock()
+			p.draw()
+			p.Unlock()
+		}
+	}
+}
+
+// draw replaces the table with the pending output and the current table
+func (p *tableProgress) draw() {
+	var buf bytes.Buffer
+
+	if p.drawn > 0 {
+		// Move to the start of the table and clear it
+		fmt.Fprintf(&buf, "\x1b[%dA\x1b[J", p.drawn)
+	}
+
+	// Incomplete lines are kept until they are complete
+	if end := bytes.LastIndexByte(p.pending.Bytes(), '\n'); end >= 0 {
+		buf.Write(p.pending.Next(end + 1))
+	}
+
+	table := p.table()
+	buf.WriteString(table)
+	p.drawn = strings.Count(table, "\n")
+
+	p.output.Write(buf.Bytes())
+}
+
+// table returns the lines of the status table: the packages in progress,
+// and a summary of the states of all packages
+func (p *tableProgress) table() string {
+	rows := make([]*progressRow, 0, len(p.rows))
+	width := len("PACKAGE")
+	for _, row := range p.rows {
+		if row.state == PackageQueued {
+			continue
+		}
+		rows = append(rows, row)
+		if len(row.name) > width {
+			width = len(row.name)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].order < rows[j].order })
+
+	var buf bytes.Buffer
+	if len(rows) > 0 {
+		fmt.Fprintf(&buf, "%-*s  %-9s  %-8s  %s\n", width, "PACKAGE", "STATE", "DURATION", "DETAIL")
+		for _, row := range rows {
+			duration := ""
+			if !row.started.IsZero() {
+				duration = formatDuration(p.now().Sub(row.started))
+			}
+			line := fmt.Sprintf("%-*s  %s  %-8s  %s", width, row.name, row.state.padded(9), duration, row.detail)
+			fmt.Fprintf(&buf, "%s\n", strings.TrimRight(line, " "))
+		}
+	}
+
+	var summary []string
+	for _, state := range []PackageState{PackageDone, PackageCached, PackageCompiling, PackageWaiting, PackageQueued, PackageFailed, PackageKilled} {
+		if p.counts[state] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", p.counts[state], state))
+		}
+	}
+	if len(summary) > 0 {
+		fmt.Fprintf(&buf, "%s\n", strings.Join(summary, ", "))
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,96 @@
+package compilator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+// lastDraw returns what the table progress drew last
+func lastDraw(output *bytes.Buffer) string {
+	draws := strings.Split(output.String(), "\x1b[J")
+	return draws[len(draws)-1]
+}
+
+func TestLineProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	release := &model.Release{Name: "tor"}
+	libevent := &model.Package{Name: "libevent", Release: release}
+	tor := &model.Package{Name: "tor", Release: release}
+
+	output := &bytes.Buffer{}
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	progress := &lineProgress{output: output, started: make(map[string]time.Time), now: func() time.Time { return now }}
+
+	progress.Update(tor, PackageQueued, "")
+	progress.Update(tor, PackageWaiting, "libevent")
+	progress.Update(libevent, PackageCompiling, "")
+	now = now.Add(90 * time.Second)
+	progress.Update(libevent, PackageDone, "")
+	fmt.Fprintln(progress, "compilation-tor > make: *** [all] Error 2")
+	progress.Update(tor, PackageFailed, "exited with code 2")
+	assert.NoError(progress.Close())
+
+	assert.Equal(`[tor/tor] waiting - libevent
+[tor/libevent] compiling
+[tor/libevent] done (1m30s)
+compilation-tor > make: *** [all] Error 2
+[tor/tor] failed - exited with code 2
+`, output.String())
+}
+
+func TestTableProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	release := &model.Release{Name: "tor"}
+	libevent := &model.Package{Name: "libevent", Release: release}
+	tor := &model.Package{Name: "tor", Release: release}
+
+	output := &bytes.Buffer{}
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	progress := NewProgress(output, true).(*tableProgress)
+	progress.now = func() time.Time { return now }
+
+	progress.Update(libevent, PackageQueued, "")
+	progress.Update(tor, PackageQueued, "")
+	assert.Equal("2 queued\n", lastDraw(output))
+
+	output.Reset()
+	progress.Update(libevent, PackageCompiling, "")
+	now = now.Add(3 * time.Second)
+	progress.Update(tor, PackageWaiting, "libevent")
+	assert.Equal(`PACKAGE       STATE      DURATION  DETAIL
+tor/libevent  compiling  3s
+tor/tor       waiting              libevent
+1 compiling, 1 waiting
+`, lastDraw(output))
+
+	output.Reset()
+	fmt.Fprint(progress, "incomplete ")
+	fmt.Fprint(progress, "line\n")
+	progress.Update(libevent, PackageDone, "")
+	assert.Contains(output.String(), "incomplete line\n")
+	assert.Contains(output.String(), "done      tor/libevent (3s)\n")
+
+	output.Reset()
+	progress.Update(tor, PackageCached, "")
+	assert.NoError(progress.Close())
+	assert.Contains(output.String(), "cached    tor/tor\n")
+	assert.Equal("1 done, 1 cached\n", lastDraw(output))
+}
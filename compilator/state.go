@@ -0,0 +1,138 @@
+package compilator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// Compilation states of a package, recorded next to it in the work directory
+// so that an interrupted run can be resumed
+const (
+	packageStatePending   = "pending"   // Queued for compilation
+	packageStateCompiling = "compiling" // Its outputs may be partial
+	packageStateDone      = "done"      // Compiled; its checksum is recorded
+)
+
+// packageState is the recorded compilation state of a package
+type packageState struct {
+	State    string `json:"state"`
+	Key      string `json:"key"`                // The compilation key, see compilationKey
+	Checksum string `json:"checksum,omitempty"` // Of the compiled package, once done
+}
+
+// readPackageState returns the recorded compilation state of a package, or
+// nil if none is
+func (c *Compilator) readPackageState(pkg *model.Package) (*packageState, error) {
+	contents, err := ioutil.ReadFile(pkg.GetPackageCompilationStateFile(c.hostWorkDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state packageState
+	if err := json.Unmarshal(contents, &state); err != nil {
+		// A state file cut short by an interruption is as good as none
+		return &packageState{}, nil
+	}
+	return &state, nil
+}
+
+// writePackageState records the compilation state of a package. The file is
+// replaced whole, so that an interruption leaves either state behind.
+func (c *Compilator) writePackageState(pkg *model.Package, state, checksum string) error {
+	contents, err := json.Marshal(&packageState{
+		State:    state,
+		Key:      compilationKey(pkg),
+		Checksum: checksum,
+	})
+	if err != nil {
+		return err
+	}
+
+	statePath := pkg.GetPackageCompilationStateFile(c.hostWorkDir)
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(statePath+".tmp", contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(statePath+".tmp", statePath)
+}
+
+// discardPartialCompilation removes what an interrupted compilation of a
+// package left behind: its inputs, its temporary output, and its compiled
+// package unless that was completely stored
+func (c *Compilator) discardPartialCompilation(pkg *model.Package) error {
+	state, err := c.readPackageState(pkg)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{
+		pkg.GetTargetPackageSourcesDir(c.hostWorkDir),
+		pkg.GetPackageCompiledTempDir(c.hostWorkDir),
+	}
+	if state != nil && state.State != packageStateDone {
+		c.ui.Println(color.YellowString("Discarding the partial compilation of %s/%s", pkg.Release.Name, pkg.Name))
+		paths = append(paths, pkg.GetPackageCompiledDir(c.hostWorkDir))
+	}
+
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Error discarding the partial compilation of %s: %s", pkg.Name, err)
+		}
+	}
+	return nil
+}
+
+// dirChecksum returns a checksum of the names, types, permissions and
+// contents of the files in a directory, and of the targets of its links
+func dirChecksum(dir string) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s %s\n", filepath.ToSlash(relPath), info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "-> %s\n", link)
+		case info.Mode().IsRegular():
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(hash, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,105 @@
+package compilator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompilationResume(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	releases := genTestCase("consul>go-1.4", "go-1.4")
+	consul, golang := releases[0].Packages[0], releases[0].Packages[1]
+	consul.Dependencies = model.Packages{golang}
+
+	compiled := make(map[string]int)
+	newCompilator := func() *Compilator {
+		c, err := NewDockerCompilator(nil, workDir, "", "", "", "", false, ui)
+		assert.NoError(err)
+		c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+			compiled[pkg.Name]++
+			return compileIntoWorkDir(c, pkg)
+		}
+		return c
+	}
+
+	if !assert.NoError(newCompilator().Compile(1, releases, nil)) {
+		return
+	}
+	assert.Equal(map[string]int{"consul": 1, "go-1.4": 1}, compiled)
+
+	c := newCompilator()
+	state, err := c.readPackageState(consul)
+	if assert.NoError(err) && assert.NotNil(state) {
+		assert.Equal(packageStateDone, state.State)
+		assert.Equal(compilationKey(consul), state.Key)
+		assert.NotEmpty(state.Checksum)
+	}
+
+	// An interruption while compiling consul leaves partial outputs behind
+	assert.NoError(c.writePackageState(consul, packageStateCompiling, ""))
+	leftover := filepath.Join(consul.GetTargetPackageSourcesDir(workDir), "leftover")
+	assert.NoError(os.MkdirAll(filepath.Dir(leftover), 0755))
+	assert.NoError(ioutil.WriteFile(leftover, []byte{}, 0644))
+
+	if !assert.NoError(c.Compile(1, releases, nil)) {
+		return
+	}
+	assert.Equal(map[string]int{"consul": 2, "go-1.4": 1}, compiled,
+		"Only the package being compiled when interrupted should be compiled again")
+	_, err = os.Stat(leftover)
+	assert.True(os.IsNotExist(err), "Partial outputs should be discarded")
+
+	// A compiled package altered since is compiled again
+	binary := filepath.Join(golang.GetPackageCompiledDir(workDir), "bin", golang.Name)
+	assert.NoError(ioutil.WriteFile(binary, []byte("truncated"), 0755))
+
+	if !assert.NoError(newCompilator().Compile(1, releases, nil)) {
+		return
+	}
+	assert.Equal(map[string]int{"consul": 2, "go-1.4": 2}, compiled)
+	contents, err := ioutil.ReadFile(binary)
+	assert.NoError(err)
+	assert.Equal(golang.Fingerprint, string(contents))
+}
+
+func TestDirChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "file"), []byte("contents"), 0644))
+	first, err := dirChecksum(dir)
+	assert.NoError(err)
+
+	again, err := dirChecksum(dir)
+	assert.NoError(err)
+	assert.Equal(first, again)
+
+	assert.NoError(os.Chmod(filepath.Join(dir, "file"), 0755))
+	changed, err := dirChecksum(dir)
+	assert.NoError(err)
+	assert.NotEqual(first, changed, "Permissions should be part of the checksum")
+
+	assert.NoError(os.Symlink("file", filepath.Join(dir, "link")))
+	linked, err := dirChecksum(dir)
+	assert.NoError(err)
+	assert.NotEqual(changed, linked, "Links should be part of the checksum")
+}
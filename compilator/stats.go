@@ -0,0 +1,245 @@
+package compilator
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// Whether packages were found in the package cache
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+	CacheNone = "none" // No package cache is used, or the package is compiled already
+)
+
+// PackageStats describes what became of a package in a compilation run, and
+// how long it took
+type PackageStats struct {
+	Package        string       `json:"package" yaml:"package"` // <release>/<package>
+	Fingerprint    string       `json:"fingerprint" yaml:"fingerprint"`
+	State          PackageState `json:"state" yaml:"state"`   // Done, cached, failed or killed, or compiled already
+	Worker         int          `json:"worker" yaml:"worker"` // From 1; 0 for packages compiled already
+	Cache          string       `json:"cache" yaml:"cache"`
+	WaitSeconds    float64      `json:"wait_seconds" yaml:"wait_seconds"` // For the dependencies
+	CompileSeconds float64      `json:"compile_seconds" yaml:"compile_seconds"`
+	WallSeconds    float64      `json:"wall_seconds" yaml:"wall_seconds"`
+	Size           int64        `json:"size" yaml:"size"` // Of the compiled package, in bytes
+}
+
+// CompilationTotals sums up the statistics of the packages of a run
+type CompilationTotals struct {
+	Packages       int     `json:"packages" yaml:"packages"`
+	Compiled       int     `json:"compiled" yaml:"compiled"`
+	Cached         int     `json:"cached" yaml:"cached"`
+	CompiledBefore int     `json:"compiled_before" yaml:"compiled_before"`
+	Failed         int     `json:"failed" yaml:"failed"`
+	CacheHits      int     `json:"cache_hits" yaml:"cache_hits"`
+	CacheMisses    int     `json:"cache_misses" yaml:"cache_misses"`
+	CompileSeconds float64 `json:"compile_seconds" yaml:"compile_seconds"` // Summed over all workers
+	WallSeconds    float64 `json:"wall_seconds" yaml:"wall_seconds"`       // Of the whole run
+	Size           int64   `json:"size" yaml:"size"`
+}
+
+// CompilationStats describes a compilation run, package by package
+type CompilationStats struct {
+	Platform string             `json:"platform" yaml:"platform"`
+	Workers  int                `json:"workers" yaml:"workers"`
+	Packages []*PackageStats    `json:"packages" yaml:"packages"`
+	Totals   *CompilationTotals `json:"totals" yaml:"totals"`
+}
+
+// PackageCompiledBefore is the state of packages skipped as they were
+// compiled by an earlier run; it only appears in statistics
+const PackageCompiledBefore PackageState = "compiled-before"
+
+// statsRecorder collects the statistics of a compilation run. The workers
+// record their packages concurrently.
+type statsRecorder struct {
+	sync.Mutex
+	started  time.Time
+	ended    time.Time
+	packages map[string]*PackageStats // By fingerprint
+	workers  chan int                 // Numbers of the idle workers
+	now      func() time.Time
+}
+
+func newStatsRecorder(workerCount int) *statsRecorder {
+	// As the workers do, see workerLib.NewWorker
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	recorder := &statsRecorder{
+		packages: make(map[string]*PackageStats),
+		workers:  make(chan int, workerCount),
+		now:      time.Now,
+	}
+	for worker := 1; worker <= workerCount; worker++ {
+		recorder.workers <- worker
+	}
+	recorder.started = recorder.now()
+	return recorder
+}
+
+// packageRun tracks the statistics of a package while a worker handles it
+type packageRun struct {
+	recorder *statsRecorder
+	pkg      *model.Package
+	stats    PackageStats
+	started  time.Time
+	compiles time.Time // When the compilation started, once it did
+}
+
+// start takes an idle worker for a package
+func (r *statsRecorder) start(pkg *model.Package) *packageRun {
+	return &packageRun{
+		recorder: r,
+		pkg:      pkg,
+		stats: PackageStats{
+			Package:     packageName(pkg),
+			Fingerprint: pkg.Fingerprint,
+			Worker:      <-r.workers,
+			Cache:       CacheNone,
+		},
+		started: r.now(),
+	}
+}
+
+// cacheLookup records whether the package was found in the package cache
+func (p *packageRun) cacheLookup(found bool) {
+	p.stats.Cache = CacheMiss
+	if found {
+		p.stats.Cache = CacheHit
+	}
+}
+
+// compiling records that the dependencies of the package are ready, and its
+// compilation starts
+func (p *packageRun) compiling() {
+	p.compiles = p.recorder.now()
+	p.stats.WaitSeconds = p.compiles.Sub(p.started).Seconds()
+}
+
+// end records the final state of the package, and the size of its compiled
+// package, if any; the worker is idle again
+func (p *packageRun) end(state PackageState, compiledDir string) {
+	now := p.recorder.now()
+	p.stats.State = state
+	p.stats.WallSeconds = now.Sub(p.started).Seconds()
+	if !p.compiles.IsZero() {
+		p.stats.CompileSeconds = now.Sub(p.compiles).Seconds()
+	}
+	if compiledDir != "" {
+		// The size is only informative
+		p.stats.Size, _ = dirSize(compiledDir)
+	}
+
+	p.recorder.Lock()
+	p.recorder.packages[p.pkg.Fingerprint] = &p.stats
+	p.recorder.Unlock()
+	p.recorder.workers <- p.stats.Worker
+}
+
+// compiledBefore records the packages skipped as they were compiled by an
+// earlier run: those gathered which don't remain to be compiled
+func (r *statsRecorder) compiledBefore(gathered, remaining model.Packages, workDir string) {
+	pending := make(map[string]bool)
+	for _, pkg := range remaining {
+		pending[pkg.Fingerprint] = true
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	for _, pkg := range gathered {
+		if pending[pkg.Fingerprint] {
+			continue
+		}
+		size, _ := dirSize(pkg.GetPackageCompiledDir(workDir))
+		r.packages[pkg.Fingerprint] = &PackageStats{
+			Package:     packageName(pkg),
+			Fingerprint: pkg.Fingerprint,
+			State:       PackageCompiledBefore,
+			Cache:       CacheNone,
+			Size:        size,
+		}
+	}
+}
+
+// end records the end of the run
+func (r *statsRecorder) end() {
+	r.Lock()
+	defer r.Unlock()
+	r.ended = r.now()
+}
+
+// Stats returns the statistics of the last compilation run, or nil before
+// any. The packages are sorted by name.
+func (c *Compilator) Stats() *CompilationStats {
+	if c.stats == nil {
+		return nil
+	}
+	r := c.stats
+	r.Lock()
+	defer r.Unlock()
+
+	ended := r.ended
+	if ended.IsZero() {
+		ended = r.now()
+	}
+	result := &CompilationStats{
+		Platform: c.platform.String(),
+		Workers:  cap(r.workers),
+		Packages: make([]*PackageStats, 0, len(r.packages)),
+		Totals:   &CompilationTotals{WallSeconds: ended.Sub(r.started).Seconds()},
+	}
+
+	totals := result.Totals
+	for _, stats := range r.packages {
+		copied := *stats
+		result.Packages = append(result.Packages, &copied)
+
+		totals.Packages++
+		switch stats.State {
+		case PackageDone:
+			totals.Compiled++
+		case PackageCached:
+			totals.Cached++
+		case PackageCompiledBefore:
+			totals.CompiledBefore++
+		case PackageFailed:
+			totals.Failed++
+		}
+		switch stats.Cache {
+		case CacheHit:
+			totals.CacheHits++
+		case CacheMiss:
+			totals.CacheMisses++
+		}
+		totals.CompileSeconds += stats.CompileSeconds
+		totals.Size += stats.Size
+	}
+	sort.Slice(result.Packages, func(i, j int) bool {
+		return result.Packages[i].Package < result.Packages[j].Package
+	})
+
+	return result
+}
+
+// dirSize returns the size of the files in a directory, in bytes
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
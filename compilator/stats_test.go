@@ -0,0 +1,92 @@
+package compilator
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+	"github.com/hpcloud/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompilationStats(t *testing.T) {
+	assert := assert.New(t)
+
+	cacheDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(cacheDir)
+
+	cache, err := NewPackageCache(cacheDir, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	releases := genTestCase("consul>go-1.4", "go-1.4")
+	consul, golang := releases[0].Packages[0], releases[0].Packages[1]
+	consul.Dependencies = model.Packages{golang}
+
+	compile := func(workDir string) *CompilationStats {
+		c, err := NewDockerCompilator(nil, workDir, "", "", "", "", false, ui)
+		assert.NoError(err)
+		c.compilePackage = compileIntoWorkDir
+		c.SetPackageCache(cache, false)
+		assert.NoError(c.Compile(2, releases, nil))
+		return c.Stats()
+	}
+
+	// The first run compiles both packages ...
+	workDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	stats := compile(workDir)
+	assert.Equal(2, stats.Workers)
+	assert.Equal("linux/amd64", stats.Platform)
+	if assert.Len(stats.Packages, 2) {
+		assert.Equal("test-release/consul", stats.Packages[0].Package)
+		assert.Equal("test-release/go-1.4", stats.Packages[1].Package)
+		for _, pkg := range stats.Packages {
+			assert.Equal(PackageDone, pkg.State)
+			assert.Equal(CacheMiss, pkg.Cache)
+			assert.True(pkg.Worker == 1 || pkg.Worker == 2, "Unknown worker %d", pkg.Worker)
+			assert.True(pkg.WallSeconds >= pkg.CompileSeconds)
+		}
+		// The compiled file holds the fingerprint
+		assert.Equal(int64(len("consul")), stats.Packages[0].Size)
+	}
+	assert.Equal(&CompilationTotals{
+		Packages:       2,
+		Compiled:       2,
+		CacheMisses:    2,
+		CompileSeconds: stats.Totals.CompileSeconds,
+		WallSeconds:    stats.Totals.WallSeconds,
+		Size:           int64(len("consul") + len("go-1.4")),
+	}, stats.Totals)
+
+	// ... the next, elsewhere, finds them in the cache ...
+	otherWorkDir, err := util.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(otherWorkDir)
+
+	stats = compile(otherWorkDir)
+	assert.Equal(2, stats.Totals.Cached)
+	assert.Equal(2, stats.Totals.CacheHits)
+	assert.Equal(0, stats.Totals.CacheMisses)
+	assert.Equal(int64(len("consul")+len("go-1.4")), stats.Totals.Size)
+
+	// ... and the one after that has nothing left to do
+	stats = compile(otherWorkDir)
+	assert.Equal(2, stats.Totals.CompiledBefore)
+	for _, pkg := range stats.Packages {
+		assert.Equal(PackageCompiledBefore, pkg.State)
+		assert.Equal(CacheNone, pkg.Cache)
+		assert.Equal(0, pkg.Worker)
+	}
+}
@@ -0,0 +1,393 @@
+package compose
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hpcloud/fissile/builder"
+	"github.com/hpcloud/fissile/model"
+)
+
+// Version is the docker-compose file format version written
+const Version = "2"
+
+// ScaleVersion is the docker-compose file format version written when a
+// service sets its scale, which older versions don't support
+const ScaleVersion = "2.2"
+
+// Settings configure the generated docker-compose file
+type Settings struct {
+	Defaults     map[string]string // Values of configuration variables, taking precedence over the role manifest
+	Repository   string            // Repository name prefix of the role images
+	Registry     string            // Docker registry of the role images
+	Organization string            // Docker organization of the role images
+}
+
+// File is a docker-compose file
+type File struct {
+	Version  string              `yaml:"version"`
+	Services map[string]*Service `yaml:"services"`
+	Volumes  map[string]struct{} `yaml:"volumes,omitempty"`
+}
+
+// Service is a container of a docker-compose file
+type Service struct {
+	Image       string            `yaml:"image"`
+	Command     []string          `yaml:"command,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Ports       []string          `yaml:"ports,omitempty"`
+	Volumes     []string          `yaml:"volumes,omitempty"`
+	Tmpfs       []string          `yaml:"tmpfs,omitempty"`
+	DependsOn   []string          `yaml:"depends_on,omitempty"`
+	NetworkMode string            `yaml:"network_mode,omitempty"`
+	Privileged  bool              `yaml:"privileged,omitempty"`
+	CapAdd      []string          `yaml:"cap_add,omitempty"`
+	Restart     string            `yaml:"restart,omitempty"`
+	Scale       *int32            `yaml:"scale,omitempty"`
+
+	MemLimit       string                    `yaml:"mem_limit,omitempty"`
+	MemReservation string                    `yaml:"mem_reservation,omitempty"`
+	CPUShares      int64                     `yaml:"cpu_shares,omitempty"`
+	CPUQuota       int64                     `yaml:"cpu_quota,omitempty"`
+	MemswapLimit   string                    `yaml:"memswap_limit,omitempty"`
+	ShmSize        string                    `yaml:"shm_size,omitempty"`
+	Ulimits        map[string]*ServiceUlimit `yaml:"ulimits,omitempty"`
+}
+
+// ServiceUlimit is a resource limit of a service
+type ServiceUlimit struct {
+	Soft int64 `yaml:"soft"`
+	Hard int64 `yaml:"hard"`
+}
+
+// cpuShares are the relative CPU shares of a container per requested CPU,
+// and cpuPeriod the default CFS period, in microseconds, its quota is of
+const (
+	cpuShares = 1024
+	cpuPeriod = 100000
+)
+
+// flightStageDependencies lists the flight stage whose roles have to be
+// started before those of another stage
+var flightStageDependencies = map[model.FlightStage]model.FlightStage{
+	model.FlightStageFlight:     model.FlightStagePreFlight,
+	model.FlightStagePostFlight: model.FlightStageFlight,
+}
+
+// NewFile creates a docker-compose file running the given roles, each as a
+// service named after the role. Roles of the manual flight stage are left
+// out. Sidecars of a role become services sharing its network. Roles scaled
+// down to zero instances are services with a scale of 0, which no other
+// service depends on, so they can be scaled up later.
+func NewFile(roles model.Roles, settings *Settings) (*File, error) {
+	file := &File{
+		Version:  Version,
+		Services: make(map[string]*Service),
+		Volumes:  make(map[string]struct{}),
+	}
+
+	rolesByStage := make(map[model.FlightStage][]string)
+	started := make(map[string]bool)
+	for _, role := range roles {
+		if role.Run != nil && !role.IsScaledDown() {
+			rolesByStage[role.Run.FlightStage] = append(rolesByStage[role.Run.FlightStage], role.Name)
+			started[role.Name] = role.Run.FlightStage != model.FlightStageManual
+		}
+	}
+
+	for _, role := range roles {
+		if role.Run == nil || role.Run.FlightStage == model.FlightStageManual {
+			continue
+		}
+
+		service, err := newRoleService(role, settings)
+		if err != nil {
+			return nil, err
+		}
+		service.DependsOn = serviceDependencies(role, rolesByStage, started)
+		if role.IsScaledDown() {
+			service.Scale = role.Run.InitialInstances
+			file.Version = ScaleVersion
+		}
+		file.Services[role.Name] = service
+
+		for _, volume := range append(append([]*model.RoleRunVolume{}, role.Run.PersistentVolumes...), role.Run.SharedVolumes...) {
+			file.Volumes[volume.Tag] = struct{}{}
+		}
+
+		for _, sidecar := range role.Sidecars {
+			sidecarService, err := newSidecarService(role, sidecar, settings)
+			if err != nil {
+				return nil, err
+			}
+			sidecarService.Scale = service.Scale
+			file.Services[fmt.Sprintf("%s-%s", role.Name, sidecar.Name)] = sidecarService
+		}
+	}
+
+	return file, nil
+}
+
+// serviceDependencies lists the services a role has to wait for: the roles of
+// the flight stage before its own, and the roles it explicitly depends on.
+// Roles which are not started with the others are left out.
+func serviceDependencies(role *model.Role, rolesByStage map[model.FlightStage][]string, started map[string]bool) []string {
+	var dependencies []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			dependencies = append(dependencies, name)
+		}
+	}
+
+	if stage, ok := flightStageDependencies[role.Run.FlightStage]; ok {
+		for _, name := range rolesByStage[stage] {
+			add(name)
+		}
+	}
+	for _, name := range role.Run.DependsOn {
+		if started[name] {
+			add(name)
+		}
+	}
+
+	sort.Strings(dependencies)
+	return dependencies
+}
+
+// newRoleService returns the service running a role
+func newRoleService(role *model.Role, settings *Settings) (*Service, error) {
+	image, err := getImageName(role, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := getEnvironment(role, nil, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &Service{
+		Image:       image,
+		Environment: environment,
+		Ports:       getPorts(role),
+		Restart:     "always",
+	}
+	if role.Type == model.RoleTypeBoshTask {
+		service.Restart = "on-failure"
+	}
+	if restart := role.Run.Restart; restart != nil {
+		service.Restart = string(restart.Policy)
+		if restart.Policy == model.RestartPolicyOnFailure && restart.MaxRetries > 0 {
+			service.Restart = fmt.Sprintf("%s:%d", restart.Policy, restart.MaxRetries)
+		}
+	}
+	if role.Run.OOMScoreAdj != nil {
+		environment["FISSILE_OOM_SCORE_ADJ"] = strconv.Itoa(*role.Run.OOMScoreAdj)
+	}
+
+	for _, volume := range append(append([]*model.RoleRunVolume{}, role.Run.PersistentVolumes...), role.Run.SharedVolumes...) {
+		service.Volumes = append(service.Volumes, fmt.Sprintf("%s:%s", volume.Tag, volume.Path))
+	}
+	for _, volume := range role.Run.EphemeralVolumes {
+		addEphemeralVolume(service, volume, volume.Path)
+	}
+
+	for _, capability := range role.Run.Capabilities {
+		capability = strings.ToUpper(capability)
+		if capability == "ALL" {
+			service.Privileged = true
+			service.CapAdd = nil
+			break
+		}
+		service.CapAdd = append(service.CapAdd, capability)
+	}
+
+	setResources(service, role.Run)
+
+	return service, nil
+}
+
+// setResources sets the memory and CPU constraints, the size of /dev/shm
+// and the ulimits of a role's service. The memory request is reserved, and
+// is also the limit when the role has none; the CPU request sets the relative
+// CPU shares of the service, and the CPU limit its quota.
+func setResources(service *Service, run *model.RoleRun) {
+	memoryLimit := run.Memory.DockerLimit()
+	if memoryLimit > 0 {
+		service.MemLimit = fmt.Sprintf("%dm", memoryLimit)
+	}
+	if run.Memory.Limit > 0 && run.Memory.Request > 0 {
+		service.MemReservation = fmt.Sprintf("%dm", run.Memory.Request)
+	}
+
+	if run.CPU.Request > 0 {
+		service.CPUShares = int64(math.Round(run.CPU.Request * cpuShares))
+	}
+	if run.CPU.Limit > 0 {
+		service.CPUQuota = int64(math.Round(run.CPU.Limit * cpuPeriod))
+	}
+
+	resources := run.Resources
+	if resources == nil {
+		return
+	}
+
+	if resources.ShmSize > 0 {
+		service.ShmSize = fmt.Sprintf("%dm", resources.ShmSize)
+	}
+	// A swap limit only applies along with a memory limit
+	if memoryLimit > 0 {
+		switch {
+		case resources.MemorySwap < 0:
+			service.MemswapLimit = "-1"
+		case resources.MemorySwap > 0:
+			service.MemswapLimit = fmt.Sprintf("%dm", resources.MemorySwap)
+		}
+	}
+
+	if len(resources.Ulimits) > 0 {
+		service.Ulimits = make(map[string]*ServiceUlimit, len(resources.Ulimits))
+		for _, ulimit := range resources.Ulimits {
+			service.Ulimits[ulimit.Name] = &ServiceUlimit{Soft: ulimit.Soft, Hard: ulimit.Hard}
+		}
+	}
+}
+
+// newSidecarService returns the service running a sidecar of a role, in the
+// network namespace of the role like in a Kubernetes pod
+func newSidecarService(role *model.Role, sidecar *model.RoleSidecar, settings *Settings) (*Service, error) {
+	environment, err := getEnvironment(role, sidecar.Env, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &Service{
+		Image:       sidecar.Image,
+		Command:     sidecar.Command,
+		Environment: environment,
+		DependsOn:   []string{role.Name},
+		NetworkMode: fmt.Sprintf("service:%s", role.Name),
+		Restart:     "always",
+	}
+
+	ephemeral := make(map[string]*model.RoleRunEphemeralVolume, len(role.Run.EphemeralVolumes))
+	for _, volume := range role.Run.EphemeralVolumes {
+		ephemeral[volume.Tag] = volume
+	}
+
+	for _, volume := range sidecar.Volumes {
+		if roleVolume, ok := ephemeral[volume.Tag]; ok {
+			addEphemeralVolume(service, roleVolume, volume.Path)
+			continue
+		}
+		service.Volumes = append(service.Volumes, fmt.Sprintf("%s:%s", volume.Tag, volume.Path))
+	}
+
+	return service, nil
+}
+
+// addEphemeralVolume mounts an ephemeral volume at a path of a service: a
+// tmpfs limited to its size if it is backed by memory, an anonymous volume
+// otherwise. Docker can't share either with other containers; sidecars get
+// ephemeral volumes of their own, unlike in Kubernetes pods.
+func addEphemeralVolume(service *Service, volume *model.RoleRunEphemeralVolume, mountPath string) {
+	if volume.Medium != model.VolumeMediumMemory {
+		service.Volumes = append(service.Volumes, mountPath)
+		return
+	}
+	if volume.Size > 0 {
+		mountPath = fmt.Sprintf("%s:size=%dm", mountPath, volume.Size)
+	}
+	service.Tmpfs = append(service.Tmpfs, mountPath)
+}
+
+// getImageName returns the name of the docker image of a role
+func getImageName(role *model.Role, settings *Settings) (string, error) {
+	devVersion, err := role.GetRoleDevVersion()
+	if err != nil {
+		return "", err
+	}
+
+	imageName := builder.GetRoleDevImageName(settings.Repository, role, devVersion)
+
+	if settings.Organization != "" {
+		imageName = fmt.Sprintf("%s/%s", settings.Organization, imageName)
+	}
+	if settings.Registry != "" {
+		imageName = fmt.Sprintf("%s/%s", settings.Registry, imageName)
+	}
+
+	return imageName, nil
+}
+
+// RoleEnvironment returns the environment of the service of a role: the
+// values of its configuration variables
+func RoleEnvironment(role *model.Role, settings *Settings) (map[string]string, error) {
+	return getEnvironment(role, nil, settings)
+}
+
+// getEnvironment returns the values of the configuration variables of a
+// role; the defaults take precedence over the role manifest. Variables
+// without any value are left out, the others are serialized according to
+// their types. If names are given, only those variables
+// are returned.
+func getEnvironment(role *model.Role, names []string, settings *Settings) (map[string]string, error) {
+	configs, err := role.GetVariablesForRole()
+	if err != nil {
+		return nil, err
+	}
+
+	var wanted map[string]bool
+	if names != nil {
+		wanted = make(map[string]bool, len(names))
+		for _, name := range names {
+			wanted[name] = true
+		}
+	}
+
+	environment := make(map[string]string, len(configs))
+	for _, config := range configs {
+		if wanted != nil && !wanted[config.Name] {
+			continue
+		}
+
+		value := config.Default
+		if defaultValue, ok := settings.Defaults[config.Name]; ok {
+			value = defaultValue
+		}
+		if value == nil {
+			continue
+		}
+
+		environment[config.Name], err = config.FormatValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of the configuration variable %s: %s", config.Name, err)
+		}
+	}
+
+	return environment, nil
+}
+
+// getPorts returns the public ports of a role, published on the host
+func getPorts(role *model.Role) []string {
+	var ports []string
+
+	for _, port := range role.Run.ExposedPorts {
+		if !port.Public {
+			continue
+		}
+
+		external := port.External
+		if external == "" {
+			external = port.Internal
+		}
+		ports = append(ports, fmt.Sprintf("%s:%s/%s", external, port.Internal, strings.ToLower(port.Protocol)))
+	}
+
+	return ports
+}
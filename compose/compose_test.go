@@ -0,0 +1,258 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadComposeManifest(assert *assert.Assertions, manifestName string) *model.RoleManifest {
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := model.NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return nil
+	}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests", manifestName)
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, []*model.Release{release})
+	if !assert.NoError(err) {
+		return nil
+	}
+
+	return roleManifest
+}
+
+func TestNewFile(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadComposeManifest(assert, "compose.yml")
+	if roleManifest == nil {
+		return
+	}
+
+	file, err := NewFile(roleManifest.Roles, &Settings{
+		Defaults:     map[string]string{"PRIVATE_KEY": "not-so-private"},
+		Repository:   "fissile",
+		Registry:     "docker.example.com",
+		Organization: "cf",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(Version, file.Version)
+	assert.Len(file.Services, 4, "The manual role should be left out")
+	assert.NotContains(file.Services, "rotate-keys")
+	assert.Equal(map[string]struct{}{"logs": {}}, file.Volumes)
+
+	myrole := file.Services["myrole"]
+	if assert.NotNil(myrole) {
+		version, err := roleManifest.LookupRole("myrole").GetRoleDevVersion()
+		assert.NoError(err)
+		assert.Equal("docker.example.com/cf/fissile-myrole:"+version, myrole.Image)
+		assert.Equal([]string{"80:8080/tcp"}, myrole.Ports, "Only public ports should be published")
+		assert.Equal([]string{"logs:/var/vcap/sys/log"}, myrole.Volumes)
+		assert.Equal([]string{"NET_ADMIN"}, myrole.CapAdd)
+		assert.Equal([]string{"setup"}, myrole.DependsOn)
+		assert.Equal("always", myrole.Restart)
+		assert.Equal(map[string]string{
+			"HOSTNAME":      "tor.example.com",
+			"PRIVATE_KEY":   "not-so-private",
+			"SHIPPER_TOKEN": "shipper-token",
+		}, myrole.Environment)
+	}
+
+	shipper := file.Services["myrole-log-shipper"]
+	if assert.NotNil(shipper) {
+		assert.Equal("docker.example.com/log-shipper:1.0", shipper.Image)
+		assert.Equal([]string{"ship"}, shipper.Command)
+		assert.Equal(map[string]string{"SHIPPER_TOKEN": "shipper-token"}, shipper.Environment)
+		assert.Equal("service:myrole", shipper.NetworkMode)
+		assert.Equal([]string{"myrole"}, shipper.DependsOn)
+		assert.Equal([]string{"logs:/var/vcap/sys/log"}, shipper.Volumes)
+	}
+
+	if assert.Contains(file.Services, "setup") {
+		assert.Empty(file.Services["setup"].DependsOn)
+		assert.Equal("on-failure", file.Services["setup"].Restart)
+	}
+	if assert.Contains(file.Services, "smoke-tests") {
+		assert.Equal([]string{"myrole"}, file.Services["smoke-tests"].DependsOn)
+	}
+}
+
+func TestNewFileResources(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadComposeManifest(assert, "resources.yml")
+	if roleManifest == nil {
+		return
+	}
+
+	file, err := NewFile(roleManifest.Roles, &Settings{Repository: "fissile"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := file.Services["myrole"]
+	if assert.NotNil(myrole) {
+		assert.Equal("512m", myrole.MemLimit)
+		assert.Empty(myrole.MemReservation)
+		assert.Equal("-1", myrole.MemswapLimit)
+		assert.Equal("256m", myrole.ShmSize)
+		assert.Equal(&ServiceUlimit{Soft: 65536, Hard: 65536}, myrole.Ulimits["nofile"])
+	}
+
+	// The defaults of the role manifest apply to roles without resources
+	foorole := file.Services["foorole"]
+	if assert.NotNil(foorole) {
+		assert.Equal("128m", foorole.MemLimit)
+		assert.Equal("64m", foorole.ShmSize)
+		assert.Equal(map[string]*ServiceUlimit{
+			"nofile":  {Soft: 1024, Hard: 4096},
+			"memlock": {Soft: -1, Hard: -1},
+		}, foorole.Ulimits)
+	}
+}
+
+func TestNewFileComputeResources(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadComposeManifest(assert, "compute-resources.yml")
+	if roleManifest == nil {
+		return
+	}
+
+	file, err := NewFile(roleManifest.Roles, &Settings{Repository: "fissile"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := file.Services["myrole"]
+	if assert.NotNil(myrole) {
+		assert.Equal("512m", myrole.MemLimit)
+		assert.Equal("256m", myrole.MemReservation)
+		assert.Equal(int64(512), myrole.CPUShares)
+		assert.Equal(int64(200000), myrole.CPUQuota)
+	}
+
+	// Without a limit, the request limits the memory
+	foorole := file.Services["foorole"]
+	if assert.NotNil(foorole) {
+		assert.Equal("128m", foorole.MemLimit)
+		assert.Empty(foorole.MemReservation)
+		assert.Equal(int64(2048), foorole.CPUShares)
+		assert.Zero(foorole.CPUQuota)
+	}
+}
+
+func TestNewFileProcessSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadComposeManifest(assert, "process.yml")
+	if roleManifest == nil {
+		return
+	}
+
+	file, err := NewFile(roleManifest.Roles, &Settings{Repository: "fissile"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := file.Services["myrole"]
+	if assert.NotNil(myrole) {
+		assert.Equal("on-failure:3", myrole.Restart)
+		assert.Equal("-500", myrole.Environment["FISSILE_OOM_SCORE_ADJ"])
+	}
+
+	foorole := file.Services["foorole"]
+	if assert.NotNil(foorole) {
+		assert.Equal("always", foorole.Restart)
+		assert.Equal("800", foorole.Environment["FISSILE_OOM_SCORE_ADJ"])
+	}
+}
+
+func TestNewFileEphemeralVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadComposeManifest(assert, "ephemeral-volumes.yml")
+	if roleManifest == nil {
+		return
+	}
+
+	file, err := NewFile(roleManifest.Roles, &Settings{Repository: "fissile"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Empty(file.Volumes, "Ephemeral volumes should not be named volumes")
+
+	myrole := file.Services["myrole"]
+	if assert.NotNil(myrole) {
+		assert.Equal([]string{"/var/vcap/data/scratch"}, myrole.Volumes)
+		assert.Equal([]string{"/var/vcap/data/cache:size=64m"}, myrole.Tmpfs)
+	}
+
+	cleaner := file.Services["myrole-cleaner"]
+	if assert.NotNil(cleaner) {
+		assert.Equal([]string{"/var/vcap/data/scratch"}, cleaner.Volumes)
+		assert.Empty(cleaner.Tmpfs)
+	}
+}
+
+func TestNewFileScaledDown(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadComposeManifest(assert, "scaled-down.yml")
+	if roleManifest == nil {
+		return
+	}
+
+	file, err := NewFile(roleManifest.Roles, &Settings{Repository: "fissile"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(ScaleVersion, file.Version)
+	if assert.Contains(file.Services, "optional") {
+		if assert.NotNil(file.Services["optional"].Scale) {
+			assert.Equal(int32(0), *file.Services["optional"].Scale)
+		}
+	}
+	if assert.Contains(file.Services, "myrole") {
+		assert.Nil(file.Services["myrole"].Scale)
+	}
+}
+
+func TestNewFileDependsOn(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadComposeManifest(assert, "depends-on.yml")
+	if roleManifest == nil {
+		return
+	}
+
+	file, err := NewFile(roleManifest.Roles, &Settings{Repository: "fissile"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	if assert.Contains(file.Services, "myrole") {
+		assert.Equal([]string{"mytask", "otherrole"}, file.Services["myrole"].DependsOn,
+			"Roles scaled down should not be waited for")
+	}
+	if assert.Contains(file.Services, "otherrole") {
+		assert.Equal([]string{"mytask"}, file.Services["otherrole"].DependsOn)
+	}
+	if assert.Contains(file.Services, "mytask") {
+		assert.Empty(file.Services["mytask"].DependsOn)
+	}
+}
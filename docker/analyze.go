@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LayerReport describes a layer of an image
+type LayerReport struct {
+	DiffID    string `json:"diff_id" yaml:"diff_id"`
+	CreatedBy string `json:"created_by" yaml:"created_by"`
+	Files     int    `json:"files" yaml:"files"`
+	Size      int64  `json:"size" yaml:"size"` // Of its files, in bytes
+}
+
+// HiddenFileReport describes a file of an image stored in a layer but hidden
+// by a later one, which replaces or removes it; the hidden copies waste space
+type HiddenFileReport struct {
+	Path       string `json:"path" yaml:"path"`
+	Layers     []int  `json:"layers" yaml:"layers"` // Storing a copy of the file, from 0 for the lowest
+	WastedSize int64  `json:"wasted_size" yaml:"wasted_size"`
+}
+
+// ImageAnalysis describes the layers of an image, and what takes up space in
+// them
+type ImageAnalysis struct {
+	Layers      []*LayerReport      `json:"layers" yaml:"layers"`
+	HiddenFiles []*HiddenFileReport `json:"hidden_files" yaml:"hidden_files"` // Most wasteful first
+	Size        int64               `json:"size" yaml:"size"`
+	WastedSize  int64               `json:"wasted_size" yaml:"wasted_size"`
+	// The size of the files of the image, grouped by the function given
+	Groups map[string]int64 `json:"groups" yaml:"groups"`
+}
+
+// AnalyzeImage saves an image and reports the size of its layers and the
+// files they hide. groupOf maps the path of each file of the image to the
+// group its size counts towards, if any.
+func (d *ImageManager) AnalyzeImage(imageName string, groupOf func(path string) string) (*ImageAnalysis, error) {
+	dir, err := ioutil.TempDir("", "fissile-analyze-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	image, err := d.saveImageToDir(imageName, dir)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeSavedImage(image, groupOf)
+}
+
+// layerFile is a file of an image, in the layer which stores its visible copy
+type layerFile struct {
+	layer int
+	size  int64
+}
+
+// analyzeSavedImage goes through the layers of an image from the lowest,
+// tracking which of their files are visible
+func analyzeSavedImage(image *savedImage, groupOf func(path string) string) (*ImageAnalysis, error) {
+	analysis := &ImageAnalysis{Groups: make(map[string]int64)}
+	createdBy := image.layersCreatedBy()
+
+	files := make(map[string]layerFile)
+	copies := make(map[string][]int)
+	wasted := make(map[string]int64)
+	hide := func(name string) {
+		if file, ok := files[name]; ok {
+			wasted[name] += file.size
+			delete(files, name)
+		}
+	}
+	// hideUnder hides the files within dir stored by layers below layer
+	hideUnder := func(dir string, layer int) {
+		for name, file := range files {
+			if file.layer < layer && isUnder(name, dir) {
+				hide(name)
+			}
+		}
+	}
+
+	for index, layerPath := range image.manifest.Layers {
+		layer := &LayerReport{}
+		if index < len(image.rootFS.DiffIDs) {
+			layer.DiffID = image.rootFS.DiffIDs[index]
+		}
+		if createdBy != nil {
+			layer.CreatedBy = createdBy[index]
+		}
+
+		err := walkLayer(filepath.Join(image.dir, layerPath), func(name string, header *tar.Header, _ io.Reader) error {
+			dir, base := path.Dir(name), path.Base(name)
+			if dir == "." {
+				dir = ""
+			}
+			switch {
+			case base == whiteoutOpaque:
+				hideUnder(dir, index)
+			case strings.HasPrefix(base, whiteoutPrefix):
+				removed := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				hide(removed)
+				hideUnder(removed, index)
+			default:
+				hide(name)
+				var size int64
+				if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+					size = header.Size
+				}
+				files[name] = layerFile{layer: index, size: size}
+				copies[name] = append(copies[name], index)
+				layer.Files++
+				layer.Size += size
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		analysis.Layers = append(analysis.Layers, layer)
+		analysis.Size += layer.Size
+	}
+
+	for name, size := range wasted {
+		if size == 0 {
+			continue
+		}
+		analysis.HiddenFiles = append(analysis.HiddenFiles, &HiddenFileReport{
+			Path:       "/" + name,
+			Layers:     copies[name],
+			WastedSize: size,
+		})
+		analysis.WastedSize += size
+	}
+	sort.Slice(analysis.HiddenFiles, func(i, j int) bool {
+		if analysis.HiddenFiles[i].WastedSize != analysis.HiddenFiles[j].WastedSize {
+			return analysis.HiddenFiles[i].WastedSize > analysis.HiddenFiles[j].WastedSize
+		}
+		return analysis.HiddenFiles[i].Path < analysis.HiddenFiles[j].Path
+	})
+
+	if groupOf != nil {
+		for name, file := range files {
+			if group := groupOf("/" + name); group != "" {
+				analysis.Groups[group] += file.size
+			}
+		}
+	}
+
+	return analysis, nil
+}
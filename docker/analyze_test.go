@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// layerEntry is an entry of a test layer; directories end with a slash
+type layerEntry struct {
+	name     string
+	contents string
+}
+
+// writeTestImage writes the files of a `docker save` tarball of an image with
+// the given layers into dir, as saveImageToDir extracts them
+func writeTestImage(dir string, layers ...[]layerEntry) error {
+	manifest := dockerArchiveManifest{Config: "config.json", RepoTags: []string{"fissile-role:1234"}}
+	config := map[string]interface{}{"architecture": "amd64", "created": "2018-01-02T03:04:05Z"}
+	var history []imageHistory
+	var diffIDs []string
+
+	for index, entries := range layers {
+		layerPath := filepath.Join(string('a'+rune(index)), "layer.tar")
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(layerPath)), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(filepath.Join(dir, layerPath))
+		if err != nil {
+			return err
+		}
+		writer := tar.NewWriter(file)
+		for _, entry := range entries {
+			header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.contents))}
+			if strings.HasSuffix(entry.name, "/") {
+				header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+			}
+			writer.WriteHeader(header)
+			writer.Write([]byte(entry.contents))
+		}
+		writer.Close()
+		file.Close()
+
+		manifest.Layers = append(manifest.Layers, layerPath)
+		diffIDs = append(diffIDs, digestOf(layerPath))
+		history = append(history, imageHistory{CreatedBy: "layer " + layerPath})
+		if index == 0 {
+			history = append(history, imageHistory{CreatedBy: "ENV A=B", EmptyLayer: true})
+		}
+	}
+	config["history"] = history
+	config["rootfs"] = imageRootFS{Type: "layers", DiffIDs: diffIDs}
+
+	contents, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), contents, 0644); err != nil {
+		return err
+	}
+	if contents, err = json.Marshal([]dockerArchiveManifest{manifest}); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "manifest.json"), contents, 0644)
+}
+
+// testImageLayers are the layers of the test images: the second one replaces
+// and removes files of the first, the third removes a directory
+var testImageLayers = [][]layerEntry{
+	{
+		{"var/", ""},
+		{"var/vcap/", ""},
+		{"var/vcap/packages-src/abc/bin", "0123456789"},
+		{"var/vcap/packages-src/abc/lib", "01234"},
+		{"var/vcap/jobs-src/nats/monit", "012"},
+		{"tmp/build/output", "0123456"},
+	},
+	{
+		{"var/vcap/", ""},
+		{"var/vcap/packages-src/abc/bin", "01234567890"},
+		{"var/vcap/packages-src/abc/.wh.lib", ""},
+		{"var/vcap/jobs-src/nats/spec", "0123"},
+	},
+	{
+		{"tmp/.wh.build", ""},
+		{"var/vcap/jobs-src/nats/templates/ctl.erb", "01"},
+	},
+}
+
+func TestAnalyzeSavedImage(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-analyze-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if !assert.NoError(writeTestImage(dir, testImageLayers...)) {
+		return
+	}
+	image, err := readSavedImage(dir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	groupOf := func(name string) string {
+		parts := strings.Split(name, "/")
+		if len(parts) > 4 && parts[3] == "jobs-src" {
+			return "job " + parts[4]
+		}
+		if len(parts) > 4 && parts[3] == "packages-src" {
+			return "package " + parts[4]
+		}
+		return ""
+	}
+	analysis, err := analyzeSavedImage(image, groupOf)
+	if !assert.NoError(err) {
+		return
+	}
+
+	if assert.Len(analysis.Layers, 3) {
+		assert.Equal(&LayerReport{DiffID: digestOf("a/layer.tar"), CreatedBy: "layer a/layer.tar", Files: 6, Size: 25}, analysis.Layers[0])
+		assert.Equal(&LayerReport{DiffID: digestOf("b/layer.tar"), CreatedBy: "layer b/layer.tar", Files: 3, Size: 15}, analysis.Layers[1])
+		assert.Equal(&LayerReport{DiffID: digestOf("c/layer.tar"), CreatedBy: "layer c/layer.tar", Files: 1, Size: 2}, analysis.Layers[2])
+	}
+	assert.Equal(int64(42), analysis.Size)
+
+	assert.Equal([]*HiddenFileReport{
+		{Path: "/var/vcap/packages-src/abc/bin", Layers: []int{0, 1}, WastedSize: 10},
+		{Path: "/tmp/build/output", Layers: []int{0}, WastedSize: 7},
+		{Path: "/var/vcap/packages-src/abc/lib", Layers: []int{0}, WastedSize: 5},
+	}, analysis.HiddenFiles)
+	assert.Equal(int64(22), analysis.WastedSize)
+
+	assert.Equal(map[string]int64{"package abc": 11, "job nats": 9}, analysis.Groups)
+}
@@ -23,6 +23,10 @@ const (
 	ContainerInPath = "/fissile-in"
 	// ContainerOutPath is the output path for fissile
 	ContainerOutPath = "/fissile-out"
+
+	// cpuPeriod is the scheduling period CPU limits are quotas of, in
+	// microseconds
+	cpuPeriod = 100000
 )
 
 var (
@@ -37,15 +41,24 @@ type dockerClient interface {
 	BuildImage(dockerclient.BuildImageOptions) error
 	CommitContainer(dockerclient.CommitContainerOptions) (*dockerclient.Image, error)
 	CreateContainer(dockerclient.CreateContainerOptions) (*dockerclient.Container, error)
+	CreateExec(dockerclient.CreateExecOptions) (*dockerclient.Exec, error)
 	CreateVolume(dockerclient.CreateVolumeOptions) (*dockerclient.Volume, error)
+	ExportImage(dockerclient.ExportImageOptions) error
 	ImageHistory(string) ([]dockerclient.ImageHistory, error)
+	InspectContainer(string) (*dockerclient.Container, error)
+	InspectExec(string) (*dockerclient.ExecInspect, error)
 	InspectImage(string) (*dockerclient.Image, error)
 	ListImages(dockerclient.ListImagesOptions) ([]dockerclient.APIImages, error)
 	ListVolumes(dockerclient.ListVolumesOptions) ([]dockerclient.Volume, error)
+	LoadImage(dockerclient.LoadImageOptions) error
+	PullImage(dockerclient.PullImageOptions, dockerclient.AuthConfiguration) error
+	PushImage(dockerclient.PushImageOptions, dockerclient.AuthConfiguration) error
 	RemoveContainer(dockerclient.RemoveContainerOptions) error
 	RemoveImage(string) error
 	RemoveVolume(string) error
 	StartContainer(string, *dockerclient.HostConfig) error
+	StartExec(string, dockerclient.StartExecOptions) error
+	TagImage(string, dockerclient.TagImageOptions) error
 	WaitContainer(string) (int, error)
 }
 
@@ -337,7 +350,14 @@ func (d *ImageManager) FindBestImageWithLabels(baseImageName string, labels []st
 		return "", nil, err
 	}
 	bestMatch := baseImages[0]
-	for _, candidate := range matchingImages {
+	// Go through the matches in a stable order, so ties go to the same image
+	matchKeys := make([]string, 0, len(matchingImages))
+	for matchKey := range matchingImages {
+		matchKeys = append(matchKeys, matchKey)
+	}
+	sort.Strings(matchKeys)
+	for _, matchKey := range matchKeys {
+		candidate := matchingImages[matchKey]
 		if candidate.Size > bestMatch.Size {
 			bestMatch = candidate
 		}
@@ -372,6 +392,22 @@ func (d *ImageManager) RemoveContainer(containerID string) error {
 	})
 }
 
+// RemoveStaleContainer removes a container left behind by an earlier run,
+// if there is one, along with its volumes
+func (d *ImageManager) RemoveStaleContainer(containerName string) error {
+	container, err := d.client.InspectContainer(containerName)
+	if _, ok := err.(*dockerclient.NoSuchContainer); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := d.RemoveContainer(container.ID); err != nil {
+		return err
+	}
+	return d.RemoveVolumes(container)
+}
+
 // RemoveImage will remove an image from Docker's internal registry
 func (d *ImageManager) RemoveImage(imageName string) error {
 	return d.client.RemoveImage(imageName)
@@ -393,6 +429,35 @@ func (d *ImageManager) CreateImage(containerID string, repository string, tag st
 	return d.client.CommitContainer(cco)
 }
 
+// ExecInContainer runs a command in an already running container, like
+// docker exec, and returns its exit code
+func (d *ImageManager) ExecInContainer(containerID string, cmd []string, stdoutWriter, stderrWriter io.Writer) (int, error) {
+	exec, err := d.client.CreateExec(dockerclient.CreateExecOptions{
+		Container:    containerID,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	err = d.client.StartExec(exec.ID, dockerclient.StartExecOptions{
+		OutputStream: stdoutWriter,
+		ErrorStream:  stderrWriter,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	inspect, err := d.client.InspectExec(exec.ID)
+	if err != nil {
+		return -1, err
+	}
+
+	return inspect.ExitCode, nil
+}
+
 // RunInContainerOpts encapsulates the options to RunInContainer()
 type RunInContainerOpts struct {
 	ContainerName string
@@ -406,6 +471,11 @@ type RunInContainerOpts struct {
 	KeepContainer bool
 	StdoutWriter  io.Writer
 	StderrWriter  io.Writer
+	Env           []string // Extra environment variables, as NAME=value
+
+	// Resource limits; none if 0
+	Memory int64 // In bytes
+	CPUs   float64
 }
 
 // RunInContainer will execute a set of commands within a running Docker container
@@ -415,6 +485,10 @@ func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (exitCode int, co
 	// os/user.Current() isn't supported when cross-compiling hence this code
 	currentUID := syscall.Geteuid()
 	currentGID := syscall.Getegid()
+	if currentUID < 0 || currentGID < 0 {
+		// Windows has no user IDs; compiled packages are left to root
+		currentUID, currentGID = 0, 0
+	}
 	var actualCmd, containerCmd []string
 	if opts.KeepContainer {
 		// Sleep effectively forever so if something goes wrong we can
@@ -432,6 +506,7 @@ func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (exitCode int, co
 		fmt.Sprintf("HOST_USERID=%d", currentUID),
 		fmt.Sprintf("HOST_USERGID=%d", currentGID),
 	}
+	env = append(env, opts.Env...)
 	for _, name := range []string{"http_proxy", "https_proxy"} {
 		var proxyURL *url.URL
 		var err error
@@ -474,6 +549,16 @@ func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (exitCode int, co
 		Name: opts.ContainerName,
 	}
 
+	if opts.Memory != 0 {
+		cco.HostConfig.Memory = opts.Memory
+		// Without swap, or the limit is twice as high
+		cco.HostConfig.MemorySwap = opts.Memory
+	}
+	if opts.CPUs != 0 {
+		cco.HostConfig.CPUPeriod = cpuPeriod
+		cco.HostConfig.CPUQuota = int64(opts.CPUs * cpuPeriod)
+	}
+
 	for name, dirverOpts := range opts.Volumes {
 		name = fmt.Sprintf("volume_%s_%s", opts.ContainerName, name)
 		_, err := d.client.CreateVolume(dockerclient.CreateVolumeOptions{
@@ -0,0 +1,147 @@
+package docker
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Files of layers marking files of the layers below as removed, see
+// https://github.com/opencontainers/image-spec/blob/master/layer.md#whiteouts
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq" // All the files of the directory are removed
+)
+
+// imageHistory is an entry of the history in the config of an image
+type imageHistory struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// imageRootFS lists the layers in the config of an image
+type imageRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// savedImage is an image saved with `docker save`, extracted into a directory
+type savedImage struct {
+	dir      string
+	manifest dockerArchiveManifest
+	config   map[string]json.RawMessage // Whole, to write it back
+	history  []imageHistory
+	rootFS   imageRootFS
+}
+
+// saveImageToDir saves an image with `docker save`, extracted into dir
+func (d *ImageManager) saveImageToDir(imageName, dir string) (*savedImage, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(d.SaveImage(imageName, writer))
+	}()
+
+	err := extractDockerArchive(reader, dir)
+	// Stops the save if the extraction failed
+	reader.CloseWithError(err)
+	if err != nil {
+		return nil, fmt.Errorf("Error saving image %s: %s", imageName, err)
+	}
+
+	return readSavedImage(dir)
+}
+
+// readSavedImage reads the manifest and config of the image of a `docker
+// save` tarball extracted into dir
+func readSavedImage(dir string) (*savedImage, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []dockerArchiveManifest
+	if err := json.Unmarshal(contents, &manifests); err != nil {
+		return nil, fmt.Errorf("Error reading the manifest of the image archive: %s", err)
+	}
+	if len(manifests) != 1 {
+		return nil, fmt.Errorf("Expected one image in the image archive, found %d", len(manifests))
+	}
+
+	image := &savedImage{dir: dir, manifest: manifests[0]}
+	if contents, err = ioutil.ReadFile(filepath.Join(dir, image.manifest.Config)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &image.config); err != nil {
+		return nil, fmt.Errorf("Error reading the config of the image archive: %s", err)
+	}
+	if history, ok := image.config["history"]; ok {
+		if err := json.Unmarshal(history, &image.history); err != nil {
+			return nil, fmt.Errorf("Error reading the history of the image archive: %s", err)
+		}
+	}
+	if rootFS, ok := image.config["rootfs"]; ok {
+		if err := json.Unmarshal(rootFS, &image.rootFS); err != nil {
+			return nil, fmt.Errorf("Error reading the layers of the image archive: %s", err)
+		}
+	}
+
+	return image, nil
+}
+
+// layersCreatedBy returns the commands which created the layers of the
+// image, in their order; none if the history doesn't match the layers
+func (i *savedImage) layersCreatedBy() []string {
+	var createdBy []string
+	for _, entry := range i.history {
+		if !entry.EmptyLayer {
+			createdBy = append(createdBy, entry.CreatedBy)
+		}
+	}
+	if len(createdBy) != len(i.manifest.Layers) {
+		return nil
+	}
+	return createdBy
+}
+
+// walkLayer calls fn for each entry of a layer tarball, with its path
+// relative to the root of the image, without a leading or trailing slash
+func walkLayer(layerPath string, fn func(name string, header *tar.Header, contents io.Reader) error) error {
+	file, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading layer %s: %s", layerPath, err)
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if name == "" {
+			continue
+		}
+		if err := fn(name, header, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// isUnder returns whether the path name is within the directory dir; all
+// paths are within the root, the empty dir
+func isUnder(name, dir string) bool {
+	return dir == "" || strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,321 @@
+package docker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The media types of the manifests in a docker registry
+const (
+	manifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	manifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// dockerHubRegistry is the host serving the registry API of the default
+// registry
+const dockerHubRegistry = "registry-1.docker.io"
+
+// PlatformImage is a pushed image built for a platform
+type PlatformImage struct {
+	Image    string
+	Platform Platform
+}
+
+// manifestListEntry describes the manifest of a platform in a manifest list
+type manifestListEntry struct {
+	MediaType string   `json:"mediaType"`
+	Size      int64    `json:"size"`
+	Digest    string   `json:"digest"`
+	Platform  Platform `json:"platform"`
+}
+
+// manifestList is a manifest referring to the manifests of an image for
+// several platforms
+type manifestList struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	Manifests     []*manifestListEntry `json:"manifests"`
+}
+
+// CreateManifestList pushes a manifest list named listName, referring to the
+// images of each platform. The images must have been pushed already, to the
+// same repository as the list. The credentials are those of the docker client
+// configuration, unless auth is given. The digest of the list is returned.
+func CreateManifestList(listName string, images []PlatformImage, auth *RegistryAuth) (string, error) {
+	listRepository, listReference := splitImageTag(listName)
+	if listReference == "" {
+		listReference = "latest"
+	}
+	client := newRegistryClient(ImageRegistry(listName), auth)
+
+	list := &manifestList{SchemaVersion: 2, MediaType: manifestListMediaType}
+	for _, image := range images {
+		repository, reference := splitImageTag(image.Image)
+		if repository != listRepository {
+			return "", fmt.Errorf("Image %s is not in the repository of %s", image.Image, listName)
+		}
+		if reference == "" {
+			reference = "latest"
+		}
+
+		entry, err := client.manifestEntry(repository, reference)
+		if err != nil {
+			return "", fmt.Errorf("Error looking up the manifest of %s: %s", image.Image, err)
+		}
+		entry.Platform = image.Platform
+		list.Manifests = append(list.Manifests, entry)
+	}
+
+	contents, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := client.putManifestList(listRepository, listReference, contents)
+	if err != nil {
+		return "", fmt.Errorf("Error pushing the manifest list %s: %s", listName, err)
+	}
+	return digest, nil
+}
+
+// registryClient talks to the HTTP API of a docker registry
+type registryClient struct {
+	registry string
+	baseURL  string
+	username string
+	password string
+	token    string
+	client   *http.Client
+}
+
+// newRegistryClient returns a client of a registry, using the given
+// credentials, or else those of the docker client configuration
+func newRegistryClient(registry string, auth *RegistryAuth) *registryClient {
+	client := &registryClient{registry: registry, client: http.DefaultClient}
+
+	if auth != nil {
+		client.username, client.password = auth.Username, auth.Password
+	} else {
+		registryAuth := loadAuthConfigurations().lookup(registry)
+		client.username, client.password = registryAuth.Username, registryAuth.Password
+	}
+
+	host := registry
+	if registry == defaultRegistry {
+		host = dockerHubRegistry
+	}
+	scheme := "https"
+	if hostname := strings.Split(host, ":")[0]; hostname == "localhost" || hostname == "127.0.0.1" {
+		scheme = "http"
+	}
+	client.baseURL = fmt.Sprintf("%s://%s/v2", scheme, host)
+
+	return client
+}
+
+// repositoryPath returns the path of a repository in the registry API
+func (c *registryClient) repositoryPath(repository string) string {
+	if c.registry != defaultRegistry {
+		return strings.TrimPrefix(repository, c.registry+"/")
+	}
+	repository = strings.TrimPrefix(repository, defaultRegistry+"/")
+	if !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return repository
+}
+
+// manifestEntry looks up the media type, size and digest of a manifest
+func (c *registryClient) manifestEntry(repository, reference string) (*manifestListEntry, error) {
+	path := fmt.Sprintf("/%s/manifests/%s", c.repositoryPath(repository), reference)
+	response, err := c.do("GET", path, repository, func(request *http.Request) {
+		request.Header.Set("Accept", manifestMediaType)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %s", response.Status)
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(response.Header.Get("Content-Type"), ";")[0])
+	if mediaType != manifestMediaType {
+		return nil, fmt.Errorf("Unsupported manifest type %s", mediaType)
+	}
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(contents)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	return &manifestListEntry{MediaType: mediaType, Size: int64(len(contents)), Digest: digest}, nil
+}
+
+// putManifestList uploads a manifest list and returns its digest
+func (c *registryClient) putManifestList(repository, reference string, contents []byte) (string, error) {
+	path := fmt.Sprintf("/%s/manifests/%s", c.repositoryPath(repository), reference)
+	response, err := c.do("PUT", path, repository, func(request *http.Request) {
+		request.Header.Set("Content-Type", manifestListMediaType)
+		request.ContentLength = int64(len(contents))
+	}, contents)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return "", fmt.Errorf("Unexpected status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(contents)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return digest, nil
+}
+
+// do sends a request to the registry, authenticating as the registry asks
+// for when the request is denied
+func (c *registryClient) do(method, path, repository string, setup func(*http.Request), body []byte) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		request, err := http.NewRequest(method, c.baseURL+path, reader)
+		if err != nil {
+			return nil, err
+		}
+		setup(request)
+		c.authorize(request)
+		return c.client.Do(request)
+	}
+
+	response, err := send()
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	challenge := response.Header.Get("Www-Authenticate")
+	response.Body.Close()
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return nil, fmt.Errorf("Unauthorized, check the credentials for %s", c.registry)
+	}
+	if err := c.authenticate(challenge[len("bearer "):], repository); err != nil {
+		return nil, err
+	}
+
+	return send()
+}
+
+// authorize adds the token, or else the credentials, to a request
+func (c *registryClient) authorize(request *http.Request) {
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// authenticate gets a token from the authorization server named by a bearer
+// challenge
+func (c *registryClient) authenticate(challenge, repository string) error {
+	params := parseChallengeParams(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("Invalid authentication challenge of %s: %s", c.registry, challenge)
+	}
+
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull,push", c.repositoryPath(repository))
+	}
+	query.Set("scope", scope)
+
+	request, err := http.NewRequest("GET", realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error authenticating to %s: %s", c.registry, response.Status)
+	}
+
+	var tokens struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return fmt.Errorf("Error authenticating to %s: %s", c.registry, err)
+	}
+	c.token = tokens.Token
+	if c.token == "" {
+		c.token = tokens.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("Error authenticating to %s: no token returned", c.registry)
+	}
+	return nil
+}
+
+// parseChallengeParams parses the key="value" parameters of an
+// authentication challenge
+func parseChallengeParams(challenge string) map[string]string {
+	params := make(map[string]string)
+	for len(challenge) > 0 {
+		challenge = strings.TrimLeft(challenge, " ,")
+		at := strings.Index(challenge, "=")
+		if at < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(challenge[:at]))
+		challenge = challenge[at+1:]
+
+		var value string
+		if strings.HasPrefix(challenge, `"`) {
+			end := strings.Index(challenge[1:], `"`)
+			if end < 0 {
+				value, challenge = challenge[1:], ""
+			} else {
+				value, challenge = challenge[1:end+1], challenge[end+2:]
+			}
+		} else {
+			end := strings.Index(challenge, ",")
+			if end < 0 {
+				end = len(challenge)
+			}
+			value, challenge = challenge[:end], challenge[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateManifestList(t *testing.T) {
+	assert := assert.New(t)
+
+	manifests := map[string]string{
+		"v1-linux-amd64": `{"schemaVersion":2,"layers":["amd64"]}`,
+		"v1-linux-arm64": `{"schemaVersion":2,"layers":["arm64"]}`,
+	}
+	var pushed manifestList
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			user, password, _ := r.BasicAuth()
+			assert.Equal("user:secret", user+":"+password)
+			assert.Equal("repository:org/fissile-myrole:pull,push", r.URL.Query().Get("scope"))
+			fmt.Fprint(w, `{"token": "granted"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer granted" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		reference := strings.TrimPrefix(r.URL.Path, "/v2/org/fissile-myrole/manifests/")
+		switch r.Method {
+		case "GET":
+			manifest, ok := manifests[reference]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", manifestMediaType)
+			w.Header().Set("Docker-Content-Digest", "sha256:"+reference)
+			fmt.Fprint(w, manifest)
+		case "PUT":
+			assert.Equal("v1", reference)
+			assert.Equal(manifestListMediaType, r.Header.Get("Content-Type"))
+			contents, _ := ioutil.ReadAll(r.Body)
+			assert.NoError(json.Unmarshal(contents, &pushed))
+			w.Header().Set("Docker-Content-Digest", "sha256:list")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+	name := registry + "/org/fissile-myrole:v1"
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+
+	digest, err := CreateManifestList(name, []PlatformImage{
+		{Image: name + "-linux-amd64", Platform: DefaultPlatform},
+		{Image: name + "-linux-arm64", Platform: arm64},
+	}, &RegistryAuth{Username: "user", Password: "secret"})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("sha256:list", digest)
+
+	assert.Equal(manifestListMediaType, pushed.MediaType)
+	if assert.Len(pushed.Manifests, 2) {
+		assert.Equal(&manifestListEntry{
+			MediaType: manifestMediaType,
+			Size:      int64(len(manifests["v1-linux-arm64"])),
+			Digest:    "sha256:v1-linux-arm64",
+			Platform:  arm64,
+		}, pushed.Manifests[1])
+	}
+
+	_, err = CreateManifestList(name, []PlatformImage{{Image: name + "-linux-s390x", Platform: DefaultPlatform}},
+		&RegistryAuth{Username: "user", Password: "secret"})
+	assert.EqualError(err, fmt.Sprintf("Error looking up the manifest of %s-linux-s390x: Unexpected status 404 Not Found", name))
+
+	_, err = CreateManifestList(name, []PlatformImage{{Image: "other:v1", Platform: DefaultPlatform}}, nil)
+	assert.EqualError(err, fmt.Sprintf("Image other:v1 is not in the repository of %s", name))
+}
+
+func TestRegistryClientRepositoryPath(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newRegistryClient(defaultRegistry, &RegistryAuth{})
+	assert.Equal("https://registry-1.docker.io/v2", client.baseURL)
+	assert.Equal("library/ubuntu", client.repositoryPath("ubuntu"))
+	assert.Equal("org/fissile-myrole", client.repositoryPath("org/fissile-myrole"))
+
+	client = newRegistryClient("registry.example.com:5000", &RegistryAuth{})
+	assert.Equal("https://registry.example.com:5000/v2", client.baseURL)
+	assert.Equal("org/fissile-myrole", client.repositoryPath("registry.example.com:5000/org/fissile-myrole"))
+}
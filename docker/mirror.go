@@ -0,0 +1,365 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ImageMirror is an image and the name it is copied to in another registry
+type ImageMirror struct {
+	Source string
+	Target string
+}
+
+// imageDescriptor refers to a blob, or a manifest, by its digest
+type imageDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+// imageManifest is the manifest of an image for a single platform
+type imageManifest struct {
+	Config imageDescriptor   `json:"config"`
+	Layers []imageDescriptor `json:"layers"`
+}
+
+// MirrorImages copies images between registries through the registry API,
+// within the transfer limits, without going through the docker daemon. The
+// images are copied by digest: manifest lists are copied with the manifests
+// of all their platforms, and the copies have the digests of the originals.
+// Blobs and manifests the target registry has already are not copied again,
+// so interrupted mirrors resume where they stopped. The credentials are those
+// of the docker client configuration, unless sourceAuth or targetAuth are
+// given. Failed copies are attempted again up to retries times. The digests
+// of the copied images are returned by target name.
+func MirrorImages(mirrors []ImageMirror, limits *TransferLimits, sourceAuth, targetAuth *RegistryAuth, retries int, output io.Writer) (map[string]string, error) {
+	if limits == nil {
+		limits = &TransferLimits{}
+	}
+
+	sources := make(map[string]string, len(mirrors))
+	var targetNames []string
+	for _, mirror := range mirrors {
+		sources[mirror.Target] = mirror.Source
+		targetNames = append(targetNames, mirror.Target)
+	}
+
+	var mutex sync.Mutex
+	digests := make(map[string]string, len(mirrors))
+
+	err := runTransfers(targetNames, limits, func(targetName string, meter *transferMeter) error {
+		stdoutWriter := NewFormattingWriter(output, coloredTransferStringFunc("mirror", targetName))
+		defer stdoutWriter.Close()
+
+		sourceName := sources[targetName]
+		return withRetries(retries, stdoutWriter, func() error {
+			m := &imageMirrorer{
+				source:    newRegistryClient(ImageRegistry(sourceName), sourceAuth),
+				target:    newRegistryClient(ImageRegistry(targetName), targetAuth),
+				meter:     meter,
+				bandwidth: limits.Bandwidth,
+				output:    stdoutWriter,
+			}
+
+			sourceRepository, sourceReference := splitImageTag(sourceName)
+			targetRepository, targetReference := splitImageTag(targetName)
+			if sourceReference == "" {
+				sourceReference = "latest"
+			}
+			if targetReference == "" {
+				targetReference = sourceReference
+			}
+
+			digest, err := m.mirrorManifest(sourceRepository, sourceReference, targetRepository, targetReference)
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			digests[targetName] = digest
+			mutex.Unlock()
+			return nil
+		})
+	})
+
+	return digests, err
+}
+
+// imageMirrorer copies the manifests and blobs of images between the
+// repositories of two registries
+type imageMirrorer struct {
+	source    *registryClient
+	target    *registryClient
+	meter     *transferMeter
+	bandwidth int64
+	output    io.Writer
+}
+
+// mirrorManifest copies a manifest, and everything it refers to, returning
+// its digest
+func (m *imageMirrorer) mirrorManifest(sourceRepository, sourceReference, targetRepository, targetReference string) (string, error) {
+	contents, mediaType, digest, err := m.source.getManifest(sourceRepository, sourceReference)
+	if err != nil {
+		return "", fmt.Errorf("Error getting the manifest %s of %s: %s", sourceReference, sourceRepository, err)
+	}
+
+	if present, err := m.target.hasManifest(targetRepository, targetReference, digest); err != nil {
+		return "", err
+	} else if present {
+		fmt.Fprintf(m.output, "Manifest %s is mirrored already\n", digest)
+		return digest, nil
+	}
+
+	switch mediaType {
+	case manifestListMediaType:
+		var list manifestList
+		if err := json.Unmarshal(contents, &list); err != nil {
+			return "", fmt.Errorf("Invalid manifest list %s: %s", digest, err)
+		}
+		for _, entry := range list.Manifests {
+			if _, err := m.mirrorManifest(sourceRepository, entry.Digest, targetRepository, entry.Digest); err != nil {
+				return "", err
+			}
+		}
+
+	case manifestMediaType:
+		var manifest imageManifest
+		if err := json.Unmarshal(contents, &manifest); err != nil {
+			return "", fmt.Errorf("Invalid manifest %s: %s", digest, err)
+		}
+		for _, blob := range append([]imageDescriptor{manifest.Config}, manifest.Layers...) {
+			if err := m.mirrorBlob(sourceRepository, targetRepository, blob); err != nil {
+				return "", fmt.Errorf("Error copying blob %s: %s", blob.Digest, err)
+			}
+		}
+
+	default:
+		return "", fmt.Errorf("Unsupported manifest type %s", mediaType)
+	}
+
+	targetDigest, err := m.target.putManifest(targetRepository, targetReference, mediaType, contents)
+	if err != nil {
+		return "", fmt.Errorf("Error pushing the manifest %s: %s", digest, err)
+	}
+	if targetDigest != digest {
+		return "", fmt.Errorf("The manifest %s was stored with the digest %s", digest, targetDigest)
+	}
+	fmt.Fprintf(m.output, "Mirrored manifest %s\n", digest)
+	return digest, nil
+}
+
+// mirrorBlob copies a blob, unless the target repository has it already
+func (m *imageMirrorer) mirrorBlob(sourceRepository, targetRepository string, blob imageDescriptor) error {
+	if present, err := m.target.hasBlob(targetRepository, blob.Digest); err != nil {
+		return err
+	} else if present {
+		return nil
+	}
+
+	reader, size, err := m.source.getBlob(sourceRepository, blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	metered := &meteredReader{reader: reader, meter: m.meter, bandwidth: m.bandwidth}
+	if err := m.target.putBlob(targetRepository, blob.Digest, metered, size); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.output, "Mirrored blob %s (%d bytes)\n", blob.Digest, size)
+	return nil
+}
+
+// meteredReader reports the bytes read to a transfer meter, and holds off
+// reading while the throughput is above the bandwidth
+type meteredReader struct {
+	reader    io.Reader
+	meter     *transferMeter
+	bandwidth int64
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	r.meter.waitBelow(r.bandwidth)
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.meter.add(int64(n))
+	}
+	return n, err
+}
+
+// getManifest gets a manifest, or manifest list, with its media type and
+// digest
+func (c *registryClient) getManifest(repository, reference string) ([]byte, string, string, error) {
+	path := fmt.Sprintf("/%s/manifests/%s", c.repositoryPath(repository), reference)
+	response, err := c.do("GET", path, repository, func(request *http.Request) {
+		request.Header.Set("Accept", manifestListMediaType+", "+manifestMediaType)
+	}, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("Unexpected status %s", response.Status)
+	}
+
+	sum := sha256.Sum256(contents)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return nil, "", "", fmt.Errorf("The manifest has the digest %s instead", digest)
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(response.Header.Get("Content-Type"), ";")[0])
+	return contents, mediaType, digest, nil
+}
+
+// hasManifest tells whether a reference of a repository refers to the
+// manifest of the given digest
+func (c *registryClient) hasManifest(repository, reference, digest string) (bool, error) {
+	path := fmt.Sprintf("/%s/manifests/%s", c.repositoryPath(repository), reference)
+	response, err := c.do("HEAD", path, repository, func(request *http.Request) {
+		request.Header.Set("Accept", manifestListMediaType+", "+manifestMediaType)
+	}, nil)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response.Header.Get("Docker-Content-Digest") == digest, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("Unexpected status %s looking up %s", response.Status, reference)
+}
+
+// putManifest uploads a manifest, or manifest list, and returns its digest
+func (c *registryClient) putManifest(repository, reference, mediaType string, contents []byte) (string, error) {
+	path := fmt.Sprintf("/%s/manifests/%s", c.repositoryPath(repository), reference)
+	response, err := c.do("PUT", path, repository, func(request *http.Request) {
+		request.Header.Set("Content-Type", mediaType)
+		request.ContentLength = int64(len(contents))
+	}, contents)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return "", fmt.Errorf("Unexpected status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(contents)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return digest, nil
+}
+
+// hasBlob tells whether a repository has a blob
+func (c *registryClient) hasBlob(repository, digest string) (bool, error) {
+	path := fmt.Sprintf("/%s/blobs/%s", c.repositoryPath(repository), digest)
+	response, err := c.do("HEAD", path, repository, func(*http.Request) {}, nil)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("Unexpected status %s looking up blob %s", response.Status, digest)
+}
+
+// getBlob starts downloading a blob, returning its contents and size
+func (c *registryClient) getBlob(repository, digest string) (io.ReadCloser, int64, error) {
+	path := fmt.Sprintf("/%s/blobs/%s", c.repositoryPath(repository), digest)
+	response, err := c.do("GET", path, repository, func(*http.Request) {}, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, 0, fmt.Errorf("Unexpected status %s downloading blob %s", response.Status, digest)
+	}
+	return response.Body, response.ContentLength, nil
+}
+
+// putBlob uploads a blob in a single request. The upload is started first,
+// which authenticates the client, so that the contents are only sent once.
+func (c *registryClient) putBlob(repository, digest string, contents io.Reader, size int64) error {
+	path := fmt.Sprintf("/%s/blobs/uploads/", c.repositoryPath(repository))
+	response, err := c.do("POST", path, repository, func(*http.Request) {}, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Unexpected status %s starting the upload", response.Status)
+	}
+
+	location, err := c.uploadURL(response.Header.Get("Location"), digest)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("PUT", location, contents)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.ContentLength = size
+	c.authorize(request)
+
+	response, err = c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Unexpected status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// uploadURL returns the URL completing an upload started at a location,
+// which may be relative to the registry
+func (c *registryClient) uploadURL(location, digest string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("The registry gave no location to upload to")
+	}
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", err
+	}
+	reference, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("Invalid upload location %s: %s", location, err)
+	}
+	upload := base.ResolveReference(reference)
+
+	query := upload.Query()
+	query.Set("digest", digest)
+	upload.RawQuery = query.Encode()
+	return upload.String(), nil
+}
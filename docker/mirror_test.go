@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry is a registry serving the blobs and manifests of a repository
+type fakeRegistry struct {
+	mutex     sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte // By tag and by digest
+	types     map[string]string // Media types by digest
+	uploads   int
+	failPuts  int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+}
+
+func fakeDigest(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (r *fakeRegistry) addManifest(tag, mediaType string, contents []byte) string {
+	digest := fakeDigest(contents)
+	r.manifests[tag] = contents
+	r.manifests[digest] = contents
+	r.types[digest] = mediaType
+	return digest
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	path := strings.TrimPrefix(request.URL.Path, "/v2/org/fissile-myrole/")
+	switch {
+	case strings.HasPrefix(path, "manifests/"):
+		reference := strings.TrimPrefix(path, "manifests/")
+		if request.Method == "PUT" {
+			contents, _ := ioutil.ReadAll(request.Body)
+			digest := r.addManifest(reference, request.Header.Get("Content-Type"), contents)
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		contents, ok := r.manifests[reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		digest := fakeDigest(contents)
+		w.Header().Set("Content-Type", r.types[digest])
+		w.Header().Set("Docker-Content-Digest", digest)
+		if request.Method == "GET" {
+			w.Write(contents)
+		}
+
+	case path == "blobs/uploads/":
+		w.Header().Set("Location", "/v2/org/fissile-myrole/blobs/uploads/1?state=x")
+		w.WriteHeader(http.StatusAccepted)
+
+	case strings.HasPrefix(path, "blobs/uploads/"):
+		if r.failPuts > 0 {
+			r.failPuts--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		contents, _ := ioutil.ReadAll(request.Body)
+		digest := request.URL.Query().Get("digest")
+		if fakeDigest(contents) != digest || request.URL.Query().Get("state") != "x" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = contents
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+
+	case strings.HasPrefix(path, "blobs/"):
+		contents, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(contents)))
+		if request.Method == "GET" {
+			w.Write(contents)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMirrorImages(t *testing.T) {
+	assert := assert.New(t)
+
+	saved := retryDelay
+	retryDelay = 0
+	defer func() { retryDelay = saved }()
+
+	source := newFakeRegistry()
+	var descriptors []string
+	for _, blob := range []string{"config", "layer1", "layer2"} {
+		contents := []byte(blob)
+		source.blobs[fakeDigest(contents)] = contents
+		descriptors = append(descriptors, fmt.Sprintf(`{"mediaType":"blob","size":%d,"digest":"%s"}`,
+			len(contents), fakeDigest(contents)))
+	}
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":%s,"layers":[%s]}`,
+		descriptors[0], strings.Join(descriptors[1:], ",")))
+	manifestDigest := source.addManifest("v1-linux-amd64", manifestMediaType, manifest)
+	list := []byte(fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"mediaType":"%s","size":%d,"digest":"%s","platform":{"os":"linux","architecture":"amd64"}}]}`,
+		manifestMediaType, len(manifest), manifestDigest))
+	listDigest := source.addManifest("v1", manifestListMediaType, list)
+
+	target := newFakeRegistry()
+	// The layer is there already, from another image
+	target.blobs[fakeDigest([]byte("layer1"))] = []byte("layer1")
+	target.failPuts = 1
+
+	sourceServer := httptest.NewServer(source)
+	defer sourceServer.Close()
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+
+	sourceName := strings.TrimPrefix(sourceServer.URL, "http://") + "/org/fissile-myrole:v1"
+	targetName := strings.TrimPrefix(targetServer.URL, "http://") + "/org/fissile-myrole:v1"
+
+	var output bytes.Buffer
+	digests, err := MirrorImages([]ImageMirror{{Source: sourceName, Target: targetName}}, nil, nil, nil, 1, &output)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(map[string]string{targetName: listDigest}, digests)
+	assert.Contains(output.String(), "retrying")
+
+	assert.Equal(list, target.manifests["v1"])
+	assert.Equal(manifest, target.manifests[manifestDigest])
+	assert.Len(target.blobs, 3)
+	assert.Equal(2, target.uploads, "Blobs the target has should not be uploaded")
+
+	output.Reset()
+	digests, err = MirrorImages([]ImageMirror{{Source: sourceName, Target: targetName}}, nil, nil, nil, 0, &output)
+	if assert.NoError(err) {
+		assert.Equal(map[string]string{targetName: listDigest}, digests)
+		assert.Contains(output.String(), "mirrored already")
+		assert.Equal(2, target.uploads, "Images mirrored already should not be copied again")
+	}
+
+	_, err = MirrorImages([]ImageMirror{{Source: strings.Replace(sourceName, ":v1", ":v2", 1), Target: targetName}},
+		nil, nil, nil, 0, &output)
+	assert.Error(err)
+}
@@ -0,0 +1,150 @@
+package docker
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Platform is an operating system and CPU architecture images are built for,
+// as in linux/amd64 or linux/arm64/v8
+type Platform struct {
+	OS           string `json:"os" yaml:"os"`
+	Architecture string `json:"architecture" yaml:"architecture"`
+	Variant      string `json:"variant,omitempty" yaml:"variant,omitempty"`
+}
+
+// DefaultPlatform is the platform images are built for unless told otherwise.
+// Its images, layers and compiled packages keep the names they had before
+// fissile knew of platforms.
+var DefaultPlatform = Platform{OS: "linux", Architecture: "amd64"}
+
+// NativePlatform returns the platform fissile runs on
+func NativePlatform() Platform {
+	return Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// ParsePlatform parses a platform given as <os>/<architecture>[/<variant>]
+func ParsePlatform(spec string) (Platform, error) {
+	parts := strings.Split(strings.TrimSpace(spec), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("Invalid platform '%s', expected <os>/<architecture>[/<variant>]", spec)
+	}
+
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		if parts[2] == "" {
+			return Platform{}, fmt.Errorf("Invalid platform '%s', expected <os>/<architecture>[/<variant>]", spec)
+		}
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// ParsePlatforms parses a comma separated list of platforms. An empty list
+// is the default platform.
+func ParsePlatforms(specs string) ([]Platform, error) {
+	var platforms []Platform
+	seen := make(map[Platform]bool)
+	for _, spec := range strings.Split(specs, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		platform, err := ParsePlatform(spec)
+		if err != nil {
+			return nil, err
+		}
+		if seen[platform] {
+			return nil, fmt.Errorf("Platform %s is given more than once", platform)
+		}
+		seen[platform] = true
+		platforms = append(platforms, platform)
+	}
+
+	if len(platforms) == 0 {
+		platforms = []Platform{DefaultPlatform}
+	}
+	return platforms, nil
+}
+
+// String returns the platform as <os>/<architecture>[/<variant>]
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+	}
+	return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+}
+
+// Suffix returns the platform in a form fit for image names, tags and
+// directory names, as in linux-arm64
+func (p Platform) Suffix() string {
+	return strings.Replace(p.String(), "/", "-", -1)
+}
+
+// Repository returns the repository the images of the platform are named
+// with: the repository itself for the default platform, the repository
+// suffixed with the platform for any other.
+func (p Platform) Repository(repository string) string {
+	if p == DefaultPlatform {
+		return repository
+	}
+	return fmt.Sprintf("%s-%s", repository, p.Suffix())
+}
+
+// Dir returns the directory holding what is built for the platform, such as
+// compiled packages: the directory itself for the default platform, the
+// directory suffixed with the platform for any other.
+func (p Platform) Dir(dir string) string {
+	if p == DefaultPlatform {
+		return dir
+	}
+	return fmt.Sprintf("%s-%s", dir, p.Suffix())
+}
+
+// File returns the file holding a report about what is built for the
+// platform: the file itself for the default platform, the file with the
+// platform inserted before its extension for any other.
+func (p Platform) File(file string) string {
+	if p == DefaultPlatform {
+		return file
+	}
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(file, ext), p.Suffix(), ext)
+}
+
+// ImageForPlatform picks the image of a platform out of a comma separated list
+// of images, each optionally prefixed with the platform it is for, as in
+// ubuntu:14.04,linux/arm64=arm64v8/ubuntu:14.04. The image without a platform
+// is used for the platforms not listed.
+func ImageForPlatform(spec string, platform Platform) (string, error) {
+	var defaultImage string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		at := strings.Index(entry, "=")
+		if at < 0 {
+			if defaultImage != "" {
+				return "", fmt.Errorf("Invalid images '%s', more than one image without a platform", spec)
+			}
+			defaultImage = entry
+			continue
+		}
+
+		entryPlatform, err := ParsePlatform(entry[:at])
+		if err != nil {
+			return "", err
+		}
+		if entryPlatform == platform {
+			return entry[at+1:], nil
+		}
+	}
+
+	if defaultImage == "" {
+		return "", fmt.Errorf("No image given for platform %s in '%s'", platform, spec)
+	}
+	return defaultImage, nil
+}
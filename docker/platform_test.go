@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	assert := assert.New(t)
+
+	platforms, err := ParsePlatforms("linux/arm64, linux/amd64,linux/arm/v7")
+	if assert.NoError(err) {
+		assert.Equal([]Platform{
+			{OS: "linux", Architecture: "arm64"},
+			DefaultPlatform,
+			{OS: "linux", Architecture: "arm", Variant: "v7"},
+		}, platforms)
+		assert.Equal("linux/arm/v7", platforms[2].String())
+		assert.Equal("linux-arm-v7", platforms[2].Suffix())
+	}
+
+	platforms, err = ParsePlatforms("")
+	if assert.NoError(err) {
+		assert.Equal([]Platform{DefaultPlatform}, platforms)
+	}
+
+	_, err = ParsePlatforms("arm64")
+	assert.EqualError(err, "Invalid platform 'arm64', expected <os>/<architecture>[/<variant>]")
+
+	_, err = ParsePlatforms("linux/arm64,linux/arm64")
+	assert.EqualError(err, "Platform linux/arm64 is given more than once")
+}
+
+func TestPlatformNames(t *testing.T) {
+	assert := assert.New(t)
+
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+	assert.Equal("fissile", DefaultPlatform.Repository("fissile"))
+	assert.Equal("fissile-linux-arm64", arm64.Repository("fissile"))
+	assert.Equal("/work/compilation", DefaultPlatform.Dir("/work/compilation"))
+	assert.Equal("/work/compilation-linux-arm64", arm64.Dir("/work/compilation"))
+	assert.Equal("/work/stats.json", DefaultPlatform.File("/work/stats.json"))
+	assert.Equal("/work/stats-linux-arm64.json", arm64.File("/work/stats.json"))
+}
+
+func TestImageForPlatform(t *testing.T) {
+	assert := assert.New(t)
+
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+	spec := "ubuntu:14.04,linux/arm64=arm64v8/ubuntu:14.04"
+
+	image, err := ImageForPlatform(spec, DefaultPlatform)
+	if assert.NoError(err) {
+		assert.Equal("ubuntu:14.04", image)
+	}
+	image, err = ImageForPlatform(spec, arm64)
+	if assert.NoError(err) {
+		assert.Equal("arm64v8/ubuntu:14.04", image)
+	}
+
+	_, err = ImageForPlatform("linux/arm64=arm64v8/ubuntu:14.04", DefaultPlatform)
+	assert.EqualError(err, "No image given for platform linux/amd64 in 'linux/arm64=arm64v8/ubuntu:14.04'")
+
+	_, err = ImageForPlatform("ubuntu:14.04,ubuntu:16.04", arm64)
+	assert.EqualError(err, "Invalid images 'ubuntu:14.04,ubuntu:16.04', more than one image without a platform")
+}
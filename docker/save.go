@@ -0,0 +1,314 @@
+package docker
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// Media types of the OCI image layout written by OCILayout
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+	ociLayoutVersion     = "1.0.0"
+	// OCIRefNameAnnotation names an image in the index of an OCI image layout
+	OCIRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// SaveImage writes an image as a `docker save` tarball to output
+func (d *ImageManager) SaveImage(imageName string, output io.Writer) error {
+	return d.client.ExportImage(dockerclient.ExportImageOptions{
+		Name:         imageName,
+		OutputStream: output,
+	})
+}
+
+// ociDescriptor describes a blob of an OCI image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is an OCI image manifest
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociIndex is the index.json of an OCI image layout
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// dockerArchiveManifest is an entry of the manifest.json of a `docker save`
+// tarball
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// OCILayout is a directory holding images in the OCI image layout. Blobs
+// shared by several images are stored once.
+type OCILayout struct {
+	dir   string
+	index ociIndex
+}
+
+// NewOCILayout creates an OCI image layout in dir, or opens the one found there
+func NewOCILayout(dir string) (*OCILayout, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return nil, err
+	}
+
+	layout := &OCILayout{dir: dir, index: ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{}}}
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err == nil {
+		if err := json.Unmarshal(contents, &layout.index); err != nil {
+			return nil, fmt.Errorf("Error reading the index of OCI image layout %s: %s", dir, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	marker, err := json.Marshal(map[string]string{"imageLayoutVersion": ociLayoutVersion})
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), marker, 0644); err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
+
+// AddDockerArchive adds the image of a `docker save` tarball to the layout,
+// named refName in the index, replacing any image of the same name. It
+// returns the digest of the image manifest.
+func (l *OCILayout) AddDockerArchive(archive io.Reader, refName string) (string, error) {
+	extractDir, err := ioutil.TempDir(l.dir, ".import-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(extractDir)
+
+	if err := extractDockerArchive(archive, extractDir); err != nil {
+		return "", fmt.Errorf("Error reading the image archive of %s: %s", refName, err)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(extractDir, "manifest.json"))
+	if err != nil {
+		return "", fmt.Errorf("Error reading the image archive of %s: %s", refName, err)
+	}
+	var archiveManifests []dockerArchiveManifest
+	if err := json.Unmarshal(contents, &archiveManifests); err != nil {
+		return "", fmt.Errorf("Error reading the image archive of %s: %s", refName, err)
+	}
+	if len(archiveManifests) != 1 {
+		return "", fmt.Errorf("Expected one image in the image archive of %s, found %d", refName, len(archiveManifests))
+	}
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Layers:        []ociDescriptor{},
+	}
+	manifest.Config, err = l.addBlobFile(filepath.Join(extractDir, archiveManifests[0].Config), ociConfigMediaType)
+	if err != nil {
+		return "", err
+	}
+	// Layers may be listed more than once, their file is moved the first time
+	layers := make(map[string]ociDescriptor)
+	for _, layer := range archiveManifests[0].Layers {
+		descriptor, ok := layers[layer]
+		if !ok {
+			descriptor, err = l.addBlobFile(filepath.Join(extractDir, layer), ociLayerMediaType)
+			if err != nil {
+				return "", err
+			}
+			layers[layer] = descriptor
+		}
+		manifest.Layers = append(manifest.Layers, descriptor)
+	}
+
+	manifestContents, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	descriptor, err := l.addBlob(manifestContents, ociManifestMediaType)
+	if err != nil {
+		return "", err
+	}
+	descriptor.Annotations = map[string]string{OCIRefNameAnnotation: refName}
+
+	manifests := []ociDescriptor{}
+	for _, existing := range l.index.Manifests {
+		if existing.Annotations[OCIRefNameAnnotation] != refName {
+			manifests = append(manifests, existing)
+		}
+	}
+	l.index.Manifests = append(manifests, descriptor)
+
+	return descriptor.Digest, nil
+}
+
+// WriteIndex writes the index.json listing the images of the layout
+func (l *OCILayout) WriteIndex() error {
+	contents, err := json.MarshalIndent(l.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(l.dir, "index.json"), contents, 0644)
+}
+
+// addBlob stores contents as a blob of the layout
+func (l *OCILayout) addBlob(contents []byte, mediaType string) (ociDescriptor, error) {
+	sum := sha256.Sum256(contents)
+	digest := hex.EncodeToString(sum[:])
+
+	err := ioutil.WriteFile(filepath.Join(l.dir, "blobs", "sha256", digest), contents, 0644)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(contents))}, nil
+}
+
+// addBlobFile moves a file into the blobs of the layout
+func (l *OCILayout) addBlobFile(path, mediaType string) (ociDescriptor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	blobPath := filepath.Join(l.dir, "blobs", "sha256", digest)
+	if _, err := os.Stat(blobPath); os.IsNotExist(err) {
+		if err := os.Rename(path, blobPath); err != nil {
+			return ociDescriptor{}, err
+		}
+	} else if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: size}, nil
+}
+
+// extractDockerArchive extracts the files of a `docker save` tarball into dir.
+// Layers shared within the archive are symlinks to the first copy, so links
+// are replaced by the file they point to.
+func extractDockerArchive(archive io.Reader, dir string) error {
+	links := make(map[string]string)
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := archivePath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			file, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(file, reader); err != nil {
+				file.Close()
+				return err
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target, err := archivePath(dir, filepath.Join(filepath.Dir(header.Name), header.Linkname))
+			if err != nil {
+				return err
+			}
+			links[path] = target
+		case tar.TypeLink:
+			target, err := archivePath(dir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			links[path] = target
+		}
+	}
+
+	for path, target := range links {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(target, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archivePath returns where a file of an archive is extracted to, refusing
+// files outside of dir
+func archivePath(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("Invalid file %s in image archive", name)
+	}
+	return path, nil
+}
+
+// copyFile copies the file at source to destination
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
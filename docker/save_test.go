@@ -0,0 +1,174 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dockerArchive returns a `docker save` tarball of an image whose second
+// layer is a symlink to the first
+func dockerArchive(repoTag string) []byte {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+
+	addFile := func(name string, contents []byte) {
+		writer.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			Typeflag: tar.TypeReg,
+		})
+		writer.Write(contents)
+	}
+
+	manifest, _ := json.Marshal([]dockerArchiveManifest{{
+		Config:   "config.json",
+		RepoTags: []string{repoTag},
+		Layers:   []string{"aaaa/layer.tar", "bbbb/layer.tar", "aaaa/layer.tar"},
+	}})
+
+	addFile("config.json", []byte(`{"architecture":"amd64"}`))
+	writer.WriteHeader(&tar.Header{Name: "aaaa/", Mode: 0755, Typeflag: tar.TypeDir})
+	addFile("aaaa/layer.tar", []byte("layer contents"))
+	writer.WriteHeader(&tar.Header{
+		Name:     "bbbb/layer.tar",
+		Linkname: "../aaaa/layer.tar",
+		Mode:     0644,
+		Typeflag: tar.TypeSymlink,
+	})
+	addFile("manifest.json", manifest)
+	writer.Close()
+
+	return buf.Bytes()
+}
+
+func digestOf(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestOCILayoutAddDockerArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-oci-layout-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	layout, err := NewOCILayout(dir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	digest, err := layout.AddDockerArchive(bytes.NewReader(dockerArchive("fissile-myrole:1234")), "fissile-myrole:1234")
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = layout.AddDockerArchive(bytes.NewReader(dockerArchive("fissile-foorole:5678")), "fissile-foorole:5678")
+	if !assert.NoError(err) {
+		return
+	}
+	// Saving an image again replaces it in the index
+	_, err = layout.AddDockerArchive(bytes.NewReader(dockerArchive("fissile-myrole:1234")), "fissile-myrole:1234")
+	if !assert.NoError(err) {
+		return
+	}
+	if !assert.NoError(layout.WriteIndex()) {
+		return
+	}
+
+	marker, err := ioutil.ReadFile(filepath.Join(dir, "oci-layout"))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.JSONEq(`{"imageLayoutVersion":"1.0.0"}`, string(marker))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if !assert.NoError(err) {
+		return
+	}
+	var index ociIndex
+	if !assert.NoError(json.Unmarshal(contents, &index)) {
+		return
+	}
+	if !assert.Len(index.Manifests, 2) {
+		return
+	}
+	assert.Equal("fissile-foorole:5678", index.Manifests[0].Annotations[OCIRefNameAnnotation])
+	assert.Equal("fissile-myrole:1234", index.Manifests[1].Annotations[OCIRefNameAnnotation])
+	assert.Equal(digest, index.Manifests[1].Digest)
+	assert.Equal(ociManifestMediaType, index.Manifests[1].MediaType)
+
+	contents, err = ioutil.ReadFile(filepath.Join(dir, "blobs", "sha256", digest[len("sha256:"):]))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(digestOf(string(contents)), digest)
+	var manifest ociManifest
+	if !assert.NoError(json.Unmarshal(contents, &manifest)) {
+		return
+	}
+	assert.Equal(digestOf(`{"architecture":"amd64"}`), manifest.Config.Digest)
+	assert.Equal(ociConfigMediaType, manifest.Config.MediaType)
+	if assert.Len(manifest.Layers, 3) {
+		for _, layer := range manifest.Layers {
+			assert.Equal(digestOf("layer contents"), layer.Digest)
+			assert.Equal(int64(len("layer contents")), layer.Size)
+			assert.Equal(ociLayerMediaType, layer.MediaType)
+		}
+	}
+
+	layer, err := ioutil.ReadFile(filepath.Join(dir, "blobs", "sha256", manifest.Layers[0].Digest[len("sha256:"):]))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("layer contents", string(layer))
+
+	// The extracted archives are removed
+	entries, err := ioutil.ReadDir(dir)
+	if !assert.NoError(err) {
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal([]string{"blobs", "index.json", "oci-layout"}, names)
+}
+
+func TestOCILayoutAddDockerArchiveInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-oci-layout-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	layout, err := NewOCILayout(dir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	if !assert.NoError(writer.WriteHeader(&tar.Header{Name: "../escape", Mode: 0644, Typeflag: tar.TypeReg})) {
+		return
+	}
+	if !assert.NoError(writer.Close()) {
+		return
+	}
+
+	_, err = layout.AddDockerArchive(&buf, "fissile-myrole:1234")
+	assert.EqualError(err, "Error reading the image archive of fissile-myrole:1234: Invalid file ../escape in image archive")
+}
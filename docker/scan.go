@@ -0,0 +1,185 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// The severities of vulnerabilities, from the lowest
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// SeverityLevel returns the rank of a severity, from 0 for the lowest; -1 if
+// it is not a known severity
+func SeverityLevel(severity string) int {
+	for level, known := range severities {
+		if strings.EqualFold(severity, known) {
+			return level
+		}
+	}
+	return -1
+}
+
+// ValidateSeverity checks that a severity is known
+func ValidateSeverity(severity string) error {
+	if SeverityLevel(severity) < 0 {
+		return fmt.Errorf("Invalid severity '%s', expected one of %s", severity, strings.Join(severities, ", "))
+	}
+	return nil
+}
+
+// Vulnerability is a vulnerability found in a package of an image
+type Vulnerability struct {
+	ID               string `json:"id" yaml:"id"`
+	Package          string `json:"package" yaml:"package"`
+	InstalledVersion string `json:"installed_version" yaml:"installed_version"`
+	FixedVersion     string `json:"fixed_version,omitempty" yaml:"fixed_version,omitempty"` // Empty if there is no fix
+	Severity         string `json:"severity" yaml:"severity"`
+	Title            string `json:"title,omitempty" yaml:"title,omitempty"`
+}
+
+// Key identifies a vulnerability of a package, whatever the image it is in
+func (v *Vulnerability) Key() string {
+	return fmt.Sprintf("%s/%s", v.Package, v.ID)
+}
+
+// ImageScanner finds the vulnerabilities of images
+type ImageScanner interface {
+	// Name returns the name of the scanner
+	Name() string
+	// ScanImage returns the vulnerabilities of a local image, the most
+	// severe first
+	ScanImage(imageName string) ([]*Vulnerability, error)
+}
+
+// imageScanners creates the scanners by name, running the given command
+var imageScanners = map[string]func(command string) ImageScanner{
+	"trivy": func(command string) ImageScanner {
+		if command == "" {
+			command = "trivy"
+		}
+		return &trivyScanner{command: command}
+	},
+}
+
+// ImageScannerNames returns the names of the image scanners
+func ImageScannerNames() []string {
+	var names []string
+	for name := range imageScanners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewImageScanner returns the named image scanner. The command is the path of
+// its executable, the usual one if empty.
+func NewImageScanner(name, command string) (ImageScanner, error) {
+	newScanner, ok := imageScanners[name]
+	if !ok {
+		return nil, fmt.Errorf("Invalid scanner '%s', expected one of %s", name, strings.Join(ImageScannerNames(), ", "))
+	}
+	return newScanner(command), nil
+}
+
+// sortVulnerabilities sorts vulnerabilities, the most severe first, then by
+// package and ID
+func sortVulnerabilities(vulnerabilities []*Vulnerability) {
+	sort.Slice(vulnerabilities, func(i, j int) bool {
+		a, b := vulnerabilities[i], vulnerabilities[j]
+		if levelA, levelB := SeverityLevel(a.Severity), SeverityLevel(b.Severity); levelA != levelB {
+			return levelA > levelB
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.ID < b.ID
+	})
+}
+
+// trivyScanner scans images with Trivy, https://github.com/aquasecurity/trivy
+type trivyScanner struct {
+	command string
+}
+
+func (s *trivyScanner) Name() string {
+	return "trivy"
+}
+
+func (s *trivyScanner) ScanImage(imageName string) ([]*Vulnerability, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(s.command, "image", "--quiet", "--format", "json", imageName)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("Error scanning image %s with trivy: %s\n%s", imageName, err, stderr.String())
+	}
+
+	vulnerabilities, err := parseTrivyReport(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the trivy report of image %s: %s", imageName, err)
+	}
+	return vulnerabilities, nil
+}
+
+// trivyResult is the report of Trivy on a target of an image, such as its OS
+// packages or the gems of an application
+type trivyResult struct {
+	Target          string `json:"Target"`
+	Vulnerabilities []struct {
+		VulnerabilityID  string `json:"VulnerabilityID"`
+		PkgName          string `json:"PkgName"`
+		InstalledVersion string `json:"InstalledVersion"`
+		FixedVersion     string `json:"FixedVersion"`
+		Severity         string `json:"Severity"`
+		Title            string `json:"Title"`
+	} `json:"Vulnerabilities"`
+}
+
+// parseTrivyReport reads the vulnerabilities of a Trivy JSON report, either a
+// list of results, as written by older versions, or an object holding them
+func parseTrivyReport(report []byte) ([]*Vulnerability, error) {
+	var results []trivyResult
+	if trimmed := bytes.TrimSpace(report); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &results); err != nil {
+			return nil, err
+		}
+	} else {
+		var wrapper struct {
+			Results []trivyResult `json:"Results"`
+		}
+		if err := json.Unmarshal(trimmed, &wrapper); err != nil {
+			return nil, err
+		}
+		results = wrapper.Results
+	}
+
+	// The same vulnerability may be reported by several targets
+	seen := make(map[string]bool)
+	var vulnerabilities []*Vulnerability
+	for _, result := range results {
+		for _, entry := range result.Vulnerabilities {
+			vulnerability := &Vulnerability{
+				ID:               entry.VulnerabilityID,
+				Package:          entry.PkgName,
+				InstalledVersion: entry.InstalledVersion,
+				FixedVersion:     entry.FixedVersion,
+				Severity:         strings.ToUpper(entry.Severity),
+				Title:            entry.Title,
+			}
+			if SeverityLevel(vulnerability.Severity) < 0 {
+				vulnerability.Severity = "UNKNOWN"
+			}
+			if seen[vulnerability.Key()] {
+				continue
+			}
+			seen[vulnerability.Key()] = true
+			vulnerabilities = append(vulnerabilities, vulnerability)
+		}
+	}
+	sortVulnerabilities(vulnerabilities)
+	return vulnerabilities, nil
+}
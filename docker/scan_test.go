@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTrivyReport(t *testing.T) {
+	assert := assert.New(t)
+
+	expected := []*Vulnerability{
+		{ID: "CVE-2018-2", Package: "openssl", InstalledVersion: "1.0.1f", FixedVersion: "1.0.1g", Severity: "CRITICAL"},
+		{ID: "CVE-2018-1", Package: "bash", InstalledVersion: "4.3", Severity: "MEDIUM", Title: "A bug"},
+		{ID: "CVE-2018-3", Package: "zlib", InstalledVersion: "1.2", Severity: "UNKNOWN"},
+	}
+
+	vulnerabilities, err := parseTrivyReport([]byte(`{
+		"SchemaVersion": 2,
+		"Results": [
+			{
+				"Target": "fissile-role-base:1.0 (ubuntu 14.04)",
+				"Vulnerabilities": [
+					{"VulnerabilityID": "CVE-2018-1", "PkgName": "bash", "InstalledVersion": "4.3", "Severity": "MEDIUM", "Title": "A bug"},
+					{"VulnerabilityID": "CVE-2018-2", "PkgName": "openssl", "InstalledVersion": "1.0.1f", "FixedVersion": "1.0.1g", "Severity": "CRITICAL"},
+					{"VulnerabilityID": "CVE-2018-3", "PkgName": "zlib", "InstalledVersion": "1.2", "Severity": "odd"}
+				]
+			},
+			{"Target": "gems", "Vulnerabilities": [
+				{"VulnerabilityID": "CVE-2018-1", "PkgName": "bash", "InstalledVersion": "4.3", "Severity": "MEDIUM", "Title": "A bug"}
+			]}
+		]
+	}`))
+	if assert.NoError(err) {
+		assert.Equal(expected, vulnerabilities)
+	}
+
+	// Older versions write a list of results
+	vulnerabilities, err = parseTrivyReport([]byte(`[{"Target": "image", "Vulnerabilities": [
+		{"VulnerabilityID": "CVE-2018-2", "PkgName": "openssl", "InstalledVersion": "1.0.1f", "FixedVersion": "1.0.1g", "Severity": "CRITICAL"}
+	]}]`))
+	if assert.NoError(err) {
+		assert.Equal(expected[:1], vulnerabilities)
+	}
+
+	_, err = parseTrivyReport([]byte("not json"))
+	assert.Error(err)
+}
+
+func TestNewImageScanner(t *testing.T) {
+	assert := assert.New(t)
+
+	scanner, err := NewImageScanner("trivy", "")
+	if assert.NoError(err) {
+		assert.Equal("trivy", scanner.Name())
+		assert.Equal("trivy", scanner.(*trivyScanner).command)
+	}
+
+	_, err = NewImageScanner("clair", "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Invalid scanner 'clair', expected one of trivy")
+	}
+
+	assert.Equal(4, SeverityLevel("critical"))
+	assert.Equal(-1, SeverityLevel("severe"))
+	assert.Error(ValidateSeverity("severe"))
+}
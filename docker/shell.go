@@ -0,0 +1,95 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"syscall"
+)
+
+// ShellOpts describes an interactive shell in a new container
+type ShellOpts struct {
+	ContainerName string
+	ImageName     string
+	// Mount points, src -> dest; ContainerInPath is mounted read-only
+	Mounts map[string]string
+	// Container paths of anonymous volumes, removed along with the container
+	Volumes []string
+	// Environment variables, in addition to HOST_USERID and HOST_USERGID
+	Env map[string]string
+	// Runs the shell instead of the entrypoint of the image
+	ResetEntrypoint bool
+	// The shell to run; bash if empty
+	Shell      string
+	WorkingDir string
+}
+
+// shellArgs returns the arguments of the docker command line starting an
+// interactive shell
+func shellArgs(opts ShellOpts) []string {
+	args := []string{"run", "--rm", "--interactive", "--tty"}
+	if opts.ContainerName != "" {
+		args = append(args, "--name", opts.ContainerName)
+	}
+	if opts.WorkingDir != "" {
+		args = append(args, "--workdir", opts.WorkingDir)
+	}
+	if opts.ResetEntrypoint {
+		args = append(args, "--entrypoint=")
+	}
+
+	args = append(args,
+		"--env", fmt.Sprintf("HOST_USERID=%d", syscall.Geteuid()),
+		"--env", fmt.Sprintf("HOST_USERGID=%d", syscall.Getegid()),
+	)
+	names := make([]string, 0, len(opts.Env))
+	for name := range opts.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--env", fmt.Sprintf("%s=%s", name, opts.Env[name]))
+	}
+
+	sources := make([]string, 0, len(opts.Mounts))
+	for src := range opts.Mounts {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+	for _, src := range sources {
+		mountString := fmt.Sprintf("%s:%s", src, opts.Mounts[src])
+		if opts.Mounts[src] == ContainerInPath {
+			mountString += ":ro"
+		}
+		args = append(args, "--volume", mountString)
+	}
+	for _, volume := range opts.Volumes {
+		args = append(args, "--volume", volume)
+	}
+
+	shell := opts.Shell
+	if shell == "" {
+		shell = "bash"
+	}
+	return append(args, opts.ImageName, shell)
+}
+
+// RunShell starts a container and attaches the terminal to a shell in it,
+// until the shell exits. The container is removed afterwards.
+func RunShell(opts ShellOpts, stdin io.Reader, stdout, stderr io.Writer) error {
+	// The docker client does not handle terminals, use the docker command
+	command := exec.Command("docker", shellArgs(opts)...)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+
+	if err := command.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// The shell reports its own failures
+			return nil
+		}
+		return fmt.Errorf("Error running a shell in %s: %s", opts.ImageName, err)
+	}
+	return nil
+}
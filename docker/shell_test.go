@@ -0,0 +1,42 @@
+package docker
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	args := shellArgs(ShellOpts{
+		ContainerName: "shell",
+		ImageName:     "fissile-cbase:1.0",
+		Mounts: map[string]string{
+			"/work/sources":  ContainerInPath,
+			"/work/compiled": ContainerOutPath,
+		},
+		Volumes:    []string{"/var/vcap/source"},
+		Env:        map[string]string{"B": "2", "A": "1"},
+		WorkingDir: ContainerInPath,
+	})
+	assert.Equal([]string{
+		"run", "--rm", "--interactive", "--tty",
+		"--name", "shell",
+		"--workdir", ContainerInPath,
+		"--env", fmt.Sprintf("HOST_USERID=%d", syscall.Geteuid()),
+		"--env", fmt.Sprintf("HOST_USERGID=%d", syscall.Getegid()),
+		"--env", "A=1",
+		"--env", "B=2",
+		"--volume", "/work/compiled:" + ContainerOutPath,
+		"--volume", "/work/sources:" + ContainerInPath + ":ro",
+		"--volume", "/var/vcap/source",
+		"fissile-cbase:1.0", "bash",
+	}, args)
+
+	args = shellArgs(ShellOpts{ImageName: "role:1.0", ResetEntrypoint: true, Shell: "sh"})
+	assert.Equal("--entrypoint=", args[4])
+	assert.Equal([]string{"role:1.0", "sh"}, args[len(args)-2:])
+}
@@ -0,0 +1,293 @@
+package docker
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// squashedLayerPath is the path of the merged layer in the image archive
+// loaded in place of a squashed image
+const squashedLayerPath = "squashed/layer.tar"
+
+// SquashImage merges the layers an image adds to its parent image into a
+// single one, replacing the image. The layers of the parent are kept, to be
+// shared with the other images built on it; without a parent, all the layers
+// of the image are merged.
+func (d *ImageManager) SquashImage(imageName, parentImageName string) error {
+	var parentLayers []string
+	if parentImageName != "" {
+		parent, err := d.client.InspectImage(parentImageName)
+		if err != nil {
+			return fmt.Errorf("Error squashing image %s: %s", imageName, err)
+		}
+		if parent.RootFS == nil {
+			return fmt.Errorf("Error squashing image %s: the layers of its parent %s are unknown", imageName, parentImageName)
+		}
+		parentLayers = parent.RootFS.Layers
+	}
+
+	old, err := d.client.InspectImage(imageName)
+	if err != nil {
+		return fmt.Errorf("Error squashing image %s: %s", imageName, err)
+	}
+
+	dir, err := ioutil.TempDir("", "fissile-squash-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	image, err := d.saveImageToDir(imageName, dir)
+	if err != nil {
+		return err
+	}
+	keep := len(parentLayers)
+	if len(image.rootFS.DiffIDs) < keep || strings.Join(image.rootFS.DiffIDs[:keep], ",") != strings.Join(parentLayers, ",") {
+		return fmt.Errorf("Error squashing image %s: it is not built on %s", imageName, parentImageName)
+	}
+	if len(image.manifest.Layers)-keep < 2 {
+		// Nothing to merge
+		return nil
+	}
+
+	if err := squashSavedImage(image, keep, imageName); err != nil {
+		return fmt.Errorf("Error squashing image %s: %s", imageName, err)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeSavedImage(writer, image))
+	}()
+	err = d.client.LoadImage(dockerclient.LoadImageOptions{InputStream: reader})
+	reader.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("Error loading squashed image %s: %s", imageName, err)
+	}
+
+	// The image replaced is left alone if anything still uses it
+	d.client.RemoveImage(old.ID)
+	return nil
+}
+
+// squashSavedImage merges the layers of a saved image above the first keep
+// ones into one, updating its manifest and config
+func squashSavedImage(image *savedImage, keep int, repoTag string) error {
+	merged := image.manifest.Layers[keep:]
+	if err := os.MkdirAll(filepath.Join(image.dir, path.Dir(squashedLayerPath)), 0755); err != nil {
+		return err
+	}
+	diffID, err := mergeLayers(image.dir, merged, filepath.Join(image.dir, squashedLayerPath))
+	if err != nil {
+		return err
+	}
+
+	image.rootFS.DiffIDs = append(image.rootFS.DiffIDs[:keep:keep], diffID)
+	image.manifest.Layers = append(image.manifest.Layers[:keep:keep], squashedLayerPath)
+	image.manifest.RepoTags = []string{repoTag}
+
+	if len(image.history) > 0 {
+		var created string
+		json.Unmarshal(image.config["created"], &created)
+
+		// Entries without layers describe the config, they are kept
+		var history []imageHistory
+		layers := 0
+		for _, entry := range image.history {
+			if !entry.EmptyLayer {
+				layers++
+				if layers > keep {
+					continue
+				}
+			}
+			history = append(history, entry)
+		}
+		image.history = append(history, imageHistory{
+			Created:   created,
+			CreatedBy: "fissile squash",
+			Comment:   fmt.Sprintf("%d layers merged", len(merged)),
+		})
+
+		contents, err := json.Marshal(image.history)
+		if err != nil {
+			return err
+		}
+		image.config["history"] = contents
+	}
+
+	contents, err := json.Marshal(image.rootFS)
+	if err != nil {
+		return err
+	}
+	image.config["rootfs"] = contents
+
+	if contents, err = json.Marshal(image.config); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(contents)
+	image.manifest.Config = hex.EncodeToString(sum[:]) + ".json"
+	return ioutil.WriteFile(filepath.Join(image.dir, image.manifest.Config), contents, 0644)
+}
+
+// mergeLayers writes the layer holding the files of the given layers, from
+// the lowest, to output, and returns its diff ID. Files hidden by later
+// layers are left out; whiteouts are kept, as they may hide files of the
+// layers below.
+func mergeLayers(dir string, layers []string, output string) (string, error) {
+	// The layer whose copy of each entry is kept
+	winners := make(map[string]int)
+	// Directories removed, then created again; those of the layers below
+	// have to be hidden
+	opaque := make(map[string]bool)
+	dropUnder := func(dir string, layer int) {
+		for name, winner := range winners {
+			if winner < layer && isUnder(name, dir) {
+				delete(winners, name)
+				delete(opaque, name)
+			}
+		}
+	}
+
+	for index, layerPath := range layers {
+		err := walkLayer(filepath.Join(dir, layerPath), func(name string, header *tar.Header, _ io.Reader) error {
+			parent, base := path.Dir(name), path.Base(name)
+			if parent == "." {
+				parent = ""
+			}
+			switch {
+			case base == whiteoutOpaque:
+				dropUnder(parent, index)
+			case strings.HasPrefix(base, whiteoutPrefix):
+				removed := path.Join(parent, strings.TrimPrefix(base, whiteoutPrefix))
+				delete(winners, removed)
+				dropUnder(removed, index)
+			default:
+				whiteout := path.Join(parent, whiteoutPrefix+base)
+				if _, ok := winners[whiteout]; ok {
+					// A layer can't both remove and hold a file
+					delete(winners, whiteout)
+					if header.Typeflag == tar.TypeDir {
+						opaque[name] = true
+					}
+				}
+			}
+			winners[name] = index
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	writer := tar.NewWriter(io.MultiWriter(file, hash))
+
+	// Hard links are written last, once the files they link to are
+	var links []*tar.Header
+	for index, layerPath := range layers {
+		err := walkLayer(filepath.Join(dir, layerPath), func(name string, header *tar.Header, contents io.Reader) error {
+			if winner, ok := winners[name]; !ok || winner != index {
+				return nil
+			}
+			if header.Typeflag == tar.TypeLink {
+				links = append(links, header)
+				return nil
+			}
+			if err := writer.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(writer, contents); err != nil {
+				return err
+			}
+			if opaque[name] {
+				return writer.WriteHeader(&tar.Header{
+					Name:     path.Join(name, whiteoutOpaque),
+					Mode:     0644,
+					Typeflag: tar.TypeReg,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, link := range links {
+		if err := writer.WriteHeader(link); err != nil {
+			return "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeSavedImage writes a saved image as a `docker save` tarball
+func writeSavedImage(output io.Writer, image *savedImage) error {
+	writer := tar.NewWriter(output)
+
+	addFile := func(name string) error {
+		file, err := os.Open(filepath.Join(image.dir, name))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, file)
+		return err
+	}
+
+	if err := addFile(image.manifest.Config); err != nil {
+		return err
+	}
+	// Layers may be listed more than once
+	written := make(map[string]bool)
+	for _, layer := range image.manifest.Layers {
+		if written[layer] {
+			continue
+		}
+		if err := addFile(layer); err != nil {
+			return err
+		}
+		written[layer] = true
+	}
+
+	manifest, err := json.Marshal([]dockerArchiveManifest{image.manifest})
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest)), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	if _, err := writer.Write(manifest); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,88 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSquashSavedImage(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-squash-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if !assert.NoError(writeTestImage(dir, testImageLayers...)) {
+		return
+	}
+	image, err := readSavedImage(dir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// The layers above the first are merged
+	if !assert.NoError(squashSavedImage(image, 1, "fissile-role:squashed")) {
+		return
+	}
+	assert.Equal([]string{"a/layer.tar", squashedLayerPath}, image.manifest.Layers)
+	assert.Equal([]string{"fissile-role:squashed"}, image.manifest.RepoTags)
+
+	entries := make(map[string]string)
+	err = walkLayer(filepath.Join(dir, squashedLayerPath), func(name string, header *tar.Header, contents io.Reader) error {
+		data, err := ioutil.ReadAll(contents)
+		entries[name] = string(data)
+		return err
+	})
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"var/vcap":                                 "",
+		"var/vcap/packages-src/abc/bin":            "01234567890",
+		"var/vcap/packages-src/abc/.wh.lib":        "",
+		"var/vcap/jobs-src/nats/spec":              "0123",
+		"tmp/.wh.build":                            "",
+		"var/vcap/jobs-src/nats/templates/ctl.erb": "01",
+	}, entries)
+
+	// The image written reads back, its config matching the layers
+	var archive bytes.Buffer
+	if !assert.NoError(writeSavedImage(&archive, image)) {
+		return
+	}
+	extractDir := filepath.Join(dir, "extracted")
+	if !assert.NoError(extractDockerArchive(&archive, extractDir)) {
+		return
+	}
+	squashed, err := readSavedImage(extractDir)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(image.manifest, squashed.manifest)
+	if assert.Len(squashed.rootFS.DiffIDs, 2) {
+		assert.Equal(digestOf("a/layer.tar"), squashed.rootFS.DiffIDs[0])
+		layer, err := ioutil.ReadFile(filepath.Join(dir, squashedLayerPath))
+		assert.NoError(err)
+		assert.Equal(digestOf(string(layer)), squashed.rootFS.DiffIDs[1])
+	}
+	assert.Equal([]imageHistory{
+		{CreatedBy: "layer a/layer.tar"},
+		{CreatedBy: "ENV A=B", EmptyLayer: true},
+		{Created: "2018-01-02T03:04:05Z", CreatedBy: "fissile squash", Comment: "2 layers merged"},
+	}, squashed.history)
+	assert.Equal([]string{"layer a/layer.tar", "fissile squash"}, squashed.layersCreatedBy())
+
+	// Only the files the first layer stores are hidden
+	analysis, err := analyzeSavedImage(squashed, nil)
+	if assert.NoError(err) {
+		assert.Equal(int64(22), analysis.WastedSize)
+		assert.Equal(int64(17), analysis.Layers[1].Size)
+	}
+}
@@ -0,0 +1,491 @@
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/fatih/color"
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// defaultRegistry is the registry of images whose name doesn't start with one
+const defaultRegistry = "docker.io"
+
+// bandwidthWindow is the period the throughput of transfers is measured over
+const bandwidthWindow = 10 * time.Second
+
+// TransferLimits bound the image pushes and pulls running at once. The docker
+// daemon performs the transfers, so their bandwidth can't be throttled
+// directly; instead, no transfer is started while the throughput of the
+// running ones is above the bandwidth limit.
+type TransferLimits struct {
+	Concurrency int            // Transfers running at once overall; unlimited if 0
+	Registries  map[string]int // Transfers running at once per registry; unlimited if missing
+	Bandwidth   int64          // Bytes per second, averaged over bandwidthWindow; unlimited if 0
+}
+
+// ParseRegistryLimits parses per-registry transfer limits given as
+// registry=count
+func ParseRegistryLimits(specs []string) (map[string]int, error) {
+	result := make(map[string]int, len(specs))
+
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid registry limit %s, expected registry=count", spec)
+		}
+
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("Invalid registry limit %s, the count must be a positive number", spec)
+		}
+
+		result[parts[0]] = count
+	}
+
+	return result, nil
+}
+
+// ParseBandwidth parses a bandwidth limit in bytes per second, given as a
+// size like 500k or 10MB; an empty limit is unlimited
+func ParseBandwidth(spec string) (int64, error) {
+	if spec == "" {
+		return 0, nil
+	}
+
+	bandwidth, err := units.FromHumanSize(spec)
+	if err != nil || bandwidth < 1 {
+		return 0, fmt.Errorf("Invalid bandwidth %s, expected a size like 500k or 10MB", spec)
+	}
+
+	return bandwidth, nil
+}
+
+// ImageRegistry returns the registry an image name refers to
+func ImageRegistry(imageName string) string {
+	parts := strings.SplitN(imageName, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return defaultRegistry
+}
+
+// splitImageTag splits the tag, or the digest, off an image name
+func splitImageTag(imageName string) (string, string) {
+	if at := strings.Index(imageName, "@"); at >= 0 {
+		return imageName[:at], imageName[at+1:]
+	}
+
+	at := strings.LastIndex(imageName, ":")
+	if at < 0 || strings.Contains(imageName[at:], "/") {
+		return imageName, ""
+	}
+	return imageName[:at], imageName[at+1:]
+}
+
+// ImageDigest returns the digest an image name refers to its image by, as in
+// ubuntu@sha256:<hex>, or an empty string for images referred to by tag
+func ImageDigest(imageName string) string {
+	if at := strings.Index(imageName, "@"); at >= 0 {
+		return imageName[at+1:]
+	}
+	return ""
+}
+
+// VerifyImageDigest checks that the local image of a name with a digest was
+// pulled with that digest. Names without a digest are not checked.
+func (d *ImageManager) VerifyImageDigest(imageName string) error {
+	digest := ImageDigest(imageName)
+	if digest == "" {
+		return nil
+	}
+
+	image, err := d.FindImage(imageName)
+	if err != nil {
+		return err
+	}
+	for _, repoDigest := range image.RepoDigests {
+		if strings.HasSuffix(repoDigest, "@"+digest) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Image %s does not have the digest %s, its digests are %s",
+		imageName, digest, strings.Join(image.RepoDigests, ", "))
+}
+
+// ResolveImageDigest returns the digest of a local image: the digest it was
+// pulled with, or for images never pushed or pulled, the ID of the image,
+// which is the digest of its config
+func (d *ImageManager) ResolveImageDigest(imageName string) (string, error) {
+	if digest := ImageDigest(imageName); digest != "" {
+		return digest, nil
+	}
+
+	image, err := d.FindImage(imageName)
+	if err != nil {
+		return "", err
+	}
+	for _, repoDigest := range image.RepoDigests {
+		if at := strings.Index(repoDigest, "@"); at >= 0 {
+			return repoDigest[at+1:], nil
+		}
+	}
+	return image.ID, nil
+}
+
+// RegistryAuth are registry credentials given explicitly, instead of those
+// of the docker client configuration
+type RegistryAuth struct {
+	Username string
+	Password string
+}
+
+// TagImage adds a name to an existing image
+func (d *ImageManager) TagImage(imageName, targetName string) error {
+	name, tag := splitImageTag(targetName)
+	return d.client.TagImage(imageName, dockerclient.TagImageOptions{
+		Repo:  name,
+		Tag:   tag,
+		Force: true,
+	})
+}
+
+// PushImages pushes images to their registries, within the transfer limits.
+// The credentials are those of the docker client configuration, unless auth
+// is given. Failed pushes are attempted again up to retries times. The
+// digests of the pushed images are returned by image name.
+func (d *ImageManager) PushImages(imageNames []string, limits *TransferLimits, auth *RegistryAuth, retries int, output io.Writer) (map[string]string, error) {
+	auths := loadAuthConfigurations()
+
+	var mutex sync.Mutex
+	digests := make(map[string]string, len(imageNames))
+
+	err := runTransfers(imageNames, limits, func(imageName string, meter *transferMeter) error {
+		stdoutWriter := NewFormattingWriter(output, coloredTransferStringFunc("push", imageName))
+		defer stdoutWriter.Close()
+
+		registryAuth := auths.lookup(ImageRegistry(imageName))
+		if auth != nil {
+			registryAuth = dockerclient.AuthConfiguration{
+				Username:      auth.Username,
+				Password:      auth.Password,
+				ServerAddress: ImageRegistry(imageName),
+			}
+		}
+
+		return withRetries(retries, stdoutWriter, func() error {
+			progress := newProgressWriter(stdoutWriter, meter)
+
+			name, tag := splitImageTag(imageName)
+			err := d.client.PushImage(dockerclient.PushImageOptions{
+				Name:          name,
+				Tag:           tag,
+				OutputStream:  progress,
+				RawJSONStream: true,
+			}, registryAuth)
+			if progressErr := progress.Err(); err == nil {
+				err = progressErr
+			}
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			digests[imageName] = progress.Digest()
+			mutex.Unlock()
+			return nil
+		})
+	})
+
+	return digests, err
+}
+
+// retryDelay is the wait before the first retry of a failed transfer; it
+// doubles with each further attempt
+var retryDelay = 5 * time.Second
+
+// withRetries runs an attempt, running it again up to retries times while
+// it fails. It returns the error of the last attempt.
+func withRetries(retries int, output io.Writer, attempt func() error) error {
+	delay := retryDelay
+	for {
+		err := attempt()
+		if err == nil || retries <= 0 {
+			return err
+		}
+		fmt.Fprintf(output, "Failed: %s; retrying in %s\n", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		retries--
+	}
+}
+
+// PullImages pulls images from their registries, within the transfer limits
+func (d *ImageManager) PullImages(imageNames []string, limits *TransferLimits, output io.Writer) error {
+	auths := loadAuthConfigurations()
+
+	return runTransfers(imageNames, limits, func(imageName string, meter *transferMeter) error {
+		stdoutWriter := NewFormattingWriter(output, coloredTransferStringFunc("pull", imageName))
+		defer stdoutWriter.Close()
+		progress := newProgressWriter(stdoutWriter, meter)
+
+		name, tag := splitImageTag(imageName)
+		err := d.client.PullImage(dockerclient.PullImageOptions{
+			Repository:    name,
+			Tag:           tag,
+			OutputStream:  progress,
+			RawJSONStream: true,
+		}, auths.lookup(ImageRegistry(imageName)))
+		if err != nil {
+			return err
+		}
+		if err := progress.Err(); err != nil {
+			return err
+		}
+		return d.VerifyImageDigest(imageName)
+	})
+}
+
+// runTransfers transfers all images, running as many transfers at once as
+// the limits allow. The transfers report the bytes they move to the meter.
+// All failures are reported in a single error.
+func runTransfers(imageNames []string, limits *TransferLimits, transfer func(string, *transferMeter) error) error {
+	if limits == nil {
+		limits = &TransferLimits{}
+	}
+	meter := newTransferMeter(bandwidthWindow)
+
+	var overall chan struct{}
+	if limits.Concurrency > 0 {
+		overall = make(chan struct{}, limits.Concurrency)
+	}
+	registries := make(map[string]chan struct{})
+	for registry, count := range limits.Registries {
+		registries[registry] = make(chan struct{}, count)
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var failures []string
+
+	for _, imageName := range imageNames {
+		wg.Add(1)
+		go func(imageName string) {
+			defer wg.Done()
+
+			// Take the registry slot first, so transfers waiting on a busy
+			// registry don't hold overall slots other registries could use
+			if registry, ok := registries[ImageRegistry(imageName)]; ok {
+				registry <- struct{}{}
+				defer func() { <-registry }()
+			}
+			if overall != nil {
+				overall <- struct{}{}
+				defer func() { <-overall }()
+			}
+
+			meter.waitBelow(limits.Bandwidth)
+
+			if err := transfer(imageName, meter); err != nil {
+				mutex.Lock()
+				failures = append(failures, fmt.Sprintf("%s: %s", imageName, err))
+				mutex.Unlock()
+			}
+		}(imageName)
+	}
+	wg.Wait()
+
+	if len(failures) != 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("Failed to transfer images:\n%s", strings.Join(failures, "\n"))
+	}
+
+	return nil
+}
+
+// transferMeter measures the throughput of transfers
+type transferMeter struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	samples []transferSample
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+// transferSample records bytes transferred at some time
+type transferSample struct {
+	time  time.Time
+	bytes int64
+}
+
+func newTransferMeter(window time.Duration) *transferMeter {
+	return &transferMeter{
+		window: window,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// add records transferred bytes
+func (m *transferMeter) add(bytes int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.samples = append(m.samples, transferSample{time: m.now(), bytes: bytes})
+}
+
+// rate returns the bytes per second transferred within the window
+func (m *transferMeter) rate() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	start := m.now().Add(-m.window)
+	for len(m.samples) > 0 && m.samples[0].time.Before(start) {
+		m.samples = m.samples[1:]
+	}
+
+	var total int64
+	for _, sample := range m.samples {
+		total += sample.bytes
+	}
+	return total * int64(time.Second) / int64(m.window)
+}
+
+// waitBelow blocks while the throughput is above the bandwidth; it returns
+// at once if the bandwidth is unlimited
+func (m *transferMeter) waitBelow(bandwidth int64) {
+	if bandwidth <= 0 {
+		return
+	}
+	for m.rate() > bandwidth {
+		m.sleep(time.Second)
+	}
+}
+
+// progressMessage is a message of the JSON stream of a push or pull
+type progressMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Progress       string `json:"progress"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+	Aux   struct {
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+// progressWriter reads the JSON stream of a push or pull: it writes its
+// messages as lines of text, and reports the progress of each layer to a
+// meter
+type progressWriter struct {
+	pipe   *io.PipeWriter
+	done   chan struct{}
+	err    error
+	digest string
+}
+
+func newProgressWriter(output io.Writer, meter *transferMeter) *progressWriter {
+	reader, writer := io.Pipe()
+	p := &progressWriter{pipe: writer, done: make(chan struct{})}
+
+	go func() {
+		defer close(p.done)
+		current := make(map[string]int64)
+
+		decoder := json.NewDecoder(bufio.NewReader(reader))
+		for {
+			var message progressMessage
+			if err := decoder.Decode(&message); err != nil {
+				if err != io.EOF {
+					p.err = err
+				}
+				io.Copy(ioutil.Discard, reader)
+				return
+			}
+
+			if message.Error != "" {
+				p.err = fmt.Errorf("%s", message.Error)
+				continue
+			}
+
+			if message.Aux.Digest != "" {
+				p.digest = message.Aux.Digest
+				continue
+			}
+
+			if delta := message.ProgressDetail.Current - current[message.ID]; message.Progress != "" && delta > 0 {
+				meter.add(delta)
+				current[message.ID] = message.ProgressDetail.Current
+			}
+
+			line := strings.TrimSpace(strings.Join([]string{message.ID, message.Status, message.Progress}, " "))
+			fmt.Fprintln(output, line)
+		}
+	}()
+
+	return p
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	return p.pipe.Write(data)
+}
+
+// Err returns the error reported in the stream, once it has ended
+func (p *progressWriter) Err() error {
+	p.pipe.Close()
+	<-p.done
+	return p.err
+}
+
+// Digest returns the digest of the pushed image reported in the stream,
+// once it has ended
+func (p *progressWriter) Digest() string {
+	p.Err()
+	return p.digest
+}
+
+// authConfigurations are the registry credentials of the docker client
+type authConfigurations map[string]dockerclient.AuthConfiguration
+
+// loadAuthConfigurations reads the registry credentials of the docker
+// client; there are none if it has no configuration
+func loadAuthConfigurations() authConfigurations {
+	auths, err := dockerclient.NewAuthConfigurationsFromDockerCfg()
+	if err != nil {
+		return authConfigurations{}
+	}
+	return authConfigurations(auths.Configs)
+}
+
+// lookup returns the credentials for a registry
+func (a authConfigurations) lookup(registry string) dockerclient.AuthConfiguration {
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	if registry == defaultRegistry {
+		keys = append(keys, "https://index.docker.io/v1/")
+	}
+
+	for _, key := range keys {
+		if auth, ok := a[key]; ok {
+			return auth
+		}
+	}
+	return dockerclient.AuthConfiguration{}
+}
+
+// coloredTransferStringFunc returns a formatting function prefixing the
+// output of an image transfer
+func coloredTransferStringFunc(action, imageName string) StringFormatter {
+	return func(s string) string {
+		return color.GreenString("%s-%s > %s", action, color.MagentaString(imageName), color.WhiteString("%s", s))
+	}
+}
@@ -0,0 +1,191 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRegistryLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	limits, err := ParseRegistryLimits([]string{"docker.io=1", "registry.example.com:5000=4"})
+	if assert.NoError(err) {
+		assert.Equal(map[string]int{"docker.io": 1, "registry.example.com:5000": 4}, limits)
+	}
+
+	_, err = ParseRegistryLimits([]string{"docker.io"})
+	assert.EqualError(err, "Invalid registry limit docker.io, expected registry=count")
+
+	_, err = ParseRegistryLimits([]string{"docker.io=0"})
+	assert.EqualError(err, "Invalid registry limit docker.io=0, the count must be a positive number")
+}
+
+func TestParseBandwidth(t *testing.T) {
+	assert := assert.New(t)
+
+	for spec, expected := range map[string]int64{"": 0, "500k": 500000, "10MB": 10000000, "2048": 2048} {
+		bandwidth, err := ParseBandwidth(spec)
+		if assert.NoError(err, spec) {
+			assert.Equal(expected, bandwidth, spec)
+		}
+	}
+
+	_, err := ParseBandwidth("fast")
+	assert.EqualError(err, "Invalid bandwidth fast, expected a size like 500k or 10MB")
+}
+
+func TestImageRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("docker.io", ImageRegistry("fissile-myrole:1234"))
+	assert.Equal("docker.io", ImageRegistry("splatform/fissile-myrole:1234"))
+	assert.Equal("registry.example.com", ImageRegistry("registry.example.com/cf/fissile-myrole:1234"))
+	assert.Equal("localhost:5000", ImageRegistry("localhost:5000/fissile-myrole"))
+	assert.Equal("localhost", ImageRegistry("localhost/fissile-myrole"))
+
+	name, tag := splitImageTag("localhost:5000/fissile-myrole")
+	assert.Equal("localhost:5000/fissile-myrole", name)
+	assert.Empty(tag)
+
+	name, tag = splitImageTag("localhost:5000/fissile-myrole:1234")
+	assert.Equal("localhost:5000/fissile-myrole", name)
+	assert.Equal("1234", tag)
+
+	name, tag = splitImageTag("localhost:5000/fissile-myrole@sha256:abcd")
+	assert.Equal("localhost:5000/fissile-myrole", name)
+	assert.Equal("sha256:abcd", tag)
+
+	assert.Equal("sha256:abcd", ImageDigest("ubuntu@sha256:abcd"))
+	assert.Empty(ImageDigest("ubuntu:14.04"))
+}
+
+func TestRunTransfersLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	var mutex sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+
+	var imageNames []string
+	for i := 0; i < 10; i++ {
+		imageNames = append(imageNames, fmt.Sprintf("slow.example.com/role-%d", i))
+		imageNames = append(imageNames, fmt.Sprintf("role-%d", i))
+	}
+
+	limits := &TransferLimits{Concurrency: 3, Registries: map[string]int{"slow.example.com": 1}}
+	err := runTransfers(imageNames, limits, func(imageName string, meter *transferMeter) error {
+		registry := ImageRegistry(imageName)
+
+		mutex.Lock()
+		running[registry]++
+		running["all"]++
+		for key, count := range running {
+			if count > maxRunning[key] {
+				maxRunning[key] = count
+			}
+		}
+		mutex.Unlock()
+
+		mutex.Lock()
+		running[registry]--
+		running["all"]--
+		mutex.Unlock()
+
+		if imageName == "role-3" {
+			return fmt.Errorf("denied")
+		}
+		return nil
+	})
+
+	assert.EqualError(err, "Failed to transfer images:\nrole-3: denied")
+	assert.True(maxRunning["all"] <= 3)
+	assert.Equal(1, maxRunning["slow.example.com"])
+}
+
+func TestTransferMeter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	meter := newTransferMeter(10 * time.Second)
+	meter.now = func() time.Time { return now }
+	var slept time.Duration
+	meter.sleep = func(duration time.Duration) {
+		slept += duration
+		now = now.Add(duration)
+	}
+
+	meter.add(5000)
+	now = now.Add(5 * time.Second)
+	meter.add(5000)
+	assert.Equal(int64(1000), meter.rate())
+
+	// Unlimited transfers never wait
+	meter.waitBelow(0)
+	assert.Zero(slept)
+
+	// The first sample leaves the window after 6 seconds, the second
+	// one after 11
+	meter.waitBelow(600)
+	assert.Equal(6*time.Second, slept)
+	meter.waitBelow(1)
+	assert.Equal(11*time.Second, slept)
+	assert.Zero(meter.rate())
+}
+
+func TestProgressWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	meter := newTransferMeter(time.Minute)
+	output := &bytes.Buffer{}
+	progress := newProgressWriter(output, meter)
+
+	progress.Write([]byte(`{"status":"Preparing","id":"1234"}
+{"status":"Pushing","id":"1234","progress":"[=>   ]","progressDetail":{"current":100,"total":400}}
+{"status":"Pushing","id":"1234","progress":"[===> ]","progressDetail":{"current":300,"total":400}}
+{"status":"Pushed","id":"1234","progressDetail":{}}
+{"status":"latest: digest: sha256:abcd size: 1234"}
+{"progressDetail":{},"aux":{"Tag":"latest","Digest":"sha256:abcd","Size":1234}}
+`))
+
+	assert.NoError(progress.Err())
+	assert.Equal("1234 Preparing\n1234 Pushing [=>   ]\n1234 Pushing [===> ]\n1234 Pushed\nlatest: digest: sha256:abcd size: 1234\n", output.String())
+	assert.Equal(int64(300), meter.rate()*int64(time.Minute/time.Second))
+	assert.Equal("sha256:abcd", progress.Digest())
+
+	progress = newProgressWriter(&bytes.Buffer{}, meter)
+	progress.Write([]byte(`{"errorDetail":{"message":"denied"},"error":"denied"}`))
+	assert.EqualError(progress.Err(), "denied")
+}
+
+func TestWithRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = time.Millisecond
+
+	attempts := 0
+	output := &bytes.Buffer{}
+	err := withRetries(2, output, func() error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("attempt %d", attempts)
+		}
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(3, attempts)
+	assert.Equal("Failed: attempt 1; retrying in 1ms\nFailed: attempt 2; retrying in 2ms\n", output.String())
+
+	attempts = 0
+	err = withRetries(1, &bytes.Buffer{}, func() error {
+		attempts++
+		return fmt.Errorf("attempt %d", attempts)
+	})
+	assert.EqualError(err, "attempt 2", "The error of the last attempt should be returned")
+	assert.Equal(2, attempts)
+}
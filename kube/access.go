@@ -0,0 +1,141 @@
+package kube
+
+import (
+	"github.com/hpcloud/fissile/model"
+
+	meta "k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+)
+
+// RBACRole is a k8s role, granting access to resources of a namespace. The
+// vendored client library knows nothing of RBAC.
+type RBACRole struct {
+	meta.TypeMeta `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+	Rules         []PolicyRule `json:"rules"`
+}
+
+// PolicyRule is an action on resources granted by a RBACRole
+type PolicyRule struct {
+	APIGroups []string `json:"apiGroups"`
+	Resources []string `json:"resources"`
+	Verbs     []string `json:"verbs"`
+}
+
+// RBACRoleBinding is a k8s role binding, granting a RBACRole to subjects
+type RBACRoleBinding struct {
+	meta.TypeMeta `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+	Subjects      []RBACSubject `json:"subjects"`
+	RoleRef       RBACRoleRef   `json:"roleRef"`
+}
+
+// RBACSubject is who a RBACRoleBinding grants its role to
+type RBACSubject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// RBACRoleRef refers to the RBACRole granted by a RBACRoleBinding
+type RBACRoleRef struct {
+	APIGroup string `json:"apiGroup"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+// needsServiceAccount tells whether the pods of a role talk to the Kubernetes
+// API: active/passive roles label their pods, and roles depending on others
+// wait for them.
+func needsServiceAccount(role *model.Role) bool {
+	return role.IsActivePassive() || len(roleDependencies(role)) > 0
+}
+
+// roleServiceAccountName returns the name of the service account the pods of
+// a role run as
+func roleServiceAccountName(role *model.Role) string {
+	return role.Name
+}
+
+// NewRoleAccess returns the service account the pods of a role run as, and
+// the role and role binding granting them what they need of the Kubernetes
+// API: active/passive roles label themselves active or passive, roles
+// depending on others look up whether those are ready. It returns nil for
+// roles needing nothing of it.
+func NewRoleAccess(role *model.Role) *v1.List {
+	if !needsServiceAccount(role) {
+		return nil
+	}
+
+	var rules []PolicyRule
+	if role.IsActivePassive() {
+		rules = append(rules, PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get", "patch"},
+		})
+	}
+	if len(roleDependencies(role)) > 0 {
+		rules = append(rules, PolicyRule{
+			APIGroups: []string{"apps", "extensions", "batch"},
+			Resources: []string{"statefulsets", "deployments", "jobs"},
+			Verbs:     []string{"get"},
+		})
+	}
+
+	name := roleServiceAccountName(role)
+	serviceAccount := &v1.ServiceAccount{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:   name,
+			Labels: roleLabels(role),
+		},
+	}
+	rbacRole := &RBACRole{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1beta1",
+			Kind:       "Role",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:   name,
+			Labels: roleLabels(role),
+		},
+		Rules: rules,
+	}
+	roleBinding := &RBACRoleBinding{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1beta1",
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:   name,
+			Labels: roleLabels(role),
+		},
+		Subjects: []RBACSubject{
+			{
+				Kind: "ServiceAccount",
+				Name: name,
+			},
+		},
+		RoleRef: RBACRoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     name,
+		},
+	}
+
+	return &v1.List{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "List",
+		},
+		Items: []runtime.RawExtension{
+			runtime.RawExtension{Object: serviceAccount},
+			runtime.RawExtension{Object: rbacRole},
+			runtime.RawExtension{Object: roleBinding},
+		},
+	}
+}
@@ -0,0 +1,64 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestActivePassive(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "active-passive.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	pod, err := NewPodTemplate(role, &ExportSettings{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("false", pod.Labels[RoleActiveLabel], "Pods should start out passive")
+	assert.Equal("myrole", pod.Spec.ServiceAccountName)
+
+	service, err := NewClusterIPService(role, false)
+	if assert.NoError(err) {
+		assert.Equal(map[string]string{RoleNameLabel: "myrole", RoleActiveLabel: "true"}, service.Spec.Selector)
+	}
+	service, err = NewClusterIPService(role, true)
+	if assert.NoError(err) {
+		assert.Equal(map[string]string{RoleNameLabel: "myrole"}, service.Spec.Selector,
+			"The headless service should name passive pods too")
+	}
+	service, err = NewPublicService(role, &ExportSettings{})
+	if assert.NoError(err) {
+		assert.Equal(map[string]string{RoleNameLabel: "myrole", RoleActiveLabel: "true"}, service.Spec.Selector)
+	}
+
+	access := NewRoleAccess(role)
+	if assert.NotNil(access) && assert.Len(access.Items, 3) {
+		serviceAccount, ok := access.Items[0].Object.(*v1.ServiceAccount)
+		if assert.True(ok) {
+			assert.Equal("myrole", serviceAccount.Name)
+		}
+		rbacRole, ok := access.Items[1].Object.(*RBACRole)
+		if assert.True(ok) && assert.Len(rbacRole.Rules, 1) {
+			assert.Equal([]string{"pods"}, rbacRole.Rules[0].Resources)
+			assert.Equal([]string{"get", "patch"}, rbacRole.Rules[0].Verbs)
+		}
+		roleBinding, ok := access.Items[2].Object.(*RBACRoleBinding)
+		if assert.True(ok) {
+			assert.Equal([]RBACSubject{{Kind: "ServiceAccount", Name: "myrole"}}, roleBinding.Subjects)
+			assert.Equal("myrole", roleBinding.RoleRef.Name)
+		}
+	}
+
+	role.Run.ActivePassiveProbe = ""
+	assert.Nil(NewRoleAccess(role))
+	pod, err = NewPodTemplate(role, &ExportSettings{})
+	if assert.NoError(err) {
+		assert.NotContains(pod.Labels, RoleActiveLabel)
+		assert.Empty(pod.Spec.ServiceAccountName)
+	}
+}
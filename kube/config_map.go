@@ -0,0 +1,89 @@
+package kube
+
+import (
+	"github.com/hpcloud/fissile/model"
+
+	meta "k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// NewConfigMap creates a ConfigMap holding the values of all ordinary
+// configuration variables of the given role. It returns nil if the role has
+// no such variables.
+func NewConfigMap(role *model.Role, settings *ExportSettings) (*v1.ConfigMap, error) {
+	values, err := getVariableValues(role, settings.Defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]string)
+	for _, value := range values {
+		if !value.variable.IsSecret() {
+			data[value.variable.Name] = value.value
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return &v1.ConfigMap{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name: configMapName(role),
+			Labels: map[string]string{
+				RoleNameLabel: role.Name,
+			},
+		},
+		Data: data,
+	}, nil
+}
+
+// NewSecret creates a Secret holding the values of all configuration
+// variables of the given role that are secret or generated. It returns nil if
+// the role has no such variables.
+func NewSecret(role *model.Role, settings *ExportSettings) (*v1.Secret, error) {
+	values, err := getVariableValues(role, settings.Defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte)
+	for _, value := range values {
+		if value.variable.IsSecret() {
+			data[value.variable.Name] = []byte(value.value)
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return &v1.Secret{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name: secretName(role),
+			Labels: map[string]string{
+				RoleNameLabel: role.Name,
+			},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: data,
+	}, nil
+}
+
+// configMapName returns the name of the ConfigMap of a role
+func configMapName(role *model.Role) string {
+	return role.Name + "-config"
+}
+
+// secretName returns the name of the Secret of a role
+func secretName(role *model.Role) string {
+	return role.Name + "-secret"
+}
@@ -0,0 +1,107 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestConfigMapAndSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "config-provider.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	settings := &ExportSettings{
+		Defaults:       map[string]string{"CONTROL_PASSWORD": "generated", "PRIVATE_KEY": "not-so-private"},
+		ConfigProvider: ConfigProviderK8s,
+	}
+
+	configMap, err := NewConfigMap(role, settings)
+	if assert.NoError(err) && assert.NotNil(configMap) {
+		assert.Equal("myrole-config", configMap.ObjectMeta.Name)
+		assert.Equal(map[string]string{"HOSTNAME": "tor.example.com"}, configMap.Data)
+	}
+
+	secret, err := NewSecret(role, settings)
+	if assert.NoError(err) && assert.NotNil(secret) {
+		assert.Equal("myrole-secret", secret.ObjectMeta.Name)
+		assert.Equal(map[string][]byte{
+			"CONTROL_PASSWORD": []byte("generated"),
+			"PRIVATE_KEY":      []byte("not-so-private"),
+		}, secret.Data)
+	}
+
+	vars, err := getEnvVarRefs(role, settings.Defaults)
+	if !assert.NoError(err) {
+		return
+	}
+
+	refs := make(map[string]*v1.EnvVarSource)
+	for _, envVar := range vars {
+		assert.Empty(envVar.Value, "Variable %s should not have an inline value", envVar.Name)
+		refs[envVar.Name] = envVar.ValueFrom
+	}
+
+	if assert.NotNil(refs["HOSTNAME"]) && assert.NotNil(refs["HOSTNAME"].ConfigMapKeyRef) {
+		assert.Equal("myrole-config", refs["HOSTNAME"].ConfigMapKeyRef.Name)
+		assert.Equal("HOSTNAME", refs["HOSTNAME"].ConfigMapKeyRef.Key)
+	}
+	for _, name := range []string{"PRIVATE_KEY", "CONTROL_PASSWORD"} {
+		if assert.NotNil(refs[name]) && assert.NotNil(refs[name].SecretKeyRef) {
+			assert.Equal("myrole-secret", refs[name].SecretKeyRef.Name)
+			assert.Equal(name, refs[name].SecretKeyRef.Key)
+		}
+	}
+	assert.NotNil(refs["KUBERNETES_NAMESPACE"])
+}
+
+func TestConfigMapWithoutVariables(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "volumes.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	configMap, err := NewConfigMap(role, &ExportSettings{})
+	assert.NoError(err)
+	assert.Nil(configMap)
+
+	secret, err := NewSecret(role, &ExportSettings{})
+	assert.NoError(err)
+	assert.Nil(secret)
+}
+
+func TestConfigMapTypedValues(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "variable-types.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	settings := &ExportSettings{
+		Defaults:       map[string]string{"DEBUG": "0"},
+		ConfigProvider: ConfigProviderK8s,
+	}
+
+	configMap, err := NewConfigMap(role, settings)
+	if assert.NoError(err) && assert.NotNil(configMap) {
+		assert.Equal(map[string]string{
+			"CLIENT_KEYS": `["alpha","beta"]`,
+			"DEBUG":       "false",
+			"HOSTNAME":    "tor.example.com",
+			"PORT":        "9050",
+		}, configMap.Data)
+	}
+
+	settings.Defaults["PORT"] = "70000"
+	_, err = NewConfigMap(role, settings)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Invalid value of the configuration variable PORT: 70000 is more than the maximum 65535")
+	}
+}
@@ -10,8 +10,16 @@ import (
 
 // NewDeployment creates a Deployment for the given role, and its attached service
 func NewDeployment(role *model.Role, settings *ExportSettings) (*extra.Deployment, *apiv1.Service, error) {
+	replicas := role.InitialInstances()
+	if hasCanaryDeployment(role) {
+		// The canary instances are taken out of the regular ones
+		replicas -= role.Run.Canary.Count
+	}
 
-	podTemplate, err := NewPodTemplate(role, settings)
+	// The regular instances are always on the stable track, with or without
+	// canary instances: the selector can't be changed once the Deployment
+	// exists, so adding canary instances later must not change it.
+	deployment, err := newDeployment(role, settings, role.Name, RoleTrackStable, replicas)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -21,25 +29,57 @@ func NewDeployment(role *model.Role, settings *ExportSettings) (*extra.Deploymen
 		return nil, nil, err
 	}
 
+	return deployment, svc, nil
+}
+
+// NewCanaryDeployment creates the Deployment for the canary instances of the
+// given role. It returns nil if the role has no canary instances. The
+// instances are selected by the service of the regular Deployment as well.
+func NewCanaryDeployment(role *model.Role, settings *ExportSettings) (*extra.Deployment, error) {
+	if !hasCanaryDeployment(role) {
+		return nil, nil
+	}
+
+	return newDeployment(role, settings, role.Name+"-canary", RoleTrackCanary, role.Run.Canary.Count)
+}
+
+// hasCanaryDeployment tests whether the role has canary instances
+func hasCanaryDeployment(role *model.Role) bool {
+	return role.Run.Canary != nil && role.Run.Canary.Count > 0
+}
+
+// newDeployment creates a Deployment running replicas pods of the given role.
+// If a track is given, the pods are labeled with it, and only pods of that
+// track are managed by the Deployment.
+func newDeployment(role *model.Role, settings *ExportSettings, name, track string, replicas int32) (*extra.Deployment, error) {
+	podTemplate, err := NewPodTemplate(role, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := map[string]string{RoleNameLabel: role.Name}
+	if track != "" {
+		selector[RoleTrackLabel] = track
+		podTemplate.ObjectMeta.Labels[RoleTrackLabel] = track
+	}
+
 	return &extra.Deployment{
 		TypeMeta: meta.TypeMeta{
 			APIVersion: "extensions/v1beta1",
 			Kind:       "Deployment",
 		},
 		ObjectMeta: apiv1.ObjectMeta{
-			Name: role.Name,
-			Labels: map[string]string{
-				RoleNameLabel: role.Name,
-			},
+			Name:   name,
+			Labels: roleLabels(role),
 		},
 		Spec: extra.DeploymentSpec{
-			Replicas: &role.Run.Scaling.Min,
+			Replicas: &replicas,
 			Selector: &meta.LabelSelector{
-				MatchLabels: map[string]string{RoleNameLabel: role.Name},
+				MatchLabels: selector,
 			},
 			Template: podTemplate,
 		},
-	}, svc, nil
+	}, nil
 }
 
 //metadata:
@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentCanary(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifestRole(assert, "canary.yml", "foorole")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	deployment, _, err := NewDeployment(role, &ExportSettings{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("foorole", deployment.ObjectMeta.Name)
+	assert.Equal(int32(2), *deployment.Spec.Replicas)
+	assert.Equal(map[string]string{
+		RoleNameLabel:  "foorole",
+		RoleTrackLabel: RoleTrackStable,
+	}, deployment.Spec.Selector.MatchLabels)
+	assert.Equal(RoleTrackStable, deployment.Spec.Template.ObjectMeta.Labels[RoleTrackLabel])
+
+	canary, err := NewCanaryDeployment(role, &ExportSettings{})
+	if !assert.NoError(err) || !assert.NotNil(canary) {
+		return
+	}
+	assert.Equal("foorole-canary", canary.ObjectMeta.Name)
+	assert.Equal(int32(1), *canary.Spec.Replicas)
+	assert.Equal(map[string]string{
+		RoleNameLabel:  "foorole",
+		RoleTrackLabel: RoleTrackCanary,
+	}, canary.Spec.Selector.MatchLabels)
+	assert.Equal(RoleTrackCanary, canary.Spec.Template.ObjectMeta.Labels[RoleTrackLabel])
+}
+
+func TestDeploymentScaledDown(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifestRole(assert, "scaled-down.yml", "optional")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	deployment, _, err := NewDeployment(role, &ExportSettings{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(int32(0), *deployment.Spec.Replicas)
+}
+
+func TestDeploymentWithoutCanary(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "exposed-ports.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	deployment, _, err := NewDeployment(role, &ExportSettings{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(role.Run.Scaling.Min, *deployment.Spec.Replicas)
+	assert.Equal(map[string]string{
+		RoleNameLabel:  "myrole",
+		RoleTrackLabel: RoleTrackStable,
+	}, deployment.Spec.Selector.MatchLabels, "The selector should not depend on canary instances")
+
+	canary, err := NewCanaryDeployment(role, &ExportSettings{})
+	assert.NoError(err)
+	assert.Nil(canary)
+}
@@ -0,0 +1,42 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// EnvFileName returns the name of the env file of a role
+func EnvFileName(role *model.Role) string {
+	return fmt.Sprintf("%s.env", role.Name)
+}
+
+// NewEnvFile returns the env file of a role, with a NAME=value line for each
+// of its configuration variables that has a value, as given to docker run
+// --env-file. Those files can't hold values spanning several lines, and
+// secret values are kept out of them; such variables are left out, with a
+// comment saying so.
+func NewEnvFile(role *model.Role, settings *ExportSettings) ([]byte, error) {
+	values, err := getVariableValues(role, settings.Defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Configuration of role %s\n", role.Name)
+	for _, value := range values {
+		if value.variable.IsSecret() {
+			fmt.Fprintf(&buf, "# %s is left out: it is secret, see the Secret %s\n", value.variable.Name, secretName(role))
+			continue
+		}
+		if strings.ContainsAny(value.value, "\r\n") {
+			fmt.Fprintf(&buf, "# %s is left out: its value spans several lines\n", value.variable.Name)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", value.variable.Name, value.value)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,56 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestNewEnvFile(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "config-provider.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	settings := &ExportSettings{
+		Defaults: map[string]string{
+			"CONTROL_PASSWORD": "generated",
+			"HOSTNAME":         `tor.example.com\nmirror.example.com`,
+			"PRIVATE_KEY":      "not-so-private",
+		},
+		ConfigProvider: ConfigProviderEnvFiles,
+	}
+
+	assert.Equal("myrole.env", EnvFileName(role))
+
+	contents, err := NewEnvFile(role, settings)
+	if assert.NoError(err) {
+		assert.Equal(`# Configuration of role myrole
+# CONTROL_PASSWORD is left out: it is secret, see the Secret myrole-secret
+# HOSTNAME is left out: its value spans several lines
+# PRIVATE_KEY is left out: it is secret, see the Secret myrole-secret
+`, string(contents))
+	}
+
+	// The pods get the values in their environment, as with the env provider,
+	// the secret ones from the Secret of the role
+	pod, err := NewPodTemplate(role, settings)
+	if assert.NoError(err) {
+		values := make(map[string]string)
+		refs := make(map[string]*v1.EnvVarSource)
+		for _, envVar := range pod.Spec.Containers[0].Env {
+			values[envVar.Name] = envVar.Value
+			refs[envVar.Name] = envVar.ValueFrom
+		}
+		assert.Equal("tor.example.com\nmirror.example.com", values["HOSTNAME"])
+		for _, name := range []string{"CONTROL_PASSWORD", "PRIVATE_KEY"} {
+			assert.Empty(values[name], "Secret variable %s should not have an inline value", name)
+			if assert.NotNil(refs[name]) && assert.NotNil(refs[name].SecretKeyRef) {
+				assert.Equal("myrole-secret", refs[name].SecretKeyRef.Name)
+			}
+		}
+	}
+}
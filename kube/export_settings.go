@@ -1,10 +1,37 @@
 package kube
 
+const (
+	// ConfigProviderEnv puts the values of configuration variables directly
+	// into the environment of the pods, except for secret ones, which are
+	// put into a Secret per role
+	ConfigProviderEnv = "env"
+	// ConfigProviderK8s puts the values of configuration variables into a
+	// ConfigMap and a Secret per role, referenced by the pods
+	ConfigProviderK8s = "k8s"
+	// ConfigProviderVault puts the values of secret configuration variables
+	// into Vault, and all others directly into the environment of the pods
+	ConfigProviderVault = "vault"
+	// ConfigProviderEnvFiles puts the values of configuration variables
+	// into the environment of the pods, like ConfigProviderEnv, and also
+	// writes the values of all but the secret ones into an env file per role,
+	// for docker run --env-file
+	ConfigProviderEnvFiles = "envfiles"
+)
+
+// The types of the services making the public ports of roles reachable
+const (
+	PublicServiceTypeLoadBalancer = "LoadBalancer"
+	PublicServiceTypeNodePort     = "NodePort"
+)
+
 // ExportSettings are configuration for creating Kubernetes configs
 type ExportSettings struct {
-	Repository      string
-	Defaults        map[string]string
-	Registry        string
-	Organization    string
-	UseMemoryLimits bool
+	Repository        string
+	Defaults          map[string]string
+	Registry          string
+	Organization      string
+	UseMemoryLimits   bool
+	ConfigProvider    string
+	VaultPath         string
+	PublicServiceType string // LoadBalancer by default
 }
@@ -0,0 +1,147 @@
+package kube
+
+import (
+	"fmt"
+
+	"github.com/hpcloud/fissile/model"
+
+	"k8s.io/client-go/pkg/api/resource"
+	meta "k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// HorizontalPodAutoscaler is a k8s horizontal pod autoscaler. Roles scaled at
+// their CPU utilization only use the autoscaling/v1 API, roles with custom
+// metrics the autoscaling/v2beta1 one; the vendored client library knows
+// neither.
+type HorizontalPodAutoscaler struct {
+	meta.TypeMeta `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+	Spec          HorizontalPodAutoscalerSpec `json:"spec"`
+}
+
+// HorizontalPodAutoscalerSpec is the spec of a HorizontalPodAutoscaler
+type HorizontalPodAutoscalerSpec struct {
+	ScaleTargetRef                 CrossVersionObjectReference `json:"scaleTargetRef"`
+	MinReplicas                    *int32                      `json:"minReplicas,omitempty"`
+	MaxReplicas                    int32                       `json:"maxReplicas"`
+	TargetCPUUtilizationPercentage *int32                      `json:"targetCPUUtilizationPercentage,omitempty"` // autoscaling/v1 only
+	Metrics                        []MetricSpec                `json:"metrics,omitempty"`                        // autoscaling/v2beta1 only
+}
+
+// CrossVersionObjectReference refers to the object a HorizontalPodAutoscaler
+// scales
+type CrossVersionObjectReference struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	APIVersion string `json:"apiVersion,omitempty"`
+}
+
+// MetricSpec is a metric a HorizontalPodAutoscaler scales at
+type MetricSpec struct {
+	Type     string                `json:"type"`
+	Resource *ResourceMetricSource `json:"resource,omitempty"`
+	Pods     *PodsMetricSource     `json:"pods,omitempty"`
+}
+
+// ResourceMetricSource is the utilization of a resource of the pods, in
+// percent of their requests
+type ResourceMetricSource struct {
+	Name                     string `json:"name"`
+	TargetAverageUtilization *int32 `json:"targetAverageUtilization,omitempty"`
+}
+
+// PodsMetricSource is a custom metric of the pods, averaged over them
+type PodsMetricSource struct {
+	MetricName         string            `json:"metricName"`
+	TargetAverageValue resource.Quantity `json:"targetAverageValue"`
+}
+
+// NewHorizontalPodAutoscaler returns a k8s horizontal pod autoscaler scaling
+// the Deployment or StatefulSet of the given role between its scaling limits.
+// It returns nil for roles which can't scale: tasks, and roles whose limits
+// are the same. The autoscaler leaves roles deployed scaled down to zero
+// instances alone until they are scaled up.
+func NewHorizontalPodAutoscaler(role *model.Role) (*HorizontalPodAutoscaler, error) {
+	if role.Type == model.RoleTypeBoshTask || role.Run == nil || role.Run.Scaling == nil {
+		return nil, nil
+	}
+
+	scaling := role.Run.Scaling
+	if scaling.Max <= scaling.Min {
+		return nil, nil
+	}
+
+	target := CrossVersionObjectReference{
+		Kind:       "Deployment",
+		Name:       role.Name,
+		APIVersion: "extensions/v1beta1",
+	}
+	minReplicas, maxReplicas := scaling.Min, scaling.Max
+	if role.IsStateful() {
+		target.Kind = "StatefulSet"
+		target.APIVersion = "apps/v1beta1"
+	} else if hasCanaryDeployment(role) {
+		// Only the regular Deployment is scaled, the canary instances are
+		// taken out of it
+		minReplicas -= role.Run.Canary.Count
+		maxReplicas -= role.Run.Canary.Count
+	}
+	if minReplicas < 1 {
+		minReplicas = 1
+	}
+
+	hpa := &HorizontalPodAutoscaler{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "autoscaling/v1",
+			Kind:       "HorizontalPodAutoscaler",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:   role.Name,
+			Labels: roleLabels(role),
+		},
+		Spec: HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: target,
+			MinReplicas:    &minReplicas,
+			MaxReplicas:    maxReplicas,
+		},
+	}
+
+	var cpuTarget *int32
+	if scaling.CPUTarget > 0 {
+		value := scaling.CPUTarget
+		cpuTarget = &value
+	}
+
+	if len(scaling.CustomMetrics) == 0 {
+		hpa.Spec.TargetCPUUtilizationPercentage = cpuTarget
+		return hpa, nil
+	}
+
+	hpa.TypeMeta.APIVersion = "autoscaling/v2beta1"
+	if cpuTarget != nil {
+		hpa.Spec.Metrics = append(hpa.Spec.Metrics, MetricSpec{
+			Type: "Resource",
+			Resource: &ResourceMetricSource{
+				Name:                     "cpu",
+				TargetAverageUtilization: cpuTarget,
+			},
+		})
+	}
+	for _, metric := range scaling.CustomMetrics {
+		value, err := resource.ParseQuantity(metric.Target)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid target %s of the custom metric %s of role %s: %s",
+				metric.Target, metric.Name, role.Name, err)
+		}
+		hpa.Spec.Metrics = append(hpa.Spec.Metrics, MetricSpec{
+			Type: "Pods",
+			Pods: &PodsMetricSource{
+				MetricName:         metric.Name,
+				TargetAverageValue: value,
+			},
+		})
+	}
+
+	return hpa, nil
+}
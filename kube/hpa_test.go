@@ -0,0 +1,117 @@
+package kube
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestHorizontalPodAutoscalerCustomMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifestRole(assert, "autoscaling.yml", "myrole")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	hpa, err := NewHorizontalPodAutoscaler(role)
+	if !assert.NoError(err) || !assert.NotNil(hpa) {
+		return
+	}
+
+	yamlConfig := bytes.Buffer{}
+	if err := WriteYamlConfig(hpa, &yamlConfig); !assert.NoError(err) {
+		return
+	}
+
+	var expected, actual interface{}
+	if !assert.NoError(yaml.Unmarshal(yamlConfig.Bytes(), &actual)) {
+		return
+	}
+	expectedYAML := strings.Replace(`---
+	apiVersion: autoscaling/v2beta1
+	kind: HorizontalPodAutoscaler
+	metadata:
+		name: myrole
+	spec:
+		scaleTargetRef:
+			apiVersion: extensions/v1beta1
+			kind: Deployment
+			name: myrole
+		minReplicas: 1
+		maxReplicas: 5
+		metrics:
+		-
+			type: Resource
+			resource:
+				name: cpu
+				targetAverageUtilization: 60
+		-
+			type: Pods
+			pods:
+				metricName: requests_per_second
+				targetAverageValue: 500m
+	`, "\t", "    ", -1)
+	if !assert.NoError(yaml.Unmarshal([]byte(expectedYAML), &expected)) {
+		return
+	}
+	_ = isYAMLSubset(assert, expected, actual, []string{})
+}
+
+func TestHorizontalPodAutoscalerFixedScaling(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifestRole(assert, "autoscaling.yml", "foorole")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	hpa, err := NewHorizontalPodAutoscaler(role)
+	assert.NoError(err)
+	assert.Nil(hpa, "Roles with the same min and max must not be autoscaled")
+}
+
+func TestHorizontalPodAutoscalerStatefulSet(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifestRole(assert, "canary.yml", "myrole")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	hpa, err := NewHorizontalPodAutoscaler(role)
+	if !assert.NoError(err) || !assert.NotNil(hpa) {
+		return
+	}
+	assert.Equal("autoscaling/v1", hpa.TypeMeta.APIVersion)
+	assert.Equal(CrossVersionObjectReference{
+		Kind:       "StatefulSet",
+		Name:       "myrole",
+		APIVersion: "apps/v1beta1",
+	}, hpa.Spec.ScaleTargetRef)
+	assert.Equal(int32(3), *hpa.Spec.MinReplicas)
+	assert.Equal(int32(5), hpa.Spec.MaxReplicas)
+	assert.Nil(hpa.Spec.TargetCPUUtilizationPercentage)
+	assert.Empty(hpa.Spec.Metrics)
+}
+
+func TestHorizontalPodAutoscalerCanary(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifestRole(assert, "canary.yml", "foorole")
+	if manifest == nil || role == nil {
+		return
+	}
+	role.Run.Scaling.Max = 6
+
+	hpa, err := NewHorizontalPodAutoscaler(role)
+	if !assert.NoError(err) || !assert.NotNil(hpa) {
+		return
+	}
+	assert.Equal("Deployment", hpa.Spec.ScaleTargetRef.Kind)
+	assert.Equal(int32(2), *hpa.Spec.MinReplicas, "Canary instances are not scaled")
+	assert.Equal(int32(5), hpa.Spec.MaxReplicas, "Canary instances are not scaled")
+}
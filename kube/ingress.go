@@ -0,0 +1,83 @@
+package kube
+
+import (
+	"fmt"
+
+	"github.com/hpcloud/fissile/model"
+
+	meta "k8s.io/client-go/pkg/api/unversioned"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	extra "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/util/intstr"
+)
+
+// NewIngress creates a k8s Ingress routing the HTTP requests for the host and
+// path of a role to the port of its service. It returns nil if the role has
+// no Ingress.
+func NewIngress(role *model.Role) (*extra.Ingress, error) {
+	if role.Run == nil || role.Run.Ingress == nil {
+		return nil, nil
+	}
+	ingress := role.Run.Ingress
+
+	var portDef *model.RoleRunExposedPort
+	for _, exposedPort := range role.Run.ExposedPorts {
+		if exposedPort.Name == ingress.Port {
+			portDef = exposedPort
+		}
+	}
+	if portDef == nil {
+		return nil, fmt.Errorf("Role %s has no exposed port %s for its ingress", role.Name, ingress.Port)
+	}
+
+	port, _, err := parsePortRange(portDef.External, portDef.Name, "external")
+	if err != nil {
+		return nil, err
+	}
+	portInfos, err := getPortInfo(portDef.Name, port, port)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &extra.Ingress{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "extensions/v1beta1",
+			Kind:       "Ingress",
+		},
+		ObjectMeta: apiv1.ObjectMeta{
+			Name:   role.Name,
+			Labels: roleLabels(role),
+		},
+		Spec: extra.IngressSpec{
+			Rules: []extra.IngressRule{
+				{
+					Host: ingress.Host,
+					IngressRuleValue: extra.IngressRuleValue{
+						HTTP: &extra.HTTPIngressRuleValue{
+							Paths: []extra.HTTPIngressPath{
+								{
+									Path: ingress.Path,
+									Backend: extra.IngressBackend{
+										ServiceName: role.Name,
+										ServicePort: intstr.FromString(portInfos[0].name),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if ingress.TLS != nil {
+		result.Spec.TLS = []extra.IngressTLS{
+			{
+				Hosts:      []string{ingress.Host},
+				SecretName: ingress.TLS.SecretName,
+			},
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package kube
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestIngressOK(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "ingress.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	ingress, err := NewIngress(role)
+	if !assert.NoError(err) || !assert.NotNil(ingress) {
+		return
+	}
+
+	yamlConfig := bytes.Buffer{}
+	if err := WriteYamlConfig(ingress, &yamlConfig); !assert.NoError(err) {
+		return
+	}
+	var expected, actual interface{}
+	if !assert.NoError(yaml.Unmarshal(yamlConfig.Bytes(), &actual)) {
+		return
+	}
+	expectedYAML := strings.Replace(`---
+	apiVersion: extensions/v1beta1
+	kind: Ingress
+	metadata:
+		name: myrole
+	spec:
+		rules:
+		-
+			host: myrole.example.com
+			http:
+				paths:
+				-
+					path: /api
+					backend:
+						serviceName: myrole
+						servicePort: http
+		tls:
+		-
+			hosts:
+			- myrole.example.com
+			secretName: myrole-tls
+	`, "\t", "    ", -1)
+	if !assert.NoError(yaml.Unmarshal([]byte(expectedYAML), &expected)) {
+		return
+	}
+	_ = isYAMLSubset(assert, expected, actual, []string{})
+}
+
+func TestIngressNone(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "exposed-ports.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	ingress, err := NewIngress(role)
+	assert.NoError(err)
+	assert.Nil(ingress)
+}
@@ -29,6 +29,9 @@ func NewJob(role *model.Role, settings *ExportSettings) (*extra.Job, error) {
 	default:
 		return nil, fmt.Errorf("Role %s has unexpected flight stage %s", role.Name, role.Run.FlightStage)
 	}
+	if role.Run.Restart != nil && role.Run.Restart.Policy == model.RestartPolicyOnFailure {
+		podTemplate.Spec.RestartPolicy = apiv1.RestartPolicyOnFailure
+	}
 
 	return &extra.Job{
 		TypeMeta: meta.TypeMeta{
@@ -10,6 +10,7 @@ import (
 	"github.com/hpcloud/fissile/model"
 	"github.com/stretchr/testify/assert"
 	yaml "gopkg.in/yaml.v2"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
 )
 
 func jobTestLoadRole(assert *assert.Assertions, roleName string) *model.Role {
@@ -122,3 +123,23 @@ func TestJobPostFlight(t *testing.T) {
 	}
 	_ = isYAMLSubset(assert, expected, actual, []string{})
 }
+
+func TestJobRestartPolicy(t *testing.T) {
+	assert := assert.New(t)
+	role := jobTestLoadRole(assert, "post-role")
+	if role == nil {
+		return
+	}
+
+	role.Run.FlightStage = model.FlightStageManual
+	job, err := NewJob(role, &ExportSettings{})
+	if assert.NoError(err) {
+		assert.Equal(apiv1.RestartPolicyNever, job.Spec.Template.Spec.RestartPolicy)
+	}
+
+	role.Run.Restart = &model.RoleRunRestart{Policy: model.RestartPolicyOnFailure, MaxRetries: 3}
+	job, err = NewJob(role, &ExportSettings{})
+	if assert.NoError(err) {
+		assert.Equal(apiv1.RestartPolicyOnFailure, job.Spec.Template.Spec.RestartPolicy)
+	}
+}
@@ -3,8 +3,10 @@ package kube
 import (
 	"encoding/base64"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -19,25 +21,36 @@ import (
 // monitPort is the port monit runs on in the pods
 const monitPort = 2289
 
+// shmVolumeName is the name of the volume backing /dev/shm
+const shmVolumeName = "shm"
+
 // NewPodTemplate creates a new pod template spec for a given role, as well as
 // any objects it depends on
 func NewPodTemplate(role *model.Role, settings *ExportSettings) (v1.PodTemplateSpec, error) {
 
-	vars, err := getEnvVars(role, settings.Defaults)
+	var vars []v1.EnvVar
+	var err error
+	switch settings.ConfigProvider {
+	case ConfigProviderK8s:
+		vars, err = getEnvVarRefs(role, settings.Defaults)
+	case ConfigProviderVault:
+		vars, err = getVaultEnvVars(role, settings)
+	default:
+		vars, err = getEnvVars(role, settings.Defaults)
+	}
 	if err != nil {
 		return v1.PodTemplateSpec{}, err
 	}
 
-	var resources v1.ResourceRequirements
-
-	if settings.UseMemoryLimits {
-		resources = v1.ResourceRequirements{
-			Requests: v1.ResourceList{
-				v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dMi", role.Run.Memory)),
-			},
-		}
+	if role.Run.OOMScoreAdj != nil {
+		vars = append(vars, v1.EnvVar{
+			Name:  "FISSILE_OOM_SCORE_ADJ",
+			Value: strconv.Itoa(*role.Run.OOMScoreAdj),
+		})
 	}
 
+	resources := getContainerResources(role, settings)
+
 	securityContext := getSecurityContext(role)
 
 	ports, err := getContainerPorts(role)
@@ -52,10 +65,8 @@ func NewPodTemplate(role *model.Role, settings *ExportSettings) (v1.PodTemplateS
 
 	podSpec := v1.PodTemplateSpec{
 		ObjectMeta: v1.ObjectMeta{
-			Name: role.Name,
-			Labels: map[string]string{
-				RoleNameLabel: role.Name,
-			},
+			Name:   role.Name,
+			Labels: roleLabels(role),
 		},
 		Spec: v1.PodSpec{
 			Containers: []v1.Container{
@@ -69,26 +80,89 @@ func NewPodTemplate(role *model.Role, settings *ExportSettings) (v1.PodTemplateS
 					SecurityContext: securityContext,
 				},
 			},
-			RestartPolicy: v1.RestartPolicyAlways,
-			DNSPolicy:     v1.DNSClusterFirst,
+			Volumes:         getVolumes(role),
+			RestartPolicy:   v1.RestartPolicyAlways,
+			DNSPolicy:       v1.DNSClusterFirst,
+			SecurityContext: getPodSecurityContext(role),
 		},
 	}
 
-	livenessProbe := getContainerLivenessProbe(role)
+	if role.IsActivePassive() {
+		// Pods start out passive, until their probe tells them otherwise
+		podSpec.ObjectMeta.Labels[RoleActiveLabel] = "false"
+	}
+	if needsServiceAccount(role) {
+		podSpec.Spec.ServiceAccountName = roleServiceAccountName(role)
+	}
+	if wait := getWaitInitContainer(role, image); wait != nil {
+		if err := setInitContainers(&podSpec, []v1.Container{*wait}); err != nil {
+			return v1.PodTemplateSpec{}, err
+		}
+	}
+
+	livenessProbe, err := getContainerLivenessProbe(role)
+	if err != nil {
+		return v1.PodTemplateSpec{}, err
+	}
 	readinessProbe, err := getContainerReadinessProbe(role)
 	if err != nil {
 		return v1.PodTemplateSpec{}, err
 	}
-	if livenessProbe != nil || readinessProbe != nil {
-		for i := range podSpec.Spec.Containers {
-			podSpec.Spec.Containers[i].LivenessProbe = livenessProbe
-			podSpec.Spec.Containers[i].ReadinessProbe = readinessProbe
+	podSpec.Spec.Containers[0].LivenessProbe = livenessProbe
+	podSpec.Spec.Containers[0].ReadinessProbe = readinessProbe
+
+	if drainCommand := role.GetDrainCommand(); drainCommand != nil {
+		podSpec.Spec.Containers[0].Lifecycle = &v1.Lifecycle{
+			PreStop: &v1.Handler{
+				Exec: &v1.ExecAction{
+					Command: drainCommand,
+				},
+			},
 		}
 	}
 
+	for _, sidecar := range role.Sidecars {
+		podSpec.Spec.Containers = append(podSpec.Spec.Containers, getSidecarContainer(sidecar, vars))
+	}
+
 	return podSpec, nil
 }
 
+// getSidecarContainer returns the container of a sidecar of a role. It gets
+// those of the role's environment variables it asks for, whichever way they
+// are passed, and mounts the role's volumes it shares.
+func getSidecarContainer(sidecar *model.RoleSidecar, roleVars []v1.EnvVar) v1.Container {
+	wanted := make(map[string]bool, len(sidecar.Env))
+	for _, name := range sidecar.Env {
+		wanted[name] = true
+	}
+
+	vars := make([]v1.EnvVar, 0, len(sidecar.Env)+1)
+	for _, envVar := range roleVars {
+		if wanted[envVar.Name] {
+			vars = append(vars, envVar)
+		}
+	}
+	vars = append(vars, getNamespaceEnvVar())
+
+	mounts := make([]v1.VolumeMount, 0, len(sidecar.Volumes))
+	for _, volume := range sidecar.Volumes {
+		mounts = append(mounts, v1.VolumeMount{
+			Name:      volume.Tag,
+			MountPath: volume.Path,
+			ReadOnly:  false,
+		})
+	}
+
+	return v1.Container{
+		Name:         sidecar.Name,
+		Image:        sidecar.Image,
+		Command:      sidecar.Command,
+		Env:          vars,
+		VolumeMounts: mounts,
+	}
+}
+
 // getContainerImageName returns the name of the docker image to use for a role
 func getContainerImageName(role *model.Role, settings *ExportSettings) (string, error) {
 
@@ -111,6 +185,38 @@ func getContainerImageName(role *model.Role, settings *ExportSettings) (string,
 	return imageName, nil
 }
 
+// getContainerResources returns the resources requested by the container of
+// a role, and its limits. Memory is only set with UseMemoryLimits.
+func getContainerResources(role *model.Role, settings *ExportSettings) v1.ResourceRequirements {
+	resources := v1.ResourceRequirements{
+		Requests: v1.ResourceList{},
+		Limits:   v1.ResourceList{},
+	}
+
+	if settings.UseMemoryLimits {
+		resources.Requests[v1.ResourceMemory] = resource.MustParse(fmt.Sprintf("%dMi", role.Run.Memory.Request))
+		if role.Run.Memory.Limit > 0 {
+			resources.Limits[v1.ResourceMemory] = resource.MustParse(fmt.Sprintf("%dMi", role.Run.Memory.Limit))
+		}
+	}
+
+	if role.Run.CPU.Request > 0 {
+		resources.Requests[v1.ResourceCPU] = resource.MustParse(fmt.Sprintf("%dm", int64(math.Round(role.Run.CPU.Request*1000))))
+	}
+	if role.Run.CPU.Limit > 0 {
+		resources.Limits[v1.ResourceCPU] = resource.MustParse(fmt.Sprintf("%dm", int64(math.Round(role.Run.CPU.Limit*1000))))
+	}
+
+	if len(resources.Requests) == 0 {
+		resources.Requests = nil
+	}
+	if len(resources.Limits) == 0 {
+		resources.Limits = nil
+	}
+
+	return resources
+}
+
 // getContainerPorts returns a list of ports for a role
 func getContainerPorts(role *model.Role) ([]v1.ContainerPort, error) {
 	result := make([]v1.ContainerPort, 0, len(role.Run.ExposedPorts))
@@ -161,7 +267,7 @@ func getContainerPorts(role *model.Role) ([]v1.ContainerPort, error) {
 
 // getVolumeMounts gets the list of volume mounts for a role
 func getVolumeMounts(role *model.Role) []v1.VolumeMount {
-	resultLen := len(role.Run.PersistentVolumes) + len(role.Run.SharedVolumes)
+	resultLen := len(role.Run.PersistentVolumes) + len(role.Run.SharedVolumes) + len(role.Run.EphemeralVolumes)
 	result := make([]v1.VolumeMount, 0, resultLen)
 
 	for _, volume := range role.Run.PersistentVolumes {
@@ -180,17 +286,153 @@ func getVolumeMounts(role *model.Role) []v1.VolumeMount {
 		})
 	}
 
+	for _, volume := range role.Run.EphemeralVolumes {
+		result = append(result, v1.VolumeMount{
+			Name:      volume.Tag,
+			MountPath: volume.Path,
+			ReadOnly:  false,
+		})
+	}
+
+	if role.Run.Resources != nil && role.Run.Resources.ShmSize > 0 {
+		result = append(result, v1.VolumeMount{
+			Name:      shmVolumeName,
+			MountPath: "/dev/shm",
+			ReadOnly:  false,
+		})
+	}
+
 	return result
 }
 
+// getVolumes gets the list of pod-level volumes for a role: the claim of each
+// shared volume, an empty dir for each ephemeral volume, and for /dev/shm. Kubernetes has no equivalent of
+// docker's --shm-size; a memory backed volume is mounted over /dev/shm
+// instead. Neither it nor ephemeral volumes are limited to their size. Swap
+// and ulimit settings cannot be represented at all.
+func getVolumes(role *model.Role) []v1.Volume {
+	var result []v1.Volume
+
+	for _, volume := range role.Run.SharedVolumes {
+		result = append(result, v1.Volume{
+			Name: volume.Tag,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: sharedVolumeClaimName(role, volume),
+				},
+			},
+		})
+	}
+
+	for _, volume := range role.Run.EphemeralVolumes {
+		emptyDir := &v1.EmptyDirVolumeSource{}
+		if volume.Medium == model.VolumeMediumMemory {
+			emptyDir.Medium = v1.StorageMediumMemory
+		}
+		result = append(result, v1.Volume{
+			Name:         volume.Tag,
+			VolumeSource: v1.VolumeSource{EmptyDir: emptyDir},
+		})
+	}
+
+	if role.Run.Resources != nil && role.Run.Resources.ShmSize > 0 {
+		result = append(result, v1.Volume{
+			Name: shmVolumeName,
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{
+					Medium: v1.StorageMediumMemory,
+				},
+			},
+		})
+	}
+
+	return result
+}
+
+// getEnvVars returns the environment variables of a role, with their values
+// given directly, except for secret ones; those are taken from the Secret of
+// the role, so that they never appear in plain text
 func getEnvVars(role *model.Role, defaults map[string]string) ([]v1.EnvVar, error) {
+	values, err := getVariableValues(role, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]v1.EnvVar, 0, len(values)+1)
+
+	for _, value := range values {
+		if value.variable.IsSecret() {
+			result = append(result, v1.EnvVar{
+				Name: value.variable.Name,
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: secretName(role)},
+						Key:                  value.variable.Name,
+					},
+				},
+			})
+			continue
+		}
+		result = append(result, v1.EnvVar{
+			Name:  value.variable.Name,
+			Value: value.value,
+		})
+	}
+
+	return append(result, getNamespaceEnvVar()), nil
+}
+
+// getEnvVarRefs returns the environment variables of a role, with their
+// values taken from the ConfigMap and Secret of the role
+func getEnvVarRefs(role *model.Role, defaults map[string]string) ([]v1.EnvVar, error) {
+	values, err := getVariableValues(role, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]v1.EnvVar, 0, len(values)+1)
+
+	for _, value := range values {
+		source := &v1.EnvVarSource{}
+		if value.variable.IsSecret() {
+			source.SecretKeyRef = &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: secretName(role)},
+				Key:                  value.variable.Name,
+			}
+		} else {
+			source.ConfigMapKeyRef = &v1.ConfigMapKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: configMapName(role)},
+				Key:                  value.variable.Name,
+			}
+		}
+
+		result = append(result, v1.EnvVar{
+			Name:      value.variable.Name,
+			ValueFrom: source,
+		})
+	}
+
+	return append(result, getNamespaceEnvVar()), nil
+}
+
+// variableValue is a configuration variable of a role, with its value
+type variableValue struct {
+	variable *model.ConfigurationVariable
+	value    string
+}
+
+// getVariableValues returns the values of all configuration variables used by
+// a role; the defaults take precedence over the role manifest. Variables
+// without any value are skipped. The values are serialized according to the
+// types of their variables.
+func getVariableValues(role *model.Role, defaults map[string]string) ([]variableValue, error) {
 	configs, err := role.GetVariablesForRole()
 
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]v1.EnvVar, 0, len(configs))
+	result := make([]variableValue, 0, len(configs))
 
 	for _, config := range configs {
 		var value interface{}
@@ -205,34 +447,35 @@ func getEnvVars(role *model.Role, defaults map[string]string) ([]v1.EnvVar, erro
 			continue
 		}
 
-		var stringifiedValue string
-		if valueAsString, ok := value.(string); ok {
-			stringifiedValue, err = strconv.Unquote(fmt.Sprintf(`"%s"`, valueAsString))
-			if err != nil {
-				stringifiedValue = valueAsString
-			}
-		} else {
-			stringifiedValue = fmt.Sprintf("%v", value)
+		stringifiedValue, err := config.FormatValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of the configuration variable %s: %s", config.Name, err)
 		}
 
-		result = append(result, v1.EnvVar{
-			Name:  config.Name,
-			Value: stringifiedValue,
+		result = append(result, variableValue{
+			variable: config,
+			value:    stringifiedValue,
 		})
 	}
 
-	result = append(result, v1.EnvVar{
+	return result, nil
+}
+
+// getNamespaceEnvVar returns the environment variable holding the namespace
+// the pod runs in
+func getNamespaceEnvVar() v1.EnvVar {
+	return v1.EnvVar{
 		Name: "KUBERNETES_NAMESPACE",
 		ValueFrom: &v1.EnvVarSource{
 			FieldRef: &v1.ObjectFieldSelector{
 				FieldPath: "metadata.namespace",
 			},
 		},
-	})
-
-	return result, nil
+	}
 }
 
+// getSecurityContext returns the security context of the container of a
+// role: its capabilities, and the user it runs as
 func getSecurityContext(role *model.Role) *v1.SecurityContext {
 	privileged := true
 
@@ -241,7 +484,8 @@ func getSecurityContext(role *model.Role) *v1.SecurityContext {
 		c = strings.ToUpper(c)
 		if c == "ALL" {
 			sc.Privileged = &privileged
-			return sc
+			sc.Capabilities = nil
+			break
 		}
 		if sc.Capabilities == nil {
 			sc.Capabilities = &v1.Capabilities{}
@@ -249,13 +493,36 @@ func getSecurityContext(role *model.Role) *v1.SecurityContext {
 		sc.Capabilities.Add = append(sc.Capabilities.Add, v1.Capability(c))
 	}
 
-	if sc.Capabilities == nil {
+	if role.Run.User != nil {
+		user := int64(*role.Run.User)
+		nonRoot := user != 0
+		sc.RunAsUser = &user
+		sc.RunAsNonRoot = &nonRoot
+	}
+
+	if sc.Privileged == nil && sc.Capabilities == nil && sc.RunAsUser == nil {
 		return nil
 	}
 	return sc
 }
 
-func getContainerLivenessProbe(role *model.Role) *v1.Probe {
+// getPodSecurityContext returns the security context of the pod of a role:
+// the volumes belong to the group the role runs as, so it can write to them
+func getPodSecurityContext(role *model.Role) *v1.PodSecurityContext {
+	if role.Run.Group == nil {
+		return nil
+	}
+	group := int64(*role.Run.Group)
+	return &v1.PodSecurityContext{FSGroup: &group}
+}
+
+// getContainerLivenessProbe returns the liveness probe of a role: its
+// liveness health check, or else a check of monit for BOSH roles
+func getContainerLivenessProbe(role *model.Role) (*v1.Probe, error) {
+	if role.Run != nil && role.Run.HealthCheck != nil && role.Run.HealthCheck.Liveness != nil {
+		return getContainerProbe(role, "liveness", role.Run.HealthCheck.Liveness)
+	}
+
 	switch role.Type {
 	case model.RoleTypeBosh:
 		return &v1.Probe{
@@ -264,40 +531,22 @@ func getContainerLivenessProbe(role *model.Role) *v1.Probe {
 					Port: intstr.FromInt(monitPort),
 				},
 			},
-			// TODO: make this configurable (figure out where the knob should live)
 			InitialDelaySeconds: 600,
-		}
+		}, nil
 	default:
-		return nil
+		return nil, nil
 	}
 }
 
+// getContainerReadinessProbe returns the readiness probe of a role: its
+// readiness health check, or else a check of its first TCP port for BOSH
+// roles
 func getContainerReadinessProbe(role *model.Role) (*v1.Probe, error) {
 	if role.Run == nil {
 		return nil, nil
 	}
-	if role.Run.HealthCheck != nil {
-		if role.Run.HealthCheck.URL != "" {
-			return getContainerURLReadinessProbe(role)
-		}
-		if role.Run.HealthCheck.Port != 0 {
-			return &v1.Probe{
-				Handler: v1.Handler{
-					TCPSocket: &v1.TCPSocketAction{
-						Port: intstr.FromInt(int(role.Run.HealthCheck.Port)),
-					},
-				},
-			}, nil
-		}
-		if len(role.Run.HealthCheck.Command) > 0 {
-			return &v1.Probe{
-				Handler: v1.Handler{
-					Exec: &v1.ExecAction{
-						Command: role.Run.HealthCheck.Command,
-					},
-				},
-			}, nil
-		}
+	if role.Run.HealthCheck != nil && role.Run.HealthCheck.Readiness != nil {
+		return getContainerProbe(role, "readiness", role.Run.HealthCheck.Readiness)
 	}
 	switch role.Type {
 	case model.RoleTypeBosh:
@@ -329,8 +578,45 @@ func getContainerReadinessProbe(role *model.Role) (*v1.Probe, error) {
 	}
 }
 
-func getContainerURLReadinessProbe(role *model.Role) (*v1.Probe, error) {
-	probeURL, err := url.Parse(role.Run.HealthCheck.URL)
+// getContainerProbe returns the probe for a health check of a role, of the
+// given kind. Health checks with templates run the script they are rendered
+// into when the container starts.
+func getContainerProbe(role *model.Role, kind string, healthProbe *model.HealthProbe) (*v1.Probe, error) {
+	probe := &v1.Probe{
+		InitialDelaySeconds: healthProbe.InitialDelay,
+		PeriodSeconds:       healthProbe.Period,
+		TimeoutSeconds:      healthProbe.Timeout,
+		FailureThreshold:    healthProbe.FailureThreshold,
+	}
+
+	switch {
+	case healthProbe.IsTemplated():
+		probe.Exec = &v1.ExecAction{
+			Command: []string{"bash", model.HealthCheckScript(kind)},
+		}
+	case healthProbe.URL != "":
+		action, err := getContainerURLProbeAction(role, healthProbe)
+		if err != nil {
+			return nil, err
+		}
+		probe.HTTPGet = action
+	case healthProbe.Port != 0:
+		probe.TCPSocket = &v1.TCPSocketAction{
+			Port: intstr.FromInt(int(healthProbe.Port)),
+		}
+	case len(healthProbe.Command) > 0:
+		probe.Exec = &v1.ExecAction{
+			Command: healthProbe.Command,
+		}
+	default:
+		return nil, nil
+	}
+
+	return probe, nil
+}
+
+func getContainerURLProbeAction(role *model.Role, healthProbe *model.HealthProbe) (*v1.HTTPGetAction, error) {
+	probeURL, err := url.Parse(healthProbe.URL)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid URL health check for %s: %s", role.Name, err)
 	}
@@ -370,10 +656,15 @@ func getContainerURLReadinessProbe(role *model.Role) (*v1.Probe, error) {
 			Value: base64.StdEncoding.EncodeToString([]byte(probeURL.User.String())),
 		})
 	}
-	for key, value := range role.Run.HealthCheck.Headers {
+	keys := make([]string, 0, len(healthProbe.Headers))
+	for key := range healthProbe.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
 		headers = append(headers, v1.HTTPHeader{
 			Name:  http.CanonicalHeaderKey(key),
-			Value: value,
+			Value: healthProbe.Headers[key],
 		})
 	}
 
@@ -384,16 +675,12 @@ func getContainerURLReadinessProbe(role *model.Role) (*v1.Probe, error) {
 	}
 	// probeURL.Fragment should not be sent to the server, so we ignore it here
 
-	return &v1.Probe{
-		Handler: v1.Handler{
-			HTTPGet: &v1.HTTPGetAction{
-				Host:        host,
-				Port:        port,
-				Path:        path,
-				Scheme:      scheme,
-				HTTPHeaders: headers,
-			},
-		},
+	return &v1.HTTPGetAction{
+		Host:        host,
+		Port:        port,
+		Path:        path,
+		Scheme:      scheme,
+		HTTPHeaders: headers,
 	}, nil
 }
 
@@ -431,3 +718,12 @@ func getContainerURLReadinessProbe(role *model.Role) (*v1.Probe, error) {
 //      - name: mysql-persistent-storage
 //        persistentVolumeClaim:
 //          claimName: mysql-pv-claim
+
+// roleLabels returns the labels identifying the objects of a role
+func roleLabels(role *model.Role) map[string]string {
+	labels := map[string]string{RoleNameLabel: role.Name}
+	if role.Group != "" {
+		labels[RoleGroupLabel] = role.Group
+	}
+	return labels
+}
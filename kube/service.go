@@ -12,9 +12,10 @@ import (
 
 // NewClusterIPService creates a new k8s ClusterIP service
 func NewClusterIPService(role *model.Role, headless bool) (*apiv1.Service, error) {
-	if len(role.Run.ExposedPorts) == 0 {
+	if len(role.Run.ExposedPorts) == 0 && !headless {
 		// Kubernetes refuses to create services with no ports, so we should
-		// not return anything at all in this case
+		// not return anything at all in this case. Headless services are fine
+		// without; the pods of stateful sets get their stable names from them.
 		return nil, nil
 	}
 
@@ -37,6 +38,9 @@ func NewClusterIPService(role *model.Role, headless bool) (*apiv1.Service, error
 	if headless {
 		service.ObjectMeta.Name = fmt.Sprintf("%s-pod", role.Name)
 		service.Spec.ClusterIP = apiv1.ClusterIPNone
+	} else if role.IsActivePassive() {
+		// Only the active pod gets traffic; the headless service names all
+		service.Spec.Selector[RoleActiveLabel] = "true"
 	}
 	for _, portDef := range role.Run.ExposedPorts {
 		protocol := apiv1.ProtocolTCP
@@ -67,9 +71,70 @@ func NewClusterIPService(role *model.Role, headless bool) (*apiv1.Service, error
 			}
 			service.Spec.Ports = append(service.Spec.Ports, svcPort)
 		}
-		if portDef.Public {
-			service.Spec.ExternalIPs = []string{"192.168.77.77"} // TODO Make this work on not-vagrant
+	}
+	return service, nil
+}
+
+// NewPublicService creates a k8s service making the public ports of a role
+// reachable from outside the cluster, of the type given by the settings: a
+// LoadBalancer (the default), or a NodePort service. It returns nil if the
+// role has no public ports.
+func NewPublicService(role *model.Role, settings *ExportSettings) (*apiv1.Service, error) {
+	serviceType := apiv1.ServiceTypeLoadBalancer
+	if settings.PublicServiceType != "" {
+		serviceType = apiv1.ServiceType(settings.PublicServiceType)
+	}
+
+	service := &apiv1.Service{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Service",
+		},
+		ObjectMeta: apiv1.ObjectMeta{
+			Name: fmt.Sprintf("%s-public", role.Name),
+		},
+		Spec: apiv1.ServiceSpec{
+			Type: serviceType,
+			Selector: map[string]string{
+				RoleNameLabel: role.Name,
+			},
+		},
+	}
+	if role.IsActivePassive() {
+		service.Spec.Selector[RoleActiveLabel] = "true"
+	}
+
+	for _, portDef := range role.Run.ExposedPorts {
+		if !portDef.Public {
+			continue
+		}
+
+		protocol := apiv1.ProtocolTCP
+		if strings.ToLower(portDef.Protocol) == "udp" {
+			protocol = apiv1.ProtocolUDP
+		}
+
+		minPort, maxPort, err := parsePortRange(portDef.External, portDef.Name, "external")
+		if err != nil {
+			return nil, err
 		}
+		portInfos, err := getPortInfo(portDef.Name, minPort, maxPort)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, portInfoEntry := range portInfos {
+			service.Spec.Ports = append(service.Spec.Ports, apiv1.ServicePort{
+				Name:       portInfoEntry.name,
+				Port:       portInfoEntry.port,
+				Protocol:   protocol,
+				TargetPort: intstr.FromString(portInfoEntry.name),
+			})
+		}
+	}
+
+	if len(service.Spec.Ports) == 0 {
+		return nil, nil
 	}
 	return service, nil
 }
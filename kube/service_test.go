@@ -149,3 +149,76 @@ func TestHeadlessServiceOK(t *testing.T) {
 	}
 	_ = isYAMLSubset(assert, expected, actual, []string{})
 }
+
+func TestServiceWithoutPorts(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "exposed-ports.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+	role.Run.ExposedPorts = nil
+
+	service, err := NewClusterIPService(role, false)
+	if assert.NoError(err) {
+		assert.Nil(service, "Roles without ports should not have a service")
+	}
+
+	service, err = NewClusterIPService(role, true)
+	if assert.NoError(err) && assert.NotNil(service, "Stateful roles need a headless service for the names of their pods") {
+		assert.Equal("myrole-pod", service.Name)
+		assert.Equal("None", service.Spec.ClusterIP)
+		assert.Empty(service.Spec.Ports)
+	}
+}
+
+func TestPublicServiceOK(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "ingress.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	service, err := NewPublicService(role, &ExportSettings{})
+	if !assert.NoError(err) || !assert.NotNil(service) {
+		return
+	}
+
+	yamlConfig := bytes.Buffer{}
+	if err := WriteYamlConfig(service, &yamlConfig); !assert.NoError(err) {
+		return
+	}
+	var expected, actual interface{}
+	if !assert.NoError(yaml.Unmarshal(yamlConfig.Bytes(), &actual)) {
+		return
+	}
+	expectedYAML := strings.Replace(`---
+			metadata:
+				name: myrole-public
+			spec:
+				ports:
+				-
+						name: http
+						port: 80
+						targetPort: http
+				selector:
+					skiff-role-name: myrole
+				type: LoadBalancer
+	`, "\t", "    ", -1)
+	if !assert.NoError(yaml.Unmarshal([]byte(expectedYAML), &expected)) {
+		return
+	}
+	_ = isYAMLSubset(assert, expected, actual, []string{})
+	assert.Len(service.Spec.Ports, 1, "Only public ports are exposed")
+
+	service, err = NewPublicService(role, &ExportSettings{PublicServiceType: PublicServiceTypeNodePort})
+	if assert.NoError(err) && assert.NotNil(service) {
+		assert.Equal(PublicServiceTypeNodePort, string(service.Spec.Type))
+	}
+
+	role.Run.ExposedPorts[0].Public = false
+	service, err = NewPublicService(role, &ExportSettings{})
+	assert.NoError(err)
+	assert.Nil(service, "Roles without public ports have no public service")
+}
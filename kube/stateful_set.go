@@ -11,12 +11,39 @@ import (
 	"k8s.io/client-go/pkg/runtime"
 )
 
+// StatefulSet is a k8s stateful set, extended with the update strategy which
+// the vendored client library does not know about yet
+type StatefulSet struct {
+	meta.TypeMeta `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+	Spec          StatefulSetSpec `json:"spec,omitempty"`
+}
+
+// StatefulSetSpec is the spec of a StatefulSet, including its update strategy
+type StatefulSetSpec struct {
+	v1beta1.StatefulSetSpec `json:",inline"`
+	UpdateStrategy          *StatefulSetUpdateStrategy `json:"updateStrategy,omitempty"`
+}
+
+// StatefulSetUpdateStrategy describes how the pods of a StatefulSet get updated
+type StatefulSetUpdateStrategy struct {
+	Type          string                            `json:"type"`
+	RollingUpdate *RollingUpdateStatefulSetStrategy `json:"rollingUpdate,omitempty"`
+}
+
+// RollingUpdateStatefulSetStrategy holds the parameters of a rolling update
+type RollingUpdateStatefulSetStrategy struct {
+	Partition *int32 `json:"partition,omitempty"`
+}
+
 // NewStatefulSet returns a k8s stateful set for the given role
-func NewStatefulSet(role *model.Role, settings *ExportSettings) (*v1beta1.StatefulSet, *v1.List, error) {
+func NewStatefulSet(role *model.Role, settings *ExportSettings) (*StatefulSet, *v1.List, error) {
 	// For each StatefulSet, we need two services -- one for the public (inside
-	// the namespace) endpoint, and one headless service to control the pods.
+	// the namespace) endpoint, and one headless service to control the pods,
+	// giving them stable network identities. Shared volumes are claimed next
+	// to them, once for all pods.
 	if role == nil {
-		panic(fmt.Sprintf("No role given"))
+		return nil, nil, fmt.Errorf("No role given")
 	}
 
 	podTemplate, err := NewPodTemplate(role, settings)
@@ -36,85 +63,120 @@ func NewStatefulSet(role *model.Role, settings *ExportSettings) (*v1beta1.Statef
 		return nil, nil, err
 	}
 
-	return &v1beta1.StatefulSet{
+	replicas := role.InitialInstances()
+
+	var deps []runtime.RawExtension
+	if headedService != nil {
+		deps = append(deps, runtime.RawExtension{Object: headedService})
+	}
+	deps = append(deps, runtime.RawExtension{Object: headlessService})
+	for _, claim := range getSharedVolumeClaims(role) {
+		deps = append(deps, runtime.RawExtension{Object: claim})
+	}
+
+	return &StatefulSet{
 			TypeMeta: meta.TypeMeta{
 				APIVersion: "apps/v1beta1",
 				Kind:       "StatefulSet",
 			},
 			ObjectMeta: v1.ObjectMeta{
-				Name: role.Name,
-				Labels: map[string]string{
-					RoleNameLabel: role.Name,
-				},
+				Name:   role.Name,
+				Labels: roleLabels(role),
 			},
-			Spec: v1beta1.StatefulSetSpec{
-				Replicas:             &role.Run.Scaling.Min,
-				ServiceName:          fmt.Sprintf("%s-pod", role.Name),
-				Template:             podTemplate,
-				VolumeClaimTemplates: volumeClaimTemplates,
+			Spec: StatefulSetSpec{
+				StatefulSetSpec: v1beta1.StatefulSetSpec{
+					Replicas:             &replicas,
+					ServiceName:          fmt.Sprintf("%s-pod", role.Name),
+					Template:             podTemplate,
+					VolumeClaimTemplates: volumeClaimTemplates,
+				},
+				UpdateStrategy: getUpdateStrategy(role),
 			},
 		}, &v1.List{
 			TypeMeta: meta.TypeMeta{
 				APIVersion: "v1",
 				Kind:       "List",
 			},
-			Items: []runtime.RawExtension{
-				runtime.RawExtension{
-					Object: headedService,
-				},
-				runtime.RawExtension{
-					Object: headlessService,
-				},
-			},
+			Items: deps,
 		}, nil
 }
 
-// getVolumeClaims returns the list of persistent volume claims from a role
-func getVolumeClaims(role *model.Role) []v1.PersistentVolumeClaim {
-	totalLength := len(role.Run.PersistentVolumes) + len(role.Run.SharedVolumes)
-	claims := make([]v1.PersistentVolumeClaim, 0, totalLength)
-
-	types := []struct {
-		volumeDefinitions []*model.RoleRunVolume
-		storageClass      string
-		accessMode        v1.PersistentVolumeAccessMode
-	}{
-		{
-			role.Run.PersistentVolumes,
-			"persistent",
-			v1.ReadWriteOnce,
-		},
-		{
-			role.Run.SharedVolumes,
-			"shared",
-			v1.ReadWriteMany,
+// getUpdateStrategy returns the partitioned rolling update strategy for roles
+// with canary settings; stateful sets of other roles use the default strategy
+func getUpdateStrategy(role *model.Role) *StatefulSetUpdateStrategy {
+	if role.Run.Canary == nil {
+		return nil
+	}
+
+	partition := role.Run.Canary.Partition
+	return &StatefulSetUpdateStrategy{
+		Type: "RollingUpdate",
+		RollingUpdate: &RollingUpdateStatefulSetStrategy{
+			Partition: &partition,
 		},
 	}
+}
 
-	for _, volumeTypeInfo := range types {
-		for _, volume := range volumeTypeInfo.volumeDefinitions {
-			pvc := v1.PersistentVolumeClaim{
-				ObjectMeta: v1.ObjectMeta{
-					Name: volume.Tag,
-					Annotations: map[string]string{
-						"volume.beta.kubernetes.io/storage-class": volumeTypeInfo.storageClass,
-					},
-				},
-				Spec: v1.PersistentVolumeClaimSpec{
-					AccessModes: []v1.PersistentVolumeAccessMode{
-						volumeTypeInfo.accessMode,
-					},
-					Resources: v1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceStorage: *resource.NewScaledQuantity(int64(volume.Size), resource.Giga),
-						},
-					},
-				},
-			}
+// The storage classes volumes are claimed from unless they name one
+const (
+	defaultPersistentStorageClass = "persistent"
+	defaultSharedStorageClass     = "shared"
+)
 
-			claims = append(claims, pvc)
-		}
+// getVolumeClaims returns the claim templates for the persistent volumes of a
+// role; each pod of the stateful set gets volumes of its own, kept as the pod
+// moves
+func getVolumeClaims(role *model.Role) []v1.PersistentVolumeClaim {
+	claims := make([]v1.PersistentVolumeClaim, 0, len(role.Run.PersistentVolumes))
+	for _, volume := range role.Run.PersistentVolumes {
+		claims = append(claims, newVolumeClaim(volume.Tag, volume, defaultPersistentStorageClass, v1.ReadWriteOnce))
 	}
+	return claims
+}
 
+// getSharedVolumeClaims returns the claims for the shared volumes of a role.
+// Unlike persistent volumes, each is claimed once, for all pods of the role to
+// mount.
+func getSharedVolumeClaims(role *model.Role) []*v1.PersistentVolumeClaim {
+	claims := make([]*v1.PersistentVolumeClaim, 0, len(role.Run.SharedVolumes))
+	for _, volume := range role.Run.SharedVolumes {
+		claim := newVolumeClaim(sharedVolumeClaimName(role, volume), volume, defaultSharedStorageClass, v1.ReadWriteMany)
+		claim.TypeMeta = meta.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+		}
+		claim.ObjectMeta.Labels = roleLabels(role)
+		claims = append(claims, &claim)
+	}
 	return claims
 }
+
+// sharedVolumeClaimName returns the name of the claim of a shared volume
+func sharedVolumeClaimName(role *model.Role, volume *model.RoleRunVolume) string {
+	return fmt.Sprintf("%s-%s", role.Name, volume.Tag)
+}
+
+// newVolumeClaim returns a claim for the size of the volume, from its storage
+// class or else the given one
+func newVolumeClaim(name string, volume *model.RoleRunVolume, storageClass string, accessMode v1.PersistentVolumeAccessMode) v1.PersistentVolumeClaim {
+	if volume.StorageClass != "" {
+		storageClass = volume.StorageClass
+	}
+
+	return v1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				VolumeStorageClassAnnotation: storageClass,
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{accessMode},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: *resource.NewScaledQuantity(int64(volume.Size), resource.Giga),
+				},
+			},
+		},
+	}
+}
@@ -57,6 +57,10 @@ func isYAMLSubset(assert *assert.Assertions, expected, actual interface{}, prefi
 }
 
 func statefulSetTestLoadManifest(assert *assert.Assertions, manifestName string) (*model.RoleManifest, *model.Role) {
+	return statefulSetTestLoadManifestRole(assert, manifestName, "myrole")
+}
+
+func statefulSetTestLoadManifestRole(assert *assert.Assertions, manifestName, roleName string) (*model.RoleManifest, *model.Role) {
 	workDir, err := os.Getwd()
 	assert.NoError(err)
 
@@ -75,7 +79,7 @@ func statefulSetTestLoadManifest(assert *assert.Assertions, manifestName string)
 	var role *model.Role
 	for _, r := range manifest.Roles {
 		if r != nil {
-			if r.Name == "myrole" {
+			if r.Name == roleName {
 				role = r
 			}
 		}
@@ -87,6 +91,11 @@ func statefulSetTestLoadManifest(assert *assert.Assertions, manifestName string)
 	return manifest, role
 }
 
+func TestStatefulSetNoRole(t *testing.T) {
+	_, _, err := NewStatefulSet(nil, &ExportSettings{})
+	assert.EqualError(t, err, "No role given")
+}
+
 func TestStatefulSetPorts(t *testing.T) {
 	assert := assert.New(t)
 
@@ -213,7 +222,7 @@ func TestStatefulSetVolumes(t *testing.T) {
 		return
 	}
 
-	statefulset, _, err := NewStatefulSet(role, &ExportSettings{})
+	statefulset, deps, err := NewStatefulSet(role, &ExportSettings{})
 	if !assert.NoError(err) {
 		return
 	}
@@ -250,6 +259,11 @@ func TestStatefulSetVolumes(t *testing.T) {
 					-
 						name: shared-volume
 						mountPath: /mnt/shared
+				volumes:
+				-
+					name: shared-volume
+					persistentVolumeClaim:
+						claimName: myrole-shared-volume
 		volumeClaimTemplates:
 			-
 				metadata:
@@ -261,19 +275,101 @@ func TestStatefulSetVolumes(t *testing.T) {
 					resources:
 						requests:
 							storage: 5G
-			-
-				metadata:
-					annotations:
-						volume.beta.kubernetes.io/storage-class: shared
-					name: shared-volume
-				spec:
-					accessModes: [ReadWriteMany]
-					resources:
-						requests:
-							storage: 40G
+	`, "\t", "    ", -1)
+	if !assert.NoError(yaml.Unmarshal([]byte(expectedYAML), &expected)) {
+		return
+	}
+	if !isYAMLSubset(assert, expected, actual, []string{}) {
+		return
+	}
+	claimTemplates := actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})["volumeClaimTemplates"]
+	assert.Len(claimTemplates, 1, "Shared volumes should not be claimed per pod")
+
+	yamlConfig.Reset()
+	if !assert.NoError(WriteYamlConfig(deps, &yamlConfig)) {
+		return
+	}
+	actual = nil
+	if !assert.NoError(yaml.Unmarshal(yamlConfig.Bytes(), &actual)) {
+		return
+	}
+	expectedYAML = strings.Replace(`---
+	items:
+	-
+		# The role has no ports, only the headless service is needed
+		metadata:
+			name: myrole-pod
+		spec:
+			clusterIP: None
+	-
+		apiVersion: v1
+		kind: PersistentVolumeClaim
+		metadata:
+			annotations:
+				volume.beta.kubernetes.io/storage-class: shared
+			labels:
+				skiff-role-name: myrole
+			name: myrole-shared-volume
+		spec:
+			accessModes: [ReadWriteMany]
+			resources:
+				requests:
+					storage: 40G
+	`, "\t", "    ", -1)
+	expected = nil
+	if !assert.NoError(yaml.Unmarshal([]byte(expectedYAML), &expected)) {
+		return
+	}
+	_ = isYAMLSubset(assert, expected, actual, []string{})
+}
+
+func TestStatefulSetCanaryPartition(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "canary.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	statefulset, _, err := NewStatefulSet(role, &ExportSettings{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	yamlConfig := bytes.Buffer{}
+	err = WriteYamlConfig(statefulset, &yamlConfig)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var expected, actual interface{}
+	if !assert.NoError(yaml.Unmarshal(yamlConfig.Bytes(), &actual)) {
+		return
+	}
+	expectedYAML := strings.Replace(`---
+	apiVersion: apps/v1beta1
+	kind: StatefulSet
+	metadata:
+		name: myrole
+	spec:
+		replicas: 3
+		serviceName: myrole-pod
+		updateStrategy:
+			type: RollingUpdate
+			rollingUpdate:
+				partition: 2
 	`, "\t", "    ", -1)
 	if !assert.NoError(yaml.Unmarshal([]byte(expectedYAML), &expected)) {
 		return
 	}
 	_ = isYAMLSubset(assert, expected, actual, []string{})
+
+	_, role = statefulSetTestLoadManifest(assert, "volumes.yml")
+	if role == nil {
+		return
+	}
+	statefulset, _, err = NewStatefulSet(role, &ExportSettings{})
+	if assert.NoError(err) {
+		assert.Nil(statefulset.Spec.UpdateStrategy, "Roles without canary settings should use the default strategy")
+	}
 }
@@ -0,0 +1,107 @@
+package kube
+
+import (
+	"path"
+
+	"github.com/hpcloud/fissile/model"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// DefaultVaultPath is the Vault KV path the secrets of all roles are stored
+// under, unless configured otherwise
+const DefaultVaultPath = "secret/fissile"
+
+// VaultPathEnvVar is the environment variable telling a role where its
+// secrets are stored in Vault
+const VaultPathEnvVar = "VAULT_SECRETS_PATH"
+
+// VaultSecretName is the Secret the containers read the address of Vault
+// (key VaultAddressKey) and the token to access it with (key VaultTokenKey)
+// from. It is not generated; it has to be created along with the roles.
+const VaultSecretName = "vault"
+
+// Keys of the Vault Secret
+const (
+	VaultAddressKey = "address"
+	VaultTokenKey   = "token"
+)
+
+// VaultSecretsPath returns the Vault KV path holding the secrets of a role
+func VaultSecretsPath(role *model.Role, settings *ExportSettings) string {
+	vaultPath := settings.VaultPath
+	if vaultPath == "" {
+		vaultPath = DefaultVaultPath
+	}
+	return path.Join(vaultPath, role.Name)
+}
+
+// NewVaultSecrets returns the values of all configuration variables of the
+// given role that are secret or generated, to be written to Vault. It returns
+// nil if the role has no such variables.
+func NewVaultSecrets(role *model.Role, settings *ExportSettings) (map[string]string, error) {
+	values, err := getVariableValues(role, settings.Defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets map[string]string
+	for _, value := range values {
+		if !value.variable.IsSecret() {
+			continue
+		}
+		if secrets == nil {
+			secrets = make(map[string]string)
+		}
+		secrets[value.variable.Name] = value.value
+	}
+
+	return secrets, nil
+}
+
+// getVaultEnvVars returns the environment variables of a role, leaving out
+// the secret ones; those are read from Vault by run.sh, from the path given
+// in VAULT_SECRETS_PATH, using the address and token of the Vault Secret
+func getVaultEnvVars(role *model.Role, settings *ExportSettings) ([]v1.EnvVar, error) {
+	values, err := getVariableValues(role, settings.Defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]v1.EnvVar, 0, len(values)+4)
+
+	for _, value := range values {
+		if value.variable.IsSecret() {
+			continue
+		}
+		result = append(result, v1.EnvVar{
+			Name:  value.variable.Name,
+			Value: value.value,
+		})
+	}
+
+	result = append(result,
+		v1.EnvVar{
+			Name:  VaultPathEnvVar,
+			Value: VaultSecretsPath(role, settings),
+		},
+		vaultSecretEnvVar("VAULT_ADDR", VaultAddressKey),
+		vaultSecretEnvVar("VAULT_TOKEN", VaultTokenKey),
+	)
+
+	return append(result, getNamespaceEnvVar()), nil
+}
+
+// vaultSecretEnvVar returns an environment variable set from a key of the
+// Vault Secret
+func vaultSecretEnvVar(name, key string) v1.EnvVar {
+	return v1.EnvVar{
+		Name: name,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: VaultSecretName},
+				Key:                  key,
+			},
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestVaultProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "config-provider.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	settings := &ExportSettings{
+		Defaults:       map[string]string{"CONTROL_PASSWORD": "generated", "PRIVATE_KEY": "not-so-private"},
+		ConfigProvider: ConfigProviderVault,
+	}
+
+	assert.Equal("secret/fissile/myrole", VaultSecretsPath(role, settings))
+
+	secrets, err := NewVaultSecrets(role, settings)
+	if assert.NoError(err) {
+		assert.Equal(map[string]string{
+			"CONTROL_PASSWORD": "generated",
+			"PRIVATE_KEY":      "not-so-private",
+		}, secrets)
+	}
+
+	settings.VaultPath = "secret/scf/"
+	assert.Equal("secret/scf/myrole", VaultSecretsPath(role, settings))
+
+	pod, err := NewPodTemplate(role, settings)
+	if !assert.NoError(err) {
+		return
+	}
+
+	values := make(map[string]string)
+	refs := make(map[string]*v1.EnvVarSource)
+	for _, envVar := range pod.Spec.Containers[0].Env {
+		values[envVar.Name] = envVar.Value
+		refs[envVar.Name] = envVar.ValueFrom
+	}
+
+	assert.Equal("tor.example.com", values["HOSTNAME"])
+	assert.Equal("secret/scf/myrole", values[VaultPathEnvVar])
+	assert.NotContains(values, "CONTROL_PASSWORD")
+	assert.NotContains(values, "PRIVATE_KEY")
+	assert.Contains(values, "KUBERNETES_NAMESPACE")
+
+	// run.sh reads the secrets with the address and token of the Vault Secret
+	for name, key := range map[string]string{"VAULT_ADDR": VaultAddressKey, "VAULT_TOKEN": VaultTokenKey} {
+		if assert.NotNil(refs[name]) && assert.NotNil(refs[name].SecretKeyRef) {
+			assert.Equal(VaultSecretName, refs[name].SecretKeyRef.Name)
+			assert.Equal(key, refs[name].SecretKeyRef.Key)
+		}
+	}
+}
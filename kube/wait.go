@@ -0,0 +1,66 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hpcloud/fissile/model"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// waitContainerName is the name of the init container waiting for the roles
+// a role depends on
+const waitContainerName = "wait-for-roles"
+
+// roleDependencies lists the roles a role waits for, as <kind>/<name> of the
+// k8s object running them. Roles deployed scaled down to zero instances are
+// left out, they may never come up.
+func roleDependencies(role *model.Role) []string {
+	var dependencies []string
+	for _, dependency := range role.Dependencies() {
+		if dependency.IsScaledDown() {
+			continue
+		}
+		kind := "deployment"
+		if dependency.Type == model.RoleTypeBoshTask {
+			kind = "job"
+		} else if dependency.IsStateful() {
+			kind = "statefulset"
+		}
+		dependencies = append(dependencies, fmt.Sprintf("%s/%s", kind, dependency.Name))
+	}
+	return dependencies
+}
+
+// getWaitInitContainer returns the init container waiting for the roles a
+// role depends on, running the script in the image of the role. It returns
+// nil for roles depending on none.
+func getWaitInitContainer(role *model.Role, image string) *v1.Container {
+	dependencies := roleDependencies(role)
+	if len(dependencies) == 0 {
+		return nil
+	}
+
+	return &v1.Container{
+		Name:    waitContainerName,
+		Image:   image,
+		Command: append([]string{"/opt/hcf/wait-for-roles.sh"}, dependencies...),
+	}
+}
+
+// setInitContainers adds init containers to a pod template. The vendored
+// client library doesn't serialize them as part of the spec, so they are
+// given by annotation, as the Kubernetes versions of its time expect.
+func setInitContainers(podSpec *v1.PodTemplateSpec, containers []v1.Container) error {
+	contents, err := json.Marshal(containers)
+	if err != nil {
+		return err
+	}
+	if podSpec.ObjectMeta.Annotations == nil {
+		podSpec.ObjectMeta.Annotations = make(map[string]string)
+	}
+	podSpec.ObjectMeta.Annotations[v1.PodInitContainersBetaAnnotationKey] = string(contents)
+	podSpec.Spec.InitContainers = containers
+	return nil
+}
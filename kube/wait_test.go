@@ -0,0 +1,55 @@
+package kube
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestPodWaitsForDependencies(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "depends-on.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	assert.Equal([]string{"job/mytask", "deployment/otherrole"}, roleDependencies(role),
+		"Roles scaled down should not be waited for")
+
+	pod, err := NewPodTemplate(role, &ExportSettings{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("myrole", pod.Spec.ServiceAccountName)
+	if assert.Len(pod.Spec.InitContainers, 1) {
+		wait := pod.Spec.InitContainers[0]
+		assert.Equal(waitContainerName, wait.Name)
+		assert.Equal(pod.Spec.Containers[0].Image, wait.Image)
+		assert.Equal([]string{"/opt/hcf/wait-for-roles.sh", "job/mytask", "deployment/otherrole"}, wait.Command)
+	}
+	var annotated []v1.Container
+	if assert.NoError(json.Unmarshal([]byte(pod.Annotations[v1.PodInitContainersBetaAnnotationKey]), &annotated)) {
+		assert.Equal(pod.Spec.InitContainers, annotated)
+	}
+
+	access := NewRoleAccess(role)
+	if assert.NotNil(access) && assert.Len(access.Items, 3) {
+		rbacRole, ok := access.Items[1].Object.(*RBACRole)
+		if assert.True(ok) && assert.Len(rbacRole.Rules, 1) {
+			assert.Equal([]string{"statefulsets", "deployments", "jobs"}, rbacRole.Rules[0].Resources)
+			assert.Equal([]string{"get"}, rbacRole.Rules[0].Verbs)
+		}
+	}
+
+	task := manifest.LookupRole("mytask")
+	pod, err = NewPodTemplate(task, &ExportSettings{})
+	if assert.NoError(err) {
+		assert.Empty(pod.Spec.InitContainers)
+		assert.Empty(pod.Annotations)
+		assert.Empty(pod.Spec.ServiceAccountName)
+	}
+	assert.Nil(NewRoleAccess(task))
+}
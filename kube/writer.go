@@ -12,6 +12,17 @@ import (
 const (
 	// RoleNameLabel is a thing
 	RoleNameLabel = "skiff-role-name"
+	// RoleGroupLabel is the group of a role, for roles that have one
+	RoleGroupLabel = "skiff-role-group"
+	// RoleTrackLabel distinguishes the canary pods of a role from the regular ones
+	RoleTrackLabel = "skiff-role-track"
+	// RoleTrackStable is the RoleTrackLabel value of regular pods
+	RoleTrackStable = "stable"
+	// RoleTrackCanary is the RoleTrackLabel value of canary pods
+	RoleTrackCanary = "canary"
+	// RoleActiveLabel marks the active pod of an active/passive role; its
+	// pods set it themselves, from the result of its probe
+	RoleActiveLabel = "skiff-role-active"
 	// VolumeStorageClassAnnotation is the annotation label for storage/v1beta1/StorageClass
 	VolumeStorageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
 )
@@ -0,0 +1,252 @@
+// Package logging writes the progress of fissile commands, filtered by level
+// and as text or JSON, apart from their results.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// Level is the severity of a log message
+type Level int
+
+// The log levels, from the most verbose
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level, as given to --log-level
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of the given name
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return Level(level), nil
+		}
+	}
+	return Info, fmt.Errorf("Invalid log level %s, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// Format is how log messages are written
+type Format string
+
+// The log formats
+const (
+	// FormatText writes messages as they are, prefixed with their subsystem
+	FormatText Format = "text"
+	// FormatJSON writes a JSON object per message, without colors
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the format of the given name
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case FormatText, FormatJSON:
+		return format, nil
+	}
+	return FormatText, fmt.Errorf("Invalid log format %s, expected one of %s, %s", name, FormatText, FormatJSON)
+}
+
+// colorCodes matches the terminal color escapes of messages, which are
+// dropped from JSON logs
+var colorCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// output is where the loggers of all subsystems write to
+type output struct {
+	sync.Mutex
+	writer io.Writer
+	level  Level
+	format Format
+	now    func() time.Time
+}
+
+// Logger writes the messages of a subsystem at or above a level
+type Logger struct {
+	output    *output
+	subsystem string
+}
+
+// New creates a logger writing the messages at or above the level to the
+// writer, in the format
+func New(writer io.Writer, level Level, format Format) *Logger {
+	return &Logger{
+		output: &output{
+			writer: writer,
+			level:  level,
+			format: format,
+			now:    time.Now,
+		},
+	}
+}
+
+// WithSubsystem returns a logger to the same output, for the messages of the
+// named subsystem, e.g. compile or docker
+func (l *Logger) WithSubsystem(subsystem string) *Logger {
+	return &Logger{output: l.output, subsystem: subsystem}
+}
+
+// Enabled returns whether messages of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.output.level
+}
+
+// Terminal returns the writer of the logger if it is a terminal showing text
+// logs, for live displays such as progress tables; nil otherwise
+func (l *Logger) Terminal() io.Writer {
+	if l.output.format != FormatText {
+		return nil
+	}
+	if file, ok := l.output.writer.(*os.File); ok && isatty.IsTerminal(file.Fd()) {
+		return file
+	}
+	return nil
+}
+
+// Debug logs a message at the debug level, formatted like fmt.Sprint
+func (l *Logger) Debug(args ...interface{}) {
+	l.Log(Debug, args...)
+}
+
+// Info logs a message at the info level, formatted like fmt.Sprint
+func (l *Logger) Info(args ...interface{}) {
+	l.Log(Info, args...)
+}
+
+// Warn logs a message at the warn level, formatted like fmt.Sprint
+func (l *Logger) Warn(args ...interface{}) {
+	l.Log(Warn, args...)
+}
+
+// Error logs a message at the error level, formatted like fmt.Sprint
+func (l *Logger) Error(args ...interface{}) {
+	l.Log(Error, args...)
+}
+
+// Debugf logs a message at the debug level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.Logf(Debug, format, args...)
+}
+
+// Infof logs a message at the info level
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.Logf(Info, format, args...)
+}
+
+// Warnf logs a message at the warn level
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.Logf(Warn, format, args...)
+}
+
+// Errorf logs a message at the error level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.Logf(Error, format, args...)
+}
+
+// Logf logs a message at the given level. A trailing newline is dropped.
+func (l *Logger) Logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+// Log logs a message at the given level, formatted like fmt.Sprint
+func (l *Logger) Log(level Level, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, strings.TrimSuffix(fmt.Sprint(args...), "\n"))
+}
+
+// logEntry is a message of JSON logs
+type logEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem,omitempty"`
+	Message   string `json:"msg"`
+}
+
+func (l *Logger) write(level Level, message string) {
+	l.output.Lock()
+	defer l.output.Unlock()
+
+	if l.output.format == FormatJSON {
+		contents, err := json.Marshal(logEntry{
+			Time:      l.output.now().UTC().Format(time.RFC3339),
+			Level:     level.String(),
+			Subsystem: l.subsystem,
+			Message:   colorCodes.ReplaceAllString(message, ""),
+		})
+		if err != nil {
+			// Marshalling strings does not fail
+			panic(err)
+		}
+		fmt.Fprintf(l.output.writer, "%s\n", contents)
+		return
+	}
+
+	switch level {
+	case Warn:
+		message = fmt.Sprintf("%s: %s", color.YellowString("Warning"), message)
+	case Error:
+		message = fmt.Sprintf("%s: %s", color.RedString("Error"), message)
+	}
+	if l.subsystem != "" {
+		message = fmt.Sprintf("[%s] %s", l.subsystem, message)
+	}
+	fmt.Fprintf(l.output.writer, "%s\n", message)
+}
+
+// Writer returns a writer logging each line written to it at the level, for
+// components printing their progress to a writer. Incomplete lines are
+// logged once their newline is written.
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{logger: l, level: level}
+}
+
+// lineWriter logs the lines written to it
+type lineWriter struct {
+	sync.Mutex
+	logger *Logger
+	level  Level
+	buffer bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.buffer.Write(p)
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// No newline yet, keep the incomplete line for the next write
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			break
+		}
+		w.logger.Logf(w.level, "%s", strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	level, err := ParseLevel("WARN")
+	assert.NoError(err)
+	assert.Equal(Warn, level)
+	assert.Equal("warn", level.String())
+
+	_, err = ParseLevel("loud")
+	assert.EqualError(err, "Invalid log level loud, expected one of debug, info, warn, error")
+
+	format, err := ParseFormat("json")
+	assert.NoError(err)
+	assert.Equal(FormatJSON, format)
+
+	_, err = ParseFormat("xml")
+	assert.EqualError(err, "Invalid log format xml, expected one of text, json")
+}
+
+func TestLoggerText(t *testing.T) {
+	assert := assert.New(t)
+
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	output := &bytes.Buffer{}
+	logger := New(output, Info, FormatText)
+	logger.Debugf("hidden")
+	logger.Infof("Building %s\n", "images")
+	logger.WithSubsystem("compile").Info("Compiling")
+	logger.WithSubsystem("config").Warnf("light opinion %s ignored", "tor.foo")
+
+	assert.Equal("Building images\n[compile] Compiling\n[config] Warning: light opinion tor.foo ignored\n", output.String())
+	assert.False(logger.Enabled(Debug))
+	assert.True(logger.Enabled(Error))
+}
+
+func TestLoggerJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	output := &bytes.Buffer{}
+	logger := New(output, Warn, FormatJSON)
+	logger.output.now = func() time.Time { return time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	logger.WithSubsystem("docker").Info("hidden")
+	logger.WithSubsystem("docker").Errorf("Error building %s", "\x1b[33mrole\x1b[0m")
+	logger.Warn("two\nlines")
+
+	assert.Equal(fmt.Sprintln(`{"time":"2017-01-02T03:04:05Z","level":"error","subsystem":"docker","msg":"Error building role"}`)+
+		fmt.Sprintln(`{"time":"2017-01-02T03:04:05Z","level":"warn","msg":"two\nlines"}`), output.String())
+}
+
+func TestLoggerWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	output := &bytes.Buffer{}
+	writer := New(output, Info, FormatText).WithSubsystem("compile").Writer(Info)
+
+	fmt.Fprint(writer, "one\ntw")
+	assert.Equal("[compile] one\n", output.String())
+
+	fmt.Fprint(writer, "o\r\nthree\n")
+	assert.Equal("[compile] one\n[compile] two\n[compile] three\n", output.String())
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// fileSHA1 returns the hex encoded SHA1 of the contents of a file
+func fileSHA1(path string) (string, error) {
+	return fileDigest(path, sha1.New())
+}
+
+// fileChecksum returns the checksum of a file, in the same format as the
+// expected checksum from a release manifest: either a plain hex encoded SHA1,
+// or a digest prefixed by its algorithm (`sha256:<hex>`), as written by newer
+// BOSH CLIs.
+func fileChecksum(path, expected string) (string, error) {
+	if strings.HasPrefix(expected, "sha256:") {
+		digest, err := fileDigest(path, sha256.New())
+		return "sha256:" + digest, err
+	}
+
+	if index := strings.Index(expected, ":"); index >= 0 {
+		return "", fmt.Errorf("Unsupported checksum algorithm %s", expected[:index])
+	}
+
+	return fileSHA1(path)
+}
+
+// fileDigest returns the hex encoded digest of the contents of a file
+func fileDigest(path string, hasher hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
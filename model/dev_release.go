@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 
 	"github.com/hpcloud/fissile/util"
 
@@ -111,59 +112,94 @@ func (r *Release) getDefaultDevReleaseName() (ver string, err error) {
 }
 
 func (r *Release) getLatestDevVersion() (ver string, err error) {
+	versions, err := r.GetDevVersions()
+	if err != nil || len(versions) == 0 {
+		return "", err
+	}
+
+	return versions[0], nil
+}
+
+// GetDevVersions returns all the versions listed in the dev releases index
+// of the release, newest first
+func (r *Release) GetDevVersions() ([]string, error) {
 	devReleaseIndexContent, err := ioutil.ReadFile(r.getDevReleaseIndexPath())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	var devReleaseIndex map[interface{}]interface{}
 
 	if err := yaml.Unmarshal([]byte(devReleaseIndexContent), &devReleaseIndex); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var semiVer version.Version
 	var builds map[interface{}]interface{}
 
 	if value, ok := devReleaseIndex["builds"]; !ok {
-		return "", fmt.Errorf("builds key did not exist in dev releases index file for release: %s", r.Name)
+		return nil, fmt.Errorf("builds key did not exist in dev releases index file for release: %s", r.Name)
 	} else if builds, ok = value.(map[interface{}]interface{}); !ok {
-		return "", fmt.Errorf("builds key in dev releases index file was not a map for release: %s, type: %T, value: %v", r.Name, value, value)
+		return nil, fmt.Errorf("builds key in dev releases index file was not a map for release: %s, type: %T, value: %v", r.Name, value, value)
 	}
 
+	versions := make([]string, 0, len(builds))
+	semiVersions := make(map[string]version.Version, len(builds))
+
 	for _, build := range builds {
 		var buildVersion string
 
 		if buildMap, ok := build.(map[interface{}]interface{}); !ok {
-			return "", fmt.Errorf("build entry was not a map in release: %s, type: %T, value: %v", r.Name, build, build)
+			return nil, fmt.Errorf("build entry was not a map in release: %s, type: %T, value: %v", r.Name, build, build)
 		} else if value, ok := buildMap["version"]; !ok {
-			return "", fmt.Errorf("version key did not exist in a build entry for release: %s", r.Name)
+			return nil, fmt.Errorf("version key did not exist in a build entry for release: %s", r.Name)
 		} else if buildVersion, ok = value.(string); !ok {
-			return "", fmt.Errorf("version was not a string in a build entry for release: %s, type: %T, value: %v", r.Name, value, value)
-		}
-
-		if ver == "" {
-			ver = buildVersion
-			semiVer, err = version.NewVersionFromString(ver)
-			if err != nil {
-				return "", err
-			}
-
-			continue
+			return nil, fmt.Errorf("version was not a string in a build entry for release: %s, type: %T, value: %v", r.Name, value, value)
 		}
 
 		semiBuildVer, err := version.NewVersionFromString(buildVersion)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		if semiBuildVer.IsGt(semiVer) {
-			ver = buildVersion
-			semiVer = semiBuildVer
+		versions = append(versions, buildVersion)
+		semiVersions[buildVersion] = semiBuildVer
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return semiVersions[versions[i]].IsGt(semiVersions[versions[j]])
+	})
+
+	return versions, nil
+}
+
+// GetDevVersionPackageVersions returns the versions of all packages in the
+// given dev version of the release
+func (r *Release) GetDevVersionPackageVersions(devVersion string) (result []string, err error) {
+	manifestPath := filepath.Join(r.getDevReleaseManifestsDir(), fmt.Sprintf("%s-%s.yml", r.Name, devVersion))
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("Error trying to load packages from YAML manifest %s: %s", manifestPath, p)
 		}
+	}()
+
+	manifestContents, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	// See loadMetadata
+	manifestContents = yamlBinaryRegexp.ReplaceAll(manifestContents, []byte("$1!!binary |-\n"))
+
+	var manifest map[interface{}]interface{}
+	if err := yaml.Unmarshal(manifestContents, &manifest); err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range manifest["packages"].([]interface{}) {
+		result = append(result, pkg.(map[interface{}]interface{})["version"].(string))
 	}
 
-	return ver, nil
+	return result, nil
 }
 
 func (r *Release) validateDevPathStructure() error {
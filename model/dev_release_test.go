@@ -42,6 +42,31 @@ func TestDevReleaseLatestVersionOk(t *testing.T) {
 	assert.Equal("0+dev.2", release.Version)
 }
 
+func TestDevReleaseVersionsOk(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	ntpReleasePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	ntpReleasePathBoshCache := filepath.Join(ntpReleasePath, "bosh-cache")
+	release, err := NewDevRelease(ntpReleasePath, "", "", ntpReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	versions, err := release.GetDevVersions()
+	assert.NoError(err)
+	assert.Equal([]string{"2+dev.3", "2+dev.2", "2+dev.1"}, versions)
+
+	packageVersions, err := release.GetDevVersionPackageVersions("2+dev.1")
+	assert.NoError(err)
+	assert.Equal([]string{"d7a94e58bfd958e811284e3d4e8ba2408abd1c6c"}, packageVersions)
+
+	_, err = release.GetDevVersionPackageVersions("2+dev.99")
+	assert.Error(err)
+}
+
 func TestDevReleaseSpecificVersionOk(t *testing.T) {
 	assert := assert.New(t)
 
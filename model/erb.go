@@ -0,0 +1,277 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// erbBlockKeywords are the Ruby keywords that open a block closed by `end`,
+// when they start a statement
+var erbBlockKeywords = map[string]bool{
+	"if":     true,
+	"unless": true,
+	"while":  true,
+	"until":  true,
+	"for":    true,
+	"case":   true,
+	"begin":  true,
+	"def":    true,
+	"class":  true,
+	"module": true,
+}
+
+// erbClosers maps closing brackets to their opening ones
+var erbClosers = map[byte]byte{
+	')': '(',
+	']': '[',
+	'}': '{',
+}
+
+// erbProblem is a syntax problem found in an ERB template
+type erbProblem struct {
+	line   int    // Line the problem was found on, starting at 1
+	tag    string // The ERB tag containing the problem
+	detail string
+}
+
+// erbOpener is a bracket or block that is still waiting to be closed
+type erbOpener struct {
+	token string
+	line  int
+	tag   string
+}
+
+// erbChecker is a lightweight syntax checker for ERB templates. It doesn't
+// parse Ruby; it verifies that all ERB tags are closed, that strings are
+// terminated, and that brackets and `end`-terminated blocks are balanced
+// across all tags of the template.
+type erbChecker struct {
+	problems []erbProblem
+	openers  []erbOpener
+}
+
+// ValidateERB checks the syntax of the template, and reports all problems
+// with the line they were found on
+func (t *JobTemplate) ValidateERB() []error {
+	var errs []error
+
+	for _, problem := range checkERB(t.Content) {
+		errs = append(errs, fmt.Errorf("jobs[%s].templates[%s:%d]: %s: %s",
+			t.Job.Name, t.SourcePath, problem.line, problem.detail, problem.tag))
+	}
+
+	return errs
+}
+
+// checkERB returns the syntax problems of an ERB template
+func checkERB(content string) []erbProblem {
+	checker := &erbChecker{}
+	line := 1
+
+	for {
+		start := strings.Index(content, "<%")
+		if start < 0 {
+			break
+		}
+		line += strings.Count(content[:start], "\n")
+		content = content[start:]
+
+		// <%% is a literal <%
+		if strings.HasPrefix(content, "<%%") {
+			content = content[3:]
+			continue
+		}
+
+		end := strings.Index(content, "%>")
+		if end < 0 {
+			checker.report(line, erbTagSummary(content), "ERB tag is never closed with %>")
+			return checker.problems
+		}
+
+		tag := content[:end+2]
+		checker.checkTag(tag, line)
+
+		line += strings.Count(tag, "\n")
+		content = content[end+2:]
+	}
+
+	for _, opener := range checker.openers {
+		if opener.token == "block" {
+			checker.report(opener.line, opener.tag, "block is never closed with 'end'")
+		} else {
+			checker.report(opener.line, opener.tag, fmt.Sprintf("'%s' is never closed", opener.token))
+		}
+	}
+
+	// Problems are found in a different order than they appear in; sort
+	// them by line, and problems on the same line by their description
+	sort.Slice(checker.problems, func(i, j int) bool {
+		a, b := checker.problems[i], checker.problems[j]
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		if a.detail != b.detail {
+			return a.detail < b.detail
+		}
+		return a.tag < b.tag
+	})
+
+	return checker.problems
+}
+
+func (c *erbChecker) report(line int, tag, detail string) {
+	c.problems = append(c.problems, erbProblem{line: line, tag: tag, detail: detail})
+}
+
+// checkTag checks the Ruby code of a single ERB tag
+func (c *erbChecker) checkTag(tag string, line int) {
+	code := strings.TrimSuffix(strings.TrimPrefix(tag, "<%"), "%>")
+	code = strings.TrimSuffix(code, "-")
+	code = strings.TrimLeft(code, "=-")
+	if strings.HasPrefix(code, "#") {
+		// Comment tag
+		return
+	}
+
+	summary := erbTagSummary(tag)
+	statementStart := true
+	loopStatement := false
+
+	for i := 0; i < len(code); i++ {
+		ch := code[i]
+		switch {
+		case ch == '\n':
+			line++
+			statementStart = true
+			loopStatement = false
+
+		case ch == ';':
+			statementStart = true
+			loopStatement = false
+
+		case ch == '#':
+			// Comment until the end of the line
+			for i+1 < len(code) && code[i+1] != '\n' {
+				i++
+			}
+
+		case ch == '"' || ch == '\'' || ch == '`':
+			end := erbStringEnd(code, i)
+			if end < 0 {
+				c.report(line, summary, fmt.Sprintf("string starting with %c is never terminated", ch))
+				return
+			}
+			line += strings.Count(code[i:end], "\n")
+			i = end
+			statementStart = false
+
+		case ch == '(' || ch == '[' || ch == '{':
+			c.openers = append(c.openers, erbOpener{token: string(ch), line: line, tag: summary})
+			statementStart = ch == '('
+
+		case erbClosers[ch] != 0:
+			if !c.close(string(erbClosers[ch])) {
+				c.report(line, summary, fmt.Sprintf("unexpected '%c'", ch))
+			}
+			statementStart = false
+
+		case ch == '=':
+			statementStart = i+1 >= len(code) || (code[i+1] != '=' && code[i+1] != '~' && code[i+1] != '>')
+			if !statementStart {
+				i++
+			}
+
+		case erbIsWordChar(ch):
+			start := i
+			for i+1 < len(code) && erbIsWordChar(code[i+1]) {
+				i++
+			}
+			if i+1 < len(code) && (code[i+1] == '?' || code[i+1] == '!') {
+				i++
+			}
+			word := code[start : i+1]
+
+			if !erbIsKeywordPosition(code, start, i+1) {
+				statementStart = false
+				continue
+			}
+
+			switch {
+			case word == "end":
+				if !c.close("block") {
+					c.report(line, summary, "unexpected 'end'")
+				}
+			case word == "do" && !loopStatement:
+				c.openers = append(c.openers, erbOpener{token: "block", line: line, tag: summary})
+			case statementStart && erbBlockKeywords[word]:
+				c.openers = append(c.openers, erbOpener{token: "block", line: line, tag: summary})
+				loopStatement = word == "while" || word == "until" || word == "for"
+			}
+			statementStart = word == "then" || word == "else" || word == "do" || word == "and" || word == "or" || word == "not"
+
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			// Whitespace doesn't change the statement state
+
+		default:
+			statementStart = false
+		}
+	}
+}
+
+// close pops the innermost opener, if it matches the token
+func (c *erbChecker) close(token string) bool {
+	if len(c.openers) == 0 || c.openers[len(c.openers)-1].token != token {
+		return false
+	}
+	c.openers = c.openers[:len(c.openers)-1]
+	return true
+}
+
+// erbStringEnd returns the index of the quote terminating the string that
+// starts at the given index, or -1 if the string is never terminated
+func erbStringEnd(code string, start int) int {
+	quote := code[start]
+	for i := start + 1; i < len(code); i++ {
+		switch code[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func erbIsWordChar(ch byte) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9')
+}
+
+// erbIsKeywordPosition tests whether the word between start and end can be a
+// keyword, rather than a method call (`x.end`), a symbol (`:end`), a hash key
+// (`end: 1`), or part of a variable name (`@end`, `$end`)
+func erbIsKeywordPosition(code string, start, end int) bool {
+	if start > 0 {
+		switch code[start-1] {
+		case '.', ':', '@', '$':
+			return false
+		}
+	}
+
+	if end < len(code) && code[end] == ':' && (end+1 >= len(code) || code[end+1] != ':') {
+		return false
+	}
+
+	return true
+}
+
+// erbTagSummary shortens an ERB tag for error messages
+func erbTagSummary(tag string) string {
+	if index := strings.Index(tag, "\n"); index >= 0 {
+		tag = tag[:index] + " ..."
+	}
+	if len(tag) > 60 {
+		tag = tag[:60] + " ..."
+	}
+	return tag
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckERBValid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, content := range []string{
+		"plain text",
+		"<%= p('foo') %>",
+		"<%# a comment with an unbalanced ( %>",
+		"<%% literal tag",
+		"<% if p('a') %>\na\n<% elsif p('b') %>\nb\n<% else %>\nc\n<% end %>",
+		"<% p('list').each do |item| -%>\n<%= item %>\n<% end -%>",
+		"<% p('list').each { |item| %>\n<%= item %>\n<% } %>",
+		"<%= p('a') if p('b') %>",
+		"<%= p('a') unless p('b') %>",
+		"<% x = if p('a') then 1 else 2 end %>",
+		"<% while i < 3 do i += 1 end %>",
+		"<% case p('a')\nwhen 'x' then 1\nend %>",
+		"<% def helper(x)\n  x.to_s\nend %>",
+		"<%= \"interpolated #{p('a')} ) end\" %>",
+		"<%= 'quote \\' inside' %>",
+		"<%= { 'a' => 1, b: [1, 2] }.to_json %>",
+		"<%= p('a').end_with?('x') %>",
+		"<%= { end: 1 }[:end] %>",
+		"<% x = 1 # trailing comment ( %>",
+	} {
+		assert.Empty(checkERB(content), "Expected no problems in %q", content)
+	}
+}
+
+func TestCheckERBProblems(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		content string
+		line    int
+		detail  string
+	}{
+		{"a\n<%= p('foo')\n", 2, "ERB tag is never closed with %>"},
+		{"<%= p('foo' %>", 1, "'(' is never closed"},
+		{"<%= p('foo')) %>", 1, "unexpected ')'"},
+		{"<%= p('foo] %>", 1, "string starting with ' is never terminated"},
+		{"a\n<% if p('a') %>\nb\n", 2, "block is never closed with 'end'"},
+		{"<% p('list').each do |x| %>\n<%= x %>\n", 1, "block is never closed with 'end'"},
+		{"a\nb\n<% end %>", 3, "unexpected 'end'"},
+		{"<% [1, 2].each { |x| %>\n<% end %>", 2, "unexpected 'end'"},
+	} {
+		var lines []int
+		for _, problem := range checkERB(testCase.content) {
+			if problem.detail == testCase.detail {
+				lines = append(lines, problem.line)
+			}
+		}
+		assert.Equal([]int{testCase.line}, lines, "Expected %q on line %d of %q", testCase.detail, testCase.line, testCase.content)
+	}
+}
+
+func TestCheckERBProblemOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	// Unclosed openers are found last, but reported in line order
+	problems := checkERB("<% [1, 2].each { |x| %>\n<% end ) %>")
+	assert.Equal([]erbProblem{
+		{line: 1, tag: "<% [1, 2].each { |x| %>", detail: "'{' is never closed"},
+		{line: 2, tag: "<% end ) %>", detail: "unexpected ')'"},
+		{line: 2, tag: "<% end ) %>", detail: "unexpected 'end'"},
+	}, problems)
+}
+
+func TestJobTemplateValidateERB(t *testing.T) {
+	assert := assert.New(t)
+
+	template := &JobTemplate{
+		SourcePath: "config.erb",
+		Job:        &Job{Name: "myjob"},
+		Content:    "ok\n<% if p('a') %>\n<%= p('b' %>\n",
+	}
+
+	errs := template.ValidateERB()
+	if assert.Len(errs, 2) {
+		assert.EqualError(errs[0], `jobs[myjob].templates[config.erb:2]: block is never closed with 'end': <% if p('a') %>`)
+		assert.EqualError(errs[1], `jobs[myjob].templates[config.erb:3]: '(' is never closed: <%= p('b' %>`)
+	}
+}
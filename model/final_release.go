@@ -0,0 +1,177 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/fissile/util"
+
+	"github.com/pivotal-golang/archiver/extractor"
+)
+
+// FinalReleasesCacheDir is the directory, inside the BOSH cache directory,
+// final release tarballs are extracted into
+const FinalReleasesCacheDir = "fissile-final-releases"
+
+// NewFinalRelease will create an instance of a BOSH final release. The path
+// is either a final release tarball (as built by `bosh create release --final
+// --with-tarball`, or downloaded from bosh.io), or a directory the tarball was
+// extracted into. Tarballs are extracted into the BOSH cache directory once,
+// and reused afterwards.
+// The name and version are optional; if given, they must match the release.
+func NewFinalRelease(path, releaseName, version, boshCacheDir string) (*Release, error) {
+	release := &Release{
+		Path:            path,
+		Name:            releaseName,
+		Version:         version,
+		DevBOSHCacheDir: boshCacheDir,
+		FinalRelease:    true,
+	}
+
+	if IsFinalReleaseTarball(path) {
+		extractedPath, err := extractFinalRelease(path, boshCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		release.Path = extractedPath
+	}
+
+	if err := release.validatePathStructure(); err != nil {
+		return nil, err
+	}
+
+	if err := release.loadMetadata(); err != nil {
+		return nil, err
+	}
+
+	if releaseName != "" && releaseName != release.Name {
+		return nil, fmt.Errorf("Final release %s is named %s, not %s", path, release.Name, releaseName)
+	}
+
+	if version != "" && version != release.Version {
+		return nil, fmt.Errorf("Final release %s has version %s, not %s", path, release.Version, version)
+	}
+
+	if err := release.loadPackages(); err != nil {
+		return nil, err
+	}
+
+	if err := release.loadDependenciesForPackages(); err != nil {
+		return nil, err
+	}
+
+	if err := release.loadJobs(); err != nil {
+		return nil, err
+	}
+
+	if err := release.loadLicense(); err != nil {
+		return nil, err
+	}
+
+	if err := release.ValidateArchives(); err != nil {
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// IsFinalRelease tests whether the given path is a BOSH final release, either
+// as a tarball, or as an extracted directory
+func IsFinalRelease(path string) bool {
+	if IsFinalReleaseTarball(path) {
+		return true
+	}
+
+	info, err := os.Stat(filepath.Join(path, manifestFile))
+	return err == nil && !info.IsDir()
+}
+
+// IsFinalReleaseTarball tests whether the given path is a final release tarball
+func IsFinalReleaseTarball(path string) bool {
+	if !strings.HasSuffix(path, ".tgz") && !strings.HasSuffix(path, ".tar.gz") {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// extractFinalRelease extracts a final release tarball into the cache
+// directory, and returns the path to the extracted release. The directory is
+// keyed by the SHA1 of the tarball, so that changed tarballs are extracted
+// again.
+func extractFinalRelease(tarballPath, boshCacheDir string) (string, error) {
+	if err := util.ValidatePath(tarballPath, false, "final release tarball"); err != nil {
+		return "", err
+	}
+
+	tarballSHA1, err := fileSHA1(tarballPath)
+	if err != nil {
+		return "", fmt.Errorf("Error calculating SHA1 of final release tarball %s: %s", tarballPath, err)
+	}
+
+	cacheDir := filepath.Join(boshCacheDir, FinalReleasesCacheDir)
+	targetDir := filepath.Join(cacheDir, tarballSHA1)
+	if _, err := os.Stat(targetDir); err == nil {
+		// Mark it as used, for fissile cache prune
+		now := time.Now()
+		os.Chtimes(targetDir, now, now)
+		return targetDir, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("Error creating final release cache directory %s: %s", cacheDir, err)
+	}
+
+	// Extract into a temporary directory first, so interrupted extractions
+	// are never mistaken for complete ones
+	tempDir, err := ioutil.TempDir(cacheDir, ".extract-")
+	if err != nil {
+		return "", fmt.Errorf("Error creating temporary directory in %s: %s", cacheDir, err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := extractor.NewTgz().Extract(tarballPath, tempDir); err != nil {
+		return "", fmt.Errorf("Error extracting final release tarball %s: %s", tarballPath, err)
+	}
+
+	if err := os.Rename(tempDir, targetDir); err != nil {
+		return "", fmt.Errorf("Error moving extracted final release into %s: %s", targetDir, err)
+	}
+
+	return targetDir, nil
+}
+
+// loadFinalReleaseLicense loads the license files from the license archive
+// of a final release
+func (r *Release) loadFinalReleaseLicense() error {
+	licenseArchive, err := os.Open(r.finalReleaseLicensePath())
+	if os.IsNotExist(err) {
+		// There were never licenses to load.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer licenseArchive.Close()
+
+	files, err := util.LoadLicenseFiles(r.finalReleaseLicensePath(), licenseArchive, util.DefaultLicensePrefixFilters...)
+	if err != nil {
+		return err
+	}
+
+	for name, contents := range files {
+		r.License.Files[path.Clean(name)] = contents
+	}
+
+	return nil
+}
+
+func (r *Release) finalReleaseLicensePath() string {
+	return filepath.Join(r.Path, licenseFile)
+}
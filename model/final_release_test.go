@@ -0,0 +1,134 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinalReleaseFromDirectory(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-final-release")
+	assert.True(IsFinalRelease(releasePath))
+	assert.False(IsFinalReleaseTarball(releasePath))
+
+	release, err := NewFinalRelease(releasePath, "", "", "")
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.True(release.FinalRelease)
+	assert.Equal("tor", release.Name)
+	assert.Equal("0.3.5", release.Version)
+	assert.False(release.UncommittedChanges)
+	assert.Len(release.Jobs, 3)
+	assert.Len(release.Packages, 2)
+
+	job, err := release.LookupJob("tor")
+	if assert.NoError(err) {
+		assert.Equal(filepath.Join(releasePath, "jobs", "tor.tgz"), job.Path)
+		assert.NotEmpty(job.Properties)
+	}
+
+	pkg, err := release.LookupPackage("tor")
+	if assert.NoError(err) {
+		assert.Equal(filepath.Join(releasePath, "packages", "tor.tgz"), pkg.Path)
+		if assert.Len(pkg.Dependencies, 1) {
+			assert.Equal("libevent", pkg.Dependencies[0].Name)
+		}
+	}
+
+	assert.Contains(release.License.Files, "LICENSE")
+}
+
+func TestFinalReleaseFromTarball(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	cacheDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(cacheDir)
+
+	tarballPath := filepath.Join(workDir, "../test-assets/tor-final-release.tgz")
+	assert.True(IsFinalRelease(tarballPath))
+	assert.True(IsFinalReleaseTarball(tarballPath))
+
+	release, err := NewFinalRelease(tarballPath, "tor", "0.3.5", cacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal("tor", release.Name)
+	assert.Len(release.Jobs, 3)
+	assert.Contains(release.Path, filepath.Join(cacheDir, FinalReleasesCacheDir))
+
+	// The extracted release is reused
+	again, err := NewFinalRelease(tarballPath, "", "", cacheDir)
+	if assert.NoError(err) {
+		assert.Equal(release.Path, again.Path)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(cacheDir, FinalReleasesCacheDir))
+	assert.NoError(err)
+	assert.Len(entries, 1, "Temporary extraction directories should be cleaned up")
+}
+
+func TestFinalReleaseMismatchedNameAndVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-final-release")
+
+	_, err = NewFinalRelease(releasePath, "ntp", "", "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "is named tor, not ntp")
+	}
+
+	_, err = NewFinalRelease(releasePath, "", "1.0", "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "has version 0.3.5, not 1.0")
+	}
+}
+
+func TestFinalReleaseCorruptArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(releasePath)
+
+	sourcePath := filepath.Join(workDir, "../test-assets/tor-final-release")
+	for _, name := range []string{
+		"release.MF",
+		"license.tgz",
+		"jobs/tor.tgz",
+		"jobs/hashmat.tgz",
+		"jobs/new_hostname.tgz",
+		"packages/tor.tgz",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(sourcePath, name))
+		assert.NoError(err)
+		assert.NoError(os.MkdirAll(filepath.Dir(filepath.Join(releasePath, name)), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(releasePath, name), contents, 0644))
+	}
+	assert.NoError(ioutil.WriteFile(filepath.Join(releasePath, "packages", "libevent.tgz"), []byte("corrupt"), 0644))
+
+	_, err = NewFinalRelease(releasePath, "", "", "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "libevent.tgz")
+		assert.Contains(err.Error(), "releases[tor].packages[libevent]: Invalid value")
+	}
+}
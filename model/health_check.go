@@ -0,0 +1,110 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// HealthCheckScriptDir is the directory the health checks with templates are
+// rendered into, as scripts, when the container starts
+const HealthCheckScriptDir = "/var/vcap/healthcheck"
+
+// UnmarshalYAML reads the health checks of a role. The flat form of older
+// role manifests, a single check without readiness or liveness, is its
+// readiness check.
+func (h *HealthCheck) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type healthCheck HealthCheck // Without this method
+	var check healthCheck
+	if err := unmarshal(&check); err != nil {
+		return err
+	}
+
+	var flat HealthProbe
+	if err := unmarshal(&flat); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(flat, HealthProbe{}) {
+		if check.Readiness != nil || check.Liveness != nil {
+			return fmt.Errorf("healthcheck mixes the settings of a check with readiness or liveness; move them under readiness: or liveness:")
+		}
+		check.Readiness = &flat
+	}
+
+	*h = HealthCheck(check)
+	return nil
+}
+
+// Probes returns the health checks of a role, by kind (readiness or
+// liveness), in that order, leaving out those not set
+func (h *HealthCheck) Probes() ([]string, []*HealthProbe) {
+	var kinds []string
+	var probes []*HealthProbe
+	if h == nil {
+		return kinds, probes
+	}
+	if h.Readiness != nil {
+		kinds = append(kinds, "readiness")
+		probes = append(probes, h.Readiness)
+	}
+	if h.Liveness != nil {
+		kinds = append(kinds, "liveness")
+		probes = append(probes, h.Liveness)
+	}
+	return kinds, probes
+}
+
+// isTemplate tests whether a setting of a health check is a template, which
+// references configuration variables as ((NAME))
+func isTemplate(setting string) bool {
+	return strings.Contains(setting, "((")
+}
+
+// Templates returns the templates of a health check: its URL, or the
+// arguments of its command, referencing configuration variables. They are
+// keyed by field, url or command[<index>].
+func (p *HealthProbe) Templates() map[string]string {
+	templates := make(map[string]string)
+	if isTemplate(p.URL) {
+		templates["url"] = p.URL
+	}
+	for index, arg := range p.Command {
+		if isTemplate(arg) {
+			templates[fmt.Sprintf("command[%d]", index)] = arg
+		}
+	}
+	return templates
+}
+
+// IsTemplated tests whether a health check has templates, rendered when the
+// container starts, rather than being used as is
+func (p *HealthProbe) IsTemplated() bool {
+	return len(p.Templates()) > 0
+}
+
+// HealthCheckScript returns the path of the script the health check of the
+// given kind is rendered into, when it has templates
+func HealthCheckScript(kind string) string {
+	return path.Join(HealthCheckScriptDir, kind+".sh")
+}
+
+// healthCheckVariables returns the configuration variables the templates of
+// the health checks of a role use, skipping templates which can't be parsed
+func (r *Role) healthCheckVariables() []string {
+	if r.Run == nil {
+		return nil
+	}
+	var variables []string
+	_, probes := r.Run.HealthCheck.Probes()
+	for _, probe := range probes {
+		for _, template := range probe.Templates() {
+			varsInTemplate, err := parseTemplate(template)
+			if err != nil {
+				continue
+			}
+			variables = append(variables, varsInTemplate...)
+		}
+	}
+	return variables
+}
@@ -1,14 +1,13 @@
 package model
 
 import (
-	"crypto/sha1"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 
 	"github.com/pivotal-golang/archiver/extractor"
@@ -25,6 +24,9 @@ type Job struct {
 	Fingerprint string
 	SHA1        string
 	Properties  []*JobProperty
+	Provides    []*JobLinkInfo
+	Consumes    []*JobLinkInfo
+	Processes   []string // Names of the processes monit checks, as declared by the job
 	Version     string
 	Release     *Release
 
@@ -63,25 +65,24 @@ func (j *Job) getProperty(name string) (*JobProperty, error) {
 	return nil, fmt.Errorf("Property %s not found in job %s", name, j.Name)
 }
 
-// ValidateSHA1 validates that the SHA1 of the actual job archive is the same
-// as the one from the release manifest
-func (j *Job) ValidateSHA1() error {
-	file, err := os.Open(j.Path)
-	if err != nil {
-		return fmt.Errorf("Error opening the job archive %s for sha1 calculation", j.Path)
+// HasDrainScript tests whether the job has a BOSH drain script
+func (j *Job) HasDrainScript() bool {
+	for _, template := range j.Templates {
+		if template.DestinationPath == jobDrainScript {
+			return true
+		}
 	}
+	return false
+}
 
-	defer file.Close()
-
-	h := sha1.New()
-
-	_, err = io.Copy(h, file)
+// ValidateSHA1 validates that the checksum of the actual job archive is the
+// same as the one from the release manifest
+func (j *Job) ValidateSHA1() error {
+	computedSha1, err := fileChecksum(j.Path, j.SHA1)
 	if err != nil {
-		return fmt.Errorf("Error copying job archive %s for sha1 calculation", j.Path)
+		return fmt.Errorf("Error calculating sha1 of job archive %s: %s", j.Path, err)
 	}
 
-	computedSha1 := fmt.Sprintf("%x", h.Sum(nil))
-
 	if computedSha1 != j.SHA1 {
 		return fmt.Errorf("Computed sha1 (%s) is different than manifest sha1 (%s) for job archive %s", computedSha1, j.SHA1, j.Path)
 	}
@@ -173,8 +174,17 @@ func (j *Job) loadJobSpec() (err error) {
 	}
 
 	if j.jobSpec["templates"] != nil {
-		for source, destination := range j.jobSpec["templates"].(map[interface{}]interface{}) {
-			templateFile := filepath.Join(jobDir, "templates", source.(string))
+		// Like the properties below, the templates are loaded in sorted
+		// order, so that everything generated from them is stable
+		templates := j.jobSpec["templates"].(map[interface{}]interface{})
+		var sources []string
+		for source := range templates {
+			sources = append(sources, source.(string))
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			destination := templates[source]
+			templateFile := filepath.Join(jobDir, "templates", source)
 
 			templateContent, err := ioutil.ReadFile(templateFile)
 			if err != nil {
@@ -182,7 +192,7 @@ func (j *Job) loadJobSpec() (err error) {
 			}
 
 			template := &JobTemplate{
-				SourcePath:      source.(string),
+				SourcePath:      source,
 				DestinationPath: destination.(string),
 				Job:             j,
 				Content:         string(templateContent),
@@ -218,12 +228,38 @@ func (j *Job) loadJobSpec() (err error) {
 				if propertyDefinitionMap["default"] != nil {
 					property.Default = propertyDefinitionMap["default"]
 				}
+				if propertyType, ok := propertyDefinitionMap["type"].(string); ok {
+					property.Type = propertyType
+				}
 			}
 
 			j.Properties = append(j.Properties, property)
 		}
 	}
 
+	if err := j.loadJobProcesses(jobDir); err != nil {
+		return err
+	}
+
+	return j.loadJobLinks()
+}
+
+// monitProcessPattern matches the processes declared in monit files
+var monitProcessPattern = regexp.MustCompile(`(?m)^\s*check\s+process\s+(\S+)`)
+
+// loadJobProcesses reads the names of the processes the monit file of the
+// extracted job checks. Names computed by the template are kept as they are.
+func (j *Job) loadJobProcesses(jobDir string) error {
+	contents, err := ioutil.ReadFile(filepath.Join(jobDir, "monit"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, match := range monitProcessPattern.FindAllStringSubmatch(string(contents), -1) {
+		j.Processes = append(j.Processes, match[1])
+	}
 	return nil
 }
 
@@ -249,20 +285,41 @@ func (j *Job) WriteConfigs(role *Role, lightOpinionsPath, darkOpinionsPath strin
 
 	var templates []map[string]string
 	for _, roleJob := range role.Jobs {
-		templates = append(templates, map[string]string{"name": roleJob.Name})
+		templates = append(templates, map[string]string{"name": role.JobName(roleJob)})
 	}
 	config["job"].(map[string]interface{})["templates"] = templates
 
+	// The BOSH spec of the instance, for templates using spec.*. The parts
+	// only known once the container runs are placeholders run.sh fills in.
+	config["name"] = role.Name
+	config["index"] = SpecIndexPlaceholder
+	config["bootstrap"] = SpecBootstrapPlaceholder
+	config["id"] = SpecIDPlaceholder
+	config["address"] = SpecAddressPlaceholder
+	config["ip"] = SpecAddressPlaceholder
+	config["networks"] = map[string]interface{}{
+		"default": map[string]interface{}{
+			"ip":              SpecAddressPlaceholder,
+			"dns_record_name": SpecIDPlaceholder,
+		},
+	}
+
 	opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath)
 	if err != nil {
 		return nil, err
 	}
-	properties, err := j.getPropertiesForJob(opinions)
+	properties, err := j.getPropertiesForJob(opinions, role.JobProperties(role.JobName(j)))
 	if err != nil {
 		return nil, err
 	}
 	config["properties"] = properties
 
+	links, err := j.getLinksForJob(role, opinions)
+	if err != nil {
+		return nil, err
+	}
+	config["links"] = links
+
 	// Write out the configuration
 	jobJSON, err := json.MarshalIndent(config, "", "    ") // 4-space indent
 	if err != nil {
@@ -272,8 +329,9 @@ func (j *Job) WriteConfigs(role *Role, lightOpinionsPath, darkOpinionsPath strin
 	return jobJSON, nil
 }
 
-// getPropertiesForJob returns the parameters for the given job, using its specs and opinions
-func (j *Job) getPropertiesForJob(opinions *Opinions) (map[string]interface{}, error) {
+// getPropertiesForJob returns the parameters for the given job, using its
+// specs and opinions, and the values the role overrides, by property name
+func (j *Job) getPropertiesForJob(opinions *Opinions, overrides map[string]interface{}) (map[string]interface{}, error) {
 	props := make(map[string]interface{})
 	lightOpinions, ok := opinions.Light["properties"]
 	if !ok {
@@ -308,21 +366,27 @@ func (j *Job) getPropertiesForJob(opinions *Opinions) (map[string]interface{}, e
 		// or no value at all we consider the key to be an
 		// inner node which is not excluded.
 
+		override, hasOverride := overrides[property.Name]
 		darkValue, ok := getOpinionValue(darkOpinionsByString, keyPieces)
 		if ok {
-			if darkValue == nil {
-				// Ignore dark opinions
-				continue
+			isDark := darkValue == nil
+			if !isDark {
+				kind := reflect.TypeOf(darkValue).Kind()
+				isDark = kind != reflect.Map && kind != reflect.Array
+			}
+			if isDark && hasOverride {
+				return nil, fmt.Errorf("Property %s of job %s is excluded by the dark opinions, and can't be overridden", property.Name, j.Name)
 			}
-			kind := reflect.TypeOf(darkValue).Kind()
-			if kind != reflect.Map && kind != reflect.Array {
+			if isDark {
 				// Ignore dark opinions
 				continue
 			}
 		}
 		lightValue, hasLightValue := getOpinionValue(lightOpinionsByString, keyPieces)
 		var finalValue interface{}
-		if hasLightValue && lightValue != nil {
+		if hasOverride {
+			finalValue = override
+		} else if hasLightValue && lightValue != nil {
 			finalValue = lightValue
 		} else {
 			finalValue = property.Default
@@ -334,6 +398,17 @@ func (j *Job) getPropertiesForJob(opinions *Opinions) (map[string]interface{}, e
 	return props, nil
 }
 
+// Placeholders in the BOSH spec of job instances, replaced by run.sh with the
+// values of the running container: the index is the ordinal of a StatefulSet
+// pod or 0, the instance with index 0 is the bootstrap one, the id is the host
+// name and the address the IP address.
+const (
+	SpecIndexPlaceholder     = "((FISSILE_SPEC_INDEX))"
+	SpecBootstrapPlaceholder = "((FISSILE_SPEC_BOOTSTRAP))"
+	SpecIDPlaceholder        = "((FISSILE_SPEC_ID))"
+	SpecAddressPlaceholder   = "((FISSILE_SPEC_ADDRESS))"
+)
+
 // initializeConfigJSON returns the scaffolding for the BOSH-style JSON structure
 func initializeConfigJSON() (map[string]interface{}, error) {
 	var config map[string]interface{}
@@ -343,9 +418,7 @@ func initializeConfigJSON() (map[string]interface{}, error) {
 		},
 		"parameters": {},
 		"properties": {},
-		"networks": {
-			"default": {}
-		}
+		"links": {}
 	}`), &config)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to unmarshal initial config: %+v", err)
@@ -353,6 +426,31 @@ func initializeConfigJSON() (map[string]interface{}, error) {
 	return config, nil
 }
 
+// Packages returns the packages used by the jobs, without duplicates
+func (slice Jobs) Packages() Packages {
+	found := make(map[string]struct{})
+	var packages Packages
+	for _, job := range slice {
+		for _, pkg := range job.Packages {
+			if _, ok := found[pkg.Fingerprint]; !ok {
+				found[pkg.Fingerprint] = struct{}{}
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	return packages
+}
+
+// lookup returns the job with the given name, or nil
+func (slice Jobs) lookup(name string) *Job {
+	for _, job := range slice {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
 // Len implements the Len function to satisfy sort.Interface
 func (slice Jobs) Len() int {
 	return len(slice)
@@ -369,5 +467,8 @@ func (slice Jobs) Swap(i, j int) {
 }
 
 func (j *Job) jobArchivePath() string {
+	if j.Release.FinalRelease {
+		return filepath.Join(j.Release.jobsDirPath(), fmt.Sprintf("%s.tgz", j.Name))
+	}
 	return filepath.Join(j.Release.DevBOSHCacheDir, j.SHA1)
 }
@@ -119,6 +119,25 @@ func TestJobPackagesOk(t *testing.T) {
 	assert.Equal("ntp-4.2.8p2", release.Jobs[0].Packages[0].Name)
 }
 
+func TestJobProcessesOk(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathCacheDir := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathCacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	job, err := release.LookupJob("tor")
+	if assert.NoError(err) {
+		assert.Equal([]string{"tor"}, job.Processes)
+	}
+}
+
 func TestJobTemplatesOk(t *testing.T) {
 	assert := assert.New(t)
 
@@ -134,11 +153,12 @@ func TestJobTemplatesOk(t *testing.T) {
 
 	assert.Len(release.Jobs[0].Templates, 2)
 
-	assert.Contains([]string{"ctl.sh", "ntp.conf.erb"}, release.Jobs[0].Templates[0].SourcePath)
-	assert.Contains([]string{"ctl.sh", "ntp.conf.erb"}, release.Jobs[0].Templates[1].SourcePath)
+	// Templates are sorted by their source
+	assert.Equal("ctl.sh", release.Jobs[0].Templates[0].SourcePath)
+	assert.Equal("ntp.conf.erb", release.Jobs[0].Templates[1].SourcePath)
 
-	assert.Contains([]string{"etc/ntp.conf", "bin/ctl"}, release.Jobs[0].Templates[0].DestinationPath)
-	assert.Contains([]string{"etc/ntp.conf", "bin/ctl"}, release.Jobs[0].Templates[1].DestinationPath)
+	assert.Equal("bin/ctl", release.Jobs[0].Templates[0].DestinationPath)
+	assert.Equal("etc/ntp.conf", release.Jobs[0].Templates[1].DestinationPath)
 }
 
 func TestJobPropertiesOk(t *testing.T) {
@@ -244,7 +264,7 @@ func TestJobsProperties(t *testing.T) {
 	opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath)
 	assert.NoError(err)
 
-	properties, err := release.Jobs[0].getPropertiesForJob(opinions)
+	properties, err := release.Jobs[0].getPropertiesForJob(opinions, nil)
 	assert.Len(properties, 2)
 	actualJSON, err := json.Marshal(properties)
 	if assert.NoError(err) {
@@ -259,6 +279,44 @@ func TestJobsProperties(t *testing.T) {
 	}
 }
 
+func TestJobsPropertiesOverrides(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	ntpReleasePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	ntpReleasePathBoshCache := filepath.Join(ntpReleasePath, "bosh-cache")
+	release, err := NewDevRelease(ntpReleasePath, "", "", ntpReleasePathBoshCache)
+	assert.NoError(err)
+
+	lightOpinionsPath := filepath.Join(workDir, "../test-assets/ntp-opinions/opinions.yml")
+	darkOpinionsPath := filepath.Join(workDir, "../test-assets/ntp-opinions/dark-opinions.yml")
+	opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath)
+	assert.NoError(err)
+
+	properties, err := release.Jobs[0].getPropertiesForJob(opinions, map[string]interface{}{
+		"ntp_conf":          "role.conf",
+		"with.json.default": map[interface{}]interface{}{"other": "value"},
+	})
+	actualJSON, err := json.Marshal(properties)
+	if assert.NoError(err) {
+		assert.JSONEq(`{
+			"ntp_conf" : "role.conf",
+			"with": {
+				"json": {
+					"default": { "other": "value" }
+				}
+			}
+		}`, string(actualJSON), "The overrides should replace the opinions")
+	}
+
+	_, err = release.Jobs[0].getPropertiesForJob(opinions, map[string]interface{}{"tor.private_key": "key"})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Property tor.private_key of job ntpd is excluded by the dark opinions")
+	}
+}
+
 func TestWriteConfigs(t *testing.T) {
 	assert := assert.New(t)
 
@@ -303,8 +361,18 @@ func TestWriteConfigs(t *testing.T) {
 		"properties": {
 			"prop": "bar"
 		},
+		"name": "dummy role",
+		"index": "((FISSILE_SPEC_INDEX))",
+		"bootstrap": "((FISSILE_SPEC_BOOTSTRAP))",
+		"id": "((FISSILE_SPEC_ID))",
+		"address": "((FISSILE_SPEC_ADDRESS))",
+		"ip": "((FISSILE_SPEC_ADDRESS))",
 		"networks": {
-			"default": {}
-		}
+			"default": {
+				"ip": "((FISSILE_SPEC_ADDRESS))",
+				"dns_record_name": "((FISSILE_SPEC_ID))"
+			}
+		},
+		"links": {}
 	}`, string(json))
 }
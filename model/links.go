@@ -0,0 +1,203 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hpcloud/fissile/validation"
+
+	"gopkg.in/yaml.v2"
+)
+
+// JobLinkInfo describes a BOSH link a job provides or consumes
+type JobLinkInfo struct {
+	Name       string   `yaml:"name"`
+	Type       string   `yaml:"type"`
+	Optional   bool     `yaml:"optional"`   // Consumed links only
+	Properties []string `yaml:"properties"` // Provided links only; the properties shared with consumers
+}
+
+// ResolvedLink is the provider of a link consumed by a job of a role
+type ResolvedLink struct {
+	Role *Role
+	Job  *Job
+	Link *JobLinkInfo
+}
+
+// roleJobLink selects the provider of a link consumed by a job of a role
+type roleJobLink struct {
+	From string `yaml:"from"` // Name of the provided link
+}
+
+// loadJobLinks reads the links the job provides and consumes from its spec
+func (j *Job) loadJobLinks() (err error) {
+	if j.Provides, err = j.parseJobLinks("provides"); err != nil {
+		return err
+	}
+	j.Consumes, err = j.parseJobLinks("consumes")
+	return err
+}
+
+// parseJobLinks reads the links of the given key of the job spec
+func (j *Job) parseJobLinks(key string) ([]*JobLinkInfo, error) {
+	if j.jobSpec[key] == nil {
+		return nil, nil
+	}
+
+	contents, err := yaml.Marshal(j.jobSpec[key])
+	if err != nil {
+		return nil, err
+	}
+	var links []*JobLinkInfo
+	if err := yaml.Unmarshal(contents, &links); err != nil {
+		return nil, fmt.Errorf("Error reading the %s links of job %s: %s", key, j.Name, err)
+	}
+
+	for _, link := range links {
+		if link.Name == "" || link.Type == "" {
+			return nil, fmt.Errorf("Job %s %s a link without a name or type", j.Name, key)
+		}
+	}
+
+	return links, nil
+}
+
+// ResolvedLinks returns the providers of the links consumed by the job of the
+// role with the given name, by link name. Optional links without a provider
+// are left out.
+func (r *Role) ResolvedLinks(jobName string) map[string]*ResolvedLink {
+	return r.links[jobName]
+}
+
+// resolveLinks finds the provider of each link consumed by the jobs of the
+// roles: the one job of any role providing a link of the same type, or with
+// from, the one providing the link of that name
+func resolveLinks(rolesManifest *RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	var providers []*ResolvedLink
+	for _, role := range rolesManifest.Roles {
+		for _, job := range role.Jobs {
+			for _, link := range job.Provides {
+				providers = append(providers, &ResolvedLink{Role: role, Job: job, Link: link})
+			}
+		}
+	}
+
+	for _, role := range rolesManifest.Roles {
+		role.links = make(map[string]map[string]*ResolvedLink)
+
+		for _, roleJob := range role.JobNameList {
+			job := roleJob.job
+			if job == nil {
+				continue
+			}
+			jobField := fmt.Sprintf("roles[%s].jobs[%s]", role.Name, roleJob.name())
+
+			consumed := make(map[string]bool, len(job.Consumes))
+			links := make(map[string]*ResolvedLink)
+			for _, link := range job.Consumes {
+				consumed[link.Name] = true
+				field := fmt.Sprintf("%s.consumes[%s]", jobField, link.Name)
+
+				from := ""
+				if roleJob.Consumes[link.Name] != nil {
+					from = roleJob.Consumes[link.Name].From
+				}
+
+				var candidates []*ResolvedLink
+				for _, provider := range providers {
+					if provider.Link.Type == link.Type && (from == "" || provider.Link.Name == from) {
+						candidates = append(candidates, provider)
+					}
+				}
+
+				switch {
+				case len(candidates) == 1:
+					links[link.Name] = candidates[0]
+				case len(candidates) > 1:
+					names := make([]string, 0, len(candidates))
+					for _, candidate := range candidates {
+						names = append(names, fmt.Sprintf("%s/%s/%s", candidate.Role.Name, candidate.Role.JobName(candidate.Job), candidate.Link.Name))
+					}
+					sort.Strings(names)
+					allErrs = append(allErrs, validation.Invalid(field, names,
+						fmt.Sprintf("Several jobs provide a link of type %s, select one with from", link.Type)))
+				case from != "":
+					allErrs = append(allErrs, validation.Required(field,
+						fmt.Sprintf("No job provides a link named %s of type %s", from, link.Type)))
+				case !link.Optional:
+					allErrs = append(allErrs, validation.Required(field,
+						fmt.Sprintf("No job provides a link of type %s", link.Type)))
+				}
+			}
+			role.links[roleJob.name()] = links
+
+			var names []string
+			for name := range roleJob.Consumes {
+				if !consumed[name] {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				allErrs = append(allErrs, validation.NotFound(
+					fmt.Sprintf("%s.consumes", jobField), name))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// getLinksForJob returns the links consumed by the job in the role, for its
+// configuration: the role and job providing each, the address of the role,
+// and the properties the provider shares, with the values the provider has
+func (j *Job) getLinksForJob(role *Role, opinions *Opinions) (map[string]interface{}, error) {
+	links := make(map[string]interface{})
+
+	for name, provider := range role.ResolvedLinks(role.JobName(j)) {
+		providerProperties, err := provider.Job.getPropertiesForJob(opinions, provider.Role.JobProperties(provider.Role.JobName(provider.Job)))
+		if err != nil {
+			return nil, err
+		}
+
+		properties := make(map[string]interface{})
+		for _, property := range provider.Link.Properties {
+			keyPieces, err := getKeyGrams(property)
+			if err != nil {
+				return nil, err
+			}
+			if value, ok := lookupConfig(providerProperties, keyPieces); ok {
+				if err := insertConfig(properties, property, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		links[name] = map[string]interface{}{
+			"role":       provider.Role.Name,
+			"job":        provider.Role.JobName(provider.Job),
+			"name":       provider.Link.Name,
+			"type":       provider.Link.Type,
+			"address":    provider.Role.Name,
+			"properties": properties,
+		}
+	}
+
+	return links, nil
+}
+
+// lookupConfig returns the value of the configuration map at the given key
+func lookupConfig(config map[string]interface{}, keys []string) (interface{}, bool) {
+	parent := config
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		parent = child
+	}
+	value, ok := parent[keys[len(keys)-1]]
+	return value, ok
+}
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadJobLinks(t *testing.T) {
+	assert := assert.New(t)
+
+	job := &Job{Name: "web"}
+	assert.NoError(yaml.Unmarshal([]byte(`
+provides:
+- name: web
+  type: http
+  properties: [web.port]
+consumes:
+- name: db
+  type: database
+- name: cache
+  type: memcache
+  optional: true
+`), &job.jobSpec))
+
+	if assert.NoError(job.loadJobLinks()) {
+		assert.Equal([]*JobLinkInfo{
+			{Name: "web", Type: "http", Properties: []string{"web.port"}},
+		}, job.Provides)
+		assert.Equal([]*JobLinkInfo{
+			{Name: "db", Type: "database"},
+			{Name: "cache", Type: "memcache", Optional: true},
+		}, job.Consumes)
+	}
+
+	job = &Job{Name: "web"}
+	assert.NoError(yaml.Unmarshal([]byte(`
+consumes:
+- name: db
+`), &job.jobSpec))
+	assert.EqualError(job.loadJobLinks(), "Job web consumes a link without a name or type")
+}
+
+// linksTestManifest returns a role manifest with a role consuming links, and
+// roles providing them
+func linksTestManifest(consumes map[string]*roleJobLink) *RoleManifest {
+	db := &Job{
+		Name:       "db",
+		Provides:   []*JobLinkInfo{{Name: "primary", Type: "database", Properties: []string{"db.port", "db.password"}}},
+		Properties: []*JobProperty{{Name: "db.port", Default: 5432}, {Name: "db.password", Default: "secret"}, {Name: "db.user"}},
+	}
+	replica := &Job{
+		Name:     "replica",
+		Provides: []*JobLinkInfo{{Name: "replica", Type: "database"}},
+	}
+	web := &Job{
+		Name: "web",
+		Consumes: []*JobLinkInfo{
+			{Name: "db", Type: "database"},
+			{Name: "cache", Type: "memcache", Optional: true},
+		},
+	}
+
+	return &RoleManifest{
+		Roles: Roles{
+			{
+				Name:        "web",
+				Jobs:        Jobs{web},
+				JobNameList: []*roleJob{{Name: "web", Consumes: consumes, job: web}},
+			},
+			{
+				Name:        "db",
+				Jobs:        Jobs{db, replica},
+				JobNameList: []*roleJob{{Name: "db", job: db}, {Name: "replica", job: replica}},
+			},
+		},
+	}
+}
+
+func TestResolveLinks(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest := linksTestManifest(map[string]*roleJobLink{"db": {From: "primary"}})
+	assert.Empty(resolveLinks(manifest))
+
+	links := manifest.Roles[0].ResolvedLinks("web")
+	if assert.Len(links, 1) && assert.Contains(links, "db") {
+		assert.Equal("db", links["db"].Role.Name)
+		assert.Equal("db", links["db"].Job.Name)
+		assert.Equal("primary", links["db"].Link.Name)
+	}
+
+	manifest = linksTestManifest(nil)
+	errs := resolveLinks(manifest)
+	assert.Equal(`roles[web].jobs[web].consumes[db]: Invalid value: ["db/db/primary","db/replica/replica"]: Several jobs provide a link of type database, select one with from`, errs.Errors())
+
+	manifest = linksTestManifest(map[string]*roleJobLink{"db": {From: "secondary"}, "queue": {From: "queue"}})
+	errs = resolveLinks(manifest)
+	assert.Equal([]string{
+		`roles[web].jobs[web].consumes[db]: Required value: No job provides a link named secondary of type database`,
+		`roles[web].jobs[web].consumes: Not found: "queue"`,
+	}, strings.Split(errs.Errors(), "\n"))
+
+	manifest = linksTestManifest(nil)
+	manifest.Roles = manifest.Roles[:1]
+	errs = resolveLinks(manifest)
+	assert.Equal(`roles[web].jobs[web].consumes[db]: Required value: No job provides a link of type database`, errs.Errors())
+}
+
+func TestWriteConfigsLinks(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest := linksTestManifest(map[string]*roleJobLink{"db": {From: "primary"}})
+	if !assert.Empty(resolveLinks(manifest)) {
+		return
+	}
+
+	tempDir, err := ioutil.TempDir("", "fissile-links-test")
+	assert.NoError(err)
+	defer os.RemoveAll(tempDir)
+
+	lightOpinionsPath := filepath.Join(tempDir, "opinions.yml")
+	assert.NoError(ioutil.WriteFile(lightOpinionsPath, []byte("properties:\n  db:\n    port: 3306\n"), 0644))
+	darkOpinionsPath := filepath.Join(tempDir, "dark-opinions.yml")
+	assert.NoError(ioutil.WriteFile(darkOpinionsPath, []byte("properties: {}\n"), 0644))
+
+	role := manifest.Roles[0]
+	contents, err := role.Jobs[0].WriteConfigs(role, lightOpinionsPath, darkOpinionsPath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var config map[string]interface{}
+	assert.NoError(json.Unmarshal(contents, &config))
+	linksJSON, err := json.Marshal(config["links"])
+	if assert.NoError(err) {
+		assert.JSONEq(`{
+			"db": {
+				"role": "db",
+				"job": "db",
+				"name": "primary",
+				"type": "database",
+				"address": "db",
+				"properties": {
+					"db": {
+						"port": 3306,
+						"password": "secret"
+					}
+				}
+			}
+		}`, string(linksJSON))
+	}
+}
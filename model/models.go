@@ -13,5 +13,6 @@ type JobProperty struct {
 	Name        string
 	Description string
 	Default     interface{}
+	Type        string // As declared by the spec, e.g. password or certificate; mostly empty
 	Job         *Job
 }
@@ -20,7 +20,8 @@ func MakeMapOfVariables(rolesManifest *RoleManifest) CVMap {
 }
 
 // GetVariablesForRole returns all the environment variables required for
-// calculating all the templates for the role
+// calculating all the templates for the role, those passed to its sidecars,
+// those enabling its jobs, and those of its health check templates
 func (r *Role) GetVariablesForRole() (ConfigurationVariableSlice, error) {
 
 	configsDictionary := MakeMapOfVariables(r.rolesManifest)
@@ -46,6 +47,26 @@ func (r *Role) GetVariablesForRole() (ConfigurationVariableSlice, error) {
 		}
 	}
 
+	for _, sidecar := range r.Sidecars {
+		for _, envVar := range sidecar.Env {
+			if confVar, ok := configsDictionary[envVar]; ok {
+				configs[confVar.Name] = confVar
+			}
+		}
+	}
+
+	for _, envVar := range r.jobConditions {
+		if confVar, ok := configsDictionary[envVar]; ok {
+			configs[confVar.Name] = confVar
+		}
+	}
+
+	for _, envVar := range r.healthCheckVariables() {
+		if confVar, ok := configsDictionary[envVar]; ok {
+			configs[confVar.Name] = confVar
+		}
+	}
+
 	result := make(ConfigurationVariableSlice, 0, len(configs))
 
 	for _, value := range configs {
@@ -1,9 +1,7 @@
 package model
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 
@@ -40,25 +38,14 @@ func newPackage(release *Release, packageReleaseInfo map[interface{}]interface{}
 	return pkg, nil
 }
 
-// ValidateSHA1 validates that the SHA1 of the actual package archive is the same
-// as the one from the release manifest
+// ValidateSHA1 validates that the checksum of the actual package archive is the
+// same as the one from the release manifest
 func (p *Package) ValidateSHA1() error {
-	file, err := os.Open(p.Path)
+	computedSHA1, err := fileChecksum(p.Path, p.SHA1)
 	if err != nil {
-		return fmt.Errorf("Error opening the package archive %s for SHA1 calculation", p.Path)
+		return fmt.Errorf("Error calculating SHA1 of package archive %s: %s", p.Path, err)
 	}
 
-	defer file.Close()
-
-	h := sha1.New()
-
-	_, err = io.Copy(h, file)
-	if err != nil {
-		return fmt.Errorf("Error copying package archive %s for SHA1 calculation", p.Path)
-	}
-
-	computedSHA1 := fmt.Sprintf("%x", h.Sum(nil))
-
 	if computedSHA1 != p.SHA1 {
 		return fmt.Errorf("Computed SHA1 (%s) is different than manifest SHA1 (%s) for package archive %s", computedSHA1, p.SHA1, p.Path)
 	}
@@ -144,6 +131,9 @@ func (p *Package) loadPackageDependencies() (err error) {
 }
 
 func (p *Package) packageArchivePath() string {
+	if p.Release.FinalRelease {
+		return filepath.Join(p.Release.packagesDirPath(), fmt.Sprintf("%s.tgz", p.Name))
+	}
 	return filepath.Join(p.Release.DevBOSHCacheDir, p.SHA1)
 }
 
@@ -164,3 +154,17 @@ func (p *Package) GetPackageCompiledTempDir(workDir string) string {
 func (p *Package) GetPackageCompiledDir(workDir string) string {
 	return filepath.Join(workDir, p.Fingerprint, "compiled")
 }
+
+// GetPackageCompilationKeyFile returns the path to the file recording the
+// fingerprints the package was compiled with, underneath the main cache
+// directory
+func (p *Package) GetPackageCompilationKeyFile(workDir string) string {
+	return filepath.Join(workDir, p.Fingerprint, "compilation-key")
+}
+
+// GetPackageCompilationStateFile returns the path to the file recording how
+// far the compilation of the package got, underneath the main cache
+// directory
+func (p *Package) GetPackageCompilationStateFile(workDir string) string {
+	return filepath.Join(workDir, p.Fingerprint, "compilation-state")
+}
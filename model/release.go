@@ -8,6 +8,7 @@ import (
 	"regexp"
 
 	"github.com/hpcloud/fissile/util"
+	"github.com/hpcloud/fissile/validation"
 
 	"gopkg.in/yaml.v2"
 )
@@ -23,6 +24,7 @@ type Release struct {
 	Version            string
 	Path               string
 	DevBOSHCacheDir    string
+	FinalRelease       bool
 
 	manifest map[interface{}]interface{}
 }
@@ -31,6 +33,7 @@ const (
 	jobsDir      = "jobs"
 	packagesDir  = "packages"
 	manifestFile = "release.MF"
+	licenseFile  = "license.tgz"
 )
 
 // yamlBinaryRegexp is the regexp used to look for the "!binary" YAML tag; see
@@ -169,6 +172,10 @@ func (r *Release) loadDependenciesForPackages() error {
 func (r *Release) loadLicense() error {
 	r.License.Files = make(map[string][]byte)
 
+	if r.FinalRelease {
+		return r.loadFinalReleaseLicense()
+	}
+
 	licenseFile, err := os.Open(r.licensePath())
 	if os.IsNotExist(err) {
 		// There were never licenses to load.
@@ -189,7 +196,7 @@ func (r *Release) loadLicense() error {
 		return err
 	}
 
-	r.License.Files[licenseFilePath] = licenseContents
+	r.License.Files[filepath.ToSlash(licenseFilePath)] = licenseContents
 
 	return nil
 }
@@ -214,6 +221,57 @@ func (r *Release) validatePathStructure() error {
 	return nil
 }
 
+// ValidateArchives verifies the checksums of all job and package archives
+// (and, for final releases, of the license archive) against the release
+// manifest. All missing and corrupt archives are reported together. Archives
+// of dev releases that are not in the BOSH cache are not reported; they are
+// only needed for compilation and image building.
+func (r *Release) ValidateArchives() error {
+	allErrs := validation.ErrorList{}
+
+	validate := func(field, path, expected string) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if r.FinalRelease {
+				allErrs = append(allErrs, validation.NotFound(field, path))
+			}
+			return
+		}
+
+		computed, err := fileChecksum(path, expected)
+		if err != nil {
+			allErrs = append(allErrs, validation.InternalError(field, err))
+			return
+		}
+
+		if computed != expected {
+			allErrs = append(allErrs, validation.Invalid(field, computed,
+				fmt.Sprintf("does not match the release manifest checksum %s of %s", expected, path)))
+		}
+	}
+
+	for _, job := range r.Jobs {
+		validate(fmt.Sprintf("releases[%s].jobs[%s]", r.Name, job.Name), job.Path, job.SHA1)
+	}
+
+	for _, pkg := range r.Packages {
+		validate(fmt.Sprintf("releases[%s].packages[%s]", r.Name, pkg.Name), pkg.Path, pkg.SHA1)
+	}
+
+	if r.FinalRelease {
+		if license, ok := r.manifest["license"].(map[interface{}]interface{}); ok {
+			if expected, ok := license["sha1"].(string); ok {
+				validate(fmt.Sprintf("releases[%s].license", r.Name), r.finalReleaseLicensePath(), expected)
+			}
+		}
+	}
+
+	if len(allErrs) != 0 {
+		return fmt.Errorf("Release %s has missing or corrupt archives:\n%s", r.Name, allErrs.Errors())
+	}
+
+	return nil
+}
+
 func (r *Release) licensePath() string {
 	return filepath.Join(r.Path, "LICENSE")
 }
@@ -227,5 +285,8 @@ func (r *Release) jobsDirPath() string {
 }
 
 func (r *Release) manifestFilePath() string {
+	if r.FinalRelease {
+		return filepath.Join(r.Path, manifestFile)
+	}
 	return filepath.Join(r.getDevReleaseManifestsDir(), r.getDevReleaseManifestFilename())
 }
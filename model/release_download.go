@@ -0,0 +1,210 @@
+package model
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// boshIOURL is the location of bosh.io; it is a variable so tests can point
+// it at a local server
+var boshIOURL = "https://bosh.io"
+
+// remoteReleaseShorthandRegexp matches the `<org>/<repo>@<version>` shorthand
+// for releases hosted on bosh.io, e.g. `cloudfoundry/uaa-release@1.2.3`
+var remoteReleaseShorthandRegexp = regexp.MustCompile(`^([\w.-]+/[\w.-]+)@([\w.+-]+)$`)
+
+// RemoteRelease describes a final release tarball that has to be downloaded
+type RemoteRelease struct {
+	URL  string // Download URL of the release tarball
+	SHA1 string // Expected SHA1 of the release tarball
+}
+
+// boshIORelease is a single entry of the bosh.io releases API
+type boshIORelease struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	SHA1    string `json:"sha1"`
+}
+
+// IsRemoteRelease tests whether the given release reference is a URL, or a
+// bosh.io shorthand, rather than a local path
+func IsRemoteRelease(ref string) bool {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return true
+	}
+
+	if !remoteReleaseShorthandRegexp.MatchString(ref) {
+		return false
+	}
+
+	// Local paths always win over the shorthand
+	_, err := os.Stat(ref)
+	return os.IsNotExist(err)
+}
+
+// ResolveRemoteRelease works out the download URL and the expected SHA1 for
+// a remote release reference. The reference is either:
+// - a bosh.io shorthand, `<org>/<repo>@<version>`
+// - a bosh.io download URL, `https://bosh.io/d/github.com/<org>/<repo>?v=<version>`
+// - any other URL, with the SHA1 given as a fragment: `https://.../release.tgz#sha1=<sha1>`
+// The SHA1 of bosh.io releases is looked up using the bosh.io API, unless a
+// fragment is given.
+func ResolveRemoteRelease(ref string) (*RemoteRelease, error) {
+	if match := remoteReleaseShorthandRegexp.FindStringSubmatch(ref); match != nil {
+		ref = fmt.Sprintf("%s/d/github.com/%s?v=%s", boshIOURL, match[1], url.QueryEscape(match[2]))
+	}
+
+	releaseURL, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid release URL %s: %s", ref, err)
+	}
+
+	fragment := releaseURL.Fragment
+	releaseURL.Fragment = ""
+	release := &RemoteRelease{URL: releaseURL.String()}
+
+	if fragment != "" {
+		if !strings.HasPrefix(fragment, "sha1=") {
+			return nil, fmt.Errorf("Invalid release URL %s: the fragment must be sha1=<sha1>", ref)
+		}
+		release.SHA1 = strings.ToLower(strings.TrimPrefix(fragment, "sha1="))
+		return release, nil
+	}
+
+	source, isBoshIO := boshIOSource(releaseURL)
+	if !isBoshIO {
+		return nil, fmt.Errorf("Can't verify release %s: no SHA1 known; append #sha1=<sha1> to the URL", ref)
+	}
+
+	entry, err := lookupBoshIORelease(source, releaseURL.Query().Get("v"))
+	if err != nil {
+		return nil, err
+	}
+
+	release.SHA1 = entry.SHA1
+	if releaseURL.Query().Get("v") == "" {
+		// Pin the version, so the tarball matches the SHA1 we looked up
+		query := releaseURL.Query()
+		query.Set("v", entry.Version)
+		releaseURL.RawQuery = query.Encode()
+		release.URL = releaseURL.String()
+	}
+
+	return release, nil
+}
+
+// boshIOSource returns the release source (e.g. github.com/org/repo) of a
+// bosh.io download URL
+func boshIOSource(releaseURL *url.URL) (string, bool) {
+	boshIO, err := url.Parse(boshIOURL)
+	if err != nil || releaseURL.Host != boshIO.Host || !strings.HasPrefix(releaseURL.Path, "/d/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(releaseURL.Path, "/d/"), true
+}
+
+// lookupBoshIORelease finds a release version in the bosh.io API; the latest
+// version is used if none is given
+func lookupBoshIORelease(source, version string) (*boshIORelease, error) {
+	apiURL := fmt.Sprintf("%s/api/v1/releases/%s", boshIOURL, source)
+
+	response, err := http.Get(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up release %s on bosh.io: %s", source, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error looking up release %s on bosh.io: %s", source, response.Status)
+	}
+
+	var entries []boshIORelease
+	if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("Error reading bosh.io information for release %s: %s", source, err)
+	}
+
+	// bosh.io lists the newest versions first
+	for _, entry := range entries {
+		if version == "" || entry.Version == version {
+			return &entry, nil
+		}
+	}
+
+	if version == "" {
+		return nil, fmt.Errorf("Release %s has no versions on bosh.io", source)
+	}
+	return nil, fmt.Errorf("Release %s has no version %s on bosh.io", source, version)
+}
+
+// DownloadRelease fetches a remote release into the download directory, and
+// returns the path to the tarball. Tarballs are stored by their SHA1, so a
+// release that was downloaded before is reused without any network access
+// beyond resolving its SHA1. Downloads that don't match the expected SHA1
+// are discarded.
+func DownloadRelease(ref, downloadDir string) (string, error) {
+	release, err := ResolveRemoteRelease(ref)
+	if err != nil {
+		return "", err
+	}
+
+	targetPath := filepath.Join(downloadDir, release.SHA1+".tgz")
+	if actualSHA1, err := fileSHA1(targetPath); err == nil && actualSHA1 == release.SHA1 {
+		// Mark it as used, for fissile cache prune
+		now := time.Now()
+		os.Chtimes(targetPath, now, now)
+		return targetPath, nil
+	}
+
+	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+		return "", fmt.Errorf("Error creating release download directory %s: %s", downloadDir, err)
+	}
+
+	tempFile, err := ioutil.TempFile(downloadDir, ".download-")
+	if err != nil {
+		return "", fmt.Errorf("Error creating temporary file in %s: %s", downloadDir, err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	response, err := http.Get(release.URL)
+	if err != nil {
+		return "", fmt.Errorf("Error downloading release %s: %s", release.URL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error downloading release %s: %s", release.URL, response.Status)
+	}
+
+	hash := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(tempFile, hash), response.Body); err != nil {
+		return "", fmt.Errorf("Error downloading release %s: %s", release.URL, err)
+	}
+
+	if actualSHA1 := hex.EncodeToString(hash.Sum(nil)); actualSHA1 != release.SHA1 {
+		return "", fmt.Errorf("Downloaded release %s has SHA1 %s, expected %s", release.URL, actualSHA1, release.SHA1)
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return "", fmt.Errorf("Error writing release %s: %s", release.URL, err)
+	}
+
+	if err := os.Rename(tempFile.Name(), targetPath); err != nil {
+		return "", fmt.Errorf("Error moving downloaded release into %s: %s", targetPath, err)
+	}
+
+	return targetPath, nil
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeBoshIO starts a server that serves the tor final release tarball
+// like bosh.io does, and counts the downloads
+func newFakeBoshIO(t *testing.T, tarballPath string, downloads *int) *httptest.Server {
+	tarballSHA1, err := fileSHA1(tarballPath)
+	assert.NoError(t, err)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/releases/github.com/example/tor-release":
+			json.NewEncoder(w).Encode([]boshIORelease{
+				{
+					Name:    "github.com/example/tor-release",
+					Version: "0.3.5",
+					URL:     server.URL + "/d/github.com/example/tor-release?v=0.3.5",
+					SHA1:    tarballSHA1,
+				},
+				{
+					Name:    "github.com/example/tor-release",
+					Version: "0.3.4",
+					URL:     server.URL + "/d/github.com/example/tor-release?v=0.3.4",
+					SHA1:    "0000000000000000000000000000000000000000",
+				},
+			})
+		case "/d/github.com/example/tor-release", "/tor.tgz":
+			*downloads++
+			http.ServeFile(w, r, tarballPath)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return server
+}
+
+func TestIsRemoteRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsRemoteRelease("https://bosh.io/d/github.com/cloudfoundry/uaa-release?v=1.2.3"))
+	assert.True(IsRemoteRelease("http://example.com/release.tgz"))
+	assert.True(IsRemoteRelease("cloudfoundry/uaa-release@1.2.3"))
+	assert.False(IsRemoteRelease("cloudfoundry/uaa-release"))
+	assert.False(IsRemoteRelease("../test-assets/tor-final-release.tgz"))
+	assert.False(IsRemoteRelease("/var/releases/uaa@1.2.3"))
+}
+
+func TestDownloadRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+	tarballPath := filepath.Join(workDir, "../test-assets/tor-final-release.tgz")
+	tarballSHA1, err := fileSHA1(tarballPath)
+	assert.NoError(err)
+
+	downloads := 0
+	server := newFakeBoshIO(t, tarballPath, &downloads)
+	defer server.Close()
+
+	savedBoshIOURL := boshIOURL
+	boshIOURL = server.URL
+	defer func() { boshIOURL = savedBoshIOURL }()
+
+	downloadDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(downloadDir)
+
+	path, err := DownloadRelease("example/tor-release@0.3.5", downloadDir)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(filepath.Join(downloadDir, tarballSHA1+".tgz"), path)
+	assert.Equal(1, downloads)
+
+	// The cached tarball is reused
+	path, err = DownloadRelease(server.URL+"/d/github.com/example/tor-release?v=0.3.5", downloadDir)
+	assert.NoError(err)
+	assert.Equal(filepath.Join(downloadDir, tarballSHA1+".tgz"), path)
+	assert.Equal(1, downloads)
+
+	// Without a version, the latest one is used
+	release, err := ResolveRemoteRelease(server.URL + "/d/github.com/example/tor-release")
+	if assert.NoError(err) {
+		assert.Equal(server.URL+"/d/github.com/example/tor-release?v=0.3.5", release.URL)
+		assert.Equal(tarballSHA1, release.SHA1)
+	}
+
+	// The downloaded release can be loaded like a local one
+	cacheDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(cacheDir)
+
+	finalRelease, err := NewFinalRelease(path, "tor", "0.3.5", cacheDir)
+	if assert.NoError(err) {
+		assert.Len(finalRelease.Jobs, 3)
+	}
+}
+
+func TestDownloadReleaseErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+	tarballPath := filepath.Join(workDir, "../test-assets/tor-final-release.tgz")
+
+	downloads := 0
+	server := newFakeBoshIO(t, tarballPath, &downloads)
+	defer server.Close()
+
+	savedBoshIOURL := boshIOURL
+	boshIOURL = server.URL
+	defer func() { boshIOURL = savedBoshIOURL }()
+
+	downloadDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(downloadDir)
+
+	_, err = DownloadRelease("example/tor-release@0.3.4", downloadDir)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "expected 0000000000000000000000000000000000000000")
+	}
+
+	_, err = DownloadRelease("example/tor-release@9.9.9", downloadDir)
+	assert.EqualError(err, "Release github.com/example/tor-release has no version 9.9.9 on bosh.io")
+
+	_, err = DownloadRelease(server.URL+"/tor.tgz", downloadDir)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "no SHA1 known")
+	}
+
+	_, err = DownloadRelease(server.URL+"/tor.tgz#sha1=1111111111111111111111111111111111111111", downloadDir)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "expected 1111111111111111111111111111111111111111")
+	}
+
+	entries, err := ioutil.ReadDir(downloadDir)
+	assert.NoError(err)
+	assert.Empty(entries, "Failed downloads should not be kept")
+}
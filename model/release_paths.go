@@ -0,0 +1,131 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReleaseListPrefix marks the files listing releases among release paths, as
+// in `--release @releases.txt`
+const ReleaseListPrefix = "@"
+
+// IsDevRelease tests whether the given path is a dev release directory, with
+// its config and dev_releases directories
+func IsDevRelease(path string) bool {
+	release := &Release{Path: path}
+	for _, dir := range []string{release.getDevReleaseConfigDir(), release.getDevReleasesDir()} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// isReleasePattern tests whether a release path is a glob pattern
+func isReleasePattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// ExpandReleasePaths replaces the glob patterns among release paths by the
+// dev and final releases they match, sorted, and the release list files,
+// given as @<file>, by the releases they list. Lists hold a release path,
+// remote release or glob pattern per line, relative to the directory of the
+// list; empty lines and lines starting with # are skipped. Releases found
+// several times are kept once, in the first place they are found.
+func ExpandReleasePaths(paths []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool, len(paths))
+	add := func(paths ...string) {
+		for _, path := range paths {
+			if !seen[path] {
+				seen[path] = true
+				result = append(result, path)
+			}
+		}
+	}
+
+	for _, path := range paths {
+		if !strings.HasPrefix(path, ReleaseListPrefix) {
+			expanded, err := expandReleasePath(path)
+			if err != nil {
+				return nil, err
+			}
+			add(expanded...)
+			continue
+		}
+
+		listPath := strings.TrimPrefix(path, ReleaseListPrefix)
+		listed, err := readReleaseList(listPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range listed {
+			expanded, err := expandReleasePath(path)
+			if err != nil {
+				return nil, fmt.Errorf("Error in release list %s: %s", listPath, err)
+			}
+			add(expanded...)
+		}
+	}
+	return result, nil
+}
+
+// expandReleasePath returns the releases matching a release path: those the
+// glob pattern matches, or the path itself
+func expandReleasePath(path string) ([]string, error) {
+	if IsRemoteRelease(path) || !isReleasePattern(path) {
+		return []string{path}, nil
+	}
+
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid release pattern %s: %s", path, err)
+	}
+	sort.Strings(matches)
+
+	var releases []string
+	for _, match := range matches {
+		if IsDevRelease(match) || IsFinalRelease(match) {
+			releases = append(releases, match)
+		}
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("No releases match %s", path)
+	}
+	return releases, nil
+}
+
+// readReleaseList returns the releases a release list file holds, with
+// relative paths made relative to the directory of the list
+func readReleaseList(listPath string) ([]string, error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading release list: %s", err)
+	}
+	defer file.Close()
+
+	dir := filepath.Dir(listPath)
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, ReleaseListPrefix) {
+			return nil, fmt.Errorf("Release list %s can't include the list %s", listPath, line)
+		}
+		if !IsRemoteRelease(line) && !filepath.IsAbs(line) {
+			line = filepath.Join(dir, line)
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading release list %s: %s", listPath, err)
+	}
+	return paths, nil
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandReleasePaths(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+	assetsDir := filepath.Join(workDir, "../test-assets")
+
+	torReleasePath := filepath.Join(assetsDir, "tor-boshrelease")
+	torFinalReleasePath := filepath.Join(assetsDir, "tor-final-release")
+	torFinalTarballPath := filepath.Join(assetsDir, "tor-final-release.tgz")
+	ntpReleasePath := filepath.Join(assetsDir, "ntp-release")
+
+	assert.True(IsDevRelease(torReleasePath))
+	assert.False(IsDevRelease(torFinalReleasePath))
+
+	// Only releases are matched, sorted
+	paths, err := ExpandReleasePaths([]string{filepath.Join(assetsDir, "tor-*"), ntpReleasePath})
+	if assert.NoError(err) {
+		assert.Equal([]string{torReleasePath, torFinalReleasePath, torFinalTarballPath, ntpReleasePath}, paths)
+	}
+
+	// Paths and remote releases are left alone
+	paths, err = ExpandReleasePaths([]string{"missing", "https://example.com/release.tgz"})
+	if assert.NoError(err) {
+		assert.Equal([]string{"missing", "https://example.com/release.tgz"}, paths)
+	}
+
+	_, err = ExpandReleasePaths([]string{filepath.Join(assetsDir, "role-manifests/*")})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "No releases match")
+	}
+
+	dir, err := ioutil.TempDir("", "fissile-release-paths-test")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Lists are relative to their directory, and releases are kept once
+	listPath := filepath.Join(dir, "releases.txt")
+	relativePath, err := filepath.Rel(dir, filepath.Join(assetsDir, "tor-final-release*"))
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(listPath, []byte("# The releases\n"+ntpReleasePath+"\n\n  "+relativePath+"\n"), 0644))
+	paths, err = ExpandReleasePaths([]string{ntpReleasePath, ReleaseListPrefix + listPath, torReleasePath})
+	if assert.NoError(err) {
+		assert.Equal([]string{ntpReleasePath, torFinalReleasePath, torFinalTarballPath, torReleasePath}, paths)
+	}
+
+	assert.NoError(ioutil.WriteFile(listPath, []byte("@other.txt\n"), 0644))
+	_, err = ExpandReleasePaths([]string{ReleaseListPrefix + listPath})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "can't include the list @other.txt")
+	}
+
+	_, err = ExpandReleasePaths([]string{ReleaseListPrefix + filepath.Join(dir, "missing.txt")})
+	assert.Error(err)
+}
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/pborman/uuid"
@@ -331,3 +332,33 @@ func TestGetDeploymentConfig(t *testing.T) {
 	}
 	assert.Len(configs, len(allExpected))
 }
+
+func TestReleaseValidateArchives(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	ntpReleasePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	ntpReleasePathBoshCache := filepath.Join(ntpReleasePath, "bosh-cache")
+	release, err := NewDevRelease(ntpReleasePath, "", "", ntpReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(release.ValidateArchives())
+
+	// Mess up the manifest checksums
+	release.Packages[0].SHA1 = "0000000000000000000000000000000000000000"
+	release.Jobs[0].SHA1 = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	err = release.ValidateArchives()
+	if assert.Error(err) {
+		lines := strings.Split(err.Error(), "\n")
+		if assert.Len(lines, 3) {
+			assert.Equal("Release ntp has missing or corrupt archives:", lines[0])
+			assert.Contains(lines[1], "releases[ntp].jobs[ntpd]: Invalid value: \"sha256:")
+			assert.Contains(lines[2], "releases[ntp].packages[ntp-4.2.8p2]: Invalid value: \"e41461c222b05f961350547da086569cc4264e54\"")
+		}
+	}
+}
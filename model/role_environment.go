@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hpcloud/fissile/validation"
+)
+
+// RoleRunEnvironment holds the environment variables of a docker role, by
+// name, with the templates of their values, referencing configuration
+// variables as ((NAME)). The role manifest either lists configuration
+// variables, passed as they are, or maps names to templates, for computed or
+// literal values.
+type RoleRunEnvironment map[string]string
+
+// UnmarshalYAML reads the environment of a role from either a list of
+// configuration variables or a map of templates by name
+func (e *RoleRunEnvironment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var names []string
+	if err := unmarshal(&names); err == nil {
+		*e = make(RoleRunEnvironment, len(names))
+		for _, name := range names {
+			(*e)[name] = variableTemplate(name)
+		}
+		return nil
+	}
+
+	var templates map[string]string
+	if err := unmarshal(&templates); err != nil {
+		return err
+	}
+	*e = RoleRunEnvironment(templates)
+	return nil
+}
+
+// variableTemplate returns the template of the value of a configuration
+// variable
+func variableTemplate(name string) string {
+	return fmt.Sprintf("((%s))", name)
+}
+
+// Names returns the names of the environment variables, sorted
+func (e RoleRunEnvironment) Names() []string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsComputed tests whether an environment variable gets a value of its own,
+// rather than that of the configuration variable of the same name
+func (e RoleRunEnvironment) IsComputed(name string) bool {
+	return e[name] != variableTemplate(name)
+}
+
+// envVarNamePattern matches valid environment variable names
+var envVarNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateRoleEnvironment reports the environment variables of a docker role
+// with invalid names, templates which can't be parsed, or templates using
+// undeclared configuration variables. Computed values can't replace those of
+// configuration variables.
+func validateRoleEnvironment(role *Role, declared CVMap) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, name := range role.Run.Environment.Names() {
+		field := fmt.Sprintf("roles[%s].run.env[%s]", role.Name, name)
+		template := role.Run.Environment[name]
+
+		if !envVarNamePattern.MatchString(name) {
+			allErrs = append(allErrs, validation.Invalid(field, name,
+				"Must consist of letters, digits and underscores, not starting with a digit"))
+			continue
+		}
+
+		varsInTemplate, err := parseTemplate(template)
+		if err != nil {
+			allErrs = append(allErrs, validation.Invalid(field, template,
+				fmt.Sprintf("Cannot parse template: %s", err)))
+			continue
+		}
+		for _, envVar := range varsInTemplate {
+			if _, ok := declared[envVar]; !ok {
+				allErrs = append(allErrs, validation.NotFound(
+					fmt.Sprintf("roles[%s].run.env", role.Name),
+					fmt.Sprintf("No variable declaration of '%s'", envVar)))
+			}
+		}
+
+		if _, ok := declared[name]; ok && role.Run.Environment.IsComputed(name) {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				fmt.Sprintf("A configuration variable has this name; it can only be passed as it is, as %s", variableTemplate(name))))
+		}
+	}
+
+	return allErrs
+}
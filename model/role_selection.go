@@ -0,0 +1,252 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// roleSelector matches the roles of a role selection expression
+type roleSelector interface {
+	matches(role *Role) bool
+}
+
+// roleNameSelector matches a role by name, or by a glob pattern of names
+type roleNameSelector struct {
+	pattern string
+	glob    bool
+}
+
+func (s *roleNameSelector) matches(role *Role) bool {
+	if !s.glob {
+		return role.Name == s.pattern
+	}
+	// The pattern is checked while parsing
+	matched, _ := path.Match(s.pattern, role.Name)
+	return matched
+}
+
+// roleAttributeSelector matches roles by tag, type, group, or flight stage
+type roleAttributeSelector struct {
+	attribute string
+	value     string
+}
+
+// roleSelectorAttributes are the attributes roles can be selected by
+var roleSelectorAttributes = []string{"tag", "type", "group", "stage"}
+
+func (s *roleAttributeSelector) matches(role *Role) bool {
+	switch s.attribute {
+	case "tag":
+		return role.HasTag(s.value)
+	case "type":
+		return string(role.Type) == s.value
+	case "group":
+		return role.Group == s.value
+	case "stage":
+		return role.Run != nil && string(role.Run.FlightStage) == s.value
+	}
+	return false
+}
+
+// roleNotSelector matches the roles another selector doesn't
+type roleNotSelector struct {
+	selector roleSelector
+}
+
+func (s *roleNotSelector) matches(role *Role) bool {
+	return !s.selector.matches(role)
+}
+
+// roleAndSelector matches the roles all of its selectors match
+type roleAndSelector struct {
+	selectors []roleSelector
+}
+
+func (s *roleAndSelector) matches(role *Role) bool {
+	for _, selector := range s.selectors {
+		if !selector.matches(role) {
+			return false
+		}
+	}
+	return true
+}
+
+// roleOrSelector matches the roles any of its selectors matches
+type roleOrSelector struct {
+	selectors []roleSelector
+}
+
+func (s *roleOrSelector) matches(role *Role) bool {
+	for _, selector := range s.selectors {
+		if selector.matches(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleSelectionParser parses a role selection expression:
+//
+//	expression := and ("OR" and)*
+//	and        := not ("AND" not)*
+//	not        := "NOT" not | "(" expression ")" | term
+//	term       := <name> | <glob> | tag:<tag> | type:<type> | group:<group> | stage:<stage>
+//
+// Operators are case insensitive.
+type roleSelectionParser struct {
+	expression string
+	tokens     []string
+	position   int
+}
+
+// parseRoleSelection parses a role selection expression
+func parseRoleSelection(expression string) (roleSelector, error) {
+	tokenizer := strings.NewReplacer("(", " ( ", ")", " ) ")
+	p := &roleSelectionParser{
+		expression: expression,
+		tokens:     strings.Fields(tokenizer.Replace(expression)),
+	}
+	if len(p.tokens) == 0 {
+		return nil, p.errorf("empty role selection")
+	}
+
+	selector, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.position < len(p.tokens) {
+		return nil, p.errorf("unexpected %s", p.tokens[p.position])
+	}
+	return selector, nil
+}
+
+func (p *roleSelectionParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid role selection '%s': %s", p.expression, fmt.Sprintf(format, args...))
+}
+
+// accept consumes the next token if it is the given operator
+func (p *roleSelectionParser) accept(operator string) bool {
+	if p.position < len(p.tokens) && strings.EqualFold(p.tokens[p.position], operator) {
+		p.position++
+		return true
+	}
+	return false
+}
+
+func (p *roleSelectionParser) parseOr() (roleSelector, error) {
+	selector, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	selectors := []roleSelector{selector}
+	for p.accept("OR") {
+		selector, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	if len(selectors) == 1 {
+		return selectors[0], nil
+	}
+	return &roleOrSelector{selectors: selectors}, nil
+}
+
+func (p *roleSelectionParser) parseAnd() (roleSelector, error) {
+	selector, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	selectors := []roleSelector{selector}
+	for p.accept("AND") {
+		selector, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	if len(selectors) == 1 {
+		return selectors[0], nil
+	}
+	return &roleAndSelector{selectors: selectors}, nil
+}
+
+func (p *roleSelectionParser) parseNot() (roleSelector, error) {
+	if p.accept("NOT") {
+		selector, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &roleNotSelector{selector: selector}, nil
+	}
+
+	if p.accept("(") {
+		selector, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("missing )")
+		}
+		return selector, nil
+	}
+
+	if p.position >= len(p.tokens) {
+		return nil, p.errorf("unexpected end")
+	}
+	token := p.tokens[p.position]
+	switch strings.ToUpper(token) {
+	case "AND", "OR", ")":
+		return nil, p.errorf("unexpected %s", token)
+	}
+	p.position++
+	return p.parseTerm(token)
+}
+
+func (p *roleSelectionParser) parseTerm(term string) (roleSelector, error) {
+	if parts := strings.SplitN(term, ":", 2); len(parts) == 2 {
+		selector := &roleAttributeSelector{attribute: parts[0], value: parts[1]}
+		if selector.value == "" {
+			return nil, p.errorf("%s without a value", term)
+		}
+		switch selector.attribute {
+		case "tag", "group":
+		case "type":
+			switch RoleType(selector.value) {
+			case RoleTypeBosh, RoleTypeBoshTask, RoleTypeDocker:
+			default:
+				return nil, p.errorf("unknown role type %s, expected one of %s, %s, %s",
+					selector.value, RoleTypeBosh, RoleTypeBoshTask, RoleTypeDocker)
+			}
+		case "stage":
+			switch FlightStage(selector.value) {
+			case FlightStagePreFlight, FlightStageFlight, FlightStagePostFlight, FlightStageManual:
+			default:
+				return nil, p.errorf("unknown flight stage %s, expected one of %s, %s, %s, %s",
+					selector.value, FlightStagePreFlight, FlightStageFlight, FlightStagePostFlight, FlightStageManual)
+			}
+		default:
+			return nil, p.errorf("unknown attribute %s, expected one of %s",
+				selector.attribute, strings.Join(roleSelectorAttributes, ", "))
+		}
+		return selector, nil
+	}
+
+	glob := strings.ContainsAny(term, "*?[")
+	if glob {
+		if _, err := path.Match(term, ""); err != nil {
+			return nil, p.errorf("bad pattern %s", term)
+		}
+	}
+	return &roleNameSelector{pattern: term, glob: glob}, nil
+}
+
+// plainRoleName returns the role name a selection consists of, if it is a
+// single role name
+func plainRoleName(selector roleSelector) (string, bool) {
+	if nameSelector, ok := selector.(*roleNameSelector); ok && !nameSelector.glob {
+		return nameSelector.pattern, true
+	}
+	return "", false
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectRolesExpressions(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := &RoleManifest{
+		Roles: Roles{
+			{Name: "router-http", Type: RoleTypeBosh, Group: "edge", Run: &RoleRun{FlightStage: FlightStageFlight}},
+			{Name: "router-tcp", Type: RoleTypeBosh, Group: "edge", Tags: []string{"dev-only"}, Run: &RoleRun{FlightStage: FlightStageFlight}},
+			{Name: "smoke-tests", Type: RoleTypeBoshTask, Tags: []string{"dev-only"}, Run: &RoleRun{FlightStage: FlightStagePostFlight}},
+			{Name: "database", Type: RoleTypeBosh, Group: "data", Run: &RoleRun{FlightStage: FlightStageFlight}},
+		},
+	}
+	roleManifest.rolesByName = make(map[string]*Role)
+	for _, role := range roleManifest.Roles {
+		roleManifest.rolesByName[role.Name] = role
+	}
+
+	for _, sample := range []struct {
+		selections []string
+		expected   []string
+		err        string
+	}{
+		{selections: []string{"database", "router-tcp"}, expected: []string{"database", "router-tcp"}},
+		{selections: []string{"router-*"}, expected: []string{"router-http", "router-tcp"}},
+		{selections: []string{"router-*", "router-tcp", "database"}, expected: []string{"router-http", "router-tcp", "database"}},
+		{selections: []string{"tag:dev-only AND NOT type:bosh-task"}, expected: []string{"router-tcp"}},
+		{selections: []string{"tag:dev-only and not type:bosh-task"}, expected: []string{"router-tcp"}},
+		{selections: []string{"group:data OR stage:post-flight"}, expected: []string{"smoke-tests", "database"}},
+		{selections: []string{"NOT (group:edge OR tag:dev-only)"}, expected: []string{"database"}},
+		{selections: []string{"group:edge AND (tag:dev-only OR router-h*)"}, expected: []string{"router-http", "router-tcp"}},
+		{selections: []string{"NOT NOT database"}, expected: []string{"database"}},
+		{selections: []string{"tag:missing"}, expected: nil},
+		{selections: []string{"missing", "router-*", "gone"}, err: "Some roles are unknown: [missing gone]"},
+		{selections: []string{"tag:dev-only AND"}, err: "Invalid role selection 'tag:dev-only AND': unexpected end"},
+		{selections: []string{"(tag:dev-only"}, err: "Invalid role selection '(tag:dev-only': missing )"},
+		{selections: []string{"tag:dev-only database"}, err: "Invalid role selection 'tag:dev-only database': unexpected database"},
+		{selections: []string{"OR database"}, err: "Invalid role selection 'OR database': unexpected OR"},
+		{selections: []string{"color:red"}, err: "Invalid role selection 'color:red': unknown attribute color, expected one of tag, type, group, stage"},
+		{selections: []string{"type:vm"}, err: "Invalid role selection 'type:vm': unknown role type vm, expected one of bosh, bosh-task, docker"},
+		{selections: []string{"stage:"}, err: "Invalid role selection 'stage:': stage: without a value"},
+		{selections: []string{"router-[a"}, err: "Invalid role selection 'router-[a': bad pattern router-[a"},
+	} {
+		roles, err := roleManifest.SelectRoles(sample.selections)
+		if sample.err != "" {
+			assert.EqualError(err, sample.err, "while testing %v", sample.selections)
+			continue
+		}
+		if !assert.NoError(err, "while testing %v", sample.selections) {
+			continue
+		}
+		var names []string
+		for _, role := range roles {
+			names = append(names, role.Name)
+		}
+		assert.Equal(sample.expected, names, "while testing %v", sample.selections)
+	}
+}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hpcloud/fissile/validation"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RoleVariant is a variant of a role: a role of its own, stamped out of the
+// role it is declared in, with the settings it overrides
+type RoleVariant struct {
+	Name      string                      // The variant is named <role>-<name>
+	Overrides map[interface{}]interface{} // The settings of the role replaced, as written in the role manifest
+}
+
+// UnmarshalYAML reads a variant: its name, along with the settings of the
+// role it overrides
+func (v *RoleVariant) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var settings map[interface{}]interface{}
+	if err := unmarshal(&settings); err != nil {
+		return err
+	}
+
+	*v = RoleVariant{Overrides: settings}
+	if name, ok := settings["name"]; ok && name != nil {
+		v.Name = fmt.Sprintf("%v", name)
+	}
+	delete(v.Overrides, "name")
+	return nil
+}
+
+// MarshalYAML writes a variant as it is written in the role manifest
+func (v RoleVariant) MarshalYAML() (interface{}, error) {
+	settings := make(map[interface{}]interface{}, len(v.Overrides)+1)
+	for key, value := range v.Overrides {
+		settings[key] = value
+	}
+	settings["name"] = v.Name
+	return settings, nil
+}
+
+// variantName returns the name of the role stamped out of a role for one of
+// its variants
+func variantName(role *Role, variant *RoleVariant) string {
+	return fmt.Sprintf("%s-%s", role.Name, variant.Name)
+}
+
+// RoleNames returns the names of the roles of the role manifest, the roles
+// with variants replaced by their variants, whether these are expanded yet
+// or not
+func (m *RoleManifest) RoleNames() []string {
+	var names []string
+	for _, role := range m.Roles {
+		if len(role.Variants) == 0 {
+			names = append(names, role.Name)
+			continue
+		}
+		for _, variant := range role.Variants {
+			names = append(names, variantName(role, variant))
+		}
+	}
+	return names
+}
+
+// mergeSettings returns the settings of a role as written in the role
+// manifest, with those of the overrides replacing them: maps are merged, key
+// by key, other values are replaced as a whole
+func mergeSettings(settings, overrides map[interface{}]interface{}) map[interface{}]interface{} {
+	merged := make(map[interface{}]interface{}, len(settings)+len(overrides))
+	for key, value := range settings {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		original, isMap := merged[key].(map[interface{}]interface{})
+		override, overridesMap := value.(map[interface{}]interface{})
+		if isMap && overridesMap {
+			merged[key] = mergeSettings(original, override)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// yamlErrorLine matches the line numbers of YAML errors
+var yamlErrorLine = regexp.MustCompile(`line \d+: `)
+
+// variantError describes the error reading the settings of a variant, without
+// the line numbers, which are those of the merged settings rather than of the
+// role manifest
+func variantError(err error) string {
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		return yamlErrorLine.ReplaceAllString(strings.Join(typeErr.Errors, "; "), "")
+	}
+	return yamlErrorLine.ReplaceAllString(err.Error(), "")
+}
+
+// expandRoleVariants replaces the roles with variants by a role for each of
+// their variants, with the settings of the role as written in the role
+// manifest, overridden by those of the variant. Roles depending on a role
+// with variants depend on all of its variants.
+func expandRoleVariants(rolesManifest *RoleManifest, manifestContents []byte) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	hasVariants := false
+	for _, role := range rolesManifest.Roles {
+		hasVariants = hasVariants || len(role.Variants) > 0
+	}
+	if !hasVariants {
+		return allErrs
+	}
+
+	// The roles as written, to merge the overrides of the variants into
+	var written struct {
+		Roles []map[interface{}]interface{} `yaml:"roles"`
+	}
+	if err := yaml.Unmarshal(manifestContents, &written); err != nil {
+		return append(allErrs, validation.InternalError("roles", err))
+	}
+
+	names := make(map[string]bool, len(rolesManifest.Roles))
+	for _, role := range rolesManifest.Roles {
+		names[role.Name] = true
+	}
+
+	variantNames := make(map[string][]string)
+	roles := make(Roles, 0, len(rolesManifest.Roles))
+	for i, role := range rolesManifest.Roles {
+		if len(role.Variants) == 0 {
+			roles = append(roles, role)
+			continue
+		}
+
+		settings := written.Roles[i]
+		delete(settings, "variants")
+		for index, variant := range role.Variants {
+			if variant.Name == "" {
+				allErrs = append(allErrs, validation.Required(
+					fmt.Sprintf("roles[%s].variants[%d].name", role.Name, index), ""))
+				continue
+			}
+
+			name := variantName(role, variant)
+			field := fmt.Sprintf("roles[%s].variants[%s]", role.Name, variant.Name)
+			if names[name] {
+				allErrs = append(allErrs, validation.Duplicate(field, name))
+				continue
+			}
+			names[name] = true
+			if _, ok := variant.Overrides["variants"]; ok {
+				allErrs = append(allErrs, validation.Forbidden(field+".variants", "Variants can't have variants of their own"))
+				continue
+			}
+
+			variantSettings := mergeSettings(settings, variant.Overrides)
+			variantSettings["name"] = name
+
+			variantRole := &Role{}
+			contents, err := yaml.Marshal(variantSettings)
+			if err == nil {
+				err = yaml.Unmarshal(contents, variantRole)
+			}
+			if err != nil {
+				allErrs = append(allErrs, validation.Invalid(field, variant.Name, variantError(err)))
+				continue
+			}
+
+			roles = append(roles, variantRole)
+			variantNames[role.Name] = append(variantNames[role.Name], name)
+		}
+	}
+
+	for _, role := range roles {
+		if role.Run == nil || len(role.Run.DependsOn) == 0 {
+			continue
+		}
+		var dependsOn []string
+		for _, name := range role.Run.DependsOn {
+			if variants, ok := variantNames[name]; ok {
+				dependsOn = append(dependsOn, variants...)
+			} else {
+				dependsOn = append(dependsOn, name)
+			}
+		}
+		role.Run.DependsOn = dependsOn
+	}
+
+	rolesManifest.Roles = roles
+	return allErrs
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadRoleManifestVariants(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/variants.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	var names []string
+	for _, role := range rolesManifest.Roles {
+		names = append(names, role.Name)
+	}
+	assert.Equal([]string{"router-z1", "router-z2", "myrole"}, names)
+	assert.Nil(rolesManifest.LookupRole("router"))
+
+	z1 := rolesManifest.LookupRole("router-z1")
+	z2 := rolesManifest.LookupRole("router-z2")
+	if !assert.NotNil(z1) || !assert.NotNil(z2) {
+		return
+	}
+	assert.Empty(z1.Variants)
+
+	// The settings of the role are kept, unless overridden
+	for _, role := range []*Role{z1, z2} {
+		assert.Equal([]string{"edge"}, role.Tags)
+		assert.Equal(128, role.Run.Memory.Request)
+		assert.Len(role.Jobs, 1)
+		assert.Equal("((BAR))", role.Configuration.Templates["properties.tor.private_key"])
+	}
+	assert.Equal("8080", z1.Run.ExposedPorts[0].Internal)
+	assert.Equal("8081", z2.Run.ExposedPorts[0].Internal)
+	assert.Equal("((FOO))", z1.Configuration.Templates["properties.tor.hostname"])
+	assert.Equal("z2.((FOO))", z2.Configuration.Templates["properties.tor.hostname"])
+
+	// Roles depending on the role depend on all of its variants
+	assert.Equal([]string{"router-z1", "router-z2"}, rolesManifest.LookupRole("myrole").Run.DependsOn)
+
+	// As written, the variants are kept, and named after them
+	written, err := ReadRoleManifest(roleManifestPath)
+	if assert.NoError(err) {
+		assert.Equal([]string{"router-z1", "router-z2", "myrole"}, written.RoleNames())
+		contents, err := yaml.Marshal(written.Roles[0].Variants)
+		if assert.NoError(err) {
+			assert.Contains(string(contents), "- name: z1\n")
+		}
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/variants-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[router].variants[1].name: Required value`,
+			`roles[router].variants[z1]: Duplicate value: "router-z1"`,
+			`roles[router].variants[z3].variants: Forbidden: Variants can't have variants of their own`,
+			`roles[router].variants[z4]: Invalid value: "z4": cannot unmarshal !!str ` + "`lots`" + ` into model.memory`,
+			`roles[router-z2].variants[z5]: Duplicate value: "router-z2-z5"`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestMergeSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	settings := map[interface{}]interface{}{
+		"name": "router",
+		"tags": []interface{}{"a", "b"},
+		"run": map[interface{}]interface{}{
+			"memory": 128,
+			"env":    []interface{}{"FOO"},
+		},
+	}
+	merged := mergeSettings(settings, map[interface{}]interface{}{
+		"tags": []interface{}{"c"},
+		"run": map[interface{}]interface{}{
+			"memory": 256,
+		},
+		"group": "zones",
+	})
+	assert.Equal(map[interface{}]interface{}{
+		"name": "router",
+		"tags": []interface{}{"c"},
+		"run": map[interface{}]interface{}{
+			"memory": 256,
+			"env":    []interface{}{"FOO"},
+		},
+		"group": "zones",
+	}, merged)
+
+	// The settings merged into are left alone
+	assert.Equal(128, settings["run"].(map[interface{}]interface{})["memory"])
+}
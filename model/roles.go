@@ -1,18 +1,23 @@
 package model
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/hpcloud/fissile/validation"
 
+	"github.com/docker/distribution/reference"
 	"gopkg.in/yaml.v2"
 )
 
@@ -26,9 +31,22 @@ const (
 	RoleTypeDocker   = RoleType("docker")    // A role that is a raw Docker image
 )
 
+// Locations of scripts inside role images
+const (
+	renderedJobsDir = "/var/vcap/jobs"   // Jobs get rendered into <renderedJobsDir>/<job>
+	jobDrainScript  = "bin/drain"        // Drain script of a job, relative to its directory
+	roleScriptsDir  = "/opt/hcf/startup" // Role scripts with relative paths are copied here
+)
+
 // FlightStage describes when a role should be executed
 type FlightStage string
 
+// validUlimits lists the resource limit names understood by docker
+var validUlimits = []string{
+	"core", "cpu", "data", "fsize", "locks", "memlock", "msgqueue", "nice",
+	"nofile", "nproc", "rss", "rtprio", "rttime", "sigpending", "stack",
+}
+
 // These are the flight stages available
 const (
 	FlightStagePreFlight  = FlightStage("pre-flight")  // A role that runs before the main jobs start
@@ -37,19 +55,47 @@ const (
 	FlightStageManual     = FlightStage("manual")      // A role that only runs via user intervention
 )
 
+// RestartPolicy describes when the processes of a role are restarted
+type RestartPolicy string
+
+// These are the restart policies available
+const (
+	RestartPolicyAlways    = RestartPolicy("always")     // Restart whenever the role exits
+	RestartPolicyOnFailure = RestartPolicy("on-failure") // Restart when the role fails, up to max-retries times
+)
+
+// VolumeMedium is the storage backing an ephemeral volume
+type VolumeMedium string
+
+// These are the media of ephemeral volumes
+const (
+	VolumeMediumDisk   = VolumeMedium("disk")   // Backed by the disk of the node
+	VolumeMediumMemory = VolumeMedium("memory") // Backed by memory (tmpfs), counted against the memory of the role
+)
+
 // RoleManifest represents a collection of roles
 type RoleManifest struct {
-	Roles         Roles          `yaml:"roles"`
-	Configuration *Configuration `yaml:"configuration"`
+	Roles         Roles                 `yaml:"roles"`
+	Configuration *Configuration        `yaml:"configuration"`
+	Defaults      *RoleManifestDefaults `yaml:"defaults"`
+	Registries    map[string]string     `yaml:"registries"`
 
 	manifestFilePath string
+	manifestSHA1     string
 	rolesByName      map[string]*Role
+	variableUsage    *VariableUsage
+}
+
+// RoleManifestDefaults holds settings applied to all roles which do not
+// set them themselves
+type RoleManifestDefaults struct {
+	Resources *RoleRunResources `yaml:"resources"`
 }
 
 // Role represents a collection of jobs that are colocated on a container
 type Role struct {
 	Name              string         `yaml:"name"`
-	Jobs              Jobs           `yaml:"_,omitempty"`
+	Jobs              Jobs           `yaml:"-"`
 	EnvironScripts    []string       `yaml:"environment_scripts"`
 	Scripts           []string       `yaml:"scripts"`
 	PostConfigScripts []string       `yaml:"post_config_scripts"`
@@ -58,35 +104,199 @@ type Role struct {
 	Configuration     *Configuration `yaml:"configuration"`
 	Run               *RoleRun       `yaml:"run"`
 	Tags              []string       `yaml:"tags"`
+	Sidecars          []*RoleSidecar `yaml:"sidecars"`
+	Group             string         `yaml:"group,omitempty"`
+	BaseImage         string         `yaml:"base-image,omitempty"`
+	Image             *RoleImage     `yaml:"image,omitempty"`
+	Variants          []*RoleVariant `yaml:"variants,omitempty"` // Roles stamped out of this one, in its place
 
 	rolesManifest *RoleManifest
+	links         map[string]map[string]*ResolvedLink // Resolved consumed links, by job and link name
+	jobConditions map[string]string                   // Variables enabling the conditional jobs, by job name
+	jobProperties map[string]map[string]interface{}   // Values of job properties overriding the opinions, by job and property name
+}
+
+// RoleImage customizes the image of a role with Dockerfile fragments, given
+// by paths relative to the role manifest
+type RoleImage struct {
+	PreSnippets  []string `yaml:"pre-snippets,omitempty"`  // Spliced in before the files of the role are added
+	PostSnippets []string `yaml:"post-snippets,omitempty"` // Spliced in after, before switching to the user of the role
 }
 
 // RoleRun describes how a role should behave at runtime
 type RoleRun struct {
-	Scaling           *RoleRunScaling       `yaml:"scaling"`
-	Capabilities      []string              `yaml:"capabilities"`
-	PersistentVolumes []*RoleRunVolume      `yaml:"persistent-volumes"`
-	SharedVolumes     []*RoleRunVolume      `yaml:"shared-volumes"`
-	Memory            int                   `yaml:"memory"`
-	VirtualCPUs       int                   `yaml:"virtual-cpus"`
-	ExposedPorts      []*RoleRunExposedPort `yaml:"exposed-ports"`
-	FlightStage       FlightStage           `yaml:"flight-stage"`
-	HealthCheck       *HealthCheck          `yaml:"healthcheck,omitempty"`
-	Environment       []string              `yaml:"env"`
-}
-
-// RoleRunScaling describes how a role should scale out at runtime
+	Scaling            *RoleRunScaling           `yaml:"scaling"`
+	Capabilities       []string                  `yaml:"capabilities"`
+	PersistentVolumes  []*RoleRunVolume          `yaml:"persistent-volumes"`
+	SharedVolumes      []*RoleRunVolume          `yaml:"shared-volumes"`
+	EphemeralVolumes   []*RoleRunEphemeralVolume `yaml:"ephemeral-volumes,omitempty"`
+	Memory             RoleRunMemory             `yaml:"memory"`
+	CPU                RoleRunCPU                `yaml:"cpu"`
+	VirtualCPUs        int                       `yaml:"virtual-cpus"` // Deprecated, the CPU request
+	ExposedPorts       []*RoleRunExposedPort     `yaml:"exposed-ports"`
+	FlightStage        FlightStage               `yaml:"flight-stage"`
+	HealthCheck        *HealthCheck              `yaml:"healthcheck,omitempty"`
+	Environment        RoleRunEnvironment        `yaml:"env"` // Names of configuration variables, or templates by name; docker roles only
+	Resources          *RoleRunResources         `yaml:"resources,omitempty"`
+	Canary             *RoleRunCanary            `yaml:"canary,omitempty"`
+	DrainScripts       []*RoleRunDrainScript     `yaml:"drain-script,omitempty"`
+	OOMScoreAdj        *int                      `yaml:"oom-score-adj,omitempty"` // -1000 to 1000; higher is killed first when out of memory
+	Restart            *RoleRunRestart           `yaml:"restart,omitempty"`
+	User               *int                      `yaml:"user,omitempty"`              // UID the role runs as; root by default
+	Group              *int                      `yaml:"group,omitempty"`             // GID the role runs as, along with the user
+	InitialInstances   *int32                    `yaml:"initial-instances,omitempty"` // Instances deployed with; scaling.min by default, 0 to ship the role scaled down
+	DriftProbe         *RoleRunDriftProbe        `yaml:"drift-probe,omitempty"`
+	Ingress            *RoleRunIngress           `yaml:"ingress,omitempty"`
+	ActivePassiveProbe string                    `yaml:"active-passive-probe,omitempty"` // Command succeeding on the one active instance
+	DependsOn          []string                  `yaml:"depends-on,omitempty"`           // Roles to be up, or tasks to be done, before the role starts
+}
+
+// DefaultDriftProbeInterval is the number of minutes between the checks of
+// the drift probe, unless the role manifest says otherwise
+const DefaultDriftProbeInterval = 5
+
+// RoleRunDriftProbe describes the periodic check of a role for configuration
+// drift, built into its image: its job templates are rendered again, with the
+// secrets read from Vault anew, and compared with what they rendered to when
+// the role started.
+type RoleRunDriftProbe struct {
+	Interval int `yaml:"interval"` // Minutes between checks, 1 to 59
+}
+
+// RoleRunRestart describes when a role is restarted. Long running roles
+// restart always by default, and tasks on failure.
+type RoleRunRestart struct {
+	Policy     RestartPolicy `yaml:"policy"`
+	MaxRetries int           `yaml:"max-retries"` // With on-failure; 0 for no limit
+}
+
+// RoleRunMemory is the memory, in MB, a role requests and is limited to.
+// A plain number is the request; without a limit, docker limits the role to
+// its request.
+type RoleRunMemory struct {
+	Request int `yaml:"request"`
+	Limit   int `yaml:"limit"`
+}
+
+// UnmarshalYAML reads the memory of a role, either as a request and limit,
+// or as a plain number, the request
+func (m *RoleRunMemory) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var request int
+	if err := unmarshal(&request); err == nil {
+		*m = RoleRunMemory{Request: request}
+		return nil
+	}
+
+	type memory RoleRunMemory
+	return unmarshal((*memory)(m))
+}
+
+// DockerLimit returns the memory limit of the role in docker, in MB; 0 for
+// no limit
+func (m RoleRunMemory) DockerLimit() int {
+	if m.Limit > 0 {
+		return m.Limit
+	}
+	return m.Request
+}
+
+// RoleRunCPU is the number of CPUs a role requests and is limited to. A
+// plain number is the request.
+type RoleRunCPU struct {
+	Request float64 `yaml:"request"`
+	Limit   float64 `yaml:"limit"`
+}
+
+// UnmarshalYAML reads the CPUs of a role, either as a request and limit, or
+// as a plain number, the request
+func (c *RoleRunCPU) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var request float64
+	if err := unmarshal(&request); err == nil {
+		*c = RoleRunCPU{Request: request}
+		return nil
+	}
+
+	type cpu RoleRunCPU
+	return unmarshal((*cpu)(c))
+}
+
+// RoleRunDrainScript is a script run to drain a role before its container
+// stops: either the BOSH drain script of one of its jobs, or a custom script
+type RoleRunDrainScript struct {
+	Job    string `yaml:"job"`    // Name of a job of the role, whose bin/drain is run
+	Script string `yaml:"script"` // Custom script; relative paths are role scripts, like those of scripts
+}
+
+// RoleSidecar describes an additional container running next to a role, such
+// as a log shipper or a metrics exporter
+type RoleSidecar struct {
+	Name    string               `yaml:"name"`
+	Image   string               `yaml:"image"`
+	Command []string             `yaml:"command"`
+	Env     []string             `yaml:"env"`     // Names of the configuration variables passed to the sidecar
+	Volumes []*RoleSidecarVolume `yaml:"volumes"` // Volumes of the role mounted into the sidecar
+}
+
+// RoleSidecarVolume describes a volume of a role shared with a sidecar
+type RoleSidecarVolume struct {
+	Tag  string `yaml:"tag"`  // Tag of a persistent, shared or ephemeral volume of the role
+	Path string `yaml:"path"` // Mount path in the sidecar; defaults to the path in the role
+}
+
+// RoleRunCanary describes a staged rollout of a role. Roles deployed as
+// StatefulSets use the partition; all others get a separate Deployment with
+// count canary instances.
+type RoleRunCanary struct {
+	Count     int32 `yaml:"count"`     // Number of canary instances, taken out of scaling.min
+	Partition int32 `yaml:"partition"` // Only pods with an ordinal at or above this get updated
+}
+
+// RoleRunResources describes additional container resource settings
+type RoleRunResources struct {
+	ShmSize    int              `yaml:"shm-size"`    // Size of /dev/shm, in MB
+	MemorySwap int              `yaml:"memory-swap"` // Memory plus swap limit, in MB; -1 for unlimited swap
+	Ulimits    []*RoleRunUlimit `yaml:"ulimits"`
+}
+
+// RoleRunUlimit describes a resource limit (see setrlimit(2)) of a container
+type RoleRunUlimit struct {
+	Name string `yaml:"name"`
+	Soft int64  `yaml:"soft"`
+	Hard int64  `yaml:"hard"`
+}
+
+// RoleRunScaling describes how a role should scale out at runtime. Roles
+// whose max is above their min are scaled between the two by the cluster,
+// at the given average CPU utilization or custom metrics of their pods.
 type RoleRunScaling struct {
-	Min int32 `yaml:"min"`
-	Max int32 `yaml:"max"`
+	Min           int32                  `yaml:"min"`
+	Max           int32                  `yaml:"max"`
+	CPUTarget     int32                  `yaml:"cpu-target,omitempty"`     // Average CPU utilization, in percent of the CPU request
+	CustomMetrics []*RoleRunCustomMetric `yaml:"custom-metrics,omitempty"` // Per pod metrics, averaged over the pods
+}
+
+// RoleRunCustomMetric is a metric exposed by the pods of a role, which the
+// role is scaled at
+type RoleRunCustomMetric struct {
+	Name   string `yaml:"name"`
+	Target string `yaml:"target"` // Average value per pod, as a quantity such as 100 or 500m
 }
 
 // RoleRunVolume describes a volume to be attached at runtime
 type RoleRunVolume struct {
-	Path string `yaml:"path"`
-	Tag  string `yaml:"tag"`
-	Size int    `yaml:"size"`
+	Path         string `yaml:"path"`
+	Tag          string `yaml:"tag"`
+	Size         int    `yaml:"size"`                    // In GB
+	StorageClass string `yaml:"storage-class,omitempty"` // Kubernetes storage class to claim the volume from
+}
+
+// RoleRunEphemeralVolume describes a scratch volume, created empty when the
+// role starts and removed when it stops
+type RoleRunEphemeralVolume struct {
+	Path   string       `yaml:"path"`
+	Tag    string       `yaml:"tag"`
+	Size   int          `yaml:"size"`   // Size limit, in MB; 0 for no limit
+	Medium VolumeMedium `yaml:"medium"` // disk (the default) or memory
 }
 
 // RoleRunExposedPort describes a port to be available to other roles, or the outside world
@@ -98,12 +308,36 @@ type RoleRunExposedPort struct {
 	Public   bool   `yaml:"public"`
 }
 
-// HealthCheck describes a non-standard health check endpoint
+// RoleRunIngress describes how HTTP requests from outside the cluster reach
+// a role, through an Ingress
+type RoleRunIngress struct {
+	Host string             `yaml:"host"`
+	Path string             `yaml:"path,omitempty"` // Path prefix of the requests, / by default
+	Port string             `yaml:"port,omitempty"` // Name of the exposed port; the only one by default
+	TLS  *RoleRunIngressTLS `yaml:"tls,omitempty"`
+}
+
+// RoleRunIngressTLS describes the TLS termination of the Ingress of a role
+type RoleRunIngressTLS struct {
+	SecretName string `yaml:"secret-name"` // Secret holding the certificate and key of the host
+}
+
+// HealthCheck describes the non-standard health checks of a role
 type HealthCheck struct {
-	URL     string            `yaml:"url"`     // URL for a HTTP GET to return 200~399. Cannot be used with other checks.
-	Headers map[string]string `yaml:"headers"` // Custom headers; only used for URL.
-	Command []string          `yaml:"command"` // Custom command. Cannot be used with other checks.
-	Port    int32             `yaml:"port"`    // Port for a TCP probe. Cannot be used with other checks.
+	Readiness *HealthProbe `yaml:"readiness,omitempty"` // Whether the role can receive traffic
+	Liveness  *HealthProbe `yaml:"liveness,omitempty"`  // Whether the role has to be restarted
+}
+
+// HealthProbe describes a non-standard health check endpoint
+type HealthProbe struct {
+	URL              string            `yaml:"url"`               // URL for a HTTP GET to return 200~399. Cannot be used with other checks.
+	Headers          map[string]string `yaml:"headers"`           // Custom headers; only used for URL.
+	Command          []string          `yaml:"command"`           // Custom command. Cannot be used with other checks.
+	Port             int32             `yaml:"port"`              // Port for a TCP probe. Cannot be used with other checks.
+	InitialDelay     int32             `yaml:"initial_delay"`     // Seconds before the first check; default if 0.
+	Period           int32             `yaml:"period"`            // Seconds between checks; default if 0.
+	Timeout          int32             `yaml:"timeout"`           // Seconds after which a check fails; default if 0.
+	FailureThreshold int32             `yaml:"failure_threshold"` // Failed checks in a row before the role is unhealthy; default if 0.
 }
 
 // Roles is an array of Role*
@@ -118,10 +352,21 @@ type Configuration struct {
 
 // ConfigurationVariable is a configuration to be exposed to the IaaS
 type ConfigurationVariable struct {
-	Name        string                          `yaml:"name"`
-	Default     interface{}                     `yaml:"default"`
-	Description string                          `yaml:"description"`
-	Generator   *ConfigurationVariableGenerator `yaml:"generator"`
+	Name          string                          `yaml:"name"`
+	Default       interface{}                     `yaml:"default"`
+	Description   string                          `yaml:"description"`
+	Generator     *ConfigurationVariableGenerator `yaml:"generator"`
+	Secret        bool                            `yaml:"secret"`
+	Type          string                          `yaml:"type"`           // See the VariableType constants; string if empty
+	AllowedValues []string                        `yaml:"allowed-values"` // The values of an enum variable
+	Min           *int                            `yaml:"min"`            // Lower bound of an int variable
+	Max           *int                            `yaml:"max"`            // Upper bound of an int variable
+}
+
+// IsSecret tests whether the variable holds sensitive data, i.e. it is
+// explicitly marked as secret, or its value is generated
+func (cv *ConfigurationVariable) IsSecret() bool {
+	return cv.Secret || cv.Generator != nil
 }
 
 // CVMap is a map from variable name to ConfigurationVariable, for
@@ -147,16 +392,71 @@ func (confVars ConfigurationVariableSlice) Swap(i, j int) {
 }
 
 // ConfigurationVariableGenerator describes how to automatically generate values
-// for a configuration variable
+// for a configuration variable. Variables sharing a generator ID get their
+// values from the same generation, e.g. a certificate and its private key.
 type ConfigurationVariableGenerator struct {
-	ID        string `yaml:"id"`
-	Type      string `yaml:"type"`
-	ValueType string `yaml:"value_type"`
+	ID           string   `yaml:"id"`
+	Type         string   `yaml:"type"`
+	ValueType    string   `yaml:"value_type"`
+	CA           string   `yaml:"ca"`            // Generator ID of the CA signing a certificate
+	RoleName     string   `yaml:"role_name"`     // Role whose service names are added to a certificate
+	SubjectNames []string `yaml:"subject_names"` // Additional names of a certificate; may use ((VARIABLES))
+	Backend      string   `yaml:"backend"`       // Backend generating the values; the default one if empty
+	Length       int      `yaml:"length"`        // Length of a password; a default length if 0
+	Charset      string   `yaml:"charset"`       // Characters a password is made of; letters and digits if empty
+}
+
+// Generator types
+const (
+	GeneratorTypePassword      = "Password"
+	GeneratorTypeSSH           = "SSH"
+	GeneratorTypeCACertificate = "CACertificate"
+	GeneratorTypeCertificate   = "Certificate"
+)
+
+// Generator value types, selecting which part of a generated value a
+// variable receives
+const (
+	ValueTypeCertificate = "certificate"
+	ValueTypePrivateKey  = "private_key"
+	ValueTypePublicKey   = "public_key"
+	ValueTypeFingerprint = "fingerprint"
+)
+
+// generatorTypes maps the lower-cased generator types to their canonical form
+var generatorTypes = map[string]string{
+	strings.ToLower(GeneratorTypePassword):      GeneratorTypePassword,
+	strings.ToLower(GeneratorTypeSSH):           GeneratorTypeSSH,
+	strings.ToLower(GeneratorTypeCACertificate): GeneratorTypeCACertificate,
+	strings.ToLower(GeneratorTypeCertificate):   GeneratorTypeCertificate,
+}
+
+// GroupID returns the ID grouping the variables whose values are generated
+// together; variables without an explicit ID form a group of their own
+func (cv *ConfigurationVariable) GroupID() string {
+	if cv.Generator == nil || cv.Generator.ID == "" {
+		return cv.Name
+	}
+	return cv.Generator.ID
 }
 
 type roleJob struct {
-	Name        string `yaml:"name"`
-	ReleaseName string `yaml:"release_name"`
+	Name        string                  `yaml:"name"`
+	ReleaseName string                  `yaml:"release_name"`
+	Consumes    map[string]*roleJobLink `yaml:"consumes"`             // Providers of consumed links, by link name
+	Condition   string                  `yaml:"condition,omitempty"`  // Variable enabling the job, as ((FEATURE_ENABLED))
+	Properties  map[string]interface{}  `yaml:"properties,omitempty"` // Values of job properties, overriding the opinions
+	Alias       string                  `yaml:"alias,omitempty"`      // Name of the job in the role, instead of its own
+
+	job *Job // The job, once found in its release
+}
+
+// name returns the name of the job in its role: its alias, if any
+func (j *roleJob) name() string {
+	if j.Alias != "" {
+		return j.Alias
+	}
+	return j.Name
 }
 
 // Len is the number of roles in the slice
@@ -195,6 +495,8 @@ func LoadRoleManifest(manifestFilePath string, releases []*Release) (*RoleManife
 
 	rolesManifest := RoleManifest{}
 	rolesManifest.manifestFilePath = manifestFilePath
+	manifestSHA1 := sha1.Sum(manifestContents)
+	rolesManifest.manifestSHA1 = hex.EncodeToString(manifestSHA1[:])
 	if err := yaml.Unmarshal(manifestContents, &rolesManifest); err != nil {
 		return nil, err
 	}
@@ -210,6 +512,7 @@ func LoadRoleManifest(manifestFilePath string, releases []*Release) (*RoleManife
 	declaredConfigs := MakeMapOfVariables(&rolesManifest)
 
 	allErrs := validation.ErrorList{}
+	allErrs = append(allErrs, expandRoleVariants(&rolesManifest, manifestContents)...)
 
 	for i := len(rolesManifest.Roles) - 1; i >= 0; i-- {
 		role := rolesManifest.Roles[i]
@@ -257,43 +560,102 @@ func LoadRoleManifest(manifestFilePath string, releases []*Release) (*RoleManife
 				continue
 			}
 
+			roleJob.job = job
 			role.Jobs = append(role.Jobs, job)
 		}
 
 		role.calculateRoleConfigurationTemplates()
 		rolesManifest.rolesByName[role.Name] = role
+
+		allErrs = append(allErrs, validateRoleGroup(role)...)
+		allErrs = append(allErrs, validateBaseImage(role)...)
+		allErrs = append(allErrs, validateImageSnippets(role)...)
+		allErrs = append(allErrs, validateRoleScripts(role)...)
+		allErrs = append(allErrs, validateDrainScripts(role)...)
+		allErrs = append(allErrs, normalizeSidecars(role, declaredConfigs)...)
+		allErrs = append(allErrs, validateJobAliases(role)...)
+		allErrs = append(allErrs, validateJobConditions(role, declaredConfigs)...)
+		allErrs = append(allErrs, validateJobProperties(role)...)
+		allErrs = append(allErrs, validateActivePassiveProbe(role)...)
 	}
 
+	allErrs = append(allErrs, resolveLinks(&rolesManifest)...)
+	allErrs = append(allErrs, validateRoleDependencies(&rolesManifest)...)
+
 	allErrs = append(allErrs, validateVariableSorting(rolesManifest.Configuration.Variables)...)
+	allErrs = append(allErrs, validateSecretVariables(rolesManifest.Configuration.Variables)...)
+	allErrs = append(allErrs, validateVariableTypes(rolesManifest.Configuration.Variables)...)
+	allErrs = append(allErrs, validateTemplateSyntax(&rolesManifest)...)
 	allErrs = append(allErrs, validateVariableUsage(&rolesManifest)...)
 	allErrs = append(allErrs, validateTemplateUsage(&rolesManifest)...)
 	allErrs = append(allErrs, validateNonTemplates(&rolesManifest)...)
+	allErrs = append(allErrs, validateRegistries(&rolesManifest)...)
+	allErrs = append(allErrs, validateVariableGenerators(&rolesManifest)...)
 
 	if len(allErrs) != 0 {
 		return nil, fmt.Errorf(allErrs.Errors())
 	}
 
+	rolesManifest.variableUsage = indexVariableUsage(&rolesManifest)
+
 	return &rolesManifest, nil
 }
 
-// GetRoleManifestDevPackageVersion gets the aggregate signature of all the packages
-func (m *RoleManifest) GetRoleManifestDevPackageVersion(roles Roles, extra string) (string, error) {
-	// Make sure our roles are sorted, to have consistent output
-	roles = append(Roles{}, roles...)
-	sort.Sort(roles)
+// ReadRoleManifest reads a role manifest as written, without looking up the
+// jobs of its roles, applying defaults, or validating it
+func ReadRoleManifest(manifestFilePath string) (*RoleManifest, error) {
+	manifestContents, err := ioutil.ReadFile(manifestFilePath)
+	if err != nil {
+		return nil, err
+	}
 
-	hasher := sha1.New()
-	hasher.Write([]byte(extra))
+	rolesManifest := &RoleManifest{manifestFilePath: manifestFilePath}
+	if err := yaml.Unmarshal(manifestContents, rolesManifest); err != nil {
+		return nil, err
+	}
+	return rolesManifest, nil
+}
 
-	for _, role := range roles {
-		version, err := role.GetRoleDevVersion()
-		if err != nil {
-			return "", err
+// MarshalCanonical writes the role manifest as YAML in a stable form, to
+// compare manifests: all settings are written, in the order of their
+// declaration, with the keys of maps and the variables sorted. The roles of
+// manifests loaded by LoadRoleManifest have their defaults applied, and the
+// templates of the manifest merged into theirs.
+func (m *RoleManifest) MarshalCanonical() ([]byte, error) {
+	canonical := *m
+	if m.Configuration != nil {
+		configuration := *m.Configuration
+		configuration.Variables = append(ConfigurationVariableSlice{}, m.Configuration.Variables...)
+		sort.Stable(configuration.Variables)
+		canonical.Configuration = &configuration
+	}
+	return yaml.Marshal(&canonical)
+}
+
+// LookupRegistry returns the image registry prefix (a registry, optionally
+// followed by an organization) of the given environment. An empty environment
+// name returns an empty prefix.
+func (m *RoleManifest) LookupRegistry(environment string) (string, error) {
+	if environment == "" {
+		return "", nil
+	}
+
+	prefix, ok := m.Registries[environment]
+	if !ok {
+		environments := make([]string, 0, len(m.Registries))
+		for name := range m.Registries {
+			environments = append(environments, name)
+		}
+		sort.Strings(environments)
+
+		if len(environments) == 0 {
+			return "", fmt.Errorf("Unknown registry environment %s: the role manifest has no registries", environment)
 		}
-		hasher.Write([]byte(version))
+		return "", fmt.Errorf("Unknown registry environment %s, expected one of: %s",
+			environment, strings.Join(environments, ", "))
 	}
 
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return strings.TrimSuffix(prefix, "/"), nil
 }
 
 // LookupRole will find the given role in the role manifest
@@ -301,7 +663,12 @@ func (m *RoleManifest) LookupRole(roleName string) *Role {
 	return m.rolesByName[roleName]
 }
 
-// SelectRoles will find only the given roles in the role manifest
+// SelectRoles will find only the given roles in the role manifest. Each
+// selection is a role name, a glob pattern of role names (router-*), or an
+// expression combining these with tag:<tag>, type:<type>, group:<group> and
+// stage:<flight-stage> using AND, OR, NOT and parentheses, e.g.
+// "tag:dev-only AND NOT type:bosh-task". The roles matching any selection are
+// returned in the order of the selections, each once.
 func (m *RoleManifest) SelectRoles(roleNames []string) (Roles, error) {
 	if len(roleNames) == 0 {
 		// No role names specified, assume all roles
@@ -310,12 +677,30 @@ func (m *RoleManifest) SelectRoles(roleNames []string) (Roles, error) {
 
 	var results Roles
 	var missingRoles []string
+	selected := make(map[*Role]bool)
 
 	for _, roleName := range roleNames {
-		if role, ok := m.rolesByName[roleName]; ok {
-			results = append(results, role)
-		} else {
-			missingRoles = append(missingRoles, roleName)
+		selector, err := parseRoleSelection(roleName)
+		if err != nil {
+			return nil, err
+		}
+
+		if name, ok := plainRoleName(selector); ok {
+			role, ok := m.rolesByName[name]
+			if !ok {
+				missingRoles = append(missingRoles, name)
+			} else if !selected[role] {
+				selected[role] = true
+				results = append(results, role)
+			}
+			continue
+		}
+
+		for _, role := range m.Roles {
+			if !selected[role] && selector.matches(role) {
+				selected[role] = true
+				results = append(results, role)
+			}
 		}
 	}
 	if len(missingRoles) > 0 {
@@ -325,13 +710,64 @@ func (m *RoleManifest) SelectRoles(roleNames []string) (Roles, error) {
 	return results, nil
 }
 
+// SelectGroups narrows the roles of the manifest down to those of the given
+// groups. All roles are kept if no groups are given.
+func (m *RoleManifest) SelectGroups(groups []string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, role := range m.Roles {
+		known[role.Group] = true
+	}
+
+	selected := make(map[string]bool, len(groups))
+	var missingGroups []string
+	for _, group := range groups {
+		if !known[group] {
+			missingGroups = append(missingGroups, group)
+		}
+		selected[group] = true
+	}
+	if len(missingGroups) > 0 {
+		return fmt.Errorf("Some role groups are unknown: %v", missingGroups)
+	}
+
+	var roles Roles
+	for _, role := range m.Roles {
+		if selected[role.Group] {
+			roles = append(roles, role)
+		} else {
+			delete(m.rolesByName, role.Name)
+		}
+	}
+	m.Roles = roles
+
+	return nil
+}
+
+// Groups returns the names of all role groups, sorted
+func (m *RoleManifest) Groups() []string {
+	known := make(map[string]bool)
+	var groups []string
+	for _, role := range m.Roles {
+		if role.Group != "" && !known[role.Group] {
+			known[role.Group] = true
+			groups = append(groups, role.Group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
 // GetScriptPaths returns the paths to the startup / post configgin scripts for a role
 func (r *Role) GetScriptPaths() map[string]string {
 	result := map[string]string{}
 
-	for _, scriptList := range [][]string{r.EnvironScripts, r.Scripts, r.PostConfigScripts} {
+	for _, scriptList := range [][]string{r.EnvironScripts, r.Scripts, r.PostConfigScripts, r.customDrainScripts()} {
 		for _, script := range scriptList {
-			if filepath.IsAbs(script) {
+			if path.IsAbs(script) {
 				// Absolute paths _inside_ the container; there is nothing to copy
 				continue
 			}
@@ -343,6 +779,72 @@ func (r *Role) GetScriptPaths() map[string]string {
 
 }
 
+// GetImageSnippetPaths returns the paths to the Dockerfile snippets spliced
+// into the image of a role, before and after its files are added
+func (r *Role) GetImageSnippetPaths() (pre, post []string) {
+	if r.Image == nil {
+		return nil, nil
+	}
+
+	resolve := func(snippets []string) []string {
+		var paths []string
+		for _, snippet := range snippets {
+			if !filepath.IsAbs(snippet) {
+				snippet = filepath.Join(filepath.Dir(r.rolesManifest.manifestFilePath), snippet)
+			}
+			paths = append(paths, snippet)
+		}
+		return paths
+	}
+	return resolve(r.Image.PreSnippets), resolve(r.Image.PostSnippets)
+}
+
+// customDrainScripts returns the custom scripts among the drain scripts of
+// a role
+func (r *Role) customDrainScripts() []string {
+	if r.Run == nil {
+		return nil
+	}
+
+	var scripts []string
+	for _, drainScript := range r.Run.DrainScripts {
+		if drainScript.Script != "" {
+			scripts = append(scripts, drainScript.Script)
+		}
+	}
+	return scripts
+}
+
+// GetDrainCommand returns the command draining a role inside its container,
+// or nil if the role has no drain scripts. The drain scripts of jobs are run
+// the way BOSH runs them when a job shuts down: a positive number printed by
+// the script is the time to wait afterwards, a negative one the time to wait
+// before asking the script again for the status of the drain. Custom scripts
+// are simply run. The command fails as soon as any script fails.
+func (r *Role) GetDrainCommand() []string {
+	if r.Run == nil || len(r.Run.DrainScripts) == 0 {
+		return nil
+	}
+
+	lines := []string{
+		"set -e",
+		`drain() { wait=$("$1" job_shutdown hash_unchanged); ` +
+			`while [ "${wait:-0}" -lt 0 ]; do sleep $((-wait)); wait=$("$1" job_check_status hash_unchanged); done; ` +
+			`sleep "${wait:-0}"; }`,
+	}
+	for _, drainScript := range r.Run.DrainScripts {
+		if drainScript.Job != "" {
+			lines = append(lines, fmt.Sprintf("drain %s", path.Join(renderedJobsDir, drainScript.Job, jobDrainScript)))
+		} else if path.IsAbs(drainScript.Script) {
+			lines = append(lines, fmt.Sprintf("bash %s", drainScript.Script))
+		} else {
+			lines = append(lines, fmt.Sprintf("bash %s", path.Join(roleScriptsDir, drainScript.Script)))
+		}
+	}
+
+	return []string{"/bin/bash", "-c", strings.Join(lines, "\n")}
+}
+
 // GetScriptSignatures returns the SHA1 of all of the script file names and contents
 func (r *Role) GetScriptSignatures() (string, error) {
 	hasher := sha1.New()
@@ -421,6 +923,80 @@ func (r *Role) GetRoleDevVersion() (string, error) {
 	}
 	roleSignature = fmt.Sprintf("%s\n%s", roleSignature, sig)
 
+	if r.BaseImage != "" {
+		roleSignature = fmt.Sprintf("%s\nbase-image:%s", roleSignature, r.BaseImage)
+	}
+
+	// The Dockerfile snippets are spliced into the image
+	pre, post := r.GetImageSnippetPaths()
+	for _, snippets := range []struct {
+		key   string
+		paths []string
+	}{{"pre-snippet", pre}, {"post-snippet", post}} {
+		for _, snippetPath := range snippets.paths {
+			contents, err := ioutil.ReadFile(snippetPath)
+			if err != nil {
+				return "", err
+			}
+			roleSignature = fmt.Sprintf("%s\n%s:%x", roleSignature, snippets.key, sha1.Sum(contents))
+		}
+	}
+
+	// The health checks are built into the image
+	if r.Run != nil && r.Run.HealthCheck != nil {
+		healthCheck, err := yaml.Marshal(r.Run.HealthCheck)
+		if err != nil {
+			return "", err
+		}
+		roleSignature = fmt.Sprintf("%s\n%s", roleSignature, healthCheck)
+	}
+
+	// So is the user the role runs as
+	if user := r.RunUser(); user != "" {
+		roleSignature = fmt.Sprintf("%s\nuser:%s", roleSignature, user)
+	}
+
+	// And its drift probe
+	if r.Run != nil && r.Run.DriftProbe != nil {
+		roleSignature = fmt.Sprintf("%s\ndrift-probe:%d", roleSignature, r.Run.DriftProbe.Interval)
+	}
+
+	// And whether it waits for other roles, with a script in its image
+	if r.Run != nil && len(r.Run.DependsOn) > 0 {
+		roleSignature = fmt.Sprintf("%s\ndepends-on", roleSignature)
+	}
+
+	// And its active/passive probe
+	if r.Run != nil && r.Run.ActivePassiveProbe != "" {
+		roleSignature = fmt.Sprintf("%s\nactive-passive-probe:%s", roleSignature, r.Run.ActivePassiveProbe)
+	}
+
+	// And the aliases of its jobs, naming their directories
+	for _, job := range r.Jobs {
+		if name := r.JobName(job); name != job.Name {
+			roleSignature = fmt.Sprintf("%s\nalias:%s:%s", roleSignature, job.Name, name)
+		}
+	}
+
+	// And the conditions of its jobs, checked by its run script
+	for _, job := range r.Jobs {
+		if variable := r.JobCondition(r.JobName(job)); variable != "" {
+			roleSignature = fmt.Sprintf("%s\ncondition:%s:%s", roleSignature, r.JobName(job), variable)
+		}
+	}
+
+	// And the values of job properties it overrides, written in the
+	// configuration of its jobs
+	for _, job := range r.Jobs {
+		if properties := r.JobProperties(r.JobName(job)); len(properties) > 0 {
+			contents, err := yaml.Marshal(properties)
+			if err != nil {
+				return "", err
+			}
+			roleSignature = fmt.Sprintf("%s\nproperties:%s:%s", roleSignature, r.JobName(job), contents)
+		}
+	}
+
 	// If there are templates, generate signature for them
 	if r.Configuration != nil && r.Configuration.Templates != nil {
 		sig, err = r.GetTemplateSignatures()
@@ -435,6 +1011,106 @@ func (r *Role) GetRoleDevVersion() (string, error) {
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
+// Dependencies returns the roles the role depends on, in the order given by
+// the role manifest
+func (r *Role) Dependencies() Roles {
+	var dependencies Roles
+	if r.Run == nil || r.rolesManifest == nil {
+		return dependencies
+	}
+	for _, name := range r.Run.DependsOn {
+		if dependency := r.rolesManifest.LookupRole(name); dependency != nil {
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	return dependencies
+}
+
+// IsActivePassive returns true if only one instance of the role is active at a
+// time, as told by its active/passive probe. Tasks never are.
+func (r *Role) IsActivePassive() bool {
+	return r.Type != RoleTypeBoshTask && r.Run != nil && r.Run.ActivePassiveProbe != ""
+}
+
+// IsStateful returns true if the role is clustered or has volumes; such roles
+// run as StatefulSets on Kubernetes, and the others as Deployments
+func (r *Role) IsStateful() bool {
+	if r.HasTag("clustered") {
+		return true
+	}
+	return r.Run != nil && (len(r.Run.PersistentVolumes) != 0 || len(r.Run.SharedVolumes) != 0)
+}
+
+// RunUser returns the user the role runs as, as uid[:gid], or an empty
+// string for roles running as root
+func (r *Role) RunUser() string {
+	if r.Run == nil || r.Run.User == nil {
+		return ""
+	}
+	if r.Run.Group == nil {
+		return strconv.Itoa(*r.Run.User)
+	}
+	return fmt.Sprintf("%d:%d", *r.Run.User, *r.Run.Group)
+}
+
+// InitialInstances returns the number of instances the role is deployed with,
+// its minimum scale unless the role manifest sets run.initial-instances
+func (r *Role) InitialInstances() int32 {
+	if r.Run == nil {
+		return 0
+	}
+	if r.Run.InitialInstances != nil {
+		return *r.Run.InitialInstances
+	}
+	if r.Run.Scaling == nil {
+		return 0
+	}
+	return r.Run.Scaling.Min
+}
+
+// JobName returns the name of a job of the role: its alias, when the role
+// gives it one, or its own name. Jobs are configured and installed under this
+// name, so that jobs of different releases sharing a name can be colocated.
+func (r *Role) JobName(job *Job) string {
+	for _, roleJob := range r.JobNameList {
+		if roleJob.job == job {
+			return roleJob.name()
+		}
+	}
+	return job.Name
+}
+
+// JobCondition returns the variable enabling the job of the role with the
+// given name, as returned by JobName, or an empty string if the job is always
+// enabled. Conditional jobs are neither configured nor started unless their
+// variable is true.
+func (r *Role) JobCondition(jobName string) string {
+	return r.jobConditions[jobName]
+}
+
+// JobProperties returns the values of the properties of the job of the role
+// with the given name which the role overrides, by property name. They take
+// precedence over the opinions.
+func (r *Role) JobProperties(jobName string) map[string]interface{} {
+	return r.jobProperties[jobName]
+}
+
+// IsScaledDown tests whether the role is deployed without any instances, to
+// be scaled up once it is enabled. Tasks run once and are never scaled down.
+func (r *Role) IsScaledDown() bool {
+	return r.Type != RoleTypeBoshTask && r.Run != nil &&
+		r.Run.InitialInstances != nil && *r.Run.InitialInstances == 0
+}
+
+// RoleManifestSHA1 returns the SHA1 of the role manifest file the role was
+// loaded from, or an empty string for roles without one
+func (r *Role) RoleManifestSHA1() string {
+	if r.rolesManifest == nil {
+		return ""
+	}
+	return r.rolesManifest.manifestSHA1
+}
+
 // HasTag returns true if the role has a specific tag
 func (r *Role) HasTag(tag string) bool {
 	for _, t := range r.Tags {
@@ -484,6 +1160,69 @@ func validateVariableSorting(variables ConfigurationVariableSlice) validation.Er
 	return allErrs
 }
 
+// validateSecretVariables reports the secret variables with a default in the
+// role manifest; their values would end up in images and plain text output
+func validateSecretVariables(variables ConfigurationVariableSlice) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, cv := range variables {
+		if cv.IsSecret() && cv.Default != nil {
+			allErrs = append(allErrs, validation.Forbidden(
+				fmt.Sprintf("configuration.variables[%s].default", cv.Name),
+				"Secret variables can't have a default in the role manifest, set their values in the defaults files"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateTemplateSyntax reports the templates which cannot be parsed, with
+// the parser error. Templates of roles are only reported when they are not
+// the global template of the same property. The other validations skip these
+// templates.
+func validateTemplateSyntax(roleManifest *RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	globalTemplates := roleManifest.Configuration.Templates
+	allErrs = append(allErrs, validateTemplatesSyntax(globalTemplates, nil, "configuration.templates")...)
+
+	for _, role := range roleManifest.Roles {
+		if role.Configuration == nil {
+			continue
+		}
+		allErrs = append(allErrs, validateTemplatesSyntax(role.Configuration.Templates, globalTemplates,
+			fmt.Sprintf("roles[%s].configuration.templates", role.Name))...)
+	}
+
+	return allErrs
+}
+
+// validateTemplatesSyntax reports the templates, by property, which cannot be
+// parsed, skipping those also in the inherited templates
+func validateTemplatesSyntax(templates, inherited map[string]string, field string) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	properties := make([]string, 0, len(templates))
+	for property := range templates {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+
+	for _, property := range properties {
+		template := templates[property]
+		if inheritedTemplate, ok := inherited[property]; ok && inheritedTemplate == template {
+			continue
+		}
+		if _, err := parseTemplate(template); err != nil {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("%s[%s]", field, property),
+				template, fmt.Sprintf("Cannot parse template: %s", err)))
+		}
+	}
+
+	return allErrs
+}
+
 // validateVariableUsage tests whether all parameters are used in a template or not.
 // It reports all variables which are not used by at least one template.
 //
@@ -529,16 +1268,36 @@ func validateVariableUsage(roleManifest *RoleManifest) validation.ErrorList {
 		}
 	}
 
-	// Iterate over the global templates, extract the used
-	// variables. Remove each found from the set of unused
-	// configs.
-
-	// Note, we have to ignore bad templates (no sensible variable
-	// references) and continue to check everything else.
+	// Variables passed to sidecars, those enabling jobs, and those of the
+	// health check templates are used as well.
 
-	for _, template := range roleManifest.Configuration.Templates {
-		varsInTemplate, err := parseTemplate(template)
-		if err != nil {
+	for _, role := range roleManifest.Roles {
+		for _, sidecar := range role.Sidecars {
+			for _, envVar := range sidecar.Env {
+				delete(unusedConfigs, envVar)
+			}
+		}
+		for _, envVar := range role.jobConditions {
+			delete(unusedConfigs, envVar)
+		}
+		for _, envVar := range role.healthCheckVariables() {
+			delete(unusedConfigs, envVar)
+		}
+	}
+	if len(unusedConfigs) == 0 {
+		return allErrs
+	}
+
+	// Iterate over the global templates, extract the used
+	// variables. Remove each found from the set of unused
+	// configs.
+
+	// Note, we have to ignore bad templates (no sensible variable
+	// references) and continue to check everything else.
+
+	for _, template := range roleManifest.Configuration.Templates {
+		varsInTemplate, err := parseTemplate(template)
+		if err != nil {
 			continue
 		}
 		for _, envVar := range varsInTemplate {
@@ -555,7 +1314,12 @@ func validateVariableUsage(roleManifest *RoleManifest) validation.ErrorList {
 	// We have only the unused variables left in the set. Report
 	// them.
 
+	unused := make([]string, 0, len(unusedConfigs))
 	for cv := range unusedConfigs {
+		unused = append(unused, cv)
+	}
+	sort.Strings(unused)
+	for _, cv := range unused {
 		allErrs = append(allErrs, validation.NotFound("configuration.variables",
 			fmt.Sprintf("No templates using '%s'", cv)))
 	}
@@ -635,6 +1399,82 @@ func validateTemplateUsage(roleManifest *RoleManifest) validation.ErrorList {
 	return allErrs
 }
 
+// roleGroupPattern matches valid role group names; they are used as label
+// values in the Kubernetes configuration
+var roleGroupPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// validateRoleGroup tests whether the group of a role is a valid name
+func validateRoleGroup(role *Role) validation.ErrorList {
+	if role.Group == "" || roleGroupPattern.MatchString(role.Group) {
+		return nil
+	}
+
+	return validation.ErrorList{validation.Invalid(
+		fmt.Sprintf("roles[%s].group", role.Name),
+		role.Group,
+		"Must consist of lower case letters, digits and dashes, starting and ending with a letter or digit")}
+}
+
+// validateBaseImage tests whether the base image of a role is a valid docker
+// image name
+func validateBaseImage(role *Role) validation.ErrorList {
+	if role.BaseImage == "" {
+		return nil
+	}
+
+	if _, err := reference.Parse(role.BaseImage); err != nil {
+		return validation.ErrorList{validation.Invalid(
+			fmt.Sprintf("roles[%s].base-image", role.Name),
+			role.BaseImage,
+			err.Error())}
+	}
+
+	return nil
+}
+
+// validateImageSnippets reports the Dockerfile snippets of a role which are
+// missing, or which start a new build stage, which would drop what fissile
+// adds to the image
+func validateImageSnippets(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	if role.Image == nil {
+		return allErrs
+	}
+
+	pre, post := role.GetImageSnippetPaths()
+	for _, snippets := range []struct {
+		key      string
+		snippets []string
+		paths    []string
+	}{
+		{"pre-snippets", role.Image.PreSnippets, pre},
+		{"post-snippets", role.Image.PostSnippets, post},
+	} {
+		field := fmt.Sprintf("roles[%s].image.%s", role.Name, snippets.key)
+		for i, snippetPath := range snippets.paths {
+			contents, err := ioutil.ReadFile(snippetPath)
+			if os.IsNotExist(err) {
+				allErrs = append(allErrs, validation.NotFound(field, snippets.snippets[i]))
+				continue
+			} else if err != nil {
+				allErrs = append(allErrs, validation.Invalid(field, snippets.snippets[i], err.Error()))
+				continue
+			}
+
+			for _, line := range strings.Split(string(contents), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) > 0 && strings.ToUpper(fields[0]) == "FROM" {
+					allErrs = append(allErrs, validation.Invalid(field, snippets.snippets[i],
+						"Snippets must not start a new build stage with FROM"))
+					break
+				}
+			}
+		}
+	}
+
+	return allErrs
+}
+
 // validateRoleRun tests whether required fields in the RoleRun are
 // set. Note, some of the fields have type-dependent checks. Some
 // issues are fixed silently.
@@ -647,11 +1487,18 @@ func validateRoleRun(role *Role, rolesManifest *RoleManifest, declared CVMap) va
 	}
 
 	allErrs = append(allErrs, normalizeFlightStage(role)...)
-	allErrs = append(allErrs, validateHealthCheck(role)...)
-	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(role.Run.Memory),
-		fmt.Sprintf("roles[%s].run.memory", role.Name))...)
-	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(role.Run.VirtualCPUs),
-		fmt.Sprintf("roles[%s].run.virtual-cpus", role.Name))...)
+	allErrs = append(allErrs, validateHealthCheck(role, declared)...)
+	allErrs = append(allErrs, normalizeResources(role, rolesManifest.Defaults)...)
+	allErrs = append(allErrs, validateScaling(role)...)
+	allErrs = append(allErrs, validateCanary(role)...)
+	allErrs = append(allErrs, validateProcessSettings(role)...)
+	allErrs = append(allErrs, normalizeComputeResources(role)...)
+	allErrs = append(allErrs, validateVolumes(role)...)
+	allErrs = append(allErrs, normalizeEphemeralVolumes(role)...)
+	allErrs = append(allErrs, validateRunUser(role)...)
+	allErrs = append(allErrs, validateInitialInstances(role)...)
+	allErrs = append(allErrs, normalizeDriftProbe(role)...)
+	allErrs = append(allErrs, normalizeIngress(role)...)
 
 	for i := range role.Run.ExposedPorts {
 		if role.Run.ExposedPorts[i].Name == "" {
@@ -676,15 +1523,7 @@ func validateRoleRun(role *Role, rolesManifest *RoleManifest, declared CVMap) va
 		// The environment variables used by docker roles must
 		// all be declared, report those which are not.
 
-		for _, envVar := range role.Run.Environment {
-			if _, ok := declared[envVar]; ok {
-				continue
-			}
-
-			allErrs = append(allErrs, validation.NotFound(
-				fmt.Sprintf("roles[%s].run.env", role.Name),
-				fmt.Sprintf("No variable declaration of '%s'", envVar)))
-		}
+		allErrs = append(allErrs, validateRoleEnvironment(role, declared)...)
 	} else {
 		// Bosh roles must not provide environment variables.
 
@@ -696,93 +1535,1176 @@ func validateRoleRun(role *Role, rolesManifest *RoleManifest, declared CVMap) va
 	return allErrs
 }
 
-// validateHealthCheck reports all roles with conflicting health
-// checks.
-func validateHealthCheck(role *Role) validation.ErrorList {
+// validateRoleScripts reports all scripts of a role which are missing,
+// not executable, or have DOS line endings. These would otherwise only
+// fail when the container boots. Absolute paths refer to files inside
+// the container and are not checked.
+func validateRoleScripts(role *Role) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	// Ensure that we don't have conflicting health checks
-	if role.Run.HealthCheck != nil {
-		checks := make([]string, 0, 3)
+	manifestDir := filepath.Dir(role.rolesManifest.manifestFilePath)
+	scriptLists := []struct {
+		key     string
+		scripts []string
+	}{
+		{"environment_scripts", role.EnvironScripts},
+		{"scripts", role.Scripts},
+		{"post_config_scripts", role.PostConfigScripts},
+		{"run.drain-script", role.customDrainScripts()},
+	}
 
-		if role.Run.HealthCheck.URL != "" {
-			checks = append(checks, "url")
+	for _, scriptList := range scriptLists {
+		for _, script := range scriptList.scripts {
+			if path.IsAbs(script) {
+				continue
+			}
+
+			field := fmt.Sprintf("roles[%s].%s", role.Name, scriptList.key)
+			scriptPath := filepath.Join(manifestDir, script)
+
+			info, err := os.Stat(scriptPath)
+			if err != nil {
+				if os.IsNotExist(err) {
+					allErrs = append(allErrs, validation.NotFound(field, script))
+				} else {
+					allErrs = append(allErrs, validation.Invalid(field, script, err.Error()))
+				}
+				continue
+			}
+
+			if info.IsDir() {
+				allErrs = append(allErrs, validation.Invalid(field, script, "Script is a directory"))
+				continue
+			}
+
+			if info.Mode()&0111 == 0 {
+				allErrs = append(allErrs, validation.Invalid(field, script, "Script is not executable"))
+			}
+
+			contents, err := ioutil.ReadFile(scriptPath)
+			if err != nil {
+				allErrs = append(allErrs, validation.Invalid(field, script, err.Error()))
+				continue
+			}
+
+			if bytes.Contains(contents, []byte("\r\n")) {
+				allErrs = append(allErrs, validation.Invalid(field, script, "Script has DOS (CRLF) line endings"))
+			}
 		}
-		if len(role.Run.HealthCheck.Command) > 0 {
-			checks = append(checks, "command")
+	}
+
+	return allErrs
+}
+
+// normalizeSidecars reports sidecars of a role lacking a name or image, or
+// referencing undeclared variables or unknown volumes. Volumes without a path
+// are mounted at the path they have in the role.
+func normalizeSidecars(role *Role, declared CVMap) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	volumePaths := map[string]string{}
+	if role.Run != nil {
+		for _, volume := range append(append([]*RoleRunVolume{}, role.Run.PersistentVolumes...), role.Run.SharedVolumes...) {
+			volumePaths[volume.Tag] = volume.Path
 		}
-		if role.Run.HealthCheck.Port != 0 {
-			checks = append(checks, "port")
+		for _, volume := range role.Run.EphemeralVolumes {
+			volumePaths[volume.Tag] = volume.Path
 		}
-		if len(checks) != 1 {
-			allErrs = append(allErrs, validation.Invalid(
-				fmt.Sprintf("roles[%s].run.healthcheck", role.Name),
-				checks, "Expected exactly one of url, command, or port"))
+	}
+
+	names := map[string]bool{role.Name: true}
+	for i, sidecar := range role.Sidecars {
+		field := fmt.Sprintf("roles[%s].sidecars[%d]", role.Name, i)
+		if sidecar.Name == "" {
+			allErrs = append(allErrs, validation.Required(field+".name", ""))
+		} else {
+			field = fmt.Sprintf("roles[%s].sidecars[%s]", role.Name, sidecar.Name)
+			if names[sidecar.Name] {
+				allErrs = append(allErrs, validation.Duplicate(field+".name", sidecar.Name))
+			}
+			names[sidecar.Name] = true
+		}
+
+		if sidecar.Image == "" {
+			allErrs = append(allErrs, validation.Required(field+".image", ""))
+		}
+
+		for _, envVar := range sidecar.Env {
+			if _, ok := declared[envVar]; !ok {
+				allErrs = append(allErrs, validation.NotFound(field+".env",
+					fmt.Sprintf("No variable declaration of '%s'", envVar)))
+			}
+		}
+
+		for _, volume := range sidecar.Volumes {
+			path, ok := volumePaths[volume.Tag]
+			if !ok {
+				allErrs = append(allErrs, validation.NotFound(field+".volumes",
+					fmt.Sprintf("No volume of the role tagged '%s'", volume.Tag)))
+				continue
+			}
+			if volume.Path == "" {
+				volume.Path = path
+			}
 		}
 	}
 
 	return allErrs
 }
 
-// normalizeFlightStage reports roles with a bad flightstage, and
-// fixes all roles without a flight stage to use the default
-// ('flight').
-func normalizeFlightStage(role *Role) validation.ErrorList {
+// validateRoleDependencies reports roles depending on unknown roles, on
+// themselves, on the same role twice, or on roles of the manual flight stage,
+// which may never run, and cycles of dependencies
+func validateRoleDependencies(rolesManifest *RoleManifest) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	// Normalize flight stage
-	switch role.Run.FlightStage {
-	case "":
-		role.Run.FlightStage = FlightStageFlight
-	case FlightStagePreFlight:
-	case FlightStageFlight:
-	case FlightStagePostFlight:
-	case FlightStageManual:
-	default:
-		allErrs = append(allErrs, validation.Invalid(
-			fmt.Sprintf("roles[%s].run.flight-stage", role.Name),
-			role.Run.FlightStage,
-			"Expected one of flight, manual, post-flight, or pre-flight"))
+	edges := make(map[string][]string)
+	for _, role := range rolesManifest.Roles {
+		if role.Run == nil {
+			continue
+		}
+		field := fmt.Sprintf("roles[%s].run.depends-on", role.Name)
+		seen := map[string]bool{}
+		for _, name := range role.Run.DependsOn {
+			dependency := rolesManifest.LookupRole(name)
+			switch {
+			case dependency == nil:
+				allErrs = append(allErrs, validation.NotFound(field, name))
+			case name == role.Name:
+				allErrs = append(allErrs, validation.Invalid(field, name, "A role can't depend on itself"))
+			case seen[name]:
+				allErrs = append(allErrs, validation.Duplicate(field, name))
+			case dependency.Run != nil && dependency.Run.FlightStage == FlightStageManual:
+				allErrs = append(allErrs, validation.Invalid(field, name,
+					"Roles of the manual flight stage may never run, nothing can depend on them"))
+			default:
+				edges[role.Name] = append(edges[role.Name], name)
+			}
+			seen[name] = true
+		}
+	}
+
+	// Depth first search for cycles; each is reported once, at the first
+	// role of it in the role manifest
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		path = append(path, name)
+		for _, next := range edges[name] {
+			switch state[next] {
+			case visiting:
+				var cycle []string
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == next {
+						cycle = append(append(cycle, path[i:]...), next)
+						break
+					}
+				}
+				allErrs = append(allErrs, validation.Invalid(
+					fmt.Sprintf("roles[%s].run.depends-on", name), next,
+					fmt.Sprintf("Roles can't depend on each other in a cycle: %s", strings.Join(cycle, " -> "))))
+			case unvisited:
+				visit(next)
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+	for _, role := range rolesManifest.Roles {
+		if state[role.Name] == unvisited {
+			visit(role.Name)
+		}
 	}
 
 	return allErrs
 }
 
-// validateNonTemplates tests whether the global templates are
-// constant or not. It reports the contant templates as errors (They
-// should be opinions).
-func validateNonTemplates(roleManifest *RoleManifest) validation.ErrorList {
+// validateActivePassiveProbe reports active/passive probes of tasks, which
+// run to completion and don't take traffic
+func validateActivePassiveProbe(role *Role) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	// Iterate over the global templates, extract the used
-	// variables. Report all templates not using any variable.
+	if role.Run == nil || role.Run.ActivePassiveProbe == "" {
+		return allErrs
+	}
 
-	for property, template := range roleManifest.Configuration.Templates {
-		varsInTemplate, err := parseTemplate(template)
-		if err != nil {
-			// Ignore bad template, cannot have sensible
-			// variable references
+	if role.Type == RoleTypeBoshTask {
+		allErrs = append(allErrs, validation.Forbidden(
+			fmt.Sprintf("roles[%s].run.active-passive-probe", role.Name),
+			"Tasks can't be active or passive"))
+	} else if strings.TrimSpace(role.Run.ActivePassiveProbe) == "" {
+		allErrs = append(allErrs, validation.Required(
+			fmt.Sprintf("roles[%s].run.active-passive-probe", role.Name), ""))
+	}
+
+	return allErrs
+}
+
+// jobAliasPattern matches the aliases of jobs, which name their directories
+var jobAliasPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// validateJobAliases checks the aliases of the jobs of a role, and that the
+// jobs have distinct names in the role: jobs of different releases sharing a
+// name need an alias. A job can't be part of a role twice.
+func validateJobAliases(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	names := make(map[string]bool, len(role.JobNameList))
+	jobs := make(map[*Job]bool, len(role.JobNameList))
+	for _, roleJob := range role.JobNameList {
+		if roleJob.job == nil {
+			// Reported already
 			continue
 		}
 
-		if len(varsInTemplate) == 0 {
-			allErrs = append(allErrs, validation.Invalid("configuration.templates",
-				template,
-				fmt.Sprintf("Using '%s' as a constant", property)))
+		field := fmt.Sprintf("roles[%s].jobs[%s]", role.Name, roleJob.name())
+		if roleJob.Alias != "" && !jobAliasPattern.MatchString(roleJob.Alias) {
+			allErrs = append(allErrs, validation.Invalid(field+".alias", roleJob.Alias,
+				"must consist of letters, digits, '-' and '_'"))
+			continue
+		}
+		if jobs[roleJob.job] {
+			allErrs = append(allErrs, validation.Duplicate(field,
+				fmt.Sprintf("%s/%s", roleJob.ReleaseName, roleJob.Name)))
+			continue
+		}
+		jobs[roleJob.job] = true
+		if names[roleJob.name()] {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				"Another job of the role has this name, give one of them an alias"))
+			continue
 		}
+		names[roleJob.name()] = true
 	}
 
 	return allErrs
 }
 
-// IsDevRole tests if the role is tagged for development, or not. It
-// returns true for development-roles, and false otherwise.
-func (r *Role) IsDevRole() bool {
-	for _, tag := range r.Tags {
-		switch tag {
-		case "dev-only":
-			return true
+// jobConditionPattern matches the conditions of jobs, a single variable
+var jobConditionPattern = regexp.MustCompile(`^\(\(\s*([^()\s]+)\s*\)\)$`)
+
+// validateJobConditions checks the conditions enabling jobs of a role, and
+// records their variables. The first job of a role which isn't a task
+// configures monit for the other jobs, so it can't be conditional.
+func validateJobConditions(role *Role, declared CVMap) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for i, roleJob := range role.JobNameList {
+		if roleJob.Condition == "" {
+			continue
 		}
+
+		field := fmt.Sprintf("roles[%s].jobs[%s].condition", role.Name, roleJob.name())
+		match := jobConditionPattern.FindStringSubmatch(strings.TrimSpace(roleJob.Condition))
+		if match == nil {
+			allErrs = append(allErrs, validation.Invalid(field, roleJob.Condition,
+				"must be a single variable, as in ((FEATURE_ENABLED))"))
+			continue
+		}
+		variable := match[1]
+
+		if _, ok := declared[variable]; !ok {
+			allErrs = append(allErrs, validation.NotFound(field,
+				fmt.Sprintf("No variable declaration of '%s'", variable)))
+			continue
+		}
+
+		if i == 0 && role.Type != RoleTypeBoshTask {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				"The first job of a role configures monit, and can't be conditional"))
+			continue
+		}
+
+		if role.jobConditions == nil {
+			role.jobConditions = make(map[string]string)
+		}
+		role.jobConditions[roleJob.name()] = variable
 	}
-	return false
+
+	return allErrs
+}
+
+// validateJobProperties checks the values of job properties a role
+// overrides, and records them by property name. They may be nested, as in
+// the opinions, or use the dotted names of the job specs; properties the job
+// doesn't have are rejected.
+func validateJobProperties(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, roleJob := range role.JobNameList {
+		if len(roleJob.Properties) == 0 {
+			continue
+		}
+		job := roleJob.job
+		if job == nil {
+			// Reported already
+			continue
+		}
+
+		field := fmt.Sprintf("roles[%s].jobs[%s].properties", role.Name, roleJob.name())
+		names := make(map[string]bool, len(job.Properties))
+		for _, property := range job.Properties {
+			names[property.Name] = true
+		}
+		hasNested := func(prefix string) bool {
+			for name := range names {
+				if strings.HasPrefix(name, prefix+".") {
+					return true
+				}
+			}
+			return false
+		}
+
+		properties := make(map[string]interface{})
+		var walk func(name string, value interface{})
+		walk = func(name string, value interface{}) {
+			if names[name] {
+				properties[name] = value
+				return
+			}
+			nested, isMap := value.(map[interface{}]interface{})
+			if !isMap || !hasNested(name) {
+				allErrs = append(allErrs, validation.NotFound(field, name))
+				return
+			}
+			keys := make([]string, 0, len(nested))
+			values := make(map[string]interface{}, len(nested))
+			for key, value := range nested {
+				keys = append(keys, fmt.Sprintf("%v", key))
+				values[fmt.Sprintf("%v", key)] = value
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				walk(name+"."+key, values[key])
+			}
+		}
+
+		keys := make([]string, 0, len(roleJob.Properties))
+		for key := range roleJob.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			walk(key, roleJob.Properties[key])
+		}
+
+		if role.jobProperties == nil {
+			role.jobProperties = make(map[string]map[string]interface{})
+		}
+		role.jobProperties[roleJob.name()] = properties
+	}
+
+	return allErrs
+}
+
+// validateDrainScripts reports drain scripts of a role which don't name
+// exactly one of a job or a script, or name a job which isn't part of the
+// role or has no drain script
+func validateDrainScripts(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if role.Run == nil {
+		return allErrs
+	}
+
+	field := fmt.Sprintf("roles[%s].run.drain-script", role.Name)
+	for _, drainScript := range role.Run.DrainScripts {
+		if drainScript.Job == "" && drainScript.Script == "" {
+			allErrs = append(allErrs, validation.Required(field, "Either a job or a script is required"))
+			continue
+		}
+		if drainScript.Job != "" && drainScript.Script != "" {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				fmt.Sprintf("Job %s can't be combined with script %s", drainScript.Job, drainScript.Script)))
+			continue
+		}
+		if drainScript.Job == "" {
+			continue
+		}
+
+		job := role.Jobs.lookup(drainScript.Job)
+		if job == nil {
+			allErrs = append(allErrs, validation.NotFound(field,
+				fmt.Sprintf("No job '%s' in the role", drainScript.Job)))
+			continue
+		}
+		if !job.HasDrainScript() {
+			allErrs = append(allErrs, validation.Invalid(field, drainScript.Job,
+				"Job has no drain script"))
+		}
+	}
+
+	return allErrs
+}
+
+// quantityPattern matches the quantities custom metric targets are given as,
+// a decimal number with an optional SI or binary suffix
+var quantityPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?([munkMGTPE]|[KMGTPE]i)?$`)
+
+// validateScaling reports bad scaling limits and autoscaling targets of a role
+func validateScaling(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	scaling := role.Run.Scaling
+	if scaling == nil {
+		return allErrs
+	}
+
+	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(scaling.Min),
+		fmt.Sprintf("roles[%s].run.scaling.min", role.Name))...)
+
+	if scaling.Max < scaling.Min {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.scaling.max", role.Name), scaling.Max,
+			"must be greater than or equal to run.scaling.min"))
+	}
+
+	if scaling.CPUTarget < 0 || scaling.CPUTarget > 100 {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.scaling.cpu-target", role.Name), scaling.CPUTarget,
+			"must be a percentage between 1 and 100"))
+	}
+
+	seen := make(map[string]bool, len(scaling.CustomMetrics))
+	for i, metric := range scaling.CustomMetrics {
+		if metric.Name == "" {
+			allErrs = append(allErrs, validation.Required(
+				fmt.Sprintf("roles[%s].run.scaling.custom-metrics[%d].name", role.Name, i), ""))
+			continue
+		}
+
+		field := fmt.Sprintf("roles[%s].run.scaling.custom-metrics[%s]", role.Name, metric.Name)
+		if seen[metric.Name] {
+			allErrs = append(allErrs, validation.Duplicate(field+".name", metric.Name))
+		}
+		seen[metric.Name] = true
+
+		if metric.Target == "" {
+			allErrs = append(allErrs, validation.Required(field+".target", ""))
+		} else if !quantityPattern.MatchString(metric.Target) {
+			allErrs = append(allErrs, validation.Invalid(field+".target", metric.Target,
+				"must be a quantity, such as 100 or 500m"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateCanary reports bad canary settings of a role
+func validateCanary(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	canary := role.Run.Canary
+	if canary == nil {
+		return allErrs
+	}
+
+	countField := fmt.Sprintf("roles[%s].run.canary.count", role.Name)
+	partitionField := fmt.Sprintf("roles[%s].run.canary.partition", role.Name)
+
+	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(canary.Count), countField)...)
+	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(canary.Partition), partitionField)...)
+
+	// Canary instances are a separate Deployment, while partitions are part of
+	// the update strategy of a StatefulSet; neither applies to the other kind
+	if role.IsStateful() {
+		if canary.Count != 0 {
+			allErrs = append(allErrs, validation.Forbidden(countField,
+				"Clustered roles and roles with volumes run as StatefulSets, use partition instead"))
+		}
+	} else if canary.Partition != 0 {
+		allErrs = append(allErrs, validation.Forbidden(partitionField,
+			"Only clustered roles and roles with volumes run as StatefulSets, use count instead"))
+	}
+
+	if role.Run.Scaling == nil {
+		return allErrs
+	}
+
+	if canary.Count > role.Run.Scaling.Min {
+		allErrs = append(allErrs, validation.Invalid(countField, canary.Count,
+			"must be less than or equal to run.scaling.min"))
+	}
+
+	if canary.Partition > role.Run.Scaling.Max {
+		allErrs = append(allErrs, validation.Invalid(partitionField, canary.Partition,
+			"must be less than or equal to run.scaling.max"))
+	}
+
+	return allErrs
+}
+
+// normalizeComputeResources turns the deprecated virtual-cpus of a role into
+// its CPU request, and reports bad memory and CPU settings
+func normalizeComputeResources(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	run := role.Run
+
+	if run.VirtualCPUs != 0 {
+		if run.CPU != (RoleRunCPU{}) {
+			allErrs = append(allErrs, validation.Forbidden(
+				fmt.Sprintf("roles[%s].run.virtual-cpus", role.Name),
+				"Use only one of virtual-cpus and cpu"))
+		} else if run.VirtualCPUs < 0 {
+			allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(run.VirtualCPUs),
+				fmt.Sprintf("roles[%s].run.virtual-cpus", role.Name))...)
+		} else {
+			run.CPU.Request = float64(run.VirtualCPUs)
+		}
+	}
+
+	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(run.Memory.Request),
+		fmt.Sprintf("roles[%s].run.memory.request", role.Name))...)
+	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(run.Memory.Limit),
+		fmt.Sprintf("roles[%s].run.memory.limit", role.Name))...)
+	if run.Memory.Limit > 0 && run.Memory.Limit < run.Memory.Request {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.memory.limit", role.Name),
+			run.Memory.Limit, "must be greater than or equal to the request"))
+	}
+
+	if run.CPU.Request < 0 {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.cpu.request", role.Name),
+			run.CPU.Request, "must be greater than or equal to 0"))
+	}
+	if run.CPU.Limit < 0 {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.cpu.limit", role.Name),
+			run.CPU.Limit, "must be greater than or equal to 0"))
+	}
+	if run.CPU.Limit > 0 && run.CPU.Limit < run.CPU.Request {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.cpu.limit", role.Name),
+			run.CPU.Limit, "must be greater than or equal to the request"))
+	}
+
+	return allErrs
+}
+
+// normalizeResources merges the manifest-wide default resources into
+// the resources of the role, and reports bad settings. Settings of the
+// role take precedence over the defaults.
+func normalizeResources(role *Role, defaults *RoleManifestDefaults) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if defaults != nil && defaults.Resources != nil {
+		if role.Run.Resources == nil {
+			role.Run.Resources = &RoleRunResources{}
+		}
+		resources := role.Run.Resources
+
+		if resources.ShmSize == 0 {
+			resources.ShmSize = defaults.Resources.ShmSize
+		}
+		if resources.MemorySwap == 0 {
+			resources.MemorySwap = defaults.Resources.MemorySwap
+		}
+		for _, ulimit := range defaults.Resources.Ulimits {
+			found := false
+			for _, roleUlimit := range resources.Ulimits {
+				if roleUlimit.Name == ulimit.Name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				resources.Ulimits = append(resources.Ulimits, ulimit)
+			}
+		}
+	}
+
+	resources := role.Run.Resources
+	if resources == nil {
+		return allErrs
+	}
+
+	allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(resources.ShmSize),
+		fmt.Sprintf("roles[%s].run.resources.shm-size", role.Name))...)
+
+	if resources.MemorySwap < -1 {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.resources.memory-swap", role.Name),
+			resources.MemorySwap, "must be -1 (unlimited), or greater than or equal to 0"))
+	} else if resources.MemorySwap > 0 && resources.MemorySwap < role.Run.Memory.DockerLimit() {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.resources.memory-swap", role.Name),
+			resources.MemorySwap, "must be greater than or equal to the memory limit"))
+	}
+
+	seen := map[string]bool{}
+	for _, ulimit := range resources.Ulimits {
+		field := fmt.Sprintf("roles[%s].run.resources.ulimits[%s]", role.Name, ulimit.Name)
+
+		valid := false
+		for _, name := range validUlimits {
+			if ulimit.Name == name {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			allErrs = append(allErrs, validation.NotSupported(field, ulimit.Name, validUlimits))
+			continue
+		}
+
+		if seen[ulimit.Name] {
+			allErrs = append(allErrs, validation.Duplicate(field, ulimit.Name))
+			continue
+		}
+		seen[ulimit.Name] = true
+
+		if ulimit.Soft > ulimit.Hard {
+			allErrs = append(allErrs, validation.Invalid(field+".soft",
+				ulimit.Soft, "must be less than or equal to the hard limit"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateHealthCheck reports all roles with conflicting health
+// checks, health check timings out of range, or health check templates
+// which can't be parsed or use undeclared variables
+func validateHealthCheck(role *Role, declared CVMap) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if role.Run.HealthCheck == nil {
+		return allErrs
+	}
+
+	kinds, probes := role.Run.HealthCheck.Probes()
+	for index, probe := range probes {
+		field := fmt.Sprintf("roles[%s].run.healthcheck.%s", role.Name, kinds[index])
+
+		// Ensure that we don't have conflicting health checks
+		checks := make([]string, 0, 3)
+		if probe.URL != "" {
+			checks = append(checks, "url")
+		}
+		if len(probe.Command) > 0 {
+			checks = append(checks, "command")
+		}
+		if probe.Port != 0 {
+			checks = append(checks, "port")
+		}
+		if len(checks) != 1 {
+			allErrs = append(allErrs, validation.Invalid(
+				field, checks, "Expected exactly one of url, command, or port"))
+		}
+
+		// The templates are rendered when the container starts, from the
+		// configuration variables
+		templates := probe.Templates()
+		templateFields := make([]string, 0, len(templates))
+		for templateField := range templates {
+			templateFields = append(templateFields, templateField)
+		}
+		sort.Strings(templateFields)
+		for _, templateField := range templateFields {
+			template := templates[templateField]
+			varsInTemplate, err := parseTemplate(template)
+			if err != nil {
+				allErrs = append(allErrs, validation.Invalid(
+					fmt.Sprintf("%s.%s", field, templateField),
+					template, fmt.Sprintf("Cannot parse template: %s", err)))
+				continue
+			}
+			for _, envVar := range varsInTemplate {
+				if _, ok := declared[envVar]; !ok {
+					allErrs = append(allErrs, validation.NotFound(
+						fmt.Sprintf("%s.%s", field, templateField),
+						fmt.Sprintf("No variable declaration of '%s'", envVar)))
+				}
+			}
+		}
+
+		timings := []struct {
+			name  string
+			value int32
+		}{
+			{"initial_delay", probe.InitialDelay},
+			{"period", probe.Period},
+			{"timeout", probe.Timeout},
+			{"failure_threshold", probe.FailureThreshold},
+		}
+		for _, timing := range timings {
+			if timing.value < 0 {
+				allErrs = append(allErrs, validation.Invalid(
+					fmt.Sprintf("%s.%s", field, timing.name), timing.value, "Must not be negative"))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// normalizeFlightStage reports roles with a bad flightstage, and
+// fixes all roles without a flight stage to use the default
+// ('flight').
+func normalizeFlightStage(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	// Normalize flight stage
+	switch role.Run.FlightStage {
+	case "":
+		role.Run.FlightStage = FlightStageFlight
+	case FlightStagePreFlight:
+	case FlightStageFlight:
+	case FlightStagePostFlight:
+	case FlightStageManual:
+	default:
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.flight-stage", role.Name),
+			role.Run.FlightStage,
+			"Expected one of flight, manual, post-flight, or pre-flight"))
+	}
+
+	return allErrs
+}
+
+// validateProcessSettings reports bad OOM score adjustments and restart
+// policies. Tasks cannot restart always, as they run to completion.
+func validateProcessSettings(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if adj := role.Run.OOMScoreAdj; adj != nil && (*adj < -1000 || *adj > 1000) {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.oom-score-adj", role.Name),
+			*adj, "must be between -1000 and 1000, inclusive"))
+	}
+
+	restart := role.Run.Restart
+	if restart == nil {
+		return allErrs
+	}
+
+	switch restart.Policy {
+	case RestartPolicyAlways:
+		if role.Type == RoleTypeBoshTask {
+			allErrs = append(allErrs, validation.Forbidden(
+				fmt.Sprintf("roles[%s].run.restart.policy", role.Name),
+				"Tasks cannot restart always"))
+		}
+		if restart.MaxRetries != 0 {
+			allErrs = append(allErrs, validation.Forbidden(
+				fmt.Sprintf("roles[%s].run.restart.max-retries", role.Name),
+				"Only applies to the on-failure policy"))
+		}
+	case RestartPolicyOnFailure:
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(restart.MaxRetries),
+			fmt.Sprintf("roles[%s].run.restart.max-retries", role.Name))...)
+	default:
+		allErrs = append(allErrs, validation.NotSupported(
+			fmt.Sprintf("roles[%s].run.restart.policy", role.Name),
+			restart.Policy, []string{string(RestartPolicyAlways), string(RestartPolicyOnFailure)}))
+	}
+
+	return allErrs
+}
+
+// storageClassPattern matches the names of kubernetes storage classes
+var storageClassPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// validateVolumes reports persistent and shared volumes lacking a tag or an
+// absolute path, without a positive size to claim, or with an invalid storage
+// class, and tags used by several volumes of a role
+func validateVolumes(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	tags := map[string]bool{}
+	kinds := []struct {
+		name    string
+		volumes []*RoleRunVolume
+	}{
+		{"persistent-volumes", role.Run.PersistentVolumes},
+		{"shared-volumes", role.Run.SharedVolumes},
+	}
+	for _, kind := range kinds {
+		for i, volume := range kind.volumes {
+			field := fmt.Sprintf("roles[%s].run.%s[%d]", role.Name, kind.name, i)
+			if volume.Tag == "" {
+				allErrs = append(allErrs, validation.Required(field+".tag", ""))
+			} else {
+				field = fmt.Sprintf("roles[%s].run.%s[%s]", role.Name, kind.name, volume.Tag)
+				if tags[volume.Tag] {
+					allErrs = append(allErrs, validation.Duplicate(field+".tag", volume.Tag))
+				}
+				tags[volume.Tag] = true
+			}
+
+			if volume.Path == "" {
+				allErrs = append(allErrs, validation.Required(field+".path", ""))
+			} else if !path.IsAbs(volume.Path) {
+				allErrs = append(allErrs, validation.Invalid(field+".path", volume.Path, "must be an absolute path"))
+			}
+
+			if volume.Size <= 0 {
+				allErrs = append(allErrs, validation.Invalid(field+".size", volume.Size, "must be greater than zero"))
+			}
+
+			if volume.StorageClass != "" && !storageClassPattern.MatchString(volume.StorageClass) {
+				allErrs = append(allErrs, validation.Invalid(field+".storage-class", volume.StorageClass,
+					"must consist of lower case alphanumeric characters, '-' or '.'"))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// normalizeEphemeralVolumes reports ephemeral volumes lacking a tag or an
+// absolute path, with a negative size or an unknown medium, and tags used by
+// several volumes of a role. Volumes without a medium are backed by disk.
+func normalizeEphemeralVolumes(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	tags := map[string]bool{}
+	for _, volume := range append(append([]*RoleRunVolume{}, role.Run.PersistentVolumes...), role.Run.SharedVolumes...) {
+		tags[volume.Tag] = true
+	}
+
+	for i, volume := range role.Run.EphemeralVolumes {
+		field := fmt.Sprintf("roles[%s].run.ephemeral-volumes[%d]", role.Name, i)
+		if volume.Tag == "" {
+			allErrs = append(allErrs, validation.Required(field+".tag", ""))
+		} else {
+			field = fmt.Sprintf("roles[%s].run.ephemeral-volumes[%s]", role.Name, volume.Tag)
+			if tags[volume.Tag] {
+				allErrs = append(allErrs, validation.Duplicate(field+".tag", volume.Tag))
+			}
+			tags[volume.Tag] = true
+		}
+
+		if volume.Path == "" {
+			allErrs = append(allErrs, validation.Required(field+".path", ""))
+		} else if !path.IsAbs(volume.Path) {
+			allErrs = append(allErrs, validation.Invalid(field+".path", volume.Path, "must be an absolute path"))
+		}
+
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(volume.Size), field+".size")...)
+
+		switch volume.Medium {
+		case "":
+			volume.Medium = VolumeMediumDisk
+		case VolumeMediumDisk, VolumeMediumMemory:
+		default:
+			allErrs = append(allErrs, validation.NotSupported(field+".medium",
+				volume.Medium, []string{string(VolumeMediumDisk), string(VolumeMediumMemory)}))
+		}
+	}
+
+	return allErrs
+}
+
+// validateRunUser reports negative user and group IDs, and a group given
+// without a user, which would leave the role running as root
+func validateRunUser(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if role.Run.User != nil {
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(*role.Run.User),
+			fmt.Sprintf("roles[%s].run.user", role.Name))...)
+	}
+	if role.Run.Group != nil {
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(*role.Run.Group),
+			fmt.Sprintf("roles[%s].run.group", role.Name))...)
+		if role.Run.User == nil {
+			allErrs = append(allErrs, validation.Required(
+				fmt.Sprintf("roles[%s].run.user", role.Name), "needed for run.group"))
+		}
+	}
+
+	return allErrs
+}
+
+// normalizeDriftProbe checks the interval of the drift probe of a role,
+// defaulting it. The probe is run by cron, which only runs for roles running
+// as root.
+func normalizeDriftProbe(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	probe := role.Run.DriftProbe
+	if probe == nil {
+		return allErrs
+	}
+
+	if probe.Interval == 0 {
+		probe.Interval = DefaultDriftProbeInterval
+	}
+	if probe.Interval < 1 || probe.Interval > 59 {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].run.drift-probe.interval", role.Name), probe.Interval,
+			"must be between 1 and 59 minutes"))
+	}
+
+	if role.Run.User != nil {
+		allErrs = append(allErrs, validation.Forbidden(
+			fmt.Sprintf("roles[%s].run.drift-probe", role.Name),
+			"The drift probe is run by cron, which needs the role to run as root"))
+	}
+
+	return allErrs
+}
+
+// normalizeIngress checks the Ingress of a role, defaulting its path, and
+// its port if the role exposes only one
+func normalizeIngress(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	ingress := role.Run.Ingress
+	if ingress == nil {
+		return allErrs
+	}
+
+	field := fmt.Sprintf("roles[%s].run.ingress", role.Name)
+
+	if ingress.Host == "" {
+		allErrs = append(allErrs, validation.Required(field+".host", ""))
+	}
+
+	if ingress.Path == "" {
+		ingress.Path = "/"
+	} else if !strings.HasPrefix(ingress.Path, "/") {
+		allErrs = append(allErrs, validation.Invalid(field+".path", ingress.Path, "must start with /"))
+	}
+
+	if ingress.TLS != nil && ingress.TLS.SecretName == "" {
+		allErrs = append(allErrs, validation.Required(field+".tls.secret-name", ""))
+	}
+
+	if ingress.Port == "" {
+		if len(role.Run.ExposedPorts) != 1 {
+			return append(allErrs, validation.Required(field+".port",
+				"the role doesn't expose exactly one port"))
+		}
+		ingress.Port = role.Run.ExposedPorts[0].Name
+	}
+
+	var port *RoleRunExposedPort
+	for _, exposedPort := range role.Run.ExposedPorts {
+		if exposedPort.Name == ingress.Port {
+			port = exposedPort
+		}
+	}
+	if port == nil {
+		return append(allErrs, validation.NotFound(field+".port",
+			fmt.Sprintf("No exposed port named '%s'", ingress.Port)))
+	}
+	if strings.Contains(port.External, "-") {
+		allErrs = append(allErrs, validation.Invalid(field+".port", ingress.Port,
+			"must be a single port, not a range"))
+	}
+	if port.Protocol != "" && strings.ToUpper(port.Protocol) != "TCP" {
+		allErrs = append(allErrs, validation.Invalid(field+".port", ingress.Port,
+			"must be a TCP port"))
+	}
+
+	return allErrs
+}
+
+// validateInitialInstances checks the instances a role is deployed with.
+// Scaling applies to the role once it is enabled, so a role can be deployed
+// scaled down to zero instances even if scaling.min is higher; otherwise the
+// instances have to be within the scaling range.
+func validateInitialInstances(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if role.Run.InitialInstances == nil {
+		return allErrs
+	}
+
+	field := fmt.Sprintf("roles[%s].run.initial-instances", role.Name)
+	instances := *role.Run.InitialInstances
+
+	if instances < 0 {
+		return append(allErrs, validation.ValidateNonnegativeField(int64(instances), field)...)
+	}
+
+	if instances == 0 {
+		if role.Run.Canary != nil && role.Run.Canary.Count > 0 {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				"roles scaled down to zero instances can't have canary instances"))
+		}
+		return allErrs
+	}
+
+	if role.Run.Scaling == nil {
+		return allErrs
+	}
+
+	if instances < role.Run.Scaling.Min || instances > role.Run.Scaling.Max {
+		allErrs = append(allErrs, validation.Invalid(field, instances,
+			fmt.Sprintf("must be 0, or between run.scaling.min (%d) and run.scaling.max (%d)",
+				role.Run.Scaling.Min, role.Run.Scaling.Max)))
+	}
+
+	return allErrs
+}
+
+// validateNonTemplates tests whether the global templates are
+// constant or not. It reports the contant templates as errors (They
+// should be opinions).
+func validateNonTemplates(roleManifest *RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	// Iterate over the global templates, extract the used
+	// variables. Report all templates not using any variable.
+
+	properties := make([]string, 0, len(roleManifest.Configuration.Templates))
+	for property := range roleManifest.Configuration.Templates {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+
+	for _, property := range properties {
+		template := roleManifest.Configuration.Templates[property]
+		varsInTemplate, err := parseTemplate(template)
+		if err != nil {
+			// Ignore bad template, cannot have sensible
+			// variable references
+			continue
+		}
+
+		if len(varsInTemplate) == 0 {
+			allErrs = append(allErrs, validation.Invalid("configuration.templates",
+				template,
+				fmt.Sprintf("Using '%s' as a constant", property)))
+		}
+	}
+
+	return allErrs
+}
+
+// IsDevRole tests if the role is tagged for development, or not. It
+// returns true for development-roles, and false otherwise.
+func (r *Role) IsDevRole() bool {
+	for _, tag := range r.Tags {
+		switch tag {
+		case "dev-only":
+			return true
+		}
+	}
+	return false
+}
+
+// validateRegistries tests whether the registry prefixes of all environments
+// are usable in image names
+func validateRegistries(roleManifest *RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	environments := make([]string, 0, len(roleManifest.Registries))
+	for environment := range roleManifest.Registries {
+		environments = append(environments, environment)
+	}
+	sort.Strings(environments)
+
+	for _, environment := range environments {
+		prefix := roleManifest.Registries[environment]
+		field := fmt.Sprintf("registries[%s]", environment)
+
+		if strings.TrimSuffix(prefix, "/") == "" {
+			allErrs = append(allErrs, validation.Required(field, ""))
+			continue
+		}
+
+		if strings.Contains(prefix, "://") {
+			allErrs = append(allErrs, validation.Invalid(field, prefix,
+				"Registry prefixes must not include a scheme"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateVariableGenerators tests whether the generators of all variables
+// can be run. Generator types are matched case-insensitively, and normalized.
+func validateVariableGenerators(roleManifest *RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	groupTypes := map[string]string{}
+	for _, variable := range roleManifest.Configuration.Variables {
+		generator := variable.Generator
+		if generator == nil {
+			continue
+		}
+
+		field := fmt.Sprintf("configuration.variables[%s].generator", variable.Name)
+
+		generatorType, ok := generatorTypes[strings.ToLower(generator.Type)]
+		if !ok {
+			allErrs = append(allErrs, validation.NotSupported(field+".type", generator.Type, []string{
+				GeneratorTypePassword, GeneratorTypeSSH, GeneratorTypeCACertificate, GeneratorTypeCertificate,
+			}))
+			continue
+		}
+		generator.Type = generatorType
+
+		if groupType, ok := groupTypes[variable.GroupID()]; ok && groupType != generatorType {
+			allErrs = append(allErrs, validation.Invalid(field+".type", generator.Type,
+				fmt.Sprintf("Generator %s is also used with type %s", variable.GroupID(), groupType)))
+		}
+		groupTypes[variable.GroupID()] = generatorType
+
+		switch generatorType {
+		case GeneratorTypePassword:
+			if generator.Length < 0 {
+				allErrs = append(allErrs, validation.Invalid(field+".length", generator.Length,
+					"must be greater than or equal to 0"))
+			}
+			// Generated values are written to env files, which can't hold quotes
+			// and backslashes in every position
+			if strings.ContainsAny(generator.Charset, `"'\`) {
+				allErrs = append(allErrs, validation.Invalid(field+".charset", generator.Charset,
+					"must not contain quotes or backslashes"))
+			}
+			continue
+		case GeneratorTypeSSH:
+			switch generator.ValueType {
+			case ValueTypePrivateKey, ValueTypePublicKey, ValueTypeFingerprint:
+			default:
+				allErrs = append(allErrs, validation.NotSupported(field+".value_type", generator.ValueType, []string{
+					ValueTypePrivateKey, ValueTypePublicKey, ValueTypeFingerprint,
+				}))
+			}
+			continue
+		}
+
+		if generator.ValueType != ValueTypeCertificate && generator.ValueType != ValueTypePrivateKey {
+			allErrs = append(allErrs, validation.NotSupported(field+".value_type", generator.ValueType, []string{
+				ValueTypeCertificate, ValueTypePrivateKey,
+			}))
+		}
+
+		if generatorType == GeneratorTypeCACertificate {
+			continue
+		}
+
+		if generator.RoleName != "" && roleManifest.LookupRole(generator.RoleName) == nil {
+			allErrs = append(allErrs, validation.NotFound(field+".role_name", generator.RoleName))
+		}
+	}
+
+	// Check the CAs after all types are known
+	for _, variable := range roleManifest.Configuration.Variables {
+		generator := variable.Generator
+		if generator == nil || generator.Type != GeneratorTypeCertificate || generator.CA == "" {
+			continue
+		}
+
+		if groupTypes[generator.CA] != GeneratorTypeCACertificate {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("configuration.variables[%s].generator.ca", variable.Name),
+				generator.CA, "Not the ID of a CACertificate generator"))
+		}
+	}
+
+	return allErrs
 }
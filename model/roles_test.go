@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
 )
 
 func TestLoadRoleManifestOK(t *testing.T) {
@@ -66,6 +67,29 @@ func TestGetScriptPaths(t *testing.T) {
 	}
 }
 
+func TestLoadRoleManifestBadScripts(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/scripts-bad.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].environment_scripts: Not found: "scripts-bad/missing.sh"`,
+			`roles[myrole].scripts: Invalid value: "scripts-bad/not-executable.sh": Script is not executable`,
+			`roles[myrole].post_config_scripts: Invalid value: "scripts-bad/crlf.sh": Script has DOS (CRLF) line endings`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
 func TestLoadRoleManifestNotOKBadJobName(t *testing.T) {
 	assert := assert.New(t)
 
@@ -277,55 +301,6 @@ func TestGetTemplateSignatures(t *testing.T) {
 	assert.NotEqual(differentTemplateHash1, differentTemplateHash2, "template hash should be dependent on template contents")
 }
 
-func TestGetRoleManifestDevPackageVersion(t *testing.T) {
-	assert := assert.New(t)
-
-	refRole := &Role{
-		Name: "bbb",
-		Jobs: Jobs{
-			{
-				SHA1: "Role 2 Job 1",
-				Packages: Packages{
-					{Name: "aaa", SHA1: "Role 2 Job 1 Package 1"},
-					{Name: "bbb", SHA1: "Role 2 Job 1 Package 2"},
-				},
-			},
-			{
-				SHA1: "Role 2 Job 2",
-				Packages: Packages{
-					{Name: "ccc", SHA1: "Role 2 Job 2 Package 1"},
-				},
-			},
-		},
-	}
-	wrongJobOrder := &Role{
-		Name: refRole.Name,
-		Jobs: Jobs{refRole.Jobs[1], refRole.Jobs[0]},
-	}
-	altRole := &Role{
-		Name: "aaa",
-		Jobs: Jobs{
-			{
-				SHA1: "Role 1 Job 1",
-				Packages: Packages{
-					{Name: "zzz", SHA1: "Role 1	 Job 1 Package 1"},
-				},
-			},
-		},
-	}
-
-	firstManifest := &RoleManifest{Roles: Roles{refRole, altRole}}
-	firstHash, _ := firstManifest.GetRoleManifestDevPackageVersion(firstManifest.Roles, "")
-	secondManifest := &RoleManifest{Roles: Roles{altRole, refRole}}
-	secondHash, _ := secondManifest.GetRoleManifestDevPackageVersion(secondManifest.Roles, "")
-	assert.Equal(firstHash, secondHash, "role manifest hash should be independent of role order")
-	jobOrderManifest := &RoleManifest{Roles: Roles{wrongJobOrder, altRole}}
-	jobOrderHash, _ := jobOrderManifest.GetRoleManifestDevPackageVersion(jobOrderManifest.Roles, "")
-	assert.NotEqual(firstHash, jobOrderHash, "role manifest hash should be dependent on job order")
-	differentExtraHash, _ := firstManifest.GetRoleManifestDevPackageVersion(firstManifest.Roles, "some string")
-	assert.NotEqual(firstHash, differentExtraHash, "role manifest hash should be dependent on extra string")
-}
-
 func TestLoadRoleManifestVariablesSortedError(t *testing.T) {
 	assert := assert.New(t)
 
@@ -400,6 +375,28 @@ func TestLoadRoleManifestNonTemplates(t *testing.T) {
 	assert.Nil(rolesManifest)
 }
 
+func TestLoadRoleManifestBadTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/templates-bad.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`configuration.templates[properties.tor.hostname]: Invalid value: "((HOSTNAME": Cannot parse template: line 1: unmatched open tag`,
+			`roles[myrole].configuration.templates[properties.tor.private_key]: Invalid value: "((#PRIVATE_KEY))": Cannot parse template: line 1: Section PRIVATE_KEY has no closing tag`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
 func TestLoadRoleManifestRunEnvDocker(t *testing.T) {
 	assert := assert.New(t)
 
@@ -416,6 +413,39 @@ func TestLoadRoleManifestRunEnvDocker(t *testing.T) {
 	assert.Equal(err.Error(),
 		`roles[dockerrole].run.env: Not found: "No variable declaration of 'UNKNOWN'"`)
 	assert.Nil(rolesManifest)
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/docker-run-env-templates.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[dockerrole].run.env[3D]: Invalid value: "3D": Must consist of letters, digits and underscores, not starting with a digit`,
+			`roles[dockerrole].run.env[BROKEN]: Invalid value: "((#HOSTNAME))": Cannot parse template: line 1: Section HOSTNAME has no closing tag`,
+			`roles[dockerrole].run.env: Not found: "No variable declaration of 'HOST'"`,
+			`roles[dockerrole].run.env[PORT]: Forbidden: A configuration variable has this name; it can only be passed as it is, as ((PORT))`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestRoleRunEnvironment(t *testing.T) {
+	assert := assert.New(t)
+
+	var run RoleRun
+	if assert.NoError(yaml.Unmarshal([]byte("env: [HOSTNAME, PORT]"), &run)) {
+		assert.Equal(RoleRunEnvironment{"HOSTNAME": "((HOSTNAME))", "PORT": "((PORT))"}, run.Environment,
+			"Listed variables should be passed as they are")
+		assert.Equal([]string{"HOSTNAME", "PORT"}, run.Environment.Names())
+		assert.False(run.Environment.IsComputed("PORT"))
+	}
+
+	run = RoleRun{}
+	if assert.NoError(yaml.Unmarshal([]byte("env: {URL: 'http://((HOSTNAME)):((PORT))/', RETRIES: 3}"), &run)) {
+		assert.Equal(RoleRunEnvironment{"URL": "http://((HOSTNAME)):((PORT))/", "RETRIES": "3"}, run.Environment)
+		assert.Equal([]string{"RETRIES", "URL"}, run.Environment.Names())
+		assert.True(run.Environment.IsComputed("URL"))
+	}
+
+	assert.Error(yaml.Unmarshal([]byte("env: HOSTNAME"), &run))
 }
 
 func TestLoadRoleManifestRunGeneral(t *testing.T) {
@@ -457,7 +487,8 @@ func TestLoadRoleManifestRunGeneral(t *testing.T) {
 		},
 		{
 			"bosh-run-bad-memory.yml", []string{
-				`roles[myrole].run.memory: Invalid value: -10: must be greater than or equal to 0`,
+				`roles[myrole].run.memory.request: Invalid value: -10: must be greater than or equal to 0`,
+				`roles[myrole].run.cpu.request: Invalid value: -2: must be greater than or equal to 0`,
 			},
 		},
 		{
@@ -490,3 +521,1169 @@ func TestLoadRoleManifestRunGeneral(t *testing.T) {
 		assert.Nil(err)
 	}
 }
+
+func TestLoadRoleManifestResources(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/resources.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	if assert.NotNil(myrole.Run.Resources) {
+		assert.Equal(256, myrole.Run.Resources.ShmSize)
+		assert.Equal(-1, myrole.Run.Resources.MemorySwap)
+		assert.Equal([]*RoleRunUlimit{
+			{Name: "nofile", Soft: 65536, Hard: 65536},
+			{Name: "memlock", Soft: -1, Hard: -1},
+		}, myrole.Run.Resources.Ulimits)
+	}
+
+	foorole := rolesManifest.LookupRole("foorole")
+	if assert.NotNil(foorole.Run.Resources) {
+		assert.Equal(64, foorole.Run.Resources.ShmSize)
+		assert.Equal(-1, foorole.Run.Resources.MemorySwap)
+		assert.Equal([]*RoleRunUlimit{
+			{Name: "nofile", Soft: 1024, Hard: 4096},
+			{Name: "memlock", Soft: -1, Hard: -1},
+		}, foorole.Run.Resources.Ulimits)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/resources-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.resources.shm-size: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[myrole].run.resources.memory-swap: Invalid value: 256: must be greater than or equal to the memory limit`,
+			`roles[myrole].run.resources.ulimits[files]: Unsupported value: "files": supported values: core, cpu, data, fsize, locks, memlock, msgqueue, nice, nofile, nproc, rss, rtprio, rttime, sigpending, stack`,
+			`roles[myrole].run.resources.ulimits[nproc].soft: Invalid value: 100: must be less than or equal to the hard limit`,
+			`roles[myrole].run.resources.ulimits[nproc]: Duplicate value: "nproc"`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestComputeResources(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/compute-resources.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.Equal(RoleRunMemory{Request: 256, Limit: 512}, myrole.Run.Memory)
+	assert.Equal(512, myrole.Run.Memory.DockerLimit())
+	assert.Equal(RoleRunCPU{Request: 0.5, Limit: 2}, myrole.Run.CPU)
+
+	foorole := rolesManifest.LookupRole("foorole")
+	assert.Equal(RoleRunMemory{Request: 128}, foorole.Run.Memory)
+	assert.Equal(128, foorole.Run.Memory.DockerLimit())
+	assert.Equal(RoleRunCPU{Request: 2}, foorole.Run.CPU)
+
+	barrole := rolesManifest.LookupRole("barrole")
+	assert.Equal(RoleRunMemory{}, barrole.Run.Memory)
+	assert.Equal(RoleRunCPU{Request: 1.5}, barrole.Run.CPU)
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/compute-resources-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[foorole].run.virtual-cpus: Forbidden: Use only one of virtual-cpus and cpu`,
+			`roles[foorole].run.memory.limit: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[foorole].run.cpu.request: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[myrole].run.memory.limit: Invalid value: 256: must be greater than or equal to the request`,
+			`roles[myrole].run.cpu.limit: Invalid value: 0.5: must be greater than or equal to the request`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestProcessSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/process.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	if assert.NotNil(myrole.Run.OOMScoreAdj) {
+		assert.Equal(-500, *myrole.Run.OOMScoreAdj)
+	}
+	assert.Equal(&RoleRunRestart{Policy: RestartPolicyOnFailure, MaxRetries: 3}, myrole.Run.Restart)
+
+	foorole := rolesManifest.LookupRole("foorole")
+	assert.Nil(foorole.Run.Restart)
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/process-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[foorole].run.restart.max-retries: Forbidden: Only applies to the on-failure policy`,
+			`roles[myrole].run.oom-score-adj: Invalid value: -1001: must be between -1000 and 1000, inclusive`,
+			`roles[myrole].run.restart.policy: Unsupported value: "never": supported values: always, on-failure`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestEphemeralVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/ephemeral-volumes.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.Equal([]*RoleRunEphemeralVolume{
+		{Path: "/var/vcap/data/scratch", Tag: "scratch", Medium: VolumeMediumDisk},
+		{Path: "/var/vcap/data/cache", Tag: "cache", Size: 64, Medium: VolumeMediumMemory},
+	}, myrole.Run.EphemeralVolumes)
+	assert.False(myrole.IsStateful())
+	if assert.Len(myrole.Sidecars, 1) {
+		assert.Equal("/var/vcap/data/scratch", myrole.Sidecars[0].Volumes[0].Path)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/ephemeral-volumes-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.ephemeral-volumes[data].tag: Duplicate value: "data"`,
+			`roles[myrole].run.ephemeral-volumes[relative].path: Invalid value: "scratch": must be an absolute path`,
+			`roles[myrole].run.ephemeral-volumes[relative].size: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[myrole].run.ephemeral-volumes[pathless].path: Required value`,
+			`roles[myrole].run.ephemeral-volumes[pathless].medium: Unsupported value: "ssd": supported values: disk, memory`,
+			`roles[myrole].run.ephemeral-volumes[3].tag: Required value`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/volumes.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if assert.NoError(err) {
+		myrole := rolesManifest.LookupRole("myrole")
+		assert.Equal("", myrole.Run.PersistentVolumes[0].StorageClass)
+		assert.Equal(40, myrole.Run.SharedVolumes[0].Size)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/volumes-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.persistent-volumes[data].storage-class: Invalid value: "Fast_SSD": must consist of lower case alphanumeric characters, '-' or '.'`,
+			`roles[myrole].run.persistent-volumes[relative].path: Invalid value: "mnt/relative": must be an absolute path`,
+			`roles[myrole].run.persistent-volumes[relative].size: Invalid value: 0: must be greater than zero`,
+			`roles[myrole].run.shared-volumes[data].tag: Duplicate value: "data"`,
+			`roles[myrole].run.shared-volumes[1].tag: Required value`,
+			`roles[myrole].run.shared-volumes[1].path: Required value`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestRunUser(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/non-root.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.Equal("1000:100", myrole.RunUser())
+	version, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	myrole.Run.Group = nil
+	assert.Equal("1000", myrole.RunUser())
+	otherVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(version, otherVersion, "The user is built into the image")
+	myrole.Run.User = nil
+	assert.Empty(myrole.RunUser())
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/non-root-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[groupie].run.group: Invalid value: -5: must be greater than or equal to 0`,
+			`roles[groupie].run.user: Required value: needed for run.group`,
+			`roles[myrole].run.user: Invalid value: -1: must be greater than or equal to 0`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestInitialInstances(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/scaled-down.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.Equal(int32(1), myrole.InitialInstances())
+	assert.False(myrole.IsScaledDown())
+
+	optional := rolesManifest.LookupRole("optional")
+	assert.Equal(int32(0), optional.InitialInstances())
+	assert.True(optional.IsScaledDown())
+	optional.Type = RoleTypeBoshTask
+	assert.False(optional.IsScaledDown(), "Tasks are never scaled down")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/scaled-down-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[canary].run.initial-instances: Forbidden: roles scaled down to zero instances can't have canary instances`,
+			`roles[negative].run.initial-instances: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[myrole].run.initial-instances: Invalid value: 1: must be 0, or between run.scaling.min (2) and run.scaling.max (3)`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestCanary(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/canary.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(&RoleRunCanary{Partition: 2}, rolesManifest.LookupRole("myrole").Run.Canary)
+	assert.Equal(&RoleRunCanary{Count: 1}, rolesManifest.LookupRole("foorole").Run.Canary)
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/canary-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[barrole].run.canary.partition: Forbidden: Only clustered roles and roles with volumes run as StatefulSets, use count instead`,
+			`roles[barrole].run.canary.count: Invalid value: 4: must be less than or equal to run.scaling.min`,
+			`roles[foorole].run.canary.count: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[foorole].run.canary.partition: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[foorole].run.canary.partition: Forbidden: Only clustered roles and roles with volumes run as StatefulSets, use count instead`,
+			`roles[myrole].run.canary.count: Forbidden: Clustered roles and roles with volumes run as StatefulSets, use partition instead`,
+			`roles[myrole].run.canary.partition: Invalid value: 6: must be less than or equal to run.scaling.max`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestScaling(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/autoscaling.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(&RoleRunScaling{
+		Min:       1,
+		Max:       5,
+		CPUTarget: 60,
+		CustomMetrics: []*RoleRunCustomMetric{
+			{Name: "requests_per_second", Target: "500m"},
+		},
+	}, rolesManifest.LookupRole("myrole").Run.Scaling)
+	assert.Equal(&RoleRunScaling{Min: 2, Max: 2}, rolesManifest.LookupRole("foorole").Run.Scaling)
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/autoscaling-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[foorole].run.scaling.min: Invalid value: -1: must be greater than or equal to 0`,
+			`roles[foorole].run.scaling.custom-metrics[0].name: Required value`,
+			`roles[foorole].run.scaling.custom-metrics[queue_length].target: Invalid value: "lots": must be a quantity, such as 100 or 500m`,
+			`roles[foorole].run.scaling.custom-metrics[queue_length].name: Duplicate value: "queue_length"`,
+			`roles[foorole].run.scaling.custom-metrics[queue_length].target: Required value`,
+			`roles[myrole].run.scaling.max: Invalid value: 2: must be greater than or equal to run.scaling.min`,
+			`roles[myrole].run.scaling.cpu-target: Invalid value: 150: must be a percentage between 1 and 100`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestJobConditions(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/job-conditions.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.Equal("", myrole.JobCondition("new_hostname"))
+	assert.Equal("TOR_ENABLED", myrole.JobCondition("tor"))
+	assert.Equal("TOR_ENABLED", rolesManifest.LookupRole("foorole").JobCondition("tor"))
+
+	version, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	delete(myrole.jobConditions, "tor")
+	otherVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(version, otherVersion, "The conditions are built into the image")
+	myrole.jobConditions["tor"] = "TOR_ENABLED"
+
+	variables, err := myrole.GetVariablesForRole()
+	if assert.NoError(err) {
+		var names []string
+		for _, variable := range variables {
+			names = append(names, variable.Name)
+		}
+		assert.Equal([]string{"FOO", "TOR_ENABLED"}, names)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/job-conditions-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].jobs[new_hostname].condition: Forbidden: The first job of a role configures monit, and can't be conditional`,
+			`roles[myrole].jobs[tor].condition: Not found: "No variable declaration of 'MISSING_ENABLED'"`,
+			`roles[foorole].jobs[tor].condition: Invalid value: "((TOR_ENABLED)) ((FOO))": must be a single variable, as in ((FEATURE_ENABLED))`,
+			`configuration.variables: Not found: "No templates using 'TOR_ENABLED'"`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestJobProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/job-properties.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.Empty(myrole.JobProperties("new_hostname"))
+	assert.Equal(map[string]interface{}{
+		"tor.hostname":    "myrole.onion",
+		"tor.client_keys": []interface{}{"alice", "bob"},
+	}, myrole.JobProperties("tor"))
+	assert.Empty(rolesManifest.LookupRole("foorole").JobProperties("tor"))
+
+	version, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	myrole.jobProperties["tor"]["tor.hostname"] = "other.onion"
+	otherVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(version, otherVersion, "The properties are built into the image")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/job-properties-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].jobs[new_hostname].properties: Not found: "tor.hostname"`,
+			`roles[myrole].jobs[tor].properties: Not found: "bogus"`,
+			`roles[myrole].jobs[tor].properties: Not found: "tor.missing"`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestJobAliases(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+	// A fork of the release, with jobs of the same names
+	forkRelease, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+	forkRelease.Name = "tor-fork"
+	releases := []*Release{release, forkRelease}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/job-aliases.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, releases)
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	if !assert.Len(myrole.Jobs, 2) {
+		return
+	}
+	assert.Equal("tor", myrole.JobName(myrole.Jobs[0]))
+	assert.Equal("tor-fork", myrole.JobName(myrole.Jobs[1]))
+	assert.Equal("tor", myrole.Jobs[1].Name)
+	assert.Equal("", myrole.JobCondition("tor"))
+	assert.Equal("TOR_FORK_ENABLED", myrole.JobCondition("tor-fork"))
+	assert.Empty(myrole.JobProperties("tor"))
+	assert.Equal(map[string]interface{}{"tor.hostname": "fork.onion"}, myrole.JobProperties("tor-fork"))
+
+	version, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	myrole.JobNameList[1].Alias = "tor-other"
+	otherVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(version, otherVersion, "The aliases are built into the image")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/job-aliases-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, releases)
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].jobs[tor]: Forbidden: Another job of the role has this name, give one of them an alias`,
+			`roles[foorole].jobs[tor/fork].alias: Invalid value: "tor/fork": must consist of letters, digits, '-' and '_'`,
+			`roles[foorole].jobs[tor-again]: Duplicate value: "tor/tor"`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestDriftProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/drift-probe.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.Equal(&RoleRunDriftProbe{Interval: 10}, myrole.Run.DriftProbe)
+	assert.Equal(&RoleRunDriftProbe{Interval: DefaultDriftProbeInterval},
+		rolesManifest.LookupRole("foorole").Run.DriftProbe)
+
+	version, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	myrole.Run.DriftProbe = nil
+	otherVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(version, otherVersion, "The drift probe is built into the image")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/drift-probe-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[foorole].run.drift-probe.interval: Invalid value: -1: must be between 1 and 59 minutes`,
+			`roles[foorole].run.drift-probe: Forbidden: The drift probe is run by cron, which needs the role to run as root`,
+			`roles[myrole].run.drift-probe.interval: Invalid value: 60: must be between 1 and 59 minutes`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestActivePassive(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/active-passive.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	assert.True(myrole.IsActivePassive())
+	assert.Equal("/var/vcap/jobs/tor/bin/tor_ctl is-leader", myrole.Run.ActivePassiveProbe)
+
+	version, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	myrole.Run.ActivePassiveProbe = ""
+	assert.False(myrole.IsActivePassive())
+	otherVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(version, otherVersion, "The active/passive probe is built into the image")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/active-passive-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.active-passive-probe: Required value`,
+			`roles[mytask].run.active-passive-probe: Forbidden: Tasks can't be active or passive`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestDependsOn(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/depends-on.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	var names []string
+	for _, dependency := range myrole.Dependencies() {
+		names = append(names, dependency.Name)
+	}
+	assert.Equal([]string{"mytask", "otherrole", "idlerole"}, names)
+	assert.Empty(rolesManifest.LookupRole("mytask").Dependencies())
+
+	version, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	myrole.Run.DependsOn = nil
+	otherVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(version, otherVersion, "The script waiting for dependencies is built into the image")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/depends-on-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.depends-on: Invalid value: "myrole": A role can't depend on itself`,
+			`roles[myrole].run.depends-on: Not found: "unknown"`,
+			`roles[myrole].run.depends-on: Invalid value: "mytask": Roles of the manual flight stage may never run, nothing can depend on them`,
+			`roles[myrole].run.depends-on: Duplicate value: "otherrole"`,
+			`roles[thirdrole].run.depends-on: Invalid value: "myrole": Roles can't depend on each other in a cycle: myrole -> otherrole -> thirdrole -> myrole`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestSecretDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/secret-defaults.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`configuration.variables[CONTROL_PASSWORD].default: Forbidden: Secret variables can't have a default in the role manifest, set their values in the defaults files`,
+			`configuration.variables[PRIVATE_KEY].default: Forbidden: Secret variables can't have a default in the role manifest, set their values in the defaults files`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestIngress(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/ingress.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(&RoleRunIngress{
+		Host: "myrole.example.com",
+		Path: "/api",
+		Port: "http",
+		TLS:  &RoleRunIngressTLS{SecretName: "myrole-tls"},
+	}, rolesManifest.LookupRole("myrole").Run.Ingress)
+	assert.Equal(&RoleRunIngress{
+		Host: "foorole.example.com",
+		Path: "/",
+		Port: "http",
+	}, rolesManifest.LookupRole("foorole").Run.Ingress, "The path and the only port are the defaults")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/ingress-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[barrole].run.ingress.port: Not found: "No exposed port named 'missing'"`,
+			`roles[foorole].run.ingress.port: Invalid value: "range": must be a single port, not a range`,
+			`roles[myrole].run.ingress.host: Required value`,
+			`roles[myrole].run.ingress.path: Invalid value: "api": must start with /`,
+			`roles[myrole].run.ingress.tls.secret-name: Required value`,
+			`roles[myrole].run.ingress.port: Required value: the role doesn't expose exactly one port`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestRegistries(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/registries.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	prefix, err := rolesManifest.LookupRegistry("production")
+	assert.NoError(err)
+	assert.Equal("docker.example.com/cf", prefix)
+
+	prefix, err = rolesManifest.LookupRegistry("")
+	assert.NoError(err)
+	assert.Empty(prefix)
+
+	_, err = rolesManifest.LookupRegistry("qa")
+	assert.EqualError(err, "Unknown registry environment qa, expected one of: internal, production, staging")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/registries-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`registries[production]: Invalid value: "https://docker.example.com/cf": Registry prefixes must not include a scheme`,
+			`registries[staging]: Required value`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestSidecars(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/sidecars.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	role := rolesManifest.LookupRole("myrole")
+	if !assert.NotNil(role) || !assert.Len(role.Sidecars, 2) {
+		return
+	}
+	assert.Equal("/var/vcap/sys/log", role.Sidecars[0].Volumes[0].Path, "Volumes should default to the path in the role")
+	assert.Equal("/logs", role.Sidecars[1].Volumes[0].Path)
+
+	variables, err := role.GetVariablesForRole()
+	if assert.NoError(err) {
+		var names []string
+		for _, variable := range variables {
+			names = append(names, variable.Name)
+		}
+		assert.Equal([]string{"HOSTNAME", "PRIVATE_KEY", "SHIPPER_TOKEN"}, names)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/sidecars-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].sidecars[0].name: Required value`,
+			`roles[myrole].sidecars[myrole].name: Duplicate value: "myrole"`,
+			`roles[myrole].sidecars[myrole].env: Not found: "No variable declaration of 'SHIPPER_TOKEN'"`,
+			`roles[myrole].sidecars[myrole].volumes: Not found: "No volume of the role tagged 'logs'"`,
+			`roles[myrole].sidecars[metrics-exporter].image: Required value`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestDrainScripts(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/drain.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	role := rolesManifest.LookupRole("myrole")
+	if !assert.NotNil(role) {
+		return
+	}
+	assert.Contains(role.GetScriptPaths(), "drain.sh", "Custom drain scripts should be copied into the image")
+
+	command := role.GetDrainCommand()
+	if assert.Len(command, 3) {
+		assert.Equal([]string{"/bin/bash", "-c"}, command[:2])
+		lines := strings.Split(command[2], "\n")
+		assert.Equal([]string{"bash /opt/hcf/startup/drain.sh", "bash /usr/local/bin/evacuate"}, lines[len(lines)-2:])
+	}
+
+	assert.Nil(rolesManifest.LookupRole("quietrole").GetDrainCommand())
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/drain-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.drain-script: Not found: "missing-drain.sh"`,
+			`roles[myrole].run.drain-script: Invalid value: "tor": Job has no drain script`,
+			`roles[myrole].run.drain-script: Not found: "No job 'ntpd' in the role"`,
+			`roles[myrole].run.drain-script: Forbidden: Job tor can't be combined with script drain.sh`,
+			`roles[myrole].run.drain-script: Required value: Either a job or a script is required`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestGenerators(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/generators.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	variables := MakeMapOfVariables(rolesManifest)
+	assert.Equal(GeneratorTypeCACertificate, variables["INTERNAL_CA_KEY"].Generator.Type, "Generator types should be normalized")
+	assert.Equal("internal_ca", variables["INTERNAL_CA_KEY"].GroupID())
+	assert.Equal("PASSWORD", variables["PASSWORD"].GroupID())
+	assert.Equal([]string{"tor.((DOMAIN))", "10.0.0.1"}, variables["TOR_CERT"].Generator.SubjectNames)
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/generators-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`configuration.variables[CA_KEY].generator.type: Invalid value: "Certificate": Generator ca is also used with type CACertificate`,
+			`configuration.variables[PASSWORD].generator.type: Unsupported value: "Magic": supported values: Password, SSH, CACertificate, Certificate`,
+			`configuration.variables[SHORT_PASSWORD].generator.length: Invalid value: -1: must be greater than or equal to 0`,
+			`configuration.variables[SHORT_PASSWORD].generator.charset: Invalid value: "abc\"\\": must not contain quotes or backslashes`,
+			`configuration.variables[SSH_KEY].generator.value_type: Unsupported value: "certificate": supported values: private_key, public_key, fingerprint`,
+			`configuration.variables[TOR_CERT].generator.value_type: Unsupported value: "cert": supported values: certificate, private_key`,
+			`configuration.variables[TOR_CERT].generator.role_name: Not found: "notarole"`,
+			`configuration.variables[TOR_CERT].generator.ca: Invalid value: "PASSWORD": Not the ID of a CACertificate generator`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/groups.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal([]string{"control-plane", "data-plane"}, rolesManifest.Groups())
+
+	err = rolesManifest.SelectGroups([]string{"data-plane", "storage", "gui"})
+	assert.EqualError(err, "Some role groups are unknown: [storage gui]")
+	assert.Len(rolesManifest.Roles, 4, "Roles should be kept when selection fails")
+
+	if assert.NoError(rolesManifest.SelectGroups([]string{"data-plane"})) {
+		var names []string
+		for _, role := range rolesManifest.Roles {
+			names = append(names, role.Name)
+		}
+		assert.Equal([]string{"myrole", "otherrole"}, names)
+		assert.Nil(rolesManifest.LookupRole("controlrole"))
+
+		_, err = rolesManifest.SelectRoles([]string{"loose"})
+		assert.EqualError(err, "Some roles are unknown: [loose]")
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/groups-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	assert.EqualError(err, `roles[myrole].group: Invalid value: "Data_Plane": Must consist of lower case letters, digits and dashes, starting and ending with a letter or digit`)
+}
+
+func TestLoadRoleManifestBaseImage(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/base-image.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	otherrole := rolesManifest.LookupRole("otherrole")
+	assert.Equal("registry.example.com/stemcells/fissile-role-base-centos:1.0", myrole.BaseImage)
+	assert.Empty(otherrole.BaseImage)
+
+	myVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	otherVersion, err := otherrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(otherVersion, myVersion, "The base image should be part of the role version")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/base-image-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	assert.EqualError(err, `roles[myrole].base-image: Invalid value: "Stemcells/Centos": invalid reference format`)
+}
+
+func TestLoadRoleManifestImageSnippets(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/image-snippets.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	myrole := rolesManifest.LookupRole("myrole")
+	otherrole := rolesManifest.LookupRole("otherrole")
+	snippetsDir := filepath.Join(workDir, "../test-assets/role-manifests/snippets")
+	pre, post := myrole.GetImageSnippetPaths()
+	assert.Equal([]string{filepath.Join(snippetsDir, "packages.dockerfile")}, pre)
+	assert.Equal([]string{filepath.Join(snippetsDir, "locales.dockerfile")}, post)
+	pre, post = otherrole.GetImageSnippetPaths()
+	assert.Empty(pre)
+	assert.Empty(post)
+
+	myVersion, err := myrole.GetRoleDevVersion()
+	assert.NoError(err)
+	otherVersion, err := otherrole.GetRoleDevVersion()
+	assert.NoError(err)
+	assert.NotEqual(otherVersion, myVersion, "The snippets should be part of the role version")
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/image-snippets-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].image.pre-snippets: Not found: "snippets/missing.dockerfile"`,
+			`roles[myrole].image.post-snippets: Invalid value: "snippets/stage.dockerfile": Snippets must not start a new build stage with FROM`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/healthcheck.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	healthCheck := rolesManifest.LookupRole("myrole").Run.HealthCheck
+	assert.Equal(&HealthProbe{URL: "http://container-ip:8080/ready", Period: 5}, healthCheck.Readiness)
+	assert.Equal(&HealthProbe{
+		Command:          []string{"/opt/hcf/alive.sh"},
+		InitialDelay:     60,
+		Timeout:          3,
+		FailureThreshold: 5,
+	}, healthCheck.Liveness)
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/healthcheck-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.healthcheck.readiness: Invalid value: ["url","port"]: Expected exactly one of url, command, or port`,
+			`roles[myrole].run.healthcheck.liveness: Invalid value: []: Expected exactly one of url, command, or port`,
+			`roles[myrole].run.healthcheck.liveness.initial_delay: Invalid value: -1: Must not be negative`,
+			`roles[myrole].run.healthcheck.liveness.failure_threshold: Invalid value: -3: Must not be negative`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+
+	// The flat form of older role manifests is the readiness check
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/healthcheck-flat.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	if assert.NoError(err) {
+		assert.Equal(&HealthCheck{Readiness: &HealthProbe{
+			URL:     "http://container-ip:8080/ready",
+			Headers: map[string]string{"X-Probe": "kube"},
+		}}, rolesManifest.LookupRole("myrole").Run.HealthCheck)
+		assert.Equal(&HealthCheck{Readiness: &HealthProbe{Port: 9050}},
+			rolesManifest.LookupRole("otherrole").Run.HealthCheck)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/healthcheck-flat-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "move them under readiness: or liveness:")
+	}
+}
+
+func TestLoadRoleManifestHealthCheckTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/healthcheck-templates.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	role := rolesManifest.LookupRole("myrole")
+	healthCheck := role.Run.HealthCheck
+	assert.True(healthCheck.Readiness.IsTemplated())
+	assert.Equal(map[string]string{"url": "http://container-ip:((HEALTH_PORT))/ready"}, healthCheck.Readiness.Templates())
+	assert.Equal(map[string]string{"command[2]": "((HEALTH_TIMEOUT))"}, healthCheck.Liveness.Templates())
+	assert.Equal("/var/vcap/healthcheck/liveness.sh", HealthCheckScript("liveness"))
+
+	// The variables of the health checks are passed to the role
+	variables, err := role.GetVariablesForRole()
+	if assert.NoError(err) {
+		var names []string
+		for _, variable := range variables {
+			names = append(names, variable.Name)
+		}
+		assert.Equal([]string{"HEALTH_PORT", "HEALTH_TIMEOUT"}, names)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/healthcheck-templates-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`roles[myrole].run.healthcheck.readiness.url: Not found: "No variable declaration of 'MISSING_PORT'"`,
+			`roles[myrole].run.healthcheck.liveness.command[1]: Invalid value: "((#HEALTH_TIMEOUT))": Cannot parse template: line 1: Section HEALTH_TIMEOUT has no closing tag`,
+			`configuration.variables: Not found: "No templates using 'HEALTH_TIMEOUT'"`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestLoadRoleManifestVariableTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/variable-types.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if assert.NoError(err) {
+		variables := rolesManifest.Configuration.Variables
+		assert.Equal(VariableTypeList, variables[0].Type)
+		assert.Equal(65535, *variables[3].Max)
+	}
+
+	roleManifestPath = filepath.Join(workDir, "../test-assets/role-manifests/variable-types-bad.yml")
+	rolesManifest, err = LoadRoleManifest(roleManifestPath, []*Release{release})
+	assert.Nil(rolesManifest)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			`configuration.variables[CLIENT_KEYS].default: Invalid value: "alpha": Doesn't match the list type: alpha is not a JSON list`,
+			`configuration.variables[DEBUG]: Forbidden: Only int variables have a minimum or a maximum`,
+			`configuration.variables[DEBUG].default: Invalid value: "maybe": Doesn't match the bool type: maybe is not a boolean`,
+			`configuration.variables[HOSTNAME].allowed-values: Required value: Enum variables need the values they allow`,
+			`configuration.variables[NAME].type: Unsupported value: "text": supported values: string, int, bool, enum, list`,
+			`configuration.variables[PORT].allowed-values: Forbidden: Only enum variables have allowed values`,
+			`configuration.variables[PORT].max: Invalid value: 1: Is less than the minimum 1024`,
+			`configuration.variables[PORT].default: Invalid value: 9050: Doesn't match the int type: 9050 is more than the maximum 1`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestMarshalCanonicalSortsVariables(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := &RoleManifest{
+		Configuration: &Configuration{
+			Variables: ConfigurationVariableSlice{{Name: "ZED"}, {Name: "ALPHA"}},
+		},
+	}
+	contents, err := roleManifest.MarshalCanonical()
+	if !assert.NoError(err) {
+		return
+	}
+
+	var canonical RoleManifest
+	if assert.NoError(yaml.Unmarshal(contents, &canonical)) {
+		assert.Equal("ALPHA", canonical.Configuration.Variables[0].Name)
+		assert.Equal("ZED", canonical.Configuration.Variables[1].Name)
+	}
+	// The manifest itself is left alone
+	assert.Equal("ZED", roleManifest.Configuration.Variables[0].Name)
+}
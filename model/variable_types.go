@@ -0,0 +1,147 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hpcloud/fissile/validation"
+)
+
+// Variable types, deciding which values a configuration variable accepts and
+// how they are serialized; variables without a type hold strings
+const (
+	VariableTypeString = "string"
+	VariableTypeInt    = "int"
+	VariableTypeBool   = "bool"
+	VariableTypeEnum   = "enum"
+	VariableTypeList   = "list"
+)
+
+var variableTypes = []string{
+	VariableTypeString,
+	VariableTypeInt,
+	VariableTypeBool,
+	VariableTypeEnum,
+	VariableTypeList,
+}
+
+// FormatValue checks a value of the variable against its type and returns
+// it serialized for the environment of the roles: integers and booleans in
+// their canonical form, lists as JSON arrays. The value is either the
+// default from the role manifest, as YAML decoded it, or a string from the
+// defaults files. Escape sequences in strings, e.g. \n, are expanded.
+func (cv *ConfigurationVariable) FormatValue(value interface{}) (string, error) {
+	switch cv.Type {
+	case VariableTypeInt:
+		var number int
+		switch value := value.(type) {
+		case int:
+			number = value
+		case string:
+			var err error
+			if number, err = strconv.Atoi(value); err != nil {
+				return "", fmt.Errorf("%s is not an integer", value)
+			}
+		default:
+			return "", fmt.Errorf("%v is not an integer", value)
+		}
+		if cv.Min != nil && number < *cv.Min {
+			return "", fmt.Errorf("%d is less than the minimum %d", number, *cv.Min)
+		}
+		if cv.Max != nil && number > *cv.Max {
+			return "", fmt.Errorf("%d is more than the maximum %d", number, *cv.Max)
+		}
+		return strconv.Itoa(number), nil
+
+	case VariableTypeBool:
+		switch value := value.(type) {
+		case bool:
+			return strconv.FormatBool(value), nil
+		case string:
+			flag, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", fmt.Errorf("%s is not a boolean", value)
+			}
+			return strconv.FormatBool(flag), nil
+		}
+		return "", fmt.Errorf("%v is not a boolean", value)
+
+	case VariableTypeList:
+		list, ok := value.([]interface{})
+		if text, isString := value.(string); isString {
+			if err := json.Unmarshal([]byte(text), &list); err != nil {
+				return "", fmt.Errorf("%s is not a JSON list", text)
+			}
+		} else if !ok {
+			return "", fmt.Errorf("%v is not a list", value)
+		}
+		serialized, err := json.Marshal(list)
+		if err != nil {
+			return "", fmt.Errorf("%v can't be serialized as a JSON list: %s", value, err)
+		}
+		return string(serialized), nil
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		text = fmt.Sprintf("%v", value)
+	} else if unquoted, err := strconv.Unquote(fmt.Sprintf(`"%s"`, text)); err == nil {
+		text = unquoted
+	}
+	if cv.Type == VariableTypeEnum {
+		for _, allowed := range cv.AllowedValues {
+			if text == allowed {
+				return text, nil
+			}
+		}
+		return "", fmt.Errorf("%s is not one of the allowed values %v", text, cv.AllowedValues)
+	}
+	return text, nil
+}
+
+// validateVariableTypes reports the variables of unknown types, the type
+// constraints which don't apply to the type of their variable, and the
+// defaults which don't match the type
+func validateVariableTypes(variables ConfigurationVariableSlice) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, cv := range variables {
+		field := fmt.Sprintf("configuration.variables[%s]", cv.Name)
+
+		switch cv.Type {
+		case "":
+			cv.Type = VariableTypeString
+		case VariableTypeString, VariableTypeInt, VariableTypeBool, VariableTypeEnum, VariableTypeList:
+		default:
+			allErrs = append(allErrs, validation.NotSupported(field+".type", cv.Type, variableTypes))
+			continue
+		}
+
+		if cv.Type == VariableTypeEnum && len(cv.AllowedValues) == 0 {
+			allErrs = append(allErrs, validation.Required(field+".allowed-values",
+				"Enum variables need the values they allow"))
+		}
+		if cv.Type != VariableTypeEnum && len(cv.AllowedValues) > 0 {
+			allErrs = append(allErrs, validation.Forbidden(field+".allowed-values",
+				"Only enum variables have allowed values"))
+		}
+		if cv.Type != VariableTypeInt && (cv.Min != nil || cv.Max != nil) {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				"Only int variables have a minimum or a maximum"))
+		} else if cv.Min != nil && cv.Max != nil && *cv.Min > *cv.Max {
+			allErrs = append(allErrs, validation.Invalid(field+".max", *cv.Max,
+				fmt.Sprintf("Is less than the minimum %d", *cv.Min)))
+		}
+
+		if cv.Default == nil || cv.IsSecret() || (cv.Type == VariableTypeEnum && len(cv.AllowedValues) == 0) {
+			continue
+		}
+		if _, err := cv.FormatValue(cv.Default); err != nil {
+			allErrs = append(allErrs, validation.Invalid(field+".default", cv.Default,
+				fmt.Sprintf("Doesn't match the %s type: %s", cv.Type, err)))
+		}
+	}
+
+	return allErrs
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurationVariableFormatValue(t *testing.T) {
+	assert := assert.New(t)
+
+	min, max := 1, 10
+	tests := []struct {
+		variable ConfigurationVariable
+		value    interface{}
+		expected string
+		err      string
+	}{
+		{ConfigurationVariable{}, `a\nb`, "a\nb", ""},
+		{ConfigurationVariable{}, 12, "12", ""},
+		{ConfigurationVariable{Type: VariableTypeInt}, 12, "12", ""},
+		{ConfigurationVariable{Type: VariableTypeInt}, "012", "12", ""},
+		{ConfigurationVariable{Type: VariableTypeInt}, "twelve", "", "twelve is not an integer"},
+		{ConfigurationVariable{Type: VariableTypeInt}, 1.5, "", "1.5 is not an integer"},
+		{ConfigurationVariable{Type: VariableTypeInt, Min: &min, Max: &max}, 0, "", "0 is less than the minimum 1"},
+		{ConfigurationVariable{Type: VariableTypeInt, Min: &min, Max: &max}, "11", "", "11 is more than the maximum 10"},
+		{ConfigurationVariable{Type: VariableTypeBool}, true, "true", ""},
+		{ConfigurationVariable{Type: VariableTypeBool}, "1", "true", ""},
+		{ConfigurationVariable{Type: VariableTypeBool}, "no", "", "no is not a boolean"},
+		{ConfigurationVariable{Type: VariableTypeEnum, AllowedValues: []string{"a", "b"}}, "b", "b", ""},
+		{ConfigurationVariable{Type: VariableTypeEnum, AllowedValues: []string{"a", "b"}}, "c", "", "c is not one of the allowed values [a b]"},
+		{ConfigurationVariable{Type: VariableTypeList}, []interface{}{"a", 1, true}, `["a",1,true]`, ""},
+		{ConfigurationVariable{Type: VariableTypeList}, `[ "a", "b" ]`, `["a","b"]`, ""},
+		{ConfigurationVariable{Type: VariableTypeList}, "a", "", "a is not a JSON list"},
+		{ConfigurationVariable{Type: VariableTypeList}, 1, "", "1 is not a list"},
+	}
+
+	for _, test := range tests {
+		value, err := test.variable.FormatValue(test.value)
+		if test.err != "" {
+			if assert.Error(err, "%#v", test.value) {
+				assert.Equal(test.err, err.Error())
+			}
+			continue
+		}
+		if assert.NoError(err, "%#v", test.value) {
+			assert.Equal(test.expected, value)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VariableUsage indexes which templates and roles use the configuration
+// variables, and which variables each template uses. Global templates are
+// named by their property, templates of a role replacing or adding to them
+// as <role>/<property>.
+type VariableUsage struct {
+	Variables map[string]*VariableUses `json:"variables" yaml:"variables"` // By variable name
+	Templates map[string][]string      `json:"templates" yaml:"templates"` // Variables used, by template name
+}
+
+// VariableUses lists the templates and roles using a variable
+type VariableUses struct {
+	Templates []string `json:"templates" yaml:"templates"`
+	Roles     []string `json:"roles" yaml:"roles"` // Roles whose jobs, job conditions or sidecars use the variable
+}
+
+// VariableUsage returns the index of the variables used by the templates
+// and roles of the role manifest, built when it was loaded
+func (m *RoleManifest) VariableUsage() *VariableUsage {
+	return m.variableUsage
+}
+
+// indexVariableUsage builds the index of the variables used by the templates
+// and roles of the role manifest. Like the validation of variable usage, role
+// templates count for the properties the jobs of their role declare, and
+// templates which don't parse are skipped.
+func indexVariableUsage(roleManifest *RoleManifest) *VariableUsage {
+	usage := &VariableUsage{
+		Variables: make(map[string]*VariableUses),
+		Templates: make(map[string][]string),
+	}
+
+	uses := func(name string) *VariableUses {
+		if _, ok := usage.Variables[name]; !ok {
+			usage.Variables[name] = &VariableUses{Templates: []string{}, Roles: []string{}}
+		}
+		return usage.Variables[name]
+	}
+
+	for _, variable := range roleManifest.Configuration.Variables {
+		uses(variable.Name)
+	}
+
+	globalTemplates := roleManifest.Configuration.Templates
+
+	addTemplate := func(templateName, template string) []string {
+		if variables, ok := usage.Templates[templateName]; ok {
+			return variables
+		}
+		variables, err := parseTemplate(template)
+		if err != nil {
+			return nil
+		}
+		variables = uniqueSorted(variables)
+		usage.Templates[templateName] = variables
+		for _, variable := range variables {
+			uses(variable).Templates = append(uses(variable).Templates, templateName)
+		}
+		return variables
+	}
+
+	for property, template := range globalTemplates {
+		addTemplate(property, template)
+	}
+
+	for _, role := range roleManifest.Roles {
+		roleVariables := make(map[string]bool)
+
+		for _, job := range role.Jobs {
+			for _, property := range job.Properties {
+				propertyName := fmt.Sprintf("properties.%s", property.Name)
+				template, ok := role.Configuration.Templates[propertyName]
+				if !ok {
+					continue
+				}
+
+				templateName := propertyName
+				if global, ok := globalTemplates[propertyName]; !ok || global != template {
+					templateName = fmt.Sprintf("%s/%s", role.Name, propertyName)
+				}
+				for _, variable := range addTemplate(templateName, template) {
+					roleVariables[variable] = true
+				}
+			}
+		}
+
+		for _, sidecar := range role.Sidecars {
+			for _, variable := range sidecar.Env {
+				roleVariables[variable] = true
+			}
+		}
+
+		for _, variable := range role.jobConditions {
+			roleVariables[variable] = true
+		}
+
+		for variable := range roleVariables {
+			uses(variable).Roles = append(uses(variable).Roles, role.Name)
+		}
+	}
+
+	for _, variableUses := range usage.Variables {
+		sort.Strings(variableUses.Templates)
+		sort.Strings(variableUses.Roles)
+	}
+
+	return usage
+}
+
+// uniqueSorted returns the sorted distinct strings of a list
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
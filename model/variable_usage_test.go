@@ -0,0 +1,57 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVariableUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/variable-usage.yml")
+	rolesManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release})
+	if !assert.NoError(err) {
+		return
+	}
+
+	usage := rolesManifest.VariableUsage()
+	if !assert.NotNil(usage) {
+		return
+	}
+
+	assert.Equal(map[string][]string{
+		"properties.tor.hostname":           {"HOSTNAME"},
+		"properties.tor.private_key":        {"PRIVATE_KEY"},
+		"otherrole/properties.tor.hostname": {"DOMAIN", "HOSTNAME"},
+	}, usage.Templates)
+
+	assert.Equal(map[string]*VariableUses{
+		"DOMAIN": {
+			Templates: []string{"otherrole/properties.tor.hostname"},
+			Roles:     []string{"otherrole"},
+		},
+		"HOSTNAME": {
+			Templates: []string{"otherrole/properties.tor.hostname", "properties.tor.hostname"},
+			Roles:     []string{"myrole", "otherrole"},
+		},
+		"PRIVATE_KEY": {
+			Templates: []string{"properties.tor.private_key"},
+			Roles:     []string{"myrole", "otherrole"},
+		},
+		"SHIPPER_TOKEN": {
+			Templates: []string{},
+			Roles:     []string{"myrole"},
+		},
+	}, usage.Variables)
+}
@@ -34,6 +34,19 @@ export BOSH_INSTALL_TARGET="/var/vcap/packages/$packageName"
 export BOSH_PACKAGE_NAME="${packageName}"
 export BOSH_PACKAGE_VERSION="${packageVersion}"
 
+# Point the usual build caches into the persistent build cache, if any
+if test -n "${FISSILE_BUILD_CACHE:-}" ; then
+  mkdir -p "${FISSILE_BUILD_CACHE}"
+  export CCACHE_DIR="${FISSILE_BUILD_CACHE}/ccache"
+  export CCACHE_BASEDIR="/var/vcap"
+  export GOCACHE="${FISSILE_BUILD_CACHE}/go-build"
+  export GEM_SPEC_CACHE="${FISSILE_BUILD_CACHE}/gem-specs"
+  export BUNDLE_USER_CACHE="${FISSILE_BUILD_CACHE}/bundler"
+  if test -d /usr/lib/ccache ; then
+    export PATH="/usr/lib/ccache:${PATH}"
+  fi
+fi
+
 echo "Compiling to ${BOSH_INSTALL_TARGET}"
 
 if test -d "/fissile-out" ; then
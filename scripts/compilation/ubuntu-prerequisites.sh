@@ -21,7 +21,7 @@ rsync openssh-server traceroute libncurses5-dev quota \
 libaio1 gdb libcap2-bin libcap2-dev libbz2-dev \
 cmake uuid-dev libgcrypt-dev ca-certificates \
 scsitools mg htop module-assistant debhelper runit parted \
-anacron software-properties-common libyaml-dev gettext git"
+anacron software-properties-common libyaml-dev gettext git ccache"
 
 export DEBIAN_FRONTEND=noninteractive
 
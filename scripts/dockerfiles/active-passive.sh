@@ -0,0 +1,71 @@
+#!/bin/bash
+# Runs the active/passive probe of the role, to find out whether this instance
+# is the active one, e.g. the one holding the lock its jobs elect a leader with.
+#
+# Usage: active-passive.sh <role>
+#
+# Started in the background by run.sh, the probe runs every
+# FISSILE_ACTIVE_PASSIVE_INTERVAL seconds (5 by default). Whenever its result
+# changes, the state of the instance, active or passive, is written to the
+# state file, and on Kubernetes, the skiff-role-active label of the pod is set
+# to true or false. The services of the role only select active pods.
+
+set -o nounset
+
+role="${1:-}"
+state=/var/vcap/data/active-passive
+interval="${FISSILE_ACTIVE_PASSIVE_INTERVAL:-5}"
+serviceaccount=/var/run/secrets/kubernetes.io/serviceaccount
+
+mkdir -p "${state}"
+echo passive > "${state}/state"
+
+function report()
+{
+    echo "$1" >&2
+    logger -t active-passive "$1" 2> /dev/null || true
+}
+
+# label sets the label of the pod marking it active or not; outside of
+# Kubernetes, there is nothing to do
+function label()
+{
+    if [ -z "${KUBERNETES_SERVICE_HOST:-}" ] || [ ! -f "${serviceaccount}/token" ]; then
+        return 0
+    fi
+    local namespace=$(cat "${serviceaccount}/namespace")
+    curl --silent --fail --output /dev/null \
+        --cacert "${serviceaccount}/ca.crt" \
+        --header "Authorization: Bearer $(cat "${serviceaccount}/token")" \
+        --header "Content-Type: application/merge-patch+json" \
+        --request PATCH \
+        --data "{\"metadata\":{\"labels\":{\"skiff-role-active\":\"$1\"}}}" \
+        "https://${KUBERNETES_SERVICE_HOST}:${KUBERNETES_SERVICE_PORT}/api/v1/namespaces/${namespace}/pods/$(hostname)"
+}
+
+current=passive
+while true; do
+    if /opt/hcf/active-passive-probe > /dev/null 2>&1; then
+        next=active
+    else
+        next=passive
+    fi
+
+    if [ "${next}" != "${current}" ]; then
+        active=false
+        if [ "${next}" == active ]; then
+            active=true
+        fi
+        # Try again next time if the label can't be set yet, so the state
+        # file never claims more than the services know
+        if label "${active}"; then
+            current="${next}"
+            echo "${current}" > "${state}/state"
+            report "Instance of ${role} is ${current} now"
+        else
+            report "Failed to mark the instance of ${role} ${next}"
+        fi
+    fi
+
+    sleep "${interval}"
+done
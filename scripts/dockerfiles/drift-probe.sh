@@ -0,0 +1,107 @@
+#!/bin/bash
+# Checks the job templates of the role for configuration drift: renders them
+# again, with the secrets read from Vault anew, and compares the result with
+# what they rendered to when the role started.
+#
+# Usage: drift-probe.sh --snapshot | <role>
+#
+# run.sh records what the templates render to at start with --snapshot. Run
+# by cron with the name of the role, the probe exits with 1 if any file now
+# renders differently, logging which ones, and with 2 if the templates can't
+# be rendered. The result is also written as metrics for the textfile
+# collector of the Prometheus node exporter, to drift.prom in the state
+# directory.
+
+set -o nounset
+
+state=/var/vcap/data/drift-probe
+
+# Only one probe at a time; a probe still running is good enough
+exec 9> "${state}/lock"
+flock -n 9 || exit 0
+
+# render renders the templates into a directory instead of their place
+function render()
+{
+    local dir="$1"
+    rm -rf "${dir}"
+    mkdir -p "${dir}"
+    sed \
+        -e "s|\":\"/var/vcap/jobs/|\":\"${dir}/jobs/|g" \
+        -e "s|\":\"/var/vcap/monit/|\":\"${dir}/monit/|g" \
+        -e "s|\":\"/etc/monitrc\"|\":\"${dir}/monitrc\"|g" \
+        "${state}/job_config.json" > "${state}/render_config.json"
+    (
+        source "${state}/env"
+        if [ -n "${VAULT_SECRETS_PATH:-}" ]; then
+            source /opt/hcf/vault-secrets.sh
+        fi
+        /opt/hcf/configgin/configgin \
+            --jobs "${state}/render_config.json" \
+            --env2conf /opt/hcf/env2conf.yml
+    ) > "${state}/render.log" 2>&1
+    local status=$?
+    # The monit configuration holds random credentials, it differs every time
+    rm -f "${dir}/monitrc"
+    return ${status}
+}
+
+function report()
+{
+    echo "$1" >&2
+    logger -t drift-probe "$1" 2> /dev/null || true
+}
+
+function metrics()
+{
+    cat > "${state}/drift.prom.tmp" <<EOF
+# HELP fissile_config_drift Whether the templates of the role render differently than when it started
+# TYPE fissile_config_drift gauge
+fissile_config_drift{role="${role}"} $1
+# HELP fissile_config_drift_files The number of files rendering differently than when the role started
+# TYPE fissile_config_drift_files gauge
+fissile_config_drift_files{role="${role}"} $2
+EOF
+    mv "${state}/drift.prom.tmp" "${state}/drift.prom"
+}
+
+# checksums lists the checksums of the rendered files, by their path
+function checksums()
+{
+    (cd "$1" && find . -type f -exec sha256sum {} + | sort -k 2)
+}
+
+if [ "${1:-}" == "--snapshot" ]; then
+    render "${state}/started"
+    exit $?
+fi
+
+role="${1:-}"
+
+if [ ! -d "${state}/started" ]; then
+    report "No templates recorded at start to compare with"
+    metrics 0 0
+    exit 2
+fi
+
+if ! render "${state}/current"; then
+    report "Failed to render the templates, see ${state}/render.log"
+    metrics 0 0
+    exit 2
+fi
+
+# Only the paths of the files are reported, their contents may be secret
+changed=$(diff <(checksums "${state}/started") <(checksums "${state}/current") | \
+    sed -n 's|^[<>] [0-9a-f]*  \./|/var/vcap/|p' | sort -u)
+if [ -z "${changed}" ]; then
+    metrics 0 0
+    exit 0
+fi
+
+count=$(echo "${changed}" | wc -l)
+report "Configuration drift, ${count} files render differently than when the role started:"
+echo "${changed}" | while read -r line; do
+    report "  ${line}"
+done
+metrics 1 "${count}"
+exit 1
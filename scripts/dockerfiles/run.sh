@@ -20,9 +20,48 @@ if [ -d /var/vcap/sys/run ]; then
     find /var/vcap/sys/run -name "*.pid" -delete
 fi
 
+# Adjust how likely the role is to be killed when out of memory; all of its
+# processes inherit this. Lowering it needs the SYS_RESOURCE capability.
+if [ -n "${FISSILE_OOM_SCORE_ADJ:-}" ]; then
+    echo "${FISSILE_OOM_SCORE_ADJ}" > /proc/self/oom_score_adj || \
+        echo "Failed to set the OOM score adjustment to ${FISSILE_OOM_SCORE_ADJ}" >&2
+fi
+
 export IP_ADDRESS=$(/bin/hostname -i | awk '{print $1}')
 export DNS_RECORD_NAME=$(/bin/hostname)
 
+# Fill in the BOSH spec of the jobs (spec.index, spec.bootstrap, spec.id,
+# spec.address, spec.networks); the index is the ordinal of a StatefulSet pod
+{{ if .role.IsStateful }}
+export INSTANCE_INDEX=$(echo "${DNS_RECORD_NAME}" | sed -n 's/.*-\([0-9]\+\)$/\1/p')
+{{ end }}
+export INSTANCE_INDEX=${INSTANCE_INDEX:-0}
+if [ "${INSTANCE_INDEX}" == 0 ]; then
+    bootstrap=true
+else
+    bootstrap=false
+fi
+for spec in /var/vcap/jobs-src/*/config_spec.json ; do
+    sed -i \
+        -e "s/\"((FISSILE_SPEC_INDEX))\"/${INSTANCE_INDEX}/g" \
+        -e "s/\"((FISSILE_SPEC_BOOTSTRAP))\"/${bootstrap}/g" \
+        -e "s/((FISSILE_SPEC_ID))/${DNS_RECORD_NAME}/g" \
+        -e "s/((FISSILE_SPEC_ADDRESS))/${IP_ADDRESS}/g" \
+        "${spec}"
+done
+
+{{ if .drift_probe }}
+# Keep the environment the templates are rendered with, for the drift probe,
+# which reads the secrets from Vault anew
+mkdir -p /var/vcap/data/drift-probe
+(umask 077 && export -p > /var/vcap/data/drift-probe/env)
+{{ end }}
+
+# Read the secrets of the role from Vault, if they are kept there
+if [ -n "${VAULT_SECRETS_PATH:-}" ]; then
+    source /opt/hcf/vault-secrets.sh
+fi
+
 # Run custom environment scripts (that are sourced)
 {{ range $script := .role.EnvironScripts }}
     source {{ if not (is_abs $script) }}/opt/hcf/startup/{{ end }}{{ $script }}
@@ -32,24 +71,67 @@ export DNS_RECORD_NAME=$(/bin/hostname)
     bash {{ if not (is_abs $script) }}/opt/hcf/startup/{{ end }}{{ $script }}
 {{ end }}
 
+jobs_config=/opt/hcf/job_config.json
+{{ if .conditions }}
+# Configure and start the conditional jobs only if the variable of their
+# condition is true, adding them to the configuration of the other jobs
+jobs_config=/var/vcap/job_config.json
+cp /opt/hcf/job_config.json "${jobs_config}"
+
+function enable-job()
+{
+    local contents=$(cat "${jobs_config}")
+    local separator=","
+    if [ "${contents}" == "{}" ]; then
+        separator=""
+    fi
+    echo "${contents%\}}${separator}\"$1\":$(cat "/opt/hcf/job_config/$1.json")}" > "${jobs_config}"
+}
+
+{{ range $job, $variable := .conditions }}
+if [ "${ {{- $variable -}} :-}" == "true" ]; then
+    enable-job {{ $job }}
+else
+    echo "Job {{ $job }} is disabled by {{ $variable }}"
+    rm -rf /var/vcap/jobs/{{ $job }} /var/vcap/monit/{{ $job }}.monitrc
+fi
+{{ end }}
+{{ end }}
+
+{{ if .healthcheck }}
+# The health checks with templates are rendered along with the jobs
+mkdir -p /var/vcap/healthcheck
+{{ end }}
+
 /opt/hcf/configgin/configgin \
-	--jobs /opt/hcf/job_config.json \
+	--jobs "${jobs_config}" \
 	--env2conf /opt/hcf/env2conf.yml
 
+{{ if .drift_probe }}
+# Record what the templates rendered to, for the drift probe to compare with
+cp "${jobs_config}" /var/vcap/data/drift-probe/job_config.json
+/opt/hcf/drift-probe.sh --snapshot || \
+    echo "Failed to record the rendered templates for the drift probe" >&2
+{{ end }}
+
 if [ -e /etc/monitrc ]
 then
   chmod 0600 /etc/monitrc
 fi
 
-# Create run dir
+# Create run dir; roles running as another user own it already
 mkdir -p /var/vcap/sys/run
-chown root:vcap /var/vcap/sys/run
+if [ "$(id -u)" == 0 ]; then
+    chown root:vcap /var/vcap/sys/run
+fi
 chmod 775 /var/vcap/sys/run
 
 {{ if eq .role.Type "bosh-task" }}
-    # Start rsyslog and cron
-    service rsyslog start
-    cron
+    # Start rsyslog and cron, which need root
+    if [ "$(id -u)" == 0 ]; then
+        service rsyslog start
+        cron
+    fi
 {{ else }}
     # rsyslog and cron are started via monit
 {{ end }}
@@ -88,7 +170,14 @@ done
 # Run
 {{ if eq .role.Type "bosh-task" }}
     {{ range $job := .role.Jobs}}
-        /var/vcap/jobs/{{ $job.Name }}/bin/run
+    {{ $name := $.role.JobName $job }}
+    {{ if index $.conditions $name }}
+        if [ -d /var/vcap/jobs/{{ $name }} ]; then
+            /var/vcap/jobs/{{ $name }}/bin/run
+        fi
+    {{ else }}
+        /var/vcap/jobs/{{ $name }}/bin/run
+    {{ end }}
     {{ end }}
 {{ else }}
 
@@ -113,6 +202,11 @@ done
 
   trap killer SIGTERM
 
+{{ if .active_passive }}
+  # Find out whether this instance is the active one, from now on
+  /opt/hcf/active-passive.sh {{ .role.Name }} &
+{{ end }}
+
   if [[ "${LOG_LEVEL}" == "debug"* || -n "${LOG_DEBUG}" ]]; then
     # monit -v without the -I would fork a child, but then we can't wait on it,
     # so it's not very useful.
@@ -0,0 +1,18 @@
+#!/bin/bash
+# Sourced by run.sh when the role keeps its secrets in Vault: reads the
+# secrets stored at the KV path in VAULT_SECRETS_PATH, and exports each of
+# them as an environment variable, for configgin to use. VAULT_ADDR and
+# VAULT_TOKEN are set from the "vault" Secret.
+
+if [ -z "${VAULT_ADDR:-}" ] || [ -z "${VAULT_TOKEN:-}" ]; then
+    echo "VAULT_ADDR and VAULT_TOKEN must be set to read secrets from ${VAULT_SECRETS_PATH}" >&2
+    exit 1
+fi
+
+vault_secrets="$(curl --silent --show-error --fail \
+    --header "X-Vault-Token: ${VAULT_TOKEN}" \
+    "${VAULT_ADDR%/}/v1/${VAULT_SECRETS_PATH}")"
+
+eval "$(echo "${vault_secrets}" | jq --raw-output '.data | to_entries[] | "export \(.key)=\(.value | @sh)"')"
+
+unset vault_secrets
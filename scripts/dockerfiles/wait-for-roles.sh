@@ -0,0 +1,69 @@
+#!/bin/bash
+# Waits for the roles a role depends on: until at least one instance of each
+# role is ready, or, for tasks, until the task succeeded.
+#
+# Usage: wait-for-roles.sh <kind>/<role> ...
+#
+# Run as an init container of the pods of the role on Kubernetes, with kind
+# being one of statefulset, deployment or job. The status of each is polled
+# every FISSILE_WAIT_INTERVAL seconds (5 by default) through the Kubernetes
+# API, with the service account of the pod.
+
+set -o nounset
+
+interval="${FISSILE_WAIT_INTERVAL:-5}"
+serviceaccount=/var/run/secrets/kubernetes.io/serviceaccount
+
+function report()
+{
+    echo "$1" >&2
+}
+
+if [ -z "${KUBERNETES_SERVICE_HOST:-}" ] || [ ! -f "${serviceaccount}/token" ]; then
+    report "Not running on Kubernetes, not waiting for $*"
+    exit 0
+fi
+namespace=$(cat "${serviceaccount}/namespace")
+
+# status prints the status of a role, as returned by the Kubernetes API
+function status()
+{
+    local kind="${1%%/*}"
+    local name="${1#*/}"
+    local path
+    case "${kind}" in
+        statefulset) path="apis/apps/v1beta1/namespaces/${namespace}/statefulsets/${name}" ;;
+        deployment)  path="apis/extensions/v1beta1/namespaces/${namespace}/deployments/${name}" ;;
+        job)         path="apis/batch/v1/namespaces/${namespace}/jobs/${name}" ;;
+        *)
+            report "Unknown kind of role ${1}"
+            return 1
+            ;;
+    esac
+    curl --silent --fail \
+        --cacert "${serviceaccount}/ca.crt" \
+        --header "Authorization: Bearer $(cat "${serviceaccount}/token")" \
+        "https://${KUBERNETES_SERVICE_HOST}:${KUBERNETES_SERVICE_PORT}/${path}"
+}
+
+# ready tells whether a role is up, or a task is done
+function ready()
+{
+    local field
+    case "${1%%/*}" in
+        statefulset) field=readyReplicas ;;
+        deployment)  field=availableReplicas ;;
+        job)         field=succeeded ;;
+    esac
+    local count=$(status "$1" | \
+        sed -n "s|.*\"${field}\": *\([0-9]*\).*|\1|p" | head -n 1)
+    [ "${count:-0}" -gt 0 ]
+}
+
+for role in "$@"; do
+    report "Waiting for ${role}"
+    until ready "${role}"; do
+        sleep "${interval}"
+    done
+    report "Done waiting for ${role}"
+done
@@ -0,0 +1,52 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BuiltinBackendName is the name of the backend generating values in fissile
+// itself
+const BuiltinBackendName = "builtin"
+
+// ErrUnsupported is returned by backends that can't generate values of the
+// requested type; those variables are skipped
+var ErrUnsupported = errors.New("Unsupported generator type")
+
+// Backend generates the values of a group of variables
+type Backend interface {
+	// Generate returns the values of all variables of the request, by name
+	Generate(request *Request) (map[string]string, error)
+}
+
+// Request describes the values a backend has to generate
+type Request struct {
+	ID           string            `json:"id"`                      // Generator ID shared by the variables
+	Type         string            `json:"type"`                    // Generator type
+	Variables    []RequestVariable `json:"variables"`               // Variables to generate values for
+	SubjectNames []string          `json:"subject_names,omitempty"` // Names a certificate is valid for
+	CA           map[string]string `json:"ca,omitempty"`            // Certificate and private key of the CA signing a certificate
+	Length       int               `json:"length,omitempty"`        // Length of a password
+	Charset      string            `json:"charset,omitempty"`       // Characters a password is made of
+}
+
+// RequestVariable is a variable to generate a value for
+type RequestVariable struct {
+	Name      string `json:"name"`
+	ValueType string `json:"value_type,omitempty"`
+}
+
+// assignValues hands each variable of the request the part of the generated
+// value matching its value type
+func (r *Request) assignValues(parts map[string]string) (map[string]string, error) {
+	result := make(map[string]string, len(r.Variables))
+	for _, variable := range r.Variables {
+		value, ok := parts[variable.ValueType]
+		if !ok {
+			return nil, fmt.Errorf("Generator %s can't produce a value of type %s for %s",
+				r.ID, variable.ValueType, variable.Name)
+		}
+		result[variable.Name] = value
+	}
+	return result, nil
+}
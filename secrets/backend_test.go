@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingBackend remembers the requests it gets, and answers with fixed values
+type recordingBackend struct {
+	requests []*Request
+	values   map[string]string
+}
+
+func (b *recordingBackend) Generate(request *Request) (map[string]string, error) {
+	b.requests = append(b.requests, request)
+	return b.values, nil
+}
+
+func TestGenerateBackendSelection(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadGeneratorsManifest(assert)
+	if roleManifest == nil {
+		return
+	}
+	model.MakeMapOfVariables(roleManifest)["PASSWORD"].Generator.Backend = "recording"
+
+	backend := &recordingBackend{values: map[string]string{"PASSWORD": "hunter2"}}
+	settings := &Settings{Backends: map[string]Backend{"recording": backend}}
+
+	result, err := Generate(roleManifest, map[string]string{}, settings)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal("hunter2", result.Values["PASSWORD"])
+	assert.Empty(result.Skipped)
+	assert.Contains(result.Values["TOR_CERT"], "BEGIN CERTIFICATE", "Certificates should use the built-in backend")
+	if assert.Len(backend.requests, 1) {
+		assert.Equal(&Request{
+			ID:        "PASSWORD",
+			Type:      model.GeneratorTypePassword,
+			Variables: []RequestVariable{{Name: "PASSWORD"}},
+			Length:    16,
+			Charset:   "abc123",
+		}, backend.requests[0])
+	}
+
+	// The default backend gets everything else, including CA material
+	settings.DefaultBackend = "recording"
+	backend.requests = nil
+	backend.values = map[string]string{
+		"INTERNAL_CA_CERT": "ca-cert", "INTERNAL_CA_KEY": "ca-key",
+		"TOR_CERT": "cert", "TOR_KEY": "key", "PASSWORD": "hunter2",
+		"SSH_FINGERPRINT": "fingerprint", "SSH_KEY": "key", "SSH_PUBLIC_KEY": "public",
+	}
+	result, err = Generate(roleManifest, map[string]string{}, settings)
+	if assert.NoError(err) && assert.Len(backend.requests, 4) {
+		assert.Equal(map[string]string{"certificate": "ca-cert", "private_key": "ca-key"}, backend.requests[1].CA)
+		assert.Equal([]string{"myrole", "*.myrole-pod", "tor.example.com", "10.0.0.1"}, backend.requests[1].SubjectNames)
+	}
+
+	settings.DefaultBackend = "credhub"
+	_, err = Generate(roleManifest, map[string]string{}, settings)
+	assert.EqualError(err, "Generator internal_ca uses unknown backend credhub")
+
+	backend.values = map[string]string{}
+	settings.DefaultBackend = ""
+	_, err = Generate(roleManifest, map[string]string{}, settings)
+	assert.EqualError(err, "Error generating values for PASSWORD: no value for PASSWORD")
+}
+
+func TestCommandBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	// The request is passed on stdin
+	backend := NewCommandBackend(`grep -q '"id":"ssh"' && echo '{"KEY": "generated"}'`)
+	values, err := backend.Generate(&Request{
+		ID:        "ssh",
+		Type:      model.GeneratorTypeSSH,
+		Variables: []RequestVariable{{Name: "KEY", ValueType: "private_key"}},
+	})
+	if assert.NoError(err) {
+		assert.Equal(map[string]string{"KEY": "generated"}, values)
+	}
+
+	_, err = NewCommandBackend("echo oops >&2; exit 3").Generate(&Request{ID: "ssh"})
+	assert.EqualError(err, "Generator command failed: exit status 3: oops")
+
+	_, err = NewCommandBackend("echo not json").Generate(&Request{ID: "ssh"})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Generator command printed invalid values")
+	}
+}
+
+func TestVaultBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/generated/tor_cert":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]string{"certificate": "cert", "TOR_KEY": "key"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	request := &Request{
+		ID:   "tor_cert",
+		Type: model.GeneratorTypeCertificate,
+		Variables: []RequestVariable{
+			{Name: "TOR_CERT", ValueType: "certificate"},
+			{Name: "TOR_KEY", ValueType: "private_key"},
+		},
+	}
+
+	values, err := NewVaultBackend(server.URL+"/", "token", "/secret/generated/").Generate(request)
+	if assert.NoError(err) {
+		assert.Equal(map[string]string{"TOR_CERT": "cert", "TOR_KEY": "key"}, values)
+	}
+
+	request.ID = "other"
+	_, err = NewVaultBackend(server.URL, "token", "secret/generated").Generate(request)
+	assert.EqualError(err, "Vault has no values at secret/generated/other")
+
+	_, err = NewVaultBackend(server.URL, "wrong", "secret/generated").Generate(request)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "403 Forbidden")
+	}
+}
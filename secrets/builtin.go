@@ -0,0 +1,23 @@
+package secrets
+
+import (
+	"github.com/hpcloud/fissile/model"
+)
+
+// builtinBackend generates values in fissile itself
+type builtinBackend struct{}
+
+// Generate implements Backend
+func (builtinBackend) Generate(request *Request) (map[string]string, error) {
+	switch request.Type {
+	case model.GeneratorTypeCACertificate:
+		return generateCA(request)
+	case model.GeneratorTypeCertificate:
+		return generateCertificate(request)
+	case model.GeneratorTypePassword:
+		return generatePassword(request)
+	case model.GeneratorTypeSSH:
+		return generateSSHKey(request)
+	}
+	return nil, ErrUnsupported
+}
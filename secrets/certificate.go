@@ -0,0 +1,188 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+const (
+	// certificateKeyBits is the size of the RSA keys of CAs and certificates
+	certificateKeyBits = 2048
+	// certificateValidity is how long generated CAs and certificates are valid
+	certificateValidity = 10 * 365 * 24 * time.Hour
+)
+
+// subjectNameVariableRegexp matches the ((VARIABLE)) references in subject names
+var subjectNameVariableRegexp = regexp.MustCompile(`\(\(([A-Za-z0-9_]+)\)\)`)
+
+// certificateAuthority is a CA that can sign certificates
+type certificateAuthority struct {
+	certificate *x509.Certificate
+	key         *rsa.PrivateKey
+}
+
+// generateCA creates a self-signed CA
+func generateCA(request *Request) (map[string]string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, certificateKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating key for CA %s: %s", request.ID, err)
+	}
+
+	template, err := newCertificateTemplate(request.ID)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating CA %s: %s", request.ID, err)
+	}
+
+	return request.assignValues(encodeCertificate(der, key))
+}
+
+// loadCA reads the CA of a certificate request
+func loadCA(request *Request) (*certificateAuthority, error) {
+	block, _ := pem.Decode([]byte(request.CA[model.ValueTypeCertificate]))
+	if block == nil {
+		return nil, fmt.Errorf("The CA of %s has an invalid certificate", request.ID)
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("The CA of %s has an invalid certificate: %s", request.ID, err)
+	}
+
+	block, _ = pem.Decode([]byte(request.CA[model.ValueTypePrivateKey]))
+	if block == nil {
+		return nil, fmt.Errorf("The CA of %s has an invalid private key", request.ID)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("The CA of %s has an invalid private key: %s", request.ID, err)
+	}
+
+	return &certificateAuthority{certificate: certificate, key: key}, nil
+}
+
+// generateCertificate creates a certificate signed by the CA of the request,
+// and valid for its subject names
+func generateCertificate(request *Request) (map[string]string, error) {
+	ca, err := loadCA(request)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, certificateKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating key for certificate %s: %s", request.ID, err)
+	}
+
+	commonName := request.ID
+	if len(request.SubjectNames) > 0 {
+		commonName = request.SubjectNames[0]
+	}
+
+	template, err := newCertificateTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+
+	for _, name := range request.SubjectNames {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating certificate %s: %s", request.ID, err)
+	}
+
+	return request.assignValues(encodeCertificate(der, key))
+}
+
+// subjectNames returns the names a certificate is valid for: the service
+// names of its role, followed by its explicit subject names
+func subjectNames(group *generatorGroup, roleManifest *model.RoleManifest, values map[string]string, settings *Settings) ([]string, error) {
+	var names []string
+
+	if roleName := group.generator.RoleName; roleName != "" {
+		names = append(names, roleName, fmt.Sprintf("*.%s-pod", roleName))
+		if settings.Namespace != "" {
+			for _, suffix := range []string{"", ".svc", ".svc.cluster.local"} {
+				names = append(names, fmt.Sprintf("%s.%s%s", roleName, settings.Namespace, suffix))
+			}
+		}
+	}
+
+	variables := model.MakeMapOfVariables(roleManifest)
+
+	for _, subjectName := range group.generator.SubjectNames {
+		var missing []string
+		name := subjectNameVariableRegexp.ReplaceAllStringFunc(subjectName, func(reference string) string {
+			name := subjectNameVariableRegexp.FindStringSubmatch(reference)[1]
+			if value, ok := values[name]; ok {
+				return value
+			}
+			if variable, ok := variables[name]; ok && variable.Default != nil {
+				return fmt.Sprintf("%v", variable.Default)
+			}
+			missing = append(missing, name)
+			return ""
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("Certificate %s uses %s in its subject names, which has no value", group.id, missing[0])
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// newCertificateTemplate returns the common parts of CAs and certificates
+func newCertificateTemplate(commonName string) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("Error generating certificate serial number: %s", err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.Add(certificateValidity),
+	}, nil
+}
+
+// encodeCertificate returns the PEM encoded certificate and private key, by
+// value type
+func encodeCertificate(der []byte, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		model.ValueTypeCertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		model.ValueTypePrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})),
+	}
+}
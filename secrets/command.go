@@ -0,0 +1,53 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandBackendName is the name of the backend running an external command
+const CommandBackendName = "command"
+
+// CommandBackend generates values by running an external command. The
+// command is run by the shell; it receives the Request as JSON on its
+// standard input, and has to print a JSON object mapping the names of the
+// variables to their values.
+type CommandBackend struct {
+	Command string
+}
+
+// NewCommandBackend creates a backend running the given command
+func NewCommandBackend(command string) *CommandBackend {
+	return &CommandBackend{Command: command}
+}
+
+// Generate implements Backend
+func (b *CommandBackend) Generate(request *Request) (map[string]string, error) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", b.Command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("Generator command failed: %s: %s", err, message)
+		}
+		return nil, fmt.Errorf("Generator command failed: %s", err)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(stdout.Bytes(), &values); err != nil {
+		return nil, fmt.Errorf("Generator command printed invalid values: %s", err)
+	}
+
+	return values, nil
+}
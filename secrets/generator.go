@@ -0,0 +1,280 @@
+package secrets
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// Settings configure the generation of values
+type Settings struct {
+	Namespace      string             // Kubernetes namespace the roles run in; used for certificate names
+	Backends       map[string]Backend // Backends by name, in addition to the built-in one
+	DefaultBackend string             // Backend used by generators that don't select one; the built-in one if empty
+}
+
+// Result lists the outcome of generating values
+type Result struct {
+	Values    map[string]string // Values of all variables with a generator, existing or generated
+	Generated []string          // Names of the variables that got a new value
+	Skipped   []string          // Names of the variables whose generator type is not supported
+}
+
+// generatorGroup is a set of variables whose values are generated together
+type generatorGroup struct {
+	id        string
+	generator *model.ConfigurationVariableGenerator
+	variables []*model.ConfigurationVariable
+}
+
+// generatorTypeOrder is the order groups are generated in; CAs have to exist
+// before the certificates they sign
+var generatorTypeOrder = map[string]int{
+	model.GeneratorTypeCACertificate: 0,
+	model.GeneratorTypeCertificate:   1,
+}
+
+// Generate creates values for all variables of the role manifest that have a
+// generator, using the backend selected by the generator, or else the
+// default one. Groups of variables that all have a value already are kept as
+// they are, so generation can be repeated safely. Certificates are
+// regenerated when their CA is. The existing values, or else the defaults of
+// the role manifest, are also used to expand ((VARIABLES)) in certificate
+// subject names.
+func Generate(roleManifest *model.RoleManifest, existing map[string]string, settings *Settings) (*Result, error) {
+	result := &Result{
+		Values: make(map[string]string),
+	}
+
+	values := make(map[string]string, len(existing))
+	for name, value := range existing {
+		values[name] = value
+	}
+
+	groups := groupVariables(roleManifest.Configuration.Variables)
+	sort.SliceStable(groups, func(i, j int) bool {
+		return typeOrder(groups[i]) < typeOrder(groups[j])
+	})
+
+	caIDs := make(map[string]string)
+	for _, group := range groups {
+		if group.generator.Type == model.GeneratorTypeCertificate {
+			caID, err := findCA(group, groups)
+			if err != nil {
+				return nil, err
+			}
+			caIDs[group.id] = caID
+		}
+	}
+
+	regenerated := make(map[string]bool)
+	skipped := make(map[string]bool)
+
+	for _, group := range groups {
+		caID := caIDs[group.id]
+		if !group.needsValues(values) && !regenerated[caID] {
+			continue
+		}
+
+		backend, err := settings.backend(group)
+		if err != nil {
+			return nil, err
+		}
+
+		request, err := newRequest(group, roleManifest, values, settings)
+		if err != nil {
+			return nil, err
+		}
+		if caID != "" {
+			request.CA, err = caValues(findGroup(caID, groups), values)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		generated, err := backend.Generate(request)
+		if err == ErrUnsupported {
+			skipped[group.id] = true
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error generating values for %s: %s", group.id, err)
+		}
+
+		regenerated[group.id] = true
+		for _, variable := range group.variables {
+			value, ok := generated[variable.Name]
+			if !ok {
+				return nil, fmt.Errorf("Error generating values for %s: no value for %s", group.id, variable.Name)
+			}
+			values[variable.Name] = value
+			result.Generated = append(result.Generated, variable.Name)
+		}
+	}
+
+	for _, group := range groups {
+		for _, variable := range group.variables {
+			if value, ok := values[variable.Name]; ok {
+				result.Values[variable.Name] = value
+			} else if skipped[group.id] {
+				result.Skipped = append(result.Skipped, variable.Name)
+			}
+		}
+	}
+
+	sort.Strings(result.Generated)
+	sort.Strings(result.Skipped)
+
+	return result, nil
+}
+
+// backend returns the backend generating the values of a group
+func (s *Settings) backend(group *generatorGroup) (Backend, error) {
+	name := group.generator.Backend
+	if name == "" {
+		name = s.DefaultBackend
+	}
+	if name == "" || name == BuiltinBackendName {
+		return builtinBackend{}, nil
+	}
+
+	backend, ok := s.Backends[name]
+	if !ok {
+		return nil, fmt.Errorf("Generator %s uses unknown backend %s", group.id, name)
+	}
+	return backend, nil
+}
+
+// newRequest describes the values to generate for a group
+func newRequest(group *generatorGroup, roleManifest *model.RoleManifest, values map[string]string, settings *Settings) (*Request, error) {
+	request := &Request{
+		ID:      group.id,
+		Type:    group.generator.Type,
+		Length:  group.generator.Length,
+		Charset: group.generator.Charset,
+	}
+
+	for _, variable := range group.variables {
+		request.Variables = append(request.Variables, RequestVariable{
+			Name:      variable.Name,
+			ValueType: variable.Generator.ValueType,
+		})
+	}
+
+	if group.generator.Type == model.GeneratorTypeCertificate {
+		names, err := subjectNames(group, roleManifest, values, settings)
+		if err != nil {
+			return nil, err
+		}
+		request.SubjectNames = names
+	}
+
+	return request, nil
+}
+
+// caValues returns the current certificate and private key of a CA, by
+// value type
+func caValues(group *generatorGroup, values map[string]string) (map[string]string, error) {
+	if group == nil {
+		return nil, fmt.Errorf("Unknown CA")
+	}
+
+	result := make(map[string]string)
+	for _, valueType := range []string{model.ValueTypeCertificate, model.ValueTypePrivateKey} {
+		value, ok := group.valueOfType(valueType, values)
+		if !ok {
+			return nil, fmt.Errorf("CA %s has no %s to sign with", group.id, valueType)
+		}
+		result[valueType] = value
+	}
+
+	return result, nil
+}
+
+// typeOrder returns the position of a group in the generation order
+func typeOrder(group *generatorGroup) int {
+	if order, ok := generatorTypeOrder[group.generator.Type]; ok {
+		return order
+	}
+	return len(generatorTypeOrder)
+}
+
+// groupVariables collects the variables with a generator by their group ID,
+// sorted by ID
+func groupVariables(variables model.ConfigurationVariableSlice) []*generatorGroup {
+	groupsByID := make(map[string]*generatorGroup)
+	var groups []*generatorGroup
+
+	for _, variable := range variables {
+		if variable.Generator == nil {
+			continue
+		}
+
+		id := variable.GroupID()
+		group, ok := groupsByID[id]
+		if !ok {
+			group = &generatorGroup{id: id, generator: variable.Generator}
+			groupsByID[id] = group
+			groups = append(groups, group)
+		}
+		group.variables = append(group.variables, variable)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].id < groups[j].id })
+
+	return groups
+}
+
+// findGroup returns the group with the given ID
+func findGroup(id string, groups []*generatorGroup) *generatorGroup {
+	for _, group := range groups {
+		if group.id == id {
+			return group
+		}
+	}
+	return nil
+}
+
+// findCA returns the ID of the CA signing the certificate of a group; a
+// certificate without an explicit CA is signed by the only CA there is
+func findCA(group *generatorGroup, groups []*generatorGroup) (string, error) {
+	if group.generator.CA != "" {
+		return group.generator.CA, nil
+	}
+
+	var caIDs []string
+	for _, candidate := range groups {
+		if candidate.generator.Type == model.GeneratorTypeCACertificate {
+			caIDs = append(caIDs, candidate.id)
+		}
+	}
+
+	if len(caIDs) != 1 {
+		return "", fmt.Errorf("Certificate %s needs a ca, as there are %d CAs to choose from", group.id, len(caIDs))
+	}
+
+	return caIDs[0], nil
+}
+
+// needsValues tests whether any variable of the group lacks a value
+func (g *generatorGroup) needsValues(values map[string]string) bool {
+	for _, variable := range g.variables {
+		if _, ok := values[variable.Name]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// valueOfType returns the value of the group's variable with the given
+// value type, if there is one
+func (g *generatorGroup) valueOfType(valueType string, values map[string]string) (string, bool) {
+	for _, variable := range g.variables {
+		if variable.Generator.ValueType == valueType {
+			value, ok := values[variable.Name]
+			return value, ok
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,156 @@
+package secrets
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/fissile/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadGeneratorsManifest(assert *assert.Assertions) *model.RoleManifest {
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := model.NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	if !assert.NoError(err) {
+		return nil
+	}
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/generators.yml")
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, []*model.Release{release})
+	if !assert.NoError(err) {
+		return nil
+	}
+
+	return roleManifest
+}
+
+func parseCertificate(assert *assert.Assertions, value string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(value))
+	if !assert.NotNil(block) {
+		return nil
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if !assert.NoError(err) {
+		return nil
+	}
+	return certificate
+}
+
+func TestGenerateCertificates(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadGeneratorsManifest(assert)
+	if roleManifest == nil {
+		return
+	}
+
+	result, err := Generate(roleManifest, map[string]string{"DOMAIN": "example.org"}, &Settings{Namespace: "cf"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal([]string{
+		"INTERNAL_CA_CERT", "INTERNAL_CA_KEY", "PASSWORD",
+		"SSH_FINGERPRINT", "SSH_KEY", "SSH_PUBLIC_KEY", "TOR_CERT", "TOR_KEY",
+	}, result.Generated)
+	assert.Empty(result.Skipped)
+	assert.Len(result.Values, 8)
+	assert.Contains(result.Values["INTERNAL_CA_KEY"], "BEGIN RSA PRIVATE KEY")
+	assert.Contains(result.Values["TOR_KEY"], "BEGIN RSA PRIVATE KEY")
+
+	ca := parseCertificate(assert, result.Values["INTERNAL_CA_CERT"])
+	certificate := parseCertificate(assert, result.Values["TOR_CERT"])
+	if ca == nil || certificate == nil {
+		return
+	}
+
+	assert.True(ca.IsCA)
+	assert.Equal("internal_ca", ca.Subject.CommonName)
+	assert.NoError(certificate.CheckSignatureFrom(ca))
+	assert.Equal([]string{
+		"myrole",
+		"*.myrole-pod",
+		"myrole.cf",
+		"myrole.cf.svc",
+		"myrole.cf.svc.cluster.local",
+		"tor.example.org",
+	}, certificate.DNSNames)
+	if assert.Len(certificate.IPAddresses, 1) {
+		assert.Equal("10.0.0.1", certificate.IPAddresses[0].String())
+	}
+}
+
+func TestGenerateKeepsExistingValues(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadGeneratorsManifest(assert)
+	if roleManifest == nil {
+		return
+	}
+
+	first, err := Generate(roleManifest, map[string]string{"DOMAIN": "example.org"}, &Settings{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Nothing is regenerated when all values exist
+	existing := map[string]string{"DOMAIN": "example.org"}
+	for name, value := range first.Values {
+		existing[name] = value
+	}
+	second, err := Generate(roleManifest, existing, &Settings{})
+	if assert.NoError(err) {
+		assert.Empty(second.Generated)
+		assert.Equal(first.Values, second.Values)
+	}
+
+	// A missing certificate is signed by the existing CA
+	delete(existing, "TOR_KEY")
+	third, err := Generate(roleManifest, existing, &Settings{})
+	if assert.NoError(err) {
+		assert.Equal([]string{"TOR_CERT", "TOR_KEY"}, third.Generated)
+		assert.Equal(first.Values["INTERNAL_CA_CERT"], third.Values["INTERNAL_CA_CERT"])
+
+		ca := parseCertificate(assert, third.Values["INTERNAL_CA_CERT"])
+		certificate := parseCertificate(assert, third.Values["TOR_CERT"])
+		if ca != nil && certificate != nil {
+			assert.NoError(certificate.CheckSignatureFrom(ca))
+		}
+	}
+
+	// A new CA means new certificates
+	delete(existing, "INTERNAL_CA_KEY")
+	fourth, err := Generate(roleManifest, existing, &Settings{})
+	if assert.NoError(err) {
+		assert.Equal([]string{"INTERNAL_CA_CERT", "INTERNAL_CA_KEY", "TOR_CERT", "TOR_KEY"}, fourth.Generated)
+	}
+}
+
+func TestGenerateSubjectNameVariables(t *testing.T) {
+	assert := assert.New(t)
+
+	roleManifest := loadGeneratorsManifest(assert)
+	if roleManifest == nil {
+		return
+	}
+
+	result, err := Generate(roleManifest, map[string]string{}, &Settings{})
+	if assert.NoError(err) {
+		certificate := parseCertificate(assert, result.Values["TOR_CERT"])
+		if certificate != nil {
+			assert.Contains(certificate.DNSNames, "tor.example.com", "The default of DOMAIN should be used")
+		}
+	}
+
+	model.MakeMapOfVariables(roleManifest)["DOMAIN"].Default = nil
+	_, err = Generate(roleManifest, map[string]string{}, &Settings{})
+	assert.EqualError(err, "Certificate tor_cert uses DOMAIN in its subject names, which has no value")
+}
@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// defaultPasswordLength is the length of passwords without an explicit one
+	defaultPasswordLength = 32
+	// defaultPasswordCharset are the characters of passwords without an
+	// explicit charset
+	defaultPasswordCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// generatePassword creates a random password, used by all variables of the
+// request
+func generatePassword(request *Request) (map[string]string, error) {
+	length := request.Length
+	if length == 0 {
+		length = defaultPasswordLength
+	}
+
+	charset := []rune(request.Charset)
+	if len(charset) == 0 {
+		charset = []rune(defaultPasswordCharset)
+	}
+
+	password := make([]rune, length)
+	max := big.NewInt(int64(len(charset)))
+	for i := range password {
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, fmt.Errorf("Error generating password %s: %s", request.ID, err)
+		}
+		password[i] = charset[index.Int64()]
+	}
+
+	values := make(map[string]string, len(request.Variables))
+	for _, variable := range request.Variables {
+		values[variable.Name] = string(password)
+	}
+
+	return values, nil
+}
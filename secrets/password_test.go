@@ -0,0 +1,33 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	assert := assert.New(t)
+
+	values, err := generatePassword(&Request{
+		ID:        "password",
+		Variables: []RequestVariable{{Name: "PASSWORD"}, {Name: "PASSWORD_COPY"}},
+		Length:    64,
+		Charset:   "xyz",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	password := values["PASSWORD"]
+	assert.Len(password, 64)
+	assert.Empty(strings.Trim(password, "xyz"), "Passwords should only use the charset")
+	assert.Equal(password, values["PASSWORD_COPY"], "Variables of a generator should share the password")
+
+	values, err = generatePassword(&Request{ID: "password", Variables: []RequestVariable{{Name: "PASSWORD"}}})
+	if assert.NoError(err) {
+		assert.Len(values["PASSWORD"], defaultPasswordLength)
+		assert.Empty(strings.Trim(values["PASSWORD"], defaultPasswordCharset))
+	}
+}
@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hpcloud/fissile/model"
+)
+
+// sshKeyBits is the size of generated SSH keys
+const sshKeyBits = 2048
+
+// generateSSHKey creates an SSH key pair. Variables receive the PEM encoded
+// private key, the public key in OpenSSH authorized_keys format, or the MD5
+// fingerprint of the public key, according to their value type.
+func generateSSHKey(request *Request) (map[string]string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, sshKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating SSH key %s: %s", request.ID, err)
+	}
+
+	publicKey := sshPublicKey(&key.PublicKey)
+
+	return request.assignValues(map[string]string{
+		model.ValueTypePrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})),
+		model.ValueTypePublicKey:   "ssh-rsa " + base64.StdEncoding.EncodeToString(publicKey),
+		model.ValueTypeFingerprint: sshFingerprint(publicKey),
+	})
+}
+
+// sshPublicKey returns the SSH wire format of an RSA public key (RFC 4253)
+func sshPublicKey(key *rsa.PublicKey) []byte {
+	var buf bytes.Buffer
+	writeSSHString(&buf, []byte("ssh-rsa"))
+	writeSSHString(&buf, sshMPInt(big.NewInt(int64(key.E))))
+	writeSSHString(&buf, sshMPInt(key.N))
+	return buf.Bytes()
+}
+
+// sshFingerprint returns the colon separated MD5 fingerprint of a public key
+func sshFingerprint(publicKey []byte) string {
+	sum := md5.Sum(publicKey)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func writeSSHString(buf *bytes.Buffer, value []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.Write(value)
+}
+
+// sshMPInt encodes a positive integer as an SSH mpint, which needs a leading
+// zero byte if the high bit is set
+func sshMPInt(n *big.Int) []byte {
+	bytes := n.Bytes()
+	if len(bytes) > 0 && bytes[0]&0x80 != 0 {
+		return append([]byte{0}, bytes...)
+	}
+	return bytes
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readSSHString splits the first length prefixed field off SSH wire data
+func readSSHString(data []byte) ([]byte, []byte) {
+	length := binary.BigEndian.Uint32(data)
+	return data[4 : 4+length], data[4+length:]
+}
+
+func TestGenerateSSHKey(t *testing.T) {
+	assert := assert.New(t)
+
+	values, err := generateSSHKey(&Request{
+		ID: "ssh_key",
+		Variables: []RequestVariable{
+			{Name: "KEY", ValueType: "private_key"},
+			{Name: "PUBLIC_KEY", ValueType: "public_key"},
+			{Name: "FINGERPRINT", ValueType: "fingerprint"},
+		},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	block, _ := pem.Decode([]byte(values["KEY"]))
+	if !assert.NotNil(block) {
+		return
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if !assert.NoError(err) {
+		return
+	}
+
+	fields := strings.Fields(values["PUBLIC_KEY"])
+	if !assert.Len(fields, 2) {
+		return
+	}
+	assert.Equal("ssh-rsa", fields[0])
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if !assert.NoError(err) {
+		return
+	}
+
+	keyType, rest := readSSHString(blob)
+	exponent, rest := readSSHString(rest)
+	modulus, rest := readSSHString(rest)
+	assert.Equal("ssh-rsa", string(keyType))
+	assert.Empty(rest)
+	assert.Equal(rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, key.PublicKey)
+	assert.Zero(modulus[0], "Moduli with the high bit set need a leading zero byte")
+
+	assert.Equal(sshFingerprint(blob), values["FINGERPRINT"])
+	assert.Len(strings.Split(values["FINGERPRINT"], ":"), 16)
+
+	_, err = generateSSHKey(&Request{ID: "ssh_key", Variables: []RequestVariable{{Name: "CERT", ValueType: "certificate"}}})
+	assert.EqualError(err, "Generator ssh_key can't produce a value of type certificate for CERT")
+}
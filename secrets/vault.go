@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// VaultBackendName is the name of the backend reading values from Vault
+const VaultBackendName = "vault"
+
+// VaultBackend satisfies generators with values stored in a Vault KV secrets
+// engine, at `<path>/<generator id>`. Each variable gets the value stored
+// under its own name, or else under its value type.
+type VaultBackend struct {
+	Address string // Address of the Vault server, e.g. https://vault:8200
+	Token   string // Token authenticating with Vault
+	Path    string // KV path the generated values are stored under
+}
+
+// NewVaultBackend creates a backend reading values from Vault
+func NewVaultBackend(address, token, path string) *VaultBackend {
+	return &VaultBackend{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Path:    strings.Trim(path, "/"),
+	}
+}
+
+// Generate implements Backend
+func (b *VaultBackend) Generate(request *Request) (map[string]string, error) {
+	url := fmt.Sprintf("%s/v1/%s/%s", b.Address, b.Path, request.ID)
+
+	httpRequest, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("X-Vault-Token", b.Token)
+
+	response, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s from Vault: %s", url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Vault has no values at %s/%s", b.Path, request.ID)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error reading %s from Vault: %s", url, response.Status)
+	}
+
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("Error reading %s from Vault: %s", url, err)
+	}
+
+	values := make(map[string]string, len(request.Variables))
+	for _, variable := range request.Variables {
+		if value, ok := secret.Data[variable.Name]; ok {
+			values[variable.Name] = value
+		} else if value, ok := secret.Data[variable.ValueType]; ok && variable.ValueType != "" {
+			values[variable.Name] = value
+		}
+	}
+
+	return values, nil
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"runtime"
 )
 
 var (
@@ -123,3 +124,17 @@ func CopyFileToTarStream(stream *tar.Writer, path string, header *tar.Header) er
 	}
 	return nil
 }
+
+// hostOS is the operating system of the build host, replaced in tests
+var hostOS = runtime.GOOS
+
+// SetHostPermissions sets the permissions of a tar entry read from the build
+// host for images built by a Linux daemon. Windows does not record whether
+// files are executable, so all entries get the permissions of executables, as
+// docker build does there.
+func SetHostPermissions(header *tar.Header) {
+	if hostOS != "windows" {
+		return
+	}
+	header.Mode = (header.Mode &^ 0777) | 0755
+}
@@ -85,3 +85,19 @@ func TestCopyFileToTarStream(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(expected, actual, "Incorrect data read")
 }
+
+func TestSetHostPermissions(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(goos string) { hostOS = goos }(hostOS)
+
+	hostOS = "linux"
+	header := &tar.Header{Name: "packages-src/abc/lib/libevent.so", Mode: 0640}
+	SetHostPermissions(header)
+	assert.Equal(int64(0640), header.Mode)
+
+	hostOS = "windows"
+	header = &tar.Header{Name: "packages-src/abc/bin/tor", Mode: int64(0666 | 01000)}
+	SetHostPermissions(header)
+	assert.Equal(int64(0755|01000), header.Mode)
+}